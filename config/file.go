@@ -0,0 +1,310 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/joho/godotenv"
+)
+
+// Settings can also live in a config file, taju.yaml (or .yml) or taju.toml
+// next to taju.env, or the file named by TAJU_CONFIG. It is for the
+// non-secret settings: secrets, tokens and sessions stay in taju.env.
+// Environment variables named like the taju.env keys override both.
+//
+// File keys are the taju.env keys in lowercase without the TAJU_ prefix,
+// and nested tables or mappings join their keys with underscores, so
+//
+//	sync_interval: 12h
+//	strava:
+//	  workers: 2
+//	activity_map:
+//	  Ride: bike
+//	  Walk: ruck
+//
+// sets TAJU_SYNC_INTERVAL=12h, TAJU_STRAVA_WORKERS=2 and
+// TAJU_ACTIVITY_MAP=Ride=bike,Walk=ruck. Lists are joined with commas.
+// Only the plain subset of YAML and TOML that settings need is read, see
+// syntax.go; anything else is an error at its line.
+
+var CONFIG_FILENAMES = []string{"taju.yaml", "taju.yml", "taju.toml"}
+
+// Error is an invalid setting and where it was set.
+type Error struct {
+	Source string
+	Line   int
+	Key    string
+	Msg    string
+}
+
+func (e Error) String() string {
+	where := e.Source
+	if e.Line > 0 {
+		where += ":" + strconv.Itoa(e.Line)
+	}
+	if e.Key != "" {
+		return fmt.Sprintf("%s: %s: %s", where, e.Key, e.Msg)
+	}
+	return fmt.Sprintf("%s: %s", where, e.Msg)
+}
+
+// Setting is a value from the config file or the environment.
+type Setting struct {
+	Value  string
+	Source string
+	Line   int
+}
+
+const (
+	NODE_SCALAR = iota
+	NODE_LIST
+	NODE_MAPPING
+)
+
+// node is a parsed YAML or TOML value. Mappings keep their key order.
+type node struct {
+	kind   int
+	line   int
+	scalar string
+	list   []node
+	keys   []string
+	values []node
+}
+
+func (n *node) child(key string) (*node, bool) {
+	if i := slices.Index(n.keys, key); i >= 0 {
+		return &n.values[i], true
+	}
+	return nil, false
+}
+
+// joined is a scalar's value, or a list's items joined with commas.
+func (n node) joined() string {
+	if n.kind != NODE_LIST {
+		return n.scalar
+	}
+	var items []string
+	for _, item := range n.list {
+		items = append(items, item.joined())
+	}
+	return strings.Join(items, ",")
+}
+
+// FilePath is the config file in use, "" if there is none.
+func FilePath() string {
+	if path := os.Getenv("TAJU_CONFIG"); path != "" {
+		return path
+	}
+	for _, name := range CONFIG_FILENAMES {
+		if _, err := os.Stat(name); err == nil {
+			return name
+		}
+	}
+	return ""
+}
+
+// LoadLayer reads the settings of the config file and the environment
+// overrides. Any invalid setting is an error: taju config validate lists
+// them.
+func LoadLayer() (map[string]Setting, error) {
+	layer, errs := ReadLayer()
+	if len(errs) > 0 {
+		var lines []string
+		for _, err := range errs {
+			lines = append(lines, "  "+err.String())
+		}
+		return nil, fmt.Errorf("invalid settings, run `taju config validate` after fixing:\n%s", strings.Join(lines, "\n"))
+	}
+	return layer, nil
+}
+
+// ReadLayer is LoadLayer returning every invalid setting.
+func ReadLayer() (map[string]Setting, []Error) {
+	layer := make(map[string]Setting)
+	var errs []Error
+	if path := FilePath(); path != "" {
+		layer, errs = readFile(path)
+	}
+	for _, pair := range os.Environ() {
+		key, value, _ := strings.Cut(pair, "=")
+		field, ok := Field(key)
+		if !ok {
+			continue
+		}
+		if err := Validate(field, value); err != nil {
+			errs = append(errs, Error{Source: "environment", Key: key, Msg: err.Error()})
+		}
+		layer[key] = Setting{Value: value, Source: "environment"}
+	}
+	return layer, errs
+}
+
+// readFile parses a config file into taju.env keys and checks them against
+// Schema.
+func readFile(path string) (map[string]Setting, []Error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, []Error{{Source: path, Msg: err.Error()}}
+	}
+	var root node
+	var errs []Error
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".toml":
+		root, errs = parseToml(string(data), path)
+	case ".yaml", ".yml":
+		root, errs = parseYaml(string(data), path)
+	default:
+		return nil, []Error{{Source: path, Msg: "unknown config file type, use .yaml, .yml or .toml"}}
+	}
+	settings := make(map[string]Setting)
+	flatten(path, "", root, settings, &errs)
+	for key, setting := range settings {
+		field, ok := Field(key)
+		switch {
+		case !ok:
+			msg := "unknown setting"
+			if suggestion := ClosestKey(key); suggestion != "" {
+				msg += ", did you mean " + FileKey(suggestion) + "?"
+			}
+			errs = append(errs, Error{Source: path, Line: setting.Line, Key: key, Msg: msg})
+			delete(settings, key)
+		case IsSecret(key):
+			errs = append(errs, Error{Source: path, Line: setting.Line, Key: key, Msg: "secrets belong in taju.env or the environment, not the config file"})
+			delete(settings, key)
+		default:
+			if err := Validate(field, setting.Value); err != nil {
+				errs = append(errs, Error{Source: path, Line: setting.Line, Key: key, Msg: err.Error()})
+			}
+		}
+	}
+	slices.SortFunc(errs, func(a, b Error) int { return a.Line - b.Line })
+	return settings, errs
+}
+
+// flatten turns the nested file keys into taju.env keys. A mapping under a
+// key that takes a list of pairs (TAJU_ACTIVITY_MAP) becomes key=value
+// pairs instead.
+func flatten(source string, prefix string, n node, out map[string]Setting, errs *[]Error) {
+	switch n.kind {
+	case NODE_MAPPING:
+		if key := resolveKey(prefix); prefix != "" && isPairsKey(key) {
+			var pairs []string
+			for i, name := range n.keys {
+				pairs = append(pairs, name+"="+n.values[i].joined())
+			}
+			out[key] = Setting{Value: strings.Join(pairs, ","), Source: source, Line: n.line}
+			return
+		}
+		for i, name := range n.keys {
+			name = strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+			if prefix != "" {
+				name = prefix + "_" + name
+			}
+			flatten(source, name, n.values[i], out, errs)
+		}
+	default:
+		key := resolveKey(prefix)
+		if _, ok := out[key]; ok {
+			*errs = append(*errs, Error{Source: source, Line: n.line, Key: key, Msg: "set twice"})
+		}
+		out[key] = Setting{Value: n.joined(), Source: source, Line: n.line}
+	}
+}
+
+// resolveKey maps a file key to its taju.env key: TAJI_ and STRAVA_ keys
+// are used as they are, everything else gets the TAJU_ prefix.
+func resolveKey(name string) string {
+	if strings.HasPrefix(name, "TAJU_") {
+		return name
+	}
+	if _, ok := Field(name); ok {
+		return name
+	}
+	return "TAJU_" + name
+}
+
+// FileKey is how a taju.env key is written in the config file.
+func FileKey(key string) string {
+	return strings.ToLower(strings.TrimPrefix(key, "TAJU_"))
+}
+
+// ValidateEnv checks the keys and values of an env file.
+func ValidateEnv(env map[string]string, source string) (errs []Error) {
+	for _, key := range slices.Sorted(maps.Keys(env)) {
+		field, ok := Field(key)
+		if !ok {
+			msg := "unknown setting"
+			if suggestion := ClosestKey(key); suggestion != "" {
+				msg += ", did you mean " + suggestion + "?"
+			}
+			errs = append(errs, Error{Source: source, Key: key, Msg: msg})
+			continue
+		}
+		if IsSecret(key) {
+			continue
+		}
+		if err := Validate(field, env[key]); err != nil {
+			errs = append(errs, Error{Source: source, Key: key, Msg: err.Error()})
+		}
+	}
+	return
+}
+
+// Layers remembers which values of an env came from the config file and
+// the environment, and the taju.env values they replaced, so the env can be
+// saved back to taju.env without them.
+type Layers struct {
+	layered  map[string]string
+	shadowed map[string]string
+}
+
+// Apply sets the settings of layer in env. Secrets are applied once
+// taju.env is decrypted, the rest before anything reads env.
+func (l *Layers) Apply(env map[string]string, layer map[string]Setting, secrets bool) {
+	if l.layered == nil {
+		l.layered, l.shadowed = make(map[string]string), make(map[string]string)
+	}
+	for key, setting := range layer {
+		if IsSecret(key) != secrets {
+			continue
+		}
+		if value, ok := env[key]; ok {
+			l.shadowed[key] = value
+		}
+		env[key] = setting.Value
+		l.layered[key] = setting.Value
+	}
+}
+
+// Persisted is env as it's written back to taju.env: settings from the
+// config file or environment are left out (or replaced by what taju.env
+// had) unless they changed since, like a refreshed token.
+func (l *Layers) Persisted(env map[string]string) map[string]string {
+	env = maps.Clone(env)
+	for key, value := range l.layered {
+		if env[key] != value {
+			continue
+		}
+		if original, ok := l.shadowed[key]; ok {
+			env[key] = original
+		} else {
+			delete(env, key)
+		}
+	}
+	return env
+}
+
+// ReadEnvFile reads an env file, nil without an error if there is none.
+func ReadEnvFile(path string) (map[string]string, error) {
+	env, err := godotenv.Read(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return env, err
+}
@@ -0,0 +1,354 @@
+// Package config reads the settings of taju: the keys of taju.env, the
+// config file (taju.yaml or taju.toml) and the environment layered over it,
+// and the state directory runtime values are written to. Nothing here
+// exits; invalid settings are returned as errors for the caller to report.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const DATE_FORMAT string = "2006-01-02"
+
+// Schema documents every key read from taju.env, the config file or the
+// environment. It isn't loaded into: each feature reads its keys where it
+// is configured. The struct only exists so `taju config docs` can list them
+// from the tags and `taju config validate` can check them, so add a field
+// here whenever a feature reads a new key. Keys ending in _ are prefixes.
+type Schema struct {
+	ClientId     string `env:"TAJU_CLIENT_ID" doc:"Strava API application client id (required)"`
+	ClientSecret string `env:"TAJU_CLIENT_SECRET" doc:"Strava API application client secret (required)"`
+
+	StravaToken        string        `env:"STRAVA_TOKEN" doc:"OAuth token of the default Strava account, written by taju auth strava"`
+	StravaTokenAccount string        `env:"STRAVA_TOKEN_" doc:"OAuth token of another Strava account, e.g. STRAVA_TOKEN_ALEX"`
+	StravaScope        string        `env:"STRAVA_SCOPE" doc:"scope the default Strava account granted, written by taju auth strava (also STRAVA_SCOPE_<ACCOUNT>)"`
+	StravaScopeAccount string        `env:"STRAVA_SCOPE_" doc:"scope another Strava account granted, e.g. STRAVA_SCOPE_ALEX"`
+	RequestedScope     string        `env:"TAJU_STRAVA_SCOPE" default:"read,activity:read_all" doc:"scope Strava authorizations ask for; activity:read instead of activity:read_all leaves out private activities"`
+	StravaRefreshToken string        `env:"STRAVA_REFRESH_TOKEN" doc:"pre-supplied refresh token used instead of the browser authorization (also STRAVA_REFRESH_TOKEN_<ACCOUNT>)"`
+	StravaCursor       string        `env:"STRAVA_CURSOR" doc:"start date of the newest activity synced (also STRAVA_CURSOR_<ACCOUNT>)"`
+	StravaFullFetch    string        `env:"STRAVA_FULL_FETCH" doc:"when the whole event window was last fetched from Strava (also STRAVA_FULL_FETCH_<ACCOUNT>)"`
+	FullFetchInterval  time.Duration `env:"TAJU_FULL_FETCH_INTERVAL" default:"24h" doc:"how often the whole event window is fetched from Strava instead of what started after the cursor, to pick up activities uploaded late; 0 every time"`
+	StravaAccounts     []string      `env:"TAJU_STRAVA_ACCOUNTS" default:"default" doc:"Strava accounts known to taju accounts"`
+	SyncAccounts       []string      `env:"TAJU_SYNC_ACCOUNTS" default:"all accounts" doc:"Strava accounts whose activities are synced"`
+	AuthPort           int           `env:"TAJU_AUTH_PORT" default:"9191" doc:"local port the Strava authorization redirect comes back to, 0 for any free port"`
+	AuthTimeout        time.Duration `env:"TAJU_AUTH_TIMEOUT" default:"3m" doc:"how long to wait for the Strava authorization redirect before asking to paste its address"`
+
+	TajiUsername    string `env:"TAJI_USERNAME" doc:"Taji100 login email, prompted for when missing"`
+	TajiPassword    string `env:"TAJI_PASSWORD" doc:"Taji100 password, prompted for when missing"`
+	TajiCsrf        string `env:"TAJI_CSRF" doc:"Taji csrftoken cookie, written on login"`
+	TajiSession     string `env:"TAJI_SESSION" doc:"Taji sessionid cookie, written on login"`
+	TajiParticipant string `env:"TAJI_PARTICIPANT" doc:"Taji participant id, written on login"`
+	Credentials     string `env:"TAJU_CREDENTIALS" default:"file" doc:"where secrets are kept: file (encrypted in taju.env), keyring (OS keyring) or passphrase (encrypted in taju.env under TAJU_PASSPHRASE)"`
+	Passphrase      string `env:"TAJU_PASSPHRASE" doc:"passphrase of the secrets with TAJU_CREDENTIALS=passphrase, read from the environment or TAJU_ANSWERS_FILE, asked for at startup when missing"`
+	ConfigFile      string `env:"TAJU_CONFIG" format:"path" default:"taju.yaml, taju.yml or taju.toml" doc:"file with the non-secret settings, keys in lowercase without TAJU_ (read from the environment)"`
+	AnswersFile     string `env:"TAJU_ANSWERS_FILE" format:"path" doc:"env file with answers to prompts, e.g. a Docker secret"`
+	WebAddr         string `env:"TAJU_WEB_ADDR" default:":9190" doc:"address of the setup page served by taju web"`
+	Profiles        string `env:"TAJU_PROFILES" doc:"comma-separated profiles sharing this taju.env, each with its own Strava accounts and Taji login (taju --profile name ...)"`
+	Participants    string `env:"TAJU_PARTICIPANTS" doc:"comma-separated members a team captain logs for (coach mode), each with their own Taji login, ledger and summary"`
+	Participant     string `env:"TAJU_PARTICIPANT_" doc:"setting of a participant: TAJI_USERNAME, TAJI_PASSWORD, TAJI_ID (checked after login), STRAVA_ACCOUNTS or IMPORT_DIR, e.g. TAJU_PARTICIPANT_ALICE_STRAVA_ACCOUNTS=alice"`
+	ImportDir       string `env:"TAJU_IMPORT_DIR" format:"path" doc:"directory of GPX, TCX, FIT and CSV files synced like a Strava account; with profiles or participants each reads its own subdirectory"`
+	Storage         string `env:"TAJU_STORAGE" default:"json" doc:"where the state ledger is kept: json (a file), sqlite or bbolt (a database to query), webdav or s3 to share it between machines"`
+	RemoteUrl       string `env:"TAJU_REMOTE_URL" format:"url" doc:"WebDAV folder, or S3 endpoint such as https://s3.us-east-1.amazonaws.com"`
+	RemoteUsername  string `env:"TAJU_REMOTE_USERNAME" doc:"WebDAV user, or S3 access key id"`
+	RemotePassword  string `env:"TAJU_REMOTE_PASSWORD" doc:"WebDAV password, or S3 secret access key"`
+	RemoteKey       string `env:"TAJU_REMOTE_KEY" doc:"passphrase encrypting the remote files; set it to share tokens and sessions too"`
+	S3Bucket        string `env:"TAJU_S3_BUCKET" doc:"bucket for TAJU_STORAGE=s3"`
+	S3Region        string `env:"TAJU_S3_REGION" default:"us-east-1" doc:"region for TAJU_STORAGE=s3"`
+	StateDir        string `env:"TAJU_STATE_DIR" format:"path" doc:"writable directory for tokens, the state ledger and history when taju.env is read-only (also read from the environment)"`
+
+	EventYear    int    `env:"TAJU_EVENT_YEAR" default:"current year" doc:"year of the February event to sync"`
+	EventStart   string `env:"TAJU_EVENT_START" format:"date" default:"Feb 1" doc:"first day to sync (YYYY-MM-DD)"`
+	EventEnd     string `env:"TAJU_EVENT_END" format:"date" default:"last day of February" doc:"last day to sync (YYYY-MM-DD)"`
+	EndInclusive bool   `env:"TAJU_EVENT_END_INCLUSIVE" default:"true" doc:"sync TAJU_EVENT_END itself; false makes it the first day left out"`
+	WindowDates  string `env:"TAJU_WINDOW_DATES" default:"local" doc:"what decides if an activity is in the event: local (the date it is logged under on Taji) or query (its start in this machine's timezone)"`
+
+	Units     string `env:"TAJU_UNITS" default:"mi" doc:"distance units shown in the terminal: mi or km"`
+	Clock     string `env:"TAJU_CLOCK" default:"12h" doc:"clock shown in the terminal: 12h or 24h"`
+	TajiUnits string `env:"TAJU_TAJI_UNITS" default:"mi" doc:"distance units the Taji form expects: mi or km"`
+	TajiClock string `env:"TAJU_TAJI_CLOCK" default:"12h" doc:"time format the Taji form expects: 12h or 24h"`
+
+	SyncInterval    time.Duration `env:"TAJU_SYNC_INTERVAL" default:"12h" doc:"time between syncs in daemon mode"`
+	ManualEditPause time.Duration `env:"TAJU_MANUAL_EDIT_PAUSE" default:"30m" doc:"pause automated changes this long after entries are logged on the Taji site, 0 to never pause"`
+	GracePeriod     time.Duration `env:"TAJU_GRACE_PERIOD" default:"0" doc:"wait this long after an activity ends before posting it, e.g. 2h"`
+	Headless        bool          `env:"TAJU_HEADLESS" default:"false" doc:"never prompt and log the summary instead of drawing it"`
+	Dashboard       bool          `env:"TAJU_DASHBOARD" default:"true" doc:"show the dashboard when syncing in a terminal"`
+	ConfirmPosts    bool          `env:"TAJU_CONFIRM_POSTS" default:"false" doc:"check the participant page for every posted activity"`
+	ControlAddr     string        `env:"TAJU_CONTROL_ADDR" default:"localhost:9191" doc:"address of the status page and the POST /sync, /metrics and /healthz endpoints (e.g. :9191 to open them to the network), off to disable"`
+
+	MinMiles         float64       `env:"TAJU_MIN_MILES" default:"0" doc:"skip activities shorter than this"`
+	SkipPrivate      bool          `env:"TAJU_SKIP_PRIVATE" default:"false" doc:"skip private activities"`
+	SkipCommutes     bool          `env:"TAJU_SKIP_COMMUTES" default:"false" doc:"skip activities marked as commutes"`
+	SkipRaces        bool          `env:"TAJU_SKIP_RACES" default:"false" doc:"skip activities marked as races"`
+	RequireTag       string        `env:"TAJU_REQUIRE_TAG" doc:"only upload activities with this tag in the name or description, e.g. #taji"`
+	NamePrefix       string        `env:"TAJU_NAME_PREFIX" doc:"only upload activities whose name starts with this"`
+	OnlyGear         []string      `env:"TAJU_ONLY_GEAR" doc:"only upload activities with one of these Strava gear ids"`
+	ExcludeIds       []string      `env:"TAJU_EXCLUDE_ACTIVITIES" doc:"Strava activity ids never to upload"`
+	ActivityMap      []string      `env:"TAJU_ACTIVITY_MAP" doc:"extra StravaType=taji_activity mappings, e.g. Ride=bike,Walk=ruck; *=activity maps unlisted and missing types"`
+	DurationOnly     []string      `env:"TAJU_DURATION_ONLY" doc:"Taji activities posted without a distance"`
+	Timezone         string        `env:"TAJU_TIMEZONE" default:"activity" doc:"timezone runs are dated in: activity (where it was run), local (this machine) or a name like Europe/Berlin"`
+	Midnight         string        `env:"TAJU_MIDNIGHT" default:"start" doc:"day a run spanning midnight is logged on: start, end, or most (the day with most of it)"`
+	SplitMidnight    bool          `env:"TAJU_SPLIT_MIDNIGHT" default:"false" doc:"post activities running past midnight as one entry per day"`
+	StravaDuplicates string        `env:"TAJU_STRAVA_DUPLICATES" default:"longer" doc:"which of an activity recorded twice (watch and phone) is synced: longer, device:<name> or off"`
+	DuplicateWindow  time.Duration `env:"TAJU_STRAVA_DUPLICATE_WINDOW" default:"2m" doc:"start times this close make two activities of the same kind one recorded twice"`
+	Strict           bool          `env:"TAJU_STRICT" default:"false" doc:"stop syncing and exit with status 3 on any drift between the ledger, Strava and Taji (sync --strict)"`
+	QueueBackoff     time.Duration `env:"TAJU_QUEUE_BACKOFF" default:"15m" doc:"wait before posting a queued activity again after a failed post, doubled with every failure"`
+	QueueMaxBackoff  time.Duration `env:"TAJU_QUEUE_MAX_BACKOFF" default:"6h" doc:"longest wait between posts of a queued activity"`
+	DailyCapMiles    float64       `env:"TAJU_DAILY_CAP_MILES" doc:"most miles logged per day, the rest of a day's activities is left off"`
+	DailySummary     bool          `env:"TAJU_DAILY_SUMMARY" default:"false" doc:"post one entry per activity type and day, adding up the day's activities"`
+	DurationSource   []string      `env:"TAJU_DURATION_SOURCE" default:"auto" doc:"Strava time posted as the duration: elapsed, moving, or auto (elapsed unless it looks wrong), optionally per Taji activity like ruck=elapsed"`
+	Transforms       []string      `env:"TAJU_TRANSFORMS" default:"time,special,adjust,units,duration,elevation,forms,overrides,validate" doc:"pipeline turning Strava activities into Taji form values"`
+	Override         string        `env:"TAJU_OVERRIDE_" doc:"field=value corrections for one activity, e.g. TAJU_OVERRIDE_123=distance=3.10"`
+	SportForm        string        `env:"TAJU_FORM_" doc:"form values of a Taji activity that isn't run-shaped, e.g. TAJU_FORM_SWIM=distance={yards}"`
+	UploadElevation  bool          `env:"TAJU_UPLOAD_ELEVATION" default:"true" doc:"post Strava's elevation gain in feet"`
+	ElevationStream  bool          `env:"TAJU_ELEVATION_STREAMS" default:"false" doc:"compute missing elevation gain from the altitude stream"`
+	Notes            bool          `env:"TAJU_NOTES" default:"false" doc:"post the Strava name, pace and a link in the notes of entries"`
+	NotesTemplate    string        `env:"TAJU_NOTES_TEMPLATE" default:"{name} - {pace} - {link}" doc:"notes text with {name}, {description}, {type}, {pace}, {splits}, {link} and {id}"`
+	UploadPhotos     bool          `env:"TAJU_UPLOAD_PHOTOS" default:"false" doc:"attach the primary Strava photo when the Taji form takes one"`
+	DistanceStep     float64       `env:"TAJU_DISTANCE_STEP" doc:"distance increment the Taji form accepts, e.g. 0.1 (default: the form's own step, else 0.01)"`
+	DistanceRounding string        `env:"TAJU_DISTANCE_ROUNDING" default:"nearest" doc:"round distances to the step: nearest or down"`
+	DurationRounding string        `env:"TAJU_DURATION_ROUNDING" default:"exact" doc:"round durations to whole minutes: exact (keep the seconds), truncate or nearest"`
+	StartRounding    string        `env:"TAJU_START_ROUNDING" default:"truncate" doc:"round start times: truncate (drop the seconds), nearest minute, or a step such as 5m"`
+
+	MatchWindow    time.Duration `env:"TAJU_MATCH_WINDOW" default:"2m" doc:"how far apart on the same day a Taji entry without the activity's key may start and still be its entry (0: the exact time only)"`
+	MatchDistance  float64       `env:"TAJU_MATCH_DISTANCE" default:"0.02" doc:"how much the distance of such an entry may differ, as a fraction"`
+	Policy         string        `env:"TAJU_POLICY_" doc:"conflict policy per class (DUPLICATE, MISMATCH, STRAVA_EDIT, TAJI_ONLY): skip, prompt, overwrite or log"`
+	MaxMiles       float64       `env:"TAJU_MAX_MILES" default:"50" doc:"hold longer activities for review"`
+	MinPace        string        `env:"TAJU_MIN_PACE" default:"3:00" doc:"hold activities faster than this pace per mile for review"`
+	GoalMiles      float64       `env:"TAJU_GOAL_MILES" default:"100" doc:"event distance goal"`
+	GoalElevation  string        `env:"TAJU_GOAL_ELEVATION" doc:"climbing goal tracked next to the distance, in feet or with a unit like 3000m"`
+	Encouragement  bool          `env:"TAJU_ENCOURAGEMENT" default:"true" doc:"encouragement under the goal progress and in notifications"`
+	MessagesFile   string        `env:"TAJU_MESSAGES_FILE" format:"path" default:"taju.messages.json" doc:"your own encouragement messages per situation (behind, ahead, on_pace, milestone, first_day, halfway_day, last_day, complete)"`
+	DistanceAdjust string        `env:"TAJU_DISTANCE_ADJUST" doc:"activity=factor or gear:<strava gear id>=factor applied to the distance posted, e.g. bike=0.25"`
+	GoalWeights    string        `env:"TAJU_GOAL_WEIGHTS" doc:"activity=weight miles weighting towards the goal, e.g. bike=0.25"`
+	Points         string        `env:"TAJU_POINTS_FILE" format:"path" default:"taju.points.json" doc:"event scoring rules"`
+	SpecialDays    string        `env:"TAJU_SPECIAL_DAYS_FILE" format:"path" default:"taju.days.json" doc:"calendar of event days with their own category or bonus, e.g. the virtual ruck march"`
+
+	PreSyncCommand    string `env:"TAJU_PRE_SYNC_COMMAND" doc:"command run before every cycle"`
+	PreSyncWebhook    string `env:"TAJU_PRE_SYNC_WEBHOOK" format:"url" doc:"URL posted to before every cycle"`
+	PostSyncCommand   string `env:"TAJU_POST_SYNC_COMMAND" doc:"command run after every cycle with the result"`
+	PostSyncWebhook   string `env:"TAJU_POST_SYNC_WEBHOOK" format:"url" doc:"URL posted the result of every cycle"`
+	HealthcheckUrl    string `env:"TAJU_HEALTHCHECK_URL" format:"url" doc:"healthchecks.io style URL pinged on start, success and failure"`
+	Notify            bool   `env:"TAJU_NOTIFY" default:"false" doc:"desktop notifications after cycles that posted or failed"`
+	NotifyOn          string `env:"TAJU_NOTIFY_ON" default:"posts" doc:"cycles the Discord, Slack and email notifications are sent for: posts (or failures), errors or always"`
+	DiscordWebhook    string `env:"TAJU_DISCORD_WEBHOOK" format:"url" doc:"Discord webhook posted a summary after cycles"`
+	SlackWebhook      string `env:"TAJU_SLACK_WEBHOOK" format:"url" doc:"Slack incoming webhook posted a summary after cycles"`
+	NotifyEmail       string `env:"TAJU_NOTIFY_EMAIL" doc:"comma-separated addresses mailed a summary after cycles"`
+	SmtpAddr          string `env:"TAJU_SMTP_ADDR" doc:"mail server for TAJU_NOTIFY_EMAIL, host:port"`
+	SmtpUsername      string `env:"TAJU_SMTP_USERNAME" doc:"mail server login"`
+	SmtpPassword      string `env:"TAJU_SMTP_PASSWORD" doc:"mail server password"`
+	SmtpFrom          string `env:"TAJU_SMTP_FROM" doc:"sender address (default: TAJU_SMTP_USERNAME)"`
+	ExportName        string `env:"TAJU_EXPORT_NAME" default:"the profile, else TAJI_USERNAME" doc:"name on the rows of the team export, to tell teammates apart in a shared file"`
+	ExportCsv         string `env:"TAJU_EXPORT_CSV" format:"path" doc:"CSV file every activity posted to Taji is appended to, e.g. on a shared drive"`
+	ExportDailyCsv    string `env:"TAJU_EXPORT_DAILY_CSV" format:"path" doc:"CSV file with the daily distance, rewritten after every cycle"`
+	SheetsId          string `env:"TAJU_SHEETS_ID" doc:"Google spreadsheet posted activities and daily totals are written to, in its Activities and Daily sheets"`
+	SheetsCredentials string `env:"TAJU_SHEETS_CREDENTIALS" format:"path" doc:"key file of the Google service account the spreadsheet is shared with"`
+	WebhookUrl        string `env:"TAJU_WEBHOOK_URL" format:"url" doc:"public URL for Strava push events, enables the webhook mode"`
+	Tunnel            string `env:"TAJU_TUNNEL" doc:"tunnel client giving the webhook a public URL without TAJU_WEBHOOK_URL: cloudflared or ngrok"`
+	WebhookAddr       string `env:"TAJU_WEBHOOK_ADDR" default:":9192" doc:"address the webhook callback listens on"`
+	WebhookCert       string `env:"TAJU_WEBHOOK_CERT" format:"path" doc:"TLS certificate for the webhook callback"`
+	WebhookKey        string `env:"TAJU_WEBHOOK_KEY" format:"path" doc:"TLS key for the webhook callback"`
+
+	Wrapup          bool          `env:"TAJU_WRAPUP" default:"true" doc:"write taju.wrapup.txt after the first sync past the end of the event"`
+	Standings       bool          `env:"TAJU_STANDINGS" default:"true" doc:"read the team page and leaderboard after each cycle for the team standings"`
+	Polite          bool          `env:"TAJU_POLITE" default:"true" doc:"go easy on Taji100: one fetch at a time, spaced requests, conditional GETs, quiet hours"`
+	TajiDelay       time.Duration `env:"TAJU_TAJI_DELAY" default:"1s" doc:"least time between two Taji requests (0 without polite mode)"`
+	QuietHours      string        `env:"TAJU_QUIET_HOURS" default:"0-6" doc:"local hours scheduled syncs wait out, e.g. 22-6, or off (off without polite mode)"`
+	TajiWorkers     int           `env:"TAJU_TAJI_WORKERS" default:"1" doc:"concurrent Taji page fetches (4 without polite mode)"`
+	StravaWorkers   int           `env:"TAJU_STRAVA_WORKERS" default:"2" doc:"concurrent Strava detail fetches"`
+	PostOrder       string        `env:"TAJU_POST_ORDER" default:"oldest" doc:"order pending activities are posted in: oldest (chronological) or newest first"`
+	PostWorkers     int           `env:"TAJU_POST_WORKERS" default:"1" doc:"concurrent posts to Taji"`
+	MaxBodyLog      int           `env:"TAJU_MAX_BODY_LOG" default:"300" doc:"bytes of a rejected Taji response to log"`
+	BreakerFailures int           `env:"TAJU_BREAKER_FAILURES" default:"3" doc:"cycles in a row that can't read Taji before it is left alone for a while (0: never)"`
+	BreakerCooldown time.Duration `env:"TAJU_BREAKER_COOLDOWN" default:"30m" doc:"first wait before trying Taji again, doubled after every failed try up to 6h"`
+	HttpTimeout     time.Duration `env:"TAJU_HTTP_TIMEOUT" default:"60s" doc:"time one Strava or Taji request may take, each retry again (0: no limit)"`
+	HttpProxy       string        `env:"TAJU_HTTP_PROXY" format:"url" doc:"proxy for Strava and Taji (default: HTTP_PROXY, HTTPS_PROXY and NO_PROXY)"`
+	CaFile          string        `env:"TAJU_CA_FILE" format:"path" doc:"PEM bundle of extra certificate authorities, for networks that inspect TLS"`
+	UserAgent       string        `env:"TAJU_USER_AGENT" doc:"User-Agent sent to Strava and Taji (default: tajuploader/<version>)"`
+
+	LogLevel  string `env:"TAJU_LOG_LEVEL" default:"info" doc:"debug, info, warn or error; debug logs every sync decision (--log-level)"`
+	LogFormat string `env:"TAJU_LOG_FORMAT" doc:"text or json structured logs instead of plain lines (--log-format)"`
+	LogFile   string `env:"TAJU_LOG_FILE" format:"path" doc:"log to this file instead of stderr (relative to TAJU_STATE_DIR if set, --log-file)"`
+	LogMaxMb  int    `env:"TAJU_LOG_MAX_MB" default:"10" doc:"size at which the log file is rotated, keeping 3 old files"`
+
+	DebugDir       string        `env:"TAJU_DEBUG_DIR" format:"path" doc:"directory for redacted debug artifacts (relative to TAJU_STATE_DIR if set)"`
+	DebugMaxMb     int           `env:"TAJU_DEBUG_MAX_MB" doc:"size limit of the debug directory"`
+	DebugMaxAge    time.Duration `env:"TAJU_DEBUG_MAX_AGE" doc:"age limit of debug artifacts"`
+	FakeNow        string        `env:"TAJU_FAKE_NOW" format:"RFC 3339" doc:"pin the clock for testing"`
+	StravaVcr      string        `env:"TAJU_STRAVA_VCR" doc:"record or replay Strava API responses"`
+	StravaCassette string        `env:"TAJU_STRAVA_CASSETTE" format:"path" doc:"directory of recorded Strava responses"`
+}
+
+// Values in the env file that are written encrypted. This only keeps them out
+// of casual screenshots and shared files; anyone on the same machine account
+// can derive the key. TAJU_CREDENTIALS=keyring moves them to the OS keyring
+// instead, and TAJU_CREDENTIALS=passphrase encrypts them under a passphrase.
+var SECRET_KEYS = []string{"STRAVA_TOKEN", "STRAVA_REFRESH_TOKEN", "TAJI_SESSION", "TAJI_CSRF", "TAJI_PASSWORD", "TAJU_CLIENT_SECRET", "TAJU_SMTP_PASSWORD",
+	"TAJU_REMOTE_PASSWORD", "TAJU_REMOTE_KEY"}
+
+// IsSecret also covers the per-account STRAVA_TOKEN_<NAME> values and the
+// Taji passwords of participants.
+func IsSecret(key string) bool {
+	if strings.HasPrefix(key, "TAJU_PARTICIPANT_") && strings.HasSuffix(key, "_TAJI_PASSWORD") {
+		return true
+	}
+	for _, secret := range SECRET_KEYS {
+		if key == secret || strings.HasPrefix(key, secret+"_") {
+			return true
+		}
+	}
+	return false
+}
+
+// Field finds the Schema field of a key, matching keys ending in _ as
+// prefixes.
+func Field(key string) (reflect.StructField, bool) {
+	schema := reflect.TypeOf(Schema{})
+	for i := 0; i < schema.NumField(); i++ {
+		field := schema.Field(i)
+		name := field.Tag.Get("env")
+		if name == key || strings.HasSuffix(name, "_") && strings.HasPrefix(key, name) && len(key) > len(name) {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// Fields lists the Schema fields in the order they are documented.
+func Fields() (fields []reflect.StructField) {
+	schema := reflect.TypeOf(Schema{})
+	for i := 0; i < schema.NumField(); i++ {
+		fields = append(fields, schema.Field(i))
+	}
+	return
+}
+
+// Type is how the type of a field is shown in taju config docs.
+func Type(field reflect.StructField) string {
+	if format := field.Tag.Get("format"); format != "" {
+		return format
+	}
+	switch field.Type {
+	case reflect.TypeOf(time.Duration(0)):
+		return "duration"
+	case reflect.TypeOf([]string(nil)):
+		return "list"
+	case reflect.TypeOf(float64(0)):
+		return "number"
+	}
+	return field.Type.String()
+}
+
+func isPairsKey(key string) bool {
+	field, ok := Field(key)
+	return ok && !strings.HasSuffix(field.Tag.Get("env"), "_") && (field.Type.Kind() == reflect.Slice || field.Type.Kind() == reflect.String)
+}
+
+// Validate checks a value against the type and format of its field. Values
+// the feature itself reads more loosely (units, enumerations) are left to
+// it.
+func Validate(field reflect.StructField, value string) error {
+	switch field.Tag.Get("format") {
+	case "url":
+		if parsed, err := url.Parse(value); value != "" && (err != nil || parsed.Scheme == "" || parsed.Host == "") {
+			return fmt.Errorf("%q is not a URL, e.g. https://example.com/hook", value)
+		}
+		return nil
+	case "date":
+		if _, err := time.Parse(DATE_FORMAT, value); err != nil {
+			return fmt.Errorf("%q is not a date, use YYYY-MM-DD", value)
+		}
+		return nil
+	case "RFC 3339":
+		if _, err := time.Parse(time.RFC3339, value); err != nil {
+			return fmt.Errorf("%q is not an RFC 3339 time, e.g. 2026-02-14T08:00:00Z", value)
+		}
+		return nil
+	}
+	switch field.Type.Kind() {
+	case reflect.Bool:
+		if _, err := strconv.ParseBool(value); err != nil {
+			return fmt.Errorf("%q is not true or false", value)
+		}
+	case reflect.Int:
+		if _, err := strconv.Atoi(value); err != nil {
+			return fmt.Errorf("%q is not a whole number", value)
+		}
+	case reflect.Float64:
+		// A distance may carry its unit, like 160km; the feature checks it.
+		if _, err := ParseNumber(strings.TrimRightFunc(strings.TrimSpace(value), unicode.IsLetter)); errors.Is(err, ErrAmbiguousNumber) {
+			return err
+		} else if err != nil {
+			return fmt.Errorf("%q is not a number", value)
+		}
+	case reflect.Int64:
+		if _, err := time.ParseDuration(value); err != nil {
+			return fmt.Errorf("%q is not a duration, e.g. 12h, 30m or 90s", value)
+		}
+	}
+	return nil
+}
+
+// ErrAmbiguousNumber is returned by ParseNumber for a number like "1,234".
+var ErrAmbiguousNumber = errors.New("the comma could group thousands or mark decimals, leave it out or use a decimal point")
+
+// ParseNumber reads a number typed with a decimal point or comma, e.g.
+// "5.2", "5,2" or "1.234,5". With both, the last one is the decimal
+// separator and the other groups thousands. A lone comma before three
+// digits, as in "1,234", is either, so it is an error.
+func ParseNumber(value string) (float64, error) {
+	value = strings.ReplaceAll(strings.TrimSpace(value), " ", "")
+	point, comma := strings.LastIndex(value, "."), strings.LastIndex(value, ",")
+	switch {
+	case comma > point:
+		if point < 0 && len(value)-comma == 4 && strings.Count(value, ",") == 1 && strings.Trim(value[:comma], "+-0") != "" {
+			return 0, fmt.Errorf("%q is ambiguous: %w", value, ErrAmbiguousNumber)
+		}
+		value = strings.ReplaceAll(value, ".", "")
+		value = strings.Replace(value, ",", ".", 1)
+	case point > comma:
+		value = strings.ReplaceAll(value, ",", "")
+	}
+	return strconv.ParseFloat(value, 64)
+}
+
+// ClosestKey suggests the known key a misspelled one was meant to be.
+func ClosestKey(key string) (closest string) {
+	best := 4
+	for _, field := range Fields() {
+		name := field.Tag.Get("env")
+		if strings.HasSuffix(name, "_") {
+			continue
+		}
+		if distance := editDistance(key, name); distance < best {
+			best, closest = distance, name
+		}
+	}
+	return
+}
+
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous = current
+	}
+	return previous[len(b)]
+}
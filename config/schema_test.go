@@ -0,0 +1,36 @@
+package config
+
+import "testing"
+
+func TestParseNumber(t *testing.T) {
+	tests := []struct {
+		value string
+		want  float64
+		err   bool
+	}{
+		{value: "5.2", want: 5.2},
+		{value: "5,2", want: 5.2},
+		{value: " 42 ", want: 42},
+		{value: "1,234.5", want: 1234.5},
+		{value: "1.234,5", want: 1234.5},
+		{value: "1 234,5", want: 1234.5},
+		// A lone comma before three digits groups thousands in one locale
+		// and marks decimals in another.
+		{value: "1,234", err: true},
+		{value: "21,097", err: true},
+		{value: "1,5", want: 1.5},
+		{value: "0,125", want: 0.125},
+		{value: "1,2345", want: 1.2345},
+		// Only the last separator is taken as the decimal one, so several
+		// commas without a point are an error rather than a guess.
+		{value: "1,234,567", err: true},
+		{value: "", err: true},
+		{value: "five", err: true},
+	}
+	for _, test := range tests {
+		got, err := ParseNumber(test.value)
+		if (err != nil) != test.err || !test.err && got != test.want {
+			t.Errorf("ParseNumber(%q) = %g, %v, want %g", test.value, got, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// The state dir is where taju writes everything it changes while running:
+// the tokens and sessions it saves to the env file, the state ledger, the
+// page history and debug artifacts. It is "" when TAJU_STATE_DIR isn't set,
+// and then all of that lives next to taju.env.
+//
+// With a state dir the binary and taju.env can sit on a read-only volume:
+// the values taju writes go to an env file in the state dir, which is read
+// over the configured one.
+
+// StateDir picks up TAJU_STATE_DIR from the environment or env, creating
+// the directory.
+func StateDir(env map[string]string) (string, error) {
+	dir := os.Getenv("TAJU_STATE_DIR")
+	if dir == "" {
+		dir = env["TAJU_STATE_DIR"]
+	}
+	if dir == "" {
+		return "", nil
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("can't create TAJU_STATE_DIR: %w", err)
+	}
+	return dir, nil
+}
+
+// StatePath returns where a file written at runtime goes in the state dir.
+// Absolute paths are left alone.
+func StatePath(dir string, name string) string {
+	if dir == "" || filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(dir, name)
+}
+
+// ReadSaved reads the values an earlier run saved in the env file at path
+// into env. There being none is fine.
+func ReadSaved(path string, env map[string]string) error {
+	saved, err := ReadEnvFile(path)
+	if err != nil {
+		return fmt.Errorf("error loading %s: %w", path, err)
+	}
+	for key, value := range saved {
+		env[key] = value
+	}
+	return nil
+}
+
+// Changed returns the values of env that differ from the configured
+// taju.env, which is what gets saved in the state dir.
+func Changed(env map[string]string, configured map[string]string) map[string]string {
+	changed := make(map[string]string)
+	for key, value := range env {
+		if configured[key] != value {
+			changed[key] = value
+		}
+	}
+	return changed
+}
@@ -0,0 +1,435 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// The config file parsers read the plain subset of TOML and YAML settings
+// need, and nothing else:
+//
+//   - TOML: key = value lines with bare, quoted or dotted keys, [table]
+//     headers, and values that are basic or literal strings on one line,
+//     bare numbers, booleans and dates (read as text), or [lists] of those,
+//     which may go on over several lines.
+//   - YAML: block mappings of key: value lines nested by indenting with
+//     spaces, and values that are plain, single- or double-quoted scalars
+//     on one line, or lists of those, as - items or a [flow, list], which
+//     may go on over several lines. The file may start with ---.
+//
+// Both take # comments. Anything outside the subset is an error at its
+// line instead of a guess: in TOML multi-line strings, inline tables,
+// arrays of tables and nested arrays; in YAML block scalars (| and >),
+// flow mappings, anchors, aliases and merge keys, tags, directives, more
+// than one document, complex keys, tabs in indentation, lists of mappings
+// and nested lists.
+
+// scanTopLevel calls fn with the index of every rune of s outside quotes
+// and the depth of brackets it is at, until fn returns false, and returns
+// the depth at the end. A quote only opens a string where a value or key
+// starts, so the apostrophe of an unquoted Sam's run is part of the text.
+// A quote doubled in a single-quoted string, or escaped with a backslash in
+// a double-quoted one, doesn't close it.
+func scanTopLevel(s string, fn func(i int, r rune, depth int) bool) int {
+	var quote rune
+	depth := 0
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case quote == '"' && r == '\\' && i+size < len(s):
+			_, escaped := utf8.DecodeRuneInString(s[i+size:])
+			size += escaped
+		case quote == '\'' && r == '\'' && strings.HasPrefix(s[i+size:], "'"):
+			size++
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case (r == '"' || r == '\'') && (i == 0 || strings.ContainsRune(" \t:=,[{", rune(s[i-1]))):
+			quote = r
+		case r == '[' || r == '{':
+			depth++
+		case r == ']' || r == '}':
+			depth--
+		default:
+			if !fn(i, r, depth) {
+				return depth
+			}
+		}
+		i += size
+	}
+	return depth
+}
+
+// stripComment cuts a # comment that isn't inside quotes.
+func stripComment(line string) string {
+	end := len(line)
+	scanTopLevel(line, func(i int, r rune, depth int) bool {
+		if r == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t') {
+			end = i
+			return false
+		}
+		return true
+	})
+	return strings.TrimRight(line[:end], " \t")
+}
+
+// splitTopLevel splits s at sep outside quotes and brackets.
+func splitTopLevel(s string, sep rune) (parts []string) {
+	start := 0
+	scanTopLevel(s, func(i int, r rune, depth int) bool {
+		if r == sep && depth == 0 {
+			parts = append(parts, s[start:i])
+			start = i + utf8.RuneLen(r)
+		}
+		return true
+	})
+	return append(parts, s[start:])
+}
+
+// balanced reports whether every bracket opened in s is closed.
+func balanced(s string) bool {
+	return scanTopLevel(s, func(int, rune, int) bool { return true }) <= 0
+}
+
+func unquote(s string) (string, error) {
+	switch {
+	case len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"':
+		return strconv.Unquote(s)
+	case len(s) >= 2 && s[0] == '\'' && s[len(s)-1] == '\'':
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'"), nil
+	case strings.HasPrefix(s, `"`) || strings.HasPrefix(s, "'"):
+		return "", fmt.Errorf("unterminated string %s", s)
+	}
+	return s, nil
+}
+
+// parseInlineValue reads a scalar or a [list] of scalars. sep tells the
+// syntax: '=' for TOML, ':' for YAML.
+func parseInlineValue(s string, sep rune, line int) (node, error) {
+	s = strings.TrimSpace(s)
+	switch {
+	case strings.HasPrefix(s, "["):
+		if !strings.HasSuffix(s, "]") {
+			return node{}, fmt.Errorf("unclosed list %s", s)
+		}
+		n := node{kind: NODE_LIST, line: line}
+		inner := strings.TrimSpace(s[1 : len(s)-1])
+		if inner == "" {
+			return n, nil
+		}
+		for _, item := range splitTopLevel(inner, ',') {
+			item = strings.TrimSpace(item)
+			if item == "" {
+				continue // trailing comma
+			}
+			if strings.HasPrefix(item, "[") {
+				return node{}, errors.New("nested lists aren't supported")
+			}
+			value, err := parseInlineValue(item, sep, line)
+			if err != nil {
+				return node{}, err
+			}
+			n.list = append(n.list, value)
+		}
+		return n, nil
+	case strings.HasPrefix(s, "{") && sep == '=':
+		return node{}, errors.New("inline tables aren't supported, use a [table] or dotted keys")
+	case strings.HasPrefix(s, "{"):
+		return node{}, errors.New("flow mappings aren't supported, put the keys on lines of their own")
+	}
+	if sep == ':' {
+		if err := yamlScalar(s); err != nil {
+			return node{}, err
+		}
+	}
+	value, err := unquote(s)
+	return node{kind: NODE_SCALAR, line: line, scalar: value}, err
+}
+
+// yamlScalar rejects the YAML values that aren't plain or quoted scalars.
+func yamlScalar(s string) error {
+	switch {
+	case strings.HasPrefix(s, "|") || strings.HasPrefix(s, ">"):
+		return errors.New("multi-line strings aren't supported")
+	case strings.HasPrefix(s, "&") || strings.HasPrefix(s, "*"):
+		return errors.New("anchors and aliases aren't supported")
+	case strings.HasPrefix(s, "!"):
+		return errors.New("tags aren't supported")
+	case strings.HasPrefix(s, "@") || strings.HasPrefix(s, "`"):
+		return fmt.Errorf("a plain value can't start with %c, quote it", s[0])
+	}
+	return nil
+}
+
+// setPath puts value at the dotted path below root, creating tables.
+func setPath(root *node, path []string, value node) error {
+	n := root
+	for _, key := range path[:len(path)-1] {
+		child, ok := n.child(key)
+		if !ok {
+			n.keys, n.values = append(n.keys, key), append(n.values, node{kind: NODE_MAPPING, line: value.line})
+			child = &n.values[len(n.values)-1]
+		}
+		if child.kind != NODE_MAPPING {
+			return fmt.Errorf("%s is already set to a value", key)
+		}
+		n = child
+	}
+	key := path[len(path)-1]
+	if _, ok := n.child(key); ok {
+		return fmt.Errorf("%s is set twice", key)
+	}
+	n.keys, n.values = append(n.keys, key), append(n.values, value)
+	return nil
+}
+
+// parseTomlKey splits a dotted TOML key, bare or quoted.
+func parseTomlKey(s string) ([]string, error) {
+	var path []string
+	for _, part := range splitTopLevel(s, '.') {
+		key, err := unquote(strings.TrimSpace(part))
+		if err != nil {
+			return nil, err
+		}
+		if key == "" {
+			return nil, fmt.Errorf("empty key in %q", s)
+		}
+		path = append(path, key)
+	}
+	return path, nil
+}
+
+func parseToml(text string, source string) (root node, errs []Error) {
+	root.kind = NODE_MAPPING
+	var table []string
+	lines := strings.Split(text, "\n")
+	for i := 0; i < len(lines); i++ {
+		number := i + 1
+		line := strings.TrimSpace(stripComment(lines[i]))
+		fail := func(format string, args ...any) {
+			errs = append(errs, Error{Source: source, Line: number, Msg: fmt.Sprintf(format, args...)})
+		}
+		switch {
+		case line == "":
+			continue
+		case strings.HasPrefix(line, "[["):
+			fail("arrays of tables aren't supported")
+			continue
+		case strings.HasPrefix(line, "["):
+			if !strings.HasSuffix(line, "]") {
+				fail("unclosed table header %s", line)
+				continue
+			}
+			path, err := parseTomlKey(line[1 : len(line)-1])
+			if err != nil {
+				fail("%v", err)
+				continue
+			}
+			table = path
+			continue
+		}
+		parts := splitTopLevel(line, '=')
+		if len(parts) < 2 {
+			fail("expected key = value")
+			continue
+		}
+		key, value := parts[0], strings.Join(parts[1:], "=")
+		// Lists may go on over several lines.
+		for !balanced(value) && i+1 < len(lines) {
+			i++
+			value += " " + strings.TrimSpace(stripComment(lines[i]))
+		}
+		value = strings.TrimSpace(value)
+		if strings.HasPrefix(value, `"""`) || strings.HasPrefix(value, "'''") {
+			fail("multi-line strings aren't supported")
+			continue
+		}
+		path, err := parseTomlKey(key)
+		if err != nil {
+			fail("%v", err)
+			continue
+		}
+		n, err := parseInlineValue(value, '=', number)
+		if err != nil {
+			fail("%v", err)
+			continue
+		}
+		if err := setPath(&root, append(slices.Clone(table), path...), n); err != nil {
+			fail("%v", err)
+		}
+	}
+	return
+}
+
+type yamlLine struct {
+	number int
+	indent int
+	text   string
+}
+
+func parseYaml(text string, source string) (node, []Error) {
+	var lines []yamlLine
+	p := &yamlParser{source: source}
+	started := false
+	for i, line := range strings.Split(text, "\n") {
+		line = stripComment(strings.TrimRight(line, "\r"))
+		trimmed := strings.TrimLeft(line, " ")
+		switch {
+		case trimmed == "":
+			continue
+		case trimmed == "---" && !started:
+			started = true
+			continue
+		case trimmed == "---" || trimmed == "...":
+			p.fail(i+1, "more than one document isn't supported")
+			continue
+		case strings.HasPrefix(trimmed, "\t"):
+			p.fail(i+1, "tabs can't indent YAML, use spaces")
+			continue
+		case strings.HasPrefix(trimmed, "%"):
+			p.fail(i+1, "directives aren't supported")
+			continue
+		case trimmed == "?" || strings.HasPrefix(trimmed, "? "):
+			p.fail(i+1, "complex keys aren't supported")
+			continue
+		}
+		started = true
+		lines = append(lines, yamlLine{number: i + 1, indent: len(line) - len(trimmed), text: trimmed})
+	}
+	p.lines = lines
+	if len(lines) == 0 {
+		return node{kind: NODE_MAPPING}, p.errs
+	}
+	root := p.block(lines[0].indent)
+	if p.next < len(lines) {
+		p.fail(lines[p.next].number, "unexpected indentation")
+	}
+	if root.kind != NODE_MAPPING {
+		p.fail(lines[0].number, "the config file must be a mapping of settings")
+		root = node{kind: NODE_MAPPING}
+	}
+	return root, p.errs
+}
+
+type yamlParser struct {
+	lines  []yamlLine
+	next   int
+	source string
+	errs   []Error
+}
+
+func (p *yamlParser) fail(line int, format string, args ...any) {
+	p.errs = append(p.errs, Error{Source: p.source, Line: line, Msg: fmt.Sprintf(format, args...)})
+}
+
+// block reads the mapping or list whose lines start at indent.
+func (p *yamlParser) block(indent int) node {
+	first := p.lines[p.next]
+	if first.text == "-" || strings.HasPrefix(first.text, "- ") {
+		n := node{kind: NODE_LIST, line: first.number}
+		for p.next < len(p.lines) && p.lines[p.next].indent == indent && strings.HasPrefix(p.lines[p.next].text, "-") {
+			line := p.lines[p.next]
+			p.next++
+			item := strings.TrimSpace(strings.TrimPrefix(line.text, "-"))
+			if item == "" || splitMappingLine(item) != nil {
+				p.fail(line.number, "lists of mappings aren't supported")
+				p.skip(indent)
+				continue
+			}
+			if item == "-" || strings.HasPrefix(item, "- ") || strings.HasPrefix(item, "[") {
+				p.fail(line.number, "nested lists aren't supported")
+				p.skip(indent)
+				continue
+			}
+			value, err := parseInlineValue(item, ':', line.number)
+			if err != nil {
+				p.fail(line.number, "%v", err)
+			}
+			n.list = append(n.list, value)
+		}
+		return n
+	}
+
+	n := node{kind: NODE_MAPPING, line: first.number}
+	for p.next < len(p.lines) && p.lines[p.next].indent == indent {
+		line := p.lines[p.next]
+		p.next++
+		parts := splitMappingLine(line.text)
+		if parts == nil {
+			p.fail(line.number, "expected key: value")
+			p.skip(indent)
+			continue
+		}
+		key, err := unquote(strings.TrimSpace(parts[0]))
+		if err != nil {
+			p.fail(line.number, "%v", err)
+			continue
+		}
+		if key == "<<" {
+			p.fail(line.number, "merge keys aren't supported")
+			p.skip(indent)
+			continue
+		}
+		var value node
+		switch raw := strings.TrimSpace(parts[1]); {
+		case raw == "":
+			value = node{kind: NODE_MAPPING, line: line.number}
+			if p.next < len(p.lines) && p.lines[p.next].indent > indent {
+				value = p.block(p.lines[p.next].indent)
+			} else if p.next < len(p.lines) && p.lines[p.next].indent == indent && strings.HasPrefix(p.lines[p.next].text, "- ") {
+				// A list may sit at the key's own indentation.
+				value = p.block(indent)
+			}
+		case yamlScalar(raw) != nil:
+			// A block scalar or an anchored mapping goes on over the lines
+			// nested below.
+			p.fail(line.number, "%v", yamlScalar(raw))
+			p.skip(indent)
+			continue
+		default:
+			for !balanced(raw) && p.next < len(p.lines) {
+				raw += " " + p.lines[p.next].text
+				p.next++
+			}
+			if value, err = parseInlineValue(raw, ':', line.number); err != nil {
+				p.fail(line.number, "%v", err)
+				continue
+			}
+		}
+		if _, ok := n.child(key); ok {
+			p.fail(line.number, "%s is set twice", key)
+			continue
+		}
+		n.keys, n.values = append(n.keys, key), append(n.values, value)
+	}
+	if p.next < len(p.lines) && p.lines[p.next].indent > indent {
+		p.fail(p.lines[p.next].number, "unexpected indentation")
+		p.skip(indent)
+	}
+	return n
+}
+
+// skip passes over the lines nested deeper than indent.
+func (p *yamlParser) skip(indent int) {
+	for p.next < len(p.lines) && p.lines[p.next].indent > indent {
+		p.next++
+	}
+}
+
+// splitMappingLine splits "key: value" (or "key:") at the colon outside
+// quotes and brackets, nil if the line isn't a mapping entry.
+func splitMappingLine(text string) []string {
+	parts := splitTopLevel(text, ':')
+	for i := 1; i < len(parts); i++ {
+		rest := strings.Join(parts[i:], ":")
+		if rest == "" || strings.HasPrefix(rest, " ") {
+			return []string{strings.Join(parts[:i], ":"), rest}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// render writes a parsed config node compactly, strings quoted.
+func render(n node) string {
+	switch n.kind {
+	case NODE_LIST:
+		var items []string
+		for _, item := range n.list {
+			items = append(items, render(item))
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	case NODE_MAPPING:
+		var pairs []string
+		for i, key := range n.keys {
+			pairs = append(pairs, key+": "+render(n.values[i]))
+		}
+		return "{" + strings.Join(pairs, ", ") + "}"
+	}
+	return fmt.Sprintf("%q", n.scalar)
+}
+
+func TestStripComment(t *testing.T) {
+	tests := []struct {
+		line string
+		want string
+	}{
+		{line: "name: Sam's run # note", want: "name: Sam's run"},
+		{line: `name: "a # b" # note`, want: `name: "a # b"`},
+		{line: "name: 'It''s # 1' # note", want: "name: 'It''s # 1'"},
+		{line: `name = "say \"hi\" # twice" # note`, want: `name = "say \"hi\" # twice"`},
+		{line: "url: https://example.com/#top", want: "url: https://example.com/#top"},
+		{line: "# only a comment", want: ""},
+		{line: `size: 5" # inches`, want: `size: 5"`},
+	}
+	for _, test := range tests {
+		if got := stripComment(test.line); got != test.want {
+			t.Errorf("stripComment(%q) = %q, want %q", test.line, got, test.want)
+		}
+	}
+}
+
+func TestParseYaml(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+		err  string
+		line int
+	}{
+		{
+			name: "scalars",
+			text: "timezone: Europe/Berlin\nheadless: true\ninterval: 12h\n",
+			want: `{timezone: "Europe/Berlin", headless: "true", interval: "12h"}`,
+		},
+		{
+			name: "apostrophe in an unquoted value",
+			text: "name: Sam's run # note\nnext: on\n",
+			want: `{name: "Sam's run", next: "on"}`,
+		},
+		{
+			name: "quoted",
+			text: "a: \"x: y\"\nb: 'It''s'\n",
+			want: `{a: "x: y", b: "It's"}`,
+		},
+		{
+			name: "URL values",
+			text: "remote:\n  url: https://dav.example.com/taju\n",
+			want: `{remote: {url: "https://dav.example.com/taju"}}`,
+		},
+		{
+			name: "flow list of URLs",
+			text: "remotes: [https://dav.example.com:8443/taju, 'https://b.example.com/#x']\n",
+			want: `{remotes: ["https://dav.example.com:8443/taju", "https://b.example.com/#x"]}`,
+		},
+		{
+			name: "document start",
+			text: "---\nlog: debug\n",
+			want: `{log: "debug"}`,
+		},
+		{
+			name: "flow list over several lines",
+			text: "accounts: [alice,\n  bob]\n",
+			want: `{accounts: ["alice", "bob"]}`,
+		},
+		{
+			name: "block list at the key's indentation",
+			text: "accounts:\n- alice\n- bob\n",
+			want: `{accounts: ["alice", "bob"]}`,
+		},
+		{
+			name: "nested",
+			text: "policy:\n  mismatch: skip\n  taji_only: overwrite\nlog: debug\n",
+			want: `{policy: {mismatch: "skip", taji_only: "overwrite"}, log: "debug"}`,
+		},
+		{name: "set twice", text: "a: 1\na: 2\n", err: "a is set twice", line: 2},
+		{name: "multi-line string", text: "notes: |\n  one\n", err: "multi-line strings aren't supported"},
+		{name: "folded string", text: "notes: >-\n  one\n  two\n", err: "multi-line strings aren't supported"},
+		{name: "plain scalar over two lines", text: "notes: one\n  two\n", err: "unexpected indentation", line: 2},
+		{name: "flow mapping", text: "remote: {url: https://dav.example.com:8443/taju, storage: webdav}\n", err: "flow mappings aren't supported"},
+		{name: "flow mapping in a list", text: "a:\n  - {b: 1}\n", err: "flow mappings aren't supported", line: 2},
+		{name: "anchor", text: "a: &x 1\n", err: "anchors and aliases aren't supported"},
+		{name: "anchored mapping", text: "base: &base\n  log: debug\n", err: "anchors and aliases aren't supported"},
+		{name: "alias in a list", text: "a:\n  - *x\n", err: "anchors and aliases aren't supported", line: 2},
+		{name: "merge key", text: "a:\n  <<: *base\n", err: "merge keys aren't supported", line: 2},
+		{name: "tag", text: "a: !!str 1\n", err: "tags aren't supported"},
+		{name: "directive", text: "%YAML 1.2\n---\na: 1\n", err: "directives aren't supported"},
+		{name: "second document", text: "a: 1\n---\nb: 2\n", err: "more than one document", line: 2},
+		{name: "complex key", text: "? a\n: 1\n", err: "complex keys aren't supported"},
+		{name: "tab indentation", text: "a:\n\tb: 1\n", err: "tabs can't indent YAML", line: 2},
+		{name: "nested list", text: "a:\n  - - b\n", err: "nested lists aren't supported", line: 2},
+		{name: "nested flow list", text: "a: [[b]]\n", err: "nested lists aren't supported"},
+		{name: "reserved character", text: "a: @home\n", err: "can't start with @"},
+		{name: "list of mappings", text: "a:\n  - b: 1\n", err: "lists of mappings aren't supported", line: 2},
+		{name: "not a mapping", text: "just text\n", err: "expected key: value"},
+		{name: "unterminated string", text: "a: \"open\n", err: "unterminated string"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			root, errs := parseYaml(test.text, "taju.yaml")
+			checkParsed(t, root, errs, test.want, test.err, test.line)
+		})
+	}
+}
+
+func TestParseToml(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+		err  string
+		line int
+	}{
+		{
+			name: "scalars",
+			text: "timezone = \"Europe/Berlin\"\nheadless = true\n",
+			want: `{timezone: "Europe/Berlin", headless: "true"}`,
+		},
+		{
+			name: "tables and dotted keys",
+			text: "[remote]\nurl = \"https://dav.example.com/taju\" # shared\npolicy.mismatch = 'skip'\n",
+			want: `{remote: {url: "https://dav.example.com/taju", policy: {mismatch: "skip"}}}`,
+		},
+		{
+			name: "dotted keys with URLs",
+			text: "remote.url = \"https://dav.example.com:8443/a=b\"\nremote.storage = \"webdav\"\n",
+			want: `{remote: {url: "https://dav.example.com:8443/a=b", storage: "webdav"}}`,
+		},
+		{
+			name: "list over several lines",
+			text: "accounts = [\n  \"alice\", # first\n  \"bob\",\n]\n",
+			want: `{accounts: ["alice", "bob"]}`,
+		},
+		{
+			name: "comment with an apostrophe",
+			text: "interval = \"12h\" # Sam's pick\n",
+			want: `{interval: "12h"}`,
+		},
+		{name: "array of tables", text: "[[profiles]]\n", err: "arrays of tables aren't supported"},
+		{name: "multi-line string", text: "notes = \"\"\"\none\n\"\"\"\n", err: "multi-line strings aren't supported"},
+		{name: "multi-line literal string", text: "a = 1\nnotes = '''\none\n'''\n", err: "multi-line strings aren't supported", line: 2},
+		{name: "inline table", text: "remote = { url = \"https://dav.example.com\", storage = \"webdav\" }\n", err: "inline tables aren't supported"},
+		{name: "inline table in a list", text: "a = [\n  { b = 1 },\n]\n", err: "inline tables aren't supported"},
+		{name: "nested array", text: "a = [[1, 2], [3]]\n", err: "nested lists aren't supported"},
+		{name: "set twice", text: "a = 1\na = 2\n", err: "a is set twice", line: 2},
+		{name: "table over a value", text: "a = 1\n[a]\nb = 2\n", err: "a is already set to a value", line: 3},
+		{name: "no value", text: "a\n", err: "expected key = value"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			root, errs := parseToml(test.text, "taju.toml")
+			checkParsed(t, root, errs, test.want, test.err, test.line)
+		})
+	}
+}
+
+// checkParsed checks the parsed root, or that the first error is err at
+// line (1 unless set).
+func checkParsed(t *testing.T, root node, errs []Error, want string, err string, line int) {
+	t.Helper()
+	if err != "" {
+		if len(errs) == 0 || !strings.Contains(errs[0].Msg, err) || errs[0].Line != max(line, 1) {
+			t.Errorf("errors %v, want %q at line %d", errs, err, max(line, 1))
+		}
+		return
+	}
+	if len(errs) > 0 {
+		t.Fatalf("errors %v", errs)
+	}
+	if got := render(root); got != want {
+		t.Errorf("parsed %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,52 @@
+// Command embed is a minimal frontend on the taju sync engine: it runs one
+// sync with the taju.env of the current directory (see taju config docs),
+// printing every activity and decision as it goes. Ctrl-C cancels the sync
+// before its next change to Taji.
+//
+//	go run ./examples/embed [--dry-run] [--profile name]
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+
+	tajusync "github.com/tajuploader/sync"
+	"github.com/tajuploader/taju"
+)
+
+func main() {
+	dry_run := flag.Bool("dry-run", false, "show what would change on Taji without changing it")
+	profile := flag.String("profile", "", "profile to use, see TAJU_PROFILES")
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	syncer, err := taju.NewSyncer(tajusync.Config{Profile: *profile})
+	if err != nil {
+		log.Fatal(err)
+	}
+	result, err := syncer.Run(ctx, tajusync.Options{
+		DryRun: *dry_run,
+		Activity: func(a tajusync.Activity) {
+			fmt.Printf("found %s %d on %s: %.2f km in %s\n", a.Type, a.StravaID, a.Start.Format("2006-01-02"), a.Distance/1000, a.Duration)
+		},
+		Decided: func(d tajusync.Decision) {
+			fmt.Printf("%s %d: %s\n", d.Decision, d.Activity.StravaID, d.Result)
+		},
+		Error: func(err error) {
+			fmt.Println("error:", err)
+		},
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("%d activities, %d posted\n", len(result.Activities), len(result.Posted))
+	if result.Failed {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,9 @@
+// Command taju uploads Strava activities to the Taji 100 challenge log, see
+// taju help. The uploader itself is package taju.
+package main
+
+import "github.com/tajuploader/taju"
+
+func main() {
+	taju.Main()
+}
@@ -0,0 +1,257 @@
+// Package stravaclient reads activities from the Strava API. It knows
+// nothing about tokens: the caller hands it an authorized *http.Client and
+// keeps refreshing or re-authorizing to itself.
+package stravaclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const API_URL string = "https://www.strava.com/api/v3"
+const PAGE_SIZE = 100
+
+type Activity struct {
+	Id                 int64   `json:"id"`
+	Name               string  `json:"name"`
+	Type               string  `json:"type"`
+	SportType          string  `json:"sport_type"`
+	StartDate          string  `json:"start_date"`
+	StartDateLocal     string  `json:"start_date_local"`
+	Timezone           string  `json:"timezone"`
+	Distance           float64 `json:"distance"`
+	MovingTime         int64   `json:"moving_time"`
+	ElapsedTime        int64   `json:"elapsed_time"`
+	TotalElevationGain float64 `json:"total_elevation_gain"`
+	Manual             bool    `json:"manual"`
+	Private            bool    `json:"private"`
+	Commute            bool    `json:"commute"`
+	WorkoutType        *int    `json:"workout_type"`
+	GearId             string  `json:"gear_id"`
+	Description        string  `json:"description"`
+	TotalPhotoCount    int     `json:"total_photo_count"`
+	DeviceName         string  `json:"device_name"`
+	Photos             Photos  `json:"photos"`
+	// The splits only come with the detailed representation.
+	SplitsStandard []Split `json:"splits_standard"`
+	SplitsMetric   []Split `json:"splits_metric"`
+}
+
+type Split struct {
+	Distance    float64 `json:"distance"`
+	ElapsedTime int64   `json:"elapsed_time"`
+	MovingTime  int64   `json:"moving_time"`
+	Split       int     `json:"split"`
+}
+
+// Photos summarizes an activity's photos. Primary is only included in the
+// detailed representation.
+type Photos struct {
+	Count   int `json:"count"`
+	Primary *struct {
+		Urls map[string]string `json:"urls"`
+	} `json:"primary"`
+}
+
+type Athlete struct {
+	Id        int64  `json:"id"`
+	Username  string `json:"username"`
+	Firstname string `json:"firstname"`
+	Lastname  string `json:"lastname"`
+}
+
+type Stream struct {
+	Type       string    `json:"type"`
+	Data       []float64 `json:"data"`
+	SeriesType string    `json:"series_type"`
+	Resolution string    `json:"resolution"`
+}
+
+// Cache stores raw API responses keyed by request URL. The client skips the
+// network for any key the cache returns.
+type Cache interface {
+	Get(key string) ([]byte, bool)
+	Set(key string, value []byte)
+}
+
+type MemoryCache struct {
+	mu      sync.Mutex
+	entries map[string][]byte
+}
+
+func NewMemoryCache() *MemoryCache {
+	return &MemoryCache{entries: make(map[string][]byte)}
+}
+
+func (c *MemoryCache) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	value, ok := c.entries[key]
+	return value, ok
+}
+
+func (c *MemoryCache) Set(key string, value []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = value
+}
+
+// Client reads the API as one Strava account.
+type Client struct {
+	// Account names the account in errors.
+	Account string
+	// API is API_URL, or a stand-in for it.
+	API string
+	// HTTPClient returns a client that authorizes its requests. It is only
+	// called when a response isn't cached.
+	HTTPClient func() (*http.Client, error)
+	Cache      Cache
+	// Context bounds the requests, nil for none.
+	Context context.Context
+	// Workers is how many activities Activities fetches at a time.
+	Workers int
+	// Artifact, if set, is handed response bodies taju couldn't use.
+	Artifact func(name string, body []byte)
+}
+
+// Get performs a GET against the API and decodes the JSON response into
+// out. Responses are only cached when cacheable is set, since activity lists
+// change as new runs are recorded.
+func (c *Client) Get(path string, query url.Values, cacheable bool, out interface{}) error {
+	endpoint := c.API + path
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+
+	if cacheable && c.Cache != nil {
+		if body, ok := c.Cache.Get(endpoint); ok {
+			return json.Unmarshal(body, out)
+		}
+	}
+
+	client, err := c.HTTPClient()
+	if err != nil {
+		return err
+	}
+	ctx := c.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != 200 {
+		c.artifact("strava-error.json", body)
+		return newError(c.Account, path, resp, body)
+	}
+
+	if err := json.Unmarshal(body, out); err != nil {
+		c.artifact("strava-response.json", body)
+		if looksLikeHTML(resp, body) {
+			return &Error{Path: path, Status: resp.StatusCode, Account: c.Account, Unavailable: true, Message: "Strava sent a web page instead of JSON"}
+		}
+		return fmt.Errorf("strava %s returned JSON taju can't read: %w", path, err)
+	}
+	if cacheable && c.Cache != nil {
+		c.Cache.Set(endpoint, body)
+	}
+	return nil
+}
+
+func (c *Client) artifact(name string, body []byte) {
+	if c.Artifact != nil {
+		c.Artifact(name, body)
+	}
+}
+
+func (c *Client) ListActivities(after time.Time, before time.Time, page int, perPage int) (activities []Activity, err error) {
+	query := url.Values{}
+	query.Set("after", fmt.Sprint(after.Unix()))
+	query.Set("before", fmt.Sprint(before.Unix()))
+	query.Set("page", fmt.Sprint(page))
+	query.Set("per_page", fmt.Sprint(perPage))
+	err = c.Get("/athlete/activities", query, false, &activities)
+	return
+}
+
+// ListAllActivities pages through the activity list until Strava returns
+// an empty page.
+func (c *Client) ListAllActivities(after time.Time, before time.Time) (activities []Activity, err error) {
+	for page := 1; ; page++ {
+		batch, err := c.ListActivities(after, before, page, PAGE_SIZE)
+		if err != nil {
+			return nil, err
+		}
+		if len(batch) == 0 {
+			return activities, nil
+		}
+		activities = append(activities, batch...)
+	}
+}
+
+func (c *Client) Activity(id int64) (activity Activity, err error) {
+	err = c.Get(fmt.Sprintf("/activities/%d", id), nil, true, &activity)
+	return
+}
+
+func (c *Client) Athlete() (athlete Athlete, err error) {
+	err = c.Get("/athlete", nil, true, &athlete)
+	return
+}
+
+func (c *Client) Streams(id int64, keys []string) (streams []Stream, err error) {
+	query := url.Values{}
+	query.Set("keys", strings.Join(keys, ","))
+	query.Set("key_by_type", "false")
+	err = c.Get(fmt.Sprintf("/activities/%d/streams", id), query, true, &streams)
+	return
+}
+
+// Activities fetches the detailed representation of several activities,
+// Workers at a time. Activities that fail to load are logged and left out.
+func (c *Client) Activities(ids []int64) (activities []Activity) {
+	results := make([]*Activity, len(ids))
+	workers := max(c.Workers, 1)
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, workers)
+	for i, id := range ids {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			activity, err := c.Activity(id)
+			if err != nil {
+				log.Print("Error fetching Strava activity ", id, ": ", err)
+				return
+			}
+			results[i] = &activity
+		}()
+	}
+	wg.Wait()
+	for _, activity := range results {
+		if activity != nil {
+			activities = append(activities, *activity)
+		}
+	}
+	return
+}
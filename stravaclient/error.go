@@ -0,0 +1,81 @@
+package stravaclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Error is a response Strava refused, with what to do about it: a rejected
+// token needs taju auth strava, a missing scope a new authorization with
+// activity:read_all, a rate limit waiting for the next window. Maintenance
+// pages come back as HTML and are reported as Strava being unavailable
+// rather than dumped into the log.
+type Error struct {
+	Path        string
+	Status      int
+	Account     string
+	Message     string
+	Fields      []string
+	Unavailable bool
+}
+
+// fault is the error body of the Strava API.
+type fault struct {
+	Message string `json:"message"`
+	Errors  []struct {
+		Resource string `json:"resource"`
+		Field    string `json:"field"`
+		Code     string `json:"code"`
+	} `json:"errors"`
+}
+
+func newError(account string, path string, resp *http.Response, body []byte) *Error {
+	e := &Error{Path: path, Status: resp.StatusCode, Account: account}
+	var f fault
+	if looksLikeHTML(resp, body) || json.Unmarshal(body, &f) != nil {
+		e.Unavailable = resp.StatusCode >= 500 || looksLikeHTML(resp, body)
+		e.Message = resp.Status
+		return e
+	}
+	e.Message = f.Message
+	for _, field := range f.Errors {
+		e.Fields = append(e.Fields, strings.Trim(field.Resource+" "+field.Field+" "+field.Code, " "))
+	}
+	e.Unavailable = resp.StatusCode >= 500
+	return e
+}
+
+// NeedsAuthorization tells whether the account has to be authorized again
+// before Strava answers.
+func (e *Error) NeedsAuthorization() bool {
+	return e.Status == http.StatusUnauthorized || e.Status == http.StatusForbidden
+}
+
+func (e *Error) Error() string {
+	detail := e.Message
+	if len(e.Fields) > 0 {
+		detail += " (" + strings.Join(e.Fields, ", ") + ")"
+	}
+	switch {
+	case e.Status == http.StatusUnauthorized:
+		return fmt.Sprintf("strava %s: the token of account %q was rejected, run taju auth strava %s: %s", e.Path, e.Account, e.Account, detail)
+	case e.Status == http.StatusForbidden:
+		return fmt.Sprintf("strava %s: account %q didn't grant access, authorize it again with activity:read_all (taju auth strava %s): %s",
+			e.Path, e.Account, e.Account, detail)
+	case e.Status == http.StatusTooManyRequests:
+		return fmt.Sprintf("strava %s: rate limited, trying again next sync: %s", e.Path, detail)
+	case e.Unavailable:
+		return fmt.Sprintf("strava %s: Strava is unavailable (%s), trying again next sync", e.Path, detail)
+	}
+	return fmt.Sprintf("strava %s returned %d: %s", e.Path, e.Status, detail)
+}
+
+func looksLikeHTML(resp *http.Response, body []byte) bool {
+	if strings.Contains(resp.Header.Get("Content-Type"), "html") {
+		return true
+	}
+	start := strings.ToLower(strings.TrimSpace(string(body[:min(len(body), 64)])))
+	return strings.HasPrefix(start, "<!doctype") || strings.HasPrefix(start, "<html")
+}
@@ -0,0 +1,88 @@
+package stravaclient
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVCR(t *testing.T) {
+	requests := 0
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[{"id": 1, "name": "Morning Run"}]`))
+	}))
+	defer api.Close()
+	cassette := t.TempDir()
+
+	get := func(transport http.RoundTripper, path string) (*http.Response, string, error) {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, api.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer secret-token")
+		res, err := transport.RoundTrip(req)
+		if err != nil {
+			return nil, "", err
+		}
+		defer res.Body.Close()
+		body, err := io.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res, string(body), nil
+	}
+
+	record, err := NewVCR(VCR_RECORD, cassette, http.DefaultTransport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, body, err := get(record, "/athlete/activities?page=1"); err != nil || !strings.Contains(body, "Morning Run") {
+		t.Fatalf("recording returned %q, %v", body, err)
+	}
+	files, err := os.ReadDir(cassette)
+	if err != nil || len(files) != 1 {
+		t.Fatalf("cassette has %d files (%v), want 1", len(files), err)
+	}
+	data, err := os.ReadFile(filepath.Join(cassette, files[0].Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "secret-token") {
+		t.Error("the recording holds the Authorization header")
+	}
+
+	// Replays never reach the network.
+	replay, err := NewVCR(VCR_REPLAY, cassette, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, body, err := get(replay, "/athlete/activities?page=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != "application/json" || !strings.Contains(body, "Morning Run") {
+		t.Errorf("replayed %d %q %q", res.StatusCode, res.Header.Get("Content-Type"), body)
+	}
+	if requests != 1 {
+		t.Errorf("Strava got %d requests, want 1", requests)
+	}
+	if _, _, err := get(replay, "/athlete/activities?page=2"); err == nil || !strings.Contains(err.Error(), "no recorded response") {
+		t.Errorf("replaying an unrecorded request: %v", err)
+	}
+}
+
+func TestNewVCR(t *testing.T) {
+	if _, err := NewVCR("rewind", t.TempDir(), nil); err == nil {
+		t.Error("NewVCR accepted an unknown mode")
+	}
+	if _, err := NewVCR(VCR_REPLAY, "", nil); err == nil {
+		t.Error("NewVCR accepted no cassette")
+	}
+}
@@ -0,0 +1,126 @@
+// Package sync is the API of the taju sync engine for programs that embed
+// it (a GUI, a bridge to a phone app): the configuration of an engine, the
+// callbacks of a run and what they are told, and the Strava and Taji
+// interfaces a program can put in place of the logins of its taju
+// configuration. taju.NewSyncer returns the Engine, e.g.
+//
+//	s, err := taju.NewSyncer(sync.Config{Profile: "club"})
+//	...
+//	result, err := s.Run(ctx, sync.Options{
+//		Activity: func(a sync.Activity) { ... },
+//		Decided:  func(d sync.Decision) { ... },
+//	})
+//
+// The engine itself is package taju: it shares the configuration, the
+// ledger and the transform pipeline with the taju commands, so only its
+// API is here. A program depends on this package and taju.NewSyncer alone,
+// and can test against its own Strava and Taji without either login.
+//
+// Next to the standard library's sync it is usually imported as tajusync.
+package sync
+
+import (
+	"context"
+	"time"
+)
+
+// Engine is the sync engine of one taju configuration. Runs of an Engine
+// take turns, like the cycles of the sync command; Engines of different
+// configurations run side by side.
+type Engine interface {
+	Run(ctx context.Context, options Options) (Result, error)
+}
+
+// Config is what an Engine is made of: the taju configuration (taju.env,
+// taju.yaml and Profile, "" for none) and the sites to sync. Without
+// Strava the engine reads the Strava accounts of the configuration, and
+// without Taji it logs in to Taji with its credentials; either way it
+// never prompts, so the accounts must have been authorized with taju auth.
+type Config struct {
+	Profile string
+	Strava  []Strava
+	Taji    Taji
+}
+
+// Options are the callbacks of one Run, all optional. They are called from
+// the cycle and the post workers, so they must be quick and safe for
+// concurrent use. DryRun decides without changing Taji.
+type Options struct {
+	DryRun   bool
+	Activity func(Activity)
+	Decided  func(Decision)
+	Posted   func(a Activity, err error)
+	Error    func(error)
+}
+
+// Activity is a Strava activity, or a part of one split across days, as
+// it is logged on Taji. Type is the Taji activity, Start in the activity's
+// timezone, Distance and Elevation are in meters.
+//
+// The rest is what a Strava source can tell of an activity for the
+// activity filters (TAJU_SKIP_PRIVATE, TAJU_REQUIRE_TAG...) and the
+// duplicate rule (TAJU_STRAVA_DUPLICATES=device:...), all optional. The
+// activities the engine hands back leave it empty.
+type Activity struct {
+	StravaID  int64
+	Part      int
+	Start     time.Time
+	Type      string
+	Distance  float64
+	Duration  time.Duration
+	Elevation float64
+
+	Name        string
+	Description string
+	Private     bool
+	Commute     bool
+	Race        bool
+	Gear        string
+	Device      string
+}
+
+// Decision is what a Run decided for an activity, or the outcome of an
+// action: Decision is post, update, delete or skip, Result what came of it
+// ("posted", "dry run", "cancelled"...).
+type Decision struct {
+	Decision string
+	Activity Activity
+	Result   string
+	Err      error
+}
+
+// Result sums up a Run: the activities fetched from Strava and those
+// posted to Taji. Partial is set when some activities couldn't be fetched,
+// Failed when a change to Taji failed; both come up again in the next Run.
+type Result struct {
+	Activities []Activity
+	Posted     []Activity
+	Partial    bool
+	Failed     bool
+}
+
+// Strava is a source of activities. Activities returns those to sync; the
+// engine gives them the intake of the activities of a Strava account: it
+// leaves out those the filters skip, those recorded twice and those
+// outside the event window, and splits them per the configuration (Part is
+// ignored). partial means some couldn't be read, so entries without one of
+// them on Taji are left alone.
+type Strava interface {
+	Activities(ctx context.Context) (activities []Activity, partial bool, err error)
+}
+
+// Taji is the log activities are synced to. An Entry is an activity as it
+// was posted, with the StravaID and Part it was posted with, so the engine
+// recognizes its own entries; the others it matches by date and values.
+type Taji interface {
+	Entries(ctx context.Context) ([]Entry, error)
+	Post(ctx context.Context, activity Activity) (id string, err error)
+	Update(ctx context.Context, id string, activity Activity) error
+	Delete(ctx context.Context, id string) error
+}
+
+// Entry is an entry of a Taji log.
+type Entry struct {
+	ID       string
+	Activity Activity
+}
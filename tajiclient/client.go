@@ -0,0 +1,427 @@
+// Package tajiclient reads and writes the activity log on taji100.com. The
+// site has no API, so pages are scraped and forms posted the way a browser
+// does. It knows nothing about where the login comes from: the caller
+// logs in with Login or hands it a saved Session, and says how to log in
+// again once the session expires.
+package tajiclient
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const BASE_URL string = "https://taji100.com"
+const LOGIN_PATH string = "/account/login/"
+
+// ErrSessionExpired is returned when Taji still sends a page to the login
+// form after logging in again.
+var ErrSessionExpired = errors.New("taji session expired and logging in again didn't help, run: taju auth taji")
+
+// Session is a logged in Taji session: its cookies and the participant it
+// belongs to.
+type Session struct {
+	CSRF        string
+	Id          string
+	Participant string
+}
+
+// Client is one Taji login.
+type Client struct {
+	// HTTP sends the requests, its Jar holds the session cookies.
+	HTTP *http.Client
+	// Base is BASE_URL, or a stand-in for it.
+	Base string
+	// Relogin, if set, logs in again when Get finds the session expired,
+	// usually by calling Login. Without it an expired session is
+	// ErrSessionExpired.
+	Relogin func(ctx context.Context) error
+	// MaxBodyLog caps the page text quoted in errors, 0 for no limit.
+	MaxBodyLog int
+	// Redact, if set, is applied to the page text quoted in errors.
+	Redact func(string) string
+	// Artifact, if set, is handed pages the client couldn't use.
+	Artifact func(name string, body []byte)
+	// Page, if set, is handed every page read, by name ("participant",
+	// "entry-edit", "log/new"...), e.g. to notice the site changed.
+	Page func(name string, body []byte)
+
+	mu      sync.Mutex
+	session Session
+	// login_mu makes fetches that all found the same session expired log
+	// in only once.
+	login_mu sync.Mutex
+}
+
+// URL is the address of a page of the site.
+func (c *Client) URL(path string) string {
+	if c.Base == "" {
+		return BASE_URL + path
+	}
+	return c.Base + path
+}
+
+func (c *Client) Session() Session {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.session
+}
+
+// SetSession uses a saved session instead of logging in.
+func (c *Client) SetSession(session Session) {
+	c.mu.Lock()
+	c.session = session
+	c.mu.Unlock()
+	if c.HTTP.Jar == nil {
+		return
+	}
+	base, err := url.Parse(c.URL("/"))
+	if err != nil {
+		return
+	}
+	c.HTTP.Jar.SetCookies(base, []*http.Cookie{{Name: "csrftoken", Value: session.CSRF}, {Name: "sessionid", Value: session.Id}})
+}
+
+// Login logs in with the account's email and password and keeps the new
+// session.
+func (c *Client) Login(ctx context.Context, username string, password string) (Session, error) {
+	body, err := c.read(ctx, LOGIN_PATH)
+	if err != nil {
+		return Session{}, err
+	}
+	csrfmiddlewaretoken, err := ParseCsrfToken(body)
+	if err != nil {
+		c.artifact("login.html", body)
+		return Session{}, err
+	}
+
+	values := url.Values{}
+	values.Add("csrfmiddlewaretoken", csrfmiddlewaretoken)
+	values.Add("email", username)
+	values.Add("password", password)
+	res, err := c.PostForm(ctx, LOGIN_PATH, values)
+	if err != nil {
+		return Session{}, err
+	}
+	res.Body.Close()
+
+	var session Session
+	if c.HTTP.Jar != nil {
+		for _, cookie := range c.HTTP.Jar.Cookies(res.Request.URL) {
+			switch cookie.Name {
+			case "csrftoken":
+				session.CSRF = cookie.Value
+			case "sessionid":
+				session.Id = cookie.Value
+			}
+		}
+	}
+
+	body, err = c.read(ctx, "/")
+	if err != nil {
+		return Session{}, err
+	}
+	session.Participant, err = ParseParticipantId(body)
+	if err != nil {
+		c.artifact("main.html", body)
+		return Session{}, err
+	}
+	c.mu.Lock()
+	c.session = session
+	c.mu.Unlock()
+	return session, nil
+}
+
+// read loads a page without checking the session, for the login.
+func (c *Client) read(ctx context.Context, path string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.URL(path), nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	return io.ReadAll(res.Body)
+}
+
+// LoginRedirect reports whether a response ended on the login page, which
+// is where Taji redirects any request once the sessionid cookie expired.
+// Parsing that page as the requested one would find no entries.
+func LoginRedirect(res *http.Response) bool {
+	return res.Request != nil && strings.Contains(res.Request.URL.Path, "/account/login")
+}
+
+// Get loads a page, logging in again once if the session expired. Only
+// GETs go through it: replaying a POST after a silent re-login could submit
+// a form twice.
+func (c *Client) Get(ctx context.Context, path string) (*http.Response, error) {
+	get := func() (*http.Response, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.URL(path), nil)
+		if err != nil {
+			return nil, err
+		}
+		return c.HTTP.Do(req)
+	}
+	session := c.Session()
+	res, err := get()
+	if err != nil || !LoginRedirect(res) {
+		return res, err
+	}
+	io.Copy(io.Discard, res.Body)
+	res.Body.Close()
+
+	if err := c.relogin(ctx, session); err != nil {
+		return nil, err
+	}
+	res, err = get()
+	if err != nil {
+		return nil, err
+	}
+	if LoginRedirect(res) {
+		res.Body.Close()
+		return nil, ErrSessionExpired
+	}
+	return res, nil
+}
+
+// relogin replaces an expired session, unless a concurrent fetch already
+// did.
+func (c *Client) relogin(ctx context.Context, expired Session) error {
+	c.login_mu.Lock()
+	defer c.login_mu.Unlock()
+	if c.Session() != expired {
+		return nil
+	}
+	if c.Relogin == nil {
+		return ErrSessionExpired
+	}
+	return c.Relogin(ctx)
+}
+
+// Read loads a page with Get and returns its body, what names the page in
+// errors.
+func (c *Client) Read(ctx context.Context, path string, what string) ([]byte, error) {
+	res, err := c.Get(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 400 {
+		return nil, fmt.Errorf("%s returned %s", what, res.Status)
+	}
+	return io.ReadAll(res.Body)
+}
+
+// ParticipantPage reads the page of the logged in participant, which lists
+// their entries. A page cut short is an error.
+func (c *Client) ParticipantPage(ctx context.Context) ([]byte, error) {
+	body, err := c.Read(ctx, fmt.Sprintf("/participants/%s/", c.Session().Participant), "participant page")
+	if err != nil {
+		return nil, err
+	}
+	c.page("participant", body)
+	if !PageComplete(body) {
+		return nil, fmt.Errorf("participant page was cut short after %d bytes", len(body))
+	}
+	return body, nil
+}
+
+// Entry reads an entry's values from its edit form.
+func (c *Client) Entry(ctx context.Context, id string) (Entry, error) {
+	body, err := c.Read(ctx, fmt.Sprintf("/log/%s/edit", id), "entry "+id)
+	if err != nil {
+		return Entry{}, err
+	}
+	c.page("entry-edit", body)
+	entry, err := ParseEntryForm(body, id)
+	if err != nil {
+		c.artifact("entry-"+id+".html", body)
+		return Entry{}, fmt.Errorf("entry %s: %w", id, err)
+	}
+	return entry, nil
+}
+
+// Form loads a form page and returns it with the hidden CSRF token that
+// has to be posted back with the form.
+func (c *Client) Form(ctx context.Context, path string) ([]byte, string, error) {
+	res, err := c.Get(ctx, path)
+	if err != nil {
+		return nil, "", err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if u, err := url.Parse(path); err == nil {
+		name := strings.Trim(u.Path, "/")
+		if ENTRY_HREF_PATTERN.MatchString(u.Path) {
+			name = "entry-edit"
+		}
+		c.page(name, body)
+	}
+	token, err := ParseCsrfToken(body)
+	if err != nil {
+		c.artifact("csrf-missing.html", body)
+		return nil, "", fmt.Errorf("%s: %w", c.URL(path), err)
+	}
+	return body, token, nil
+}
+
+// PostForm submits form values the same way the browser does, including
+// the Referer header Django checks for CSRF.
+func (c *Client) PostForm(ctx context.Context, path string, values url.Values) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.URL(path), strings.NewReader(values.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Add("Referer", c.URL(path))
+	return c.HTTP.Do(req)
+}
+
+// PostMultipart submits form values with a file attached, for forms that
+// take an upload.
+func (c *Client) PostMultipart(ctx context.Context, path string, values url.Values, field string, filename string, data []byte) (*http.Response, error) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for key, list := range values {
+		for _, value := range list {
+			writer.WriteField(key, value)
+		}
+	}
+	part, err := writer.CreateFormFile(field, filename)
+	if err != nil {
+		return nil, err
+	}
+	part.Write(data)
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.URL(path), &body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Content-Type", writer.FormDataContentType())
+	req.Header.Add("Referer", c.URL(path))
+	return c.HTTP.Do(req)
+}
+
+// Update overwrites an entry with values by posting its edit form.
+func (c *Client) Update(ctx context.Context, id string, values url.Values) error {
+	edit_path := fmt.Sprintf("/log/%s/edit", id)
+	_, csrfmiddlewaretoken, err := c.Form(ctx, edit_path)
+	if err != nil {
+		return err
+	}
+	values = cloneValues(values)
+	values.Set("csrfmiddlewaretoken", csrfmiddlewaretoken)
+
+	res, err := c.PostForm(ctx, edit_path, values)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	return c.CheckForm(res, "updating entry "+id)
+}
+
+// Delete removes an entry through the delete route of its edit page.
+func (c *Client) Delete(ctx context.Context, id string) error {
+	_, csrfmiddlewaretoken, err := c.Form(ctx, fmt.Sprintf("/log/%s/edit", id))
+	if err != nil {
+		return err
+	}
+	values := url.Values{}
+	values.Add("csrfmiddlewaretoken", csrfmiddlewaretoken)
+
+	res, err := c.PostForm(ctx, fmt.Sprintf("/log/%s/delete", id), values)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	return c.CheckForm(res, "deleting entry "+id)
+}
+
+func cloneValues(values url.Values) url.Values {
+	clone := make(url.Values, len(values))
+	for key, list := range values {
+		clone[key] = append([]string(nil), list...)
+	}
+	return clone
+}
+
+// CheckForm turns a rejected form POST into an error carrying Taji's own
+// error messages. Django answers an invalid form with 200 and the form
+// rendered again, so the body is checked even when the status looks fine.
+func (c *Client) CheckForm(res *http.Response, what string) error {
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return fmt.Errorf("%s: %w", what, err)
+	}
+	if LoginRedirect(res) {
+		// The form was loaded with a valid session, so this is rare; the
+		// next cycle logs in again before it reads anything.
+		return fmt.Errorf("%s: the Taji session expired while posting", what)
+	}
+	if messages := ParseFormErrors(body); len(messages) > 0 {
+		c.artifact("form-error.html", body)
+		return fmt.Errorf("%s was rejected by Taji: %s", what, strings.Join(messages, "; "))
+	}
+	if res.StatusCode >= 400 {
+		c.artifact("form-error.html", body)
+		excerpt := BodyExcerpt(body, c.MaxBodyLog)
+		if c.Redact != nil {
+			excerpt = c.Redact(excerpt)
+		}
+		return fmt.Errorf("%s failed: %s: %s", what, res.Status, excerpt)
+	}
+	return nil
+}
+
+// LOG_ENTRY_PATH matches the pages of one Taji entry, /log/<id>/ and
+// /log/<id>/edit.
+var LOG_ENTRY_PATH = regexp.MustCompile(`/log/(\d+)(/|$)`)
+
+// CreatedLogId reads the outcome of a successful log form POST from where
+// Taji redirected to. Django redirects after a saved form, so ending up on
+// the form again means the entry wasn't saved even without an error
+// message. The new entry's page carries its log id; other pages (the
+// participant page) don't, and the id is found on the next scrape.
+func CreatedLogId(res *http.Response, what string) (string, error) {
+	if res.Request == nil {
+		return "", nil
+	}
+	path := res.Request.URL.Path
+	if strings.HasSuffix(strings.TrimSuffix(path, "/"), "/log/new") {
+		return "", fmt.Errorf("%s was not saved, Taji showed the log form again", what)
+	}
+	if match := LOG_ENTRY_PATH.FindStringSubmatch(path); match != nil {
+		return match[1], nil
+	}
+	return "", nil
+}
+
+func (c *Client) artifact(name string, body []byte) {
+	if c.Artifact != nil {
+		c.Artifact(name, body)
+	}
+}
+
+func (c *Client) page(name string, body []byte) {
+	if c.Page != nil {
+		c.Page(name, body)
+	}
+}
@@ -0,0 +1,177 @@
+package tajiclient
+
+import (
+	"context"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// testClient is a logged in client whose site is server.
+func testClient(server *httptest.Server) *Client {
+	c := &Client{HTTP: &http.Client{}, Base: server.URL, MaxBodyLog: 200}
+	c.SetSession(Session{Id: "session"})
+	return c
+}
+
+func TestUpdate(t *testing.T) {
+	values := url.Values{"activity": {"run"}, "date": {"2026-02-01"}, "time": {"07:15 AM"}, "distance": {"3.20"}, "notes": {"taju:0123456789ab"}}
+	tests := []struct {
+		name   string
+		form   string
+		post   func(w http.ResponseWriter, r *http.Request)
+		want   string
+		posted bool
+	}{
+		{
+			name: "saved",
+			form: "entry-edit.html",
+			post: func(w http.ResponseWriter, r *http.Request) {
+				http.Redirect(w, r, "/log/901/", http.StatusFound)
+			},
+			posted: true,
+		},
+		{
+			name: "rejected by the form",
+			form: "entry-edit.html",
+			post: func(w http.ResponseWriter, r *http.Request) {
+				w.Write(fixture(t, "entry-edit-rejected.html"))
+			},
+			want:   "updating entry 901 was rejected by Taji: Ensure this value is greater than or equal to 0.01.",
+			posted: true,
+		},
+		{
+			name: "session expired while posting",
+			form: "entry-edit.html",
+			post: func(w http.ResponseWriter, r *http.Request) {
+				http.Redirect(w, r, "/account/login/?next=/log/901/edit", http.StatusFound)
+			},
+			want:   "updating entry 901: the Taji session expired while posting",
+			posted: true,
+		},
+		{
+			name: "server error",
+			form: "entry-edit.html",
+			post: func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, "Server Error (500)", http.StatusInternalServerError)
+			},
+			want:   "updating entry 901 failed: 500 Internal Server Error: Server Error (500)",
+			posted: true,
+		},
+		{
+			name: "form without a CSRF token",
+			form: "login-failed.html",
+			want: "csrfmiddlewaretoken",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var posted url.Values
+			var server *httptest.Server
+			mux := http.NewServeMux()
+			mux.HandleFunc("GET /log/901/edit", func(w http.ResponseWriter, r *http.Request) {
+				w.Write(fixture(t, test.form))
+			})
+			mux.HandleFunc("POST /log/901/edit", func(w http.ResponseWriter, r *http.Request) {
+				if r.Referer() != server.URL+"/log/901/edit" {
+					t.Errorf("Referer = %q", r.Referer())
+				}
+				r.ParseForm()
+				posted = r.PostForm
+				test.post(w, r)
+			})
+			mux.HandleFunc("GET /log/901/", func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("<html><body>Entry</body></html>"))
+			})
+			mux.HandleFunc("GET /account/login/", func(w http.ResponseWriter, r *http.Request) {
+				w.Write(fixture(t, "login-failed.html"))
+			})
+			server = httptest.NewServer(mux)
+			defer server.Close()
+
+			err := testClient(server).Update(context.Background(), "901", values)
+			if test.want == "" && err != nil {
+				t.Fatal(err)
+			}
+			if test.want != "" && (err == nil || !strings.Contains(err.Error(), test.want)) {
+				t.Errorf("Update() error = %v, want %q", err, test.want)
+			}
+			if (posted != nil) != test.posted {
+				t.Fatalf("posted %v, want a post %t", posted, test.posted)
+			}
+			if !test.posted {
+				return
+			}
+			if got := posted.Get("csrfmiddlewaretoken"); got != "edit-token" {
+				t.Errorf("posted csrfmiddlewaretoken = %q, want the form's", got)
+			}
+			for name := range values {
+				if posted.Get(name) != values.Get(name) {
+					t.Errorf("posted %s = %q, want %q", name, posted.Get(name), values.Get(name))
+				}
+			}
+		})
+	}
+}
+
+func TestGetRelogin(t *testing.T) {
+	tests := []struct {
+		name    string
+		relogin bool
+		renewed bool
+		logins  int
+		want    error
+	}{
+		{name: "logs in again", relogin: true, renewed: true, logins: 1},
+		{name: "login doesn't help", relogin: true, logins: 1, want: ErrSessionExpired},
+		{name: "no relogin", want: ErrSessionExpired},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mux := http.NewServeMux()
+			mux.HandleFunc("GET /participants/7/", func(w http.ResponseWriter, r *http.Request) {
+				if cookie, err := r.Cookie("sessionid"); err != nil || cookie.Value != "renewed" {
+					http.Redirect(w, r, "/account/login/?next=/participants/7/", http.StatusFound)
+					return
+				}
+				w.Write(fixture(t, "participant.html"))
+			})
+			mux.HandleFunc("GET /account/login/", func(w http.ResponseWriter, r *http.Request) {
+				w.Write(fixture(t, "login-failed.html"))
+			})
+			server := httptest.NewServer(mux)
+			defer server.Close()
+
+			jar, err := cookiejar.New(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c := testClient(server)
+			c.HTTP.Jar = jar
+			c.SetSession(Session{Id: "expired", Participant: "7"})
+			logins := 0
+			if test.relogin {
+				c.Relogin = func(ctx context.Context) error {
+					logins++
+					if test.renewed {
+						c.SetSession(Session{Id: "renewed", Participant: "7"})
+					}
+					return nil
+				}
+			}
+			body, err := c.ParticipantPage(context.Background())
+			if err != test.want {
+				t.Fatalf("ParticipantPage() error = %v, want %v", err, test.want)
+			}
+			if test.want == nil && len(ParseLogEntries(body)) == 0 {
+				t.Error("ParticipantPage() read no entries after logging in again")
+			}
+			if logins != test.logins {
+				t.Errorf("logged in %d times, want %d", logins, test.logins)
+			}
+		})
+	}
+}
@@ -0,0 +1,157 @@
+package tajiclient
+
+import (
+	"bytes"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// The Taji pages are parsed with golang.org/x/net/html, the HTML5 parsing
+// algorithm browsers use, so changes in quote style, attribute order, extra
+// classes, whitespace or unclosed tags don't break the uploader. Parsers
+// return an error naming the element they couldn't find instead of
+// panicking on a missing match.
+
+// Element is an element of a parsed page.
+type Element struct {
+	Tag   string
+	Attrs map[string]string
+	// Text is the element's text content, nested tags stripped and
+	// surrounding whitespace trimmed.
+	Text string
+	node *html.Node
+}
+
+func (e Element) Attr(name string) string {
+	return e.Attrs[name]
+}
+
+func (e Element) Has(name string) bool {
+	_, ok := e.Attrs[name]
+	return ok
+}
+
+// Find returns the elements with the given tag inside the element.
+func (e Element) Find(tag string) []Element {
+	if e.node == nil {
+		return nil
+	}
+	return collectElements(e.node, tag)
+}
+
+// ParsePage parses a page, or a piece of one, into its document tree. The
+// parser recovers from any markup the way browsers do, so it never fails on
+// a body read into memory.
+func ParsePage(body []byte) *html.Node {
+	doc, err := html.Parse(bytes.NewReader(body))
+	if err != nil {
+		return &html.Node{Type: html.DocumentNode}
+	}
+	return doc
+}
+
+// WalkElements calls fn on every element below node in document order,
+// skipping the children of those it returns false for.
+func WalkElements(node *html.Node, fn func(*html.Node) bool) {
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type == html.ElementNode && !fn(child) {
+			continue
+		}
+		WalkElements(child, fn)
+	}
+}
+
+func collectElements(root *html.Node, tag string) (elements []Element) {
+	WalkElements(root, func(node *html.Node) bool {
+		if node.Data == tag {
+			elements = append(elements, newElement(node))
+		}
+		return true
+	})
+	return
+}
+
+func newElement(node *html.Node) Element {
+	element := Element{Tag: node.Data, Attrs: make(map[string]string), node: node}
+	for _, attr := range node.Attr {
+		if _, ok := element.Attrs[attr.Key]; !ok {
+			element.Attrs[attr.Key] = attr.Val
+		}
+	}
+	element.Text = strings.TrimSpace(nodeText(node))
+	return element
+}
+
+// nodeText is the text content of a node, without that of scripts and styles.
+func nodeText(node *html.Node) string {
+	var text strings.Builder
+	var collect func(*html.Node)
+	collect = func(n *html.Node) {
+		switch {
+		case n.Type == html.TextNode:
+			text.WriteString(n.Data)
+		case n.Type == html.ElementNode && (n.DataAtom == atom.Script || n.DataAtom == atom.Style):
+			return
+		case n.Type == html.ElementNode && text.Len() > 0 && isBlock(n.DataAtom):
+			text.WriteByte(' ')
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			collect(child)
+		}
+	}
+	collect(node)
+	return text.String()
+}
+
+// isBlock reports the elements whose text reads as separate from what
+// comes before, so "<li>a</li><li>b</li>" reads "a b", not "ab".
+func isBlock(tag atom.Atom) bool {
+	switch tag {
+	case atom.P, atom.Div, atom.Li, atom.Br, atom.Td, atom.Th, atom.Tr,
+		atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6, atom.Option:
+		return true
+	}
+	return false
+}
+
+// FindElements returns every element with the given tag. For elements with
+// content (a, textarea) Text holds the unescaped text, with nested tags
+// stripped.
+func FindElements(body []byte, tag string) []Element {
+	return collectElements(ParsePage(body), tag)
+}
+
+// PageText is the visible text of a page.
+func PageText(body []byte) string {
+	doc := ParsePage(body)
+	var text strings.Builder
+	WalkElements(doc, func(node *html.Node) bool {
+		if node.DataAtom == atom.Head {
+			return false
+		}
+		if node.DataAtom == atom.Body {
+			text.WriteString(nodeText(node))
+			return false
+		}
+		return true
+	})
+	return text.String()
+}
+
+// FindInput returns the first input (or textarea) with the given name.
+func FindInput(body []byte, name string) (Element, bool) {
+	return inputNamed(ParsePage(body), name)
+}
+
+func inputNamed(doc *html.Node, name string) (Element, bool) {
+	for _, tag := range []string{"input", "textarea", "select"} {
+		for _, element := range collectElements(doc, tag) {
+			if element.Attr("name") == name {
+				return element, true
+			}
+		}
+	}
+	return Element{}, false
+}
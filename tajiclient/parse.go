@@ -0,0 +1,233 @@
+package tajiclient
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var DATE_PATTERN = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+
+func ParseCsrfToken(body []byte) (string, error) {
+	input, ok := FindInput(body, "csrfmiddlewaretoken")
+	if !ok || input.Attr("value") == "" {
+		return "", fmt.Errorf("taji page has no csrfmiddlewaretoken input")
+	}
+	return input.Attr("value"), nil
+}
+
+var PARTICIPANT_HREF_PATTERN = regexp.MustCompile(`^(?:https?://[^/]+)?/participants/([^/]+)/?$`)
+
+// ParseParticipantId finds the participant id in the "My Page" link of the
+// navigation bar, or the first participant link if the label changed.
+func ParseParticipantId(body []byte) (string, error) {
+	var fallback string
+	for _, a := range FindElements(body, "a") {
+		match := PARTICIPANT_HREF_PATTERN.FindStringSubmatch(a.Attr("href"))
+		if match == nil {
+			continue
+		}
+		if strings.EqualFold(a.Text, "My Page") {
+			return match[1], nil
+		}
+		if fallback == "" {
+			fallback = match[1]
+		}
+	}
+	if fallback == "" {
+		return "", fmt.Errorf("taji page has no participant link, is the login correct?")
+	}
+	return fallback, nil
+}
+
+var ENTRY_HREF_PATTERN = regexp.MustCompile(`^(?:https?://[^/]+)?/log/([^/]+)/edit/?$`)
+
+// ParseLogEntries returns the ids of the entries on a participant page, in
+// page order and without duplicates.
+func ParseLogEntries(body []byte) (entries []string) {
+	seen := make(map[string]bool)
+	for _, a := range FindElements(body, "a") {
+		match := ENTRY_HREF_PATTERN.FindStringSubmatch(a.Attr("href"))
+		if match != nil && !seen[match[1]] {
+			seen[match[1]] = true
+			entries = append(entries, match[1])
+		}
+	}
+	return
+}
+
+// ParseLogRows returns a digest of the row each entry is listed in on a
+// participant page, keyed by log id. The row shows some of the entry's
+// values, so a new digest means the entry was edited on the site.
+func ParseLogRows(body []byte) map[string]string {
+	rows := make(map[string]string)
+	for _, tr := range FindElements(body, "tr") {
+		for _, a := range tr.Find("a") {
+			match := ENTRY_HREF_PATTERN.FindStringSubmatch(a.Attr("href"))
+			if match == nil {
+				continue
+			}
+			if _, seen := rows[match[1]]; !seen {
+				sum := sha256.Sum256([]byte(strings.Join(strings.Fields(tr.Text), " ")))
+				rows[match[1]] = hex.EncodeToString(sum[:8])
+			}
+			break
+		}
+	}
+	return rows
+}
+
+// PageComplete reports whether a page was read to its end. A participant
+// page cut short would make the entries after the cut look deleted.
+func PageComplete(body []byte) bool {
+	return bytes.Contains(bytes.ToLower(body), []byte("</html>"))
+}
+
+// Entry is a logged entry as its edit form shows it. Activity and
+// Elevation are empty when the form doesn't have them.
+type Entry struct {
+	Id        string
+	Date      string
+	Time      string
+	Distance  string
+	Duration  string
+	Elevation string
+	Activity  string
+	Notes     string
+}
+
+// ParseEntryForm reads an entry's values back from its edit form. The date is
+// the checked date radio button.
+func ParseEntryForm(body []byte, id string) (entry Entry, err error) {
+	entry.Id = id
+	doc := ParsePage(body)
+	for _, input := range collectElements(doc, "input") {
+		if input.Has("checked") && (input.Attr("name") == "date" || DATE_PATTERN.MatchString(input.Attr("value"))) {
+			entry.Date = input.Attr("value")
+			break
+		}
+	}
+	if entry.Date == "" {
+		return entry, fmt.Errorf("edit form of entry %s has no checked date", id)
+	}
+
+	time_input, ok := inputNamed(doc, "time")
+	if !ok {
+		return entry, fmt.Errorf("edit form of entry %s has no time input", id)
+	}
+	entry.Time = time_input.Attr("value")
+
+	if distance, ok := inputNamed(doc, "distance"); ok {
+		entry.Distance = distance.Attr("value")
+	}
+	if duration, ok := inputNamed(doc, "duration"); ok {
+		entry.Duration = duration.Attr("value")
+	}
+	if elevation, ok := inputNamed(doc, "elevation_gain"); ok {
+		entry.Elevation = elevation.Attr("value")
+	}
+	entry.Activity = ParseChoice(body, "activity")
+	if notes, ok := inputNamed(doc, "notes"); ok {
+		entry.Notes = notes.Text + notes.Attr("value")
+	}
+	return entry, nil
+}
+
+// ParseChoice returns the value picked in a radio group or select, "" if
+// none is.
+func ParseChoice(body []byte, name string) string {
+	for _, input := range FindElements(body, "input") {
+		if input.Attr("name") == name && input.Has("checked") {
+			return input.Attr("value")
+		}
+	}
+	for _, option := range selectElements(body, name) {
+		if option.Has("selected") {
+			return optionValue(option)
+		}
+	}
+	return ""
+}
+
+// SelectOptions returns the values a select offers.
+func SelectOptions(body []byte, name string) (values []string) {
+	for _, option := range selectElements(body, name) {
+		values = append(values, optionValue(option))
+	}
+	return
+}
+
+// selectElements returns the options of the first select with the name.
+func selectElements(body []byte, name string) []Element {
+	for _, element := range FindElements(body, "select") {
+		if element.Attr("name") == name {
+			return element.Find("option")
+		}
+	}
+	return nil
+}
+
+func optionValue(option Element) string {
+	if option.Has("value") {
+		return option.Attr("value")
+	}
+	return option.Text
+}
+
+// ParseFileField returns the name of the form's file input, if it has one.
+func ParseFileField(body []byte) (string, bool) {
+	for _, input := range FindElements(body, "input") {
+		if strings.EqualFold(input.Attr("type"), "file") && input.Attr("name") != "" {
+			return input.Attr("name"), true
+		}
+	}
+	return "", false
+}
+
+// Django renders form errors as <ul class="errorlist"><li>...</li></ul>;
+// the site theme may also use alert or invalid-feedback blocks.
+var FORM_ERROR_CLASSES = []string{"errorlist", "alert-danger", "invalid-feedback"}
+
+var WHITESPACE_PATTERN = regexp.MustCompile(`\s+`)
+
+// ParseFormErrors returns the human readable errors of a re-rendered form.
+func ParseFormErrors(body []byte) (errors []string) {
+	seen := make(map[string]bool)
+	for _, tag := range []string{"ul", "div", "span", "p"} {
+		for _, element := range FindElements(body, tag) {
+			if !hasClass(element, FORM_ERROR_CLASSES...) {
+				continue
+			}
+			text := WHITESPACE_PATTERN.ReplaceAllString(element.Text, " ")
+			if text != "" && !seen[text] {
+				seen[text] = true
+				errors = append(errors, text)
+			}
+		}
+	}
+	return
+}
+
+func hasClass(element Element, classes ...string) bool {
+	for _, class := range strings.Fields(element.Attr("class")) {
+		for _, wanted := range classes {
+			if class == wanted {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// BodyExcerpt returns the visible text of a page, cut to max bytes, for
+// errors that don't come with a recognizable error element.
+func BodyExcerpt(body []byte, max int) string {
+	text := strings.TrimSpace(WHITESPACE_PATTERN.ReplaceAllString(PageText(body), " "))
+	if max > 0 && len(text) > max {
+		text = text[:max] + "..."
+	}
+	return text
+}
@@ -0,0 +1,190 @@
+package tajiclient
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// fixture reads a saved Taji page from testdata.
+func fixture(t *testing.T, name string) []byte {
+	t.Helper()
+	body, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+func TestParseCsrfToken(t *testing.T) {
+	tests := []struct {
+		page    string
+		want    string
+		wantErr bool
+	}{
+		{page: "participant.html", want: "tok&en-123"},
+		{page: "entry-edit.html", want: "edit-token"},
+		{page: "entry-edit-unquoted.html", want: "abc"},
+		{page: "login-failed.html", wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.page, func(t *testing.T) {
+			got, err := ParseCsrfToken(fixture(t, test.page))
+			if (err != nil) != test.wantErr {
+				t.Fatalf("ParseCsrfToken() error = %v, wantErr %v", err, test.wantErr)
+			}
+			if got != test.want {
+				t.Errorf("ParseCsrfToken() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseParticipantId(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    []byte
+		want    string
+		wantErr bool
+	}{
+		{name: "my page link", body: fixture(t, "participant.html"), want: "4242"},
+		{name: "link in script ignored", body: []byte(`<script>x = "<a href='/participants/1/'>My Page</a>"</script><a href="/participants/2/">Profile</a>`), want: "2"},
+		{name: "relabeled link falls back", body: []byte(`<a href="/participants/7/">Jane</a><a href="/participants/8/">Joe</a>`), want: "7"},
+		{name: "absolute link", body: []byte(`<a href="https://taji100.com/participants/9">My  Page</a>`), want: "9"},
+		{name: "failed login", body: fixture(t, "login-failed.html"), wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ParseParticipantId(test.body)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("ParseParticipantId() error = %v, wantErr %v", err, test.wantErr)
+			}
+			if got != test.want {
+				t.Errorf("ParseParticipantId() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseLogEntries(t *testing.T) {
+	tests := []struct {
+		name string
+		body []byte
+		want []string
+	}{
+		{name: "participant page", body: fixture(t, "participant.html"), want: []string{"901", "902"}},
+		{name: "no entries", body: fixture(t, "login-failed.html")},
+		{name: "unclosed tags", body: []byte(`<table><tr><td><a href=/log/5/edit/>Edit<tr><td><a href='/log/6/edit'>Edit`), want: []string{"5", "6"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := ParseLogEntries(test.body); !slices.Equal(got, test.want) {
+				t.Errorf("ParseLogEntries() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseLogRows(t *testing.T) {
+	page := fixture(t, "participant.html")
+	rows := ParseLogRows(page)
+	if len(rows) != 2 || rows["901"] == "" || rows["901"] == rows["902"] {
+		t.Fatalf("ParseLogRows() = %q, want a digest for each of 901 and 902", rows)
+	}
+	reformatted := ParseLogRows(bytes.ReplaceAll(page, []byte("<td>3.10</td>"), []byte("<td>\n  3.10 </td>")))
+	if reformatted["901"] != rows["901"] {
+		t.Error("whitespace in a row changed its digest")
+	}
+	edited := ParseLogRows(bytes.ReplaceAll(page, []byte("<td>3.10</td>"), []byte("<td>4.00</td>")))
+	if edited["901"] == rows["901"] || edited["902"] != rows["902"] {
+		t.Errorf("editing entry 901 changed the digests from %q to %q", rows, edited)
+	}
+	if !PageComplete(page) || PageComplete(page[:len(page)/2]) {
+		t.Error("PageComplete() doesn't tell a cut short page")
+	}
+}
+
+func TestParseEntryForm(t *testing.T) {
+	tests := []struct {
+		page    string
+		want    Entry
+		wantErr string
+	}{
+		{
+			page: "entry-edit.html",
+			want: Entry{Id: "901", Date: "2026-02-01", Time: "07:15 AM", Distance: "3.10", Duration: "00:28:04",
+				Elevation: "42", Activity: "run", Notes: "Easy & slow\ntaju:0123456789ab"},
+		},
+		{
+			page: "entry-edit-unquoted.html",
+			want: Entry{Id: "901", Date: "2026-02-11", Time: "6:05 PM", Distance: "5", Duration: "1:02:03", Activity: "hike", Notes: "no key here"},
+		},
+		{page: "entry-edit-no-date.html", wantErr: "has no checked date"},
+		{page: "entry-edit-no-time.html", wantErr: "has no time input"},
+	}
+	for _, test := range tests {
+		t.Run(test.page, func(t *testing.T) {
+			got, err := ParseEntryForm(fixture(t, test.page), "901")
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("ParseEntryForm() error = %v, want one containing %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("ParseEntryForm() = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSelectOptions(t *testing.T) {
+	got := SelectOptions(fixture(t, "entry-edit.html"), "activity")
+	if want := []string{"run", "walk", "Ruck"}; !slices.Equal(got, want) {
+		t.Errorf("SelectOptions() = %q, want %q", got, want)
+	}
+	if got := SelectOptions(fixture(t, "entry-edit.html"), "missing"); got != nil {
+		t.Errorf("SelectOptions() of a missing select = %q, want none", got)
+	}
+}
+
+func TestParseFileField(t *testing.T) {
+	tests := []struct {
+		page   string
+		want   string
+		wantOk bool
+	}{
+		{page: "entry-edit.html", want: "photo", wantOk: true},
+		{page: "entry-edit-unquoted.html"},
+	}
+	for _, test := range tests {
+		t.Run(test.page, func(t *testing.T) {
+			got, ok := ParseFileField(fixture(t, test.page))
+			if got != test.want || ok != test.wantOk {
+				t.Errorf("ParseFileField() = %q, %v, want %q, %v", got, ok, test.want, test.wantOk)
+			}
+		})
+	}
+}
+
+func TestParseFormErrors(t *testing.T) {
+	got := ParseFormErrors(fixture(t, "login-failed.html"))
+	want := []string{"Please enter a correct username and password. Note that both fields may be case-sensitive."}
+	if !slices.Equal(got, want) {
+		t.Errorf("ParseFormErrors() = %q, want %q", got, want)
+	}
+}
+
+func TestBodyExcerpt(t *testing.T) {
+	got := BodyExcerpt([]byte(`<html><head><title>Oops</title><style>p {}</style></head><body><h1>Server Error</h1><p>Try  again
+	later.</p><script>alert(1)</script></body></html>`), 0)
+	if want := "Server Error Try again later."; got != want {
+		t.Errorf("BodyExcerpt() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,95 @@
+package tajiclient
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"sync/atomic"
+)
+
+// TransferStats counts what actually went over the wire, which matters to
+// people syncing from a phone hotspot.
+type TransferStats struct {
+	Requests      atomic.Int64
+	BytesSent     atomic.Int64
+	BytesReceived atomic.Int64
+	HTTP2         atomic.Int64
+	ReusedConns   atomic.Int64
+}
+
+// countingTransport asks for gzip explicitly and inflates responses itself,
+// so the received byte count is the compressed size on the wire.
+type countingTransport struct {
+	base  http.RoundTripper
+	stats *TransferStats
+}
+
+// NewTransport counts the traffic of base in stats.
+func NewTransport(base http.RoundTripper, stats *TransferStats) http.RoundTripper {
+	return &countingTransport{base: base, stats: stats}
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if info.Reused {
+				t.stats.ReusedConns.Add(1)
+			}
+		},
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+
+	t.stats.Requests.Add(1)
+	if req.ContentLength > 0 {
+		t.stats.BytesSent.Add(req.ContentLength)
+	}
+
+	res, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.ProtoMajor == 2 {
+		t.stats.HTTP2.Add(1)
+	}
+
+	res.Body = &countingReader{ReadCloser: res.Body, count: &t.stats.BytesReceived}
+	if strings.EqualFold(res.Header.Get("Content-Encoding"), "gzip") {
+		gz, err := gzip.NewReader(res.Body)
+		if err != nil {
+			res.Body.Close()
+			return nil, err
+		}
+		res.Body = &gzipBody{Reader: gz, wire: res.Body}
+		res.Header.Del("Content-Encoding")
+		res.Header.Del("Content-Length")
+		res.ContentLength = -1
+		res.Uncompressed = true
+	}
+	return res, nil
+}
+
+type countingReader struct {
+	io.ReadCloser
+	count *atomic.Int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.count.Add(int64(n))
+	return n, err
+}
+
+type gzipBody struct {
+	*gzip.Reader
+	wire io.ReadCloser
+}
+
+func (b *gzipBody) Close() error {
+	b.Reader.Close()
+	return b.wire.Close()
+}
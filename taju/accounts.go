@@ -0,0 +1,226 @@
+package taju
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+const DEFAULT_ACCOUNT string = "default"
+
+var ACCOUNT_NAME_PATTERN = regexp.MustCompile(`^[a-z0-9]+$`)
+
+// stravaTokenKey is the env file key holding an account's OAuth token. The
+// default account keeps the original STRAVA_TOKEN key.
+func stravaTokenKey(name string) string {
+	if name == DEFAULT_ACCOUNT {
+		return "STRAVA_TOKEN"
+	}
+	return "STRAVA_TOKEN_" + strings.ToUpper(name)
+}
+
+// stravaRefreshKey names a pre-supplied refresh token for an account, used
+// instead of the browser authorization on headless machines.
+func stravaRefreshKey(name string) string {
+	if name == DEFAULT_ACCOUNT {
+		return "STRAVA_REFRESH_TOKEN"
+	}
+	return "STRAVA_REFRESH_TOKEN_" + strings.ToUpper(name)
+}
+
+// stravaCursorKey is the env file key for an account's incremental fetch
+// cursor.
+func stravaCursorKey(name string) string {
+	if name == DEFAULT_ACCOUNT {
+		return "STRAVA_CURSOR"
+	}
+	return "STRAVA_CURSOR_" + strings.ToUpper(name)
+}
+
+// stravaFullFetchKey is the env file key for when an account's activities
+// were last fetched for the whole event window.
+func stravaFullFetchKey(name string) string {
+	if name == DEFAULT_ACCOUNT {
+		return "STRAVA_FULL_FETCH"
+	}
+	return "STRAVA_FULL_FETCH_" + strings.ToUpper(name)
+}
+
+func loadStravaCursor(env map[string]string, s *strava) {
+	for key, at := range map[string]*time.Time{stravaCursorKey(s.name): &s.cursor, stravaFullFetchKey(s.name): &s.full_fetch} {
+		value, ok := env[key]
+		if !ok {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			log.Printf("Ignoring invalid %s=%q", key, value)
+			continue
+		}
+		*at = parsed
+	}
+}
+
+// DEFAULT_FULL_FETCH_INTERVAL is how often a poll asks Strava for the whole
+// event window rather than what started after the cursor, see
+// loadFullFetchInterval.
+const DEFAULT_FULL_FETCH_INTERVAL = 24 * time.Hour
+
+// loadFullFetchInterval reads TAJU_FULL_FETCH_INTERVAL. Strava lists
+// activities by start date, so one uploaded long after it started (a watch
+// synced the next day, an activity added by hand) is before the cursor by
+// the time it shows up; the full fetch, whose time is kept in taju.env so
+// restarts and scheduled one-shot runs keep to it, picks it up. 0 fetches
+// the whole window every time.
+func loadFullFetchInterval(env map[string]string) (time.Duration, error) {
+	value, ok := env["TAJU_FULL_FETCH_INTERVAL"]
+	if !ok {
+		return DEFAULT_FULL_FETCH_INTERVAL, nil
+	}
+	interval, err := time.ParseDuration(value)
+	if err != nil || interval < 0 {
+		return 0, fmt.Errorf("invalid TAJU_FULL_FETCH_INTERVAL=%q, expected a duration like 24h", value)
+	}
+	return interval, nil
+}
+
+func saveStravaCursors(u *uploader) {
+	changed := false
+	for _, s := range u.accounts {
+		if s.cursor.IsZero() {
+			continue
+		}
+		// A backfill of an older range must not move the cursor back.
+		if stored, err := time.Parse(time.RFC3339, u.env[stravaCursorKey(s.name)]); err == nil && !s.cursor.After(stored) {
+			continue
+		}
+		value := s.cursor.Format(time.RFC3339)
+		if u.env[stravaCursorKey(s.name)] != value {
+			u.env[stravaCursorKey(s.name)] = value
+			changed = true
+		}
+	}
+	for _, s := range u.accounts {
+		if s.full_fetch.IsZero() {
+			continue
+		}
+		if value := s.full_fetch.Format(time.RFC3339); u.env[stravaFullFetchKey(s.name)] != value {
+			u.env[stravaFullFetchKey(s.name)] = value
+			changed = true
+		}
+	}
+	if changed {
+		dumpEnvFile(u)
+	}
+}
+
+// saveStravaTokens writes refreshed access tokens back to the env file so a
+// restart doesn't start with an expired token.
+func saveStravaTokens(u *uploader) {
+	changed := false
+	for _, s := range u.accounts {
+		token, err := json.Marshal(s.token)
+		if err != nil {
+			continue
+		}
+		if u.env[stravaTokenKey(s.name)] != string(token) {
+			u.env[stravaTokenKey(s.name)] = string(token)
+			changed = true
+		}
+		// An authorization again during the cycle may have changed it.
+		if s.scope != "" && u.env[stravaScopeKey(s.name)] != s.scope {
+			u.env[stravaScopeKey(s.name)] = s.scope
+			changed = true
+		}
+	}
+	if changed {
+		dumpEnvFile(u)
+	}
+}
+
+func splitList(value string) (items []string) {
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return
+}
+
+// stravaAccounts lists every authorized-or-requested account in
+// TAJU_STRAVA_ACCOUNTS, defaulting to the single default account.
+func stravaAccounts(env map[string]string) []string {
+	accounts := splitList(env["TAJU_STRAVA_ACCOUNTS"])
+	if len(accounts) == 0 {
+		return []string{DEFAULT_ACCOUNT}
+	}
+	return accounts
+}
+
+// syncAccounts lists the accounts whose activities feed the sync,
+// TAJU_SYNC_ACCOUNTS or all of them.
+func syncAccounts(env map[string]string) []string {
+	accounts := splitList(env["TAJU_SYNC_ACCOUNTS"])
+	if len(accounts) == 0 {
+		return stravaAccounts(env)
+	}
+	return accounts
+}
+
+func accountsCommand(u *uploader, args []string) {
+	if len(args) == 0 {
+		args = []string{"list"}
+	}
+
+	accounts := stravaAccounts(u.env)
+	switch args[0] {
+	case "list":
+		syncing := syncAccounts(u.env)
+		for _, name := range accounts {
+			_, authorized := u.env[stravaTokenKey(name)]
+			fmt.Printf("%-12s authorized=%-5t sync=%t\n", name, authorized, slices.Contains(syncing, name))
+		}
+		return
+	case "add":
+		if len(args) != 2 || !ACCOUNT_NAME_PATTERN.MatchString(args[1]) {
+			log.Fatal("Usage: taju accounts add <name> (lowercase letters and digits)")
+		}
+		delete(u.env, stravaTokenKey(args[1]))
+		delete(u.env, stravaScopeKey(args[1]))
+		if err := initStrava(u.env, &strava{settings: u.settings}, args[1]); err != nil {
+			log.Fatal(err)
+		}
+		if !slices.Contains(accounts, args[1]) {
+			accounts = append(accounts, args[1])
+		}
+	case "remove":
+		if len(args) != 2 {
+			log.Fatal("Usage: taju accounts remove <name>")
+		}
+		delete(u.env, stravaTokenKey(args[1]))
+		delete(u.env, stravaScopeKey(args[1]))
+		accounts = slices.DeleteFunc(accounts, func(name string) bool { return name == args[1] })
+		syncing := slices.DeleteFunc(splitList(u.env["TAJU_SYNC_ACCOUNTS"]), func(name string) bool { return name == args[1] })
+		u.env["TAJU_SYNC_ACCOUNTS"] = strings.Join(syncing, ",")
+	case "use":
+		if len(args) != 2 {
+			log.Fatal("Usage: taju accounts use <name>[,<name>...]")
+		}
+		for _, name := range splitList(args[1]) {
+			if !slices.Contains(accounts, name) {
+				log.Fatalf("Unknown account %q, add it first with: taju accounts add %s", name, name)
+			}
+		}
+		u.env["TAJU_SYNC_ACCOUNTS"] = args[1]
+	default:
+		log.Fatal("Unknown accounts command: ", args[0])
+	}
+
+	u.env["TAJU_STRAVA_ACCOUNTS"] = strings.Join(accounts, ",")
+	dumpEnvFile(u)
+	log.Printf("Strava accounts: %s", u.env["TAJU_STRAVA_ACCOUNTS"])
+}
@@ -0,0 +1,78 @@
+package taju
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFullFetchDue(t *testing.T) {
+	tests := []struct {
+		name   string
+		strava strava
+		want   bool
+	}{
+		{name: "never fetched", strava: strava{full_interval: DEFAULT_FULL_FETCH_INTERVAL}, want: true},
+		{name: "fetched recently", strava: strava{full_fetch: TEST_NOW.Add(-time.Hour), full_interval: DEFAULT_FULL_FETCH_INTERVAL}},
+		{name: "interval elapsed", strava: strava{full_fetch: TEST_NOW.Add(-DEFAULT_FULL_FETCH_INTERVAL), full_interval: DEFAULT_FULL_FETCH_INTERVAL}, want: true},
+		{name: "every time", strava: strava{full_fetch: TEST_NOW.Add(-time.Minute)}, want: true},
+		{name: "custom window", strava: strava{full_fetch: TEST_NOW.Add(-time.Hour), full_interval: DEFAULT_FULL_FETCH_INTERVAL, custom_window: true}, want: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := fullFetchDue(&test.strava, TEST_NOW); got != test.want {
+				t.Errorf("fullFetchDue() = %t, want %t", got, test.want)
+			}
+		})
+	}
+}
+
+func TestStravaCursorsPersisted(t *testing.T) {
+	cursor, full_fetch := TEST_NOW.Add(-2*time.Hour), TEST_NOW.Add(-time.Hour)
+	u := &uploader{env: map[string]string{}, config: map[string]string{}, state_dir: t.TempDir()}
+	u.accounts = []*strava{
+		{name: DEFAULT_ACCOUNT, cursor: cursor, full_fetch: full_fetch},
+		{name: "club", cursor: cursor},
+	}
+	saveStravaCursors(u)
+
+	want := map[string]string{
+		"STRAVA_CURSOR":      cursor.Format(time.RFC3339),
+		"STRAVA_FULL_FETCH":  full_fetch.Format(time.RFC3339),
+		"STRAVA_CURSOR_CLUB": cursor.Format(time.RFC3339),
+	}
+	for key, value := range want {
+		if u.env[key] != value {
+			t.Errorf("%s = %q, want %q", key, u.env[key], value)
+		}
+	}
+	if value, ok := u.env["STRAVA_FULL_FETCH_CLUB"]; ok {
+		t.Errorf("STRAVA_FULL_FETCH_CLUB = %q for an account never fully fetched", value)
+	}
+
+	loaded := &strava{name: DEFAULT_ACCOUNT}
+	loadStravaCursor(u.env, loaded)
+	if !loaded.cursor.Equal(cursor) || !loaded.full_fetch.Equal(full_fetch) {
+		t.Errorf("loadStravaCursor() = cursor %v, full fetch %v, want %v, %v", loaded.cursor, loaded.full_fetch, cursor, full_fetch)
+	}
+}
+
+func TestLoadFullFetchInterval(t *testing.T) {
+	tests := []struct {
+		env     map[string]string
+		want    time.Duration
+		invalid bool
+	}{
+		{env: map[string]string{}, want: DEFAULT_FULL_FETCH_INTERVAL},
+		{env: map[string]string{"TAJU_FULL_FETCH_INTERVAL": "6h"}, want: 6 * time.Hour},
+		{env: map[string]string{"TAJU_FULL_FETCH_INTERVAL": "0"}, want: 0},
+		{env: map[string]string{"TAJU_FULL_FETCH_INTERVAL": "daily"}, invalid: true},
+	}
+	for _, test := range tests {
+		got, err := loadFullFetchInterval(test.env)
+		if (err != nil) != test.invalid {
+			t.Errorf("loadFullFetchInterval(%v) error = %v, want an error: %t", test.env, err, test.invalid)
+		} else if got != test.want {
+			t.Errorf("loadFullFetchInterval(%v) = %s, want %s", test.env, got, test.want)
+		}
+	}
+}
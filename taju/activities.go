@@ -0,0 +1,263 @@
+package taju
+
+import (
+	"fmt"
+	"log"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// Strava activity types that are uploaded without any configuration. Other
+// sports are opt-in through TAJU_ACTIVITY_MAP, e.g.
+//
+//	TAJU_ACTIVITY_MAP=Ride=bike,Walk=ruck,Hike=hike,NordicSki=ski
+//
+// Keys are Strava sport types (or the older activity types), values are the
+// activity names of the Taji log form. Mapping a type to an empty value stops
+// it from being uploaded. The key * maps every sport that isn't listed,
+// including activities uploaded by other apps without a type; without it
+// they are skipped.
+const OTHER_SPORTS string = "*"
+
+var DEFAULT_ACTIVITY_MAP = map[string]string{
+	"Run":        "run",
+	"TrailRun":   "run",
+	"VirtualRun": "run",
+}
+
+func loadActivityMap(env map[string]string) (map[string]string, error) {
+	activity_map := make(map[string]string)
+	for strava_type, taji_activity := range DEFAULT_ACTIVITY_MAP {
+		activity_map[strava_type] = taji_activity
+	}
+	for _, pair := range splitList(env["TAJU_ACTIVITY_MAP"]) {
+		strava_type, taji_activity, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid TAJU_ACTIVITY_MAP entry %q, expected StravaType=taji_activity", pair)
+		}
+		activity_map[strings.TrimSpace(strava_type)] = strings.ToLower(strings.TrimSpace(taji_activity))
+	}
+	return activity_map, nil
+}
+
+// tajiActivity maps a Strava activity to the Taji activity it is logged as,
+// preferring the detailed sport type over the legacy type. Other apps don't
+// always write the types the way Strava does, so the case is ignored when
+// there is no exact match.
+func tajiActivity(activity_map map[string]string, activity stravaActivity) (string, bool) {
+	for _, strava_type := range []string{activity.SportType, activity.Type} {
+		strava_type = strings.TrimSpace(strava_type)
+		if strava_type == "" {
+			continue
+		}
+		if taji_activity, ok := activity_map[strava_type]; ok {
+			return taji_activity, taji_activity != ""
+		}
+		for key, taji_activity := range activity_map {
+			if strings.EqualFold(key, strava_type) {
+				return taji_activity, taji_activity != ""
+			}
+		}
+	}
+	taji_activity, ok := activity_map[OTHER_SPORTS]
+	if ok && taji_activity != "" {
+		slog.Debug("Mapping an unlisted sport", "strava_id", activity.Id, "sport_type", activity.SportType, "type", activity.Type,
+			"activity", taji_activity)
+	}
+	return taji_activity, ok && taji_activity != ""
+}
+
+// missingFields lists the summary fields an activity needs for upload but
+// doesn't have. Activities starting in a privacy zone can come back with
+// zeroed or absent fields in the list endpoint.
+func missingFields(s *strava, activity stravaActivity) (missing []string) {
+	if _, err := time.Parse(time.RFC3339, activity.StartDate); err != nil {
+		missing = append(missing, "start_date")
+	}
+	if activityDuration(s, activity) <= 0 {
+		missing = append(missing, "elapsed_time")
+	}
+	if activity.Distance <= 0 && !durationOnlyAllowed(s, activity) {
+		missing = append(missing, "distance")
+	}
+	if activity.Type == "" && activity.SportType == "" && s.activity_map[OTHER_SPORTS] == "" {
+		missing = append(missing, "type")
+	}
+	return
+}
+
+// completeActivities re-fetches incomplete activities from the detail
+// endpoint and drops, with a warning, those that are still incomplete.
+func completeActivities(s *strava, activities []stravaActivity) (complete []stravaActivity) {
+	var ids []int64
+	for _, activity := range activities {
+		if len(missingFields(s, activity)) > 0 && activity.Id != 0 {
+			ids = append(ids, activity.Id)
+		}
+	}
+	details := make(map[int64]stravaActivity)
+	for _, detail := range stravaAPI(s).Activities(ids) {
+		details[detail.Id] = detail
+	}
+
+	for _, activity := range activities {
+		if detail, ok := details[activity.Id]; ok {
+			activity = detail
+		}
+		if missing := missingFields(s, activity); len(missing) > 0 {
+			log.Printf("Skipping Strava activity %d (%q), it is missing %s", activity.Id, activity.Name, strings.Join(missing, ", "))
+			continue
+		}
+		complete = append(complete, activity)
+	}
+	return
+}
+
+// loadDurationOnly reads TAJU_DURATION_ONLY, the Taji activities that accept
+// entries without a distance ("time on feet"). Strava activities of those
+// categories that have no distance are posted with their duration only
+// instead of being skipped.
+func loadDurationOnly(env map[string]string) map[string]bool {
+	allowed := make(map[string]bool)
+	for _, activity := range splitList(env["TAJU_DURATION_ONLY"]) {
+		allowed[strings.ToLower(activity)] = true
+	}
+	return allowed
+}
+
+func durationOnlyAllowed(s *strava, activity stravaActivity) bool {
+	taji_activity, ok := tajiActivity(s.activity_map, activity)
+	return ok && s.duration_only[taji_activity]
+}
+
+// Strava durations an activity can be posted with, chosen by
+// TAJU_DURATION_SOURCE. The first value is the default, and Taji activities
+// can pick their own, e.g.
+//
+//	TAJU_DURATION_SOURCE=moving,ruck=elapsed
+//
+// posts the moving time (without stoplights and coffee stops) except for
+// rucks.
+const (
+	DURATION_AUTO    string = "auto"
+	DURATION_ELAPSED string = "elapsed"
+	DURATION_MOVING  string = "moving"
+)
+
+// An elapsed time more than ABSURD_PAUSE_RATIO times the moving time, and
+// at least ABSURD_PAUSE longer, usually means the watch was left running
+// after a treadmill run.
+const (
+	ABSURD_PAUSE_RATIO int64 = 3
+	ABSURD_PAUSE       int64 = 30 * 60
+)
+
+// loadDurationSource reads TAJU_DURATION_SOURCE, keyed by Taji activity
+// with "" for the default.
+func loadDurationSource(env map[string]string) (map[string]string, error) {
+	sources := map[string]string{"": DURATION_AUTO}
+	for _, item := range splitList(env["TAJU_DURATION_SOURCE"]) {
+		taji_activity, source, ok := strings.Cut(strings.ToLower(item), "=")
+		if !ok {
+			taji_activity, source = "", taji_activity
+		}
+		switch source = strings.TrimSpace(source); source {
+		case DURATION_AUTO, DURATION_ELAPSED, DURATION_MOVING:
+			sources[strings.TrimSpace(taji_activity)] = source
+		default:
+			return nil, fmt.Errorf("invalid TAJU_DURATION_SOURCE entry %q, expected auto, elapsed or moving", item)
+		}
+	}
+	return sources, nil
+}
+
+// durationSource is the TAJU_DURATION_SOURCE of an activity.
+func durationSource(s *strava, activity stravaActivity) string {
+	if taji_activity, ok := tajiActivity(s.activity_map, activity); ok {
+		if source, ok := s.duration_source[taji_activity]; ok {
+			return source
+		}
+	}
+	return s.duration_source[""]
+}
+
+// activityDuration is the duration, in seconds, an activity is posted with.
+// The elapsed time is preferred, falling back to the moving time when it is
+// missing (some manual entries) or absurd.
+func activityDuration(s *strava, activity stravaActivity) int64 {
+	elapsed, moving := activity.ElapsedTime, activity.MovingTime
+	source := durationSource(s, activity)
+	switch {
+	case source == DURATION_MOVING && moving > 0:
+		return moving
+	case elapsed <= 0:
+		return moving
+	case source == DURATION_ELAPSED || moving <= 0:
+		return elapsed
+	case elapsed > ABSURD_PAUSE_RATIO*moving && elapsed-moving > ABSURD_PAUSE:
+		return moving
+	}
+	return elapsed
+}
+
+// ELEVATION_THRESHOLD is the climb, in meters, the altitude stream has to
+// show before it counts. It filters out GPS noise that would otherwise add
+// up to hundreds of feet on a flat run.
+const ELEVATION_THRESHOLD float64 = 3
+
+// fillElevation computes the elevation gain of activities Strava reports
+// without one (devices without a barometer, some manual uploads) from their
+// altitude stream. It is enabled with TAJU_ELEVATION_STREAMS=true.
+func fillElevation(s *strava, activities []stravaActivity) {
+	if !s.elevation_streams {
+		return
+	}
+	var missing []int
+	for i, activity := range activities {
+		if activity.TotalElevationGain <= 0 && activity.Distance > 0 && !activity.Manual {
+			missing = append(missing, i)
+		}
+	}
+	api := stravaAPI(s)
+	forEachLimit(len(missing), s.detail_workers, func(i int) {
+		activity := &activities[missing[i]]
+		streams, err := api.Streams(activity.Id, []string{"altitude"})
+		if err != nil {
+			log.Print("Error fetching altitude of Strava activity ", activity.Id, ": ", err)
+			return
+		}
+		for _, stream := range streams {
+			if stream.Type == "altitude" {
+				activity.TotalElevationGain = elevationGain(stream.Data)
+			}
+		}
+	})
+}
+
+// elevationGain sums the climbs in an altitude series, ignoring changes
+// smaller than ELEVATION_THRESHOLD.
+func elevationGain(altitude []float64) (gain float64) {
+	if len(altitude) == 0 {
+		return 0
+	}
+	low := altitude[0]
+	high := altitude[0]
+	for _, point := range altitude[1:] {
+		switch {
+		case point > high:
+			high = point
+		case high-point >= ELEVATION_THRESHOLD:
+			if high-low >= ELEVATION_THRESHOLD {
+				gain += high - low
+			}
+			low, high = point, point
+		case point < low:
+			low, high = point, point
+		}
+	}
+	if high-low >= ELEVATION_THRESHOLD {
+		gain += high - low
+	}
+	return
+}
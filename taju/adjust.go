@@ -0,0 +1,66 @@
+package taju
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// distanceAdjustments are how Taji counts the distance of an activity, as a
+// factor of what was recorded: TAJU_DISTANCE_ADJUST=bike=0.25 posts a
+// quarter of the miles ridden, gear:g1234=1.5 counts everything done with a
+// Strava gear (the weighted ruck pack) half again. A gear's factor wins
+// over its activity's. The adjusted distance is what is posted and counted
+// towards the goal; the recorded one is kept next to it for the summaries.
+type distanceAdjustments map[string]float64
+
+const GEAR_PREFIX string = "gear:"
+
+func loadDistanceAdjustments(env map[string]string) (distanceAdjustments, error) {
+	adjustments := make(distanceAdjustments)
+	for _, item := range splitList(env["TAJU_DISTANCE_ADJUST"]) {
+		key, value, ok := strings.Cut(item, "=")
+		factor, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if !ok || strings.TrimSpace(key) == "" || err != nil || factor < 0 {
+			return nil, fmt.Errorf("invalid TAJU_DISTANCE_ADJUST entry %q, expected activity=factor or gear:id=factor", item)
+		}
+		adjustments[strings.TrimSpace(key)] = factor
+	}
+	return adjustments, nil
+}
+
+func (a distanceAdjustments) factor(run runDetails) (float64, bool) {
+	if run.gear != "" {
+		if factor, ok := a[GEAR_PREFIX+run.gear]; ok {
+			return factor, true
+		}
+	}
+	factor, ok := a[run.activity]
+	return factor, ok
+}
+
+func adjustTransform(env map[string]string, _ *settings) (runTransform, error) {
+	adjustments, err := loadDistanceAdjustments(env)
+	if err != nil {
+		return runTransform{}, err
+	}
+	return runTransform{"adjust", func(run *runDetails) error {
+		factor, ok := adjustments.factor(*run)
+		if !ok || factor == 1 {
+			return nil
+		}
+		if run.raw_distance == 0 {
+			run.raw_distance = run.distance_float
+		}
+		run.distance_float = run.raw_distance * factor
+		return nil
+	}}, nil
+}
+
+// recorded is the distance as recorded, before TAJU_DISTANCE_ADJUST.
+func (r runDetails) recorded() float64 {
+	if r.raw_distance > 0 {
+		return r.raw_distance
+	}
+	return r.distance_float
+}
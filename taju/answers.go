@@ -0,0 +1,51 @@
+package taju
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/joho/godotenv"
+)
+
+// answer returns a pre-supplied answer for an interactive prompt. It looks at
+// the process environment first, then the file named by TAJU_ANSWERS_FILE
+// (e.g. a Docker or systemd secret), then taju.env. Only when none of them has
+// the key does it prompt, and it refuses to block when s can't, see
+// settings.interactive.
+func answer(s *settings, env map[string]string, key string, prompt string) (string, error) {
+	value, ok, err := presupplied(env, key)
+	if ok || err != nil {
+		return value, err
+	}
+
+	if !s.interactive() {
+		return "", fmt.Errorf("no answer for %s and nobody can be asked here. Set %s in the environment or in TAJU_ANSWERS_FILE", key, key)
+	}
+	fmt.Print(prompt)
+	fmt.Scanln(&value)
+	return value, nil
+}
+
+// presupplied looks a key up in the places answer reads without prompting.
+func presupplied(env map[string]string, key string) (string, bool, error) {
+	if value, ok := os.LookupEnv(key); ok {
+		return value, true, nil
+	}
+
+	answers_file := os.Getenv("TAJU_ANSWERS_FILE")
+	if answers_file == "" {
+		answers_file = env["TAJU_ANSWERS_FILE"]
+	}
+	if answers_file != "" {
+		answers, err := godotenv.Read(answers_file)
+		if err != nil {
+			return "", false, fmt.Errorf("error loading answers file: '%s': %w", answers_file, err)
+		}
+		if value, ok := answers[key]; ok {
+			return value, true, nil
+		}
+	}
+
+	value, ok := env[key]
+	return value, ok, nil
+}
@@ -0,0 +1,121 @@
+package taju
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/tajuploader/config"
+)
+
+const DEFAULT_DEBUG_MAX_MB = 50
+const DEFAULT_DEBUG_MAX_AGE = 7 * 24 * time.Hour
+
+// artifactDir keeps debug dumps (HTML snapshots, raw API responses) in one
+// directory and prunes it by age and total size after every write.
+type artifactDir struct {
+	mu       sync.Mutex
+	path     string
+	max_size int64
+	max_age  time.Duration
+	clock    clock
+}
+
+var ARTIFACT_NAME_PATTERN = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// loadDebugArtifacts enables debug dumps when TAJU_DEBUG_DIR is set.
+func loadDebugArtifacts(env map[string]string, state_dir string, c clock) *artifactDir {
+	path, ok := env["TAJU_DEBUG_DIR"]
+	if !ok || path == "" {
+		return nil
+	}
+	path = config.StatePath(state_dir, path)
+	if err := os.MkdirAll(path, 0700); err != nil {
+		log.Print("Debug artifacts disabled, cannot create ", path, ": ", err)
+		return nil
+	}
+
+	max_mb := DEFAULT_DEBUG_MAX_MB
+	if value, ok := env["TAJU_DEBUG_MAX_MB"]; ok {
+		if n, err := strconv.Atoi(value); err == nil && n > 0 {
+			max_mb = n
+		} else {
+			log.Printf("Ignoring invalid TAJU_DEBUG_MAX_MB=%q", value)
+		}
+	}
+	max_age := DEFAULT_DEBUG_MAX_AGE
+	if value, ok := env["TAJU_DEBUG_MAX_AGE"]; ok {
+		if d, err := time.ParseDuration(value); err == nil && d > 0 {
+			max_age = d
+		} else {
+			log.Printf("Ignoring invalid TAJU_DEBUG_MAX_AGE=%q", value)
+		}
+	}
+
+	a := &artifactDir{
+		path:     path,
+		max_size: int64(max_mb) * 1024 * 1024,
+		max_age:  max_age,
+		clock:    c,
+	}
+	a.rotate()
+	return a
+}
+
+// save writes a redacted dump if debug artifacts are enabled, that is a is
+// not nil.
+func (a *artifactDir) save(name string, data []byte) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	filename := fmt.Sprintf("%s-%s", a.clock.Now().Format("20060102-150405.000"), ARTIFACT_NAME_PATTERN.ReplaceAllString(name, "_"))
+	path := filepath.Join(a.path, filename)
+	if err := os.WriteFile(path, []byte(redact(string(data))), 0600); err != nil {
+		log.Print("Failed to write debug artifact ", path, ": ", err)
+		return
+	}
+	log.Print("Saved debug artifact ", path)
+	a.rotateLocked()
+}
+
+func (a *artifactDir) rotate() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.rotateLocked()
+}
+
+// rotateLocked deletes artifacts older than max_age, then the oldest ones
+// until the directory fits in max_size.
+func (a *artifactDir) rotateLocked() {
+	dir_entries, err := os.ReadDir(a.path)
+	if err != nil {
+		log.Print("Failed to read debug artifacts: ", err)
+		return
+	}
+
+	var files []os.FileInfo
+	for _, entry := range dir_entries {
+		if info, err := entry.Info(); err == nil && info.Mode().IsRegular() {
+			files = append(files, info)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].ModTime().After(files[j].ModTime()) })
+
+	var total int64
+	cutoff := a.clock.Now().Add(-a.max_age)
+	for _, info := range files {
+		total += info.Size()
+		if info.ModTime().Before(cutoff) || total > a.max_size {
+			os.Remove(filepath.Join(a.path, info.Name()))
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package taju
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"maps"
+	"math/rand"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	DEFAULT_BACKFILL_BATCH = 10
+	DEFAULT_BACKFILL_PAUSE = time.Minute
+)
+
+// backfillPace spaces out the posts of a backfill: after every batch of
+// posts it waits the pause, plus up to half of it again at random, so a
+// month of activities doesn't go to Taji in one burst.
+type backfillPace struct {
+	batch int64
+	pause time.Duration
+	clock clock
+	posts atomic.Int64
+}
+
+func (p *backfillPace) wait() {
+	n := p.posts.Add(1)
+	if n == 1 || (n-1)%p.batch != 0 || p.pause <= 0 {
+		return
+	}
+	delay := p.pause + time.Duration(rand.Int63n(int64(p.pause)/2+1))
+	log.Printf("Posted %d activities, pausing for %s", n-1, delay.Round(time.Second))
+	<-p.clock.After(delay)
+}
+
+// backfillCommand uploads what is missing from a past date range, for
+// people who start using taju in the middle of the event: the whole range
+// is read from Strava (not just what is newer than the cursor), the
+// changes are shown for confirmation, and the posts go out one at a time in
+// batches of --batch with --pause between them.
+func backfillCommand(u *uploader, args []string) {
+	flags := flag.NewFlagSet("backfill", flag.ExitOnError)
+	from := flags.String("from", "", "first day, YYYY-MM-DD")
+	to := flags.String("to", "", "last day, YYYY-MM-DD (default: today)")
+	batch := flags.Int("batch", DEFAULT_BACKFILL_BATCH, "posts between pauses")
+	pause := flags.Duration("pause", DEFAULT_BACKFILL_PAUSE, "pause between batches, up to half of it again is added at random")
+	yes := flags.Bool("yes", false, "don't ask before uploading")
+	flags.Parse(args)
+	if *from == "" {
+		log.Fatal("Usage: taju backfill --from 2025-02-01 [--to 2025-02-14] [--batch 10] [--pause 1m] [--yes]")
+	}
+	if *batch < 1 {
+		log.Fatal("--batch must be at least 1")
+	}
+	if *to == "" {
+		*to = u.clock.Now().Format(DATE_FORMAT)
+	}
+	window := maps.Clone(u.env)
+	window["TAJU_EVENT_START"], window["TAJU_EVENT_END"] = *from, *to
+	start, end, err := eventWindow(window, u.clock.Now())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := initStravaAccounts(u); err != nil {
+		log.Fatal(err)
+	}
+	for _, s := range u.accounts {
+		s.window_start, s.window_end = start, end
+		s.cursor, s.custom_window = time.Time{}, true
+	}
+	if err := initTajiSession(u); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Backfilling %s to %s", start.Format(DATE_FORMAT), end.AddDate(0, 0, -1).Format(DATE_FORMAT))
+
+	u.post_workers = 1
+	s, err := newSyncer(u)
+	if err != nil {
+		log.Fatal(err)
+	}
+	s.confirm_plan = !*yes
+	s.pace = &backfillPace{batch: int64(*batch), pause: *pause, clock: u.clock}
+	// A range before the cursor mustn't move it back.
+	s.scratch = true
+	registerDecisionLog(s)
+	result := s.cycle()
+	fmt.Printf("Backfill posted %d of %d planned changes.\n", len(result.posted), len(result.plan))
+	flushState([]*uploader{u})
+	if result.failed {
+		os.Exit(1)
+	}
+}
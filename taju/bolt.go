@@ -0,0 +1,125 @@
+package taju
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// BOLT_TIMEOUT is how long to wait for another taju process holding the
+// database.
+const BOLT_TIMEOUT = 10 * time.Second
+
+// BOLT_STATE is the bucket of the sections that aren't ledgers.
+const BOLT_STATE string = "state"
+
+// boltStorage keeps the ledger in a bbolt database (TAJU_STORAGE=bbolt), a
+// single file without a server or cgo. Every ledger section is a bucket of
+// entries keyed like in the JSON ledger, with the entry's JSON as value;
+// the rest of the state is a JSON value per section in the state bucket. A
+// new database starts from the JSON ledger it replaces.
+type boltStorage struct {
+	path     string
+	imported jsonStorage
+}
+
+func (b boltStorage) String() string {
+	return b.path
+}
+
+func (b boltStorage) load(state *stateStore) error {
+	if _, err := os.Stat(b.path); errors.Is(err, os.ErrNotExist) {
+		return b.imported.load(state)
+	}
+	db, err := bolt.Open(b.path, 0600, &bolt.Options{Timeout: BOLT_TIMEOUT, ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ledgers := make(map[string]map[string]*ledgerEntry)
+	other := make(map[string]json.RawMessage)
+	err = db.View(func(tx *bolt.Tx) error {
+		for _, section := range LEDGER_SECTIONS {
+			bucket := tx.Bucket([]byte(section))
+			if bucket == nil {
+				continue
+			}
+			entries := make(map[string]*ledgerEntry)
+			err := bucket.ForEach(func(key, value []byte) error {
+				e := new(ledgerEntry)
+				if err := json.Unmarshal(value, e); err != nil {
+					return fmt.Errorf("%s entry %s: %w", section, key, err)
+				}
+				entries[string(key)] = e
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			ledgers[section] = entries
+		}
+		if bucket := tx.Bucket([]byte(BOLT_STATE)); bucket != nil {
+			return bucket.ForEach(func(key, value []byte) error {
+				other[string(key)] = json.RawMessage(append([]byte(nil), value...))
+				return nil
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return loadSections(state, ledgers, other)
+}
+
+// save replaces the stored state in one transaction.
+func (b boltStorage) save(state *stateStore) error {
+	ledgers, other, err := stateSections(state)
+	if err != nil {
+		return err
+	}
+	db, err := bolt.Open(b.path, 0600, &bolt.Options{Timeout: BOLT_TIMEOUT})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.Update(func(tx *bolt.Tx) error {
+		for _, section := range append(slices.Clone(LEDGER_SECTIONS), BOLT_STATE) {
+			if err := tx.DeleteBucket([]byte(section)); err != nil && !errors.Is(err, bolt.ErrBucketNotFound) {
+				return err
+			}
+		}
+		for section, entries := range ledgers {
+			bucket, err := tx.CreateBucket([]byte(section))
+			if err != nil {
+				return err
+			}
+			for key, e := range entries {
+				value, err := json.Marshal(e)
+				if err != nil {
+					return err
+				}
+				if err := bucket.Put([]byte(key), value); err != nil {
+					return err
+				}
+			}
+		}
+		bucket, err := tx.CreateBucket([]byte(BOLT_STATE))
+		if err != nil {
+			return err
+		}
+		for section, value := range other {
+			if err := bucket.Put([]byte(section), value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
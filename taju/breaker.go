@@ -0,0 +1,137 @@
+package taju
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+)
+
+const (
+	BREAKER_FAILURES     = 3
+	BREAKER_COOLDOWN     = 30 * time.Minute
+	BREAKER_MAX_COOLDOWN = 6 * time.Hour
+)
+
+// tajiBreaker stops a cycle from going at Taji while it keeps failing, e.g.
+// during site maintenance: after TAJU_BREAKER_FAILURES cycles in a row that
+// couldn't read Taji it opens, and cycles queue their activities without a
+// single Taji request until the cooldown (TAJU_BREAKER_COOLDOWN, doubled
+// every time a probe fails, up to 6h) is over. The next cycle then reads
+// the participant page as the probe: if that works the breaker closes and
+// the cycle goes on as usual. The state is kept in the ledger, so a
+// restart doesn't start hammering the site again.
+type tajiBreaker struct {
+	failures int
+	cooldown time.Duration
+}
+
+// breakerState is the breaker as kept in the ledger.
+type breakerState struct {
+	Failures int       `json:"failures"`
+	Reason   string    `json:"reason"`
+	Opened   time.Time `json:"opened,omitempty"`
+	Probes   int       `json:"probes,omitempty"`
+	RetryAt  time.Time `json:"retry_at,omitempty"`
+}
+
+func (b breakerState) open() bool {
+	return !b.Opened.IsZero()
+}
+
+// breakerChanged is published when the breaker opens or closes.
+type breakerChanged struct {
+	state breakerState
+}
+
+func loadTajiBreaker(env map[string]string) (tajiBreaker, error) {
+	breaker := tajiBreaker{failures: BREAKER_FAILURES, cooldown: BREAKER_COOLDOWN}
+	if value, ok := env["TAJU_BREAKER_FAILURES"]; ok {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return tajiBreaker{}, fmt.Errorf("invalid TAJU_BREAKER_FAILURES=%q, expected a number of cycles (0 turns the breaker off)", value)
+		}
+		breaker.failures = n
+	}
+	if value, ok := env["TAJU_BREAKER_COOLDOWN"]; ok {
+		cooldown, err := time.ParseDuration(value)
+		if err != nil || cooldown <= 0 {
+			return tajiBreaker{}, fmt.Errorf("invalid TAJU_BREAKER_COOLDOWN=%q, expected a duration like 30m", value)
+		}
+		breaker.cooldown = cooldown
+	}
+	return breaker, nil
+}
+
+// allowTaji returns an error while the breaker is open and its cooldown isn't
+// over.
+func (s *syncer) allowTaji() error {
+	state := s.u.state.breaker()
+	if !state.open() || !s.u.clock.Now().Before(state.RetryAt) {
+		return nil
+	}
+	return fmt.Errorf("Taji has been failing since %s (%s), next try at %s", state.Opened.Local().Format("Jan 2 15:04"),
+		state.Reason, state.RetryAt.Local().Format("15:04"))
+}
+
+// tajiRead records whether the cycle could read Taji.
+func (s *syncer) tajiRead(err error) {
+	if s.breaker.failures == 0 || s.scratch {
+		return
+	}
+	now := s.u.clock.Now()
+	var changed *breakerState
+	s.u.state.updateBreaker(func(state *breakerState) {
+		if err == nil {
+			if state.open() {
+				log.Printf("Taji is responding again after failing since %s", state.Opened.Local().Format("Jan 2 15:04"))
+				changed = &breakerState{}
+			}
+			*state = breakerState{}
+			return
+		}
+		state.Failures++
+		state.Reason = err.Error()
+		switch {
+		case state.open():
+			state.Probes++
+		case state.Failures >= s.breaker.failures:
+			state.Opened = now
+			changed = state
+		default:
+			return
+		}
+		cooldown := s.breaker.cooldown
+		for i := 0; i < state.Probes && cooldown < BREAKER_MAX_COOLDOWN; i++ {
+			cooldown *= 2
+		}
+		state.RetryAt = now.Add(min(cooldown, max(BREAKER_MAX_COOLDOWN, s.breaker.cooldown)))
+		if changed != nil {
+			log.Printf("Taji failed %d cycles in a row, not trying again until %s", state.Failures, state.RetryAt.Local().Format("Jan 2 15:04"))
+		}
+	})
+	if changed != nil {
+		s.events.publish(breakerChanged{*changed})
+	}
+}
+
+func (s *stateStore) breaker() breakerState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Breaker == nil {
+		return breakerState{}
+	}
+	return *s.Breaker
+}
+
+func (s *stateStore) updateBreaker(update func(*breakerState)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Breaker == nil {
+		s.Breaker = &breakerState{}
+	}
+	update(s.Breaker)
+	if *s.Breaker == (breakerState{}) {
+		s.Breaker = nil
+	}
+}
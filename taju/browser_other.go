@@ -0,0 +1,18 @@
+//go:build !darwin && !windows
+
+package taju
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+)
+
+// openBrowser opens a URL with xdg-open, when there is a desktop to open it
+// on.
+func openBrowser(url string) error {
+	if os.Getenv("DISPLAY") == "" && os.Getenv("WAYLAND_DISPLAY") == "" {
+		return errors.New("no display")
+	}
+	return exec.Command("xdg-open", url).Start()
+}
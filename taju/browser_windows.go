@@ -0,0 +1,9 @@
+package taju
+
+import "os/exec"
+
+// openBrowser hands the URL to the default browser. rundll32 avoids cmd's
+// start, which would mangle the & in the query.
+func openBrowser(url string) error {
+	return exec.Command("rundll32", "url.dll,FileProtocolHandler", url).Start()
+}
@@ -0,0 +1,59 @@
+package taju
+
+import "sync"
+
+// Events published on a syncer's bus during a cycle. Features that react to
+// cycles (notifications, the healthcheck, user hooks, the dashboard, the
+// jsonl emitter) subscribe to the ones they need instead of being wired
+// into the sync engine.
+type (
+	// cycleStarted is published before anything is fetched.
+	cycleStarted struct{}
+	// activityDiscovered is published for every activity fetched from Strava.
+	activityDiscovered struct{ run runDetails }
+	// activityDecided is published when the cycle decides what to do with an
+	// activity, and again when an action finishes.
+	activityDecided struct {
+		decision string
+		run      runDetails
+		result   string
+		err      error
+	}
+	// entryPosted is published after every post to Taji, err set if it failed.
+	entryPosted struct {
+		run runDetails
+		err error
+	}
+	// errorOccurred is published for every error of a cycle.
+	errorOccurred struct{ err error }
+	// cycleCompleted is published last, also when the cycle failed.
+	cycleCompleted struct{ result cycleResult }
+)
+
+// eventBus delivers events to its subscribers in the order they
+// subscribed. Delivery is synchronous, so subscribers must be quick (or
+// start their own goroutine) and safe to call from the post workers.
+type eventBus struct {
+	mu          sync.RWMutex
+	subscribers []func(event any)
+}
+
+func (b *eventBus) publish(event any) {
+	b.mu.RLock()
+	subscribers := b.subscribers
+	b.mu.RUnlock()
+	for _, handler := range subscribers {
+		handler(event)
+	}
+}
+
+// subscribe registers handler for the events of type E.
+func subscribe[E any](b *eventBus, handler func(E)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subscribers = append(b.subscribers, func(event any) {
+		if e, ok := event.(E); ok {
+			handler(e)
+		}
+	})
+}
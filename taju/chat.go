@@ -0,0 +1,232 @@
+package taju
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	NOTIFY_ON_POSTS  string = "posts"
+	NOTIFY_ON_ERRORS string = "errors"
+	NOTIFY_ON_ALWAYS string = "always"
+)
+
+// teamNotifier posts a summary of a cycle to a Discord or Slack webhook
+// and/or mails it, for teams that want progress pings in their channel.
+// TAJU_NOTIFY_ON picks the cycles: the ones that posted or failed (posts,
+// the default), only failures (errors) or every one (always).
+type teamNotifier struct {
+	on      string
+	discord string
+	slack   string
+	mail    mailSettings
+	profile string
+	client  *http.Client
+
+	mu         sync.Mutex
+	last_error error
+}
+
+type mailSettings struct {
+	addr     string
+	username string
+	password string
+	from     string
+	to       []string
+}
+
+// registerTeamNotifications subscribes the configured notifiers to the
+// syncer. Failures to deliver are logged and never fail the sync.
+func registerTeamNotifications(s *syncer, env map[string]string, network networkSettings) error {
+	n := &teamNotifier{
+		on:      env["TAJU_NOTIFY_ON"],
+		discord: env["TAJU_DISCORD_WEBHOOK"],
+		slack:   env["TAJU_SLACK_WEBHOOK"],
+		mail: mailSettings{addr: env["TAJU_SMTP_ADDR"], username: env["TAJU_SMTP_USERNAME"], password: env["TAJU_SMTP_PASSWORD"],
+			from: env["TAJU_SMTP_FROM"], to: splitList(env["TAJU_NOTIFY_EMAIL"])},
+		profile: s.u.profile,
+		client:  network.client(USER_HOOK_TIMEOUT),
+	}
+	switch n.on {
+	case "":
+		n.on = NOTIFY_ON_POSTS
+	case NOTIFY_ON_POSTS, NOTIFY_ON_ERRORS, NOTIFY_ON_ALWAYS:
+	default:
+		return fmt.Errorf("invalid TAJU_NOTIFY_ON=%q, expected posts, errors or always", n.on)
+	}
+	if n.mail.from == "" {
+		n.mail.from = n.mail.username
+	}
+	if len(n.mail.to) > 0 && n.mail.addr == "" {
+		return errors.New("TAJU_NOTIFY_EMAIL needs TAJU_SMTP_ADDR")
+	}
+	if n.discord == "" && n.slack == "" && len(n.mail.to) == 0 {
+		return nil
+	}
+	subscribe(&s.events, func(e errorOccurred) {
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		n.last_error = e.err
+	})
+	subscribe(&s.events, func(cycleStarted) {
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		n.last_error = nil
+	})
+	subscribe(&s.events, func(e cycleCompleted) {
+		n.mu.Lock()
+		last_error := n.last_error
+		n.mu.Unlock()
+		if message, ok := n.message(e.result, last_error); ok {
+			n.send(message)
+		}
+	})
+	return nil
+}
+
+// message is the summary of a cycle, e.g. "2 new runs uploaded, 54.3/100 mi".
+func (n *teamNotifier) message(result cycleResult, last_error error) (string, bool) {
+	var message string
+	switch {
+	case result.failed:
+		message = "Taj Uploader: the last sync failed"
+		if last_error != nil {
+			message += ": " + redact(last_error.Error())
+		}
+	case n.on == NOTIFY_ON_ERRORS:
+		return "", false
+	case len(result.posted) > 0:
+		message = fmt.Sprintf("%s uploaded, %.1f/%.0f %s", postedSummary(result.posted),
+			result.units.fromMiles(result.progress.done), result.units.fromMiles(result.progress.target), result.units.name())
+	case n.on == NOTIFY_ON_ALWAYS:
+		message = fmt.Sprintf("Nothing new to upload, %.1f/%.0f %s",
+			result.units.fromMiles(result.progress.done), result.units.fromMiles(result.progress.target), result.units.name())
+	default:
+		return "", false
+	}
+	if result.climb.set() && !result.failed {
+		message += fmt.Sprintf(", %s / %s climbed", result.climb.format(result.climb.done, 0), result.climb.format(result.climb.target, 0))
+	}
+	if len(result.posted) > 0 && result.encouragement != "" {
+		message += ". " + result.encouragement
+	}
+	if n.profile != "" {
+		message = n.profile + ": " + message
+	}
+	return message, true
+}
+
+// postedSummary counts the posted activities by kind: "2 new runs" or
+// "1 new run and 1 new walk".
+func postedSummary(posted []runDetails) string {
+	var kinds []string
+	counts := make(map[string]int)
+	for _, run := range posted {
+		if counts[run.activity] == 0 {
+			kinds = append(kinds, run.activity)
+		}
+		counts[run.activity]++
+	}
+	var parts []string
+	for _, kind := range kinds {
+		if counts[kind] == 1 {
+			parts = append(parts, "1 new "+kind)
+		} else {
+			parts = append(parts, fmt.Sprintf("%d new %ss", counts[kind], kind))
+		}
+	}
+	if len(parts) == 1 {
+		return parts[0]
+	}
+	return strings.Join(parts[:len(parts)-1], ", ") + " and " + parts[len(parts)-1]
+}
+
+func (n *teamNotifier) send(message string) {
+	ctx, cancel := context.WithTimeout(context.Background(), USER_HOOK_TIMEOUT)
+	defer cancel()
+	if n.discord != "" {
+		body, _ := json.Marshal(map[string]string{"content": message})
+		if err := postHookWebhook(ctx, n.client, n.discord, body); err != nil {
+			log.Print("Failed to notify Discord: ", err)
+		}
+	}
+	if n.slack != "" {
+		body, _ := json.Marshal(map[string]string{"text": message})
+		if err := postHookWebhook(ctx, n.client, n.slack, body); err != nil {
+			log.Print("Failed to notify Slack: ", err)
+		}
+	}
+	if len(n.mail.to) > 0 {
+		if err := sendMail(n.mail, message, USER_HOOK_TIMEOUT); err != nil {
+			log.Print("Failed to send the notification email: ", err)
+		}
+	}
+}
+
+// sendMail sends message as a plain text email, using STARTTLS when the
+// server offers it. Credentials are only sent over TLS. Like the webhooks,
+// the whole exchange is bounded by a context: the connection is closed when
+// timeout is up.
+func sendMail(m mailSettings, message string, timeout time.Duration) error {
+	host, _, err := net.SplitHostPort(m.addr)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	conn, err := new(net.Dialer).DialContext(ctx, "tcp", m.addr)
+	if err != nil {
+		return err
+	}
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if m.username != "" {
+		if err := c.Auth(smtp.PlainAuth("", m.username, m.password, host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(m.from); err != nil {
+		return err
+	}
+	for _, to := range m.to {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	var mail bytes.Buffer
+	fmt.Fprintf(&mail, "From: %s\r\nTo: %s\r\nSubject: %s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s\r\n",
+		m.from, strings.Join(m.to, ", "), "Taj Uploader", message)
+	if _, err := w.Write(mail.Bytes()); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
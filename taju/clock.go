@@ -0,0 +1,72 @@
+package taju
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// clock is the uploader's only source of the current time, so scheduling and
+// event-window logic can be exercised without real waiting.
+type clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time        { return time.Now() }
+func (realClock) Sleep(d time.Duration) { time.Sleep(d) }
+
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// fakeClock starts at a fixed instant and only moves when slept on or
+// advanced, which makes a 12 hour sleep return immediately.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock(now time.Time) *fakeClock {
+	return &fakeClock{now: now}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Sleep(d time.Duration) {
+	c.Advance(d)
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.Advance(d)
+	ready := make(chan time.Time, 1)
+	ready <- c.Now()
+	return ready
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// newClock returns the real clock unless TAJU_FAKE_NOW pins the start time
+// (RFC 3339) for time-travel testing.
+func newClock(env map[string]string) (clock, error) {
+	value, ok := env["TAJU_FAKE_NOW"]
+	if !ok {
+		return realClock{}, nil
+	}
+	now, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TAJU_FAKE_NOW: %w", err)
+	}
+	log.Print("Using a fake clock starting at ", now)
+	return newFakeClock(now), nil
+}
@@ -0,0 +1,34 @@
+package taju
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFakeClock(t *testing.T) {
+	c := newFakeClock(TEST_NOW)
+	c.Sleep(12 * time.Hour)
+	if got := c.Now(); !got.Equal(TEST_NOW.Add(12 * time.Hour)) {
+		t.Errorf("Now() after Sleep = %v, want 12h later", got)
+	}
+	if got := <-c.After(time.Hour); !got.Equal(TEST_NOW.Add(13 * time.Hour)) {
+		t.Errorf("After() fired at %v, want 13h later", got)
+	}
+	c.Advance(-13 * time.Hour)
+	if got := c.Now(); !got.Equal(TEST_NOW) {
+		t.Errorf("Now() after Advance = %v, want %v", got, TEST_NOW)
+	}
+}
+
+func TestNewClock(t *testing.T) {
+	if c, _ := newClock(map[string]string{}); c != (realClock{}) {
+		t.Error("newClock() without TAJU_FAKE_NOW isn't the real clock")
+	}
+	c, err := newClock(map[string]string{"TAJU_FAKE_NOW": "2026-02-20T12:00:00Z"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := c.Now(); !got.Equal(TEST_NOW) {
+		t.Errorf("Now() = %v, want %v", got, TEST_NOW)
+	}
+}
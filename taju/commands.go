@@ -0,0 +1,452 @@
+package taju
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"maps"
+	"math"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// registerSubscribers subscribes the hooks, notifications, exports and
+// statistics configured in taju.env to the events of syncer.
+func registerSubscribers(syncer *syncer) error {
+	env := syncer.u.env
+	// Hooks and pings go out through the network settings of Strava and Taji.
+	network, err := loadNetworkSettings(env)
+	if err != nil {
+		return err
+	}
+	registerUserHooks(syncer, env, network)
+	registerHealthcheck(syncer, env, network)
+	registerNotifications(syncer, env)
+	if err := registerTeamNotifications(syncer, env, network); err != nil {
+		return err
+	}
+	if err := registerTeamExport(syncer, env); err != nil {
+		return err
+	}
+	registerDecisionLog(syncer)
+	registerMetrics(syncer)
+	registerStats(syncer)
+	return nil
+}
+
+func syncCommand(u *uploader, args []string) {
+	flags := flag.NewFlagSet("sync", flag.ExitOnError)
+	once := flags.Bool("once", false, "run a single sync cycle and exit")
+	headless_flag := flags.Bool("headless", false, "never prompt and log the summary instead of drawing it (TAJU_HEADLESS)")
+	daemon := flags.Bool("daemon", false, "keep syncing on an interval (the default)")
+	emit := flags.String("emit", "", "write one record per activity to stdout (jsonl)")
+	dry_run := flags.Bool("dry-run", false, "show what would change on Taji without changing it")
+	demo := flags.Bool("demo", false, "sync into an empty, in-memory Taji and show its participant page; nothing is saved")
+	confirm_plan := flags.Bool("confirm", false, "show the planned changes and ask before applying them")
+	strict := flags.Bool("strict", false, "stop and exit non-zero on any drift between the ledger, Strava and Taji (TAJU_STRICT)")
+	start := flags.String("start", "", "first day to sync, YYYY-MM-DD (overrides TAJU_EVENT_START)")
+	end := flags.String("end", "", "last day to sync, YYYY-MM-DD (overrides TAJU_EVENT_END)")
+	trace_mapping := flags.Bool("trace-mapping", false, "print how every Strava activity is mapped to the Taji form (to stderr)")
+	every := flags.Duration("interval", SYNC_INTERVAL, "time between syncs, e.g. 30m (overrides TAJU_SYNC_INTERVAL)")
+	flags.Parse(args)
+	if *once && *daemon {
+		log.Fatal("--once and --daemon can't be used together")
+	}
+	u.settings.headless = u.settings.headless || *headless_flag
+
+	interval_set := false
+	flags.Visit(func(f *flag.Flag) { interval_set = interval_set || f.Name == "interval" })
+	if value, ok := u.env["TAJU_SYNC_INTERVAL"]; ok && !interval_set {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			log.Fatal("Invalid TAJU_SYNC_INTERVAL: ", err)
+		}
+		*every = d
+	}
+	if *every < time.Minute {
+		log.Fatal("The sync interval must be at least a minute")
+	}
+
+	// With --emit stdout carries the records alone; the plan and the demo
+	// page go to stderr, and there is no prompt to answer.
+	var emitter *jsonlEmitter
+	out := io.Writer(os.Stdout)
+	switch *emit {
+	case "":
+	case "jsonl":
+		emitter = newJsonlEmitter(os.Stdout, u.clock)
+		out = os.Stderr
+	default:
+		log.Fatal("Unknown emit format: ", *emit)
+	}
+	if emitter != nil && *confirm_plan {
+		log.Fatal("--confirm prompts on stdout, it can't be combined with --emit")
+	}
+
+	// With profiles every one of them is synced in the same loop, one after
+	// the other.
+	profiles := syncProfiles(u)
+	var syncers []*syncer
+	var sinks []*memoryTaji
+	for _, u := range profiles {
+		if u.participant {
+			log.Printf("Participant %s:", u.profile)
+		} else if u.profile != "" {
+			log.Printf("Profile %s:", u.profile)
+		}
+		if err := initStravaAccounts(u); err != nil {
+			log.Fatal(err)
+		}
+		for _, s := range u.accounts {
+			s.trace_mapping = *trace_mapping
+		}
+		if *start != "" || *end != "" {
+			// A custom range (e.g. a backfill) doesn't continue from the cursor.
+			window := maps.Clone(u.env)
+			if *start != "" {
+				window["TAJU_EVENT_START"] = *start
+			}
+			if *end != "" {
+				window["TAJU_EVENT_END"] = *end
+			}
+			window_start, window_end, err := eventWindow(window, u.clock.Now())
+			if err != nil {
+				log.Fatal(err)
+			}
+			for _, s := range u.accounts {
+				s.window_start, s.window_end = window_start, window_end
+				s.cursor, s.custom_window = time.Time{}, true
+			}
+		}
+		if *demo {
+			u.state = memoryState(u.clock)
+		} else {
+			if err := initTajiSession(u); err != nil {
+				log.Fatal(err)
+			}
+		}
+		log.Print("Initialized successfully.")
+
+		syncer, err := newSyncer(u)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if *demo {
+			sink := newMemoryTaji(nil)
+			syncer.taji, syncer.scratch = sink, true
+			sinks = append(sinks, sink)
+		}
+		syncer.dry_run, syncer.plan_out = *dry_run, out
+		syncer.confirm_plan = *confirm_plan
+		syncer.strict = *strict || envBool(u.env, "TAJU_STRICT")
+		if err := registerSubscribers(syncer); err != nil {
+			log.Fatal(err)
+		}
+		if emitter != nil {
+			subscribe(&syncer.events, emitter.emit)
+		}
+		syncers = append(syncers, syncer)
+	}
+
+	// Syncs triggered between scheduled cycles (a Strava push event, the
+	// control endpoint, Enter on the terminal) wake the loop early; one
+	// queued trigger is enough to catch up.
+	triggers := make(chan string, 1)
+	quit := make(chan struct{})
+	var board *dashboard
+	var page *statusPage
+	if !*once {
+		startWebhook(u.env, triggers, u.clock)
+		page = newStatusPage(syncers, triggers, u.clock)
+		startControlServer(u.env, triggers, page)
+		// Confirmations and prompt policies read the terminal themselves.
+		prompts := slices.ContainsFunc(syncers, func(s *syncer) bool {
+			return slices.Contains(slices.Collect(maps.Values(s.policies)), POLICY_PROMPT)
+		})
+		if emitter == nil && !*confirm_plan && !prompts && u.settings.interactive() {
+			single_keys := watchKeypress(triggers, quit)
+			defer func() { restoreKeys() }()
+			// Several profiles get a line each instead of the dashboard.
+			if _, ok := u.env["TAJU_DASHBOARD"]; len(syncers) == 1 && !*demo && (!ok || envBool(u.env, "TAJU_DASHBOARD")) {
+				board = newDashboard(syncers[0])
+				board.single_keys = single_keys
+			}
+		}
+	}
+
+	// The dashboard is redrawn every second while waiting for the countdown.
+	var redraw <-chan time.Time
+	if board != nil {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		redraw = ticker.C
+	}
+
+	quiet, err := loadQuietHours(u.env)
+	if err != nil {
+		log.Fatal(err)
+	}
+	stop := watchShutdown(profiles)
+	// Before the event the daemon only keeps the logins ready.
+	if !*once && !*demo && !waitForEvent(profiles, stop) {
+		flushState(profiles)
+		return
+	}
+	failures := 0
+	for {
+		results := make([]cycleResult, len(syncers))
+		// aborted is set when a signal cut the cycle short.
+		failed, aborted := false, false
+		for i, syncer := range syncers {
+			var err error
+			results[i], err = syncer.run(stopping, syncOptions{})
+			failed = failed || results[i].failed
+			aborted = aborted || err != nil
+		}
+		if failed {
+			failures++
+		} else {
+			failures = 0
+		}
+		interval := quiet.delay(u.clock.Now(), syncInterval(*every, failures))
+		if page != nil {
+			page.synced(u.clock.Now(), u.clock.Now().Add(interval))
+		}
+		if board != nil {
+			board.synced(u.clock.Now(), u.clock.Now().Add(interval))
+			board.draw(u.clock.Now())
+		} else if u.settings.headless {
+			for i, p := range profiles {
+				logCycle(p, results[i], interval)
+			}
+		} else if emitter == nil && len(profiles) > 1 {
+			for i, p := range profiles {
+				printProfileCycle(p, results[i])
+			}
+			fmt.Printf("Next sync at %s\n", u.settings.display.clock(u.clock.Now().Add(interval)))
+		} else if emitter == nil {
+			updateOutput(u.clock.Now(), results[0], profiles[0].scoring, &profiles[0].taji.transfer, interval)
+		}
+		for _, sink := range sinks {
+			sink.writePage(out)
+		}
+		if slices.ContainsFunc(results, func(r cycleResult) bool { return len(r.inconsistencies) > 0 }) {
+			// The ledger stays as it was, only refreshed tokens are kept.
+			for _, p := range profiles {
+				dumpEnvFile(p)
+			}
+			restoreKeys()
+			os.Exit(EXIT_INCONSISTENT)
+		}
+		stopping := false
+		select {
+		case <-stop:
+			stopping = true
+		default:
+		}
+		if *once || stopping {
+			flushState(profiles)
+			if aborted {
+				restoreKeys()
+				os.Exit(EXIT_ABORTED)
+			}
+			if failed {
+				restoreKeys()
+				os.Exit(1)
+			}
+			return
+		}
+		wait := u.clock.After(interval)
+	waiting:
+		for {
+			select {
+			case <-wait:
+				break waiting
+			case reason := <-triggers:
+				log.Print("Syncing now: ", reason)
+				break waiting
+			case <-quit:
+				flushState(profiles)
+				return
+			case <-stop:
+				flushState(profiles)
+				if failed {
+					restoreKeys()
+					os.Exit(1)
+				}
+				return
+			case <-redraw:
+				board.draw(u.clock.Now())
+			}
+		}
+	}
+}
+
+func deleteCommand(u *uploader, args []string) {
+	if len(args) != 1 {
+		log.Fatal("Usage: taju delete <log id>")
+	}
+	if err := initTajiSession(u); err != nil {
+		log.Fatal(err)
+	}
+	if !confirm(fmt.Sprintf("Delete Taji entry %s?", args[0])) {
+		return
+	}
+	if err := deleteTajiEntry(&u.taji, args[0]); err != nil {
+		log.Fatal(err)
+	}
+	log.Print("Deleted Taji entry ", args[0])
+}
+
+func authCommand(u *uploader, args []string) {
+	if len(args) < 1 {
+		log.Fatal("Usage: taju auth strava [account] | taju auth taji")
+	}
+	switch args[0] {
+	case "strava":
+		flags := flag.NewFlagSet("auth strava", flag.ExitOnError)
+		code := flags.String("code", "", "authorization code, or the whole address, copied from the redirect, for machines without a browser")
+		name := DEFAULT_ACCOUNT
+		if len(args) > 1 && !strings.HasPrefix(args[1], "-") {
+			name, args = args[1], args[1:]
+		}
+		flags.Parse(args[1:])
+		delete(u.env, stravaTokenKey(name))
+		delete(u.env, stravaScopeKey(name))
+		if *code != "" {
+			exchangeStravaCode(u.env, name, *code)
+			break
+		}
+		if err := initStrava(u.env, &strava{settings: u.settings}, name); err != nil {
+			log.Fatal(err)
+		}
+	case "taji":
+		delete(u.env, "TAJI_CSRF")
+		delete(u.env, "TAJI_SESSION")
+		delete(u.env, "TAJI_PARTICIPANT")
+		if err := initTaji(u.env, &u.taji); err != nil {
+			log.Fatal(err)
+		}
+	default:
+		log.Fatal("Unknown auth target: ", args[0])
+	}
+	dumpEnvFile(u)
+}
+
+// exchangeStravaCode completes the authorization of an account with a code
+// obtained on another device, see initStrava. The whole redirect address
+// can be given instead, which also tells the scope that was granted.
+func exchangeStravaCode(env map[string]string, name string, code string) {
+	code, scope := redirectGrant(code)
+	token, err := stravaConfig(env).Exchange(context.Background(), code)
+	if err != nil {
+		log.Fatal(err)
+	}
+	data, _ := json.Marshal(token)
+	env[stravaTokenKey(name)] = string(data)
+	storeStravaScope(env, &strava{name: name}, scope)
+	log.Printf("Authorized Strava account %q", name)
+}
+
+func statusCommand(u *uploader) {
+	if u.profile == "" && len(participantNames(u.env)) > 0 {
+		// The captain first, then every participant they log for.
+		for _, p := range syncProfiles(u) {
+			if p.participant {
+				fmt.Printf("Participant %s:\n", p.profile)
+			}
+			printStatus(p)
+		}
+		return
+	}
+	if u.profile != "" || len(profileNames(u.env)) == 0 {
+		printStatus(u)
+		return
+	}
+	for _, name := range profileNames(u.env) {
+		fmt.Printf("Profile %s:\n", name)
+		printStatus(loadProfile(u, name))
+	}
+}
+
+func printStatus(u *uploader) {
+	syncing := u.syncAccounts()
+	fmt.Println("Strava accounts:")
+	for _, name := range stravaAccounts(u.env) {
+		_, authorized := u.env[stravaTokenKey(name)]
+		cursor := u.env[stravaCursorKey(name)]
+		if cursor == "" {
+			cursor = "none"
+		}
+		full_fetch := u.env[stravaFullFetchKey(name)]
+		if full_fetch == "" {
+			full_fetch = "never"
+		}
+		fmt.Printf("  %-12s authorized=%-5t sync=%-5t cursor=%s full fetch=%s\n", name, authorized, slices.Contains(syncing, name), cursor, full_fetch)
+	}
+
+	_, session := u.env["TAJI_SESSION"]
+	fmt.Println("Taji:")
+	fmt.Printf("  logged in=%t participant=%s\n", session, u.env["TAJI_PARTICIPANT"])
+	if breaker := u.state.breaker(); breaker.open() {
+		fmt.Printf("  failing since %s, next try at %s: %s\n", u.settings.display.clock(breaker.Opened.Local()),
+			u.settings.display.clock(breaker.RetryAt.Local()), breaker.Reason)
+	}
+	if standings := u.state.standings(); standings != nil {
+		fmt.Printf("  %s (as of %s)\n", standings.summary(u.settings.display), u.settings.display.clock(standings.Checked.Local()))
+	}
+	if taji_only := u.state.tajiOnlyRuns(u.settings, nil); len(taji_only) > 0 {
+		miles := 0.0
+		for _, run := range taji_only {
+			miles += meter2mile(run.distance_float)
+		}
+		fmt.Printf("  %d entries logged on the site, %.2f %s\n", len(taji_only), u.settings.display.fromMiles(miles), u.settings.display.name())
+	}
+
+	if queued := u.state.queued(); len(queued) > 0 {
+		fmt.Printf("Pending uploads: %d (kept until Taji takes them)\n", len(queued))
+		for _, upload := range queued {
+			fmt.Printf("  strava %d %s %s %s: %d failed posts, next try %s: %s\n", upload.StravaId, upload.Activity, upload.Date, upload.Time,
+				upload.Attempts, u.settings.display.clock(upload.NextAttempt.Local()), upload.Error)
+		}
+	}
+	if failed := u.state.failures(); len(failed) > 0 {
+		fmt.Println("Failed posts (retried on the next sync):")
+		for _, entry := range failed {
+			fmt.Printf("  strava %d %s %s %s: %s\n", entry.StravaId, entry.Activity, entry.Date, entry.Time, entry.Error)
+		}
+	}
+}
+
+// logCycle is the headless replacement for the summary screen: one
+// structured log record per cycle that log collectors can parse.
+func logCycle(u *uploader, result cycleResult, interval time.Duration) {
+	progress := result.progress
+	var profile []any
+	if u.participant {
+		profile = []any{"participant", u.profile}
+	} else if u.profile != "" {
+		profile = []any{"profile", u.profile}
+	}
+	slog.Info("sync cycle", append(profile,
+		"failed", result.failed,
+		"activities", len(result.activities),
+		"taji_events", len(result.events),
+		"planned", len(result.plan),
+		"posted", len(result.posted),
+		"taji_requests", result.taji_requests,
+		"miles", math.Round(progress.done*100)/100,
+		"recorded_miles", math.Round(result.recorded_miles*100)/100,
+		"goal_percent", math.Round(progress.percent*10)/10,
+		"elevation_feet", math.Round(result.climb.done),
+		"team_rank", standingsRank(result.standings),
+		"pending_uploads", u.state.queuedCount(),
+		"next_sync", u.clock.Now().Add(interval).Format(time.RFC3339),
+	)...)
+}
@@ -0,0 +1,71 @@
+package taju
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/tajuploader/config"
+)
+
+func configCommand(args []string) {
+	if len(args) == 1 && args[0] == "validate" {
+		if !validateConfig() {
+			os.Exit(1)
+		}
+		return
+	}
+	if len(args) != 1 || args[0] != "docs" {
+		log.Fatal("Usage: taju config docs|validate")
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY\tTYPE\tDEFAULT\tDESCRIPTION")
+	for _, field := range config.Fields() {
+		key := field.Tag.Get("env")
+		if strings.HasSuffix(key, "_") {
+			key += "<NAME>"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", key, config.Type(field), field.Tag.Get("default"), field.Tag.Get("doc"))
+	}
+	w.Flush()
+}
+
+// validateEnvFile checks the keys and values in taju.env, and the
+// participants they set up.
+func validateEnvFile(env map[string]string) []config.Error {
+	errs := config.ValidateEnv(env, ENV_FILENAME)
+	if err := checkParticipants(env); err != nil {
+		errs = append(errs, config.Error{Source: ENV_FILENAME, Key: "TAJU_PARTICIPANTS", Msg: err.Error()})
+	}
+	return errs
+}
+
+// validateConfig is taju config validate: it checks the config file, the
+// environment overrides and taju.env.
+func validateConfig() bool {
+	layer, errs := config.ReadLayer()
+	if env, err := config.ReadEnvFile(ENV_FILENAME); err != nil {
+		errs = append(errs, config.Error{Source: ENV_FILENAME, Msg: err.Error()})
+	} else {
+		errs = append(errs, validateEnvFile(env)...)
+	}
+	for _, err := range errs {
+		fmt.Println(err)
+	}
+	if len(errs) > 0 {
+		if len(errs) == 1 {
+			fmt.Println("1 problem found.")
+		} else {
+			fmt.Printf("%d problems found.\n", len(errs))
+		}
+		return false
+	}
+	path := config.FilePath()
+	if path == "" {
+		path = "no config file"
+	}
+	fmt.Printf("Settings are valid (%s, %d from the config file and environment).\n", path, len(layer))
+	return true
+}
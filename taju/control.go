@@ -0,0 +1,110 @@
+package taju
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// startControlServer lets a sync be forced between scheduled cycles with
+// POST /sync on TAJU_CONTROL_ADDR (localhost:9191 by default, "off" turns
+// it off), e.g. curl -X POST localhost:9191/sync after finishing a run. It
+// also serves the status page on /, Prometheus metrics on /metrics, the
+// daily distance for Grafana on /daily and a liveness check on /healthz.
+func startControlServer(env map[string]string, triggers chan<- string, page *statusPage) {
+	addr, ok := env["TAJU_CONTROL_ADDR"]
+	if !ok {
+		addr = fmt.Sprintf("localhost:%d", PORT)
+	}
+	if addr == "" || addr == "off" {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/sync", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		queueSync(triggers, "requested over HTTP")
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprintln(w, "Sync queued.")
+	})
+	mux.HandleFunc("GET /metrics", serveMetrics)
+	mux.HandleFunc("GET /healthz", serveHealthz)
+	page.register(mux)
+
+	server := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		log.Print("Control server stopped: ", server.ListenAndServe())
+	}()
+}
+
+// restoreKeys puts the terminal back to reading lines after watchKeypress
+// switched it to single keys. The sync command calls it on every way out,
+// since a shell left without echo is unusable.
+var restoreKeys = func() {}
+
+// watchKeypress queues a sync whenever Enter or s is pressed on the
+// terminal, and closes quit when q is. Where the terminal can't read single
+// keys (see singleKeys) q has to be followed by Enter; single reports which
+// it is. The caller checks that someone is at the terminal.
+func watchKeypress(triggers chan<- string, quit chan<- struct{}) (single bool) {
+	restore, err := singleKeys(os.Stdin)
+	if err != nil {
+		go readKeyLines(os.Stdin, triggers, quit)
+		return false
+	}
+	restoreKeys = sync.OnceFunc(restore)
+	go readKeys(os.Stdin, triggers, quit)
+	return true
+}
+
+func readKeys(in io.Reader, triggers chan<- string, quit chan<- struct{}) {
+	reader := bufio.NewReader(in)
+	for {
+		key, err := reader.ReadByte()
+		if err != nil {
+			return
+		}
+		switch key {
+		case 'q', 'Q':
+			close(quit)
+			return
+		case '\r', '\n':
+			queueSync(triggers, "Enter was pressed")
+		case 's', 'S':
+			queueSync(triggers, "s was pressed")
+		}
+	}
+}
+
+func readKeyLines(in io.Reader, triggers chan<- string, quit chan<- struct{}) {
+	reader := bufio.NewReader(in)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		if strings.EqualFold(strings.TrimSpace(line), "q") {
+			close(quit)
+			return
+		}
+		queueSync(triggers, "Enter was pressed")
+	}
+}
+
+// queueSync asks the sync loop to run a cycle now. If one is already queued
+// the request is dropped, the queued cycle covers it.
+func queueSync(triggers chan<- string, reason string) {
+	select {
+	case triggers <- reason:
+	default:
+	}
+}
@@ -0,0 +1,53 @@
+package taju
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestReadKeys(t *testing.T) {
+	tests := []struct {
+		name  string
+		read  func(io.Reader, chan<- string, chan<- struct{})
+		input string
+		want  string
+		quit  bool
+	}{
+		{name: "s", read: readKeys, input: "s", want: "s was pressed"},
+		{name: "Enter", read: readKeys, input: "\n", want: "Enter was pressed"},
+		{name: "Enter on a Windows console", read: readKeys, input: "\r", want: "Enter was pressed"},
+		{name: "other keys", read: readKeys, input: "xyz"},
+		{name: "q", read: readKeys, input: "q", quit: true},
+		{name: "nothing after q", read: readKeys, input: "qs", quit: true},
+		{name: "line mode Enter", read: readKeyLines, input: "\n", want: "Enter was pressed"},
+		{name: "line mode q", read: readKeyLines, input: "q\n", quit: true},
+		{name: "line mode q without Enter", read: readKeyLines, input: "q"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			triggers, quit := make(chan string, 1), make(chan struct{})
+			test.read(strings.NewReader(test.input), triggers, quit)
+			select {
+			case reason := <-triggers:
+				if reason != test.want {
+					t.Errorf("queued %q, want %q", reason, test.want)
+				}
+			default:
+				if test.want != "" {
+					t.Errorf("nothing queued, want %q", test.want)
+				}
+			}
+			select {
+			case <-quit:
+				if !test.quit {
+					t.Error("quit, want to keep going")
+				}
+			default:
+				if test.quit {
+					t.Error("didn't quit")
+				}
+			}
+		})
+	}
+}
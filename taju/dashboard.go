@@ -0,0 +1,125 @@
+package taju
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const DASHBOARD_RECENT = 8
+const DASHBOARD_ERRORS = 3
+const PROGRESS_WIDTH = 40
+
+type dashboardRow struct {
+	run      runDetails
+	decision string
+	result   string
+}
+
+// dashboard is the status screen of an interactive sync. It is redrawn
+// every second while waiting so the countdown stays current, and replaces
+// the plain summary when stdout is a terminal (TAJU_DASHBOARD=false keeps
+// the plain summary).
+type dashboard struct {
+	mu     sync.Mutex
+	last   time.Time
+	next   time.Time
+	result cycleResult
+	recent []dashboardRow
+	errors []string
+	u      *uploader
+	// single_keys is set when the terminal reads single keys, see
+	// watchKeypress.
+	single_keys bool
+}
+
+func newDashboard(s *syncer) *dashboard {
+	d := &dashboard{u: s.u}
+	subscribe(&s.events, func(e activityDecided) {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		d.recent = append(d.recent, dashboardRow{e.run, e.decision, e.result})
+		if len(d.recent) > DASHBOARD_RECENT {
+			d.recent = d.recent[len(d.recent)-DASHBOARD_RECENT:]
+		}
+	})
+	subscribe(&s.events, func(e errorOccurred) {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		d.errors = append(d.errors, redact(e.err.Error()))
+		if len(d.errors) > DASHBOARD_ERRORS {
+			d.errors = d.errors[len(d.errors)-DASHBOARD_ERRORS:]
+		}
+	})
+	subscribe(&s.events, func(e cycleCompleted) {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		d.result = e.result
+	})
+	return d
+}
+
+// synced records when the cycle finished and when the next one is due.
+func (d *dashboard) synced(now time.Time, next time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.last, d.next = now, next
+}
+
+func (d *dashboard) draw(now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	display := d.u.settings.display
+	var screen strings.Builder
+	fmt.Fprintf(&screen, "Taji Uploader                         last sync %s\n\n", display.clock(d.last.Local()))
+
+	for _, progress := range d.result.goals() {
+		filled := int(min(progress.done/progress.target, 1) * PROGRESS_WIDTH)
+		fmt.Fprintf(&screen, "[%s%s] %s / %s\n",
+			strings.Repeat("#", filled), strings.Repeat("-", PROGRESS_WIDTH-filled), progress.format(progress.done, 2), progress.format(progress.target, 0))
+		fmt.Fprintln(&screen, progress.summary())
+	}
+	if d.result.encouragement != "" {
+		fmt.Fprintln(&screen, d.result.encouragement)
+	}
+	if d.result.standings != nil {
+		fmt.Fprintln(&screen, d.result.standings.summary(display))
+	}
+	scoring := d.u.scoring
+	for _, total := range d.result.totals {
+		fmt.Fprintf(&screen, "  %-6s %3d events  %7.2f %s", total.activity, total.count, display.fromMiles(total.miles), display.name())
+		if scoring != nil {
+			fmt.Fprintf(&screen, "  %7.1f %s", total.points, scoring.Unit)
+		}
+		screen.WriteString("\n")
+	}
+
+	screen.WriteString("\nRecent activities:\n")
+	if len(d.recent) == 0 {
+		screen.WriteString("  none yet\n")
+	}
+	for i := len(d.recent) - 1; i >= 0; i-- {
+		row := d.recent[i]
+		fmt.Fprintf(&screen, "  %-10s %-8s %-6s %6s %s %9s  %s (%s)\n",
+			row.run.date, row.run.time, row.run.activity, row.run.distance, d.u.settings.taji_units.distance, row.run.duration, row.decision, row.result)
+	}
+
+	if len(d.errors) > 0 {
+		screen.WriteString("\nErrors:\n")
+		for _, err := range d.errors {
+			fmt.Fprintf(&screen, "  %s\n", err)
+		}
+	}
+
+	keys := "Enter: sync now   q Enter: quit"
+	if d.single_keys {
+		keys = "s or Enter: sync now   q: quit"
+	}
+	fmt.Fprintf(&screen, "\nNext sync in %s.  %s\n", max(d.next.Sub(now), 0).Round(time.Second), keys)
+
+	clearScreen()
+	os.Stdout.WriteString(screen.String())
+}
@@ -0,0 +1,109 @@
+package taju
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+)
+
+// dedupeCommand finds Taji entries logged more than once (same date, time
+// and distance) and deletes the extras. The entry to keep is the one the
+// uploader posted (it carries an idempotency key), otherwise the oldest.
+func dedupeCommand(u *uploader, args []string) {
+	flags := flag.NewFlagSet("dedupe", flag.ExitOnError)
+	yes := flags.Bool("yes", false, "delete the duplicates without asking")
+	flags.Parse(args)
+
+	if err := initTajiSession(u); err != nil {
+		log.Fatal(err)
+	}
+	entries, err := getTajiEntries(&u.taji)
+	if err != nil {
+		log.Fatal(err)
+	}
+	events, err := getTajiEvents(&u.taji, entries)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	groups := make(map[tajiEvent][]tajiEvent)
+	var order []tajiEvent
+	for _, event := range events {
+		group := tajiEvent{date: event.date, time: event.time, distance: event.distance}
+		if _, ok := groups[group]; !ok {
+			order = append(order, group)
+		}
+		groups[group] = append(groups[group], event)
+	}
+
+	var extras []tajiEvent
+	for _, group := range order {
+		duplicates := groups[group]
+		if len(duplicates) < 2 {
+			continue
+		}
+		slices.SortStableFunc(duplicates, func(a, b tajiEvent) int {
+			if (a.key != "") != (b.key != "") {
+				if a.key != "" {
+					return -1
+				}
+				return 1
+			}
+			return compareLogIds(a.entry, b.entry)
+		})
+		fmt.Printf("%s %s %s mi:\n", group.date, group.time, group.distance)
+		fmt.Printf("  keep   entry %s %s\n", duplicates[0].entry, duplicates[0].duration)
+		for _, event := range duplicates[1:] {
+			fmt.Printf("  delete entry %s %s\n", event.entry, event.duration)
+		}
+		extras = append(extras, duplicates[1:]...)
+	}
+
+	if len(extras) == 0 {
+		fmt.Println("No duplicate Taji entries.")
+		return
+	}
+	if !*yes && !confirm(fmt.Sprintf("Delete these %d duplicate entries?", len(extras))) {
+		return
+	}
+
+	failed := false
+	for _, event := range extras {
+		if err := deleteTajiEntry(&u.taji, event.entry); err != nil {
+			log.Print("Error:", err)
+			failed = true
+			continue
+		}
+		log.Print("Deleted Taji entry ", event.entry)
+	}
+	// Ledger entries pointing at deleted entries are dropped on the next
+	// read of the participant page.
+	if entries, err := getTajiEntries(&u.taji); err == nil {
+		u.state.forgetVanished(entries)
+	}
+	if err := u.state.save(); err != nil {
+		log.Print("Error:", err)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// compareLogIds orders Taji log ids numerically, which is the order they
+// were created in.
+func compareLogIds(a, b string) int {
+	x, err_x := strconv.Atoi(a)
+	y, err_y := strconv.Atoi(b)
+	if err_x != nil || err_y != nil {
+		if a < b {
+			return -1
+		} else if a > b {
+			return 1
+		}
+		return 0
+	}
+	return x - y
+}
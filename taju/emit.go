@@ -0,0 +1,56 @@
+package taju
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+type activityRecord struct {
+	Time     time.Time `json:"time"`
+	Decision string    `json:"decision"`
+	Activity string    `json:"activity"`
+	Date     string    `json:"date"`
+	Start    string    `json:"start"`
+	Distance string    `json:"distance"`
+	Duration string    `json:"duration"`
+	Result   string    `json:"result"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// jsonlEmitter writes one JSON object per processed activity so sync output
+// can be piped into jq or loaded elsewhere.
+type jsonlEmitter struct {
+	mu    sync.Mutex
+	enc   *json.Encoder
+	clock clock
+}
+
+func newJsonlEmitter(w io.Writer, c clock) *jsonlEmitter {
+	return &jsonlEmitter{enc: json.NewEncoder(w), clock: c}
+}
+
+func (e *jsonlEmitter) emit(decided activityDecided) {
+	run := decided.run
+	record := activityRecord{
+		Time:     e.clock.Now(),
+		Decision: decided.decision,
+		Activity: run.activity,
+		Date:     run.date,
+		Start:    run.time,
+		Distance: run.distance,
+		Duration: run.duration,
+		Result:   decided.result,
+	}
+	if decided.err != nil {
+		record.Error = decided.err.Error()
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.enc.Encode(record); err != nil {
+		log.Print("Failed to emit record: ", err)
+	}
+}
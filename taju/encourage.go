@@ -0,0 +1,165 @@
+package taju
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const MESSAGES_FILENAME string = "taju.messages.json"
+
+// Situations an encouragement is picked for, most specific first.
+const (
+	MOOD_COMPLETE    string = "complete"
+	MOOD_MILESTONE   string = "milestone"
+	MOOD_FIRST_DAY   string = "first_day"
+	MOOD_HALFWAY_DAY string = "halfway_day"
+	MOOD_LAST_DAY    string = "last_day"
+	MOOD_BEHIND      string = "behind"
+	MOOD_AHEAD       string = "ahead"
+	MOOD_ON_PACE     string = "on_pace"
+)
+
+// MILESTONE_STEP is the share of the goal, in percent, that makes a
+// milestone when crossed.
+const MILESTONE_STEP = 25
+
+// encouragements are the messages shown under the goal progress in the
+// summary, the dashboard and notifications. A message file
+// (TAJU_MESSAGES_FILE, taju.messages.json by default) replaces the built-in
+// messages of the situations it lists, an empty list silences one:
+//
+//	{
+//	  "behind": ["{daily} a day and you're back on track."],
+//	  "milestone": ["{milestone} done!"],
+//	  "on_pace": []
+//	}
+//
+// Messages can use {done}, {target}, {remaining}, {daily}, {percent},
+// {milestone} (the last one passed), {days_left} and {finish}. TAJU_ENCOURAGEMENT=false turns them off.
+type encouragements map[string][]string
+
+var DEFAULT_ENCOURAGEMENTS = encouragements{
+	MOOD_COMPLETE:    {"Goal reached with {done} logged. Everything from here is a bonus!"},
+	MOOD_MILESTONE:   {"{milestone} of the way there, that's a milestone. Keep it rolling!", "You just passed {milestone} of your goal. Nice work!"},
+	MOOD_FIRST_DAY:   {"Day one! Every mile of the {target} starts with this one."},
+	MOOD_HALFWAY_DAY: {"Halfway through the event and {percent} of the way to your goal."},
+	MOOD_LAST_DAY:    {"Last day! {remaining} to go, leave it all out there."},
+	MOOD_BEHIND:      {"A little behind pace: {daily} a day gets you there. You've got this.", "{remaining} to go in {days_left} days. One run at a time."},
+	MOOD_AHEAD:       {"Ahead of pace, on track to finish by {finish}. Great work!", "You're ahead of schedule with {remaining} to go."},
+	MOOD_ON_PACE:     {"Right on pace. Keep it steady.", "Steady does it: {daily} a day to finish on time."},
+}
+
+func loadEncouragements(env map[string]string) (encouragements, error) {
+	if value, ok := env["TAJU_ENCOURAGEMENT"]; ok {
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TAJU_ENCOURAGEMENT=%q, expected true or false", value)
+		}
+		if !enabled {
+			return nil, nil
+		}
+	}
+	messages := make(encouragements)
+	for mood, list := range DEFAULT_ENCOURAGEMENTS {
+		messages[mood] = list
+	}
+	path := env["TAJU_MESSAGES_FILE"]
+	if path == "" {
+		path = MESSAGES_FILENAME
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && env["TAJU_MESSAGES_FILE"] == "" {
+		return messages, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error loading %s: %w", path, err)
+	}
+	var custom encouragements
+	if err := json.Unmarshal(data, &custom); err != nil {
+		return nil, fmt.Errorf("error loading %s: %w", path, err)
+	}
+	for mood, list := range custom {
+		if _, ok := DEFAULT_ENCOURAGEMENTS[mood]; !ok {
+			return nil, fmt.Errorf("error loading %s: unknown situation %q", path, mood)
+		}
+		messages[mood] = list
+	}
+	return messages, nil
+}
+
+// mood picks the situation of a goal status. before is the status without
+// today's activities, to spot a milestone crossed today.
+func mood(status goalStatus, before goalStatus, now time.Time, start time.Time) string {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	event_days := int(math.Round(status.window_end.Sub(start).Hours() / 24))
+	day := int(math.Floor(today.Sub(start).Hours() / 24))
+	switch {
+	case status.done >= status.target:
+		return MOOD_COMPLETE
+	case int(status.percent)/MILESTONE_STEP > int(before.percent)/MILESTONE_STEP:
+		return MOOD_MILESTONE
+	case day == 0:
+		return MOOD_FIRST_DAY
+	case day == event_days-1:
+		return MOOD_LAST_DAY
+	case day == event_days/2:
+		return MOOD_HALFWAY_DAY
+	case status.projected.IsZero() || day < 0 || day >= event_days:
+		return ""
+	case status.behind():
+		return MOOD_BEHIND
+	case status.projected.Before(status.window_end.AddDate(0, 0, -2)):
+		return MOOD_AHEAD
+	}
+	return MOOD_ON_PACE
+}
+
+// pick returns one of the mood's messages with its placeholders filled.
+// The message changes once a day rather than with every redraw.
+func (e encouragements) pick(mood string, status goalStatus, now time.Time) string {
+	messages := e[mood]
+	if len(messages) == 0 {
+		return ""
+	}
+	message := messages[now.YearDay()%len(messages)]
+	days_left := int(math.Ceil(status.window_end.Sub(now).Hours() / 24))
+	finish := ""
+	if !status.projected.IsZero() {
+		finish = status.projected.Local().Format("Jan 2")
+	}
+	return strings.NewReplacer(
+		"{done}", status.format(status.done, 2),
+		"{target}", status.format(status.target, 0),
+		"{remaining}", status.format(max(status.target-status.done, 0), 2),
+		"{daily}", status.format(status.daily_needed, 2),
+		"{percent}", fmt.Sprintf("%.0f%%", status.percent),
+		"{milestone}", fmt.Sprintf("%d%%", int(status.percent)/MILESTONE_STEP*MILESTONE_STEP),
+		"{days_left}", fmt.Sprint(max(days_left, 0)),
+		"{finish}", finish,
+	).Replace(message)
+}
+
+// encouragement is the message for the uploader's distance goal right now,
+// "" when there is nothing to say.
+func encouragement(u *uploader, activities []runDetails) string {
+	if u.messages == nil {
+		return ""
+	}
+	now, start, end := goalWindow(u)
+	today := now.Format(DATE_FORMAT)
+	var earlier []runDetails
+	for _, run := range activities {
+		if run.date < today {
+			earlier = append(earlier, run)
+		}
+	}
+	status := u.goal.status(activities, now, start, end)
+	before := u.goal.status(earlier, now, start, end)
+	return u.messages.pick(mood(status, before, now, start), status, now)
+}
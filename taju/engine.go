@@ -0,0 +1,202 @@
+package taju
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	tajusync "github.com/tajuploader/sync"
+)
+
+// The sync engine can be driven by a frontend other than the sync command
+// (a GUI, a bridge to a phone app) through the tajusync API: NewSyncer
+// returns the engine of a configuration, and each Run runs one cycle with
+// the frontend's callbacks and stops it when the context is cancelled.
+// examples/embed is a complete program. Inside the package, commands use
+// syncer.run with syncOptions the same way.
+
+// Syncer is the tajusync.Engine of one taju configuration.
+type Syncer struct {
+	s *syncer
+}
+
+// NewSyncer loads the configuration of config the way the taju command
+// does, and logs in to the sites it doesn't replace. An invalid
+// configuration or a failed login is returned as the error.
+func NewSyncer(config tajusync.Config) (*Syncer, error) {
+	u := &uploader{profile: config.Profile, headless: true}
+	if err := initUploader(u); err != nil {
+		return nil, err
+	}
+	if len(config.Strava) == 0 {
+		if err := initStravaAccounts(u); err != nil {
+			return nil, err
+		}
+	}
+	if config.Taji == nil {
+		if err := initTajiSession(u); err != nil {
+			return nil, err
+		}
+	}
+	s, err := newSyncer(u)
+	if err != nil {
+		return nil, err
+	}
+	if err := useSites(s, config); err != nil {
+		return nil, err
+	}
+	s.strict = envBool(u.env, "TAJU_STRICT")
+	if err := registerSubscribers(s); err != nil {
+		return nil, err
+	}
+	return &Syncer{s}, nil
+}
+
+// Run runs one sync cycle with the callbacks of options, and saves the
+// ledger and any refreshed logins. Cancelling ctx cuts short the requests
+// in flight, except a post already sent, and stops the cycle before its
+// next change to Taji; the error is then ctx's.
+func (s *Syncer) Run(ctx context.Context, options tajusync.Options) (tajusync.Result, error) {
+	result, err := s.s.run(ctx, syncOptions{
+		dry_run: options.DryRun,
+		activity: func(run runDetails) {
+			if options.Activity != nil {
+				options.Activity(activityOf(run))
+			}
+		},
+		decided: func(d syncDecision) {
+			if options.Decided != nil {
+				options.Decided(tajusync.Decision{Decision: d.Decision, Activity: activityOf(d.Run), Result: d.Result, Err: d.Err})
+			}
+		},
+		posted: func(run runDetails, err error) {
+			if options.Posted != nil {
+				options.Posted(activityOf(run), err)
+			}
+		},
+		error: options.Error,
+	})
+	flushState([]*uploader{s.s.u})
+	summary := tajusync.Result{Partial: result.partial, Failed: result.failed}
+	for _, run := range result.activities {
+		summary.Activities = append(summary.Activities, activityOf(run))
+	}
+	for _, run := range result.posted {
+		summary.Posted = append(summary.Posted, activityOf(run))
+	}
+	return summary, err
+}
+
+func activityOf(run runDetails) tajusync.Activity {
+	return tajusync.Activity{StravaID: run.strava_id, Part: run.part, Start: run.start, Type: run.activity,
+		Distance: run.distance_float, Duration: time.Duration(run.duration_int) * time.Second, Elevation: run.elevation_float}
+}
+
+// syncDecision is what the cycle decided for an activity, or the outcome of
+// an action: decision is post, update, delete or skip, result what came of
+// it ("posted", "dry run", "cancelled"...).
+type syncDecision struct {
+	Decision string
+	Run      runDetails
+	Result   string
+	Err      error
+}
+
+// syncOptions are the callbacks of one run, all optional. They are called
+// from the cycle and the post workers, so they must be quick and safe for
+// concurrent use, like any subscriber of the syncer's events.
+type syncOptions struct {
+	dry_run  bool
+	activity func(runDetails)
+	decided  func(syncDecision)
+	posted   func(run runDetails, err error)
+	error    func(error)
+}
+
+// runState is the run in flight, nil between runs.
+type runState struct {
+	mu      sync.Mutex
+	ctx     context.Context
+	options *syncOptions
+	once    sync.Once
+}
+
+// run runs one cycle with the callbacks of options. Cancelling ctx cuts
+// short the requests in flight and stops the cycle before its next change
+// to Taji; the changes it didn't make are decided as "cancelled" and come
+// up again in the next cycle, and the ledger is saved as usual. The error
+// is ctx's if the run was cancelled.
+func (s *syncer) run(ctx context.Context, options syncOptions) (cycleResult, error) {
+	s.current.once.Do(func() {
+		subscribe(&s.events, func(e activityDiscovered) {
+			if o := s.current.get(); o != nil && o.activity != nil {
+				o.activity(e.run)
+			}
+		})
+		subscribe(&s.events, func(e activityDecided) {
+			if o := s.current.get(); o != nil && o.decided != nil {
+				o.decided(syncDecision{e.decision, e.run, e.result, e.err})
+			}
+		})
+		subscribe(&s.events, func(e entryPosted) {
+			if o := s.current.get(); o != nil && o.posted != nil {
+				o.posted(e.run, e.err)
+			}
+		})
+		subscribe(&s.events, func(e errorOccurred) {
+			if o := s.current.get(); o != nil && o.error != nil {
+				o.error(e.err)
+			}
+		})
+	})
+
+	// The options belong to this run until its cycle ends, a concurrent run
+	// waits for it before setting its own.
+	s.running.Lock()
+	defer s.running.Unlock()
+	dry_run := s.dry_run
+	s.dry_run = s.dry_run || options.dry_run
+	s.current.set(ctx, &options)
+	s.bindContext(ctx)
+	defer func() {
+		s.bindContext(nil)
+		s.current.set(nil, nil)
+		s.dry_run = dry_run
+	}()
+	result := s.runCycle()
+	return result, ctx.Err()
+}
+
+// bindContext sends the requests of the sites under ctx, nil for none, so
+// cancelling a run also cuts short the requests it has in flight.
+func (s *syncer) bindContext(ctx context.Context) {
+	if site, ok := s.taji.(contextService); ok {
+		site.bindContext(ctx)
+	}
+	for _, account := range s.strava {
+		if site, ok := account.(contextService); ok {
+			site.bindContext(ctx)
+		}
+	}
+}
+
+func (r *runState) set(ctx context.Context, options *syncOptions) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ctx, r.options = ctx, options
+}
+
+func (r *runState) get() *syncOptions {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.options
+}
+
+// cancelled reports whether the run in flight was cancelled, false for
+// cycles not started with run.
+func (s *syncer) cancelled() bool {
+	s.current.mu.Lock()
+	ctx := s.current.ctx
+	s.current.mu.Unlock()
+	return ctx != nil && ctx.Err() != nil
+}
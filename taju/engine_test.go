@@ -0,0 +1,265 @@
+package taju
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	tajusync "github.com/tajuploader/sync"
+	"github.com/tajuploader/tajiclient"
+	"golang.org/x/oauth2"
+)
+
+func TestSyncerRun(t *testing.T) {
+	tests := []struct {
+		name    string
+		dry_run bool
+		// cancel cancels the run once its activities are fetched.
+		cancel bool
+		posted int
+		result string
+		err    error
+	}{
+		{name: "posts the activities", posted: 2, result: "posted"},
+		{name: "dry run", dry_run: true, result: "dry run"},
+		{name: "cancelled", cancel: true, err: context.Canceled},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			strava := &fakeStrava{runs: []runDetails{
+				testRun(t, 1, "2026-02-10T07:00:00Z", 1800, 5000),
+				testRun(t, 2, "2026-02-11T07:00:00Z", 2400, 6000),
+			}}
+			taji := &fakeTaji{}
+			s := &Syncer{newTestSyncer(t, map[string]string{}, taji, strava)}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			var mu sync.Mutex
+			var seen []int64
+			var results []string
+			result, err := s.Run(ctx, tajusync.Options{
+				DryRun: test.dry_run,
+				Activity: func(a tajusync.Activity) {
+					mu.Lock()
+					defer mu.Unlock()
+					seen = append(seen, a.StravaID)
+					if test.cancel {
+						cancel()
+					}
+				},
+				Decided: func(d tajusync.Decision) {
+					mu.Lock()
+					defer mu.Unlock()
+					if d.Decision == ACTION_POST {
+						results = append(results, d.Result)
+					}
+				},
+			})
+			if !errors.Is(err, test.err) {
+				t.Fatalf("err %v, want %v", err, test.err)
+			}
+			slices.Sort(seen)
+			if !slices.Equal(seen, []int64{1, 2}) {
+				t.Errorf("activities %v, want 1 and 2", seen)
+			}
+			if len(taji.posted) != test.posted || len(result.Posted) != test.posted {
+				t.Errorf("posted %d (result %d), want %d", len(taji.posted), len(result.Posted), test.posted)
+			}
+			// A cancelled run stops before changing Taji and decides nothing.
+			want := []string{test.result, test.result}
+			if test.cancel {
+				want = nil
+			}
+			if !slices.Equal(results, want) {
+				t.Errorf("decided %q, want %q", results, want)
+			}
+			if len(result.Activities) != 2 {
+				t.Fatalf("result has %d activities, want 2", len(result.Activities))
+			}
+			if a := result.Activities[0]; a.Distance != 5000 || a.Duration != 30*time.Minute {
+				t.Errorf("activity %+v, want 5000 m in 30m", a)
+			}
+		})
+	}
+}
+
+// testStrava is an account whose API is server, with a token that never
+// needs refreshing.
+func testStrava(server *httptest.Server) *strava {
+	token := &oauth2.Token{AccessToken: "access"}
+	return &strava{name: "club", token: token, source: oauth2.StaticTokenSource(token), ctx: context.Background(), api: server.URL, settings: newSettings()}
+}
+
+// testTaji is a logged in Taji client whose site is server.
+func testTaji(server *httptest.Server) *taji {
+	t := &taji{client: &tajiclient.Client{HTTP: &http.Client{}, Base: server.URL}, settings: newSettings()}
+	t.client.SetSession(tajiclient.Session{Id: "session"})
+	return t
+}
+
+// stalledSite is a site that doesn't answer for 10 seconds, unless the
+// client gives up on the request.
+func stalledSite(t *testing.T) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(10 * time.Second):
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestRunCancelsRequests(t *testing.T) {
+	tests := []struct {
+		name  string
+		sites func(t *testing.T) (tajiService, stravaService)
+	}{
+		{name: "Taji stalls", sites: func(t *testing.T) (tajiService, stravaService) {
+			return testTaji(stalledSite(t)), &fakeStrava{runs: []runDetails{testRun(t, 1, "2026-02-10T07:00:00Z", 1800, 5000)}}
+		}},
+		{name: "Strava stalls", sites: func(t *testing.T) (tajiService, stravaService) {
+			strava := testStrava(stalledSite(t))
+			strava.clock = newFakeClock(TEST_NOW)
+			strava.window_start, strava.window_end, _ = eventWindow(map[string]string{}, TEST_NOW)
+			return &fakeTaji{}, strava
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			taji, strava := test.sites(t)
+			s := &Syncer{newTestSyncer(t, map[string]string{}, taji, strava)}
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+
+			start := time.Now()
+			_, err := s.Run(ctx, tajusync.Options{})
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("err %v, want the run's deadline", err)
+			}
+			if took := time.Since(start); took > 5*time.Second {
+				t.Errorf("the run took %s after it was cancelled, want the stalled request cut short", took)
+			}
+		})
+	}
+}
+
+// siteStrava and siteTaji are the sites of an embedding program.
+type siteStrava []tajusync.Activity
+
+func (s siteStrava) Activities(ctx context.Context) ([]tajusync.Activity, bool, error) {
+	return s, false, nil
+}
+
+type siteTaji struct {
+	mu      sync.Mutex
+	entries map[string]tajusync.Activity
+	posts   int
+	updates int
+}
+
+func (s *siteTaji) Entries(ctx context.Context) (entries []tajusync.Entry, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, activity := range s.entries {
+		entries = append(entries, tajusync.Entry{ID: id, Activity: activity})
+	}
+	return
+}
+
+func (s *siteTaji) Post(ctx context.Context, activity tajusync.Activity) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.posts++
+	id := fmt.Sprint(s.posts)
+	s.entries[id] = activity
+	return id, nil
+}
+
+func (s *siteTaji) Update(ctx context.Context, id string, activity tajusync.Activity) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.updates++
+	s.entries[id] = activity
+	return nil
+}
+
+func (s *siteTaji) Delete(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, id)
+	return nil
+}
+
+func TestSyncerSites(t *testing.T) {
+	start := time.Date(2026, 2, 10, 7, 0, 0, 0, time.UTC)
+	strava := siteStrava{
+		{StravaID: 1, Start: start, Type: "run", Distance: 5000, Duration: 30 * time.Minute, Elevation: 12},
+		{StravaID: 2, Start: start.AddDate(0, 0, 1), Type: "walk", Distance: 3000, Duration: 45 * time.Minute},
+		// Outside the event window.
+		{StravaID: 3, Start: start.AddDate(0, 1, 0), Type: "run", Distance: 5000, Duration: 30 * time.Minute},
+	}
+	taji := &siteTaji{entries: make(map[string]tajusync.Activity)}
+	syncer := newTestSyncer(t, map[string]string{}, nil)
+	if err := useSites(syncer, tajusync.Config{Strava: []tajusync.Strava{strava}, Taji: taji}); err != nil {
+		t.Fatal(err)
+	}
+	s := &Syncer{syncer}
+
+	result, err := s.Run(context.Background(), tajusync.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Activities) != 2 || len(result.Posted) != 2 || taji.posts != 2 {
+		t.Fatalf("%d activities, %d posted (%d on the site), want 2 of 2", len(result.Activities), len(result.Posted), taji.posts)
+	}
+	if got := taji.entries["1"]; !got.Start.Equal(start) || got.StravaID != 1 || got.Distance != 5000 || got.Elevation != 12 {
+		t.Errorf("posted %+v, want activity 1 as it was", got)
+	}
+
+	// The entries read back match the activities they were posted for.
+	result, err = s.Run(context.Background(), tajusync.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Posted) != 0 || taji.posts != 2 || taji.updates != 0 {
+		t.Errorf("the second run posted %d and updated %d, want the entries left alone", len(result.Posted), taji.updates)
+	}
+}
+
+// The activities of an embedding program's source get the intake of a
+// Strava account's.
+func TestStravaSiteIntake(t *testing.T) {
+	start := time.Date(2026, 2, 10, 7, 0, 0, 0, time.UTC)
+	strava := siteStrava{
+		{StravaID: 1, Start: start, Type: "run", Distance: 5000, Duration: 30 * time.Minute, Private: true},
+		// Recorded twice, the longer one is kept.
+		{StravaID: 2, Start: start.Add(3 * time.Hour), Type: "walk", Distance: 3000, Duration: 45 * time.Minute},
+		{StravaID: 3, Start: start.Add(3*time.Hour + time.Minute), Type: "walk", Distance: 3100, Duration: 45 * time.Minute},
+		// Split at midnight.
+		{StravaID: 4, Start: time.Date(2026, 2, 11, 23, 0, 0, 0, time.UTC), Type: "run", Distance: 12000, Duration: 2 * time.Hour},
+	}
+	syncer := newTestSyncer(t, map[string]string{"TAJU_SKIP_PRIVATE": "true", "TAJU_SPLIT_MIDNIGHT": "true"}, nil)
+	if err := useSites(syncer, tajusync.Config{Strava: []tajusync.Strava{strava}}); err != nil {
+		t.Fatal(err)
+	}
+	runs, partial, err := syncer.strava[len(syncer.strava)-1].Activities()
+	if err != nil || partial {
+		t.Fatalf("partial=%t, %v", partial, err)
+	}
+	var got []string
+	for _, run := range runs {
+		got = append(got, fmt.Sprintf("%d/%d %s", run.strava_id, run.part, run.date))
+	}
+	want := []string{"3/0 2026-02-10", "4/1 2026-02-11", "4/2 2026-02-12"}
+	if !slices.Equal(got, want) {
+		t.Errorf("runs %q, want %q", got, want)
+	}
+}
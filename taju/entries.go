@@ -0,0 +1,28 @@
+package taju
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// deleteTajiEntry removes a logged entry through the delete route of its
+// edit page.
+func deleteTajiEntry(t *taji, logID string) error {
+	return t.client.Delete(t.run.context(), logID)
+}
+
+// updateTajiEntry overwrites an existing entry with new run details by
+// posting the edit form.
+func updateTajiEntry(t *taji, logID string, r runDetails) error {
+	return t.client.Update(t.run.context(), logID, t.settings.runValues("", r))
+}
+
+// confirm asks a yes/no question on the terminal and defaults to no.
+func confirm(prompt string) bool {
+	fmt.Printf("%s [y/N]: ", prompt)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
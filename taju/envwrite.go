@@ -0,0 +1,84 @@
+package taju
+
+import (
+	"bytes"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+
+	"github.com/joho/godotenv"
+)
+
+// ENV_BACKUPS is how many earlier versions of an env file are kept, as
+// taju.env.bak (the last one), taju.env.bak.1 and so on.
+const ENV_BACKUPS = 3
+
+// writeEnvFile replaces an env file without ever leaving it half written:
+// the new content is checked to read back as env, written to a temporary
+// file next to it and renamed over it. The file holds the only copy of the
+// tokens, so the version it replaces is kept as a backup first.
+func writeEnvFile(env map[string]string, path string) error {
+	text, err := godotenv.Marshal(env)
+	if err != nil {
+		return err
+	}
+	data := []byte(text + "\n")
+	parsed, err := godotenv.UnmarshalBytes(data)
+	if err != nil || !maps.Equal(parsed, env) {
+		return fmt.Errorf("the new %s wouldn't read back the same, leaving it as it is", path)
+	}
+
+	mode := os.FileMode(0600)
+	old, err := os.ReadFile(path)
+	switch {
+	case err == nil && bytes.Equal(old, data):
+		return nil
+	case err == nil:
+		if info, err := os.Stat(path); err == nil {
+			mode = info.Mode().Perm()
+		}
+		if err := backupEnvFile(path, old, mode); err != nil {
+			return err
+		}
+	case !os.IsNotExist(err):
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".taju-env-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// backupEnvFile shifts the backups of path by one and writes old as the
+// newest.
+func backupEnvFile(path string, old []byte, mode os.FileMode) error {
+	backup := func(i int) string {
+		if i == 0 {
+			return path + ".bak"
+		}
+		return fmt.Sprintf("%s.bak.%d", path, i)
+	}
+	os.Remove(backup(ENV_BACKUPS - 1))
+	for i := ENV_BACKUPS - 2; i >= 0; i-- {
+		os.Rename(backup(i), backup(i+1))
+	}
+	return os.WriteFile(backup(0), old, mode)
+}
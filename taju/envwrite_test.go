@@ -0,0 +1,93 @@
+package taju
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/joho/godotenv"
+)
+
+func TestWriteEnvFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, ENV_FILENAME)
+	read := func(path string) map[string]string {
+		t.Helper()
+		env, err := godotenv.Read(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return env
+	}
+
+	// Values that need quoting read back the same.
+	first := map[string]string{"TAJU_CLIENT_ID": "123", "STRAVA_TOKEN": `{"access_token":"a b","expiry":"2026-02-10T07:00:00Z"}`, "TAJU_NOTES": "line one\nline two"}
+	if err := writeEnvFile(first, path); err != nil {
+		t.Fatal(err)
+	}
+	if got := read(path); !maps.Equal(got, first) {
+		t.Errorf("read back %v, want %v", got, first)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("a new env file has mode %v (%v), want 0600", info.Mode().Perm(), err)
+	}
+	if _, err := os.Stat(path + ".bak"); !os.IsNotExist(err) {
+		t.Errorf("a new env file has a backup: %v", err)
+	}
+
+	// An unchanged env isn't written, so it leaves no backup either.
+	if err := writeEnvFile(first, path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path + ".bak"); !os.IsNotExist(err) {
+		t.Errorf("rewriting the same env made a backup: %v", err)
+	}
+
+	// A user's permissions are kept, and every change keeps the version
+	// it replaced, up to ENV_BACKUPS of them.
+	if err := os.Chmod(path, 0640); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= ENV_BACKUPS+1; i++ {
+		if err := writeEnvFile(map[string]string{"TAJU_CLIENT_ID": fmt.Sprint(i)}, path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := read(path)["TAJU_CLIENT_ID"]; got != fmt.Sprint(ENV_BACKUPS+1) {
+		t.Errorf("TAJU_CLIENT_ID = %q after %d writes", got, ENV_BACKUPS+1)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0640 {
+		t.Errorf("mode %v (%v) after rewriting, want 0640", info.Mode().Perm(), err)
+	}
+	backups := []string{path + ".bak", path + ".bak.1", path + ".bak.2"}
+	for i, backup := range backups {
+		if got, want := read(backup)["TAJU_CLIENT_ID"], fmt.Sprint(ENV_BACKUPS-i); got != want {
+			t.Errorf("%s has TAJU_CLIENT_ID %q, want %q", filepath.Base(backup), got, want)
+		}
+	}
+	if _, err := os.Stat(path + ".bak.3"); !os.IsNotExist(err) {
+		t.Errorf("more than %d backups: %v", ENV_BACKUPS, err)
+	}
+
+	// No temporary files are left behind.
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1+ENV_BACKUPS {
+		var names []string
+		for _, file := range files {
+			names = append(names, file.Name())
+		}
+		t.Errorf("directory has %v", names)
+	}
+}
+
+func TestWriteEnvFileMissingDir(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "missing", ENV_FILENAME)
+	if err := writeEnvFile(map[string]string{"A": "1"}, path); err == nil {
+		t.Error("wrote an env file into a directory that doesn't exist")
+	}
+}
@@ -0,0 +1,155 @@
+package taju
+
+import (
+	"cmp"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"io"
+	"log"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+)
+
+var EXPORT_COLUMNS = []string{"date", "time", "activity", "distance", "duration", "elevation_gain", "strava_id", "part", "taji_log_id", "uploaded_at"}
+
+// exportedActivity is a row of taju export. Distance and duration are as on
+// Taji, in its units.
+type exportedActivity struct {
+	Date       string    `json:"date"`
+	Time       string    `json:"time"`
+	Activity   string    `json:"activity"`
+	Distance   string    `json:"distance"`
+	Duration   string    `json:"duration"`
+	Elevation  string    `json:"elevation_gain,omitempty"`
+	StravaId   int64     `json:"strava_id,omitempty"`
+	Part       int       `json:"part,omitempty"`
+	LogId      string    `json:"taji_log_id"`
+	UploadedAt time.Time `json:"uploaded_at"`
+}
+
+// exported lists the activities the ledger has on Taji, oldest first,
+// including those logged with taju add and on the Taji site.
+func (s *stateStore) exported() (activities []exportedActivity) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, entry := range slices.Concat(slices.Collect(maps.Values(s.Entries)), slices.Collect(maps.Values(s.Parts)), slices.Collect(maps.Values(s.Manual)),
+		slices.Collect(maps.Values(s.TajiOnly))) {
+		if entry.Status != STATE_UPLOADED {
+			continue
+		}
+		uploaded := entry.UploadedAt
+		if uploaded.IsZero() {
+			// Ledgers from before UploadedAt only know the last change.
+			uploaded = entry.UpdatedAt
+		}
+		activities = append(activities, exportedActivity{Date: entry.Date, Time: entry.Time, Activity: entry.Activity,
+			Distance: entry.Distance, Duration: entry.Duration, Elevation: entry.Elevation, StravaId: entry.StravaId,
+			Part: entry.Part, LogId: entry.LogId, UploadedAt: uploaded})
+	}
+	slices.SortFunc(activities, func(a, b exportedActivity) int {
+		return cmp.Or(cmp.Compare(a.Date, b.Date), cmp.Compare(a.Time, b.Time), cmp.Compare(a.LogId, b.LogId))
+	})
+	return
+}
+
+func writeExportCsv(w io.Writer, activities []exportedActivity) error {
+	out := csv.NewWriter(w)
+	out.Write(EXPORT_COLUMNS)
+	for _, a := range activities {
+		strava_id, part := "", ""
+		if a.StravaId != 0 {
+			strava_id = strconv.FormatInt(a.StravaId, 10)
+		}
+		if a.Part != 0 {
+			part = strconv.Itoa(a.Part)
+		}
+		out.Write([]string{a.Date, a.Time, a.Activity, a.Distance, a.Duration, a.Elevation, strava_id, part, a.LogId,
+			a.UploadedAt.Format(time.RFC3339)})
+	}
+	out.Flush()
+	return out.Error()
+}
+
+func writeExportJson(w io.Writer, activities []exportedActivity) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if activities == nil {
+		activities = []exportedActivity{}
+	}
+	return encoder.Encode(activities)
+}
+
+// exportCommand dumps the synced activities from the ledger for spreadsheets
+// and charts. Nothing is read from Strava or Taji.
+func exportCommand(u *uploader, args []string) {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	format := flags.String("format", "csv", "csv or json")
+	out := flags.String("out", "-", "file to write, - for stdout")
+	daily := flags.Bool("daily", false, "write the distance per day of the event instead, as a time series")
+	flags.Parse(args)
+
+	if *daily {
+		exportDaily(u, *format, *out)
+		return
+	}
+
+	var write func(io.Writer, []exportedActivity) error
+	switch *format {
+	case "csv":
+		write = writeExportCsv
+	case "json":
+		write = writeExportJson
+	default:
+		log.Fatalf("Unknown export format %q, expected csv or json", *format)
+	}
+
+	activities := u.state.exported()
+	w := io.Writer(os.Stdout)
+	if *out != "-" {
+		file, err := os.Create(*out)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer file.Close()
+		w = file
+	}
+	if err := write(w, activities); err != nil {
+		log.Fatal(err)
+	}
+	if *out != "-" {
+		log.Printf("Exported %d activities to %s", len(activities), *out)
+	}
+}
+
+// exportDaily writes the daily series, the same the control server serves
+// on /daily.
+func exportDaily(u *uploader, format string, out string) {
+	write := writeDailyCsv
+	switch format {
+	case "csv":
+	case "json":
+		write = writeDailyJson
+	default:
+		log.Fatalf("Unknown export format %q, expected csv or json", format)
+	}
+	series := dailySeries(u)
+	w := io.Writer(os.Stdout)
+	if out != "-" {
+		file, err := os.Create(out)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer file.Close()
+		w = file
+	}
+	if err := write(w, series); err != nil {
+		log.Fatal(err)
+	}
+	if out != "-" {
+		log.Printf("Exported %d days to %s", len(series), out)
+	}
+}
@@ -0,0 +1,243 @@
+package taju
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// FILE_ID_BIT marks the ids of activities read from files, far above any
+// Strava activity id, so they can share the ledger with Strava activities.
+const FILE_ID_BIT int64 = 1 << 62
+
+// fileSource syncs the GPX, TCX, FIT and CSV files in a directory like a
+// Strava account, for participants who aren't on Strava: a team captain
+// logging for a member drops their watch exports in the directory and the
+// member's profile posts them. TAJU_IMPORT_DIR sets the directory; with
+// profiles every profile reads its own subdirectory of it (imports/alice),
+// so one shared directory serves the whole team while each profile keeps
+// its own ledger, duplicate checks and summary. In coach mode the files
+// can also be named for the participant instead, see participants.go.
+//
+// An activity's id is a hash of the file's content, or of the row of a
+// CSV file, so a file that is renamed or copied in again isn't posted
+// twice.
+type fileSource struct {
+	dir string
+	// owner, if set, only reads the files named for that participant
+	// (alice-parkrun.gpx); otherwise the files named for any of others are
+	// left to them.
+	owner        string
+	others       []string
+	env          map[string]string
+	activity_map map[string]string
+	settings     *settings
+	// intake picks, splits and maps the activity files like those of a
+	// Strava account. CSV rows are typed up like taju add and only kept
+	// to the event window.
+	intake
+}
+
+// fileActivity is an activity file as it was read, before the intake.
+type fileActivity struct {
+	path   string
+	source sourceActivity
+	run    runDetails
+}
+
+// loadFileSources returns the import directories of u: TAJU_IMPORT_DIR,
+// or a profile's subdirectory of it. A participant reads their own
+// TAJU_PARTICIPANT_<NAME>_IMPORT_DIR, or else their subdirectory of
+// TAJU_IMPORT_DIR and the files in it named for them.
+func loadFileSources(u *uploader) ([]*fileSource, error) {
+	dir := u.env["TAJU_IMPORT_DIR"]
+	own := ""
+	if u.participant {
+		own = u.env[participantKey(u.profile, "IMPORT_DIR")]
+	}
+	if dir == "" && own == "" {
+		return nil, nil
+	}
+	start, end, err := eventWindow(u.env, u.clock.Now())
+	if err != nil {
+		return nil, err
+	}
+	activity_map, err := loadActivityMap(u.env)
+	if err != nil {
+		return nil, err
+	}
+	in, err := loadIntake(u.env, u.settings, start, end)
+	if err != nil {
+		return nil, err
+	}
+	source := func(dir string) *fileSource {
+		return &fileSource{dir: dir, env: u.env, activity_map: activity_map, settings: u.settings, intake: in}
+	}
+	switch {
+	case own != "":
+		return []*fileSource{source(own)}, nil
+	case u.participant:
+		named := source(dir)
+		named.owner = u.profile
+		return []*fileSource{source(filepath.Join(dir, u.profile)), named}, nil
+	case u.profile != "":
+		return []*fileSource{source(filepath.Join(dir, u.profile))}, nil
+	}
+	shared := source(dir)
+	shared.others = participantNames(u.env)
+	return []*fileSource{shared}, nil
+}
+
+// Activities reads every activity file in the directory. A file (or CSV
+// row) that can't be read is skipped with an error, and the activities are
+// then partial so its Taji entry isn't taken for one without a source.
+func (f *fileSource) Activities() (runs []runDetails, partial bool, err error) {
+	names, err := os.ReadDir(f.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, true, err
+	}
+	var errs []error
+	recorded := make(map[int64]fileActivity)
+	var sources []sourceActivity
+	for _, entry := range names {
+		ext := strings.ToLower(filepath.Ext(entry.Name()))
+		if entry.IsDir() || !slices.Contains([]string{".gpx", ".tcx", ".fit", ".csv"}, ext) {
+			continue
+		}
+		if f.owner != "" && !participantFiles(entry.Name(), []string{f.owner}) || participantFiles(entry.Name(), f.others) {
+			continue
+		}
+		path := filepath.Join(f.dir, entry.Name())
+		if ext == ".csv" {
+			rows, err := f.readCSV(path)
+			if err != nil {
+				errs = append(errs, err)
+			}
+			for _, run := range rows {
+				if f.inWindow(run) {
+					runs = append(runs, run)
+				}
+			}
+			continue
+		}
+		activity, ok, err := f.read(path)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		if ok {
+			recorded[activity.source.id] = activity
+			sources = append(sources, activity.source)
+		}
+	}
+	for _, source := range f.pick(sources) {
+		activity := recorded[source.id]
+		parts, err := f.runs(activity.run, f.pipeline.apply)
+		if err != nil {
+			log.Printf("%s: %v", activity.path, err)
+			continue
+		}
+		runs = append(runs, parts...)
+	}
+	sortRuns(runs)
+	return runs, len(errs) > 0, errors.Join(errs...)
+}
+
+// read reads an activity file, false if it maps to no Taji activity.
+func (f *fileSource) read(path string) (fileActivity, bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fileActivity{}, false, err
+	}
+	activity, err := parseActivityFile(path)
+	if err != nil {
+		return fileActivity{}, false, err
+	}
+	taji_activity, ok := tajiActivity(f.activity_map, activity)
+	if !ok {
+		log.Printf("%s: %s activities aren't uploaded, see TAJU_ACTIVITY_MAP", path, activity.SportType)
+		return fileActivity{}, false, nil
+	}
+	run := f.settings.createRun(taji_activity, activity.StartDate, activity.ElapsedTime, activity.Distance)
+	run.elevation_float = activity.TotalElevationGain
+	run.strava_id = fileActivityId(data)
+	source := stravaSource(activity, taji_activity)
+	source.id = run.strava_id
+	return fileActivity{path: path, source: source, run: run}, true, nil
+}
+
+// CSV_COLUMNS are the columns of an activity CSV, the values of taju add.
+// time and elevation are optional.
+var CSV_COLUMNS = []string{"activity", "date", "time", "distance", "duration", "elevation"}
+
+// readCSV reads the activities typed up in a CSV file, one per row under
+// a header naming CSV_COLUMNS, e.g.
+//
+//	activity,date,time,distance,duration
+//	walk,2026-02-10,7:30AM,3.1,45m
+//
+// The values are read like the flags of taju add: the activity is the
+// Taji one, distances are in the units of the Taji form unless they name
+// theirs. A row that can't be read is an error, the others are returned.
+func (f *fileSource) readCSV(path string) ([]runDetails, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+	columns := map[string]int{}
+	for i, name := range records[0] {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range []string{"activity", "date", "distance", "duration"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("%s: no %s column, expected a header of %s", path, name, strings.Join(CSV_COLUMNS, ","))
+		}
+	}
+	var runs []runDetails
+	var errs []error
+	for i, record := range records[1:] {
+		value := func(name string) string {
+			if c, ok := columns[name]; ok && c < len(record) {
+				return strings.TrimSpace(record[c])
+			}
+			return ""
+		}
+		m := manualValues{activity: value("activity"), date: value("date"), time: value("time"),
+			distance: value("distance"), duration: value("duration"), elevation: value("elevation")}
+		if m.time == "" {
+			m.time = DEFAULT_MANUAL_TIME
+		}
+		run, err := f.settings.manualRun(f.env, m)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s, row %d: %w", path, i+2, err))
+			continue
+		}
+		run.strava_id = fileActivityId([]byte(strings.Join(record, ",")))
+		runs = append(runs, run)
+	}
+	return runs, errors.Join(errs...)
+}
+
+func fileActivityId(data []byte) int64 {
+	h := fnv.New64a()
+	h.Write(data)
+	return FILE_ID_BIT | int64(h.Sum64()&uint64(FILE_ID_BIT-1))
+}
@@ -0,0 +1,124 @@
+package taju
+
+import (
+	"fmt"
+	"log"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Strava workout types marking a race, for runs and for rides.
+var RACE_WORKOUT_TYPES = []int{1, 11}
+
+// activityFilters drop activities before they are mapped, so they are
+// never uploaded:
+//
+//	TAJU_MIN_MILES=0.5            shorter activities
+//	TAJU_SKIP_PRIVATE=true        activities only the athlete can see
+//	TAJU_SKIP_COMMUTES=true       activities marked as commutes
+//	TAJU_SKIP_RACES=true          activities marked as races
+//	TAJU_REQUIRE_TAG=#taji        activities without the tag in their name or description
+//	TAJU_NAME_PREFIX=Taji         activities whose name doesn't start with it
+//	TAJU_ONLY_GEAR=g123,b456      activities with other (or no) gear
+//	TAJU_EXCLUDE_ACTIVITIES=1,2   these activity ids
+type activityFilters struct {
+	min_miles    float64
+	skip_private bool
+	skip_commute bool
+	skip_race    bool
+	require_tag  string
+	name_prefix  string
+	gear         []string
+	exclude      []int64
+}
+
+func loadActivityFilters(env map[string]string) (activityFilters, error) {
+	f := activityFilters{
+		skip_private: envBool(env, "TAJU_SKIP_PRIVATE"),
+		skip_commute: envBool(env, "TAJU_SKIP_COMMUTES"),
+		skip_race:    envBool(env, "TAJU_SKIP_RACES"),
+		require_tag:  strings.ToLower(env["TAJU_REQUIRE_TAG"]),
+		name_prefix:  strings.ToLower(env["TAJU_NAME_PREFIX"]),
+		gear:         splitList(env["TAJU_ONLY_GEAR"]),
+	}
+	if value, ok := env["TAJU_MIN_MILES"]; ok {
+		miles, err := parseMiles(value)
+		if err != nil || miles < 0 {
+			return activityFilters{}, fmt.Errorf("invalid TAJU_MIN_MILES=%q", value)
+		}
+		f.min_miles = miles
+	}
+	for _, value := range splitList(env["TAJU_EXCLUDE_ACTIVITIES"]) {
+		id, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return activityFilters{}, fmt.Errorf("invalid activity id %q in TAJU_EXCLUDE_ACTIVITIES", value)
+		}
+		f.exclude = append(f.exclude, id)
+	}
+	return f, nil
+}
+
+// skip returns why an activity is filtered out, if it is.
+func (f activityFilters) skip(activity sourceActivity) (string, bool) {
+	name := strings.ToLower(activity.name)
+	switch {
+	case slices.Contains(f.exclude, activity.id):
+		return "excluded", true
+	case f.min_miles > 0 && meter2mile(activity.distance) < f.min_miles:
+		return "shorter than TAJU_MIN_MILES", true
+	case f.skip_private && activity.private:
+		return "private", true
+	case f.skip_commute && activity.commute:
+		return "a commute", true
+	case f.skip_race && activity.race:
+		return "a race", true
+	case f.require_tag != "" && !strings.Contains(name, f.require_tag) && !strings.Contains(strings.ToLower(activity.description), f.require_tag):
+		return "missing the tag " + f.require_tag, true
+	case f.name_prefix != "" && !strings.HasPrefix(name, f.name_prefix):
+		return "not named " + f.name_prefix + "...", true
+	case len(f.gear) > 0 && !slices.Contains(f.gear, activity.gear):
+		return "other gear", true
+	}
+	return "", false
+}
+
+// stravaSource is what the filters and the duplicate rule go by for a
+// Strava activity (or an activity file read like one) mapped to
+// taji_activity.
+func stravaSource(activity stravaActivity, taji_activity string) sourceActivity {
+	start, _ := time.Parse(time.RFC3339, activity.StartDate)
+	return sourceActivity{id: activity.Id, name: activity.Name, description: activity.Description, private: activity.Private,
+		commute: activity.Commute, race: activity.WorkoutType != nil && slices.Contains(RACE_WORKOUT_TYPES, *activity.WorkoutType),
+		gear: activity.GearId, activity: taji_activity, start: start, distance: activity.Distance, elapsed: activity.ElapsedTime}
+}
+
+// sourceOf is activity as the intake of s sees it.
+func (s *strava) sourceOf(activity stravaActivity) sourceActivity {
+	taji_activity, _ := tajiActivity(s.activity_map, activity)
+	return stravaSource(activity, taji_activity)
+}
+
+// filterActivities drops the activities the filters skip. An activity
+// that was fetched before and is skipped now (e.g. it was made private) is
+// forgotten as well.
+func filterActivities(s *strava, activities []stravaActivity) []stravaActivity {
+	private := 0
+	kept := slices.DeleteFunc(activities, func(activity stravaActivity) bool {
+		reason, skip := s.filters.skip(s.sourceOf(activity))
+		if skip {
+			slog.Debug("Skipping Strava activity", "strava_id", activity.Id, "name", activity.Name, "reason", reason)
+			delete(s.seen, activity.Id)
+		}
+		if reason == "private" {
+			private++
+		}
+		return skip
+	})
+	if private > 0 {
+		log.Printf("Skipped %d private activities of Strava account %q (TAJU_SKIP_PRIVATE)", private, s.name)
+	}
+	return kept
+}
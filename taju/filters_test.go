@@ -0,0 +1,72 @@
+package taju
+
+import "testing"
+
+func TestActivityFilters(t *testing.T) {
+	race, workout := 1, 3
+	run := stravaActivity{Id: 7, Name: "Morning Run #taji", Distance: 5000, GearId: "g123"}
+	tests := []struct {
+		name     string
+		env      map[string]string
+		activity func(a *stravaActivity)
+		reason   string
+	}{
+		{name: "no filters"},
+		{name: "long enough", env: map[string]string{"TAJU_MIN_MILES": "3"}},
+		{name: "too short", env: map[string]string{"TAJU_MIN_MILES": "3.5"}, reason: "shorter than TAJU_MIN_MILES"},
+		{name: "private", env: map[string]string{"TAJU_SKIP_PRIVATE": "true"}, activity: func(a *stravaActivity) { a.Private = true }, reason: "private"},
+		{name: "private kept", activity: func(a *stravaActivity) { a.Private = true }},
+		{name: "commute", env: map[string]string{"TAJU_SKIP_COMMUTES": "true"}, activity: func(a *stravaActivity) { a.Commute = true }, reason: "a commute"},
+		{name: "race", env: map[string]string{"TAJU_SKIP_RACES": "true"}, activity: func(a *stravaActivity) { a.WorkoutType = &race }, reason: "a race"},
+		{name: "workout isn't a race", env: map[string]string{"TAJU_SKIP_RACES": "true"}, activity: func(a *stravaActivity) { a.WorkoutType = &workout }},
+		{name: "tag in the name", env: map[string]string{"TAJU_REQUIRE_TAG": "#TAJI"}},
+		{
+			name: "tag in the description", env: map[string]string{"TAJU_REQUIRE_TAG": "#taji"},
+			activity: func(a *stravaActivity) { a.Name, a.Description = "Morning Run", "for the #Taji challenge" },
+		},
+		{name: "no tag", env: map[string]string{"TAJU_REQUIRE_TAG": "#taji100"}, reason: "missing the tag #taji100"},
+		{name: "name prefix", env: map[string]string{"TAJU_NAME_PREFIX": "morning"}},
+		{name: "other name", env: map[string]string{"TAJU_NAME_PREFIX": "Taji"}, reason: "not named taji..."},
+		{name: "listed gear", env: map[string]string{"TAJU_ONLY_GEAR": "b456, g123"}},
+		{name: "other gear", env: map[string]string{"TAJU_ONLY_GEAR": "b456"}, reason: "other gear"},
+		{name: "no gear", env: map[string]string{"TAJU_ONLY_GEAR": "g123"}, activity: func(a *stravaActivity) { a.GearId = "" }, reason: "other gear"},
+		{name: "excluded", env: map[string]string{"TAJU_EXCLUDE_ACTIVITIES": "3, 7"}, reason: "excluded"},
+		// The first filter that applies is the reason.
+		{name: "excluded and private", env: map[string]string{"TAJU_EXCLUDE_ACTIVITIES": "7", "TAJU_SKIP_PRIVATE": "true"}, activity: func(a *stravaActivity) { a.Private = true }, reason: "excluded"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			activity := run
+			if test.activity != nil {
+				test.activity(&activity)
+			}
+			filters, err := loadActivityFilters(test.env)
+			if err != nil {
+				t.Fatal(err)
+			}
+			reason, skip := filters.skip(stravaSource(activity, "run"))
+			if skip != (test.reason != "") || reason != test.reason {
+				t.Errorf("skip = %t (%q), want %q", skip, reason, test.reason)
+			}
+		})
+	}
+}
+
+func TestFilterActivities(t *testing.T) {
+	filters, err := loadActivityFilters(map[string]string{"TAJU_SKIP_PRIVATE": "true"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &strava{
+		intake: intake{filters: filters},
+		seen:   map[int64][]runDetails{1: {{strava_id: 1}}, 2: {{strava_id: 2}}},
+	}
+	kept := filterActivities(s, []stravaActivity{{Id: 1}, {Id: 2, Private: true}, {Id: 3}})
+	if len(kept) != 2 || kept[0].Id != 1 || kept[1].Id != 3 {
+		t.Errorf("kept %+v, want activities 1 and 3", kept)
+	}
+	// An activity made private since it was fetched is forgotten.
+	if _, ok := s.seen[2]; ok || len(s.seen) != 1 {
+		t.Errorf("seen %v, want only activity 1", s.seen)
+	}
+}
@@ -0,0 +1,184 @@
+package taju
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Garmin FIT files are decoded just far enough for an import: the session
+// summary and the altitude of the records. Field numbers and scales are
+// from the FIT SDK profile.
+const (
+	FIT_MSG_SESSION = 18
+	FIT_MSG_RECORD  = 20
+
+	FIT_FIELD_TIMESTAMP          = 253
+	FIT_RECORD_ALTITUDE          = 2
+	FIT_RECORD_DISTANCE          = 5
+	FIT_RECORD_ENHANCED_ALTITUDE = 78
+	FIT_SESSION_START_TIME       = 2
+	FIT_SESSION_SPORT            = 5
+	FIT_SESSION_ELAPSED_TIME     = 7
+	FIT_SESSION_DISTANCE         = 9
+	FIT_SESSION_ASCENT           = 22
+)
+
+// FIT timestamps count seconds from 1989-12-31 00:00 UTC.
+var FIT_EPOCH = time.Date(1989, 12, 31, 0, 0, 0, 0, time.UTC)
+
+// FIT_SPORTS maps FIT sport numbers to Strava sport types, so imported
+// files go through TAJU_ACTIVITY_MAP like Strava activities.
+var FIT_SPORTS = map[uint64]string{1: "Run", 2: "Ride", 5: "Swim", 11: "Walk", 17: "Hike"}
+
+type fitField struct {
+	num  byte
+	size byte
+}
+
+type fitDefinition struct {
+	global     uint16
+	order      binary.ByteOrder
+	fields     []fitField
+	dev_fields int
+}
+
+// parseFIT reads an activity from a FIT file.
+func parseFIT(data []byte) (activity stravaActivity, altitude []float64, err error) {
+	if len(data) < 12 || !bytes.Equal(data[8:12], []byte(".FIT")) {
+		return activity, nil, errors.New("not a FIT file")
+	}
+	header_size := int(data[0])
+	end := header_size + int(binary.LittleEndian.Uint32(data[4:8]))
+	if header_size < 12 || end > len(data) {
+		return activity, nil, errors.New("truncated FIT file")
+	}
+
+	var first, last time.Time
+	var last_distance float64
+	have_session := false
+	definitions := make(map[byte]*fitDefinition)
+	for pos := header_size; pos < end; {
+		header := data[pos]
+		pos++
+		local := header & 0x0F
+		if header&0x80 != 0 {
+			// Compressed timestamp header, always a data message.
+			local = (header >> 5) & 0x03
+		} else if header&0x40 != 0 {
+			if pos+5 > end {
+				return activity, nil, errors.New("truncated FIT definition")
+			}
+			def := &fitDefinition{order: binary.LittleEndian}
+			if data[pos+1] == 1 {
+				def.order = binary.BigEndian
+			}
+			def.global = def.order.Uint16(data[pos+2 : pos+4])
+			count := int(data[pos+4])
+			pos += 5
+			if pos+3*count > end {
+				return activity, nil, errors.New("truncated FIT definition")
+			}
+			for i := 0; i < count; i++ {
+				def.fields = append(def.fields, fitField{num: data[pos], size: data[pos+1]})
+				pos += 3
+			}
+			if header&0x20 != 0 {
+				if pos >= end {
+					return activity, nil, errors.New("truncated FIT definition")
+				}
+				count = int(data[pos])
+				pos++
+				for i := 0; i < count && pos+3 <= end; i++ {
+					def.dev_fields += int(data[pos+1])
+					pos += 3
+				}
+			}
+			definitions[local] = def
+			continue
+		}
+
+		def, ok := definitions[local]
+		if !ok {
+			return activity, nil, fmt.Errorf("FIT data message for undefined local type %d", local)
+		}
+		values := make(map[byte]uint64)
+		for _, field := range def.fields {
+			if pos+int(field.size) > end {
+				return activity, nil, errors.New("truncated FIT data message")
+			}
+			if value, ok := fitValue(data[pos:pos+int(field.size)], def.order); ok {
+				values[field.num] = value
+			}
+			pos += int(field.size)
+		}
+		pos += def.dev_fields
+
+		switch def.global {
+		case FIT_MSG_RECORD:
+			if ts, ok := values[FIT_FIELD_TIMESTAMP]; ok {
+				t := FIT_EPOCH.Add(time.Duration(ts) * time.Second)
+				if first.IsZero() {
+					first = t
+				}
+				last = t
+			}
+			if distance, ok := values[FIT_RECORD_DISTANCE]; ok {
+				last_distance = float64(distance) / 100
+			}
+			if alt, ok := values[FIT_RECORD_ENHANCED_ALTITUDE]; ok {
+				altitude = append(altitude, float64(alt)/5-500)
+			} else if alt, ok := values[FIT_RECORD_ALTITUDE]; ok {
+				altitude = append(altitude, float64(alt)/5-500)
+			}
+		case FIT_MSG_SESSION:
+			have_session = true
+			if start, ok := values[FIT_SESSION_START_TIME]; ok {
+				activity.StartDate = FIT_EPOCH.Add(time.Duration(start) * time.Second).Format(time.RFC3339)
+			}
+			if elapsed, ok := values[FIT_SESSION_ELAPSED_TIME]; ok {
+				activity.ElapsedTime = int64(elapsed / 1000)
+			}
+			if distance, ok := values[FIT_SESSION_DISTANCE]; ok {
+				activity.Distance = float64(distance) / 100
+			}
+			if ascent, ok := values[FIT_SESSION_ASCENT]; ok {
+				activity.TotalElevationGain = float64(ascent)
+			}
+			if sport, ok := values[FIT_SESSION_SPORT]; ok {
+				activity.SportType = FIT_SPORTS[sport]
+			}
+		}
+	}
+
+	// Files without a session message are summed up from their records.
+	if !have_session || activity.StartDate == "" {
+		if first.IsZero() {
+			return activity, nil, errors.New("FIT file has no session or timed records")
+		}
+		activity.StartDate = first.Format(time.RFC3339)
+		activity.ElapsedTime = int64(last.Sub(first).Seconds())
+	}
+	if activity.Distance == 0 {
+		activity.Distance = last_distance
+	}
+	return activity, altitude, nil
+}
+
+// fitValue reads an unsigned field. FIT marks missing values with all bits
+// set; those (and fields of odd sizes, like strings) are skipped.
+func fitValue(raw []byte, order binary.ByteOrder) (uint64, bool) {
+	switch len(raw) {
+	case 1:
+		return uint64(raw[0]), raw[0] != 0xFF
+	case 2:
+		v := order.Uint16(raw)
+		return uint64(v), v != 0xFFFF
+	case 4:
+		v := order.Uint32(raw)
+		return uint64(v), v != 0xFFFFFFFF
+	}
+	return 0, false
+}
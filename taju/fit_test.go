@@ -0,0 +1,170 @@
+package taju
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fitMessage is a FIT message for fitFile: its global number and fields.
+type fitMessage struct {
+	global     uint16
+	big_endian bool
+	fields     []fitTestField
+}
+
+type fitTestField struct {
+	num   byte
+	size  byte
+	value uint64
+}
+
+// fitFile encodes messages as a FIT file, each with its own definition on
+// local type 0 like simple writers do.
+func fitFile(messages ...fitMessage) []byte {
+	var data bytes.Buffer
+	for _, message := range messages {
+		var order binary.ByteOrder = binary.LittleEndian
+		arch := byte(0)
+		if message.big_endian {
+			order, arch = binary.BigEndian, 1
+		}
+		data.Write([]byte{0x40, 0, arch})
+		binary.Write(&data, order, message.global)
+		data.WriteByte(byte(len(message.fields)))
+		for _, field := range message.fields {
+			data.Write([]byte{field.num, field.size, 0})
+		}
+		data.WriteByte(0)
+		for _, field := range message.fields {
+			switch field.size {
+			case 1:
+				data.WriteByte(byte(field.value))
+			case 2:
+				binary.Write(&data, order, uint16(field.value))
+			case 4:
+				binary.Write(&data, order, uint32(field.value))
+			default:
+				data.Write(make([]byte, field.size))
+			}
+		}
+	}
+	header := []byte{14, 0x10, 0, 0}
+	header = binary.LittleEndian.AppendUint32(header, uint32(data.Len()))
+	header = append(header, ".FIT"...)
+	header = append(header, 0, 0)
+	return append(header, data.Bytes()...)
+}
+
+// fitTime is t as a FIT timestamp.
+func fitTime(t time.Time) uint64 {
+	return uint64(t.Sub(FIT_EPOCH) / time.Second)
+}
+
+// fitRecord is a record message at t, with distance in meters and
+// altitude in meters.
+func fitRecord(t time.Time, distance float64, altitude float64) fitMessage {
+	return fitMessage{global: FIT_MSG_RECORD, fields: []fitTestField{
+		{num: FIT_FIELD_TIMESTAMP, size: 4, value: fitTime(t)},
+		{num: FIT_RECORD_DISTANCE, size: 4, value: uint64(distance * 100)},
+		{num: FIT_RECORD_ALTITUDE, size: 2, value: uint64((altitude + 500) * 5)},
+	}}
+}
+
+func TestParseFIT(t *testing.T) {
+	start := time.Date(2026, 2, 10, 12, 30, 0, 0, time.UTC)
+	session := func(big_endian bool) fitMessage {
+		return fitMessage{global: FIT_MSG_SESSION, big_endian: big_endian, fields: []fitTestField{
+			{num: FIT_FIELD_TIMESTAMP, size: 4, value: fitTime(start.Add(time.Hour))},
+			{num: FIT_SESSION_START_TIME, size: 4, value: fitTime(start)},
+			{num: FIT_SESSION_SPORT, size: 1, value: 1},
+			{num: FIT_SESSION_ELAPSED_TIME, size: 4, value: 2700 * 1000},
+			{num: FIT_SESSION_DISTANCE, size: 4, value: 802500},
+			{num: FIT_SESSION_ASCENT, size: 2, value: 42},
+			// A string field (the sport name) is skipped.
+			{num: 3, size: 16},
+		}}
+	}
+	tests := []struct {
+		name      string
+		file      []byte
+		want      stravaActivity
+		altitudes int
+		err       string
+	}{
+		{
+			name:      "session",
+			file:      fitFile(fitRecord(start, 0, 100), fitRecord(start.Add(time.Minute), 200, 104), session(false)),
+			want:      stravaActivity{SportType: "Run", StartDate: "2026-02-10T12:30:00Z", ElapsedTime: 2700, Distance: 8025, TotalElevationGain: 42},
+			altitudes: 2,
+		},
+		{
+			name: "big-endian session",
+			file: fitFile(session(true)),
+			want: stravaActivity{SportType: "Run", StartDate: "2026-02-10T12:30:00Z", ElapsedTime: 2700, Distance: 8025, TotalElevationGain: 42},
+		},
+		{
+			name:      "records only",
+			file:      fitFile(fitRecord(start, 0, 100), fitRecord(start.Add(10*time.Minute), 1609.34, 101), fitRecord(start.Add(20*time.Minute), 3218.68, 99)),
+			want:      stravaActivity{StartDate: "2026-02-10T12:30:00Z", ElapsedTime: 1200, Distance: 3218.68},
+			altitudes: 3,
+		},
+		{
+			name: "invalid session values are skipped",
+			file: fitFile(fitRecord(start, 0, 100), fitRecord(start.Add(time.Minute), 150, 100), fitMessage{global: FIT_MSG_SESSION, fields: []fitTestField{
+				{num: FIT_SESSION_START_TIME, size: 4, value: fitTime(start)},
+				{num: FIT_SESSION_DISTANCE, size: 4, value: 0xFFFFFFFF},
+				{num: FIT_SESSION_SPORT, size: 1, value: 0xFF},
+			}}),
+			want:      stravaActivity{StartDate: "2026-02-10T12:30:00Z", Distance: 150},
+			altitudes: 2,
+		},
+		{name: "not a FIT file", file: []byte("<gpx></gpx>"), err: "not a FIT file"},
+		{name: "truncated", file: fitFile(session(false))[:40], err: "truncated FIT file"},
+		{name: "no session or records", file: fitFile(fitMessage{global: 0, fields: []fitTestField{{num: 0, size: 1, value: 4}}}), err: "no session or timed records"},
+		{name: "data before its definition", file: func() []byte {
+			file := fitFile(session(false))
+			// Turn the definition header into a data header of local type 1.
+			file[14] = 0x01
+			return file
+		}(), err: "undefined local type"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			activity, altitude, err := parseFIT(test.file)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("err %v, want %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if activity.SportType != test.want.SportType || activity.StartDate != test.want.StartDate || activity.ElapsedTime != test.want.ElapsedTime ||
+				!closeTo(activity.Distance, test.want.Distance, 0.01) || activity.TotalElevationGain != test.want.TotalElevationGain {
+				t.Errorf("parsed %+v, want %+v", activity, test.want)
+			}
+			if len(altitude) != test.altitudes {
+				t.Errorf("read %d altitudes, want %d", len(altitude), test.altitudes)
+			}
+		})
+	}
+}
+
+func TestParseFITAltitude(t *testing.T) {
+	start := time.Date(2026, 2, 10, 12, 30, 0, 0, time.UTC)
+	_, altitude, err := parseFIT(fitFile(fitRecord(start, 0, 120.4), fitRecord(start.Add(time.Minute), 10, -12)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(altitude) != 2 || !closeTo(altitude[0], 120.4, 0.2) || !closeTo(altitude[1], -12, 0.2) {
+		t.Errorf("altitudes %v, want [120.4 -12]", altitude)
+	}
+}
+
+func closeTo(got float64, want float64, tolerance float64) bool {
+	return got >= want-tolerance && got <= want+tolerance
+}
@@ -0,0 +1,216 @@
+package taju
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"maps"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/tajuploader/tajiclient"
+)
+
+// formField is one field of the Taji log form as probed: its type, the
+// constraints the browser would enforce, and the values a radio group or
+// select offers.
+type formField struct {
+	Type     string   `json:"type"`
+	Required bool     `json:"required,omitempty"`
+	Step     string   `json:"step,omitempty"`
+	Min      string   `json:"min,omitempty"`
+	Max      string   `json:"max,omitempty"`
+	Choices  []string `json:"choices,omitempty"`
+}
+
+// formSchema is what the new entry form of one Taji activity takes. The
+// fields differ per activity (a ruck may ask for the pack weight, a swim has
+// no elevation), so each is probed on its own and cached in the ledger:
+// every post refreshes the schema of its activity, and taju forms probe
+// reads them all. Posting and validation follow the cached schema instead
+// of assuming every activity takes the run's fields: values for fields the
+// form doesn't have are left out, and runs missing a required field or of
+// an activity Taji doesn't offer are rejected before posting.
+type formSchema struct {
+	Activity string                `json:"activity"`
+	Fields   map[string]*formField `json:"fields"`
+	Probed   time.Time             `json:"probed"`
+}
+
+// probeForm reads the fields of a log form.
+func probeForm(body []byte, activity string) *formSchema {
+	schema := &formSchema{Activity: activity, Fields: make(map[string]*formField)}
+	for _, tag := range []string{"input", "textarea", "select"} {
+		for _, element := range tajiclient.FindElements(body, tag) {
+			name := element.Attr("name")
+			if name == "" || name == "csrfmiddlewaretoken" {
+				continue
+			}
+			field, ok := schema.Fields[name]
+			if !ok {
+				field = &formField{Type: strings.ToLower(element.Attr("type"))}
+				if tag != "input" || field.Type == "" {
+					field.Type = cmpOr(field.Type, tag)
+				}
+				schema.Fields[name] = field
+			}
+			field.Required = field.Required || element.Has("required")
+			field.Step = cmpOr(field.Step, element.Attr("step"))
+			field.Min = cmpOr(field.Min, element.Attr("min"))
+			field.Max = cmpOr(field.Max, element.Attr("max"))
+			switch {
+			case tag == "select":
+				field.Choices = append(field.Choices, tajiclient.SelectOptions(body, name)...)
+			case field.Type == "radio" || field.Type == "checkbox":
+				if value := element.Attr("value"); value != "" && !slices.Contains(field.Choices, value) {
+					field.Choices = append(field.Choices, value)
+				}
+			}
+		}
+	}
+	return schema
+}
+
+func cmpOr(value string, fallback string) string {
+	if value != "" {
+		return value
+	}
+	return fallback
+}
+
+// noteFormSchema caches the schema of a form just loaded for a post.
+// Without a ledger (check-forms) nothing is probed or filtered.
+func (s *settings) noteFormSchema(body []byte, activity string) {
+	if s.state == nil {
+		return
+	}
+	schema := probeForm(body, activity)
+	schema.Probed = s.state.clock.Now()
+	s.state.setFormSchema(schema)
+}
+
+func (s *stateStore) setFormSchema(schema *formSchema) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Forms == nil {
+		s.Forms = make(map[string]*formSchema)
+	}
+	s.Forms[schema.Activity] = schema
+}
+
+// formSchemaOf returns the cached schema of an activity's form, nil if it
+// wasn't probed yet.
+func (s *settings) formSchemaOf(activity string) *formSchema {
+	if s.state == nil {
+		return nil
+	}
+	s.state.mu.Lock()
+	defer s.state.mu.Unlock()
+	return s.state.Forms[activity]
+}
+
+// FORM_VALUE_FIELDS are the run values runValues fills in, by field.
+var FORM_VALUE_FIELDS = map[string]func(runDetails) string{
+	"distance":       func(r runDetails) string { return r.distance },
+	"elevation_gain": func(r runDetails) string { return r.elevation_gain },
+}
+
+// checkSchema rejects a run the probed form of its activity wouldn't take.
+func (f *formSchema) checkSchema(run runDetails) error {
+	if field, ok := f.Fields["activity"]; ok && len(field.Choices) > 0 && !slices.Contains(field.Choices, run.activity) {
+		return fmt.Errorf("the Taji form offers no activity %q (%s)", run.activity, strings.Join(field.Choices, ", "))
+	}
+	for name, value := range FORM_VALUE_FIELDS {
+		if field, ok := f.Fields[name]; ok && field.Required && value(run) == "" {
+			return fmt.Errorf("the Taji %s form requires %s", run.activity, strings.ReplaceAll(name, "_", " "))
+		}
+	}
+	return nil
+}
+
+// keeps reports whether the form has a field, true without a schema.
+func (f *formSchema) keeps(name string) bool {
+	if f == nil {
+		return true
+	}
+	_, ok := f.Fields[name]
+	return ok
+}
+
+// formsCommand probes the log form of every activity the uploader may post
+// and prints the schemas.
+func formsCommand(u *uploader, args []string) {
+	if len(args) < 1 || args[0] != "probe" {
+		log.Fatal("Usage: taju forms probe [activity...]")
+	}
+	flags := flag.NewFlagSet("forms probe", flag.ExitOnError)
+	flags.Parse(args[1:])
+	activities := flags.Args()
+	if len(activities) == 0 {
+		configured, err := formActivities(u.env)
+		if err != nil {
+			log.Fatal(err)
+		}
+		activities = slices.Sorted(maps.Keys(configured))
+	}
+
+	if err := initTajiSession(u); err != nil {
+		log.Fatal(err)
+	}
+	for _, activity := range activities {
+		body, _, err := u.taji.client.Form(u.taji.run.context(), "/log/new?activity="+url.QueryEscape(activity))
+		if err != nil {
+			log.Printf("Error probing the %s form: %v", activity, err)
+			continue
+		}
+		u.settings.noteFormSchema(body, activity)
+		printFormSchema(u.settings.formSchemaOf(activity))
+	}
+	if err := u.state.save(); err != nil {
+		log.Fatal(err)
+	}
+	dumpEnvFile(u)
+}
+
+// formActivities are the Taji activities the configuration can post.
+func formActivities(env map[string]string) (map[string]bool, error) {
+	activity_map, err := loadActivityMap(env)
+	if err != nil {
+		return nil, err
+	}
+	special_days, err := loadSpecialDays(env)
+	if err != nil {
+		return nil, err
+	}
+	activities := make(map[string]bool)
+	for _, activity := range activity_map {
+		if activity != "" {
+			activities[activity] = true
+		}
+	}
+	for _, day := range special_days {
+		if day.Activity != "" {
+			activities[day.Activity] = true
+		}
+	}
+	return activities, nil
+}
+
+func printFormSchema(schema *formSchema) {
+	fmt.Printf("\n%s form:\n", schema.Activity)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "FIELD\tTYPE\tREQUIRED\tSTEP\tMIN\tMAX\tCHOICES")
+	for _, name := range slices.Sorted(maps.Keys(schema.Fields)) {
+		field := schema.Fields[name]
+		required := ""
+		if field.Required {
+			required = "yes"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", name, field.Type, required, field.Step, field.Min, field.Max, strings.Join(field.Choices, ","))
+	}
+	w.Flush()
+}
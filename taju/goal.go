@@ -0,0 +1,189 @@
+package taju
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const DEFAULT_GOAL_MILES float64 = 100
+
+// goal is the event target. TAJU_GOAL_MILES sets the distance (100 by
+// default) and TAJU_GOAL_WEIGHTS how much a mile of each Taji activity
+// counts towards it when the event rules differ per activity, e.g.
+//
+//	TAJU_GOAL_WEIGHTS=bike=0.25,ruck=1.5
+//
+// Activities without a weight count one for one.
+//
+// TAJU_GOAL_ELEVATION adds a climbing goal, e.g. 10000 (feet) or 3000m,
+// tracked next to the distance from Strava's elevation gain.
+type goal struct {
+	miles     float64
+	weights   map[string]float64
+	elevation float64
+	// units are the display units the statuses are written in.
+	units units
+}
+
+const (
+	GOAL_MILES     string = "miles"
+	GOAL_ELEVATION string = "elevation"
+)
+
+// goalStatus is the progress towards the goal within the event window, in
+// miles or, for the elevation goal, feet. projected is zero when there is
+// no pace to project from yet.
+type goalStatus struct {
+	kind         string
+	target       float64
+	done         float64
+	percent      float64
+	daily_needed float64
+	projected    time.Time
+	window_end   time.Time
+	units        units
+}
+
+func loadGoal(env map[string]string, display units) (goal, error) {
+	g := goal{miles: DEFAULT_GOAL_MILES, weights: make(map[string]float64), units: display}
+	if value, ok := env["TAJU_GOAL_MILES"]; ok {
+		miles, err := parseMiles(value)
+		if err != nil || miles <= 0 {
+			return goal{}, fmt.Errorf("invalid TAJU_GOAL_MILES=%q, expected a positive number", value)
+		}
+		g.miles = miles
+	}
+	for _, pair := range splitList(env["TAJU_GOAL_WEIGHTS"]) {
+		activity, value, ok := strings.Cut(pair, "=")
+		weight, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if !ok || err != nil || weight < 0 {
+			return goal{}, fmt.Errorf("invalid TAJU_GOAL_WEIGHTS entry %q, expected activity=weight", pair)
+		}
+		g.weights[strings.ToLower(strings.TrimSpace(activity))] = weight
+	}
+	if value, ok := env["TAJU_GOAL_ELEVATION"]; ok {
+		meters, err := parseElevation(value)
+		if err != nil || meters <= 0 {
+			return goal{}, fmt.Errorf("invalid TAJU_GOAL_ELEVATION=%q, expected feet or a height like 3000m", value)
+		}
+		g.elevation = meter2feet(meters)
+	}
+	return g, nil
+}
+
+// progress returns the weighted miles the activities count for.
+func (g goal) progress(activities []runDetails) (miles float64) {
+	for _, activity := range activities {
+		weight, ok := g.weights[activity.activity]
+		if !ok {
+			weight = 1
+		}
+		miles += meter2mile(activity.distance_float) * weight
+	}
+	return
+}
+
+// climbed returns the feet of elevation gain of the activities.
+func (g goal) climbed(activities []runDetails) (feet float64) {
+	for _, activity := range activities {
+		feet += meter2feet(activity.elevation_float)
+	}
+	return
+}
+
+// status computes the progress at now for an event window [start, end).
+func (g goal) status(activities []runDetails, now time.Time, start time.Time, end time.Time) goalStatus {
+	status := track(GOAL_MILES, g.miles, g.progress(activities), now, start, end)
+	status.units = g.units
+	return status
+}
+
+// climbStatus is status for the elevation goal, zero without one.
+func (g goal) climbStatus(activities []runDetails, now time.Time, start time.Time, end time.Time) goalStatus {
+	if g.elevation == 0 {
+		return goalStatus{}
+	}
+	status := track(GOAL_ELEVATION, g.elevation, g.climbed(activities), now, start, end)
+	status.units = g.units
+	return status
+}
+
+func track(kind string, target float64, done float64, now time.Time, start time.Time, end time.Time) goalStatus {
+	status := goalStatus{kind: kind, target: target, done: done, window_end: end}
+	status.percent = 100 * status.done / target
+	remaining := math.Max(target-status.done, 0)
+
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	if days_left := end.Sub(today).Hours() / 24; days_left > 0 {
+		status.daily_needed = remaining / math.Ceil(days_left)
+	}
+	days_elapsed := math.Min(now.Sub(start).Hours(), end.Sub(start).Hours()) / 24
+	switch {
+	case remaining == 0:
+		status.projected = today
+	case status.done > 0 && days_elapsed > 0:
+		pace := status.done / days_elapsed
+		status.projected = now.Add(time.Duration(remaining / pace * 24 * float64(time.Hour)))
+	}
+	return status
+}
+
+// behind reports whether the projected finish falls after the event window.
+func (s goalStatus) behind() bool {
+	return s.done < s.target && !s.projected.IsZero() && !s.projected.Before(s.window_end)
+}
+
+// set reports whether there is a goal, the elevation goal being optional.
+func (s goalStatus) set() bool {
+	return s.target > 0
+}
+
+// format writes an amount of the goal in its units: miles or km with the
+// given decimals, feet or meters of climbing rounded.
+func (s goalStatus) format(value float64, decimals int) string {
+	if s.kind == GOAL_ELEVATION {
+		if s.units.distance == UNITS_KM {
+			return fmt.Sprintf("%.0f m", value/meter2feet(1))
+		}
+		return fmt.Sprintf("%.0f ft", value)
+	}
+	return fmt.Sprintf("%.*f %s", decimals, s.units.fromMiles(value), s.units.name())
+}
+
+// summary describes the status in a couple of lines for the terminal, in
+// the display units.
+func (s goalStatus) summary() string {
+	var lines []string
+	target := s.format(s.target, 0)
+	if s.kind == GOAL_ELEVATION {
+		target += " of climbing"
+	}
+	lines = append(lines, fmt.Sprintf("You are %.1f%% of the way to %s (%s logged).", s.percent, target, s.format(s.done, 2)))
+	if s.done >= s.target {
+		lines = append(lines, "Goal complete. Great job!")
+		return strings.Join(lines, "\n")
+	}
+	if s.daily_needed > 0 {
+		lines = append(lines, fmt.Sprintf("You need %s a day to finish by %s.", s.format(s.daily_needed, 2), s.window_end.AddDate(0, 0, -1).Format("Jan 2")))
+	}
+	if !s.projected.IsZero() {
+		lines = append(lines, fmt.Sprintf("At your current pace you'll finish on %s.", s.projected.Local().Format("Jan 2, 2006")))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func goalWindow(u *uploader) (now time.Time, start time.Time, end time.Time) {
+	now = u.clock.Now()
+	start, end, err := eventWindow(u.env, now)
+	if len(u.accounts) > 0 {
+		start, end, err = u.accounts[0].window_start, u.accounts[0].window_end, nil
+	}
+	if err != nil {
+		log.Print("Error:", err)
+	}
+	return
+}
@@ -0,0 +1,131 @@
+package taju
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"log"
+	"maps"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// GOLDEN_DIR holds the expected Taji form bodies of the check-forms
+// fixtures, one url-encoded body per file.
+const GOLDEN_DIR string = "testdata/forms"
+
+// formFixture is a representative activity whose form body Taji depends on
+// byte for byte: zero-padded time and duration fields, the 12 hour clock,
+// distance rounding and the idempotency note.
+type formFixture struct {
+	name     string
+	activity string
+	start    string
+	zone     string
+	duration int64
+	meters   float64
+	climb    float64
+}
+
+var FORM_FIXTURES = []formFixture{
+	{name: "short-run", activity: "run", start: "2026-02-03T12:05:00Z", zone: "America/New_York", duration: 9*60 + 7, meters: 1609.344, climb: 4},
+	{name: "long-run", activity: "run", start: "2026-02-08T14:30:00Z", zone: "America/New_York", duration: 2*3600 + 3*60 + 9, meters: 21097.5, climb: 152.4},
+	{name: "midnight-run", activity: "run", start: "2026-02-11T04:45:00Z", zone: "America/New_York", duration: 50 * 60, meters: 8046.72},
+	{name: "ruck", activity: "ruck", start: "2026-02-14T06:00:00Z", zone: "Europe/Berlin", duration: 3600 + 15*60, meters: 6437.376, climb: 80},
+}
+
+// body is the form body the fixture posts as Strava activity id, through
+// pipeline with the settings s, one url-encoded line as it is kept in its
+// golden.
+func (f formFixture) body(s *settings, pipeline runPipeline, id int64) ([]byte, error) {
+	location, err := time.LoadLocation(f.zone)
+	if err != nil {
+		return nil, err
+	}
+	run := s.createRun(f.activity, f.start, f.duration, f.meters)
+	run.strava_id = id
+	run.location = location
+	run.elevation_float = f.climb
+	run, err = pipeline.apply(run)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(s.runValues("CSRF", run).Encode() + "\n"), nil
+}
+
+// checkFormsCommand renders the fixtures with the default pipeline and
+// compares them with the goldens in --dir (make check). --update rewrites
+// the goldens after an intended change to the form fields.
+func checkFormsCommand(args []string) {
+	flags := flag.NewFlagSet("check-forms", flag.ExitOnError)
+	update := flags.Bool("update", false, "write the current form bodies as the new goldens")
+	dir := flags.String("dir", GOLDEN_DIR, "directory of the goldens")
+	flags.Parse(args)
+
+	settings := newSettings()
+	pipeline, err := loadPipeline(map[string]string{}, settings)
+	if err != nil {
+		log.Fatal(err)
+	}
+	failed := false
+	for i, fixture := range FORM_FIXTURES {
+		body, err := fixture.body(settings, pipeline, int64(1000+i))
+		if err != nil {
+			log.Fatalf("%s: %v", fixture.name, err)
+		}
+
+		path := filepath.Join(*dir, fixture.name+".golden")
+		if *update {
+			if err := os.MkdirAll(*dir, 0755); err != nil {
+				log.Fatal(err)
+			}
+			if err := os.WriteFile(path, body, 0644); err != nil {
+				log.Fatal(err)
+			}
+			continue
+		}
+		golden, err := os.ReadFile(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !bytes.Equal(body, golden) {
+			failed = true
+			fmt.Printf("%s: the form body changed\n", fixture.name)
+			for _, line := range formDiff(string(golden), string(body)) {
+				fmt.Println("  " + line)
+			}
+			continue
+		}
+		fmt.Printf("%s: ok\n", fixture.name)
+	}
+	if failed {
+		fmt.Println("Run `taju check-forms --update` if the change is intended.")
+		os.Exit(1)
+	}
+}
+
+// formDiff lists the fields that differ between two url-encoded bodies.
+func formDiff(want string, got string) (lines []string) {
+	want_values, _ := url.ParseQuery(strings.TrimSpace(want))
+	got_values, _ := url.ParseQuery(strings.TrimSpace(got))
+	fields := slices.Sorted(maps.Keys(want_values))
+	for field := range got_values {
+		if !want_values.Has(field) {
+			fields = append(fields, field)
+		}
+	}
+	for _, field := range fields {
+		if !slices.Equal(want_values[field], got_values[field]) {
+			lines = append(lines, fmt.Sprintf("%s: want %q, got %q", field, want_values[field], got_values[field]))
+		}
+	}
+	if len(lines) == 0 {
+		// Same values in another order.
+		lines = append(lines, "want "+strings.TrimSpace(want), "got  "+strings.TrimSpace(got))
+	}
+	return
+}
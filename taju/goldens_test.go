@@ -0,0 +1,63 @@
+package taju
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestFormGoldens(t *testing.T) {
+	settings := newSettings()
+	pipeline, err := loadPipeline(map[string]string{}, settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, fixture := range FORM_FIXTURES {
+		t.Run(fixture.name, func(t *testing.T) {
+			body, err := fixture.body(settings, pipeline, int64(1000+i))
+			if err != nil {
+				t.Fatal(err)
+			}
+			golden, err := os.ReadFile(filepath.Join(GOLDEN_DIR, fixture.name+".golden"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(body, golden) {
+				t.Errorf("the form body changed, run `taju check-forms --update` if intended:\n%q", formDiff(string(golden), string(body)))
+			}
+		})
+	}
+}
+
+func TestFormDiff(t *testing.T) {
+	tests := []struct {
+		name      string
+		want, got string
+		lines     []string
+	}{
+		{
+			name: "changed field",
+			want: "distance=3.10&time=07%3A05+AM", got: "distance=3.1&time=07%3A05+AM",
+			lines: []string{`distance: want ["3.10"], got ["3.1"]`},
+		},
+		{
+			name: "added and removed fields",
+			want: "distance=3.10&notes=x", got: "distance=3.10&photo=y",
+			lines: []string{`notes: want ["x"], got []`, `photo: want [], got ["y"]`},
+		},
+		{
+			name: "reordered",
+			want: "a=1&b=2\n", got: "b=2&a=1\n",
+			lines: []string{"want a=1&b=2", "got  b=2&a=1"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if lines := formDiff(test.want, test.got); !slices.Equal(lines, test.lines) {
+				t.Errorf("formDiff() = %q, want %q", lines, test.lines)
+			}
+		})
+	}
+}
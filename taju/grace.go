@@ -0,0 +1,30 @@
+package taju
+
+import (
+	"fmt"
+	"time"
+)
+
+// loadGracePeriod reads TAJU_GRACE_PERIOD, how long after an activity ends
+// it is left alone so it can still be cropped, corrected or renamed on
+// Strava before it is posted to Taji. Zero (the default) posts right away.
+func loadGracePeriod(env map[string]string) (time.Duration, error) {
+	value, ok := env["TAJU_GRACE_PERIOD"]
+	if !ok {
+		return 0, nil
+	}
+	grace, err := time.ParseDuration(value)
+	if err != nil || grace < 0 {
+		return 0, fmt.Errorf("invalid TAJU_GRACE_PERIOD=%q, expected a duration like 2h", value)
+	}
+	return grace, nil
+}
+
+// inGracePeriod reports whether run ended less than grace ago.
+func inGracePeriod(run runDetails, grace time.Duration, now time.Time) bool {
+	if grace == 0 || run.start.IsZero() {
+		return false
+	}
+	ended := run.start.Add(time.Duration(run.duration_int) * time.Second)
+	return now.Before(ended.Add(grace))
+}
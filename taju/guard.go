@@ -0,0 +1,154 @@
+package taju
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+)
+
+const (
+	DEFAULT_MAX_MILES float64 = 50
+	DEFAULT_MIN_PACE  string  = "3:00"
+)
+
+const (
+	REVIEW_APPROVED string = "approved"
+	REVIEW_REJECTED string = "rejected"
+)
+
+// guardRails hold activities with impossible values for review instead of
+// posting them: negative durations from data glitches, more than
+// TAJU_MAX_MILES (50 by default) in one activity, or a pace faster than
+// TAJU_MIN_PACE per mile (3:00 by default), usually a GPS jump or a ride
+// recorded as a run.
+type guardRails struct {
+	max_miles float64
+	min_pace  int64
+}
+
+// reviewItem is an activity held back by the guard rails. The sync leaves it
+// alone until `taju review` records a Decision.
+type reviewItem struct {
+	StravaId int64  `json:"strava_id"`
+	Activity string `json:"activity"`
+	Date     string `json:"date"`
+	Time     string `json:"time"`
+	Distance string `json:"distance"`
+	Duration string `json:"duration"`
+	Reason   string `json:"reason"`
+	Decision string `json:"decision,omitempty"`
+}
+
+func loadGuardRails(env map[string]string) (guardRails, error) {
+	guard := guardRails{max_miles: DEFAULT_MAX_MILES}
+	if value, ok := env["TAJU_MAX_MILES"]; ok {
+		miles, err := parseMiles(value)
+		if err != nil || miles <= 0 {
+			return guardRails{}, fmt.Errorf("invalid TAJU_MAX_MILES=%q, expected a positive number", value)
+		}
+		guard.max_miles = miles
+	}
+	pace := DEFAULT_MIN_PACE
+	if value, ok := env["TAJU_MIN_PACE"]; ok {
+		pace = value
+	}
+	seconds, ok := parseClockDuration(pace)
+	if !ok || seconds < 0 {
+		return guardRails{}, fmt.Errorf("invalid TAJU_MIN_PACE=%q, expected minutes:seconds per mile", pace)
+	}
+	guard.min_pace = seconds
+	return guard, nil
+}
+
+// check returns why a run looks impossible, or "" when it looks fine.
+func (g guardRails) check(run runDetails) string {
+	miles := meter2mile(run.distance_float)
+	switch {
+	case run.duration_int < 0:
+		return fmt.Sprintf("negative duration (%d s)", run.duration_int)
+	case miles > g.max_miles:
+		return fmt.Sprintf("%.2f mi is more than TAJU_MAX_MILES (%.0f)", miles, g.max_miles)
+	case miles > 0 && float64(run.duration_int)/miles < float64(g.min_pace):
+		pace := int64(float64(run.duration_int) / miles)
+		return fmt.Sprintf("pace %d:%02d/mi is faster than TAJU_MIN_PACE", pace/60, pace%60)
+	}
+	return ""
+}
+
+// holdForReview queues a run for review and reports whether it is new to
+// the queue.
+func (s *stateStore) holdForReview(run runDetails, reason string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Review == nil {
+		s.Review = make(map[int64]*reviewItem)
+	}
+	if _, ok := s.Review[run.strava_id]; ok {
+		return false
+	}
+	s.Review[run.strava_id] = &reviewItem{
+		StravaId: run.strava_id,
+		Activity: run.activity,
+		Date:     run.date,
+		Time:     run.time,
+		Distance: run.distance,
+		Duration: run.duration,
+		Reason:   reason,
+	}
+	return true
+}
+
+func (s *stateStore) reviewDecision(strava_id int64) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if item, ok := s.Review[strava_id]; ok {
+		return item.Decision
+	}
+	return ""
+}
+
+// reviewCommand walks through the activities held by the guard rails.
+// Approved activities are posted by the next sync, rejected ones never are.
+func reviewCommand(u *uploader) {
+	var held []*reviewItem
+	for _, item := range u.state.Review {
+		if item.Decision == "" {
+			held = append(held, item)
+		}
+	}
+	if len(held) == 0 {
+		fmt.Println("No activities are waiting for review.")
+		return
+	}
+	slices.SortFunc(held, func(a, b *reviewItem) int {
+		return strings.Compare(a.Date+a.Time, b.Date+b.Time)
+	})
+
+	reader := bufio.NewReader(os.Stdin)
+questions:
+	for i, item := range held {
+		fmt.Printf("\n(%d/%d) Strava %d: %s on %s at %s, %s mi in %s\n",
+			i+1, len(held), item.StravaId, item.Activity, item.Date, item.Time, item.Distance, item.Duration)
+		fmt.Printf("  held because: %s\n", item.Reason)
+		fmt.Print("Post it? [a]pprove, [r]eject, [l]ater, [q]uit: ")
+		answer, err := reader.ReadString('\n')
+		if err != nil {
+			break
+		}
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "a", "approve":
+			item.Decision = REVIEW_APPROVED
+		case "r", "reject":
+			item.Decision = REVIEW_REJECTED
+		case "q", "quit":
+			break questions
+		}
+	}
+
+	if err := u.state.save(); err != nil {
+		log.Fatal(err)
+	}
+}
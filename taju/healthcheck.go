@@ -0,0 +1,56 @@
+package taju
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const HEALTHCHECK_TIMEOUT = 10 * time.Second
+
+// registerHealthcheck pings TAJU_HEALTHCHECK_URL the way healthchecks.io
+// expects: <url>/start when a cycle begins, <url> when it succeeds and
+// <url>/fail when it fails. If the daemon stops syncing the check goes quiet
+// and the service alerts.
+func registerHealthcheck(s *syncer, env map[string]string, network networkSettings) {
+	check_url := strings.TrimRight(env["TAJU_HEALTHCHECK_URL"], "/")
+	if check_url == "" {
+		return
+	}
+	client := network.client(HEALTHCHECK_TIMEOUT)
+	subscribe(&s.events, func(cycleStarted) {
+		pingHealthcheck(client, check_url+"/start", nil)
+	})
+	subscribe(&s.events, func(e cycleCompleted) {
+		body, _ := json.Marshal(summarizeCycle("post", e.result))
+		if e.result.failed {
+			pingHealthcheck(client, check_url+"/fail", body)
+		} else {
+			pingHealthcheck(client, check_url, body)
+		}
+	})
+}
+
+func pingHealthcheck(client *http.Client, ping_url string, body []byte) {
+	ctx, cancel := context.WithTimeout(context.Background(), HEALTHCHECK_TIMEOUT)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", ping_url, bytes.NewReader(body))
+	if err != nil {
+		log.Print("Healthcheck ping failed: ", err)
+		return
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		log.Print("Healthcheck ping failed: ", err)
+		return
+	}
+	res.Body.Close()
+	if res.StatusCode >= 400 {
+		log.Print("Healthcheck ping failed: ", res.Status)
+	}
+}
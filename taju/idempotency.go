@@ -0,0 +1,36 @@
+package taju
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+)
+
+// The notes field of every uploaded entry carries a short hash of the Strava
+// activity id, so entries can be matched to activities even after their date,
+// time or distance were edited on either side. The parts of a split activity
+// (see splitRules) each have their own key.
+const IDEMPOTENCY_PREFIX string = "taju:"
+
+var IDEMPOTENCY_PATTERN = regexp.MustCompile(`taju:([0-9a-f]{12})`)
+
+func idempotencyKey(run runDetails) string {
+	if run.strava_id == 0 {
+		return ""
+	}
+	source := fmt.Sprintf("strava:%d", run.strava_id)
+	if run.part > 0 {
+		source += fmt.Sprintf(":%d", run.part)
+	}
+	sum := sha256.Sum256([]byte(source))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+func parseIdempotencyKey(notes string) string {
+	match := IDEMPOTENCY_PATTERN.FindStringSubmatch(notes)
+	if match == nil {
+		return ""
+	}
+	return match[1]
+}
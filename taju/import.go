@@ -0,0 +1,206 @@
+package taju
+
+import (
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Activity types written in GPX and TCX files, mapped to Strava sport types
+// so imported files go through TAJU_ACTIVITY_MAP like Strava activities.
+var FILE_SPORTS = map[string]string{
+	"run": "Run", "running": "Run", "trail_running": "TrailRun", "treadmill_running": "Run",
+	"walk": "Walk", "walking": "Walk", "hike": "Hike", "hiking": "Hike",
+	"ride": "Ride", "cycling": "Ride", "biking": "Ride",
+}
+
+type gpxFile struct {
+	Tracks []struct {
+		Type     string `xml:"type"`
+		Segments []struct {
+			Points []gpxPoint `xml:"trkpt"`
+		} `xml:"trkseg"`
+	} `xml:"trk"`
+}
+
+type gpxPoint struct {
+	Lat  float64   `xml:"lat,attr"`
+	Lon  float64   `xml:"lon,attr"`
+	Ele  *float64  `xml:"ele"`
+	Time time.Time `xml:"time"`
+}
+
+type tcxFile struct {
+	Activities []struct {
+		Sport string `xml:"Sport,attr"`
+		Laps  []struct {
+			StartTime      time.Time `xml:"StartTime,attr"`
+			TotalTime      float64   `xml:"TotalTimeSeconds"`
+			DistanceMeters float64   `xml:"DistanceMeters"`
+			Points         []struct {
+				Time     time.Time `xml:"Time"`
+				Altitude *float64  `xml:"AltitudeMeters"`
+			} `xml:"Track>Trackpoint"`
+		} `xml:"Lap"`
+	} `xml:"Activities>Activity"`
+}
+
+// parseActivityFile reads a GPX, TCX or FIT export into the Strava activity
+// fields the pipeline uses. Sports the file doesn't name are taken as runs.
+func parseActivityFile(path string) (stravaActivity, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return stravaActivity{}, err
+	}
+	var activity stravaActivity
+	var altitude []float64
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".gpx":
+		activity, altitude, err = parseGPX(data)
+	case ".tcx":
+		activity, altitude, err = parseTCX(data)
+	case ".fit":
+		activity, altitude, err = parseFIT(data)
+	default:
+		return activity, fmt.Errorf("%s: unknown file type, expected .gpx, .tcx or .fit", path)
+	}
+	if err != nil {
+		return activity, fmt.Errorf("%s: %w", path, err)
+	}
+	if activity.TotalElevationGain == 0 {
+		activity.TotalElevationGain = elevationGain(altitude)
+	}
+	if activity.SportType == "" {
+		activity.SportType = "Run"
+	}
+	activity.Name = filepath.Base(path)
+	return activity, nil
+}
+
+func parseGPX(data []byte) (activity stravaActivity, altitude []float64, err error) {
+	var file gpxFile
+	if err := xml.Unmarshal(data, &file); err != nil {
+		return activity, nil, err
+	}
+	var points []gpxPoint
+	for _, track := range file.Tracks {
+		if activity.SportType == "" {
+			activity.SportType = FILE_SPORTS[strings.ToLower(track.Type)]
+		}
+		for _, segment := range track.Segments {
+			points = append(points, segment.Points...)
+		}
+	}
+	if len(points) == 0 || points[0].Time.IsZero() {
+		return activity, nil, errors.New("GPX file has no timed track points")
+	}
+	for i, point := range points {
+		if i > 0 {
+			activity.Distance += haversine(points[i-1], point)
+		}
+		if point.Ele != nil {
+			altitude = append(altitude, *point.Ele)
+		}
+	}
+	activity.StartDate = points[0].Time.UTC().Format(time.RFC3339)
+	activity.ElapsedTime = int64(points[len(points)-1].Time.Sub(points[0].Time).Seconds())
+	return activity, altitude, nil
+}
+
+func parseTCX(data []byte) (activity stravaActivity, altitude []float64, err error) {
+	var file tcxFile
+	if err := xml.Unmarshal(data, &file); err != nil {
+		return activity, nil, err
+	}
+	if len(file.Activities) == 0 || len(file.Activities[0].Laps) == 0 {
+		return activity, nil, errors.New("TCX file has no laps")
+	}
+	tcx := file.Activities[0]
+	activity.SportType = FILE_SPORTS[strings.ToLower(tcx.Sport)]
+	var first, last time.Time
+	for _, lap := range tcx.Laps {
+		activity.Distance += lap.DistanceMeters
+		activity.ElapsedTime += int64(lap.TotalTime)
+		for _, point := range lap.Points {
+			if first.IsZero() {
+				first = point.Time
+			}
+			last = point.Time
+			if point.Altitude != nil {
+				altitude = append(altitude, *point.Altitude)
+			}
+		}
+	}
+	start := tcx.Laps[0].StartTime
+	if start.IsZero() {
+		start = first
+	}
+	// Paused time counts towards the elapsed time, like on Strava.
+	if !first.IsZero() && last.Sub(start).Seconds() > float64(activity.ElapsedTime) {
+		activity.ElapsedTime = int64(last.Sub(start).Seconds())
+	}
+	activity.StartDate = start.UTC().Format(time.RFC3339)
+	return activity, altitude, nil
+}
+
+// haversine is the distance between two track points in meters.
+func haversine(a gpxPoint, b gpxPoint) float64 {
+	const EARTH_RADIUS = 6371000
+	lat1, lat2 := a.Lat*math.Pi/180, b.Lat*math.Pi/180
+	dlat := lat2 - lat1
+	dlon := (b.Lon - a.Lon) * math.Pi / 180
+	h := math.Sin(dlat/2)*math.Sin(dlat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dlon/2)*math.Sin(dlon/2)
+	return 2 * EARTH_RADIUS * math.Asin(math.Sqrt(h))
+}
+
+// importCommand uploads activities from exported files, for activities
+// that aren't on Strava. They are mapped like Strava activities and posted
+// like taju add.
+func importCommand(u *uploader, args []string) {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	activity_flag := flags.String("activity", "", "Taji activity to log the files as, instead of mapping their sport")
+	force := flags.Bool("force", false, "post even if an activity looks like a duplicate")
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		log.Fatal("Usage: taju import [--activity run] [--force] <file.gpx|file.tcx|file.fit>...")
+	}
+
+	activity_map, err := loadActivityMap(u.env)
+	if err != nil {
+		log.Fatal(err)
+	}
+	pipeline, err := loadPipeline(u.env, u.settings)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var runs []runDetails
+	for _, path := range flags.Args() {
+		activity, err := parseActivityFile(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		taji_activity := *activity_flag
+		if taji_activity == "" {
+			var ok bool
+			if taji_activity, ok = tajiActivity(activity_map, activity); !ok {
+				log.Fatalf("%s: %s activities aren't uploaded, see TAJU_ACTIVITY_MAP or use --activity", path, activity.SportType)
+			}
+		}
+		run := u.settings.createRun(taji_activity, activity.StartDate, activity.ElapsedTime, activity.Distance)
+		run.elevation_float = activity.TotalElevationGain
+		if run, err = pipeline.apply(run); err != nil {
+			log.Fatalf("%s: %v", path, err)
+		}
+		runs = append(runs, run)
+	}
+	if !postManual(u, runs, *force) {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,123 @@
+package taju
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const TEST_GPX = `<?xml version="1.0" encoding="UTF-8"?>
+<gpx version="1.1" creator="test" xmlns="http://www.topografix.com/GPX/1/1">
+  <trk>
+    <type>running</type>
+    <trkseg>
+      <trkpt lat="40.0000" lon="-75.0000"><ele>10</ele><time>2026-02-10T12:00:00Z</time></trkpt>
+      <trkpt lat="40.0090" lon="-75.0000"><ele>20</ele><time>2026-02-10T12:05:00Z</time></trkpt>
+    </trkseg>
+    <trkseg>
+      <trkpt lat="40.0180" lon="-75.0000"><ele>15</ele><time>2026-02-10T12:10:30Z</time></trkpt>
+    </trkseg>
+  </trk>
+</gpx>`
+
+const TEST_TCX = `<?xml version="1.0" encoding="UTF-8"?>
+<TrainingCenterDatabase xmlns="http://www.garmin.com/xmlschemas/TrainingCenterDatabase/v2">
+  <Activities>
+    <Activity Sport="Biking">
+      <Lap StartTime="2026-02-10T07:00:00Z">
+        <TotalTimeSeconds>600</TotalTimeSeconds>
+        <DistanceMeters>4000</DistanceMeters>
+        <Track>
+          <Trackpoint><Time>2026-02-10T07:00:00Z</Time><AltitudeMeters>50</AltitudeMeters></Trackpoint>
+          <Trackpoint><Time>2026-02-10T07:10:00Z</Time><AltitudeMeters>60</AltitudeMeters></Trackpoint>
+        </Track>
+      </Lap>
+      <Lap StartTime="2026-02-10T07:20:00Z">
+        <TotalTimeSeconds>600</TotalTimeSeconds>
+        <DistanceMeters>4500.5</DistanceMeters>
+        <Track>
+          <Trackpoint><Time>2026-02-10T07:20:00Z</Time><AltitudeMeters>60</AltitudeMeters></Trackpoint>
+          <Trackpoint><Time>2026-02-10T07:30:00Z</Time><AltitudeMeters>52</AltitudeMeters></Trackpoint>
+        </Track>
+      </Lap>
+    </Activity>
+  </Activities>
+</TrainingCenterDatabase>`
+
+func TestParseActivityFile(t *testing.T) {
+	tests := []struct {
+		name      string
+		file      string
+		content   string
+		sport     string
+		start     string
+		elapsed   int64
+		distance  float64
+		elevation float64
+		err       string
+	}{
+		// Two 0.009 degree steps north are about 1001 m each; the pause
+		// between the segments counts towards the elapsed time.
+		{name: "GPX", file: "morning.gpx", content: TEST_GPX, sport: "Run", start: "2026-02-10T12:00:00Z", elapsed: 630, distance: 2001.5, elevation: 10},
+		// Laps add up, and the 10 minutes between them count as elapsed.
+		{name: "TCX", file: "ride.TCX", content: TEST_TCX, sport: "Ride", start: "2026-02-10T07:00:00Z", elapsed: 1800, distance: 8500.5, elevation: 10},
+		{
+			name: "GPX without a type is a run", file: "walk.gpx",
+			content: strings.Replace(TEST_GPX, "<type>running</type>", "", 1),
+			sport:   "Run", start: "2026-02-10T12:00:00Z", elapsed: 630, distance: 2001.5, elevation: 10,
+		},
+		{name: "GPX without times", file: "route.gpx", content: `<gpx><trk><trkseg><trkpt lat="1" lon="2"/></trkseg></trk></gpx>`, err: "no timed track points"},
+		{name: "TCX without laps", file: "empty.tcx", content: `<TrainingCenterDatabase><Activities><Activity Sport="Running"/></Activities></TrainingCenterDatabase>`, err: "no laps"},
+		{name: "not XML", file: "broken.gpx", content: "<gpx><trk>", err: "broken.gpx"},
+		{name: "unknown type", file: "run.kml", content: "<kml/>", err: "unknown file type"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), test.file)
+			if err := os.WriteFile(path, []byte(test.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			activity, err := parseActivityFile(path)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("err %v, want %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if activity.SportType != test.sport || activity.StartDate != test.start || activity.ElapsedTime != test.elapsed {
+				t.Errorf("read %s at %s for %ds, want %s at %s for %ds", activity.SportType, activity.StartDate, activity.ElapsedTime, test.sport, test.start, test.elapsed)
+			}
+			if !closeTo(activity.Distance, test.distance, 2) {
+				t.Errorf("distance %.1f m, want %.1f", activity.Distance, test.distance)
+			}
+			if !closeTo(activity.TotalElevationGain, test.elevation, 0.01) {
+				t.Errorf("elevation gain %.1f m, want %.1f", activity.TotalElevationGain, test.elevation)
+			}
+			if activity.Name != test.file {
+				t.Errorf("name %q, want the file name", activity.Name)
+			}
+		})
+	}
+}
+
+func TestParseFITFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "watch.fit")
+	start := FIT_EPOCH.AddDate(36, 1, 10)
+	if err := os.WriteFile(path, fitFile(fitRecord(start, 0, 100), fitRecord(start.Add(5*time.Minute), 1000, 110), fitRecord(start.Add(10*time.Minute), 2000, 104)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	activity, err := parseActivityFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The gain comes from the altitude of the records, the sport defaults
+	// to a run.
+	if activity.SportType != "Run" || activity.ElapsedTime != 600 || !closeTo(activity.TotalElevationGain, 10, 0.2) {
+		t.Errorf("read %+v", activity)
+	}
+}
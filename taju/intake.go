@@ -0,0 +1,177 @@
+package taju
+
+import (
+	"log"
+	"log/slog"
+	"slices"
+	"time"
+)
+
+// The activities of every source (Strava accounts, import directories,
+// the sources of an embedding program) get the same intake before they
+// are synced: the activity filters and the duplicate rule pick them, then
+// each is split across days, sent through the pipeline and kept to the
+// event window. The sources only differ in how they read activities.
+type intake struct {
+	filters      activityFilters
+	duplicates   duplicateRule
+	split        splitRules
+	pipeline     runPipeline
+	window_dates string
+	window_start time.Time
+	window_end   time.Time
+}
+
+// sourceActivity is what the filters and the duplicate rule go by, for an
+// activity of any source. activity is the Taji activity it maps to, ""
+// for none, and device the name of what recorded it, if known.
+type sourceActivity struct {
+	id          int64
+	name        string
+	description string
+	private     bool
+	commute     bool
+	race        bool
+	gear        string
+	device      string
+	activity    string
+	start       time.Time
+	distance    float64
+	elapsed     int64
+}
+
+// loadIntake loads the intake of env for the event window [start, end).
+func loadIntake(env map[string]string, s *settings, start time.Time, end time.Time) (in intake, err error) {
+	in.window_start, in.window_end = start, end
+	if in.filters, err = loadActivityFilters(env); err != nil {
+		return intake{}, err
+	}
+	if in.duplicates, err = loadDuplicateRule(env); err != nil {
+		return intake{}, err
+	}
+	if in.split, err = loadSplitRules(env, s); err != nil {
+		return intake{}, err
+	}
+	if in.pipeline, err = loadPipeline(env, s); err != nil {
+		return intake{}, err
+	}
+	if in.window_dates, err = loadWindowDates(env); err != nil {
+		return intake{}, err
+	}
+	return in, nil
+}
+
+// pick keeps the activities the filters let through and one of every
+// group recorded twice, in start order.
+func (in *intake) pick(activities []sourceActivity) []sourceActivity {
+	activities = in.filter(activities, func(activity sourceActivity, reason string) {
+		slog.Debug("Skipping activity", "id", activity.id, "name", activity.name, "reason", reason)
+	})
+	return in.dropDuplicates(activities, nil, func(activity sourceActivity, best sourceActivity) {
+		log.Printf("Skipping activity %d (%q), it was recorded twice, keeping %d (%q)", activity.id, activity.name, best.id, best.name)
+	})
+}
+
+// filter drops the activities the filters skip, telling skipped why.
+func (in *intake) filter(activities []sourceActivity, skipped func(activity sourceActivity, reason string)) []sourceActivity {
+	return slices.DeleteFunc(activities, func(activity sourceActivity) bool {
+		reason, skip := in.filters.skip(activity)
+		if skip {
+			skipped(activity, reason)
+		}
+		return skip
+	})
+}
+
+// dropDuplicates keeps one activity of every group recorded twice, and
+// returns the activities in start order. devices, if set, reads the device
+// names of the activities recorded twice that don't have one; dropped is
+// told of every activity left out and the one kept instead.
+func (in *intake) dropDuplicates(activities []sourceActivity, devices func(ids []int64) map[int64]string,
+	dropped func(activity sourceActivity, best sourceActivity)) (kept []sourceActivity) {
+	slices.SortStableFunc(activities, func(a, b sourceActivity) int { return a.start.Compare(b.start) })
+	if in.duplicates.prefer == DUPLICATES_OFF {
+		return activities
+	}
+	var groups [][]sourceActivity
+	for _, activity := range activities {
+		if len(groups) > 0 {
+			group := groups[len(groups)-1]
+			first := group[0]
+			if activity.start.Sub(first.start) <= in.duplicates.window && first.activity != "" && first.activity == activity.activity {
+				groups[len(groups)-1] = append(group, activity)
+				continue
+			}
+		}
+		groups = append(groups, []sourceActivity{activity})
+	}
+
+	if in.duplicates.prefer == DUPLICATES_DEVICE && devices != nil {
+		var ids []int64
+		for _, group := range groups {
+			for _, activity := range group {
+				if len(group) > 1 && activity.device == "" {
+					ids = append(ids, activity.id)
+				}
+			}
+		}
+		if len(ids) > 0 {
+			names := devices(ids)
+			for _, group := range groups {
+				for i := range group {
+					if name, ok := names[group[i].id]; ok {
+						group[i].device = name
+					}
+				}
+			}
+		}
+	}
+	for _, group := range groups {
+		best := group[0]
+		for _, activity := range group[1:] {
+			if in.duplicates.better(activity, best) {
+				best = activity
+			}
+		}
+		for _, activity := range group {
+			if activity.id != best.id {
+				dropped(activity, best)
+			}
+		}
+		kept = append(kept, best)
+	}
+	return
+}
+
+// runs splits run across days per the configuration, sends every part
+// through apply (the pipeline, unless it is traced) and keeps those in the
+// event window: by their logged dates with TAJU_WINDOW_DATES=local, else
+// by the start of run.
+func (in *intake) runs(run runDetails, apply func(runDetails) (runDetails, error)) ([]runDetails, error) {
+	if in.window_dates != WINDOW_LOCAL && !in.inWindow(run) {
+		slog.Debug("Skipping activity", "id", run.strava_id, "reason", "outside the event window")
+		return nil, nil
+	}
+	var runs []runDetails
+	for _, part := range in.split.split(run) {
+		part, err := apply(part)
+		if err != nil {
+			return nil, err
+		}
+		if in.window_dates == WINDOW_LOCAL && !in.inWindow(part) {
+			slog.Debug("Skipping activity", "id", part.strava_id, "reason", "dated "+part.date+", outside the event window")
+			continue
+		}
+		runs = append(runs, part)
+	}
+	return runs, nil
+}
+
+// inWindow reports whether run belongs to the event window: by its start,
+// or with TAJU_WINDOW_DATES=local by the date it is logged on.
+func (in *intake) inWindow(run runDetails) bool {
+	if in.window_dates == WINDOW_LOCAL {
+		return inWindow(in.window_dates, run, in.window_start, in.window_end)
+	}
+	return !run.start.Before(in.window_start) && run.start.Before(in.window_end)
+}
@@ -0,0 +1,150 @@
+package taju
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const JOURNAL_FILENAME string = "taju.journal.json"
+
+const (
+	JOURNAL_PLANNED string = "planned"
+	JOURNAL_STARTED string = "started"
+	JOURNAL_DONE    string = "done"
+	JOURNAL_FAILED  string = "failed"
+)
+
+// cycleJournal records the actions of the cycle being executed, and how far
+// each one got, before anything is sent to Taji. The file is removed when
+// the cycle finishes, so finding one means the last cycle died mid-way
+// (crash, kill -9, power loss); see recover. Every change is synced to disk
+// since the ledger is only saved at the end of a cycle.
+type cycleJournal struct {
+	mu      sync.Mutex
+	path    string
+	Started time.Time       `json:"started"`
+	Actions []journalAction `json:"actions"`
+}
+
+type journalAction struct {
+	Kind     string `json:"kind"`
+	StravaId int64  `json:"strava_id,omitempty"`
+	Part     int    `json:"part,omitempty"`
+	LogId    string `json:"log_id,omitempty"`
+	Activity string `json:"activity,omitempty"`
+	Date     string `json:"date"`
+	Time     string `json:"time"`
+	Status   string `json:"status"`
+}
+
+// loadJournal returns the journal left behind by an interrupted cycle, if any.
+func loadJournal(path string) (*cycleJournal, bool) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, false
+	}
+	j := &cycleJournal{path: path}
+	if err == nil {
+		err = json.Unmarshal(data, j)
+	}
+	if err != nil {
+		log.Print("Ignoring unreadable cycle journal ", path, ": ", err)
+		os.Remove(path)
+		return nil, false
+	}
+	return j, true
+}
+
+// beginJournal writes the plan before it is executed.
+func beginJournal(path string, plan []plannedAction, now time.Time) (*cycleJournal, error) {
+	j := &cycleJournal{path: path, Started: now}
+	for _, action := range plan {
+		entry := journalAction{Kind: action.kind, StravaId: action.run.strava_id, Part: action.run.part, LogId: action.event.entry,
+			Activity: action.run.activity, Date: action.run.date, Time: action.run.time, Status: JOURNAL_PLANNED}
+		if action.kind == ACTION_DELETE {
+			entry.Date, entry.Time = action.event.date, action.event.time
+		}
+		j.Actions = append(j.Actions, entry)
+	}
+	return j, j.write()
+}
+
+// mark records the progress of the i-th action of the plan.
+func (j *cycleJournal) mark(i int, status string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.Actions[i].Status = status
+	if err := j.write(); err != nil {
+		log.Print("Error writing the cycle journal: ", err)
+	}
+}
+
+// finish removes the journal once the cycle is over.
+func (j *cycleJournal) finish() {
+	if err := os.Remove(j.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Print("Error removing the cycle journal: ", err)
+	}
+}
+
+// write replaces the journal file. Post workers mark actions concurrently,
+// so callers hold j.mu to keep an older snapshot from landing last.
+func (j *cycleJournal) write() error {
+	data, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(j.path), ".taju-journal-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), j.path)
+}
+
+// recover settles the actions of an interrupted cycle against what Taji
+// shows now. A post that made it to Taji is linked in the ledger (its notes
+// carry the idempotency key) so it is never posted twice; one that didn't
+// is planned again like any new activity. Edits and deletes are compared
+// again by the planner anyway, so they are only reported.
+func (j *cycleJournal) recover(state *stateStore, s *settings, events []tajiEvent) {
+	log.Printf("The sync cycle started at %s was interrupted, checking its changes on Taji", j.Started.Local().Format(time.DateTime))
+	for _, action := range j.Actions {
+		if action.Status == JOURNAL_PLANNED || action.Status == JOURNAL_FAILED {
+			continue
+		}
+		run := runDetails{strava_id: action.StravaId, part: action.Part, activity: action.Activity, date: action.Date, time: action.Time}
+		switch action.Kind {
+		case ACTION_POST:
+			if event, ok := s.findEvent(run, events); ok {
+				state.link(run, event)
+				log.Printf("Recovered %s on %s at %s: it is Taji entry %s", run.activity, run.date, run.time, event.entry)
+			} else if action.Status == JOURNAL_STARTED {
+				log.Printf("%s on %s at %s didn't reach Taji, it will be posted again", run.activity, run.date, run.time)
+			}
+		case ACTION_UPDATE, ACTION_DELETE:
+			if action.Status == JOURNAL_STARTED {
+				log.Printf("The %s of Taji entry %s may not have finished, it will be checked again", action.Kind, action.LogId)
+			}
+		}
+	}
+	if err := state.save(); err != nil {
+		log.Print("Error saving the state after recovery: ", err)
+	}
+	j.finish()
+}
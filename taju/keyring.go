@@ -0,0 +1,86 @@
+package taju
+
+import (
+	"fmt"
+	"sync"
+)
+
+const KEYRING_PREFIX string = "keyring:"
+const KEYRING_SERVICE string = "tajuploader"
+
+// With TAJU_CREDENTIALS=keyring the secret values are kept by the operating
+// system instead of taju.env: the macOS Keychain, the Secret Service (GNOME
+// Keyring, KWallet) through secret-tool on Linux, and DPAPI on Windows. The
+// env file only keeps a placeholder (on Windows the DPAPI-protected value,
+// which only the same Windows user can read). When the keyring can't be
+// reached the value falls back to the env file encryption.
+const CREDENTIALS_KEYRING string = "keyring"
+
+type keyringEntry struct {
+	value  string
+	stored string
+}
+
+// keyringCache remembers what the keyring holds per account, so rewriting
+// taju.env doesn't call out to the keyring for unchanged values. Profiles
+// syncing at once share it.
+var (
+	keyringCache = make(map[string]keyringEntry)
+	keyringMu    sync.Mutex
+)
+
+// keyringAccount names the keyring item of a secret: the key, after the
+// profile (or participant) whose env file it belongs to, so the profiles'
+// STRAVA_TOKEN and TAJI_SESSION items don't overwrite each other. The
+// placeholder in the env file carries the account, see keyringSet.
+func keyringAccount(profile string, key string) string {
+	if profile == "" {
+		return key
+	}
+	return profile + "/" + key
+}
+
+// loadCredentials returns where TAJU_CREDENTIALS keeps the secret values.
+func loadCredentials(env map[string]string) (string, error) {
+	switch backend := env["TAJU_CREDENTIALS"]; backend {
+	case "":
+		return CREDENTIALS_FILE, nil
+	case CREDENTIALS_FILE, CREDENTIALS_KEYRING, CREDENTIALS_PASSPHRASE:
+		return backend, nil
+	default:
+		return "", fmt.Errorf("invalid TAJU_CREDENTIALS=%q, expected file, keyring or passphrase", backend)
+	}
+}
+
+// sealKeyring stores a secret in the keyring under account and returns
+// what goes into the env file in its place.
+func sealKeyring(account string, value string) (string, error) {
+	keyringMu.Lock()
+	cached, ok := keyringCache[account]
+	keyringMu.Unlock()
+	if ok && cached.value == value {
+		return cached.stored, nil
+	}
+	stored, err := keyringSet(account, value)
+	if err != nil {
+		return "", err
+	}
+	keyringMu.Lock()
+	keyringCache[account] = keyringEntry{value, stored}
+	keyringMu.Unlock()
+	return stored, nil
+}
+
+// openKeyring reads a secret whose env file value is a keyring placeholder.
+// Placeholders written before profiles had their own accounts name the
+// bare key, and are still read from there.
+func openKeyring(account string, stored string) (string, error) {
+	value, err := keyringGet(stored)
+	if err != nil {
+		return "", err
+	}
+	keyringMu.Lock()
+	keyringCache[account] = keyringEntry{value, stored}
+	keyringMu.Unlock()
+	return value, nil
+}
@@ -0,0 +1,18 @@
+package taju
+
+import (
+	"os/exec"
+	"strings"
+)
+
+// The placeholder names the Keychain item's account.
+func keyringSet(account string, value string) (string, error) {
+	err := exec.Command("security", "add-generic-password", "-U", "-s", KEYRING_SERVICE, "-a", account, "-w", value).Run()
+	return KEYRING_PREFIX + account, err
+}
+
+func keyringGet(stored string) (string, error) {
+	account := strings.TrimPrefix(stored, KEYRING_PREFIX)
+	out, err := exec.Command("security", "find-generic-password", "-s", KEYRING_SERVICE, "-a", account, "-w").Output()
+	return strings.TrimSuffix(string(out), "\n"), err
+}
@@ -0,0 +1,54 @@
+package taju
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestKeyringAccount(t *testing.T) {
+	tests := []struct {
+		profile string
+		key     string
+		want    string
+	}{
+		{key: "STRAVA_TOKEN", want: "STRAVA_TOKEN"},
+		{profile: "alice", key: "STRAVA_TOKEN", want: "alice/STRAVA_TOKEN"},
+		{profile: "bob", key: "TAJI_SESSION", want: "bob/TAJI_SESSION"},
+	}
+	for _, test := range tests {
+		if got := keyringAccount(test.profile, test.key); got != test.want {
+			t.Errorf("account of %s for %q is %q, want %q", test.key, test.profile, got, test.want)
+		}
+	}
+	if keyringAccount("alice", "TAJI_SESSION") == keyringAccount("bob", "TAJI_SESSION") {
+		t.Error("two profiles share a keyring item")
+	}
+}
+
+// TestKeyringCache seals unchanged values from several profiles at once,
+// as profiles syncing together do; they come from the cache without
+// calling the keyring.
+func TestKeyringCache(t *testing.T) {
+	saved := keyringCache
+	t.Cleanup(func() { keyringCache = saved })
+	keyringCache = make(map[string]keyringEntry)
+	for i := range 8 {
+		account := keyringAccount(fmt.Sprint("p", i), "TAJI_SESSION")
+		keyringCache[account] = keyringEntry{value: "session", stored: KEYRING_PREFIX + account}
+	}
+
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			account := keyringAccount(fmt.Sprint("p", i), "TAJI_SESSION")
+			stored, err := sealKeyring(account, "session")
+			if err != nil || stored != KEYRING_PREFIX+account {
+				t.Errorf("sealed %s as %q (%v), want its own item", account, stored, err)
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,27 @@
+package taju
+
+import (
+	"os/exec"
+	"strings"
+)
+
+// DPAPI protects the value with the Windows user's credentials. PowerShell's
+// SecureString conversion is DPAPI underneath; the protected value is kept
+// in the env file itself. Values are passed on stdin to stay out of the
+// process list.
+func keyringSet(account string, value string) (string, error) {
+	cmd := exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command",
+		"[Console]::In.ReadToEnd() | ConvertTo-SecureString -AsPlainText -Force | ConvertFrom-SecureString")
+	cmd.Stdin = strings.NewReader(value)
+	out, err := cmd.Output()
+	return KEYRING_PREFIX + strings.TrimSpace(string(out)), err
+}
+
+func keyringGet(stored string) (string, error) {
+	cmd := exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command",
+		"$s = [Console]::In.ReadToEnd().Trim() | ConvertTo-SecureString; "+
+			"[Runtime.InteropServices.Marshal]::PtrToStringBSTR([Runtime.InteropServices.Marshal]::SecureStringToBSTR($s))")
+	cmd.Stdin = strings.NewReader(strings.TrimPrefix(stored, KEYRING_PREFIX))
+	out, err := cmd.Output()
+	return strings.TrimRight(string(out), "\r\n"), err
+}
@@ -0,0 +1,25 @@
+package taju
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// singleKeys switches the terminal to reading single keys without echoing
+// them. Output processing and signals are left alone, so log lines and
+// Ctrl-C work as before.
+func singleKeys(f *os.File) (restore func(), err error) {
+	fd := int(f.Fd())
+	saved, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err != nil {
+		return nil, err
+	}
+	keys := *saved
+	keys.Lflag &^= unix.ICANON | unix.ECHO
+	keys.Cc[unix.VMIN], keys.Cc[unix.VTIME] = 1, 0
+	if err := unix.IoctlSetTermios(fd, unix.TCSETS, &keys); err != nil {
+		return nil, err
+	}
+	return func() { unix.IoctlSetTermios(fd, unix.TCSETS, saved) }, nil
+}
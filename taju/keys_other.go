@@ -0,0 +1,13 @@
+//go:build !darwin && !linux && !windows
+
+package taju
+
+import (
+	"errors"
+	"os"
+)
+
+// singleKeys isn't supported here, keys are read a line at a time.
+func singleKeys(f *os.File) (restore func(), err error) {
+	return nil, errors.New("single keys aren't supported on this system")
+}
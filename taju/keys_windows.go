@@ -0,0 +1,21 @@
+package taju
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// singleKeys switches the console to reading single keys without echoing
+// them. Processed input stays on, so Ctrl-C works as before.
+func singleKeys(f *os.File) (restore func(), err error) {
+	handle := windows.Handle(f.Fd())
+	var saved uint32
+	if err := windows.GetConsoleMode(handle, &saved); err != nil {
+		return nil, err
+	}
+	if err := windows.SetConsoleMode(handle, saved&^(windows.ENABLE_LINE_INPUT|windows.ENABLE_ECHO_INPUT)); err != nil {
+		return nil, err
+	}
+	return func() { windows.SetConsoleMode(handle, saved) }, nil
+}
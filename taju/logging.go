@@ -0,0 +1,193 @@
+package taju
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/tajuploader/config"
+)
+
+const (
+	DEFAULT_LOG_MAX_MB = 10
+	LOG_BACKUPS        = 3
+)
+
+// logOptions are the --log-level, --log-format and --log-file flags, which
+// override TAJU_LOG_LEVEL, TAJU_LOG_FORMAT and TAJU_LOG_FILE.
+var logOptions struct {
+	level  string
+	format string
+	file   string
+}
+
+// configureLogging sets up levels, the output format and the log file once
+// the env file is loaded. Everything still goes through the redactor.
+//
+// Most of taju logs with the log package; a bridge gives those lines a
+// level from their wording (see messageLevel), so they are filtered and
+// formatted like the slog records. Lines are stamped with the uploader's
+// clock, so a TAJU_FAKE_NOW run logs the time it pretends it is.
+func configureLogging(env map[string]string, state_dir string, c clock) error {
+	option := func(flag string, key string) string {
+		if flag != "" {
+			return flag
+		}
+		return env[key]
+	}
+
+	var level slog.Level
+	if value := option(logOptions.level, "TAJU_LOG_LEVEL"); value != "" {
+		if err := level.UnmarshalText([]byte(value)); err != nil {
+			return fmt.Errorf("invalid log level %q, expected debug, info, warn or error", value)
+		}
+	}
+
+	if path := option(logOptions.file, "TAJU_LOG_FILE"); path != "" {
+		max_mb := DEFAULT_LOG_MAX_MB
+		if value, ok := env["TAJU_LOG_MAX_MB"]; ok {
+			n, err := strconv.Atoi(value)
+			if err != nil || n <= 0 {
+				return fmt.Errorf("invalid TAJU_LOG_MAX_MB=%q", value)
+			}
+			max_mb = n
+		}
+		file, err := openRotatingFile(config.StatePath(state_dir, path), int64(max_mb)<<20)
+		if err != nil {
+			return fmt.Errorf("can't open the log file: %w", err)
+		}
+		logRedactor.out = file
+	}
+
+	bridge := &logBridge{level: level, out: logRedactor, clock: c}
+	options := &slog.HandlerOptions{Level: level}
+	switch format := option(logOptions.format, "TAJU_LOG_FORMAT"); format {
+	case "":
+		// The plain log lines taju always wrote.
+		slog.SetLogLoggerLevel(level)
+	case "text":
+		bridge.handler = slog.NewTextHandler(logRedactor, options)
+	case "json":
+		bridge.handler = slog.NewJSONHandler(logRedactor, options)
+	default:
+		return fmt.Errorf("invalid log format %q, expected text or json", format)
+	}
+	if bridge.handler != nil {
+		slog.SetDefault(slog.New(bridge.handler))
+	}
+	log.SetFlags(0)
+	log.SetOutput(bridge)
+	return nil
+}
+
+// logBridge receives the lines of the log package.
+type logBridge struct {
+	level   slog.Level
+	out     io.Writer
+	handler slog.Handler
+	clock   clock
+}
+
+func (b *logBridge) Write(p []byte) (int, error) {
+	level, message := messageLevel(strings.TrimSuffix(string(p), "\n"))
+	if level < b.level {
+		return len(p), nil
+	}
+	if b.handler != nil {
+		record := slog.NewRecord(b.clock.Now(), level, message, 0)
+		return len(p), b.handler.Handle(context.Background(), record)
+	}
+	_, err := fmt.Fprintf(b.out, "%s %s\n", b.clock.Now().Format("2006/01/02 15:04:05"), strings.TrimSuffix(string(p), "\n"))
+	return len(p), err
+}
+
+// messageLevel guesses the level of a log package line. Lines written by
+// slog's default handler carry theirs.
+func messageLevel(message string) (slog.Level, string) {
+	for _, level := range []slog.Level{slog.LevelDebug, slog.LevelInfo, slog.LevelWarn, slog.LevelError} {
+		if rest, ok := strings.CutPrefix(message, level.String()+" "); ok {
+			return level, rest
+		}
+	}
+	switch {
+	case strings.HasPrefix(message, "Error"), strings.HasPrefix(message, "Failed"), strings.Contains(message, " failed"):
+		return slog.LevelError, message
+	case strings.HasPrefix(message, "Ignoring"), strings.HasPrefix(message, "Warning"):
+		return slog.LevelWarn, message
+	}
+	return slog.LevelInfo, message
+}
+
+// registerDecisionLog logs every decision of a cycle at debug level, to
+// find out why an activity was (or wasn't) uploaded.
+func registerDecisionLog(s *syncer) {
+	subscribe(&s.events, func(e activityDecided) {
+		attrs := []any{"strava_id", e.run.strava_id, "activity", e.run.activity, "date", e.run.date, "time", e.run.time,
+			"distance", e.run.distance, "duration", e.run.duration, "decision", e.decision, "result", e.result}
+		if e.err != nil {
+			attrs = append(attrs, "error", e.err.Error())
+		}
+		if s.u.profile != "" {
+			attrs = append(attrs, "profile", s.u.profile)
+		}
+		slog.Debug("activity", attrs...)
+	})
+}
+
+// rotatingFile is a log file that is rolled over to path.1 (and path.1 to
+// path.2, up to LOG_BACKUPS) when it grows past max bytes.
+type rotatingFile struct {
+	mu   sync.Mutex
+	path string
+	max  int64
+	size int64
+	file *os.File
+}
+
+func openRotatingFile(path string, max int64) (*rotatingFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	r := &rotatingFile{path: path, max: max}
+	return r, r.open()
+}
+
+func (r *rotatingFile) open() error {
+	file, err := os.OpenFile(r.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	r.file, r.size = file, info.Size()
+	return nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.size > 0 && r.size+int64(len(p)) > r.max {
+		r.file.Close()
+		for i := LOG_BACKUPS - 1; i > 0; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", r.path, i), fmt.Sprintf("%s.%d", r.path, i+1))
+		}
+		os.Rename(r.path, r.path+".1")
+		if err := r.open(); err != nil {
+			// Keep logging to stderr rather than losing the lines.
+			r.file, r.size = os.Stderr, 0
+		}
+	}
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
@@ -0,0 +1,21 @@
+package taju
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestLogBridgeClock(t *testing.T) {
+	var plain, structured bytes.Buffer
+	c := newFakeClock(TEST_NOW)
+	(&logBridge{out: &plain, clock: c}).Write([]byte("Synced 2 activities\n"))
+	if want := "2026/02/20 12:00:00 Synced 2 activities\n"; plain.String() != want {
+		t.Errorf("plain line = %q, want %q", plain.String(), want)
+	}
+	(&logBridge{out: &structured, clock: c, handler: slog.NewJSONHandler(&structured, nil)}).Write([]byte("Synced 2 activities\n"))
+	if !strings.Contains(structured.String(), `"time":"2026-02-20T12:00:00Z"`) {
+		t.Errorf("JSON line = %s, want the fake clock's time", structured.String())
+	}
+}
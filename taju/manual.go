@@ -0,0 +1,257 @@
+package taju
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/tajuploader/tajiclient"
+)
+
+const DEFAULT_MANUAL_TIME string = "12:00"
+
+// MANUAL_TIME_LAYOUTS are the clock times taju add and edit accept, and the
+// ones the Taji form uses (see units.timeLayout).
+var MANUAL_TIME_LAYOUTS = []string{"15:04", "3:04PM", "3:04 PM", "03:04:PM"}
+
+// manualValues are the values of an entry typed on the command line. Empty
+// values are left as they are when editing. Distances and elevation are in
+// the units of the Taji form (see settings) and feet.
+type manualValues struct {
+	activity  string
+	date      string
+	time      string
+	distance  string
+	duration  string
+	elevation string
+}
+
+// flags adds the flags of the values, the distance in the units of the
+// form.
+func (m *manualValues) flags(flags *flag.FlagSet, taji units) {
+	flags.StringVar(&m.activity, "activity", m.activity, "Taji activity, e.g. run, walk or bike")
+	flags.StringVar(&m.date, "date", "", "day of the activity, YYYY-MM-DD")
+	flags.StringVar(&m.time, "time", m.time, "start time, e.g. 07:30 or 7:30PM")
+	flags.StringVar(&m.distance, "distance", "", "distance in "+taji.name()+", or with a unit like 5,2km")
+	flags.StringVar(&m.duration, "duration", "", "duration, h:mm:ss, mm:ss or e.g. 45m")
+	flags.StringVar(&m.elevation, "elevation", "", "elevation gain in feet, or with a unit like 40m")
+}
+
+// parseTypedDuration reads h:mm:ss, mm:ss or a duration like 45m or
+// 1h05m into seconds.
+func parseTypedDuration(value string) (int64, bool) {
+	if seconds, ok := parseClockDuration(value); ok {
+		return seconds, true
+	}
+	d, err := time.ParseDuration(strings.ReplaceAll(value, " ", ""))
+	if err != nil {
+		return 0, false
+	}
+	return int64(d.Seconds()), true
+}
+
+// parseElevation reads an elevation gain in feet, or with a unit, into
+// meters.
+func parseElevation(value string) (float64, error) {
+	value = strings.TrimSpace(value)
+	if strings.TrimRightFunc(value, unicode.IsLetter) == value {
+		value += "ft"
+	}
+	return parseDistance(value, UNITS_MILES)
+}
+
+// manualRun builds a run from manual values and fills in the form fields
+// with the same pipeline Strava activities go through.
+func (s *settings) manualRun(env map[string]string, m manualValues) (runDetails, error) {
+	run := runDetails{activity: m.activity}
+	if _, err := time.Parse(DATE_FORMAT, m.date); err != nil {
+		return run, fmt.Errorf("invalid date %q, expected YYYY-MM-DD", m.date)
+	}
+	for _, layout := range MANUAL_TIME_LAYOUTS {
+		if start, err := time.ParseInLocation(DATE_FORMAT+" "+layout, m.date+" "+strings.ToUpper(m.time), time.Local); err == nil {
+			run.start = start
+			break
+		}
+	}
+	if run.start.IsZero() {
+		return run, fmt.Errorf("invalid time %q, expected e.g. 07:30 or 7:30PM", m.time)
+	}
+	if m.distance != "" {
+		distance, err := parseDistance(m.distance, s.taji_units.distance)
+		if err != nil || distance < 0 {
+			return run, fmt.Errorf("invalid distance %q, expected e.g. 3.1, 5,2km or 3.1mi", m.distance)
+		}
+		run.distance_float = distance
+	}
+	duration, ok := parseTypedDuration(m.duration)
+	if !ok || duration <= 0 {
+		return run, fmt.Errorf("invalid duration %q, expected h:mm:ss, mm:ss or e.g. 45m", m.duration)
+	}
+	run.duration_int = duration
+	if m.elevation != "" {
+		elevation, err := parseElevation(m.elevation)
+		if err != nil || elevation < 0 {
+			return run, fmt.Errorf("invalid elevation %q", m.elevation)
+		}
+		run.elevation_float = elevation
+	}
+	pipeline, err := loadPipeline(env, s)
+	if err != nil {
+		return run, err
+	}
+	return pipeline.apply(run)
+}
+
+// addCommand posts an activity that never made it to Strava. It goes
+// through the same checks as a sync: nothing is posted over an entry at the
+// same time, and a likely duplicate needs --force. The entry is kept in the
+// ledger as manual so syncs don't treat it as a stray Taji entry.
+func addCommand(u *uploader, args []string) {
+	flags := flag.NewFlagSet("add", flag.ExitOnError)
+	m := manualValues{activity: "run", time: DEFAULT_MANUAL_TIME}
+	m.flags(flags, u.settings.taji_units)
+	force := flags.Bool("force", false, "post even if it looks like a duplicate")
+	flags.Parse(args)
+	if m.date == "" || m.duration == "" {
+		log.Fatal("Usage: taju add --date YYYY-MM-DD --duration h:mm:ss [--distance 3.1] [--time 07:30] [--activity run] [--elevation ft]")
+	}
+	run, err := u.settings.manualRun(u.env, m)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if !postManual(u, []runDetails{run}, *force) {
+		os.Exit(1)
+	}
+}
+
+// postManual posts runs that don't come from Strava (taju add, taju
+// import). Nothing is posted over an entry at the same time, and a likely
+// duplicate is only posted with force. It reports whether all were posted.
+func postManual(u *uploader, runs []runDetails, force bool) bool {
+	if err := initTajiSession(u); err != nil {
+		log.Fatal(err)
+	}
+	entries, err := getTajiEntries(&u.taji)
+	if err != nil {
+		log.Fatal(err)
+	}
+	events, unknown := u.state.knownEvents(entries, u.taji.rows)
+	scraped, err := getTajiEvents(&u.taji, unknown)
+	if err != nil {
+		log.Fatal(err)
+	}
+	u.state.cacheEvents(scraped)
+	u.state.noteListing(u.taji.rows)
+	u.state.forgetVanished(entries)
+	events = append(events, scraped...)
+
+	ok := true
+	for _, run := range runs {
+		if event, found := u.settings.findEvent(run, events); found {
+			log.Printf("Taji already has entry %s on %s at %s, change it with: taju edit %s", event.entry, event.date, event.time, event.entry)
+			ok = false
+			continue
+		}
+		if event, found := findSuspectedDuplicate(run, events); found && !force {
+			log.Printf("Taji entry %s on %s at %s has the same distance, use --force to post anyway", event.entry, event.date, event.time)
+			ok = false
+			continue
+		}
+		if _, err := postRun(&u.taji, run); err != nil {
+			log.Print("Error:", err)
+			ok = false
+			continue
+		}
+		entries, events = refreshTajiEvents(&u.taji, entries, events)
+		if event, found := u.settings.findEvent(run, events); found {
+			u.state.recordManual(event)
+			log.Printf("Logged %s %s %s on %s at %s as Taji entry %s", run.distance, u.settings.taji_units.distance, run.activity, run.date, run.time, event.entry)
+		} else {
+			log.Printf("Posted %s on %s at %s, but it doesn't show up on Taji yet", run.activity, run.date, run.time)
+		}
+	}
+	if err := u.state.save(); err != nil {
+		log.Print("Error:", err)
+	}
+	return ok
+}
+
+// editCommand corrects an entry on Taji. Values that aren't given are kept.
+func editCommand(u *uploader, args []string) {
+	if len(args) < 1 || strings.HasPrefix(args[0], "-") {
+		log.Fatal("Usage: taju edit <log id> [--date YYYY-MM-DD] [--time 07:30] [--distance 3.1] [--duration h:mm:ss] [--activity run] [--elevation ft]")
+	}
+	log_id := args[0]
+	flags := flag.NewFlagSet("edit", flag.ExitOnError)
+	var m manualValues
+	m.flags(flags, u.settings.taji_units)
+	flags.Parse(args[1:])
+
+	if err := initTajiSession(u); err != nil {
+		log.Fatal(err)
+	}
+	edit_path := fmt.Sprintf("/log/%s/edit", log_id)
+	form, csrfmiddlewaretoken, err := u.taji.client.Form(u.taji.run.context(), edit_path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	entry, err := tajiclient.ParseEntryForm(form, log_id)
+	if err != nil {
+		log.Fatal(err)
+	}
+	event := entryEvent(entry)
+	current := manualValues{activity: "run", date: event.date, time: event.time, distance: event.distance, duration: event.duration}
+	if input, ok := tajiclient.FindInput(form, "activity"); ok && input.Attr("value") != "" {
+		current.activity = input.Attr("value")
+	}
+	if input, ok := tajiclient.FindInput(form, "elevation_gain"); ok {
+		current.elevation = input.Attr("value")
+	}
+	for _, field := range []struct{ value, current *string }{
+		{&m.activity, &current.activity}, {&m.date, &current.date}, {&m.time, &current.time},
+		{&m.distance, &current.distance}, {&m.duration, &current.duration}, {&m.elevation, &current.elevation},
+	} {
+		if *field.value != "" {
+			*field.current = *field.value
+		}
+	}
+	run, err := u.settings.manualRun(u.env, current)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("Taji entry %s: %s %s %s on %s at %s -> %s %s %s on %s at %s\n", log_id,
+		event.distance, u.settings.taji_units.distance, event.duration, event.date, event.time,
+		run.distance, u.settings.taji_units.distance, run.duration, run.date, run.time)
+	if !confirm("Save this change?") {
+		return
+	}
+	// The whole form is posted, so the notes (and with them the idempotency
+	// key of an entry posted from Strava) are sent back as they were.
+	values := u.settings.runValues(csrfmiddlewaretoken, run)
+	if notes, ok := tajiclient.FindInput(form, "notes"); ok {
+		values.Set("notes", notes.Text+notes.Attr("value"))
+	}
+	res, err := u.taji.client.PostForm(u.taji.run.context(), edit_path, values)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer res.Body.Close()
+	if err := u.taji.client.CheckForm(res, "updating entry "+log_id); err != nil {
+		log.Fatal(err)
+	}
+
+	event.date, event.time, event.distance, event.duration = run.date, run.time, run.distance, run.duration
+	if strava_id, ok := u.state.updateTajiValues(event); ok {
+		log.Printf("Taji entry %s comes from Strava activity %d, the next sync may change it back (see TAJU_POLICY_STRAVA_EDIT); TAJU_OVERRIDE_%d keeps a correction", log_id, strava_id, strava_id)
+	}
+	if err := u.state.save(); err != nil {
+		log.Print("Error:", err)
+	}
+	log.Print("Updated Taji entry ", log_id)
+}
@@ -0,0 +1,192 @@
+package taju
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// eventMatch is how close a Taji entry without the run's idempotency key
+// has to be to count as the run's entry. A key (and the ledger, whose
+// entries carry their activity's key, see knownEvents) is always tried
+// first; then an entry at the same date and time; then, so a minute of
+// rounding or a different way of writing the time doesn't post the run
+// again, an entry on the same date starting within window whose distance is
+// within distance (a fraction) of the run's:
+//
+//	TAJU_MATCH_WINDOW=2m       0 only takes the exact time
+//	TAJU_MATCH_DISTANCE=0.02
+type eventMatch struct {
+	window   time.Duration
+	distance float64
+}
+
+const (
+	DEFAULT_MATCH_WINDOW   = 2 * time.Minute
+	DEFAULT_MATCH_DISTANCE = 0.02
+)
+
+func loadEventMatch(env map[string]string) (eventMatch, error) {
+	m := eventMatch{window: DEFAULT_MATCH_WINDOW, distance: DEFAULT_MATCH_DISTANCE}
+	if value, ok := env["TAJU_MATCH_WINDOW"]; ok {
+		window, err := time.ParseDuration(value)
+		if err != nil || window < 0 {
+			return m, fmt.Errorf("invalid TAJU_MATCH_WINDOW=%q, expected a duration like 2m", value)
+		}
+		m.window = window
+	}
+	if value, ok := env["TAJU_MATCH_DISTANCE"]; ok {
+		distance, err := strconv.ParseFloat(value, 64)
+		if err != nil || distance < 0 || distance >= 1 {
+			return m, fmt.Errorf("invalid TAJU_MATCH_DISTANCE=%q, expected a fraction like 0.02", value)
+		}
+		m.distance = distance
+	}
+	return m, nil
+}
+
+// CLOCK_LAYOUTS are the ways a time of day has been seen written on Taji.
+var CLOCK_LAYOUTS = []string{"03:04:PM", "03:04 PM", "3:04 PM", "3:04PM", "03:04:05 PM", "15:04", "15:04:05"}
+
+// parseClockTime reads a time of day as seconds after midnight.
+func parseClockTime(value string) (int64, bool) {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	for _, layout := range CLOCK_LAYOUTS {
+		if t, err := time.Parse(layout, value); err == nil {
+			return int64(t.Hour()*3600 + t.Minute()*60 + t.Second()), true
+		}
+	}
+	return 0, false
+}
+
+// matchRule is the rule that matched a Taji entry to a run, see
+// eventIndex.match. The rules are tried in this order.
+type matchRule int
+
+const (
+	MATCH_NONE matchRule = iota
+	// MATCH_KEY: the entry carries the run's idempotency key.
+	MATCH_KEY
+	// MATCH_START: the entry starts on the run's date at its time of day.
+	MATCH_START
+	// MATCH_NEAR: the entry is on the run's date within the window of the
+	// eventMatch, with about the run's distance.
+	MATCH_NEAR
+)
+
+func (r matchRule) String() string {
+	return [...]string{"none", "idempotency key", "start", "near start"}[r]
+}
+
+// eventStart parses a start as written on the Taji form, a DATE_FORMAT date
+// and a time of day in one of CLOCK_LAYOUTS, as the instant it reads as in
+// UTC. The form has no zone, and neither does the comparison.
+func eventStart(date string, clock string) (time.Time, bool) {
+	day, err := time.Parse(DATE_FORMAT, date)
+	if err != nil {
+		return time.Time{}, false
+	}
+	seconds, ok := parseClockTime(clock)
+	if !ok {
+		return time.Time{}, false
+	}
+	return day.Add(time.Duration(seconds) * time.Second), true
+}
+
+// eventIndex holds Taji events with their keys and starts parsed once, so
+// matching every run of a cycle against hundreds of entries doesn't parse
+// each entry's time once per run.
+type eventIndex struct {
+	events []tajiEvent
+	// starts are the parsed starts of events, zero where they don't parse.
+	starts []time.Time
+	by_key map[string]int
+	// settings has the TAJU_MATCH_ rules and the rounding of the runs.
+	settings *settings
+}
+
+func indexEvents(events []tajiEvent, s *settings) *eventIndex {
+	x := &eventIndex{events: events, starts: make([]time.Time, len(events)), by_key: make(map[string]int), settings: s}
+	for i, event := range events {
+		if start, ok := eventStart(event.date, event.time); ok {
+			x.starts[i] = start
+		}
+		if _, seen := x.by_key[event.key]; event.key != "" && !seen {
+			x.by_key[event.key] = i
+		}
+	}
+	return x
+}
+
+// match returns the Taji event that is run's entry and the rule that
+// matched it:
+//
+//   - the event carrying the run's idempotency key, whatever its date and
+//     time (it was posted for the run and edited since on either side);
+//   - else the first event starting at the run's date and time of day,
+//     compared parsed, so 7:05 AM and 07:05 AM are the same start; events
+//     whose time doesn't parse match when written exactly the same;
+//   - else the event on the run's date starting closest to it within
+//     rules.window with about its distance (see similar), unless it
+//     carries another activity's key.
+//
+// The result is MATCH_NONE if none is the run's.
+func (x *eventIndex) match(run runDetails, rules eventMatch) (tajiEvent, matchRule) {
+	key := idempotencyKey(run)
+	if i, ok := x.by_key[key]; key != "" && ok {
+		return x.events[i], MATCH_KEY
+	}
+	start, parsed := eventStart(run.date, run.time)
+	for i, event := range x.events {
+		if event.date != run.date {
+			continue
+		}
+		if (parsed && x.starts[i].Equal(start)) || event.time == run.time {
+			return event, MATCH_START
+		}
+	}
+	if !parsed || rules.window <= 0 {
+		return tajiEvent{}, MATCH_NONE
+	}
+	best := -1
+	var best_gap time.Duration
+	for i, event := range x.events {
+		if x.starts[i].IsZero() || event.date != run.date || (event.key != "" && event.key != key) {
+			continue
+		}
+		gap := x.starts[i].Sub(start).Abs()
+		if gap > rules.window || !x.similar(run, event, rules) {
+			continue
+		}
+		if best < 0 || gap < best_gap {
+			best, best_gap = i, gap
+		}
+	}
+	if best < 0 {
+		return tajiEvent{}, MATCH_NONE
+	}
+	return x.events[best], MATCH_NEAR
+}
+
+// find is match with the TAJU_MATCH_ rules.
+func (x *eventIndex) find(run runDetails) (tajiEvent, bool) {
+	event, rule := x.match(run, x.settings.match)
+	return event, rule != MATCH_NONE
+}
+
+// similar compares the distances as posted, or the durations of runs
+// posted without a distance.
+func (x *eventIndex) similar(run runDetails, event tajiEvent, m eventMatch) bool {
+	distance, err_run := strconv.ParseFloat(run.distance, 64)
+	other, err_event := strconv.ParseFloat(event.distance, 64)
+	if err_run != nil || err_event != nil || distance <= 0 || other <= 0 {
+		if run.distance != "" || event.distance != "" {
+			return false
+		}
+		duration, ok := parseClockDuration(event.duration)
+		return ok && absInt64(x.settings.roundDuration(duration)-run.duration_int) < 60
+	}
+	return math.Abs(distance-other) <= math.Max(m.distance*math.Max(distance, other), 0.01)
+}
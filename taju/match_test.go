@@ -0,0 +1,135 @@
+package taju
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEventIndexMatch(t *testing.T) {
+	rules := eventMatch{window: DEFAULT_MATCH_WINDOW, distance: DEFAULT_MATCH_DISTANCE}
+	run := runDetails{strava_id: 1, date: "2026-02-10", time: "07:05 AM", distance: "3.10", duration_int: 1800}
+	key := idempotencyKey(run)
+	other := idempotencyKey(runDetails{strava_id: 2})
+
+	tests := []struct {
+		name   string
+		run    runDetails
+		rules  *eventMatch
+		events []tajiEvent
+		want   string
+		rule   matchRule
+	}{
+		{
+			name:   "idempotency key wins over an edited start",
+			events: []tajiEvent{{entry: "1", date: "2026-02-10", time: "07:05 AM"}, {entry: "2", date: "2026-02-11", time: "09:00 AM", key: key}},
+			want:   "2", rule: MATCH_KEY,
+		},
+		{
+			name:   "same start",
+			events: []tajiEvent{{entry: "1", date: "2026-02-10", time: "06:00 AM"}, {entry: "2", date: "2026-02-10", time: "07:05 AM"}},
+			want:   "2", rule: MATCH_START,
+		},
+		{
+			name:   "same start written differently",
+			events: []tajiEvent{{entry: "1", date: "2026-02-10", time: "7:05AM"}},
+			want:   "1", rule: MATCH_START,
+		},
+		{
+			name:   "24 hour clock",
+			run:    runDetails{date: "2026-02-10", time: "07:05 PM"},
+			events: []tajiEvent{{entry: "1", date: "2026-02-10", time: "19:05"}},
+			want:   "1", rule: MATCH_START,
+		},
+		{
+			name:   "unparsed times written the same",
+			run:    runDetails{date: "2026-02-10", time: "morning"},
+			events: []tajiEvent{{entry: "1", date: "2026-02-10", time: "morning"}},
+			want:   "1", rule: MATCH_START,
+		},
+		{
+			name:   "another day",
+			events: []tajiEvent{{entry: "1", date: "2026-02-11", time: "07:05 AM", distance: "3.10"}},
+		},
+		{
+			name:   "nearest within the window",
+			events: []tajiEvent{{entry: "1", date: "2026-02-10", time: "07:03 AM", distance: "3.10"}, {entry: "2", date: "2026-02-10", time: "07:06 AM", distance: "3.12"}},
+			want:   "2", rule: MATCH_NEAR,
+		},
+		{
+			name:   "near but another distance",
+			events: []tajiEvent{{entry: "1", date: "2026-02-10", time: "07:06 AM", distance: "4.00"}},
+		},
+		{
+			name:   "near but outside the window",
+			events: []tajiEvent{{entry: "1", date: "2026-02-10", time: "07:08 AM", distance: "3.10"}},
+		},
+		{
+			name:   "near but another activity's entry",
+			events: []tajiEvent{{entry: "1", date: "2026-02-10", time: "07:06 AM", distance: "3.10", key: other}},
+		},
+		{
+			name:   "exact time only",
+			rules:  &eventMatch{},
+			events: []tajiEvent{{entry: "1", date: "2026-02-10", time: "07:06 AM", distance: "3.10"}},
+		},
+		{
+			name:   "near without distances compares durations",
+			run:    runDetails{date: "2026-02-10", time: "07:05 AM", duration_int: 1800},
+			events: []tajiEvent{{entry: "1", date: "2026-02-10", time: "07:06 AM", duration: "00:30:20"}},
+			want:   "1", rule: MATCH_NEAR,
+		},
+		{
+			name: "no events",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r, m := run, rules
+			if test.run.date != "" {
+				r = test.run
+			}
+			if test.rules != nil {
+				m = *test.rules
+			}
+			event, rule := indexEvents(test.events, newSettings()).match(r, m)
+			if event.entry != test.want || rule != test.rule {
+				t.Errorf("match() = entry %q by %s, want entry %q by %s", event.entry, rule, test.want, test.rule)
+			}
+		})
+	}
+}
+
+func TestEventStart(t *testing.T) {
+	tests := []struct {
+		date, clock string
+		want        time.Time
+		ok          bool
+	}{
+		{date: "2026-02-10", clock: "07:05 AM", want: time.Date(2026, 2, 10, 7, 5, 0, 0, time.UTC), ok: true},
+		{date: "2026-02-10", clock: "12:00 AM", want: time.Date(2026, 2, 10, 0, 0, 0, 0, time.UTC), ok: true},
+		{date: "2026-02-10", clock: "23:59:30", want: time.Date(2026, 2, 10, 23, 59, 30, 0, time.UTC), ok: true},
+		{date: "2026-02-10", clock: "noon"},
+		{date: "Feb 10", clock: "07:05 AM"},
+	}
+	for _, test := range tests {
+		got, ok := eventStart(test.date, test.clock)
+		if ok != test.ok || !got.Equal(test.want) {
+			t.Errorf("eventStart(%q, %q) = %v, %v, want %v, %v", test.date, test.clock, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+func BenchmarkFindEvent(b *testing.B) {
+	var events []tajiEvent
+	start := time.Date(2026, 2, 1, 6, 0, 0, 0, time.UTC)
+	for i := range 300 {
+		at := start.Add(time.Duration(i) * 97 * time.Minute)
+		events = append(events, tajiEvent{entry: "e", date: at.Format(DATE_FORMAT), time: at.Format("03:04 PM"), distance: "2.00"})
+	}
+	run := runDetails{date: "2026-02-28", time: "11:11 PM", distance: "5.00"}
+	index := indexEvents(events, newSettings())
+	b.ResetTimer()
+	for range b.N {
+		index.find(run)
+	}
+}
@@ -0,0 +1,108 @@
+package taju
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// syncMetrics are the counters served in the Prometheus text format on
+// /metrics of the control server. They are process-wide, so with profiles
+// they add up every profile.
+var syncMetrics struct {
+	activities_fetched atomic.Int64
+	uploads_attempted  atomic.Int64
+	uploads_succeeded  atomic.Int64
+	uploads_failed     atomic.Int64
+	cycles             atomic.Int64
+	cycles_failed      atomic.Int64
+	last_success       atomic.Int64
+	retries            atomic.Int64
+
+	mu         sync.Mutex
+	rate_limit map[string]int
+}
+
+// registerMetrics counts the events of s.
+func registerMetrics(s *syncer) {
+	subscribe(&s.events, func(activityDiscovered) {
+		syncMetrics.activities_fetched.Add(1)
+	})
+	subscribe(&s.events, func(e entryPosted) {
+		syncMetrics.uploads_attempted.Add(1)
+		if e.err != nil {
+			syncMetrics.uploads_failed.Add(1)
+		} else {
+			syncMetrics.uploads_succeeded.Add(1)
+		}
+	})
+	subscribe(&s.events, func(e cycleCompleted) {
+		syncMetrics.cycles.Add(1)
+		if e.result.failed {
+			syncMetrics.cycles_failed.Add(1)
+		} else {
+			syncMetrics.last_success.Store(s.u.clock.Now().Unix())
+		}
+	})
+}
+
+// noteRateLimit keeps the requests left in Strava's short (15 minute) and
+// daily windows, from the X-RateLimit headers of every API response.
+func noteRateLimit(res *http.Response) {
+	limits := strings.Split(res.Header.Get("X-RateLimit-Limit"), ",")
+	usage := strings.Split(res.Header.Get("X-RateLimit-Usage"), ",")
+	if len(limits) != 2 || len(usage) != 2 {
+		return
+	}
+	syncMetrics.mu.Lock()
+	defer syncMetrics.mu.Unlock()
+	if syncMetrics.rate_limit == nil {
+		syncMetrics.rate_limit = make(map[string]int)
+	}
+	for i, window := range []string{"short", "daily"} {
+		limit, err1 := strconv.Atoi(strings.TrimSpace(limits[i]))
+		used, err2 := strconv.Atoi(strings.TrimSpace(usage[i]))
+		if err1 == nil && err2 == nil {
+			syncMetrics.rate_limit[window] = limit - used
+		}
+	}
+}
+
+func serveMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	metric := func(name string, kind string, help string, value int64) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", name, help, name, kind, name, value)
+	}
+	metric("taju_activities_fetched_total", "counter", "Strava activities fetched.", syncMetrics.activities_fetched.Load())
+	metric("taju_uploads_attempted_total", "counter", "Posts to Taji.", syncMetrics.uploads_attempted.Load())
+	metric("taju_uploads_succeeded_total", "counter", "Posts to Taji that succeeded.", syncMetrics.uploads_succeeded.Load())
+	metric("taju_uploads_failed_total", "counter", "Posts to Taji that failed.", syncMetrics.uploads_failed.Load())
+	metric("taju_sync_cycles_total", "counter", "Sync cycles run.", syncMetrics.cycles.Load())
+	metric("taju_sync_cycles_failed_total", "counter", "Sync cycles that failed.", syncMetrics.cycles_failed.Load())
+	metric("taju_http_retries_total", "counter", "HTTP requests retried after an error or rate limit.", syncMetrics.retries.Load())
+	metric("taju_last_successful_sync_timestamp_seconds", "gauge", "Unix time of the last sync cycle that succeeded.", syncMetrics.last_success.Load())
+
+	syncMetrics.mu.Lock()
+	defer syncMetrics.mu.Unlock()
+	if len(syncMetrics.rate_limit) > 0 {
+		fmt.Fprint(w, "# HELP taju_strava_rate_limit_remaining Strava API requests left in the window.\n# TYPE taju_strava_rate_limit_remaining gauge\n")
+		for _, window := range []string{"short", "daily"} {
+			if remaining, ok := syncMetrics.rate_limit[window]; ok {
+				fmt.Fprintf(w, "taju_strava_rate_limit_remaining{window=%q} %d\n", window, remaining)
+			}
+		}
+	}
+}
+
+// serveHealthz answers liveness probes while the sync loop runs.
+func serveHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprintln(w, "ok")
+	if last := syncMetrics.last_success.Load(); last > 0 {
+		fmt.Fprintln(w, "last successful sync:", time.Unix(last, 0).UTC().Format(time.RFC3339))
+	}
+}
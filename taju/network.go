@@ -0,0 +1,169 @@
+package taju
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"time"
+)
+
+// DEFAULT_HTTP_TIMEOUT bounds one Strava or Taji request, from connecting to
+// reading the whole body, so a hung request fails the cycle instead of
+// stalling it. Retries each get their own timeout.
+const DEFAULT_HTTP_TIMEOUT = 60 * time.Second
+
+// networkSettings are how taju reaches Strava and Taji: the request
+// timeout (TAJU_HTTP_TIMEOUT, 0 for none), a proxy (TAJU_HTTP_PROXY, else
+// the usual HTTP_PROXY/HTTPS_PROXY/NO_PROXY), extra certificate authorities
+// for networks that inspect TLS (TAJU_CA_FILE, a PEM bundle added to the
+// system's) and the User-Agent both clients send (TAJU_USER_AGENT).
+type networkSettings struct {
+	timeout    time.Duration
+	proxy      func(*http.Request) (*url.URL, error)
+	roots      *x509.CertPool
+	user_agent string
+}
+
+func loadNetworkSettings(env map[string]string) (networkSettings, error) {
+	settings := networkSettings{
+		timeout:    DEFAULT_HTTP_TIMEOUT,
+		proxy:      http.ProxyFromEnvironment,
+		user_agent: defaultUserAgent(),
+	}
+	if value, ok := env["TAJU_HTTP_TIMEOUT"]; ok {
+		timeout, err := time.ParseDuration(value)
+		if err != nil || timeout < 0 {
+			return networkSettings{}, fmt.Errorf("invalid TAJU_HTTP_TIMEOUT=%q, expected a duration like 60s", value)
+		}
+		settings.timeout = timeout
+	}
+	if value := env["TAJU_HTTP_PROXY"]; value != "" {
+		proxy, err := url.Parse(value)
+		if err != nil || proxy.Host == "" {
+			return networkSettings{}, fmt.Errorf("invalid TAJU_HTTP_PROXY=%q, expected a URL like http://proxy:3128", value)
+		}
+		settings.proxy = http.ProxyURL(proxy)
+	}
+	if path := env["TAJU_CA_FILE"]; path != "" {
+		pem, err := os.ReadFile(path)
+		if err != nil {
+			return networkSettings{}, fmt.Errorf("error reading TAJU_CA_FILE: %w", err)
+		}
+		roots, err := x509.SystemCertPool()
+		if err != nil {
+			roots = x509.NewCertPool()
+		}
+		if !roots.AppendCertsFromPEM(pem) {
+			return networkSettings{}, fmt.Errorf("TAJU_CA_FILE %s has no PEM certificates", path)
+		}
+		settings.roots = roots
+	}
+	if value := env["TAJU_USER_AGENT"]; value != "" {
+		settings.user_agent = value
+	}
+	return settings, nil
+}
+
+func defaultUserAgent() string {
+	version := "dev"
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		version = info.Main.Version
+	}
+	return fmt.Sprintf("tajuploader/%s (%s; +https://github.com/smpentecost/tajiUploader)", version, runtime.GOOS)
+}
+
+// transport returns the connection level transport both clients build on.
+func (n networkSettings) transport() *http.Transport {
+	return &http.Transport{
+		Proxy:                 n.proxy,
+		DialContext:           (&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}).DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+		TLSClientConfig:       &tls.Config{MinVersion: tls.VersionTLS12, RootCAs: n.roots},
+	}
+}
+
+// wrap adds the User-Agent and the timeout to each attempt of a request.
+// It goes under the retrying transport, so a retry isn't cut short by the
+// time the earlier attempts took.
+func (n networkSettings) wrap(base http.RoundTripper) http.RoundTripper {
+	return &attemptTransport{base: base, timeout: n.timeout, user_agent: n.user_agent}
+}
+
+// client is the HTTP client of what taju calls besides Strava and Taji: the
+// push subscription, health checks, hooks and chat webhooks. Requests go
+// through the same proxy and certificate authorities with retries, each
+// attempt cut off after limit or TAJU_HTTP_TIMEOUT, whichever is shorter.
+func (n networkSettings) client(limit time.Duration) *http.Client {
+	if n.timeout == 0 || limit < n.timeout {
+		n.timeout = limit
+	}
+	return &http.Client{Transport: newRetryTransport(n.wrap(n.transport()), realClock{})}
+}
+
+type attemptTransport struct {
+	base       http.RoundTripper
+	timeout    time.Duration
+	user_agent string
+}
+
+// RoundTrip sends an attempt under the timeout. Reads are also cut short
+// when the sync is stopping or the request's context (the run's, see
+// contextService) is cancelled; a post is left to finish, so its outcome
+// is known.
+func (t *attemptTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", t.user_agent)
+	read := req.Method == http.MethodGet || req.Method == http.MethodHead
+	ctx, cancel := req.Context(), context.CancelFunc(func() {})
+	if !read {
+		ctx = context.WithoutCancel(ctx)
+	}
+	if t.timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, t.timeout)
+	}
+	if read {
+		var cancel_read context.CancelFunc
+		ctx, cancel_read = context.WithCancel(ctx)
+		release := context.AfterFunc(stopping, cancel_read)
+		cancel_timeout := cancel
+		cancel = func() {
+			release()
+			cancel_read()
+			cancel_timeout()
+		}
+	}
+	res, err := t.base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, fmt.Errorf("%s %s timed out after %s: %w", req.Method, req.URL.Host, t.timeout, err)
+		}
+		return nil, err
+	}
+	// The body is read after RoundTrip returns, under the same deadline.
+	res.Body = &cancelBody{ReadCloser: res.Body, cancel: cancel}
+	return res, nil
+}
+
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
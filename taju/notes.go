@@ -0,0 +1,126 @@
+package taju
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+const DEFAULT_NOTES_TEMPLATE string = "{name} - {pace} - {link}"
+
+// NOTES_PLACEHOLDERS are what a notes template can use: the Strava activity's
+// {name}, {description} and {type}, its average {pace} and per mile (or km)
+// {splits} in the units of the Taji form, and the {link} to it on Strava.
+var NOTES_PLACEHOLDERS = []string{"{name}", "{description}", "{type}", "{pace}", "{splits}", "{link}", "{id}"}
+
+var NOTES_PLACEHOLDER_PATTERN = regexp.MustCompile(`\{[a-z_]+\}`)
+
+// notesTemplate is the text posted in the notes field of an entry next to
+// the idempotency key, so the Taji log isn't just bare numbers.
+// TAJU_NOTES=true turns it on with DEFAULT_NOTES_TEMPLATE, and
+// TAJU_NOTES_TEMPLATE changes the text, e.g.
+//
+//	TAJU_NOTES_TEMPLATE={name}: {pace}, splits {splits} {link}
+//
+// Description and splits only come with the detailed representation of an
+// activity, so using them costs a Strava request per activity.
+type notesTemplate string
+
+func loadNotesTemplate(env map[string]string) (notesTemplate, error) {
+	if !envBool(env, "TAJU_NOTES") {
+		return "", nil
+	}
+	template, ok := env["TAJU_NOTES_TEMPLATE"]
+	if !ok {
+		template = DEFAULT_NOTES_TEMPLATE
+	}
+	for _, placeholder := range NOTES_PLACEHOLDER_PATTERN.FindAllString(template, -1) {
+		if !slices.Contains(NOTES_PLACEHOLDERS, placeholder) {
+			return "", fmt.Errorf("unknown placeholder %s in TAJU_NOTES_TEMPLATE, expected one of %s", placeholder, strings.Join(NOTES_PLACEHOLDERS, " "))
+		}
+	}
+	return notesTemplate(template), nil
+}
+
+// detailed reports whether the template needs the detailed representation
+// of activities.
+func (n notesTemplate) detailed() bool {
+	return strings.Contains(string(n), "{description}") || strings.Contains(string(n), "{splits}")
+}
+
+// render fills in the template for an activity, with paces per unit of
+// taji, the units of the form.
+func (n notesTemplate) render(activity stravaActivity, run runDetails, taji units) string {
+	if n == "" {
+		return ""
+	}
+	sport := activity.SportType
+	if sport == "" {
+		sport = activity.Type
+	}
+	notes := strings.NewReplacer(
+		"{name}", activity.Name,
+		"{description}", strings.TrimSpace(activity.Description),
+		"{type}", sport,
+		"{pace}", formatPace(run.duration_int, run.distance_float, taji),
+		"{splits}", formatSplits(activity, taji),
+		"{link}", fmt.Sprintf("https://www.strava.com/activities/%d", activity.Id),
+		"{id}", fmt.Sprint(activity.Id),
+	).Replace(string(n))
+	return strings.TrimSpace(notes)
+}
+
+// formatPace writes the time per mile (or km, see units) like 9:05/mi, ""
+// without a distance.
+func formatPace(seconds int64, meters float64, u units) string {
+	distance := u.fromMeters(meters)
+	if distance <= 0 || seconds <= 0 {
+		return ""
+	}
+	pace := int64(float64(seconds)/distance + 0.5)
+	return fmt.Sprintf("%d:%02d/%s", pace/60, pace%60, u.distance)
+}
+
+// formatSplits lists the pace of every full mile (or km) split.
+func formatSplits(activity stravaActivity, u units) string {
+	splits := activity.SplitsStandard
+	if u.distance == UNITS_KM {
+		splits = activity.SplitsMetric
+	}
+	var paces []string
+	for _, split := range splits {
+		// The last split is usually a fraction.
+		if u.fromMeters(split.Distance) < 0.95 {
+			continue
+		}
+		paces = append(paces, strings.TrimSuffix(formatPace(split.MovingTime, split.Distance, u), "/"+u.distance))
+	}
+	return strings.Join(paces, ", ")
+}
+
+// fillNotes loads the detailed representation of activities when the notes
+// template needs their description or splits.
+func fillNotes(s *strava, activities []stravaActivity) {
+	if !s.notes.detailed() {
+		return
+	}
+	var missing []int
+	for i, activity := range activities {
+		if activity.SplitsStandard == nil && activity.SplitsMetric == nil {
+			missing = append(missing, i)
+		}
+	}
+	api := stravaAPI(s)
+	forEachLimit(len(missing), s.detail_workers, func(i int) {
+		activity := &activities[missing[i]]
+		detail, err := api.Activity(activity.Id)
+		if err != nil {
+			log.Print("Error fetching details of Strava activity ", activity.Id, ": ", err)
+			return
+		}
+		activity.Description = detail.Description
+		activity.SplitsStandard, activity.SplitsMetric = detail.SplitsStandard, detail.SplitsMetric
+	})
+}
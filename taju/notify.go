@@ -0,0 +1,139 @@
+package taju
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/tajuploader/stravaclient"
+	"github.com/tajuploader/tajiclient"
+	"golang.org/x/oauth2"
+)
+
+// notification is what the desktop notifier shows after a cycle.
+type notification struct {
+	Title   string
+	Message string
+}
+
+// registerNotifications shows a desktop notification after cycles that
+// posted activities or failed, when TAJU_NOTIFY=true. A Strava account that
+// needs authorizing again or an expired Taji login get their own
+// notification, once until a cycle succeeds again, since the daemon can't
+// fix either by itself. The backend is chosen per platform at build time,
+// see desktopNotify.
+func registerNotifications(s *syncer, env map[string]string) {
+	if !envBool(env, "TAJU_NOTIFY") {
+		return
+	}
+	// behind remembers the last projection so falling off pace alerts once
+	// instead of after every cycle.
+	behind, behind_climb := false, false
+	// Errors are published from the post workers too.
+	var mu sync.Mutex
+	auth := make(map[string]bool)
+	var auth_notifications []notification
+	subscribe(&s.events, func(e errorOccurred) {
+		n, ok := authNotification(e.err)
+		if !ok {
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if !auth[n.Message] {
+			auth[n.Message] = true
+			auth_notifications = append(auth_notifications, n)
+		}
+	})
+	subscribe(&s.events, func(e breakerChanged) {
+		n := notification{"Taji Uploader: Taji is back", "Taji is responding again, the queued activities are being posted."}
+		if e.state.open() {
+			n = notification{"Taji Uploader: Taji is down", fmt.Sprintf("Taji failed %d syncs in a row (%s). Activities are kept until it is back, the next try is at %s.",
+				e.state.Failures, e.state.Reason, e.state.RetryAt.Local().Format("15:04"))}
+		}
+		if err := desktopNotify(n); err != nil {
+			log.Print("Failed to show notification: ", err)
+		}
+	})
+	subscribe(&s.events, func(e cycleCompleted) {
+		result := e.result
+		mu.Lock()
+		notifications := auth_notifications
+		auth_notifications = nil
+		if !result.failed {
+			clear(auth)
+		}
+		mu.Unlock()
+		if n, ok := cycleNotification(result, s.u.settings.taji_units); ok && (len(notifications) == 0 || !result.failed) {
+			notifications = append(notifications, n)
+		}
+		if result.progress.behind() && !behind {
+			notifications = append(notifications, notification{"Taji Uploader: behind pace", fmt.Sprintf(
+				"At your current pace you'll reach %.0f %s on %s, after the event ends. You need %.2f %s a day to finish in time.",
+				result.units.fromMiles(result.progress.target), result.units.name(), result.progress.projected.Local().Format("Jan 2"),
+				result.units.fromMiles(result.progress.daily_needed), result.units.name())})
+		}
+		if result.climb.behind() && !behind_climb {
+			notifications = append(notifications, notification{"Taji Uploader: behind on climbing", fmt.Sprintf(
+				"At your current pace you'll reach %s of climbing on %s, after the event ends. You need %s a day to finish in time.",
+				result.climb.format(result.climb.target, 0), result.climb.projected.Local().Format("Jan 2"),
+				result.climb.format(result.climb.daily_needed, 0))})
+		}
+		if !result.failed {
+			behind, behind_climb = result.progress.behind(), result.climb.behind()
+		}
+		for _, n := range notifications {
+			if err := desktopNotify(n); err != nil {
+				log.Print("Failed to show notification: ", err)
+			}
+		}
+	})
+}
+
+// authNotification tells what to run when err means a login has to be
+// renewed by hand.
+func authNotification(err error) (notification, bool) {
+	var strava_err *stravaclient.Error
+	var retrieve_err *oauth2.RetrieveError
+	switch {
+	case errors.Is(err, tajiclient.ErrSessionExpired):
+		return notification{"Taji Uploader: Taji login expired", "Syncing is stopped until you log in again: run taju auth taji."}, true
+	case errors.As(err, &strava_err) && strava_err.NeedsAuthorization():
+		return notification{"Taji Uploader: Strava needs authorizing", fmt.Sprintf(
+			"Strava account %q can't be read until it is authorized again: run taju auth strava %s.", strava_err.Account, strava_err.Account)}, true
+	case errors.As(err, &retrieve_err):
+		return notification{"Taji Uploader: Strava needs authorizing", "Strava rejected a refresh token: run taju auth strava."}, true
+	}
+	return notification{}, false
+}
+
+// cycleNotification says what the cycle logged, the distances in the
+// taji units they were posted in.
+func cycleNotification(result cycleResult, taji units) (notification, bool) {
+	switch {
+	case result.failed:
+		return notification{"Taji Uploader", "The last sync failed, see the log for details."}, true
+	case len(result.posted) == 1:
+		run := result.posted[0]
+		return notification{"Taji Uploader", fmt.Sprintf("Logged %s %s %s on %s.%s%s", run.distance, taji.distance, run.activity, run.date, projection(result.progress), cheer(result))}, true
+	case len(result.posted) > 1:
+		return notification{"Taji Uploader", fmt.Sprintf("Logged %d activities on Taji.%s%s", len(result.posted), projection(result.progress), cheer(result))}, true
+	}
+	return notification{}, false
+}
+
+// cheer is the cycle's encouragement as the end of a notification.
+func cheer(result cycleResult) string {
+	if result.encouragement == "" {
+		return ""
+	}
+	return " " + result.encouragement
+}
+
+func projection(progress goalStatus) string {
+	if progress.done >= progress.target || progress.projected.IsZero() {
+		return ""
+	}
+	return " Projected finish: " + progress.projected.Local().Format("Jan 2") + "."
+}
@@ -0,0 +1,14 @@
+package taju
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+)
+
+// desktopNotify uses Notification Center through osascript, which ships
+// with macOS on both Intel and Apple Silicon.
+func desktopNotify(n notification) error {
+	script := fmt.Sprintf("display notification %s with title %s", strconv.Quote(n.Message), strconv.Quote(n.Title))
+	return exec.Command("osascript", "-e", script).Run()
+}
@@ -0,0 +1,11 @@
+//go:build !darwin && !windows
+
+package taju
+
+import "os/exec"
+
+// desktopNotify uses notify-send (libnotify), available on most Linux
+// desktops including Raspberry Pi OS.
+func desktopNotify(n notification) error {
+	return exec.Command("notify-send", "--app-name=Taji Uploader", n.Title, n.Message).Run()
+}
@@ -0,0 +1,23 @@
+package taju
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// desktopNotify shows a toast through the WinRT notification API from
+// PowerShell, which is available natively on x64 and arm64 Windows.
+func desktopNotify(n notification) error {
+	quote := func(s string) string { return "'" + strings.ReplaceAll(s, "'", "''") + "'" }
+	script := fmt.Sprintf(`
+[Windows.UI.Notifications.ToastNotificationManager, Windows.UI.Notifications, ContentType = WindowsRuntime] | Out-Null
+$template = [Windows.UI.Notifications.ToastNotificationManager]::GetTemplateContent([Windows.UI.Notifications.ToastTemplateType]::ToastText02)
+$text = $template.GetElementsByTagName('text')
+$text.Item(0).AppendChild($template.CreateTextNode(%s)) | Out-Null
+$text.Item(1).AppendChild($template.CreateTextNode(%s)) | Out-Null
+$toast = [Windows.UI.Notifications.ToastNotification]::new($template)
+[Windows.UI.Notifications.ToastNotificationManager]::CreateToastNotifier('Taji Uploader').Show($toast)
+`, quote(n.Title), quote(n.Message))
+	return exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command", script).Run()
+}
@@ -0,0 +1,226 @@
+package taju
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+)
+
+// The outbox keeps the runs that couldn't be posted, because Taji was down
+// or rejected the post, in the ledger until they are on Taji. Queued runs
+// join every cycle's activities even when Strava no longer returns them, so
+// nothing is lost while the site is out during the event. Each failed post
+// pushes the next one back, doubling from TAJU_QUEUE_BACKOFF up to
+// TAJU_QUEUE_MAX_BACKOFF; while Taji can't be read at all the cycles back
+// off instead (see syncInterval) and the queue is posted as soon as one
+// gets through. A run is never posted twice: it is matched against
+// the Taji entries like any other activity before it is posted again.
+const (
+	QUEUE_BACKOFF     = RETRY_INTERVAL
+	QUEUE_MAX_BACKOFF = 6 * time.Hour
+)
+
+type queueBackoff struct {
+	base time.Duration
+	max  time.Duration
+}
+
+func loadQueueBackoff(env map[string]string) (queueBackoff, error) {
+	backoff := queueBackoff{base: QUEUE_BACKOFF, max: QUEUE_MAX_BACKOFF}
+	for key, target := range map[string]*time.Duration{"TAJU_QUEUE_BACKOFF": &backoff.base, "TAJU_QUEUE_MAX_BACKOFF": &backoff.max} {
+		if value, ok := env[key]; ok {
+			duration, err := time.ParseDuration(value)
+			if err != nil || duration <= 0 {
+				return queueBackoff{}, fmt.Errorf("invalid %s=%q, expected a duration like 15m", key, value)
+			}
+			*target = duration
+		}
+	}
+	backoff.max = max(backoff.max, backoff.base)
+	return backoff, nil
+}
+
+// delay is the wait before the next attempt after the given number of
+// failed ones.
+func (b queueBackoff) delay(attempts int) time.Duration {
+	delay := b.base
+	for i := 1; i < attempts && delay < b.max; i++ {
+		delay *= 2
+	}
+	return min(delay, b.max)
+}
+
+// queuedUpload is a run waiting in the outbox, with the form values it
+// is posted with.
+type queuedUpload struct {
+	StravaId        int64     `json:"strava_id"`
+	Part            int       `json:"part,omitempty"`
+	Start           time.Time `json:"start"`
+	Zone            string    `json:"zone,omitempty"`
+	Activity        string    `json:"activity"`
+	Date            string    `json:"date"`
+	Time            string    `json:"time"`
+	TimeHours       string    `json:"time_hours"`
+	TimeMinutes     string    `json:"time_minutes"`
+	TimeAmpm        string    `json:"time_ampm"`
+	Distance        string    `json:"distance"`
+	Duration        string    `json:"duration"`
+	DurationHours   string    `json:"duration_hours"`
+	DurationMinutes string    `json:"duration_minutes"`
+	DurationSeconds string    `json:"duration_seconds"`
+	Elevation       string    `json:"elevation_gain,omitempty"`
+	Meters          float64   `json:"meters"`
+	Seconds         int64     `json:"seconds"`
+	ElevationMeters float64   `json:"elevation_meters,omitempty"`
+	PhotoUrl        string    `json:"photo_url,omitempty"`
+	Notes           string    `json:"notes,omitempty"`
+
+	// Attempts counts the failed posts.
+	Attempts    int       `json:"attempts"`
+	Error       string    `json:"error,omitempty"`
+	QueuedAt    time.Time `json:"queued_at"`
+	NextAttempt time.Time `json:"next_attempt"`
+}
+
+func (q *queuedUpload) run() runDetails {
+	run := runDetails{
+		strava_id: q.StravaId, part: q.Part, start: q.Start,
+		activity: q.Activity, date: q.Date, time: q.Time,
+		time_hours: q.TimeHours, time_minutes: q.TimeMinutes, time_ampm: q.TimeAmpm,
+		distance: q.Distance, duration: q.Duration,
+		duration_hours: q.DurationHours, duration_minutes: q.DurationMinutes, duration_seconds: q.DurationSeconds,
+		elevation_gain: q.Elevation, distance_float: q.Meters, duration_int: q.Seconds, elevation_float: q.ElevationMeters,
+		photo_url: q.PhotoUrl, notes: q.Notes,
+	}
+	if location, err := time.LoadLocation(q.Zone); err == nil && q.Zone != "" {
+		run.location = location
+	}
+	return run
+}
+
+// errUnconfirmed is the outbox error of a posted run that didn't show up on
+// the participant page with TAJU_CONFIRM_POSTS set.
+var errUnconfirmed = errors.New("posted but not seen on the participant page")
+
+// enqueue puts a run that couldn't be posted in the outbox, or updates the
+// one already there. backoff is nil when the run wasn't posted at all
+// because Taji couldn't be read, which leaves it due right away.
+func (s *stateStore) enqueue(run runDetails, err error, backoff *queueBackoff) {
+	if run.strava_id == 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Outbox == nil {
+		s.Outbox = make(map[string]*queuedUpload)
+	}
+	now := s.clock.Now()
+	key := partKey(run.strava_id, run.part)
+	queued, ok := s.Outbox[key]
+	if !ok {
+		queued = &queuedUpload{QueuedAt: now}
+		s.Outbox[key] = queued
+	}
+	attempts, next := queued.Attempts, now
+	if backoff != nil {
+		attempts++
+		next = now.Add(backoff.delay(attempts))
+	}
+	*queued = queuedUpload{
+		StravaId: run.strava_id, Part: run.part, Start: run.start,
+		Activity: run.activity, Date: run.date, Time: run.time,
+		TimeHours: run.time_hours, TimeMinutes: run.time_minutes, TimeAmpm: run.time_ampm,
+		Distance: run.distance, Duration: run.duration,
+		DurationHours: run.duration_hours, DurationMinutes: run.duration_minutes, DurationSeconds: run.duration_seconds,
+		Elevation: run.elevation_gain, Meters: run.distance_float, Seconds: run.duration_int, ElevationMeters: run.elevation_float,
+		PhotoUrl:    run.photo_url,
+		Notes:       run.notes,
+		Attempts:    attempts,
+		Error:       redact(err.Error()),
+		QueuedAt:    queued.QueuedAt,
+		NextAttempt: next,
+	}
+	if run.location != nil {
+		queued.Zone = run.location.String()
+	}
+}
+
+// queued returns the outbox, oldest run first.
+func (s *stateStore) queued() []queuedUpload {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var queued []queuedUpload
+	for _, upload := range s.Outbox {
+		queued = append(queued, *upload)
+	}
+	slices.SortFunc(queued, func(a, b queuedUpload) int { return strings.Compare(a.Date+a.Time, b.Date+b.Time) })
+	return queued
+}
+
+// retryAt returns when a queued run is due to be posted again, and
+// whether it is queued at all.
+func (s *stateStore) retryAt(run runDetails) (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	queued, ok := s.Outbox[partKey(run.strava_id, run.part)]
+	if !ok {
+		return time.Time{}, false
+	}
+	return queued.NextAttempt, true
+}
+
+func (s *stateStore) dequeue(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.Outbox, key)
+}
+
+// withQueued adds the queued runs Strava didn't return to a cycle's
+// activities. After a complete fetch a queued run that is missing was
+// deleted (or filtered out) on Strava, so it leaves the outbox instead.
+func (s *syncer) withQueued(activities []runDetails, complete bool) []runDetails {
+	fetched := make(map[string]bool)
+	for _, run := range activities {
+		fetched[partKey(run.strava_id, run.part)] = true
+	}
+	for _, queued := range s.u.state.queued() {
+		key := partKey(queued.StravaId, queued.Part)
+		switch {
+		case fetched[key]:
+		case complete:
+			log.Printf("Strava activity %d queued for Taji is gone, dropping it from the queue", queued.StravaId)
+			s.u.state.dequeue(key)
+		default:
+			activities = append(activities, queued.run())
+		}
+	}
+	sortRuns(activities)
+	return activities
+}
+
+// holdQueued takes the posts of queued runs that aren't due yet out of a
+// plan.
+func (s *syncer) holdQueued(plan []plannedAction) []plannedAction {
+	now := s.u.clock.Now()
+	return slices.DeleteFunc(plan, func(action plannedAction) bool {
+		if action.kind != ACTION_POST {
+			return false
+		}
+		at, ok := s.u.state.retryAt(action.run)
+		if !ok || !at.After(now) {
+			return false
+		}
+		s.decided("skip", action.run, fmt.Sprintf("queued, retrying after %s", s.u.settings.display.clock(at.Local())), nil)
+		return true
+	})
+}
+
+// queuedCount is the number of runs waiting in the outbox.
+func (s *stateStore) queuedCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.Outbox)
+}
@@ -0,0 +1,161 @@
+package taju
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/joho/godotenv"
+	"github.com/tajuploader/config"
+)
+
+// Coach mode: a team captain logs for members who aren't online from the
+// captain's own taju.env. TAJU_PARTICIPANTS names the members, and every
+// member has their own Taji login and their own activity source, Strava
+// accounts the captain authorized (taju accounts add) or activity files:
+//
+//	TAJU_PARTICIPANTS=alice,bob
+//	TAJU_PARTICIPANT_ALICE_TAJI_USERNAME=alice@example.com
+//	TAJU_PARTICIPANT_ALICE_TAJI_PASSWORD=...
+//	TAJU_PARTICIPANT_ALICE_TAJI_ID=4711
+//	TAJU_PARTICIPANT_ALICE_STRAVA_ACCOUNTS=alice
+//	TAJU_PARTICIPANT_BOB_IMPORT_DIR=/srv/taju/bob
+//
+// taju sync syncs the captain and then every participant, each like a
+// profile with its own Taji session and ledger, so duplicates are checked
+// per participant, and its own line in the summary. A member without an
+// import directory of their own reads the shared TAJU_IMPORT_DIR: its
+// subdirectory named after them, and the files in it named for them
+// (bob-parkrun.gpx, bob_february.csv). The captain's sync leaves the
+// participants' Strava accounts and files alone.
+
+// participantNames lists the participants in TAJU_PARTICIPANTS.
+func participantNames(env map[string]string) []string {
+	return splitList(env["TAJU_PARTICIPANTS"])
+}
+
+// participantKey is the taju.env key of one setting of a participant, e.g.
+// TAJU_PARTICIPANT_ALICE_TAJI_USERNAME.
+func participantKey(name string, key string) string {
+	return "TAJU_PARTICIPANT_" + strings.ToUpper(name) + "_" + key
+}
+
+// participantFiles reports whether a file in a shared import directory is
+// named for one of the participants, e.g. alice-parkrun.gpx for alice.
+func participantFiles(file string, participants []string) bool {
+	file = strings.ToLower(file)
+	return slices.ContainsFunc(participants, func(name string) bool {
+		return strings.HasPrefix(file, name+"-") || strings.HasPrefix(file, name+"_")
+	})
+}
+
+// checkParticipants checks the participants of env: valid names that
+// aren't also profiles, each with a Taji login of their own, and Strava
+// accounts that exist and feed a single participant.
+func checkParticipants(env map[string]string) error {
+	names := participantNames(env)
+	if len(names) > 0 && len(profileNames(env)) > 0 {
+		return errors.New("TAJU_PROFILES and TAJU_PARTICIPANTS can't be used together")
+	}
+	linked := map[string]string{}
+	for _, name := range names {
+		if !ACCOUNT_NAME_PATTERN.MatchString(name) {
+			return fmt.Errorf("invalid participant %q, use lowercase letters and digits", name)
+		}
+		if env[participantKey(name, "TAJI_USERNAME")] == "" {
+			return fmt.Errorf("%s is not set, every participant logs in to Taji with their own account", participantKey(name, "TAJI_USERNAME"))
+		}
+		for _, account := range splitList(env[participantKey(name, "STRAVA_ACCOUNTS")]) {
+			if !slices.Contains(stravaAccounts(env), account) {
+				return fmt.Errorf("%s: unknown Strava account %q, add it with taju accounts add", participantKey(name, "STRAVA_ACCOUNTS"), account)
+			}
+			if other, ok := linked[account]; ok {
+				return fmt.Errorf("the Strava account %q feeds both %s and %s", account, other, name)
+			}
+			linked[account] = name
+		}
+	}
+	return nil
+}
+
+// loadParticipantEnv turns env, the captain's settings, into the ones of a
+// participant: the session and Strava tokens saved in their env file
+// instead of the captain's. A changed login drops the saved session. The
+// login itself is set once the secrets are decrypted, see
+// useParticipantLogin.
+func loadParticipantEnv(env map[string]string, state_dir string, name string) error {
+	if err := checkParticipants(env); err != nil {
+		return err
+	}
+	deleteTajiKeys(env)
+	path := config.StatePath(state_dir, profileFile(name, ENV_FILENAME))
+	saved, err := godotenv.Read(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("error loading %s: %w", path, err)
+	}
+	username := env[participantKey(name, "TAJI_USERNAME")]
+	if saved["TAJI_USERNAME"] != username {
+		deleteTajiKeys(saved)
+	}
+	for key, value := range saved {
+		env[key] = value
+	}
+	return nil
+}
+
+// useParticipantLogin logs in to Taji with the participant's login rather
+// than the captain's.
+func useParticipantLogin(env map[string]string, name string) {
+	env["TAJI_USERNAME"] = env[participantKey(name, "TAJI_USERNAME")]
+	env["TAJI_PASSWORD"] = env[participantKey(name, "TAJI_PASSWORD")]
+}
+
+// deleteTajiKeys removes the Taji login and session keys from env.
+func deleteTajiKeys(env map[string]string) {
+	for key := range env {
+		if strings.HasPrefix(key, "TAJI_") {
+			delete(env, key)
+		}
+	}
+}
+
+// checkParticipantId stops a participant's sync when their login belongs
+// to another participant than TAJU_PARTICIPANT_<NAME>_TAJI_ID, so a mixed
+// up password doesn't log one member's activities for another.
+func checkParticipantId(u *uploader) error {
+	want, got := u.env[participantKey(u.profile, "TAJI_ID")], u.taji.client.Session().Participant
+	if !u.participant || want == "" || got == want {
+		return nil
+	}
+	return fmt.Errorf("the Taji login of %s is participant %s, but %s is %s", u.profile, got,
+		participantKey(u.profile, "TAJI_ID"), want)
+}
+
+// syncAccounts lists the Strava accounts whose activities u syncs: a
+// participant's linked accounts, and for the captain the ones in
+// TAJU_SYNC_ACCOUNTS or else every account not linked to a participant.
+func (u *uploader) syncAccounts() []string {
+	if u.participant {
+		return splitList(u.env[participantKey(u.profile, "STRAVA_ACCOUNTS")])
+	}
+	accounts := syncAccounts(u.env)
+	if u.env["TAJU_SYNC_ACCOUNTS"] != "" {
+		return accounts
+	}
+	return slices.DeleteFunc(accounts, func(account string) bool {
+		return slices.ContainsFunc(participantNames(u.env), func(name string) bool {
+			return slices.Contains(splitList(u.env[participantKey(name, "STRAVA_ACCOUNTS")]), account)
+		})
+	})
+}
+
+// name is how u is called in summaries: the profile or participant, else
+// the Taji login.
+func (u *uploader) name() string {
+	if u.profile != "" {
+		return u.profile
+	}
+	return u.env["TAJI_USERNAME"]
+}
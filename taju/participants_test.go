@@ -0,0 +1,217 @@
+package taju
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/tajuploader/config"
+)
+
+// coachEnv is a captain's taju.env logging for alice, through a Strava
+// account of hers, and for bob, from files.
+func coachEnv() map[string]string {
+	return map[string]string{
+		"TAJI_USERNAME": "captain@example.com", "TAJI_PASSWORD": "captain", "TAJI_SESSION": "captain-session",
+		"TAJU_STRAVA_ACCOUNTS":                   "captain,alice",
+		"TAJU_PARTICIPANTS":                      "alice,bob",
+		"TAJU_PARTICIPANT_ALICE_TAJI_USERNAME":   "alice@example.com",
+		"TAJU_PARTICIPANT_ALICE_TAJI_PASSWORD":   "alice",
+		"TAJU_PARTICIPANT_ALICE_STRAVA_ACCOUNTS": "alice",
+		"TAJU_PARTICIPANT_BOB_TAJI_USERNAME":     "bob@example.com",
+	}
+}
+
+func TestCheckParticipants(t *testing.T) {
+	tests := []struct {
+		name   string
+		change map[string]string
+		err    string
+	}{
+		{name: "valid"},
+		{name: "with profiles", change: map[string]string{"TAJU_PROFILES": "carol"}, err: "can't be used together"},
+		{name: "invalid name", change: map[string]string{"TAJU_PARTICIPANTS": "alice,Bob"}, err: `invalid participant "Bob"`},
+		{name: "no login", change: map[string]string{"TAJU_PARTICIPANT_BOB_TAJI_USERNAME": ""}, err: "TAJU_PARTICIPANT_BOB_TAJI_USERNAME is not set"},
+		{name: "unknown account", change: map[string]string{"TAJU_PARTICIPANT_BOB_STRAVA_ACCOUNTS": "bob"}, err: `unknown Strava account "bob"`},
+		{name: "shared account", change: map[string]string{"TAJU_PARTICIPANT_BOB_STRAVA_ACCOUNTS": "alice"}, err: "feeds both alice and bob"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			env := coachEnv()
+			for key, value := range test.change {
+				env[key] = value
+			}
+			err := checkParticipants(env)
+			if test.err == "" && err != nil || test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+				t.Errorf("err %v, want %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestParticipantEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		saved   string
+		session string
+	}{
+		{name: "first sync logs in"},
+		{name: "saved session", saved: "TAJI_USERNAME=alice@example.com\nTAJI_SESSION=alice-session\n", session: "alice-session"},
+		{name: "login changed", saved: "TAJI_USERNAME=old@example.com\nTAJI_SESSION=old-session\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if test.saved != "" {
+				if err := os.WriteFile(config.StatePath(dir, profileFile("alice", ENV_FILENAME)), []byte(test.saved), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			env := coachEnv()
+			loadParticipantEnv(env, dir, "alice")
+			useParticipantLogin(env, "alice")
+			if env["TAJI_USERNAME"] != "alice@example.com" || env["TAJI_PASSWORD"] != "alice" {
+				t.Errorf("login %s/%s, want alice's", env["TAJI_USERNAME"], env["TAJI_PASSWORD"])
+			}
+			if env["TAJI_SESSION"] != test.session {
+				t.Errorf("session %q, want %q", env["TAJI_SESSION"], test.session)
+			}
+		})
+	}
+}
+
+func TestParticipantSyncAccounts(t *testing.T) {
+	tests := []struct {
+		name        string
+		profile     string
+		participant bool
+		sync        string
+		want        []string
+	}{
+		{name: "captain leaves the participants' accounts", want: []string{"captain"}},
+		{name: "captain's TAJU_SYNC_ACCOUNTS", sync: "captain,alice", want: []string{"captain", "alice"}},
+		{name: "participant", profile: "alice", participant: true, want: []string{"alice"}},
+		{name: "participant without Strava", profile: "bob", participant: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			env := coachEnv()
+			if test.sync != "" {
+				env["TAJU_SYNC_ACCOUNTS"] = test.sync
+			}
+			u := &uploader{env: env, profile: test.profile, participant: test.participant}
+			if got := u.syncAccounts(); !slices.Equal(got, test.want) {
+				t.Errorf("accounts %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParticipantFiles(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"team.csv":          "activity,date,distance,duration\nrun,2026-02-10,3.1,30m\n",
+		"alice-feb.csv":     "activity,date,time,distance,duration\nwalk,2026-02-11,7:30AM,2,40m\n",
+		"bob_feb.csv":       "activity,date,distance,duration\nwalk,2026-02-11,2,40m\nwalk,2026-02-12,2.5,50m\n",
+		"alice/parkrun.csv": "activity,date,time,distance,duration\nrun,2026-02-14,9:00AM,5km,28m\n",
+		"notes.txt":         "not an activity",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	own := t.TempDir()
+	if err := os.WriteFile(filepath.Join(own, "log.csv"), []byte(files["bob_feb.csv"]), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		profile     string
+		participant bool
+		own         string
+		want        []string
+	}{
+		{name: "captain", want: []string{"2026-02-10"}},
+		{name: "participant's subdirectory and files", profile: "alice", participant: true, want: []string{"2026-02-11", "2026-02-14"}},
+		{name: "participant's files", profile: "bob", participant: true, want: []string{"2026-02-11", "2026-02-12"}},
+		{name: "participant's own directory", profile: "bob", participant: true, own: own, want: []string{"2026-02-11", "2026-02-12"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			env := coachEnv()
+			env["TAJU_IMPORT_DIR"] = dir
+			if test.own != "" {
+				env["TAJU_PARTICIPANT_BOB_IMPORT_DIR"] = test.own
+			}
+			u := &uploader{env: env, profile: test.profile, participant: test.participant, clock: newFakeClock(TEST_NOW), settings: newSettings()}
+			sources, err := loadFileSources(u)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var dates []string
+			for _, source := range sources {
+				runs, partial, err := source.Activities()
+				if err != nil || partial {
+					t.Fatalf("%s: partial=%t, %v", source.dir, partial, err)
+				}
+				for _, run := range runs {
+					dates = append(dates, run.start.Format(DATE_FORMAT))
+				}
+			}
+			slices.Sort(dates)
+			if !slices.Equal(dates, test.want) {
+				t.Errorf("activities on %q, want %q", dates, test.want)
+			}
+		})
+	}
+}
+
+func TestReadCSV(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		runs    int
+		// miles is the distance of the first row.
+		miles float64
+		err   string
+	}{
+		{name: "rows", content: "Activity, Date, Time, Distance, Duration, Elevation\nrun,2026-02-10,07:05,3.1,30:00,40m\nwalk,2026-02-11,,2,45m,\n", runs: 2, miles: 3.1},
+		{name: "quoted decimal comma", content: "activity,date,distance,duration\nrun,2026-02-10,\"5,2km\",30:00\n", runs: 1, miles: 3.231},
+		{name: "empty", content: ""},
+		{name: "bad row", content: "activity,date,distance,duration\nrun,2026-02-10,3.1,30m\nrun,10.2.2026,3.1,30m\n", runs: 1, err: "row 3: invalid date"},
+		{name: "no distance column", content: "activity,date,duration\nrun,2026-02-10,30m\n", err: "no distance column"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "log.csv")
+			if err := os.WriteFile(path, []byte(test.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			runs, err := (&fileSource{env: map[string]string{}, settings: newSettings()}).readCSV(path)
+			if test.err == "" && err != nil || test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+				t.Errorf("err %v, want %q", err, test.err)
+			}
+			if len(runs) != test.runs {
+				t.Fatalf("read %d runs, want %d", len(runs), test.runs)
+			}
+			if test.miles > 0 && math.Abs(meter2mile(runs[0].distance_float)-test.miles) > 0.001 {
+				t.Errorf("first row is %.3f miles, want %.3f", meter2mile(runs[0].distance_float), test.miles)
+			}
+			for _, run := range runs {
+				if run.strava_id&FILE_ID_BIT == 0 {
+					t.Errorf("run %d has no file id", run.strava_id)
+				}
+			}
+		})
+	}
+}
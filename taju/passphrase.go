@@ -0,0 +1,142 @@
+package taju
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+const PASSPHRASE_PREFIX string = "pass:"
+
+// PBKDF2 (HMAC-SHA256) rounds and salt size of the passphrase key.
+const PASSPHRASE_ROUNDS int = 600000
+const PASSPHRASE_SALT int = 16
+
+// With TAJU_CREDENTIALS=passphrase the secret values are encrypted under a
+// key derived from a passphrase instead of the machine, so a copy of
+// taju.env (a backup, a stolen laptop's disk) is no use without it. The
+// passphrase comes from TAJU_PASSPHRASE in the environment or the answers
+// file, never taju.env, or is asked for once at startup.
+const CREDENTIALS_PASSPHRASE string = "passphrase"
+
+var passphrase struct {
+	mu    sync.Mutex
+	value string
+	read  bool
+	// salt is the one the values written by this process share, keys the
+	// key per salt, so the slow derivation runs once per file.
+	salt []byte
+	keys map[string][]byte
+}
+
+// passphraseKey derives the key for a salt, reading the passphrase the
+// first time.
+func passphraseKey(salt []byte) []byte {
+	passphrase.mu.Lock()
+	defer passphrase.mu.Unlock()
+	if !passphrase.read {
+		passphrase.value = readPassphrase()
+		passphrase.read = true
+		addRedaction(passphrase.value)
+	}
+	if key, ok := passphrase.keys[string(salt)]; ok {
+		return key
+	}
+	key := pbkdf2.Key([]byte(passphrase.value), salt, PASSPHRASE_ROUNDS, 32, sha256.New)
+	if passphrase.keys == nil {
+		passphrase.keys = make(map[string][]byte)
+	}
+	passphrase.keys[string(salt)] = key
+	return key
+}
+
+// checkPassphrase stops a configuration that never prompts when env has
+// values sealed under a passphrase that is neither set nor read already.
+func checkPassphrase(env map[string]string) error {
+	sealed := false
+	for _, value := range env {
+		sealed = sealed || strings.HasPrefix(value, PASSPHRASE_PREFIX)
+	}
+	passphrase.mu.Lock()
+	read := passphrase.read
+	passphrase.mu.Unlock()
+	if !sealed || read {
+		return nil
+	}
+	value, ok, err := presupplied(nil, "TAJU_PASSPHRASE")
+	if err != nil || ok && value != "" {
+		return err
+	}
+	return errors.New("taju.env is encrypted with a passphrase and nothing may prompt for it. Set TAJU_PASSPHRASE in the environment or in TAJU_ANSWERS_FILE")
+}
+
+func readPassphrase() string {
+	value, ok, err := presupplied(nil, "TAJU_PASSPHRASE")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if ok && value != "" {
+		return value
+	}
+	if !terminal() {
+		log.Fatal("taju.env is encrypted with a passphrase and stdin is not a terminal. Set TAJU_PASSPHRASE in the environment or in TAJU_ANSWERS_FILE.")
+	}
+	fmt.Print("Enter the passphrase of taju.env and hit ENTER: ")
+	// Without stty (Windows) the passphrase shows as it is typed.
+	echo := exec.Command("stty", "-echo")
+	echo.Stdin = os.Stdin
+	hidden := echo.Run() == nil
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if hidden {
+		restore := exec.Command("stty", "echo")
+		restore.Stdin = os.Stdin
+		restore.Run()
+		fmt.Println()
+	}
+	value = strings.TrimRight(line, "\r\n")
+	if err != nil || value == "" {
+		log.Fatal("No passphrase entered")
+	}
+	return value
+}
+
+func sealPassphrase(plain string) (string, error) {
+	passphrase.mu.Lock()
+	if passphrase.salt == nil {
+		passphrase.salt = make([]byte, PASSPHRASE_SALT)
+		if _, err := rand.Read(passphrase.salt); err != nil {
+			passphrase.mu.Unlock()
+			return "", err
+		}
+	}
+	salt := passphrase.salt
+	passphrase.mu.Unlock()
+
+	sealed, err := sealBytes(passphraseKey(salt), []byte(plain))
+	if err != nil {
+		return "", err
+	}
+	return PASSPHRASE_PREFIX + base64.StdEncoding.EncodeToString(append(salt[:len(salt):len(salt)], sealed...)), nil
+}
+
+func openPassphrase(value string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, PASSPHRASE_PREFIX))
+	if err != nil {
+		return "", err
+	}
+	if len(data) < PASSPHRASE_SALT {
+		return "", errors.New("encrypted value is too short")
+	}
+	plain, err := openBytes(passphraseKey(data[:PASSPHRASE_SALT]), data[PASSPHRASE_SALT:])
+	return string(plain), err
+}
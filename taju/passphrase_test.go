@@ -0,0 +1,94 @@
+package taju
+
+import (
+	"strings"
+	"sync"
+	"testing"
+)
+
+// usePassphraseOf resets the passphrase state to one read from
+// TAJU_PASSPHRASE.
+func usePassphraseOf(t *testing.T, value string) {
+	t.Helper()
+	t.Setenv("TAJU_PASSPHRASE", value)
+	passphrase.mu.Lock()
+	passphrase.value, passphrase.read, passphrase.salt, passphrase.keys = "", false, nil, nil
+	passphrase.mu.Unlock()
+	t.Cleanup(func() {
+		passphrase.mu.Lock()
+		passphrase.value, passphrase.read, passphrase.salt, passphrase.keys = "", false, nil, nil
+		passphrase.mu.Unlock()
+	})
+}
+
+func TestPassphraseRoundTrip(t *testing.T) {
+	usePassphraseOf(t, "correct horse")
+	tests := []string{"secret-token", "", `{"access_token":"a","refresh_token":"r"}`, strings.Repeat("x", 4096)}
+	for _, plain := range tests {
+		sealed, err := sealPassphrase(plain)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(sealed, PASSPHRASE_PREFIX) || (plain != "" && strings.Contains(sealed, plain)) {
+			t.Fatalf("sealPassphrase(%q) = %q", plain, sealed)
+		}
+		opened, err := openPassphrase(sealed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if opened != plain {
+			t.Errorf("openPassphrase(sealPassphrase(%q)) = %q", plain, opened)
+		}
+	}
+}
+
+func TestOpenPassphrase(t *testing.T) {
+	// Sealed before the key derivation moved to x/crypto, with passphrase
+	// "correct horse"; values already in taju.env must still open.
+	const SEALED = "pass:MDEyMzQ1Njc4OWFiY2RlZmZpeGVkbm9uY2UxMpPh+KDWBj8/bDye8bswoHxej6fnP+ftmVLujZI="
+	tests := []struct {
+		name       string
+		passphrase string
+		value      string
+		want       string
+		wantErr    bool
+	}{
+		{name: "existing value", passphrase: "correct horse", value: SEALED, want: "secret-token"},
+		{name: "wrong passphrase", passphrase: "battery staple", value: SEALED, wantErr: true},
+		{name: "tampered", passphrase: "correct horse", value: strings.Replace(SEALED, "ZI=", "ZA=", 1), wantErr: true},
+		{name: "truncated", passphrase: "correct horse", value: "pass:MDEy", wantErr: true},
+		{name: "not base64", passphrase: "correct horse", value: "pass:%%%", wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			usePassphraseOf(t, test.passphrase)
+			got, err := openPassphrase(test.value)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("openPassphrase() error = %v, wantErr %v", err, test.wantErr)
+			}
+			if got != test.want {
+				t.Errorf("openPassphrase() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestPassphraseKeyConcurrent(t *testing.T) {
+	usePassphraseOf(t, "correct horse")
+	salt := []byte("0123456789abcdef")
+	keys := make([][]byte, 4)
+	var derive sync.WaitGroup
+	for i := range keys {
+		derive.Add(1)
+		go func() {
+			defer derive.Done()
+			keys[i] = passphraseKey(salt)
+		}()
+	}
+	derive.Wait()
+	for _, key := range keys[1:] {
+		if string(key) != string(keys[0]) {
+			t.Fatal("passphraseKey() differs between callers")
+		}
+	}
+}
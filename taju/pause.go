@@ -0,0 +1,55 @@
+package taju
+
+import (
+	"fmt"
+	"time"
+)
+
+const DEFAULT_MANUAL_EDIT_PAUSE = 30 * time.Minute
+
+// loadManualEditPause reads TAJU_MANUAL_EDIT_PAUSE, how long automated
+// changes wait after entries were logged by hand on the Taji site, so the
+// uploader doesn't interleave with (or clobber) someone doing their own
+// bookkeeping. 0 turns the pause off.
+func loadManualEditPause(env map[string]string) (time.Duration, error) {
+	value, ok := env["TAJU_MANUAL_EDIT_PAUSE"]
+	if !ok {
+		return DEFAULT_MANUAL_EDIT_PAUSE, nil
+	}
+	pause, err := time.ParseDuration(value)
+	if err != nil || pause < 0 {
+		return 0, fmt.Errorf("invalid TAJU_MANUAL_EDIT_PAUSE=%q, expected a duration like 30m", value)
+	}
+	return pause, nil
+}
+
+// noteManualEdits looks for entries that showed up on Taji since the last
+// cycle without the uploader's idempotency key, i.e. logged on the site,
+// and pauses automated changes for pause after seeing one. The first cycle
+// only takes stock. It returns how many such entries were found.
+func (s *stateStore) noteManualEdits(scraped []tajiEvent, pause time.Duration, now time.Time) (found int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	first := s.LastSync.IsZero()
+	s.LastSync = now
+	if first || pause == 0 {
+		return 0
+	}
+	for _, event := range scraped {
+		if event.key == "" {
+			found++
+		}
+	}
+	if found > 0 {
+		s.PausedUntil = now.Add(pause)
+	}
+	return found
+}
+
+// pausedUntil returns when the pause set by noteManualEdits ends, if one is
+// running.
+func (s *stateStore) pausedUntil(now time.Time) (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.PausedUntil, now.Before(s.PausedUntil)
+}
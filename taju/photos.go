@@ -0,0 +1,94 @@
+package taju
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+)
+
+// MAX_PHOTO_BYTES bounds the Strava photo attached to a post, well above
+// the size of the largest one Strava serves.
+const MAX_PHOTO_BYTES = 20 << 20
+
+// fillPhotos loads the primary photo of activities that have photos when
+// TAJU_UPLOAD_PHOTOS=true. The list endpoint only counts photos, their URLs
+// come with the detailed representation.
+func fillPhotos(s *strava, activities []stravaActivity) {
+	if !s.upload_photos {
+		return
+	}
+	var missing []int
+	for i, activity := range activities {
+		if activity.TotalPhotoCount > 0 && activity.Photos.Primary == nil {
+			missing = append(missing, i)
+		}
+	}
+	api := stravaAPI(s)
+	forEachLimit(len(missing), s.detail_workers, func(i int) {
+		activity := &activities[missing[i]]
+		detail, err := api.Activity(activity.Id)
+		if err != nil {
+			log.Print("Error fetching photos of Strava activity ", activity.Id, ": ", err)
+			return
+		}
+		activity.Photos = detail.Photos
+	})
+}
+
+// primaryPhotoURL returns the largest size of the activity's primary photo.
+func primaryPhotoURL(activity stravaActivity) (photo_url string) {
+	if activity.Photos.Primary == nil {
+		return ""
+	}
+	largest := -1
+	for size, candidate := range activity.Photos.Primary.Urls {
+		if n, err := strconv.Atoi(size); err == nil && n > largest {
+			largest, photo_url = n, candidate
+		}
+	}
+	return
+}
+
+// postRunWithPhoto posts the log form with the run's Strava photo attached
+// to the form's file input.
+func postRunWithPhoto(t *taji, endpoint_path string, values url.Values, field string, r runDetails) (*http.Response, error) {
+	data, err := downloadPhoto(t.run.context(), t.photos, r.photo_url)
+	if err != nil {
+		return nil, err
+	}
+
+	filename := fmt.Sprintf("strava-%d.jpg", r.strava_id)
+	if photo, err := url.Parse(r.photo_url); err == nil && path.Ext(photo.Path) != "" {
+		filename = fmt.Sprintf("strava-%d%s", r.strava_id, path.Ext(photo.Path))
+	}
+	return t.client.PostMultipart(t.run.context(), endpoint_path, values, field, filename, data)
+}
+
+// downloadPhoto reads a photo of at most MAX_PHOTO_BYTES.
+func downloadPhoto(ctx context.Context, client *http.Client, photo_url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, photo_url, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if err := checkStatus(res, "downloading Strava photo"); err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(io.LimitReader(res.Body, MAX_PHOTO_BYTES+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > MAX_PHOTO_BYTES {
+		return nil, fmt.Errorf("the Strava photo is larger than %d MB", MAX_PHOTO_BYTES>>20)
+	}
+	return data, nil
+}
@@ -0,0 +1,39 @@
+package taju
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDownloadPhoto(t *testing.T) {
+	tests := []struct {
+		name   string
+		size   int
+		status int
+		ok     bool
+	}{
+		{name: "photo", size: 1 << 20, status: http.StatusOK, ok: true},
+		{name: "as large as allowed", size: MAX_PHOTO_BYTES, status: http.StatusOK, ok: true},
+		{name: "too large", size: MAX_PHOTO_BYTES + 1, status: http.StatusOK},
+		{name: "gone", status: http.StatusNotFound},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(test.status)
+				w.Write(bytes.Repeat([]byte{0xff}, test.size))
+			}))
+			defer server.Close()
+			data, err := downloadPhoto(context.Background(), server.Client(), server.URL+"/photo.jpg")
+			if test.ok && (err != nil || len(data) != test.size) {
+				t.Errorf("read %d bytes (%v), want %d", len(data), err, test.size)
+			}
+			if !test.ok && err == nil {
+				t.Error("downloaded, want an error")
+			}
+		})
+	}
+}
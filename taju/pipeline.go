@@ -0,0 +1,262 @@
+package taju
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A run goes through an ordered pipeline of transforms between the raw
+// Strava values set by createRun and the form fields posted to Taji. The
+// order is configurable with TAJU_TRANSFORMS so distance and time policies
+// compose; a transform returning an error keeps the activity from uploading.
+type runTransform struct {
+	name  string
+	apply func(run *runDetails) error
+}
+
+type runPipeline []runTransform
+
+const DEFAULT_TRANSFORMS string = "time,special,adjust,units,duration,elevation,forms,overrides,validate"
+
+// TRANSFORM_BUILDERS build the transforms of env, writing the values per
+// the settings of its uploader. They fail on an invalid setting.
+var TRANSFORM_BUILDERS = map[string]func(env map[string]string, s *settings) (runTransform, error){
+	"time":      clockTimeTransform,
+	"special":   specialDaysTransform,
+	"adjust":    adjustTransform,
+	"units":     infallible(distanceTransform),
+	"duration":  infallible(func(map[string]string, *settings) runTransform { return runTransform{"duration", durationTransform} }),
+	"elevation": elevationTransform,
+	"forms":     infallible(sportFormsTransform),
+	"overrides": overridesTransform,
+	"validate":  infallible(validateTransform),
+}
+
+// infallible adapts a builder without settings of its own to read.
+func infallible(build func(map[string]string, *settings) runTransform) func(map[string]string, *settings) (runTransform, error) {
+	return func(env map[string]string, s *settings) (runTransform, error) {
+		return build(env, s), nil
+	}
+}
+
+func loadPipeline(env map[string]string, s *settings) (runPipeline, error) {
+	var pipeline runPipeline
+	names := splitList(env["TAJU_TRANSFORMS"])
+	if len(names) == 0 {
+		names = splitList(DEFAULT_TRANSFORMS)
+	}
+	for _, name := range names {
+		build, ok := TRANSFORM_BUILDERS[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown transform %q in TAJU_TRANSFORMS", name)
+		}
+		transform, err := build(env, s)
+		if err != nil {
+			return nil, err
+		}
+		pipeline = append(pipeline, transform)
+	}
+	return pipeline, nil
+}
+
+func (p runPipeline) apply(run runDetails) (runDetails, error) {
+	return p.applyTraced(run, nil)
+}
+
+// applyTraced runs the pipeline calling trace, if set, after every transform
+// with the run as it was before and after it.
+func (p runPipeline) applyTraced(run runDetails, trace func(name string, before, after runDetails, err error)) (runDetails, error) {
+	for _, transform := range p {
+		before := run
+		err := transform.apply(&run)
+		if trace != nil {
+			trace(transform.name, before, run, err)
+		}
+		if err != nil {
+			return run, fmt.Errorf("%s: %w", transform.name, err)
+		}
+	}
+	return run, nil
+}
+
+// clockTimeTransform fills in the local start date and clock time, in the
+// 12 or 24 hour format of the Taji form (see units). Runs are dated in
+// their own timezone, see loadTimezone and loadMidnightRule.
+func clockTimeTransform(env map[string]string, s *settings) (runTransform, error) {
+	zone, err := loadTimezone(env)
+	if err != nil {
+		return runTransform{}, err
+	}
+	midnight, err := loadMidnightRule(env)
+	if err != nil {
+		return runTransform{}, err
+	}
+	return runTransform{"time", func(run *runDetails) error {
+		location := runLocation(zone, *run)
+		s.setClockTime(run, logStart(run.start, time.Duration(run.duration_int)*time.Second, location, midnight))
+		return nil
+	}}, nil
+}
+
+func (s *settings) setClockTime(run *runDetails, t time.Time) {
+	run.date = t.Format("2006-01-02")
+	run.time = t.Format(s.taji_units.timeLayout())
+	run.time_minutes = t.Format("04")
+	if s.taji_units.clock24 {
+		run.time_hours = t.Format("15")
+		run.time_ampm = ""
+	} else {
+		run.time_hours = t.Format("03")
+		run.time_ampm = t.Format("PM")
+	}
+}
+
+// distanceTransform converts the distance to the units of the Taji form
+// (miles unless TAJU_TAJI_UNITS=km) with two decimals. Runs without a
+// distance are left blank, see loadDurationOnly.
+func distanceTransform(_ map[string]string, s *settings) runTransform {
+	return runTransform{"units", func(run *runDetails) error {
+		if run.distance_float <= 0 {
+			run.distance = ""
+			return nil
+		}
+		run.distance = s.tajiDistance(run.distance_float)
+		return nil
+	}}
+}
+
+func durationTransform(run *runDetails) error {
+	seconds := run.duration_int % 60
+	minutes := run.duration_int / 60 % 60
+	hours := run.duration_int / 3600
+	run.duration = fmt.Sprintf("%01d:%01d:%02d", hours, minutes, seconds)
+	run.duration_hours = fmt.Sprintf("%01d", hours)
+	run.duration_minutes = fmt.Sprintf("%01d", minutes)
+	run.duration_seconds = fmt.Sprintf("%02d", seconds)
+	return nil
+}
+
+// elevationTransform posts Strava's total_elevation_gain in whole feet.
+// TAJU_UPLOAD_ELEVATION=false leaves it off for those who don't want
+// elevation counted.
+func elevationTransform(env map[string]string, _ *settings) (runTransform, error) {
+	upload := true
+	if value, ok := env["TAJU_UPLOAD_ELEVATION"]; ok {
+		upload = envBool(env, "TAJU_UPLOAD_ELEVATION")
+		if _, err := strconv.ParseBool(value); err != nil {
+			return runTransform{}, fmt.Errorf("invalid TAJU_UPLOAD_ELEVATION=%q, expected true or false", value)
+		}
+	}
+	return runTransform{"elevation", func(run *runDetails) error {
+		run.elevation_gain = ""
+		if upload && run.elevation_float > 0 {
+			run.elevation_gain = fmt.Sprintf("%.0f", meter2feet(run.elevation_float))
+		}
+		return nil
+	}}, nil
+}
+
+// overridesTransform applies manual corrections from TAJU_OVERRIDE_<strava
+// id> keys, e.g. TAJU_OVERRIDE_1234567=distance=3.10,elevation_gain=120.
+// Distances and elevations can be typed with a decimal comma and a unit
+// (distance=5,2km, elevation_gain=40m) and are written in the units of the
+// Taji form; durations can be h:mm:ss or e.g. 45m.
+func overridesTransform(env map[string]string, s *settings) (runTransform, error) {
+	overrides := make(map[string]map[string]string)
+	for key, value := range env {
+		id, ok := strings.CutPrefix(key, "TAJU_OVERRIDE_")
+		if !ok {
+			continue
+		}
+		fields := make(map[string]string)
+		last := ""
+		for _, pair := range splitList(value) {
+			field, field_value, ok := strings.Cut(pair, "=")
+			if !ok {
+				// The decimals of a number typed with a comma.
+				if last == "" {
+					return runTransform{}, fmt.Errorf("invalid %s entry %q, expected field=value", key, pair)
+				}
+				fields[last] += "," + pair
+				continue
+			}
+			last = strings.TrimSpace(field)
+			fields[last] = strings.TrimSpace(field_value)
+		}
+		for field, field_value := range fields {
+			normal, err := s.normalizeOverride(field, field_value)
+			if err != nil {
+				return runTransform{}, fmt.Errorf("invalid %s: %w", key, err)
+			}
+			fields[field] = normal
+		}
+		overrides[id] = fields
+	}
+
+	return runTransform{"overrides", func(run *runDetails) error {
+		for field, value := range overrides[fmt.Sprint(run.strava_id)] {
+			switch field {
+			case "activity":
+				run.activity = value
+			case "date":
+				run.date = value
+			case "distance":
+				run.distance = value
+			case "duration":
+				run.duration = value
+			case "elevation_gain":
+				run.elevation_gain = value
+			default:
+				return fmt.Errorf("cannot override %q", field)
+			}
+		}
+		return nil
+	}}, nil
+}
+
+// normalizeOverride writes an override value the way the Taji form takes
+// it.
+func (s *settings) normalizeOverride(field string, value string) (string, error) {
+	switch field {
+	case "distance":
+		meters, err := parseDistance(value, s.taji_units.distance)
+		if err != nil || meters < 0 {
+			return "", fmt.Errorf("invalid distance %q", value)
+		}
+		return s.tajiDistance(meters), nil
+	case "duration":
+		seconds, ok := parseTypedDuration(value)
+		if !ok || seconds <= 0 {
+			return "", fmt.Errorf("invalid duration %q", value)
+		}
+		return fmt.Sprintf("%d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60), nil
+	case "elevation_gain":
+		meters, err := parseElevation(value)
+		if err != nil || meters < 0 {
+			return "", fmt.Errorf("invalid elevation %q", value)
+		}
+		return fmt.Sprintf("%.0f", meter2feet(meters)), nil
+	}
+	return value, nil
+}
+
+// validateTransform rejects runs Taji would refuse.
+func validateTransform(_ map[string]string, s *settings) runTransform {
+	return runTransform{"validate", func(run *runDetails) error {
+		if run.activity == "" {
+			return fmt.Errorf("no activity type")
+		}
+		if run.date == "" || run.time == "" {
+			return fmt.Errorf("no start date or time")
+		}
+		if run.duration_int <= 0 && run.duration == "" {
+			return fmt.Errorf("no duration")
+		}
+		if schema := s.formSchemaOf(run.activity); schema != nil {
+			return schema.checkSchema(*run)
+		}
+		return nil
+	}}
+}
@@ -0,0 +1,31 @@
+package taju
+
+import "testing"
+
+func TestDurationTransform(t *testing.T) {
+	tests := []struct {
+		seconds              int64
+		duration             string
+		hours, minutes, secs string
+	}{
+		{seconds: 29*60 + 5, duration: "0:29:05", hours: "0", minutes: "29", secs: "05"},
+		{seconds: 3600, duration: "1:0:00", hours: "1", minutes: "0", secs: "00"},
+		{seconds: 2*3600 + 3*60 + 9, duration: "2:3:09", hours: "2", minutes: "3", secs: "09"},
+		{seconds: 10*3600 + 59*60 + 59, duration: "10:59:59", hours: "10", minutes: "59", secs: "59"},
+	}
+	for _, test := range tests {
+		t.Run(test.duration, func(t *testing.T) {
+			run := runDetails{duration_int: test.seconds}
+			if err := durationTransform(&run); err != nil {
+				t.Fatal(err)
+			}
+			if run.duration != test.duration || run.duration_hours != test.hours || run.duration_minutes != test.minutes || run.duration_seconds != test.secs {
+				t.Errorf("%d s is %q (%s h %s min %s s), want %q", test.seconds, run.duration, run.duration_hours,
+					run.duration_minutes, run.duration_seconds, test.duration)
+			}
+			if seconds, ok := parseClockDuration(run.duration); !ok || seconds != test.seconds {
+				t.Errorf("%q reads back as %d s, want %d", run.duration, seconds, test.seconds)
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package taju
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Conflict policies decide what the sync engine does when Strava and Taji
+// disagree. Each class is configured with TAJU_POLICY_<CLASS> in taju.env:
+//
+//	class        detected when                                  skip          overwrite               log (default*)
+//	duplicate    no entry at the run's time, but one on the     don't post    update that entry       post anyway
+//	             same day with the same distance
+//	mismatch     entry at the run's time has another distance   leave it*     update from Strava      report only
+//	             or duration
+//	strava_edit  entry uploaded for the run (idempotency key)   leave it      update from Strava*     report only
+//	             has another date, time, distance or duration
+//	taji_only    entry with no Strava activity at its time      ignore it*    delete the entry        report only
+//
+// prompt asks on the terminal whether to take the overwrite action (or, for
+// duplicate, whether to post anyway) and falls back to skip when nobody is
+// there to answer. Starred actions are the defaults. Entries the uploader
+// posted itself follow Strava edits (a cropped or corrected activity);
+// everything else keeps the uploader's historical behavior.
+type conflictPolicy string
+
+const (
+	POLICY_SKIP      conflictPolicy = "skip"
+	POLICY_PROMPT    conflictPolicy = "prompt"
+	POLICY_OVERWRITE conflictPolicy = "overwrite"
+	POLICY_LOG       conflictPolicy = "log"
+)
+
+type conflictClass string
+
+const (
+	CONFLICT_DUPLICATE   conflictClass = "duplicate"
+	CONFLICT_MISMATCH    conflictClass = "mismatch"
+	CONFLICT_STRAVA_EDIT conflictClass = "strava_edit"
+	CONFLICT_TAJI_ONLY   conflictClass = "taji_only"
+)
+
+var DEFAULT_POLICIES = map[conflictClass]conflictPolicy{
+	CONFLICT_DUPLICATE:   POLICY_LOG,
+	CONFLICT_MISMATCH:    POLICY_SKIP,
+	CONFLICT_STRAVA_EDIT: POLICY_OVERWRITE,
+	CONFLICT_TAJI_ONLY:   POLICY_SKIP,
+}
+
+type conflictPolicies map[conflictClass]conflictPolicy
+
+func loadConflictPolicies(env map[string]string) (conflictPolicies, error) {
+	policies := make(conflictPolicies)
+	for class, fallback := range DEFAULT_POLICIES {
+		key := "TAJU_POLICY_" + strings.ToUpper(string(class))
+		value, ok := env[key]
+		if !ok {
+			policies[class] = fallback
+			continue
+		}
+		switch policy := conflictPolicy(strings.ToLower(value)); policy {
+		case POLICY_SKIP, POLICY_PROMPT, POLICY_OVERWRITE, POLICY_LOG:
+			policies[class] = policy
+		default:
+			return nil, fmt.Errorf("invalid %s=%q, expected skip, prompt, overwrite or log", key, value)
+		}
+	}
+	return policies, nil
+}
+
+// resolve reports whether the overwrite action should be taken for a
+// conflict, logging it when the policy asks for that. A prompt is only
+// shown when ask is set, see settings.interactive.
+func (p conflictPolicies) resolve(class conflictClass, description string, ask bool) bool {
+	switch p[class] {
+	case POLICY_OVERWRITE:
+		log.Printf("Conflict (%s): %s, overwriting", class, description)
+		return true
+	case POLICY_PROMPT:
+		if !ask {
+			log.Printf("Conflict (%s): %s, skipping since nobody can answer the prompt", class, description)
+			return false
+		}
+		return confirm(fmt.Sprintf("Conflict (%s): %s. Overwrite?", class, description))
+	case POLICY_LOG:
+		log.Printf("Conflict (%s): %s", class, description)
+	}
+	return false
+}
+
+// classifyMatch reports which conflict, if any, the Taji event matched to
+// run by findEvent has with it. An event carrying the run's idempotency key
+// was posted from this activity, so any difference is an edit on Strava.
+func (s *settings) classifyMatch(run runDetails, event tajiEvent) (conflictClass, bool) {
+	class := CONFLICT_MISMATCH
+	if key := idempotencyKey(run); key != "" && event.key == key {
+		class = CONFLICT_STRAVA_EDIT
+		if event.date != run.date || event.time != run.time {
+			return class, true
+		}
+	}
+	if event.distance != "" {
+		posted := s.quantizeDistance(s.taji_units.fromMeters(run.distance_float))
+		if run.distance_unit != "" {
+			// Written by a TAJU_FORM_ template in its own unit.
+			posted, _ = strconv.ParseFloat(run.distance, 64)
+		}
+		if distance, err := strconv.ParseFloat(event.distance, 64); err == nil && math.Abs(distance-posted) >= 0.01 {
+			return class, true
+		}
+	}
+	if event.duration != "" {
+		if duration, ok := parseClockDuration(event.duration); ok && absInt64(s.roundDuration(duration)-run.duration_int) >= 60 {
+			return class, true
+		}
+	}
+	return "", false
+}
+
+// findSuspectedDuplicate returns an event on the run's day with the same
+// distance, e.g. a run that was also entered manually at a rounded time.
+func findSuspectedDuplicate(run runDetails, events []tajiEvent) (tajiEvent, bool) {
+	for _, event := range events {
+		if run.distance != "" && event.date == run.date && event.distance == run.distance {
+			return event, true
+		}
+	}
+	return tajiEvent{}, false
+}
+
+// parseClockDuration parses h:mm:ss (or mm:ss) into seconds.
+func parseClockDuration(value string) (int64, bool) {
+	var seconds int64
+	for _, part := range strings.Split(value, ":") {
+		n, err := strconv.ParseInt(part, 10, 64)
+		if err != nil {
+			return 0, false
+		}
+		seconds = seconds*60 + n
+	}
+	return seconds, true
+}
+
+func absInt64(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,208 @@
+package taju
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Polite mode is on unless TAJU_POLITE=false: Taji100 is a small community
+// site, and every user of the uploader polls it. It fetches Taji pages one
+// at a time, spaced by TAJU_TAJI_DELAY, revalidates pages instead of loading
+// them again, and doesn't sync during TAJU_QUIET_HOURS.
+const (
+	POLITE_TAJI_WORKERS = 1
+	POLITE_TAJI_DELAY   = time.Second
+	POLITE_QUIET_HOURS  = "0-6"
+	POLITE_CACHE_PAGES  = 200
+)
+
+func politeMode(env map[string]string) (bool, error) {
+	value, ok := env["TAJU_POLITE"]
+	if !ok {
+		return true, nil
+	}
+	polite, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid TAJU_POLITE=%q, expected true or false", value)
+	}
+	return polite, nil
+}
+
+// loadTajiDelay reads TAJU_TAJI_DELAY, the least time between two requests
+// to Taji.
+func loadTajiDelay(env map[string]string) (time.Duration, error) {
+	value, ok := env["TAJU_TAJI_DELAY"]
+	if !ok {
+		if polite, err := politeMode(env); err != nil || !polite {
+			return 0, err
+		}
+		return POLITE_TAJI_DELAY, nil
+	}
+	delay, err := time.ParseDuration(value)
+	if err != nil || delay < 0 {
+		return 0, fmt.Errorf("invalid TAJU_TAJI_DELAY=%q, expected a duration like 1s", value)
+	}
+	return delay, nil
+}
+
+// quietHours is a daily range of local hours, end exclusive, that may wrap
+// around midnight (e.g. 22-6).
+type quietHours struct {
+	start, end int
+}
+
+// loadQuietHours reads TAJU_QUIET_HOURS, e.g. 0-6. "off" (or no value
+// outside polite mode) syncs around the clock.
+func loadQuietHours(env map[string]string) (*quietHours, error) {
+	value, ok := env["TAJU_QUIET_HOURS"]
+	if !ok {
+		if polite, err := politeMode(env); err != nil || !polite {
+			return nil, err
+		}
+		value = POLITE_QUIET_HOURS
+	}
+	if value == "" || value == "off" {
+		return nil, nil
+	}
+	start, end, ok := strings.Cut(value, "-")
+	from, err1 := strconv.Atoi(strings.TrimSpace(start))
+	to, err2 := strconv.Atoi(strings.TrimSpace(end))
+	if !ok || err1 != nil || err2 != nil || from < 0 || from > 23 || to < 0 || to > 24 || from == to {
+		return nil, fmt.Errorf("invalid TAJU_QUIET_HOURS=%q, expected hours like 0-6", value)
+	}
+	return &quietHours{start: from, end: to % 24}, nil
+}
+
+func (q *quietHours) contains(t time.Time) bool {
+	hour := t.Hour()
+	if q.start < q.end {
+		return hour >= q.start && hour < q.end
+	}
+	return hour >= q.start || hour < q.end
+}
+
+// delay moves a sync that would run during the quiet hours to their end.
+// Triggered syncs (push events, Enter, the control endpoint) still run.
+func (q *quietHours) delay(now time.Time, interval time.Duration) time.Duration {
+	if q == nil {
+		return interval
+	}
+	next := now.Add(interval)
+	if !q.contains(next) {
+		return interval
+	}
+	end := time.Date(next.Year(), next.Month(), next.Day(), q.end, 0, 0, 0, next.Location())
+	if !end.After(next) {
+		end = end.AddDate(0, 0, 1)
+	}
+	return end.Sub(now)
+}
+
+// politeTransport spaces out requests to Taji and revalidates pages it has
+// seen with If-None-Match or If-Modified-Since, answering a 304 with the
+// page it kept.
+type politeTransport struct {
+	base  http.RoundTripper
+	delay time.Duration
+	clock clock
+
+	mu    sync.Mutex
+	next  time.Time
+	pages map[string]*cachedPage
+}
+
+type cachedPage struct {
+	etag          string
+	last_modified string
+	header        http.Header
+	body          []byte
+}
+
+func newPoliteTransport(base http.RoundTripper, delay time.Duration, c clock) *politeTransport {
+	return &politeTransport{base: base, delay: delay, clock: c, pages: make(map[string]*cachedPage)}
+}
+
+func (t *politeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.wait()
+	if req.Method != http.MethodGet {
+		return t.base.RoundTrip(req)
+	}
+
+	page_url := req.URL.String()
+	t.mu.Lock()
+	page := t.pages[page_url]
+	t.mu.Unlock()
+	if page != nil {
+		req = req.Clone(req.Context())
+		if page.etag != "" {
+			req.Header.Set("If-None-Match", page.etag)
+		}
+		if page.last_modified != "" {
+			req.Header.Set("If-Modified-Since", page.last_modified)
+		}
+	}
+
+	res, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case res.StatusCode == http.StatusNotModified && page != nil:
+		io.Copy(io.Discard, res.Body)
+		res.Body.Close()
+		return &http.Response{
+			Status:        "200 OK",
+			StatusCode:    http.StatusOK,
+			Proto:         res.Proto,
+			ProtoMajor:    res.ProtoMajor,
+			ProtoMinor:    res.ProtoMinor,
+			Header:        page.header.Clone(),
+			Body:          io.NopCloser(bytes.NewReader(page.body)),
+			ContentLength: int64(len(page.body)),
+			Request:       req,
+		}, nil
+	case res.StatusCode == http.StatusOK && (res.Header.Get("ETag") != "" || res.Header.Get("Last-Modified") != ""):
+		body, err := io.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		res.Body = io.NopCloser(bytes.NewReader(body))
+		t.mu.Lock()
+		if len(t.pages) >= POLITE_CACHE_PAGES {
+			clear(t.pages)
+		}
+		t.pages[page_url] = &cachedPage{
+			etag:          res.Header.Get("ETag"),
+			last_modified: res.Header.Get("Last-Modified"),
+			header:        res.Header.Clone(),
+			body:          body,
+		}
+		t.mu.Unlock()
+	}
+	return res, nil
+}
+
+// wait holds a request until TAJU_TAJI_DELAY passed since the previous one.
+func (t *politeTransport) wait() {
+	if t.delay <= 0 {
+		return
+	}
+	t.mu.Lock()
+	now := t.clock.Now()
+	start := now
+	if t.next.After(now) {
+		start = t.next
+	}
+	t.next = start.Add(t.delay)
+	t.mu.Unlock()
+	if wait := start.Sub(now); wait > 0 {
+		t.clock.Sleep(wait)
+	}
+}
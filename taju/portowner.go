@@ -0,0 +1,80 @@
+package taju
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// portOwner describes the process listening on a local TCP port, as far as
+// the OS tools let us see it, e.g. "python3 (pid 4242)". It returns "" when
+// the owner can't be identified.
+func portOwner(port int) string {
+	if runtime.GOOS == "windows" {
+		return windowsPortOwner(port)
+	}
+	out, err := exec.Command("lsof", "-nP", fmt.Sprintf("-iTCP:%d", port), "-sTCP:LISTEN").Output()
+	if err == nil {
+		scanner := bufio.NewScanner(bytes.NewReader(out))
+		scanner.Scan() // header
+		if scanner.Scan() {
+			if fields := strings.Fields(scanner.Text()); len(fields) > 1 {
+				return fmt.Sprintf("%s (pid %s)", fields[0], fields[1])
+			}
+		}
+	}
+	// ss prints users:(("name",pid=123,fd=4)) for sockets we may see.
+	out, err = exec.Command("ss", "-Hltnp", fmt.Sprintf("sport = :%d", port)).Output()
+	if err == nil {
+		line := string(out)
+		if start := strings.Index(line, `(("`); start >= 0 {
+			rest := line[start+3:]
+			name, rest, _ := strings.Cut(rest, `"`)
+			if _, pid, ok := strings.Cut(rest, "pid="); ok {
+				pid, _, _ = strings.Cut(pid, ",")
+				return fmt.Sprintf("%s (pid %s)", name, pid)
+			}
+			return name
+		}
+	}
+	return ""
+}
+
+func windowsPortOwner(port int) string {
+	out, err := exec.Command("netstat", "-ano", "-p", "TCP").Output()
+	if err != nil {
+		return ""
+	}
+	suffix := ":" + strconv.Itoa(port)
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 5 || !strings.HasSuffix(fields[1], suffix) || fields[3] != "LISTENING" {
+			continue
+		}
+		pid := fields[4]
+		out, err := exec.Command("tasklist", "/FI", "PID eq "+pid, "/FO", "CSV", "/NH").Output()
+		if err != nil {
+			return "pid " + pid
+		}
+		name, _, _ := strings.Cut(strings.TrimSpace(string(out)), ",")
+		return fmt.Sprintf("%s (pid %s)", strings.Trim(name, `"`), pid)
+	}
+	return ""
+}
+
+// closeHint tells the user how to free the port held by owner.
+func closeHint(owner string) string {
+	_, pid, ok := strings.Cut(owner, "pid ")
+	if !ok {
+		return ""
+	}
+	pid = strings.TrimSuffix(pid, ")")
+	if runtime.GOOS == "windows" {
+		return "taskkill /PID " + pid
+	}
+	return "kill " + pid
+}
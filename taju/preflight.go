@@ -0,0 +1,161 @@
+package taju
+
+import (
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"time"
+)
+
+// PREFLIGHT_INTERVAL is how often the daemon checks the logins while it
+// waits for the event to start.
+const PREFLIGHT_INTERVAL = 7 * 24 * time.Hour
+
+// PREFLIGHT_DAYS are the days of Strava activities the test sync reads.
+const PREFLIGHT_DAYS = 7
+
+type preflightCheck struct {
+	name   string
+	ok     bool
+	detail string
+}
+
+// preflight walks the checklist for day one: the Taji login reaches the
+// participant page, every Strava account is authorized, and a test sync of
+// the last week of activities into a throwaway Taji gets through the whole
+// pipeline. Nothing is posted to Taji and the ledger isn't touched.
+func preflight(u *uploader) (checks []preflightCheck) {
+	ok, detail := testTajiSession(&u.taji)
+	checks = append(checks, preflightCheck{"Taji login", ok, detail})
+
+	authorized := true
+	for _, s := range u.accounts {
+		athlete, err := stravaAPI(s).Athlete()
+		if err != nil {
+			authorized = false
+			checks = append(checks, preflightCheck{"Strava (" + s.name + ")", false, err.Error()})
+			continue
+		}
+		checks = append(checks, preflightCheck{"Strava (" + s.name + ")", true,
+			fmt.Sprintf("authorized as %s %s", athlete.Firstname, athlete.Lastname)})
+	}
+	if len(u.accounts) == 0 {
+		checks = append(checks, preflightCheck{"Strava", false, "no accounts to sync, run: taju auth strava"})
+		authorized = false
+	}
+	if authorized {
+		checks = append(checks, testSync(u))
+	}
+	saveStravaTokens(u)
+	return
+}
+
+// testSync runs a scratch cycle over the last PREFLIGHT_DAYS days. The
+// accounts are copies, so the real ones keep their window and what they
+// have seen; only a refreshed token is taken over.
+func testSync(u *uploader) preflightCheck {
+	now := u.clock.Now()
+	scratch := &uploader{env: maps.Clone(u.env), config: u.config, profile: u.profile, clock: u.clock, scoring: u.scoring, goal: u.goal,
+		messages: u.messages, layers: u.layers, state_dir: u.state_dir, post_workers: 1, state: memoryState(u.clock), settings: u.settings}
+	for _, account := range u.accounts {
+		probe := *account
+		probe.seen, probe.complete, probe.cursor = nil, false, time.Time{}
+		probe.window_start, probe.window_end = now.AddDate(0, 0, -PREFLIGHT_DAYS), now
+		scratch.accounts = append(scratch.accounts, &probe)
+	}
+	s, err := newSyncer(scratch)
+	if err != nil {
+		return preflightCheck{"Test sync", false, err.Error()}
+	}
+	s.taji, s.scratch = newMemoryTaji(nil), true
+	var errs []error
+	subscribe(&s.events, func(e errorOccurred) { errs = append(errs, e.err) })
+	result := s.cycle()
+	for i, account := range u.accounts {
+		account.token, account.source = scratch.accounts[i].token, scratch.accounts[i].source
+	}
+	if result.failed {
+		detail := "the test sync failed"
+		if len(errs) > 0 {
+			detail += ": " + errs[0].Error()
+		}
+		return preflightCheck{"Test sync", false, detail}
+	}
+	return preflightCheck{"Test sync", true, fmt.Sprintf("%d activities of the last %d days would be posted", len(result.posted), PREFLIGHT_DAYS)}
+}
+
+func printPreflight(checks []preflightCheck) bool {
+	all_ok := true
+	for _, check := range checks {
+		printCheck(check.ok, check.name, check.detail)
+		all_ok = all_ok && check.ok
+	}
+	return all_ok
+}
+
+// countdown is the time left until the event starts, "" once it has.
+func countdown(now time.Time, start time.Time) string {
+	left := start.Sub(now)
+	if left <= 0 {
+		return ""
+	}
+	days := int(left.Hours()) / 24
+	return fmt.Sprintf("%d days %d hours until the event starts on %s", days, int(left.Hours())%24, start.Format("Mon Jan 2"))
+}
+
+// preflightCommand prints the countdown and the checklist, failing if an
+// item did.
+func preflightCommand(u *uploader) {
+	if err := initStravaAccounts(u); err != nil {
+		log.Fatal(err)
+	}
+	if err := initTajiSession(u); err != nil {
+		log.Fatal(err)
+	}
+	_, start, _ := goalWindow(u)
+	if left := countdown(u.clock.Now(), start); left != "" {
+		fmt.Println(left)
+	}
+	ok := printPreflight(preflight(u))
+	dumpEnvFile(u)
+	if !ok {
+		os.Exit(1)
+	}
+}
+
+// waitForEvent is the daemon before the event: instead of syncing an empty
+// window it shows the countdown and runs the checklist every
+// PREFLIGHT_INTERVAL, notifying (with TAJU_NOTIFY) when an item fails, so
+// a login that lapsed over January is fixed before day one. It returns
+// when the event starts, false if stop closed first.
+func waitForEvent(profiles []*uploader, stop <-chan struct{}) bool {
+	u := profiles[0]
+	for {
+		now, start, _ := goalWindow(u)
+		left := countdown(now, start)
+		if left == "" {
+			return true
+		}
+		log.Print(left)
+		for _, p := range profiles {
+			if p.profile != "" {
+				log.Printf("Profile %s:", p.profile)
+			}
+			for _, check := range preflight(p) {
+				printCheck(check.ok, check.name, check.detail)
+				if !check.ok && envBool(p.env, "TAJU_NOTIFY") {
+					if err := desktopNotify(notification{"Taji Uploader: not ready for the event", check.name + ": " + check.detail}); err != nil {
+						log.Print("Failed to show notification: ", err)
+					}
+				}
+			}
+			dumpEnvFile(p)
+		}
+		select {
+		case <-u.clock.After(min(PREFLIGHT_INTERVAL, start.Sub(now))):
+		case <-stop:
+			return false
+		}
+	}
+}
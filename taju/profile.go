@@ -0,0 +1,124 @@
+package taju
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/joho/godotenv"
+	"github.com/tajuploader/config"
+)
+
+// PROFILE_KEYS are the taju.env keys (and key prefixes) that belong to one
+// person: their Strava tokens and cursors, and their Taji login and session.
+// A profile never inherits them from the shared taju.env.
+var PROFILE_KEYS = []string{"STRAVA_TOKEN", "STRAVA_REFRESH_TOKEN", "STRAVA_CURSOR", "STRAVA_FULL_FETCH", "TAJI_",
+	"TAJU_STRAVA_ACCOUNTS", "TAJU_SYNC_ACCOUNTS"}
+
+// profileNames lists the profiles in TAJU_PROFILES. With profiles, several
+// people share one taju.env (and one machine): each profile has its own
+// Strava accounts, Taji session and ledger, and taju sync runs all of them.
+func profileNames(env map[string]string) []string {
+	return splitList(env["TAJU_PROFILES"])
+}
+
+// profileFile names the file of a profile, e.g. taju.alice.state.json for
+// taju.state.json. Without a profile it is the file itself.
+func profileFile(profile string, name string) string {
+	if profile == "" {
+		return name
+	}
+	return "taju." + profile + "." + strings.TrimPrefix(name, "taju.")
+}
+
+// path returns where u reads and writes one of its runtime files.
+func (u *uploader) path(name string) string {
+	return config.StatePath(u.state_dir, profileFile(u.profile, name))
+}
+
+func isProfileKey(key string) bool {
+	return slices.ContainsFunc(PROFILE_KEYS, func(prefix string) bool { return strings.HasPrefix(key, prefix) })
+}
+
+// loadProfileEnv replaces the personal keys of env with the ones saved in
+// the profile's env file. An unknown profile is an error, a profile that
+// hasn't been set up yet starts out logged out.
+func loadProfileEnv(env map[string]string, state_dir string, profile string) error {
+	if !ACCOUNT_NAME_PATTERN.MatchString(profile) {
+		return fmt.Errorf("invalid profile %q, use lowercase letters and digits", profile)
+	}
+	if !slices.Contains(profileNames(env), profile) {
+		return fmt.Errorf("unknown profile %q, add it to TAJU_PROFILES", profile)
+	}
+	path := config.StatePath(state_dir, profileFile(profile, ENV_FILENAME))
+	saved, err := godotenv.Read(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error loading %s: %w", path, err)
+	}
+	for key, value := range saved {
+		env[key] = value
+	}
+	return nil
+}
+
+// loadProfile loads the configuration of one profile next to base, which
+// is already initialized (logging and the clock are shared, and a headless
+// base stays headless).
+func loadProfile(base *uploader, profile string) *uploader {
+	u := &uploader{profile: profile, clock: base.clock, post_workers: base.post_workers, headless: base.settings.headless}
+	if err := loadEnvFile(u); err != nil {
+		log.Fatal(err)
+	}
+	if err := loadUploader(u); err != nil {
+		log.Fatal(err)
+	}
+	return u
+}
+
+// syncProfiles returns the uploaders taju sync runs: every profile when
+// profiles are configured and none was picked with --profile, the captain
+// and every participant in coach mode, else u.
+func syncProfiles(u *uploader) []*uploader {
+	names := profileNames(u.env)
+	if participants := participantNames(u.env); u.profile == "" && len(participants) > 0 {
+		// In coach mode the captain syncs too, see participants.go.
+		if err := checkParticipants(u.env); err != nil {
+			log.Fatal(err)
+		}
+		profiles := []*uploader{u}
+		for _, name := range participants {
+			profiles = append(profiles, loadProfile(u, name))
+		}
+		return profiles
+	}
+	if u.profile != "" || len(names) == 0 {
+		return []*uploader{u}
+	}
+	var profiles []*uploader
+	for _, name := range names {
+		profiles = append(profiles, loadProfile(u, name))
+	}
+	return profiles
+}
+
+// printProfileCycle is the summary of one profile's cycle when several
+// profiles sync in the same loop, where there's no room for the full screen.
+func printProfileCycle(u *uploader, result cycleResult) {
+	progress := result.progress
+	status := "ok"
+	if result.failed {
+		status = "failed"
+	}
+	fmt.Printf("%-12s %-6s %d activities, %d posted, %.1f of %.0f %s (%.0f%%), %d Taji requests\n", u.name(), status,
+		len(result.activities), len(result.posted), result.units.fromMiles(progress.done),
+		result.units.fromMiles(progress.target), result.units.name(), progress.percent, result.taji_requests)
+	if result.standings != nil {
+		fmt.Printf("%-12s %-6s %s\n", "", "", result.standings.summary(result.units))
+	}
+}
@@ -0,0 +1,182 @@
+package taju
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tajuploader/tajiclient"
+)
+
+// DEFAULT_DISTANCE_STEP is hundredths, what the uploader always posted.
+const DEFAULT_DISTANCE_STEP = 0.01
+
+// distanceStep is the increment the Taji form takes distances in, in
+// taji_units. It is TAJU_DISTANCE_STEP when that is set, else the step of
+// the form's distance input as last seen (kept in the state ledger so
+// matching doesn't depend on having loaded the form). Distances are
+// rounded to it before posting, so the server doesn't reject them, and
+// when comparing, so a rounded entry isn't taken for an edit.
+type distanceStep struct {
+	mu         sync.Mutex
+	value      float64
+	configured bool
+	// down rounds down to the step instead of to the nearest, see
+	// loadRounding.
+	down  bool
+	state *stateStore
+}
+
+func (s *settings) loadDistanceStep(env map[string]string) error {
+	value, ok := env["TAJU_DISTANCE_STEP"]
+	if !ok {
+		return nil
+	}
+	step, err := strconv.ParseFloat(value, 64)
+	if err != nil || step <= 0 {
+		return fmt.Errorf("invalid TAJU_DISTANCE_STEP=%q, expected e.g. 0.01 or 0.1", value)
+	}
+	s.step.value, s.step.configured = step, true
+	return nil
+}
+
+// quantizeDistance rounds a distance to the nearest step, or down to it
+// with TAJU_DISTANCE_ROUNDING=down.
+func (s *settings) quantizeDistance(distance float64) float64 {
+	s.step.mu.Lock()
+	step, down := s.step.value, s.step.down
+	s.step.mu.Unlock()
+	// Round away the float error of the division, e.g. 3.3/0.1 = 32.99999.
+	steps := math.Round(distance/step*1e6) / 1e6
+	if down {
+		return math.Floor(steps) * step
+	}
+	return math.Round(steps) * step
+}
+
+// clockRounding is how createRun rounds the Strava start time and duration
+// before they become form fields, so what is posted, totalled and compared
+// with Taji entries is the same value. The form keeps the start time to
+// the minute; Strava has it to the second.
+type clockRounding struct {
+	// start is the step the start time is rounded to, 0 to drop the
+	// seconds as the form does.
+	start time.Duration
+	// duration is ROUND_EXACT, ROUND_TRUNCATE or ROUND_NEAREST to whole
+	// minutes.
+	duration string
+}
+
+const (
+	ROUND_EXACT    string = "exact"
+	ROUND_TRUNCATE string = "truncate"
+	ROUND_NEAREST  string = "nearest"
+	ROUND_DOWN     string = "down"
+)
+
+// loadRounding reads TAJU_DISTANCE_ROUNDING (nearest or down),
+// TAJU_DURATION_ROUNDING (exact, truncate or nearest minute) and
+// TAJU_START_ROUNDING (truncate, nearest or a step such as 5m).
+func (s *settings) loadRounding(env map[string]string) error {
+	switch value := env["TAJU_DISTANCE_ROUNDING"]; value {
+	case "", ROUND_NEAREST:
+	case ROUND_DOWN:
+		s.step.down = true
+	default:
+		return fmt.Errorf("invalid TAJU_DISTANCE_ROUNDING=%q, expected nearest or down", value)
+	}
+
+	switch value := env["TAJU_DURATION_ROUNDING"]; value {
+	case "", ROUND_EXACT:
+	case ROUND_TRUNCATE, ROUND_NEAREST:
+		s.rounding.duration = value
+	default:
+		return fmt.Errorf("invalid TAJU_DURATION_ROUNDING=%q, expected exact, truncate or nearest", value)
+	}
+
+	switch value := env["TAJU_START_ROUNDING"]; value {
+	case "", ROUND_TRUNCATE:
+	case ROUND_NEAREST:
+		s.rounding.start = time.Minute
+	default:
+		step, err := time.ParseDuration(value)
+		if err != nil || step < time.Minute || step%time.Minute != 0 || step > time.Hour {
+			return fmt.Errorf("invalid TAJU_START_ROUNDING=%q, expected truncate, nearest or whole minutes such as 5m", value)
+		}
+		s.rounding.start = step
+	}
+	return nil
+}
+
+// roundStart rounds a start time per TAJU_START_ROUNDING.
+func (s *settings) roundStart(start time.Time) time.Time {
+	if s.rounding.start == 0 {
+		return start
+	}
+	return start.Round(s.rounding.start)
+}
+
+// roundDuration rounds seconds per TAJU_DURATION_ROUNDING.
+func (s *settings) roundDuration(seconds int64) int64 {
+	switch s.rounding.duration {
+	case ROUND_TRUNCATE:
+		return seconds / 60 * 60
+	case ROUND_NEAREST:
+		return (seconds + 30) / 60 * 60
+	}
+	return seconds
+}
+
+// formatDistance writes a quantized distance with two decimals, or as many
+// as a finer step needs.
+func (s *settings) formatDistance(distance float64) string {
+	s.step.mu.Lock()
+	step := strconv.FormatFloat(s.step.value, 'f', -1, 64)
+	s.step.mu.Unlock()
+	decimals := 2
+	if _, fraction, ok := strings.Cut(step, "."); ok && len(fraction) > decimals {
+		decimals = len(fraction)
+	}
+	return strconv.FormatFloat(s.quantizeDistance(distance), 'f', decimals, 64)
+}
+
+// detectDistanceStep picks up the step of the distance input of a Taji
+// form, unless TAJU_DISTANCE_STEP is set. It reports whether it changed.
+func (s *settings) detectDistanceStep(form []byte) bool {
+	input, ok := tajiclient.FindInput(form, "distance")
+	if !ok {
+		return false
+	}
+	step, err := strconv.ParseFloat(input.Attr("step"), 64)
+	if err != nil || step <= 0 {
+		// No step (or "any") takes any precision.
+		return false
+	}
+	s.step.mu.Lock()
+	defer s.step.mu.Unlock()
+	if s.step.configured || step == s.step.value {
+		return false
+	}
+	log.Printf("The Taji form takes distances in steps of %g %s, rounding to that (set TAJU_DISTANCE_STEP to override)", step, s.taji_units.name())
+	s.step.value = step
+	if s.step.state != nil {
+		s.step.state.setDistanceStep(step)
+	}
+	return true
+}
+
+func (s *stateStore) distanceStep() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.DistanceStep
+}
+
+func (s *stateStore) setDistanceStep(step float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.DistanceStep = step
+}
@@ -0,0 +1,98 @@
+package taju
+
+import "testing"
+
+// withDistanceStep returns settings with the distance step set and the
+// ledger it is kept in.
+func withDistanceStep(step float64, configured bool) (*settings, *stateStore) {
+	s, state := newSettings(), &stateStore{}
+	s.step.value, s.step.configured, s.step.state = step, configured, state
+	return s, state
+}
+
+func TestFormatDistance(t *testing.T) {
+	tests := []struct {
+		step     float64
+		distance float64
+		want     string
+	}{
+		{step: 0.01, distance: 3.14159, want: "3.14"},
+		{step: 0.01, distance: 26.2188, want: "26.22"},
+		{step: 0.01, distance: 0, want: "0.00"},
+		// 3.3/0.1 is 32.99999... in floating point.
+		{step: 0.1, distance: 3.3, want: "3.30"},
+		{step: 0.1, distance: 3.26, want: "3.30"},
+		{step: 0.1, distance: 3.24, want: "3.20"},
+		{step: 0.25, distance: 3.3, want: "3.25"},
+		{step: 0.25, distance: 3.4, want: "3.50"},
+		{step: 0.5, distance: 13.1, want: "13.00"},
+		// A finer step keeps its decimals.
+		{step: 0.001, distance: 3.14159, want: "3.142"},
+	}
+	for _, test := range tests {
+		s, _ := withDistanceStep(test.step, false)
+		if got := s.formatDistance(test.distance); got != test.want {
+			t.Errorf("formatDistance(%g) in steps of %g = %s, want %s", test.distance, test.step, got, test.want)
+		}
+	}
+}
+
+func TestDetectDistanceStep(t *testing.T) {
+	tests := []struct {
+		name       string
+		form       string
+		configured bool
+		changed    bool
+		want       float64
+		// formatted is 3.26 miles as posted after the form was seen.
+		formatted string
+	}{
+		{name: "step of the input", form: `<form><input type="number" name="distance" step="0.1"></form>`, changed: true, want: 0.1, formatted: "3.30"},
+		{name: "same step", form: `<form><input type="number" name="distance" step="0.01"></form>`, want: 0.01, formatted: "3.26"},
+		{name: "any precision", form: `<form><input type="number" name="distance" step="any"></form>`, want: 0.01, formatted: "3.26"},
+		{name: "no step", form: `<form><input type="number" name="distance"></form>`, want: 0.01, formatted: "3.26"},
+		{name: "no distance", form: `<form><input name="duration" step="60"></form>`, want: 0.01, formatted: "3.26"},
+		{name: "TAJU_DISTANCE_STEP wins", form: `<form><input name="distance" step="0.1"></form>`, configured: true, want: 0.01, formatted: "3.26"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, state := withDistanceStep(0.01, test.configured)
+			if changed := s.detectDistanceStep([]byte(test.form)); changed != test.changed {
+				t.Errorf("changed = %t, want %t", changed, test.changed)
+			}
+			if got := s.formatDistance(3.26); got != test.formatted {
+				t.Errorf("formatDistance(3.26) = %s, want %s", got, test.formatted)
+			}
+			// The step is kept in the ledger, so it is known before the
+			// form is next loaded.
+			if test.changed && state.distanceStep() != test.want {
+				t.Errorf("ledger step %g, want %g", state.distanceStep(), test.want)
+			}
+		})
+	}
+}
+
+func TestLoadDistanceStep(t *testing.T) {
+	state := &stateStore{}
+	state.setDistanceStep(0.1)
+	s := newSettings()
+	if err := s.loadDistanceStep(map[string]string{}); err != nil {
+		t.Fatal(err)
+	}
+	s.useState(state, "", nil)
+	if got := s.formatDistance(3.26); got != "3.30" {
+		t.Errorf("with the ledger's step, formatDistance(3.26) = %s, want 3.30", got)
+	}
+
+	s = newSettings()
+	if err := s.loadDistanceStep(map[string]string{"TAJU_DISTANCE_STEP": "0.25"}); err != nil {
+		t.Fatal(err)
+	}
+	s.useState(state, "", nil)
+	if got := s.formatDistance(3.3); got != "3.25" {
+		t.Errorf("with TAJU_DISTANCE_STEP=0.25, formatDistance(3.3) = %s, want 3.25", got)
+	}
+	if s.detectDistanceStep([]byte(`<input name="distance" step="0.1">`)) {
+		t.Error("the form's step replaced TAJU_DISTANCE_STEP")
+	}
+}
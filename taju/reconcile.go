@@ -0,0 +1,207 @@
+package taju
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// reconcileCommand compares the whole event window on Strava with every
+// Taji entry (scraped, not taken from the ledger) and the ledger, reports the
+// drift and optionally fixes it: all drift of a kind with --fix, or one item
+// at a time with --interactive.
+func reconcileCommand(u *uploader, args []string) {
+	flags := flag.NewFlagSet("reconcile", flag.ExitOnError)
+	fix := flags.String("fix", "", "comma separated drift to fix: missing (post), mismatch (edit), orphans (delete)")
+	yes := flags.Bool("yes", false, "apply fixes without asking")
+	interactive_fix := flags.Bool("interactive", false, "go through the drift one item at a time and pick the fix")
+	flags.Parse(args)
+	if *interactive_fix && *fix != "" {
+		log.Fatal("Use either --fix or --interactive")
+	}
+
+	fixes := splitList(*fix)
+	for _, name := range fixes {
+		if !slices.Contains([]string{"missing", "mismatch", "orphans"}, name) {
+			log.Fatal("Unknown --fix value: ", name)
+		}
+	}
+
+	if err := initStravaAccounts(u); err != nil {
+		log.Fatal(err)
+	}
+	if err := initTajiSession(u); err != nil {
+		log.Fatal(err)
+	}
+
+	var activities []runDetails
+	for _, s := range u.accounts {
+		s.cursor = time.Time{}
+		runs, _, err := getStravaActivities(s)
+		if err != nil {
+			log.Fatal(err)
+		}
+		activities = append(activities, runs...)
+	}
+	// Like a sync, a day's activities make one entry with a daily summary.
+	sortRuns(activities)
+	split, err := loadSplitRules(u.env, u.settings)
+	if err != nil {
+		log.Fatal(err)
+	}
+	activities = split.combineDaily(activities)
+	entries, err := getTajiEntries(&u.taji)
+	if err != nil {
+		log.Fatal(err)
+	}
+	events, err := getTajiEvents(&u.taji, entries)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var drift []plannedAction
+	matched := make(map[string]bool)
+	index := indexEvents(events, u.settings)
+	fmt.Println("Strava activities missing on Taji:")
+	for _, run := range activities {
+		event, ok := index.find(run)
+		if !ok {
+			fmt.Printf("  %s %s %s %s mi %s (strava %d)\n", run.activity, run.date, run.time, run.distance, run.duration, run.strava_id)
+			drift = append(drift, plannedAction{kind: ACTION_POST, run: run, reason: "missing"})
+			continue
+		}
+		matched[event.entry] = true
+		u.state.link(run, event)
+	}
+
+	fmt.Println("Taji entries that differ from Strava:")
+	for _, run := range activities {
+		event, ok := index.find(run)
+		if !ok {
+			continue
+		}
+		if class, conflict := u.settings.classifyMatch(run, event); conflict {
+			fmt.Printf("  entry %s %s %s: %s mi %s on Taji, %s mi %s on Strava (%s)\n",
+				event.entry, event.date, event.time, event.distance, event.duration, run.distance, run.duration, class)
+			drift = append(drift, plannedAction{kind: ACTION_UPDATE, run: run, event: event, reason: string(class)})
+		}
+	}
+
+	fmt.Println("Taji entries without a Strava activity:")
+	for _, event := range events {
+		if matched[event.entry] {
+			continue
+		}
+		if u.state.isManual(event.entry) {
+			fmt.Printf("  entry %s %s %s %s mi %s (logged with taju add, kept)\n", event.entry, event.date, event.time, event.distance, event.duration)
+			continue
+		}
+		fmt.Printf("  entry %s %s %s %s mi %s\n", event.entry, event.date, event.time, event.distance, event.duration)
+		drift = append(drift, plannedAction{kind: ACTION_DELETE, event: event, reason: "orphan"})
+	}
+
+	fmt.Println("Ledger entries without a Taji entry:")
+	on_page := make(map[string]bool)
+	for _, entry := range entries {
+		on_page[entry] = true
+	}
+	for id, entry := range u.state.Entries {
+		if entry.LogId == "" || !on_page[entry.LogId] {
+			fmt.Printf("  strava %d %s %s (%s)\n", id, entry.Date, entry.Time, entry.Status)
+			u.state.forget(id)
+		}
+	}
+	for key, entry := range u.state.Parts {
+		if entry.LogId == "" || !on_page[entry.LogId] {
+			fmt.Printf("  strava %d part %d %s %s (%s)\n", entry.StravaId, entry.Part, entry.Date, entry.Time, entry.Status)
+			u.state.forgetPart(key)
+		}
+	}
+
+	var plan []plannedAction
+	if *interactive_fix {
+		plan = pickFixes(u, drift)
+	} else {
+		for _, action := range drift {
+			if slices.Contains(fixes, RECONCILE_FIXES[action.kind]) {
+				plan = append(plan, action)
+			}
+		}
+	}
+	if len(plan) > 0 {
+		printPlan(os.Stdout, plan)
+		if *yes || *interactive_fix || confirm(fmt.Sprintf("Apply these %d fixes to Taji?", len(plan))) {
+			s, err := newSyncer(u)
+			if err != nil {
+				log.Fatal(err)
+			}
+			var failed atomic.Bool
+			s.execute(plan, &failed)
+			if failed.Load() {
+				defer os.Exit(1)
+			}
+		}
+	}
+	if err := u.state.save(); err != nil {
+		log.Print("Error:", err)
+	}
+}
+
+// RECONCILE_FIXES are the --fix names of the drift each action fixes.
+var RECONCILE_FIXES = map[string]string{ACTION_POST: "missing", ACTION_UPDATE: "mismatch", ACTION_DELETE: "orphans"}
+
+// pickFixes asks what to do about each item of drift: apply its fix, keep
+// a Taji entry without a Strava activity as logged by hand (as if it were
+// added with taju add, so syncs leave it alone), or leave it for now.
+func pickFixes(u *uploader, drift []plannedAction) (plan []plannedAction) {
+	if len(drift) == 0 {
+		return nil
+	}
+	if !u.settings.interactive() {
+		log.Fatal("reconcile --interactive needs a terminal, use --fix")
+	}
+	reader := bufio.NewReader(os.Stdin)
+	for i, action := range drift {
+		run, event := action.run, action.event
+		fmt.Printf("\n(%d/%d) ", i+1, len(drift))
+		var prompt string
+		switch action.kind {
+		case ACTION_POST:
+			fmt.Printf("%s on %s at %s is missing on Taji\n", run.activity, run.date, run.time)
+			fmt.Printf("  Strava %-12d %s mi  %s\n", run.strava_id, run.distance, run.duration)
+			prompt = "[p]ost it, [i]gnore, [q]uit: "
+		case ACTION_UPDATE:
+			fmt.Printf("%s on %s differs between Strava and Taji (%s)\n", run.activity, run.date, action.reason)
+			fmt.Printf("  Strava %-12d at %s  %s mi  %s\n", run.strava_id, run.time, run.distance, run.duration)
+			fmt.Printf("  Taji   entry %-6s at %s  %s mi  %s\n", event.entry, event.time, event.distance, event.duration)
+			prompt = "[e]dit Taji to match Strava, [i]gnore, [q]uit: "
+		case ACTION_DELETE:
+			fmt.Printf("Taji entry %s on %s at %s has no Strava activity\n", event.entry, event.date, event.time)
+			fmt.Printf("  %s mi  %s\n", event.distance, event.duration)
+			prompt = "[d]elete it, [k]eep it as logged by hand, [i]gnore, [q]uit: "
+		}
+		fmt.Print(prompt)
+		answer, err := reader.ReadString('\n')
+		if err != nil {
+			break
+		}
+		switch answer = strings.ToLower(strings.TrimSpace(answer)); {
+		case answer == "q" || answer == "quit":
+			return
+		case action.kind == ACTION_POST && (answer == "p" || answer == "post"),
+			action.kind == ACTION_UPDATE && (answer == "e" || answer == "edit"),
+			action.kind == ACTION_DELETE && (answer == "d" || answer == "delete"):
+			plan = append(plan, action)
+		case action.kind == ACTION_DELETE && (answer == "k" || answer == "keep"):
+			u.state.recordManual(event)
+			fmt.Printf("Keeping entry %s as logged by hand\n", event.entry)
+		}
+	}
+	return
+}
@@ -0,0 +1,68 @@
+package taju
+
+import (
+	"io"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Patterns for secrets that show up in URLs, form bodies, cookies and JSON.
+var REDACT_PATTERNS = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)(csrfmiddlewaretoken|csrftoken|sessionid|password|access_token|refresh_token|client_secret)(["']?\s*[:=]\s*["']?)([^"'&;\s,}]+)`),
+	regexp.MustCompile(`(?i)(Bearer\s+)([A-Za-z0-9._~+/=-]+)`),
+	regexp.MustCompile(`([?&]code=)([^&\s]+)`),
+}
+
+const REDACTED string = "[REDACTED]"
+
+type redactor struct {
+	mu      sync.Mutex
+	secrets []string
+	out     io.Writer
+}
+
+var logRedactor = &redactor{}
+
+// initLogging routes the standard logger through the redactor so tokens,
+// session ids and passwords never reach the log output.
+func initLogging() {
+	logRedactor.out = log.Writer()
+	log.SetOutput(logRedactor)
+}
+
+// addRedaction registers a literal secret value to be scrubbed from logs.
+func addRedaction(secret string) {
+	if len(secret) < 4 {
+		return
+	}
+	logRedactor.mu.Lock()
+	defer logRedactor.mu.Unlock()
+	logRedactor.secrets = append(logRedactor.secrets, secret)
+}
+
+func redact(text string) string {
+	logRedactor.mu.Lock()
+	secrets := logRedactor.secrets
+	logRedactor.mu.Unlock()
+
+	for _, secret := range secrets {
+		text = strings.ReplaceAll(text, secret, REDACTED)
+	}
+	for _, pattern := range REDACT_PATTERNS {
+		if pattern.NumSubexp() == 3 {
+			text = pattern.ReplaceAllString(text, "${1}${2}"+REDACTED)
+		} else {
+			text = pattern.ReplaceAllString(text, "${1}"+REDACTED)
+		}
+	}
+	return text
+}
+
+func (r *redactor) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(r.out, redact(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,551 @@
+package taju
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/joho/godotenv"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	STORAGE_WEBDAV string = "webdav"
+	STORAGE_S3     string = "s3"
+
+	REMOTE_TIMEOUT = 30 * time.Second
+
+	// REMOTE_MAGIC starts the remote files encrypted under a salted key,
+	// followed by the REMOTE_SALT bytes of salt, see remoteKey.
+	REMOTE_MAGIC string = "taju-scrypt1\n"
+	REMOTE_SALT  int    = 16
+	// scrypt cost of the remote key.
+	REMOTE_SCRYPT_N int = 1 << 15
+)
+
+var errRemoteConflict = errors.New("changed by another machine")
+
+// blobStore is a remote directory of files, see remoteStorage.
+type blobStore interface {
+	// get returns a file and its ETag, os.ErrNotExist if there is none.
+	get(name string) ([]byte, string, error)
+	// put replaces a file if it still has the given ETag ("" if it must not
+	// exist yet), returning the new ETag or errRemoteConflict.
+	put(name string, data []byte, etag string) (string, error)
+}
+
+// remoteStorage keeps the ledger on a WebDAV server or in an S3 bucket
+// (TAJU_STORAGE=webdav or s3), for people syncing from more than one
+// machine. Saves are conditional on the version that was loaded; when
+// another machine saved in between, the activities only it knows are merged
+// in and the rest of the ledger saved here wins. Even a lost ledger entry
+// isn't posted twice: posts are found again on Taji by their idempotency
+// key.
+//
+// With TAJU_REMOTE_KEY the tokens and sessions taju saves are kept there
+// too, and everything is encrypted with that key first. Without it only
+// the ledger is shared, unencrypted, and each machine logs in on its own.
+type remoteStorage struct {
+	blobs blobStore
+	name  string
+	key   *remoteKey
+	etag  string
+	// loaded are the shared values as read, see saveRemoteEnv.
+	loaded map[string]string
+}
+
+// remoteKey encrypts the remote files under a key derived from
+// TAJU_REMOTE_KEY with scrypt. Every file carries its own random salt, so
+// the same TAJU_REMOTE_KEY opens what any machine saved while a leaked file
+// can't be checked against a precomputed table. Files saved before the salt
+// open with the SHA-256 of TAJU_REMOTE_KEY and are salted when next saved.
+type remoteKey struct {
+	mu     sync.Mutex
+	secret string
+	// salt is the one this process seals with, keys the derived key per
+	// salt, so the slow derivation runs once per file.
+	salt []byte
+	keys map[string][]byte
+}
+
+func (k *remoteKey) derive(salt []byte) ([]byte, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if key, ok := k.keys[string(salt)]; ok {
+		return key, nil
+	}
+	key, err := scrypt.Key([]byte(k.secret), salt, REMOTE_SCRYPT_N, 8, 1, 32)
+	if err != nil {
+		return nil, err
+	}
+	if k.keys == nil {
+		k.keys = make(map[string][]byte)
+	}
+	k.keys[string(salt)] = key
+	return key, nil
+}
+
+func (k *remoteKey) seal(data []byte) ([]byte, error) {
+	k.mu.Lock()
+	if k.salt == nil {
+		k.salt = make([]byte, REMOTE_SALT)
+		if _, err := rand.Read(k.salt); err != nil {
+			k.mu.Unlock()
+			return nil, err
+		}
+	}
+	salt := k.salt
+	k.mu.Unlock()
+	key, err := k.derive(salt)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := sealBytes(key, data)
+	if err != nil {
+		return nil, err
+	}
+	return slices.Concat([]byte(REMOTE_MAGIC), salt, sealed), nil
+}
+
+func (k *remoteKey) open(data []byte) ([]byte, error) {
+	rest, salted := bytes.CutPrefix(data, []byte(REMOTE_MAGIC))
+	if !salted {
+		legacy := sha256.Sum256([]byte(k.secret))
+		return openBytes(legacy[:], data)
+	}
+	if len(rest) < REMOTE_SALT {
+		return nil, errors.New("encrypted file is too short")
+	}
+	key, err := k.derive(rest[:REMOTE_SALT])
+	if err != nil {
+		return nil, err
+	}
+	return openBytes(key, rest[REMOTE_SALT:])
+}
+
+func openRemote(env map[string]string) (blobStore, *remoteKey, error) {
+	endpoint := strings.TrimRight(env["TAJU_REMOTE_URL"], "/")
+	if endpoint == "" {
+		return nil, nil, fmt.Errorf("TAJU_STORAGE=%s needs TAJU_REMOTE_URL", env["TAJU_STORAGE"])
+	}
+	var key *remoteKey
+	if value := env["TAJU_REMOTE_KEY"]; value != "" {
+		key = &remoteKey{secret: value}
+	}
+	client := &http.Client{Timeout: REMOTE_TIMEOUT}
+	if env["TAJU_STORAGE"] == STORAGE_S3 {
+		bucket := env["TAJU_S3_BUCKET"]
+		if bucket == "" {
+			return nil, nil, errors.New("TAJU_STORAGE=s3 needs TAJU_S3_BUCKET")
+		}
+		region := env["TAJU_S3_REGION"]
+		if region == "" {
+			region = "us-east-1"
+		}
+		return &s3Store{client: client, endpoint: endpoint, bucket: bucket, region: region,
+			access_key: env["TAJU_REMOTE_USERNAME"], secret_key: env["TAJU_REMOTE_PASSWORD"], clock: realClock{}}, key, nil
+	}
+	return &webdavStore{client: client, base: endpoint, username: env["TAJU_REMOTE_USERNAME"], password: env["TAJU_REMOTE_PASSWORD"]}, key, nil
+}
+
+func (r *remoteStorage) String() string {
+	return r.name + " on the remote store"
+}
+
+func (r *remoteStorage) load(state *stateStore) error {
+	data, etag, err := r.blobs.get(r.name)
+	if errors.Is(err, os.ErrNotExist) {
+		r.etag = ""
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	r.etag = etag
+	if data, err = r.open(data); err != nil {
+		return err
+	}
+	return json.Unmarshal(data, state)
+}
+
+func (r *remoteStorage) save(state *stateStore) error {
+	for attempt := 0; ; attempt++ {
+		state.mu.Lock()
+		data, err := json.MarshalIndent(state, "", "  ")
+		state.mu.Unlock()
+		if err != nil {
+			return fmt.Errorf("encoding the state: %w", err)
+		}
+		if data, err = r.seal(data); err != nil {
+			return err
+		}
+		etag, err := r.blobs.put(r.name, data, r.etag)
+		if errors.Is(err, errRemoteConflict) && attempt < 2 {
+			log.Printf("Warning: %s was saved by another machine since it was loaded, merging", r.name)
+			if err := r.merge(state); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		r.etag = etag
+		return nil
+	}
+}
+
+// merge adds what only the saved ledger knows to state: the entries of
+// every map state doesn't have, and of the ledger entries the ones saved
+// later. The rest of the ledger saved here wins. Queued runs the merged
+// ledger has as uploaded leave the outbox.
+func (r *remoteStorage) merge(state *stateStore) error {
+	saved := &stateStore{}
+	if err := r.load(saved); err != nil {
+		return err
+	}
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	mergeNewer(&state.Entries, saved.Entries)
+	mergeNewer(&state.Parts, saved.Parts)
+	mergeNewer(&state.Manual, saved.Manual)
+	mergeNewer(&state.TajiOnly, saved.TajiOnly)
+	mergeMissing(&state.Templates, saved.Templates)
+	mergeMissing(&state.Pending, saved.Pending)
+	mergeMissing(&state.Review, saved.Review)
+	mergeMissing(&state.Scraped, saved.Scraped)
+	mergeMissing(&state.Outbox, saved.Outbox)
+	mergeMissing(&state.Forms, saved.Forms)
+	for key, queued := range state.Outbox {
+		if entry := state.entry(runDetails{strava_id: queued.StravaId, part: queued.Part}, false); entry != nil && entry.Status == STATE_UPLOADED {
+			delete(state.Outbox, key)
+		}
+	}
+	if saved.PausedUntil.After(state.PausedUntil) {
+		state.PausedUntil = saved.PausedUntil
+	}
+	return nil
+}
+
+// mergeMissing adds the values of from whose key into doesn't have.
+func mergeMissing[K comparable, V any](into *map[K]V, from map[K]V) {
+	for key, value := range from {
+		if _, ok := (*into)[key]; ok {
+			continue
+		}
+		if *into == nil {
+			*into = make(map[K]V)
+		}
+		(*into)[key] = value
+	}
+}
+
+// mergeNewer is mergeMissing for ledger entries, also taking the ones from
+// updated since.
+func mergeNewer[K comparable](into *map[K]*ledgerEntry, from map[K]*ledgerEntry) {
+	for key, entry := range from {
+		if mine, ok := (*into)[key]; ok && !entry.UpdatedAt.After(mine.UpdatedAt) {
+			continue
+		}
+		if *into == nil {
+			*into = make(map[K]*ledgerEntry)
+		}
+		(*into)[key] = entry
+	}
+}
+
+func (r *remoteStorage) seal(data []byte) ([]byte, error) {
+	if r.key == nil {
+		return data, nil
+	}
+	return r.key.seal(data)
+}
+
+func (r *remoteStorage) open(data []byte) ([]byte, error) {
+	if r.key == nil {
+		return data, nil
+	}
+	data, err := r.key.open(data)
+	if err != nil {
+		return nil, fmt.Errorf("can't decrypt %s, check TAJU_REMOTE_KEY: %w", r.name, err)
+	}
+	return data, nil
+}
+
+// remoteEnv returns where the tokens and sessions of u are shared, if they
+// are: a remote store with TAJU_REMOTE_KEY set. It is opened once, so a
+// save is conditional on the version loadRemoteEnv read.
+func remoteEnv(u *uploader) (*remoteStorage, bool) {
+	backend := u.env["TAJU_STORAGE"]
+	if (backend != STORAGE_WEBDAV && backend != STORAGE_S3) || u.env["TAJU_REMOTE_KEY"] == "" {
+		return nil, false
+	}
+	if u.remote_env == nil {
+		blobs, key, err := openRemote(u.env)
+		if err != nil {
+			// openStorage reports what is missing.
+			return nil, false
+		}
+		u.remote_env = &remoteStorage{blobs: blobs, key: key, name: profileFile(u.profile, ENV_FILENAME)}
+	}
+	return u.remote_env, true
+}
+
+// readEnv reads the shared values and notes their version. A file that
+// can't be opened still has its version noted, so the next save replaces
+// it.
+func (r *remoteStorage) readEnv() (map[string]string, error) {
+	data, etag, err := r.blobs.get(r.name)
+	if errors.Is(err, os.ErrNotExist) {
+		r.etag, r.loaded = "", nil
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	r.etag = etag
+	if data, err = r.open(data); err != nil {
+		return nil, err
+	}
+	saved, err := godotenv.UnmarshalBytes(data)
+	if err != nil {
+		return nil, err
+	}
+	r.loaded = saved
+	return saved, nil
+}
+
+// loadRemoteEnv reads the tokens and sessions another machine saved over
+// the local ones.
+func loadRemoteEnv(u *uploader) {
+	r, ok := remoteEnv(u)
+	if !ok {
+		return
+	}
+	saved, err := r.readEnv()
+	if err != nil {
+		log.Print("Ignoring the remote tokens: ", err)
+		return
+	}
+	for key, value := range saved {
+		u.env[key] = value
+		addRedaction(value)
+	}
+}
+
+// saveRemoteEnv shares the tokens, sessions and cursors of u. Like the
+// ledger the save is conditional on the version that was read; when another
+// machine saved in between, its values are taken for the keys this one
+// didn't change since, and the save is tried again.
+func saveRemoteEnv(u *uploader) {
+	r, ok := remoteEnv(u)
+	if !ok {
+		return
+	}
+	shared := u.layers.Persisted(u.env)
+	maps.DeleteFunc(shared, func(key string, value string) bool { return !isProfileKey(key) })
+	for attempt := 0; ; attempt++ {
+		text, err := godotenv.Marshal(shared)
+		var data []byte
+		if err == nil {
+			data, err = r.seal([]byte(text))
+		}
+		var etag string
+		if err == nil {
+			etag, err = r.blobs.put(r.name, data, r.etag)
+		}
+		if errors.Is(err, errRemoteConflict) && attempt < 2 {
+			log.Printf("Warning: %s was saved by another machine since it was read, merging", r.name)
+			if err = mergeRemoteEnv(u, r, shared); err == nil {
+				continue
+			}
+		}
+		if err != nil {
+			log.Print("Failed to save the tokens to the remote store: ", err)
+			return
+		}
+		r.etag, r.loaded = etag, shared
+		return
+	}
+}
+
+// mergeRemoteEnv takes the values another machine saved into shared, and
+// into u, for the keys that are as this machine last read them.
+func mergeRemoteEnv(u *uploader, r *remoteStorage, shared map[string]string) error {
+	read := r.loaded
+	saved, err := r.readEnv()
+	if err != nil {
+		return err
+	}
+	for key, value := range saved {
+		if mine, ok := shared[key]; ok && mine != read[key] {
+			continue
+		}
+		shared[key] = value
+		u.env[key] = value
+		addRedaction(value)
+	}
+	return nil
+}
+
+// webdavStore is a WebDAV collection, e.g. a Nextcloud folder.
+type webdavStore struct {
+	client   *http.Client
+	base     string
+	username string
+	password string
+}
+
+func (w *webdavStore) request(method string, name string, body []byte) (*http.Request, error) {
+	req, err := http.NewRequest(method, w.base+"/"+url.PathEscape(name), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if w.username != "" {
+		req.SetBasicAuth(w.username, w.password)
+	}
+	return req, nil
+}
+
+func (w *webdavStore) get(name string) ([]byte, string, error) {
+	req, err := w.request("GET", name, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	return getBlob(w.client, req, name)
+}
+
+func (w *webdavStore) put(name string, data []byte, etag string) (string, error) {
+	req, err := w.request("PUT", name, data)
+	if err != nil {
+		return "", err
+	}
+	return putBlob(w.client, req, name, etag)
+}
+
+// s3Store is a bucket of an S3-compatible service (AWS, MinIO, Backblaze,
+// ...), addressed path-style and signed with AWS Signature Version 4. The
+// service rejects signatures dated more than a few minutes off, so clock
+// is the real one even under TAJU_FAKE_NOW.
+type s3Store struct {
+	client     *http.Client
+	endpoint   string
+	bucket     string
+	region     string
+	access_key string
+	secret_key string
+	clock      clock
+}
+
+func (s *s3Store) request(method string, name string, body []byte) (*http.Request, error) {
+	req, err := http.NewRequest(method, s.endpoint+"/"+url.PathEscape(s.bucket)+"/"+url.PathEscape(name), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	s.sign(req, body, s.clock.Now().UTC())
+	return req, nil
+}
+
+func (s *s3Store) sign(req *http.Request, body []byte, now time.Time) {
+	amz_date := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	payload := sha256Hex(body)
+	req.Header.Set("x-amz-date", amz_date)
+	req.Header.Set("x-amz-content-sha256", payload)
+
+	const signed_headers = "host;x-amz-content-sha256;x-amz-date"
+	canonical := strings.Join([]string{req.Method, req.URL.EscapedPath(), req.URL.RawQuery,
+		"host:" + req.URL.Host, "x-amz-content-sha256:" + payload, "x-amz-date:" + amz_date, "",
+		signed_headers, payload}, "\n")
+	scope := day + "/" + s.region + "/s3/aws4_request"
+	to_sign := strings.Join([]string{"AWS4-HMAC-SHA256", amz_date, scope, sha256Hex([]byte(canonical))}, "\n")
+
+	key := []byte("AWS4" + s.secret_key)
+	for _, part := range []string{day, s.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.access_key, scope, signed_headers, hex.EncodeToString(hmacSHA256(key, to_sign))))
+}
+
+func (s *s3Store) get(name string) ([]byte, string, error) {
+	req, err := s.request("GET", name, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	return getBlob(s.client, req, name)
+}
+
+func (s *s3Store) put(name string, data []byte, etag string) (string, error) {
+	req, err := s.request("PUT", name, data)
+	if err != nil {
+		return "", err
+	}
+	return putBlob(s.client, req, name, etag)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func getBlob(client *http.Client, req *http.Request, name string) ([]byte, string, error) {
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return nil, "", os.ErrNotExist
+	}
+	if err := checkStatus(res, "reading "+name); err != nil {
+		return nil, "", err
+	}
+	data, err := io.ReadAll(res.Body)
+	return data, res.Header.Get("ETag"), err
+}
+
+// putBlob sends a conditional PUT: If-Match the ETag that was read, or
+// If-None-Match for a file that didn't exist. "*" writes unconditionally.
+func putBlob(client *http.Client, req *http.Request, name string, etag string) (string, error) {
+	switch etag {
+	case "*":
+	case "":
+		req.Header.Set("If-None-Match", "*")
+	default:
+		req.Header.Set("If-Match", etag)
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusPreconditionFailed {
+		return "", errRemoteConflict
+	}
+	if err := checkStatus(res, "saving "+name); err != nil {
+		return "", err
+	}
+	return res.Header.Get("ETag"), nil
+}
@@ -0,0 +1,174 @@
+package taju
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestS3Sign(t *testing.T) {
+	c := newFakeClock(TEST_NOW)
+	s := &s3Store{endpoint: "https://s3.example.com", bucket: "taju", region: "eu-west-1", access_key: "AKID", secret_key: "secret", clock: c}
+	sign := func() *http.Request {
+		req, err := s.request("PUT", "state.json", []byte("{}"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return req
+	}
+	first, again := sign(), sign()
+	if got := first.Header.Get("x-amz-date"); got != "20260220T120000Z" {
+		t.Errorf("x-amz-date = %q, want the clock's time", got)
+	}
+	if !strings.HasPrefix(first.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/20260220/eu-west-1/s3/aws4_request, ") {
+		t.Errorf("Authorization = %q", first.Header.Get("Authorization"))
+	}
+	if first.Header.Get("Authorization") != again.Header.Get("Authorization") {
+		t.Error("the same request at the same time was signed differently")
+	}
+	c.Advance(time.Second)
+	if sign().Header.Get("Authorization") == first.Header.Get("Authorization") {
+		t.Error("the signature doesn't depend on the time")
+	}
+}
+
+// memoryBlobs is a remote store in memory, with a new ETag on every write.
+type memoryBlobs struct {
+	files   map[string][]byte
+	etags   map[string]string
+	version int
+}
+
+func (m *memoryBlobs) get(name string) ([]byte, string, error) {
+	data, ok := m.files[name]
+	if !ok {
+		return nil, "", os.ErrNotExist
+	}
+	return data, m.etags[name], nil
+}
+
+func (m *memoryBlobs) put(name string, data []byte, etag string) (string, error) {
+	if etag != "*" && etag != m.etags[name] {
+		return "", errRemoteConflict
+	}
+	if m.files == nil {
+		m.files, m.etags = make(map[string][]byte), make(map[string]string)
+	}
+	m.version++
+	m.files[name], m.etags[name] = data, fmt.Sprint(m.version)
+	return m.etags[name], nil
+}
+
+func TestRemoteKey(t *testing.T) {
+	plain := []byte(`{"entries":{}}`)
+	first, err := (&remoteKey{secret: "correct horse"}).seal(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := (&remoteKey{secret: "correct horse"}).seal(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	salt := func(sealed []byte) []byte { return sealed[len(REMOTE_MAGIC) : len(REMOTE_MAGIC)+REMOTE_SALT] }
+	if !bytes.HasPrefix(first, []byte(REMOTE_MAGIC)) || bytes.Equal(salt(first), salt(second)) {
+		t.Errorf("two machines sealed with the salts %x and %x", salt(first), salt(second))
+	}
+	for _, sealed := range [][]byte{first, second} {
+		if got, err := (&remoteKey{secret: "correct horse"}).open(sealed); err != nil || !bytes.Equal(got, plain) {
+			t.Errorf("open() = %q, %v", got, err)
+		}
+	}
+	if _, err := (&remoteKey{secret: "wrong"}).open(first); err == nil {
+		t.Error("opened with the wrong TAJU_REMOTE_KEY")
+	}
+
+	// Files from before the salt still open.
+	legacy_key := sha256.Sum256([]byte("correct horse"))
+	legacy, err := sealBytes(legacy_key[:], plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := (&remoteKey{secret: "correct horse"}).open(legacy); err != nil || !bytes.Equal(got, plain) {
+		t.Errorf("open() of an unsalted file = %q, %v", got, err)
+	}
+}
+
+func TestRemoteMerge(t *testing.T) {
+	blobs := &memoryBlobs{}
+	key := &remoteKey{secret: "correct horse"}
+	machine := func() (*remoteStorage, *stateStore) {
+		r := &remoteStorage{blobs: blobs, key: key, name: STATE_FILENAME}
+		state := &stateStore{clock: newFakeClock(TEST_NOW), Entries: make(map[int64]*ledgerEntry)}
+		if err := r.load(state); err != nil {
+			t.Fatal(err)
+		}
+		return r, state
+	}
+	first, first_state := machine()
+	second, second_state := machine()
+
+	// The first machine posts run 1 and holds run 3 for review; the second
+	// posts run 2, queues run 4 and resolves run 5, with an older copy of
+	// run 1 still queued.
+	first_state.Entries[1] = &ledgerEntry{StravaId: 1, LogId: "101", Status: STATE_UPLOADED, UpdatedAt: TEST_NOW}
+	first_state.Review = map[int64]*reviewItem{3: {}}
+	if err := first.save(first_state); err != nil {
+		t.Fatal(err)
+	}
+	second_state.Entries[2] = &ledgerEntry{StravaId: 2, LogId: "102", Status: STATE_UPLOADED, UpdatedAt: TEST_NOW}
+	second_state.Entries[1] = &ledgerEntry{StravaId: 1, Status: STATE_FAILED, UpdatedAt: TEST_NOW.Add(-time.Hour)}
+	second_state.Outbox = map[string]*queuedUpload{partKey(1, 0): {StravaId: 1}, partKey(4, 0): {StravaId: 4}}
+	second_state.Pending = map[int64]*pendingMatch{5: {}}
+	if err := second.save(second_state); err != nil {
+		t.Fatal(err)
+	}
+
+	_, merged := machine()
+	if len(merged.Entries) != 2 || merged.Entries[1].LogId != "101" {
+		t.Errorf("merged entries %v, want both runs with run 1 as posted", merged.Entries)
+	}
+	if merged.Review[3] == nil || merged.Pending[5] == nil {
+		t.Errorf("merged review %v and pending %v, want what each machine held", merged.Review, merged.Pending)
+	}
+	if _, ok := merged.Outbox[partKey(1, 0)]; ok || merged.Outbox[partKey(4, 0)] == nil {
+		t.Errorf("merged outbox %v, want run 4 queued and the posted run 1 not", merged.Outbox)
+	}
+}
+
+func TestRemoteEnvConflict(t *testing.T) {
+	blobs := &memoryBlobs{}
+	key := &remoteKey{secret: "correct horse"}
+	machine := func() *uploader {
+		u := &uploader{env: map[string]string{"TAJU_STORAGE": STORAGE_WEBDAV, "TAJU_REMOTE_KEY": "correct horse"}}
+		u.remote_env = &remoteStorage{blobs: blobs, key: key, name: ENV_FILENAME}
+		loadRemoteEnv(u)
+		return u
+	}
+	first := machine()
+	first.env["STRAVA_TOKEN"], first.env["TAJI_SESSION"] = "token 1", "session 1"
+	saveRemoteEnv(first)
+
+	// Both read the same version, then each refreshes one value.
+	first, second := machine(), machine()
+	first.env["STRAVA_TOKEN"] = "token 2"
+	saveRemoteEnv(first)
+	second.env["TAJI_SESSION"] = "session 2"
+	saveRemoteEnv(second)
+
+	if second.env["STRAVA_TOKEN"] != "token 2" {
+		t.Errorf("STRAVA_TOKEN = %q after the merge, want the other machine's", second.env["STRAVA_TOKEN"])
+	}
+	got := machine().env
+	if got["STRAVA_TOKEN"] != "token 2" || got["TAJI_SESSION"] != "session 2" {
+		t.Errorf("remote has %q and %q, want both refreshed values", got["STRAVA_TOKEN"], got["TAJI_SESSION"])
+	}
+	shared, err := key.open(blobs.files[ENV_FILENAME])
+	if err != nil || bytes.Contains(shared, []byte("TAJU_REMOTE_KEY")) {
+		t.Errorf("remote has %q (%v), want only the profile keys", shared, err)
+	}
+}
@@ -0,0 +1,214 @@
+package taju
+
+import (
+	"flag"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// progressReport sums up a week or month of the event from the ledger, for
+// posting to the team chat. Nothing is read from Strava or Taji.
+type progressReport struct {
+	title   string
+	from    time.Time
+	to      time.Time
+	count   int
+	miles   float64
+	seconds int64
+	// pace is the average over the activities with a distance, see
+	// formatPace.
+	pace    string
+	longest runDetails
+	totals  []activityTotal
+	// points_unit names the points of TAJU_POINTS, "" without scoring.
+	points_unit string
+	// days counts the days of the period so far within the event, missed
+	// those without an activity.
+	days     int
+	missed   []string
+	progress goalStatus
+	// units are the display units, the pace is in the taji units.
+	units units
+}
+
+// reportPeriod is the week (from Monday) or month around now, or the one
+// before with last.
+func reportPeriod(period string, now time.Time, last bool) (from time.Time, to time.Time) {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	if period == "month" {
+		from = today.AddDate(0, 0, 1-today.Day())
+		if last {
+			from = from.AddDate(0, -1, 0)
+		}
+		return from, from.AddDate(0, 1, 0)
+	}
+	from = today.AddDate(0, 0, -(int(today.Weekday())+6)%7)
+	if last {
+		from = from.AddDate(0, 0, -7)
+	}
+	return from, from.AddDate(0, 0, 7)
+}
+
+func buildReport(u *uploader, period string, last bool) progressReport {
+	now, start, end := goalWindow(u)
+	from, to := reportPeriod(period, now, last)
+	report := progressReport{from: from, to: to}
+	if period == "month" {
+		report.title = "Taji100 " + from.Format("January 2006")
+	} else {
+		report.title = fmt.Sprintf("Taji100 week of %s", from.Format("Jan 2"))
+	}
+
+	runs := ledgerRuns(u.settings, u.state.exported(), from, to)
+	days := make(map[string]bool)
+	var paced_seconds int64
+	var paced_meters float64
+	for _, run := range runs {
+		days[run.date] = true
+		report.miles += meter2mile(run.distance_float)
+		report.seconds += run.duration_int
+		if run.distance_float > 0 {
+			paced_seconds += run.duration_int
+			paced_meters += run.distance_float
+		}
+		if run.distance_float > report.longest.distance_float {
+			report.longest = run
+		}
+	}
+	report.count = len(runs)
+	report.pace = formatPace(paced_seconds, paced_meters, u.settings.taji_units)
+	report.units = u.settings.display
+	report.totals = activityTotals(runs, u.scoring)
+	if u.scoring != nil {
+		report.points_unit = u.scoring.Unit
+	}
+	for date := from; date.Before(to) && date.Before(end) && !date.After(now); date = date.AddDate(0, 0, 1) {
+		if date.Before(start) {
+			continue
+		}
+		report.days++
+		if !days[date.Format(DATE_FORMAT)] {
+			report.missed = append(report.missed, date.Format("Mon Jan 2"))
+		}
+	}
+	report.progress = u.goal.status(ledgerRuns(u.settings, u.state.exported(), start, end), now, start, end)
+	return report
+}
+
+// lines are the report's points, formatted the same for every output.
+func (r progressReport) lines() (lines []string) {
+	lines = append(lines, fmt.Sprintf("%d activities, %.2f %s in %s", r.count, r.units.fromMiles(r.miles),
+		r.units.name(), hoursMinutes(time.Duration(r.seconds)*time.Second)))
+	for _, total := range r.totals {
+		line := fmt.Sprintf("%s: %d, %.2f %s", total.activity, total.count, r.units.fromMiles(total.miles), r.units.name())
+		if r.points_unit != "" {
+			line += fmt.Sprintf(", %.1f %s", total.points, r.points_unit)
+		}
+		lines = append(lines, line)
+	}
+	if r.longest.distance_float > 0 {
+		lines = append(lines, fmt.Sprintf("Longest: %.2f %s (%s) on %s", r.units.fromMeters(r.longest.distance_float),
+			r.units.name(), r.longest.activity, r.longest.date))
+	}
+	if r.pace != "" {
+		lines = append(lines, "Average pace: "+r.pace)
+	}
+	switch {
+	case r.days == 0:
+	case len(r.missed) == 0:
+		lines = append(lines, "No days missed")
+	default:
+		lines = append(lines, fmt.Sprintf("Days missed: %d (%s)", len(r.missed), strings.Join(r.missed, ", ")))
+	}
+	if r.progress.set() {
+		line := fmt.Sprintf("Event: %s of %s (%.1f%%)", r.progress.format(r.progress.done, 2), r.progress.format(r.progress.target, 0), r.progress.percent)
+		switch {
+		case r.progress.done >= r.progress.target:
+			line += ", goal complete"
+		case !r.progress.projected.IsZero():
+			line += ", projected to finish " + r.progress.projected.Local().Format("Jan 2")
+		}
+		lines = append(lines, line)
+	}
+	return
+}
+
+func (r progressReport) period() string {
+	return fmt.Sprintf("%s to %s", r.from.Format("Jan 2"), r.to.AddDate(0, 0, -1).Format("Jan 2, 2006"))
+}
+
+var reportTemplate = template.Must(template.New("report").Parse(`<!doctype html>
+<html><head><meta charset="utf-8"><title>{{.Title}}</title></head>
+<body style="font-family: sans-serif">
+<h2>{{.Title}}</h2>
+<p>{{.Period}}</p>
+<ul>
+{{range .Lines}}<li>{{.}}</li>
+{{end}}</ul>
+</body></html>
+`))
+
+func writeReport(w io.Writer, r progressReport, format string) error {
+	switch format {
+	case "markdown":
+		fmt.Fprintf(w, "**%s** (%s)\n\n", r.title, r.period())
+		for _, line := range r.lines() {
+			fmt.Fprintf(w, "- %s\n", line)
+		}
+		return nil
+	case "html":
+		return reportTemplate.Execute(w, struct {
+			Title, Period string
+			Lines         []string
+		}{r.title, r.period(), r.lines()})
+	}
+	fmt.Fprintf(w, "%s, %s\n\n", r.title, r.period())
+	for _, line := range r.lines() {
+		fmt.Fprintf(w, "  %s\n", line)
+	}
+	return nil
+}
+
+// reportCommand prints the week's or month's report from the ledger.
+func reportCommand(u *uploader, args []string) {
+	flags := flag.NewFlagSet("report", flag.ExitOnError)
+	week := flags.Bool("week", false, "report this week, from Monday (the default)")
+	month := flags.Bool("month", false, "report this month")
+	last := flags.Bool("last", false, "report the week or month before")
+	format := flags.String("format", "text", "text, markdown or html")
+	out := flags.String("out", "-", "file to write, - for stdout")
+	flags.Parse(args)
+
+	if *week && *month {
+		log.Fatal("Pick one of --week and --month")
+	}
+	if *format != "text" && *format != "markdown" && *format != "html" {
+		log.Fatalf("Unknown report format %q, expected text, markdown or html", *format)
+	}
+	period := "week"
+	if *month {
+		period = "month"
+	}
+
+	report := buildReport(u, period, *last)
+	w := io.Writer(os.Stdout)
+	if *out != "-" {
+		file, err := os.Create(*out)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer file.Close()
+		w = file
+	}
+	if err := writeReport(w, report, *format); err != nil {
+		log.Fatal(err)
+	}
+	if *out != "-" {
+		log.Printf("Wrote the report to %s", *out)
+	}
+}
@@ -0,0 +1,135 @@
+package taju
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// UNCERTAIN_DISTANCE is how far apart, relative to the longer distance, a
+// run and a Taji entry on the same day can be and still be queued as a
+// possible duplicate.
+const UNCERTAIN_DISTANCE float64 = 0.1
+
+const (
+	RESOLUTION_SAME      string = "same"
+	RESOLUTION_DIFFERENT string = "different"
+)
+
+// pendingMatch pairs a Strava activity with a Taji entry that may be the
+// same workout. The sync doesn't guess: the pair waits until `taju resolve`
+// records a Resolution.
+type pendingMatch struct {
+	StravaId     int64  `json:"strava_id"`
+	LogId        string `json:"log_id"`
+	Activity     string `json:"activity"`
+	Date         string `json:"date"`
+	Time         string `json:"time"`
+	Distance     string `json:"distance"`
+	Duration     string `json:"duration"`
+	TajiTime     string `json:"taji_time"`
+	TajiDistance string `json:"taji_distance"`
+	TajiDuration string `json:"taji_duration"`
+	Resolution   string `json:"resolution,omitempty"`
+}
+
+// findUncertainDuplicate returns an event on the run's day whose distance is
+// close to, but not the same as, the run's.
+func findUncertainDuplicate(run runDetails, events []tajiEvent) (tajiEvent, bool) {
+	distance, err := strconv.ParseFloat(run.distance, 64)
+	if err != nil || distance <= 0 {
+		return tajiEvent{}, false
+	}
+	for _, event := range events {
+		if event.date != run.date || event.key != "" {
+			continue
+		}
+		other, err := strconv.ParseFloat(event.distance, 64)
+		if err != nil || other <= 0 {
+			continue
+		}
+		if math.Abs(distance-other)/math.Max(distance, other) <= UNCERTAIN_DISTANCE {
+			return event, true
+		}
+	}
+	return tajiEvent{}, false
+}
+
+func (s *stateStore) queueMatch(run runDetails, event tajiEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Pending == nil {
+		s.Pending = make(map[int64]*pendingMatch)
+	}
+	s.Pending[run.strava_id] = &pendingMatch{
+		StravaId:     run.strava_id,
+		LogId:        event.entry,
+		Activity:     run.activity,
+		Date:         run.date,
+		Time:         run.time,
+		Distance:     run.distance,
+		Duration:     run.duration,
+		TajiTime:     event.time,
+		TajiDistance: event.distance,
+		TajiDuration: event.duration,
+	}
+}
+
+func (s *stateStore) pendingMatch(strava_id int64) (pendingMatch, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	match, ok := s.Pending[strava_id]
+	if !ok {
+		return pendingMatch{}, false
+	}
+	return *match, true
+}
+
+// resolveCommand walks through the queued possible duplicates and records
+// whether each pair is the same workout. The next sync acts on the answers:
+// same links the activity to the entry, different posts the activity.
+func resolveCommand(u *uploader) {
+	var queued []*pendingMatch
+	for _, match := range u.state.Pending {
+		if match.Resolution == "" {
+			queued = append(queued, match)
+		}
+	}
+	if len(queued) == 0 {
+		fmt.Println("No possible duplicates are waiting to be resolved.")
+		return
+	}
+	slices.SortFunc(queued, func(a, b *pendingMatch) int {
+		return strings.Compare(a.Date+a.Time, b.Date+b.Time)
+	})
+
+	reader := bufio.NewReader(os.Stdin)
+questions:
+	for i, match := range queued {
+		fmt.Printf("\n(%d/%d) %s on %s\n", i+1, len(queued), match.Activity, match.Date)
+		fmt.Printf("  Strava %-12d at %s  %s mi  %s\n", match.StravaId, match.Time, match.Distance, match.Duration)
+		fmt.Printf("  Taji   entry %-6s at %s  %s mi  %s\n", match.LogId, match.TajiTime, match.TajiDistance, match.TajiDuration)
+		fmt.Print("Same workout? [s]ame, [d]ifferent (post it), [l]ater, [q]uit: ")
+		answer, err := reader.ReadString('\n')
+		if err != nil {
+			break
+		}
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "s", "same":
+			match.Resolution = RESOLUTION_SAME
+		case "d", "different":
+			match.Resolution = RESOLUTION_DIFFERENT
+		case "q", "quit":
+			break questions
+		}
+	}
+
+	if err := u.state.save(); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,132 @@
+package taju
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	MAX_RETRIES       = 4
+	RETRY_BASE_DELAY  = time.Second
+	RETRY_MAX_DELAY   = time.Minute
+	STRAVA_RATE_FRAME = 15 * time.Minute
+)
+
+// retryTransport retries failed requests with exponential backoff. GETs are
+// retried on network errors, 5xx and 429; other methods only on 429 and 503,
+// where the server says it didn't process the request, so a form POST that
+// timed out is never sent twice.
+type retryTransport struct {
+	base  http.RoundTripper
+	clock clock
+}
+
+func newRetryTransport(base http.RoundTripper, c clock) *retryTransport {
+	return &retryTransport{base: base, clock: c}
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	idempotent := req.Method == "GET" || req.Method == "HEAD"
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+
+		res, err := t.base.RoundTrip(req)
+		if res != nil {
+			noteRateLimit(res)
+		}
+		retry := false
+		switch {
+		case err != nil:
+			// Reads cut short by stopping aren't retried either.
+			retry = idempotent && req.Context().Err() == nil && stopping.Err() == nil
+		case res.StatusCode == 429 || res.StatusCode == 503:
+			retry = true
+		case res.StatusCode >= 500:
+			retry = idempotent
+		}
+		if !retry || attempt >= MAX_RETRIES {
+			return res, err
+		}
+
+		delay := backoff(attempt)
+		if res != nil {
+			wait, rate_err := rateLimitDelay(res, t.clock.Now())
+			io.Copy(io.Discard, res.Body)
+			res.Body.Close()
+			if rate_err != nil {
+				return nil, rate_err
+			}
+			if wait > 0 {
+				delay = wait
+			}
+			log.Printf("%s %s returned %s, retrying in %s", req.Method, req.URL.Host+req.URL.Path, res.Status, delay.Round(time.Second))
+		} else {
+			log.Printf("%s %s failed: %v, retrying in %s", req.Method, req.URL.Host+req.URL.Path, err, delay.Round(time.Second))
+		}
+		syncMetrics.retries.Add(1)
+		t.clock.Sleep(delay)
+	}
+}
+
+// backoff is 1s, 2s, 4s, ... with jitter, capped at RETRY_MAX_DELAY.
+func backoff(attempt int) time.Duration {
+	delay := RETRY_BASE_DELAY << attempt
+	delay += time.Duration(rand.Int63n(int64(delay) / 2))
+	return min(delay, RETRY_MAX_DELAY)
+}
+
+var ErrDailyRateLimit = errors.New("strava daily rate limit reached, try again tomorrow")
+
+// rateLimitDelay works out how long to wait from Retry-After or from
+// Strava's X-RateLimit-Limit/X-RateLimit-Usage headers ("short,daily"). The
+// short limit resets every 15 minutes; hitting the daily limit is an error.
+func rateLimitDelay(res *http.Response, now time.Time) (time.Duration, error) {
+	if value := res.Header.Get("Retry-After"); value != "" {
+		if seconds, err := strconv.Atoi(value); err == nil {
+			return time.Duration(seconds) * time.Second, nil
+		}
+		if at, err := http.ParseTime(value); err == nil {
+			return at.Sub(now), nil
+		}
+	}
+
+	limits := strings.Split(res.Header.Get("X-RateLimit-Limit"), ",")
+	usage := strings.Split(res.Header.Get("X-RateLimit-Usage"), ",")
+	if len(limits) != 2 || len(usage) != 2 {
+		return 0, nil
+	}
+	parse := func(value string) int {
+		n, _ := strconv.Atoi(strings.TrimSpace(value))
+		return n
+	}
+	if parse(usage[1]) >= parse(limits[1]) {
+		return 0, ErrDailyRateLimit
+	}
+	if parse(usage[0]) >= parse(limits[0]) {
+		next := now.UTC().Truncate(STRAVA_RATE_FRAME).Add(STRAVA_RATE_FRAME)
+		return next.Sub(now) + time.Second, nil
+	}
+	return 0, nil
+}
+
+// checkStatus turns an unexpected status into an error.
+func checkStatus(res *http.Response, what string) error {
+	if res.StatusCode >= 400 {
+		return fmt.Errorf("%s returned %s", what, res.Status)
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+package taju
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const SCHEDULE_NAME string = "TajUploader"
+const LAUNCHD_LABEL string = "com.tajuploader.sync"
+const CRON_MARKER string = "# tajuploader sync"
+
+func scheduleCommand(args []string) {
+	if len(args) < 1 {
+		log.Fatal("Usage: taju schedule install --every 6h | taju schedule remove")
+	}
+
+	flags := flag.NewFlagSet("schedule", flag.ExitOnError)
+	every := flags.Duration("every", 6*time.Hour, "how often to run taju sync --once")
+	flags.Parse(args[1:])
+
+	if *every < time.Minute {
+		log.Fatal("The schedule interval must be at least one minute")
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		log.Fatal(err)
+	}
+	// taju.env is read from the working directory, so the scheduled run has
+	// to start where the schedule was installed.
+	dir, err := os.Getwd()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	switch args[0] {
+	case "install":
+		err = installSchedule(exe, dir, *every)
+	case "remove":
+		err = removeSchedule()
+	default:
+		log.Fatal("Unknown schedule command: ", args[0])
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Schedule %s done", args[0])
+}
+
+func installSchedule(exe string, dir string, every time.Duration) error {
+	switch runtime.GOOS {
+	case "windows":
+		minutes := int(every / time.Minute)
+		if minutes > 1439 {
+			return fmt.Errorf("windows scheduled tasks can repeat at most every 1439 minutes")
+		}
+		command := fmt.Sprintf(`cmd /c "cd /d "%s" && "%s" sync --once"`, dir, exe)
+		return runScheduler("schtasks", "/Create", "/F", "/TN", SCHEDULE_NAME,
+			"/SC", "MINUTE", "/MO", fmt.Sprint(minutes), "/TR", command)
+	case "darwin":
+		path, err := launchdPlistPath(LAUNCHD_LABEL)
+		if err != nil {
+			return err
+		}
+		plist := fmt.Sprintf(LAUNCHD_PLIST, LAUNCHD_LABEL, exe, dir, int(every/time.Second))
+		if err := os.WriteFile(path, []byte(plist), 0644); err != nil {
+			return err
+		}
+		return runScheduler("launchctl", "load", "-w", path)
+	default:
+		spec, err := cronSpec(every)
+		if err != nil {
+			return err
+		}
+		lines := cronLines()
+		lines = append(lines, fmt.Sprintf("%s cd '%s' && '%s' sync --once %s", spec, dir, exe, CRON_MARKER))
+		return writeCrontab(lines)
+	}
+}
+
+func removeSchedule() error {
+	switch runtime.GOOS {
+	case "windows":
+		return runScheduler("schtasks", "/Delete", "/F", "/TN", SCHEDULE_NAME)
+	case "darwin":
+		path, err := launchdPlistPath(LAUNCHD_LABEL)
+		if err != nil {
+			return err
+		}
+		runScheduler("launchctl", "unload", "-w", path)
+		return os.Remove(path)
+	default:
+		return writeCrontab(cronLines())
+	}
+}
+
+func runScheduler(name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+const LAUNCHD_PLIST string = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	<string>%s</string>
+	<key>ProgramArguments</key>
+	<array>
+		<string>%s</string>
+		<string>sync</string>
+		<string>--once</string>
+	</array>
+	<key>WorkingDirectory</key>
+	<string>%s</string>
+	<key>StartInterval</key>
+	<integer>%d</integer>
+</dict>
+</plist>
+`
+
+func launchdPlistPath(label string) (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, "Library", "LaunchAgents", label+".plist"), nil
+}
+
+// cronSpec turns an interval into a cron schedule. Cron can only express
+// intervals that divide an hour or a day evenly.
+func cronSpec(every time.Duration) (string, error) {
+	minutes := int(every / time.Minute)
+	switch {
+	case minutes < 60 && 60%minutes == 0:
+		return fmt.Sprintf("*/%d * * * *", minutes), nil
+	case minutes%60 == 0 && 24%(minutes/60) == 0:
+		return fmt.Sprintf("0 */%d * * *", minutes/60), nil
+	}
+	return "", fmt.Errorf("cron cannot run every %s, pick an interval that divides an hour or a day", every)
+}
+
+// cronLines returns the current crontab without any tajuploader entries.
+func cronLines() (lines []string) {
+	out, err := exec.Command("crontab", "-l").Output()
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(strings.TrimRight(string(out), "\n"), "\n") {
+		if line != "" && !strings.HasSuffix(line, CRON_MARKER) {
+			lines = append(lines, line)
+		}
+	}
+	return
+}
+
+func writeCrontab(lines []string) error {
+	cmd := exec.Command("crontab", "-")
+	cmd.Stdin = strings.NewReader(strings.Join(lines, "\n") + "\n")
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
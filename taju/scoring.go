@@ -0,0 +1,87 @@
+package taju
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+const POINTS_FILENAME string = "taju.points.json"
+
+// pointsRule scores one Taji category. Rules with activity "*" apply to
+// every category, on top of the category's own rule.
+type pointsRule struct {
+	Activity string  `json:"activity"`
+	PerMile  float64 `json:"per_mile"`
+	PerHour  float64 `json:"per_hour"`
+	PerFoot  float64 `json:"per_foot"`
+}
+
+// pointsRules are the event's published scoring rules, kept in a data file
+// (TAJU_POINTS_FILE, taju.points.json by default) so they can be updated
+// when the event changes them without a new release:
+//
+//	{
+//	  "unit": "Taji points",
+//	  "rules": [
+//	    {"activity": "run", "per_mile": 1},
+//	    {"activity": "ruck", "per_mile": 1.5},
+//	    {"activity": "*", "per_foot": 0.001}
+//	  ]
+//	}
+//
+// Without the file only miles are shown.
+type pointsRules struct {
+	Unit  string       `json:"unit"`
+	Rules []pointsRule `json:"rules"`
+
+	// days holds the bonuses of special days, see specialDay.
+	days specialDays
+}
+
+func loadPointsRules(env map[string]string) (*pointsRules, error) {
+	path := env["TAJU_POINTS_FILE"]
+	if path == "" {
+		path = POINTS_FILENAME
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && env["TAJU_POINTS_FILE"] == "" {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error loading %s: %w", path, err)
+	}
+	rules := new(pointsRules)
+	if err := json.Unmarshal(data, rules); err != nil {
+		return nil, fmt.Errorf("error loading %s: %w", path, err)
+	}
+	if rules.Unit == "" {
+		rules.Unit = "points"
+	}
+	if rules.days, err = loadSpecialDays(env); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// points scores a run. A nil rule set scores nothing.
+func (p *pointsRules) points(run runDetails) (points float64) {
+	if p == nil {
+		return 0
+	}
+	miles := meter2mile(run.distance_float)
+	hours := float64(run.duration_int) / 3600
+	feet, _ := strconv.ParseFloat(run.elevation_gain, 64)
+	for _, rule := range p.Rules {
+		if rule.Activity != "*" && rule.Activity != run.activity {
+			continue
+		}
+		points += rule.PerMile*miles + rule.PerHour*hours + rule.PerFoot*feet
+	}
+	if day, ok := p.days[run.date]; ok && day.earnsBonus(run.activity) {
+		points *= day.Bonus
+	}
+	return
+}
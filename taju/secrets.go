@@ -0,0 +1,165 @@
+package taju
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/user"
+	"strings"
+
+	"github.com/tajuploader/config"
+)
+
+const SECRET_PREFIX string = "enc:"
+
+// CREDENTIALS_FILE keeps the secret values in the env file, see below.
+const CREDENTIALS_FILE string = "file"
+
+// The values of config.SECRET_KEYS are written to the env file encrypted.
+// This only keeps them out of casual screenshots and shared files; anyone
+// on the same machine account can derive the key. TAJU_CREDENTIALS=keyring
+// moves them to the OS keyring instead, see keyring.go, and
+// TAJU_CREDENTIALS=passphrase encrypts them under a passphrase, see
+// passphrase.go.
+
+func machineKey() []byte {
+	seed := []string{"tajuploader"}
+	if host, err := os.Hostname(); err == nil {
+		seed = append(seed, host)
+	}
+	if u, err := user.Current(); err == nil {
+		seed = append(seed, u.Uid, u.Username)
+	}
+	for _, path := range []string{"/etc/machine-id", "/var/lib/dbus/machine-id"} {
+		if id, err := os.ReadFile(path); err == nil {
+			seed = append(seed, strings.TrimSpace(string(id)))
+			break
+		}
+	}
+	key := sha256.Sum256([]byte(strings.Join(seed, "\x00")))
+	return key[:]
+}
+
+func encryptSecret(plain string) (string, error) {
+	sealed, err := sealBytes(machineKey(), []byte(plain))
+	if err != nil {
+		return "", err
+	}
+	return SECRET_PREFIX + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func decryptSecret(value string) (string, error) {
+	if strings.HasPrefix(value, PASSPHRASE_PREFIX) {
+		return openPassphrase(value)
+	}
+	if !strings.HasPrefix(value, SECRET_PREFIX) {
+		return value, nil
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, SECRET_PREFIX))
+	if err != nil {
+		return "", err
+	}
+	plain, err := openBytes(machineKey(), sealed)
+	return string(plain), err
+}
+
+// sealBytes encrypts data with AES-GCM under a 32 byte key, nonce first.
+func sealBytes(key []byte, data []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, data, nil), nil
+}
+
+func openBytes(key []byte, sealed []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("encrypted value is too short")
+	}
+	return gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+}
+
+// decryptSecrets decrypts the secret values in place. Values that cannot be
+// decrypted (e.g. the file was copied from another machine) are dropped so
+// that the uploader re-authenticates instead of using garbage. A wrong
+// passphrase is an error instead, the values aren't lost.
+func decryptSecrets(env map[string]string, profile string) error {
+	for key, value := range env {
+		if !config.IsSecret(key) {
+			continue
+		}
+		var plain string
+		var err error
+		if strings.HasPrefix(value, KEYRING_PREFIX) {
+			plain, err = openKeyring(keyringAccount(profile, key), value)
+		} else {
+			plain, err = decryptSecret(value)
+		}
+		if err != nil && strings.HasPrefix(value, PASSPHRASE_PREFIX) {
+			return fmt.Errorf("failed to decrypt %s, is the passphrase right? %w", key, err)
+		}
+		if err != nil {
+			log.Print("Failed to decrypt ", key, ", it will be requested again: ", err)
+			delete(env, key)
+			continue
+		}
+		env[key] = plain
+	}
+	return nil
+}
+
+// encryptSecrets returns a copy of env with the secret values encrypted,
+// for the env file of profile ("" for taju.env), the way backend keeps
+// them, see loadCredentials.
+func encryptSecrets(env map[string]string, profile string, backend string) map[string]string {
+	out := make(map[string]string, len(env))
+	for key, value := range env {
+		out[key] = value
+	}
+	for key, value := range out {
+		if !config.IsSecret(key) {
+			continue
+		}
+		if backend == CREDENTIALS_KEYRING {
+			stored, err := sealKeyring(keyringAccount(profile, key), value)
+			if err == nil {
+				out[key] = stored
+				continue
+			}
+			log.Print("Failed to store ", key, " in the OS keyring, keeping it in the env file: ", err)
+		}
+		seal := encryptSecret
+		if backend == CREDENTIALS_PASSPHRASE {
+			seal = sealPassphrase
+		}
+		sealed, err := seal(value)
+		if err != nil {
+			log.Print("Failed to encrypt ", key, ": ", err)
+			continue
+		}
+		out[key] = sealed
+	}
+	return out
+}
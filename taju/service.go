@@ -0,0 +1,254 @@
+package taju
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+)
+
+// The sync daemon can be registered with the OS so it starts on boot and
+// comes back after a crash or reboot, instead of a terminal kept open all
+// month:
+//
+//	Linux    a systemd user unit (--system: a system unit, run as the
+//	         installing user), with lingering so it starts without a login
+//	macOS    a launchd agent kept alive while the user is logged in
+//	Windows  a Task Scheduler task run at logon; --system registers a
+//	         Windows service started on boot, as LocalSystem, and
+//	         restarted a minute after a crash (see service_windows.go)
+//
+// Like taju schedule, the service runs where it was installed, since
+// taju.env is read from the working directory. A Windows service is
+// started in the system directory, so it is given the directory to change
+// to: the service manager runs "taju service run DIR".
+const (
+	SERVICE_NAME     string = "tajuploader"
+	SERVICE_TASK     string = "TajUploaderDaemon"
+	SERVICE_LABEL    string = "com.tajuploader.daemon"
+	SERVICE_LOG_FILE string = "taju.service.log"
+)
+
+func serviceCommand(args []string) {
+	const usage = "Usage: taju service install [--system] | taju service uninstall [--system] | taju service status [--system]"
+	if len(args) < 1 {
+		log.Fatal(usage)
+	}
+	if args[0] == "run" {
+		if len(args) != 2 {
+			log.Fatal("Usage: taju service run DIR (started by the Windows service manager)")
+		}
+		if err := runService(args[1]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	flags := flag.NewFlagSet("service", flag.ExitOnError)
+	system := flags.Bool("system", false, "install for the whole machine (needs root or an administrator)")
+	flags.Parse(args[1:])
+
+	exe, err := os.Executable()
+	if err != nil {
+		log.Fatal(err)
+	}
+	dir, err := os.Getwd()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	switch args[0] {
+	case "install":
+		err = installService(exe, dir, *system)
+	case "uninstall":
+		err = uninstallService(*system)
+	case "status":
+		err = serviceStatus(*system)
+	default:
+		log.Fatal(usage)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if args[0] != "status" {
+		log.Printf("Service %s done", args[0])
+	}
+}
+
+func installService(exe string, dir string, system bool) error {
+	switch runtime.GOOS {
+	case "windows":
+		if system {
+			return installWindowsService(exe, dir)
+		}
+		command := fmt.Sprintf(`cmd /c "cd /d "%s" && "%s" sync --daemon --headless >> %s 2>&1"`, dir, exe, SERVICE_LOG_FILE)
+		args := []string{"/Create", "/F", "/TN", SERVICE_TASK, "/TR", command, "/SC", "ONLOGON"}
+		if err := runScheduler("schtasks", args...); err != nil {
+			return err
+		}
+		return runScheduler("schtasks", "/Run", "/TN", SERVICE_TASK)
+	case "darwin":
+		if system {
+			return fmt.Errorf("--system isn't supported on macOS, the agent runs while you are logged in")
+		}
+		path, err := launchdPlistPath(SERVICE_LABEL)
+		if err != nil {
+			return err
+		}
+		logs := filepath.Join(dir, SERVICE_LOG_FILE)
+		plist := fmt.Sprintf(LAUNCHD_DAEMON_PLIST, SERVICE_LABEL, exe, dir, logs, logs)
+		if err := os.WriteFile(path, []byte(plist), 0644); err != nil {
+			return err
+		}
+		return runScheduler("launchctl", "load", "-w", path)
+	default:
+		path, err := systemdUnitPath(system)
+		if err != nil {
+			return err
+		}
+		owner, target := "", "default.target"
+		if system {
+			current, err := user.Current()
+			if err != nil {
+				return err
+			}
+			owner, target = "User="+current.Username+"\n", "multi-user.target"
+		}
+		unit := fmt.Sprintf(SYSTEMD_UNIT, dir, owner, exe, target)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, []byte(unit), 0644); err != nil {
+			return err
+		}
+		if err := runScheduler("systemctl", systemctlArgs(system, "daemon-reload")...); err != nil {
+			return err
+		}
+		if err := runScheduler("systemctl", systemctlArgs(system, "enable", "--now", SERVICE_NAME)...); err != nil {
+			return err
+		}
+		if !system {
+			// Without lingering a user unit only runs while the user is
+			// logged in.
+			if current, err := user.Current(); err == nil {
+				if err := runScheduler("loginctl", "enable-linger", current.Username); err != nil {
+					log.Printf("Couldn't enable lingering (%v), the service starts when you log in", err)
+				}
+			}
+		}
+		return nil
+	}
+}
+
+func uninstallService(system bool) error {
+	switch runtime.GOOS {
+	case "windows":
+		if system {
+			return uninstallWindowsService()
+		}
+		runScheduler("schtasks", "/End", "/TN", SERVICE_TASK)
+		return runScheduler("schtasks", "/Delete", "/F", "/TN", SERVICE_TASK)
+	case "darwin":
+		path, err := launchdPlistPath(SERVICE_LABEL)
+		if err != nil {
+			return err
+		}
+		runScheduler("launchctl", "unload", "-w", path)
+		return os.Remove(path)
+	default:
+		path, err := systemdUnitPath(system)
+		if err != nil {
+			return err
+		}
+		runScheduler("systemctl", systemctlArgs(system, "disable", "--now", SERVICE_NAME)...)
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		return runScheduler("systemctl", systemctlArgs(system, "daemon-reload")...)
+	}
+}
+
+func serviceStatus(system bool) error {
+	switch runtime.GOOS {
+	case "windows":
+		if system {
+			return windowsServiceStatus()
+		}
+		return runScheduler("schtasks", "/Query", "/TN", SERVICE_TASK, "/V", "/FO", "LIST")
+	case "darwin":
+		return runScheduler("launchctl", "list", SERVICE_LABEL)
+	default:
+		return runScheduler("systemctl", systemctlArgs(system, "status", "--no-pager", SERVICE_NAME)...)
+	}
+}
+
+func systemctlArgs(system bool, args ...string) []string {
+	if system {
+		return args
+	}
+	return append([]string{"--user"}, args...)
+}
+
+func systemdUnitPath(system bool) (string, error) {
+	if system {
+		return filepath.Join("/etc/systemd/system", SERVICE_NAME+".service"), nil
+	}
+	config, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(config, "systemd", "user", SERVICE_NAME+".service"), nil
+}
+
+// SYSTEMD_UNIT restarts the daemon when it fails; a failed cycle doesn't
+// end it, so a failure is a crash or a bad config. A strict-mode stop
+// (EXIT_INCONSISTENT) waits for someone to look at the drift.
+const SYSTEMD_UNIT string = `[Unit]
+Description=Taji Uploader sync daemon
+Wants=network-online.target
+After=network-online.target
+
+[Service]
+WorkingDirectory=%s
+%sExecStart="%s" sync --daemon --headless
+Restart=on-failure
+RestartSec=60
+RestartPreventExitStatus=3
+
+[Install]
+WantedBy=%s
+`
+
+const LAUNCHD_DAEMON_PLIST string = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	<string>%s</string>
+	<key>ProgramArguments</key>
+	<array>
+		<string>%s</string>
+		<string>sync</string>
+		<string>--daemon</string>
+		<string>--headless</string>
+	</array>
+	<key>WorkingDirectory</key>
+	<string>%s</string>
+	<key>RunAtLoad</key>
+	<true/>
+	<key>KeepAlive</key>
+	<dict>
+		<key>SuccessfulExit</key>
+		<false/>
+	</dict>
+	<key>ThrottleInterval</key>
+	<integer>60</integer>
+	<key>StandardOutPath</key>
+	<string>%s</string>
+	<key>StandardErrorPath</key>
+	<string>%s</string>
+</dict>
+</plist>
+`
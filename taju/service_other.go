@@ -0,0 +1,24 @@
+//go:build !windows
+
+package taju
+
+import "errors"
+
+var errNotWindows = errors.New("Windows services are only available on Windows")
+
+func installWindowsService(exe string, dir string) error {
+	return errNotWindows
+}
+
+func uninstallWindowsService() error {
+	return errNotWindows
+}
+
+func windowsServiceStatus() error {
+	return errNotWindows
+}
+
+// runService is only started by the Windows service manager.
+func runService(dir string) error {
+	return errors.New("taju service run is started by the Windows service manager, use taju service install")
+}
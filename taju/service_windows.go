@@ -0,0 +1,117 @@
+package taju
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// SERVICE_STOP_WAIT is how long the service manager is told a stop may
+// take: the cycle in flight finishes its post first, see watchShutdown.
+const SERVICE_STOP_WAIT = 30 * time.Second
+
+// installWindowsService registers the daemon with the service control
+// manager, which needs an administrator. It starts on boot, a little after
+// the network services, and is restarted a minute after a crash like the
+// systemd unit.
+func installWindowsService(exe string, dir string) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("connecting to the service manager, run this as an administrator: %w", err)
+	}
+	defer m.Disconnect()
+	s, err := m.CreateService(SERVICE_NAME, exe, mgr.Config{
+		DisplayName:      "Taji Uploader",
+		Description:      "Uploads Strava activities to the Taji 100 log",
+		StartType:        mgr.StartAutomatic,
+		DelayedAutoStart: true,
+	}, "service", "run", dir)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	restart := []mgr.RecoveryAction{{Type: mgr.ServiceRestart, Delay: time.Minute}}
+	if err := s.SetRecoveryActions(restart, uint32((24 * time.Hour).Seconds())); err != nil {
+		return err
+	}
+	return s.Start()
+}
+
+func uninstallWindowsService() error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("connecting to the service manager, run this as an administrator: %w", err)
+	}
+	defer m.Disconnect()
+	s, err := m.OpenService(SERVICE_NAME)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	s.Control(svc.Stop)
+	return s.Delete()
+}
+
+func windowsServiceStatus() error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+	s, err := m.OpenService(SERVICE_NAME)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	status, err := s.Query()
+	if err != nil {
+		return err
+	}
+	states := map[svc.State]string{svc.Stopped: "stopped", svc.StartPending: "starting", svc.StopPending: "stopping",
+		svc.Running: "running", svc.ContinuePending: "resuming", svc.PausePending: "pausing", svc.Paused: "paused"}
+	fmt.Printf("%s: %s (pid %d)\n", SERVICE_NAME, states[status.State], status.ProcessId)
+	return nil
+}
+
+// runService is taju service run, the command the service manager starts:
+// the sync daemon in dir, logging to SERVICE_LOG_FILE there, answering the
+// manager's requests. A stop or shutdown is handled like a SIGTERM.
+func runService(dir string) error {
+	if err := os.Chdir(dir); err != nil {
+		return err
+	}
+	os.Args = []string{os.Args[0], "--log-file", SERVICE_LOG_FILE, "sync", "--daemon", "--headless"}
+	return svc.Run(SERVICE_NAME, serviceHandler{})
+}
+
+type serviceHandler struct{}
+
+func (serviceHandler) Execute(args []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		Main()
+	}()
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for {
+		select {
+		case <-done:
+			return false, 0
+		case request := <-requests:
+			switch request.Cmd {
+			case svc.Interrogate:
+				status <- request.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending, WaitHint: uint32(SERVICE_STOP_WAIT.Milliseconds())}
+				shutdownSignals <- syscall.SIGTERM
+				<-done
+				return false, 0
+			}
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package taju
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// stravaService is what a sync cycle needs from a Strava account, and
+// tajiService what it needs from Taji. The syncer only talks to the two
+// sites through them, so a different frontend can drive the sync engine
+// and the engine can run against fake sites.
+type stravaService interface {
+	Activities() (runs []runDetails, partial bool, err error)
+}
+
+type tajiService interface {
+	Entries() ([]string, error)
+	Events(entries []string) ([]tajiEvent, error)
+	Post(run runDetails) (log_id string, err error)
+	Update(log_id string, run runDetails) error
+	Delete(log_id string) error
+}
+
+// contextService is a site whose requests can be bound to the context of
+// the run in flight, so cancelling the run cuts them short, see
+// syncer.run.
+type contextService interface {
+	bindContext(ctx context.Context)
+}
+
+// listingService is a site whose participant page shows some of each
+// entry's values, so an entry edited on the site is noticed without reading
+// every edit page, see knownEvents.
+type listingService interface {
+	entryRows() map[string]string
+}
+
+// runContext is the context a site's requests are sent under: the one of
+// the run in flight, else Background.
+type runContext struct {
+	ctx atomic.Pointer[context.Context]
+}
+
+func (r *runContext) bindContext(ctx context.Context) {
+	if ctx == nil {
+		r.ctx.Store(nil)
+		return
+	}
+	r.ctx.Store(&ctx)
+}
+
+func (r *runContext) context() context.Context {
+	if r == nil {
+		return context.Background()
+	}
+	if ctx := r.ctx.Load(); ctx != nil {
+		return *ctx
+	}
+	return context.Background()
+}
+
+func (s *strava) bindContext(ctx context.Context) {
+	if s.run == nil {
+		s.run = new(runContext)
+	}
+	s.run.bindContext(ctx)
+}
+
+func (t *taji) bindContext(ctx context.Context) {
+	t.run.bindContext(ctx)
+}
+
+func (s *strava) Activities() ([]runDetails, bool, error) {
+	return getStravaActivities(s)
+}
+
+func (t *taji) Entries() ([]string, error) {
+	return getTajiEntries(t)
+}
+
+func (t *taji) entryRows() map[string]string {
+	return t.rows
+}
+
+func (t *taji) Events(entries []string) ([]tajiEvent, error) {
+	return getTajiEvents(t, entries)
+}
+
+func (t *taji) Post(run runDetails) (string, error) {
+	return postRun(t, run)
+}
+
+func (t *taji) Update(log_id string, run runDetails) error {
+	return updateTajiEntry(t, log_id, run)
+}
+
+func (t *taji) Delete(log_id string) error {
+	return deleteTajiEntry(t, log_id)
+}
@@ -0,0 +1,142 @@
+package taju
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeStrava is a Strava account with fixed activities, or one that fails.
+type fakeStrava struct {
+	runs    []runDetails
+	partial bool
+	err     error
+}
+
+func (f *fakeStrava) Activities() ([]runDetails, bool, error) {
+	return f.runs, f.partial, f.err
+}
+
+// fakeTaji is a Taji log in memory. Posted runs become events the way the
+// site shows them, with the idempotency key in the notes.
+type fakeTaji struct {
+	mu     sync.Mutex
+	events []tajiEvent
+	next   int
+	err    error
+	// listing, if set, is how a posted run shows up on the participant
+	// page, and whether it does at all.
+	listing func(tajiEvent) (tajiEvent, bool)
+	// rows are the row digests of the participant page, see listingService,
+	// and scrape_err fails reading the edit pages.
+	rows       map[string]string
+	scrape_err error
+	posted     []runDetails
+	updated    []string
+	deleted    []string
+	// listings counts the reads of the participant page.
+	listings int
+}
+
+func (f *fakeTaji) Entries() ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.listings++
+	if f.err != nil {
+		return nil, f.err
+	}
+	var entries []string
+	for _, event := range f.events {
+		entries = append(entries, event.entry)
+	}
+	return entries, nil
+}
+
+func (f *fakeTaji) Events(entries []string) ([]tajiEvent, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return nil, f.err
+	}
+	if f.scrape_err != nil && len(entries) > 0 {
+		return nil, f.scrape_err
+	}
+	var events []tajiEvent
+	for _, event := range f.events {
+		if slices.Contains(entries, event.entry) {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+func (f *fakeTaji) entryRows() map[string]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.rows
+}
+
+func (f *fakeTaji) Post(run runDetails) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return "", f.err
+	}
+	f.next++
+	entry := fmt.Sprintf("%d", 100+f.next)
+	event, listed := eventOf(entry, run), true
+	if f.listing != nil {
+		event, listed = f.listing(event)
+	}
+	if listed {
+		f.events = append(f.events, event)
+	}
+	f.posted = append(f.posted, run)
+	return entry, nil
+}
+
+func (f *fakeTaji) Update(log_id string, run runDetails) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	i := slices.IndexFunc(f.events, func(e tajiEvent) bool { return e.entry == log_id })
+	if i < 0 {
+		return fmt.Errorf("no entry %s", log_id)
+	}
+	f.events[i] = eventOf(log_id, run)
+	f.updated = append(f.updated, log_id)
+	return nil
+}
+
+func (f *fakeTaji) Delete(log_id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.events = slices.DeleteFunc(f.events, func(e tajiEvent) bool { return e.entry == log_id })
+	f.deleted = append(f.deleted, log_id)
+	return nil
+}
+
+func eventOf(entry string, run runDetails) tajiEvent {
+	return tajiEvent{entry: entry, date: run.date, time: run.time, distance: run.distance, duration: run.duration,
+		key: idempotencyKey(run), activity: run.activity, elevation: run.elevation_gain}
+}
+
+// testRun is a Strava run through the default pipeline, started at start
+// (RFC 3339) in UTC.
+func testRun(t *testing.T, id int64, start string, seconds int64, meters float64) runDetails {
+	t.Helper()
+	s := newSettings()
+	run := s.createRun("run", start, seconds, meters)
+	run.strava_id = id
+	run.location = time.UTC
+	pipeline, err := loadPipeline(map[string]string{}, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	run, err = pipeline.apply(run)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return run
+}
@@ -0,0 +1,34 @@
+package taju
+
+import (
+	"context"
+	"log"
+)
+
+const DEFAULT_MAX_BODY_LOG = 300
+
+// reloginTaji replaces an expired session with a new login, using the
+// stored credentials or prompting for them. The client calls it once for
+// all the fetches that found the same session expired.
+func reloginTaji(ctx context.Context, t *taji) error {
+	log.Print("The Taji session expired, logging in again")
+	username, password, err := tajiCredentials(t, t.env)
+	if err != nil {
+		return err
+	}
+	addRedaction(password)
+	if _, err := t.client.Login(ctx, username, password); err != nil {
+		return err
+	}
+	saveTajiLogin(t, t.env)
+	t.relogged.Store(true)
+	return nil
+}
+
+// saveTajiSession writes the env file when the session was renewed during
+// a cycle.
+func saveTajiSession(u *uploader) {
+	if u.taji.relogged.Swap(false) {
+		dumpEnvFile(u)
+	}
+}
@@ -0,0 +1,97 @@
+package taju
+
+import (
+	"os"
+)
+
+// settings are what one configuration decides about writing, rounding and
+// matching runs and whether to prompt, along with what it learns of the
+// Taji forms and where its debug artifacts go. The uploader loads them and
+// shares them with its accounts, its Taji session and its syncer, so
+// configurations loaded side by side in one program keep their own.
+type settings struct {
+	// headless is set by TAJU_HEADLESS=true (or sync --headless) for
+	// running under systemd or in Docker: nothing prompts, even with a
+	// terminal attached, and the summary is logged instead of drawn.
+	headless bool
+	// display is for the terminal output and taji_units for the form
+	// values, see units.
+	display    units
+	taji_units units
+	match      eventMatch
+	step       distanceStep
+	rounding   clockRounding
+	// sport_forms are the TAJU_FORM_ templates keyed by Taji activity.
+	sport_forms map[string]sportForm
+	// state is the ledger the form schemas are cached in, nil without
+	// one (check-forms), see formSchemaOf.
+	state     *stateStore
+	templates *templateTracker
+	artifacts *artifactDir
+}
+
+// newSettings are the defaults: miles, a 12 hour clock, the distance in
+// hundredths and nothing rounded but the seconds of the start.
+func newSettings() *settings {
+	return &settings{
+		display:     units{distance: UNITS_MILES},
+		taji_units:  units{distance: UNITS_MILES},
+		match:       eventMatch{window: DEFAULT_MATCH_WINDOW, distance: DEFAULT_MATCH_DISTANCE},
+		step:        distanceStep{value: DEFAULT_DISTANCE_STEP},
+		rounding:    clockRounding{duration: ROUND_EXACT},
+		sport_forms: make(map[string]sportForm),
+	}
+}
+
+// loadSettings reads the settings of env. The ledger is attached once it
+// is loaded, see useState.
+func loadSettings(env map[string]string, state_dir string, c clock) (*settings, error) {
+	s := newSettings()
+	s.headless = envBool(env, "TAJU_HEADLESS")
+	var err error
+	if s.display, err = loadUnits(env, "TAJU_UNITS", "TAJU_CLOCK"); err != nil {
+		return nil, err
+	}
+	if s.taji_units, err = loadUnits(env, "TAJU_TAJI_UNITS", "TAJU_TAJI_CLOCK"); err != nil {
+		return nil, err
+	}
+	if s.match, err = loadEventMatch(env); err != nil {
+		return nil, err
+	}
+	if err := s.loadDistanceStep(env); err != nil {
+		return nil, err
+	}
+	if err := s.loadRounding(env); err != nil {
+		return nil, err
+	}
+	if s.sport_forms, err = loadSportForms(env); err != nil {
+		return nil, err
+	}
+	s.artifacts = loadDebugArtifacts(env, state_dir, c)
+	return s, nil
+}
+
+// useState caches the form schemas and the page templates in the ledger,
+// and takes the distance step last seen on the form from it unless
+// TAJU_DISTANCE_STEP is set. history is the path of the history log.
+func (s *settings) useState(state *stateStore, history string, c clock) {
+	s.state = state
+	s.templates = &templateTracker{seen: make(map[string]bool), state: state, clock: c, path: history, artifacts: s.artifacts}
+	s.step.mu.Lock()
+	defer s.step.mu.Unlock()
+	s.step.state = state
+	if step := state.distanceStep(); step > 0 && !s.step.configured {
+		s.step.value = step
+	}
+}
+
+// interactive reports whether the user can be asked: not headless, and
+// stdin is a terminal.
+func (s *settings) interactive() bool {
+	return !s.headless && terminal()
+}
+
+func terminal() bool {
+	info, err := os.Stdin.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,55 @@
+package taju
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// EXIT_ABORTED is the status of a sync stopped by a signal in the middle
+// of a cycle, or aborted by a second one: the shell's convention for a
+// process ended by SIGINT.
+const EXIT_ABORTED = 130
+
+// stopping is cancelled by the first signal. The cycle in flight stops
+// before its next change to Taji, and the Strava and Taji reads in flight
+// are cut short (see attemptTransport); a post already sent is waited for.
+var stopping, stopSyncing = context.WithCancel(context.Background())
+
+// shutdownSignals receives the signals watchShutdown acts on, and the stop
+// requests of the Windows service control manager, see runService.
+var shutdownSignals = make(chan os.Signal, 2)
+
+// watchShutdown turns the first SIGINT or SIGTERM into a request to stop
+// the cycle in flight, cancelling stopping and closing the returned
+// channel. A second signal
+// aborts right away, saving the ledger and env file first: posts that were
+// in flight are recorded as posting, so the next run looks for them on Taji
+// before posting again.
+func watchShutdown(profiles []*uploader) <-chan struct{} {
+	stop := make(chan struct{})
+	signal.Notify(shutdownSignals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-shutdownSignals
+		log.Printf("Received %s, stopping the current sync (send it again to abort)", sig)
+		stopSyncing()
+		close(stop)
+		<-shutdownSignals
+		log.Print("Aborting the sync")
+		flushState(profiles)
+		restoreKeys()
+		os.Exit(EXIT_ABORTED)
+	}()
+	return stop
+}
+
+func flushState(profiles []*uploader) {
+	for _, u := range profiles {
+		if err := u.state.save(); err != nil {
+			log.Print("Error:", err)
+		}
+		dumpEnvFile(u)
+	}
+}
@@ -0,0 +1,139 @@
+package taju
+
+import (
+	"cmp"
+	"fmt"
+	"html"
+	"io"
+	"maps"
+	"slices"
+	"strconv"
+	"sync"
+)
+
+// memoryTaji is a Taji that only lives in memory. It takes posts, edits and
+// deletes like the real site and renders a pseudo participant page, so the
+// sync engine can run end to end without touching taji100.com: sync --demo
+// shows what an account would look like after syncing, and a test harness
+// can drive a syncer against it.
+type memoryTaji struct {
+	mu      sync.Mutex
+	next    int
+	entries map[string]tajiEvent
+	runs    map[string]runDetails
+}
+
+func newMemoryTaji(events []tajiEvent) *memoryTaji {
+	m := &memoryTaji{next: 1, entries: make(map[string]tajiEvent), runs: make(map[string]runDetails)}
+	for _, event := range events {
+		m.entries[event.entry] = event
+		if id, err := strconv.Atoi(event.entry); err == nil && id >= m.next {
+			m.next = id + 1
+		}
+	}
+	return m
+}
+
+// Entries lists the log ids newest first, like the participant page.
+func (m *memoryTaji) Entries() ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var entries []string
+	for _, event := range m.sorted() {
+		entries = append(entries, event.entry)
+	}
+	return entries, nil
+}
+
+func (m *memoryTaji) Events(entries []string) (events []tajiEvent, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, log_id := range entries {
+		event, ok := m.entries[log_id]
+		if !ok {
+			return nil, fmt.Errorf("entry %s: 404 Not Found", log_id)
+		}
+		events = append(events, event)
+	}
+	return
+}
+
+func (m *memoryTaji) Post(run runDetails) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	log_id := strconv.Itoa(m.next)
+	m.next++
+	m.store(log_id, run)
+	return log_id, nil
+}
+
+func (m *memoryTaji) Update(log_id string, run runDetails) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.entries[log_id]; !ok {
+		return fmt.Errorf("updating entry %s failed: 404 Not Found", log_id)
+	}
+	m.store(log_id, run)
+	return nil
+}
+
+func (m *memoryTaji) Delete(log_id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.entries[log_id]; !ok {
+		return fmt.Errorf("deleting entry %s failed: 404 Not Found", log_id)
+	}
+	delete(m.entries, log_id)
+	delete(m.runs, log_id)
+	return nil
+}
+
+// store keeps a run as Taji would show it. The caller holds m.mu.
+func (m *memoryTaji) store(log_id string, run runDetails) {
+	m.entries[log_id] = tajiEvent{date: run.date, time: run.time, entry: log_id,
+		distance: run.distance, duration: run.duration, key: idempotencyKey(run), activity: run.activity, elevation: run.elevation_gain}
+	m.runs[log_id] = run
+}
+
+// sorted returns the entries newest first. The caller holds m.mu.
+func (m *memoryTaji) sorted() []tajiEvent {
+	events := slices.Collect(maps.Values(m.entries))
+	slices.SortFunc(events, func(a, b tajiEvent) int {
+		return cmp.Or(cmp.Compare(b.date, a.date), cmp.Compare(b.time, a.time), cmp.Compare(a.entry, b.entry))
+	})
+	return events
+}
+
+// writePage renders the pseudo participant page: a table of the entries
+// with their totals.
+func (m *memoryTaji) writePage(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	events := m.sorted()
+	total := 0.0
+	fmt.Fprintln(w, `<table class="log">`)
+	fmt.Fprintln(w, `  <tr><th>Entry</th><th>Activity</th><th>Date</th><th>Time</th><th>Distance</th><th>Duration</th></tr>`)
+	for _, event := range events {
+		distance, _ := strconv.ParseFloat(event.distance, 64)
+		total += distance
+		fmt.Fprintf(w, "  <tr><td><a href=\"/log/%s/edit\">%s</a></td><td>%s</td><td>%s</td><td>%s</td><td>%s</td><td>%s</td></tr>\n",
+			event.entry, event.entry, html.EscapeString(m.runs[event.entry].activity), event.date, event.time, event.distance, event.duration)
+	}
+	fmt.Fprintf(w, "  <tr><th colspan=\"4\">%d entries</th><th>%s</th><th></th></tr>\n", len(events), strconv.FormatFloat(total, 'f', 2, 64))
+	fmt.Fprintln(w, `</table>`)
+}
+
+// memoryStorage keeps a ledger that is thrown away on exit, for runs that
+// mustn't touch the real one (sync --demo).
+type memoryStorage struct{}
+
+func (memoryStorage) load(state *stateStore) error { return nil }
+func (memoryStorage) save(state *stateStore) error { return nil }
+func (memoryStorage) String() string               { return "memory" }
+
+// memoryState is an empty ledger in memoryStorage, which never fails to
+// load.
+func memoryState(c clock) *stateStore {
+	state, _ := loadState(memoryStorage{}, c)
+	return state
+}
@@ -0,0 +1,181 @@
+package taju
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	tajusync "github.com/tajuploader/sync"
+)
+
+// The sites of an embedding program stand in for the Strava accounts and
+// the Taji login of its configuration, see tajusync.Config. Their
+// activities get the intake of every source, and the entries of its Taji are read back with the values the form
+// would show.
+
+// stravaSite is a tajusync.Strava of the embedding program.
+type stravaSite struct {
+	source   tajusync.Strava
+	settings *settings
+	run      runContext
+	intake
+}
+
+// tajiSite is the tajusync.Taji of the embedding program. entries are
+// those of the last listing, which Events reads.
+type tajiSite struct {
+	site     tajusync.Taji
+	settings *settings
+	zone     *time.Location
+	run      runContext
+	mu       sync.Mutex
+	entries  map[string]tajusync.Activity
+}
+
+// useSites puts the sites of config in place of those of s.
+func useSites(s *syncer, config tajusync.Config) error {
+	u := s.u
+	if config.Taji != nil {
+		zone, err := loadTimezone(u.env)
+		if err != nil {
+			return err
+		}
+		s.taji = &tajiSite{site: config.Taji, settings: u.settings, zone: zone}
+	}
+	if len(config.Strava) == 0 {
+		return nil
+	}
+	start, end, err := eventWindow(u.env, u.clock.Now())
+	if err != nil {
+		return err
+	}
+	in, err := loadIntake(u.env, u.settings, start, end)
+	if err != nil {
+		return err
+	}
+	for _, source := range config.Strava {
+		s.strava = append(s.strava, &stravaSite{source: source, settings: u.settings, intake: in})
+	}
+	return nil
+}
+
+func (s *stravaSite) bindContext(ctx context.Context) {
+	s.run.bindContext(ctx)
+}
+
+// Activities are those of the source the intake keeps. One that fails the
+// pipeline is skipped with an error, and the activities are then partial.
+func (s *stravaSite) Activities() (runs []runDetails, partial bool, err error) {
+	activities, partial, err := s.source.Activities(s.run.context())
+	if err != nil {
+		return nil, true, err
+	}
+	by_id := make(map[int64]tajusync.Activity)
+	var sources []sourceActivity
+	for _, activity := range activities {
+		by_id[activity.StravaID] = activity
+		sources = append(sources, sourceActivity{id: activity.StravaID, name: activity.Name, description: activity.Description,
+			private: activity.Private, commute: activity.Commute, race: activity.Race, gear: activity.Gear, device: activity.Device,
+			activity: activity.Type, start: activity.Start, distance: activity.Distance, elapsed: int64(activity.Duration / time.Second)})
+	}
+	var errs []error
+	for _, source := range s.pick(sources) {
+		activity := by_id[source.id]
+		run := s.settings.createRun(activity.Type, activity.Start.Format(time.RFC3339), int64(activity.Duration/time.Second), activity.Distance)
+		run.strava_id = activity.StravaID
+		run.location = activity.Start.Location()
+		run.elevation_float = activity.Elevation
+		parts, err := s.runs(run, s.pipeline.apply)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("activity %d: %w", activity.StravaID, err))
+			continue
+		}
+		runs = append(runs, parts...)
+	}
+	sortRuns(runs)
+	return runs, partial || len(errs) > 0, errors.Join(errs...)
+}
+
+func (t *tajiSite) bindContext(ctx context.Context) {
+	t.run.bindContext(ctx)
+}
+
+// Entries lists the ids newest first, like the participant page.
+func (t *tajiSite) Entries() ([]string, error) {
+	entries, err := t.site.Entries(t.run.context())
+	if err != nil {
+		return nil, err
+	}
+	slices.SortStableFunc(entries, func(a, b tajusync.Entry) int {
+		return b.Activity.Start.Compare(a.Activity.Start)
+	})
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.entries = make(map[string]tajusync.Activity)
+	var log_ids []string
+	for _, entry := range entries {
+		t.entries[entry.ID] = entry.Activity
+		log_ids = append(log_ids, entry.ID)
+	}
+	return log_ids, nil
+}
+
+func (t *tajiSite) Events(entries []string) (events []tajiEvent, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, log_id := range entries {
+		activity, ok := t.entries[log_id]
+		if !ok {
+			return nil, fmt.Errorf("entry %s isn't listed", log_id)
+		}
+		run := t.loggedRun(activity)
+		events = append(events, tajiEvent{date: run.date, time: run.time, entry: log_id, distance: run.distance,
+			duration: run.duration, key: idempotencyKey(run), activity: run.activity, elevation: run.elevation_gain})
+	}
+	return
+}
+
+func (t *tajiSite) Post(run runDetails) (string, error) {
+	return t.site.Post(t.run.context(), t.loggedActivity(run))
+}
+
+func (t *tajiSite) Update(log_id string, run runDetails) error {
+	return t.site.Update(t.run.context(), log_id, t.loggedActivity(run))
+}
+
+func (t *tajiSite) Delete(log_id string) error {
+	return t.site.Delete(t.run.context(), log_id)
+}
+
+// loggedActivity is run as it is logged: starting at the date and clock
+// time of the form, with the elevation only if it is posted.
+func (t *tajiSite) loggedActivity(run runDetails) tajusync.Activity {
+	activity := activityOf(run)
+	layout := "2006-01-02 " + t.settings.taji_units.timeLayout()
+	if start, err := time.ParseInLocation(layout, run.date+" "+run.time, runLocation(t.zone, run)); err == nil {
+		activity.Start = start
+	}
+	if run.elevation_gain == "" {
+		activity.Elevation = 0
+	}
+	return activity
+}
+
+// loggedRun holds the values the form shows for an entry, the way the
+// pipeline writes them.
+func (t *tajiSite) loggedRun(activity tajusync.Activity) runDetails {
+	run := runDetails{strava_id: activity.StravaID, part: activity.Part, activity: activity.Type,
+		duration_int: int64(activity.Duration / time.Second), distance_float: activity.Distance, elevation_float: activity.Elevation}
+	t.settings.setClockTime(&run, activity.Start)
+	if activity.Distance > 0 {
+		run.distance = t.settings.tajiDistance(activity.Distance)
+	}
+	durationTransform(&run)
+	if activity.Elevation > 0 {
+		run.elevation_gain = fmt.Sprintf("%.0f", meter2feet(activity.Elevation))
+	}
+	return run
+}
@@ -0,0 +1,88 @@
+package taju
+
+import (
+	"fmt"
+	"time"
+)
+
+// progressSnapshot is the progress of the event as of one cycle, worked out
+// once in measure and shown as it is by the console summary, the dashboard,
+// the status page, the logs and the notifications, so they never disagree
+// about a total. It is part of cycleResult.
+type progressSnapshot struct {
+	progress goalStatus
+	climb    goalStatus
+	// encouragement is the message for the progress, see encouragements.
+	encouragement string
+
+	// miles and seconds add up everything logged, unweighted;
+	// recorded_miles is the same before TAJU_DISTANCE_ADJUST.
+	miles          float64
+	recorded_miles float64
+	seconds        int64
+	totals         []activityTotal
+	points         float64
+	days           []dailyDistance
+	// units are the display units of the configuration.
+	units units
+}
+
+func takeSnapshot(u *uploader, logged []runDetails) (snapshot progressSnapshot) {
+	now, start, end := goalWindow(u)
+	snapshot.progress = u.goal.status(logged, now, start, end)
+	snapshot.climb = u.goal.climbStatus(logged, now, start, end)
+	snapshot.encouragement = encouragement(u, logged)
+	for _, run := range logged {
+		snapshot.miles += meter2mile(run.distance_float)
+		snapshot.recorded_miles += meter2mile(run.recorded())
+		snapshot.seconds += run.duration_int
+	}
+	snapshot.totals = activityTotals(logged, u.scoring)
+	for _, total := range snapshot.totals {
+		snapshot.points += total.points
+	}
+	snapshot.days = dailyTotals(logged, u.settings.display, now, start, end)
+	snapshot.units = u.settings.display
+	return
+}
+
+// goals are the goals that are set, distance first.
+func (p progressSnapshot) goals() (goals []goalStatus) {
+	for _, status := range []goalStatus{p.progress, p.climb} {
+		if status.set() {
+			goals = append(goals, status)
+		}
+	}
+	return
+}
+
+// recordedNote is the recorded distance when TAJU_DISTANCE_ADJUST changed
+// it, "" otherwise.
+func (p progressSnapshot) recordedNote() string {
+	miles, recorded := fmt.Sprintf("%.2f", p.units.fromMiles(p.miles)), fmt.Sprintf("%.2f", p.units.fromMiles(p.recorded_miles))
+	if miles == recorded {
+		return ""
+	}
+	return fmt.Sprintf("%s %s recorded", recorded, p.units.name())
+}
+
+// dailyTotals adds up runs per day of the event window in display, with
+// the days without an activity as zeros so charts don't skip them. Days
+// after today are left out.
+func dailyTotals(runs []runDetails, display units, now time.Time, start time.Time, end time.Time) (series []dailyDistance) {
+	days := make(map[string]dailyDistance)
+	for _, run := range runs {
+		day := days[run.date]
+		day.Distance += display.fromMeters(run.distance_float)
+		day.Activities++
+		days[run.date] = day
+	}
+	var total float64
+	for date := start; date.Before(end) && !date.After(now); date = date.AddDate(0, 0, 1) {
+		day := days[date.Format(DATE_FORMAT)]
+		total += day.Distance
+		series = append(series, dailyDistance{Time: date, Date: date.Format(DATE_FORMAT), Distance: round2(day.Distance),
+			Total: round2(total), Activities: day.Activities, Unit: display.name()})
+	}
+	return series
+}
@@ -0,0 +1,106 @@
+package taju
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+)
+
+const SPECIAL_DAYS_FILENAME string = "taju.days.json"
+
+// specialDay is an event day with its own category or bonus, like the
+// virtual ruck march. The calendar is a data file (TAJU_SPECIAL_DAYS_FILE,
+// taju.days.json by default) so it can follow the event's announcements:
+//
+//	{
+//	  "days": [
+//	    {"date": "2026-02-22", "name": "Virtual ruck march", "activity": "ruck",
+//	     "from": ["run", "walk"], "bonus": 2}
+//	  ]
+//	}
+//
+// On that date, activities of the categories in from (every category
+// without it) are posted as activity, and bonus multiplies the points of
+// the day's activities (see pointsRules). Without the file there are no
+// special days.
+type specialDay struct {
+	Date     string   `json:"date"`
+	Name     string   `json:"name"`
+	Activity string   `json:"activity,omitempty"`
+	From     []string `json:"from,omitempty"`
+	Bonus    float64  `json:"bonus,omitempty"`
+}
+
+// specialDays are the special days by date.
+type specialDays map[string]specialDay
+
+func loadSpecialDays(env map[string]string) (specialDays, error) {
+	path := env["TAJU_SPECIAL_DAYS_FILE"]
+	if path == "" {
+		path = SPECIAL_DAYS_FILENAME
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && env["TAJU_SPECIAL_DAYS_FILE"] == "" {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error loading %s: %w", path, err)
+	}
+	var calendar struct {
+		Days []specialDay `json:"days"`
+	}
+	if err := json.Unmarshal(data, &calendar); err != nil {
+		return nil, fmt.Errorf("error loading %s: %w", path, err)
+	}
+	days := make(specialDays)
+	for _, day := range calendar.Days {
+		if _, err := time.Parse(DATE_FORMAT, day.Date); err != nil {
+			return nil, fmt.Errorf("invalid date %q in %s, expected YYYY-MM-DD", day.Date, path)
+		}
+		if day.Bonus < 0 {
+			return nil, fmt.Errorf("invalid bonus %g for %s in %s", day.Bonus, day.Date, path)
+		}
+		if _, ok := days[day.Date]; ok {
+			return nil, fmt.Errorf("%s is listed twice in %s", day.Date, path)
+		}
+		days[day.Date] = day
+	}
+	return days, nil
+}
+
+// recategorizes reports whether the day posts activities of the category
+// as its own.
+func (d specialDay) recategorizes(activity string) bool {
+	return d.Activity != "" && (len(d.From) == 0 || slices.Contains(d.From, activity))
+}
+
+// earnsBonus reports whether an activity posted in the category gets the
+// day's bonus.
+func (d specialDay) earnsBonus(activity string) bool {
+	if d.Bonus == 0 {
+		return false
+	}
+	if d.Activity != "" {
+		return activity == d.Activity
+	}
+	return len(d.From) == 0 || slices.Contains(d.From, activity)
+}
+
+// specialDaysTransform posts the activities of a special day in its
+// category. It runs after time, which dates the run, and before overrides,
+// so TAJU_OVERRIDE_<id>=activity=... still has the last word.
+func specialDaysTransform(env map[string]string, _ *settings) (runTransform, error) {
+	days, err := loadSpecialDays(env)
+	if err != nil {
+		return runTransform{}, err
+	}
+	return runTransform{"special", func(run *runDetails) error {
+		if day, ok := days[run.date]; ok && day.recategorizes(run.activity) {
+			run.activity = day.Activity
+		}
+		return nil
+	}}, nil
+}
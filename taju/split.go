@@ -0,0 +1,157 @@
+package taju
+
+import (
+	"fmt"
+	"time"
+)
+
+// Taji100 scores per day. TAJU_SPLIT_MIDNIGHT=true posts an activity that
+// runs past midnight (an ultra, a night hike) as one entry per day, with
+// the distance shared out by time, and TAJU_DAILY_CAP_MILES caps the miles
+// logged per day across all activities. Each part is its own Taji entry
+// with its own idempotency key, and the ledger keeps them under Parts.
+// TAJU_DAILY_SUMMARY=true goes the other way, see combineDaily.
+type splitRules struct {
+	midnight    bool
+	zone        *time.Location
+	daily_miles float64
+	summary     bool
+	// settings write the distances of capped and combined runs.
+	settings *settings
+}
+
+func loadSplitRules(env map[string]string, s *settings) (splitRules, error) {
+	zone, err := loadTimezone(env)
+	if err != nil {
+		return splitRules{}, err
+	}
+	rules := splitRules{midnight: envBool(env, "TAJU_SPLIT_MIDNIGHT"), zone: zone, summary: envBool(env, "TAJU_DAILY_SUMMARY"), settings: s}
+	if value, ok := env["TAJU_DAILY_CAP_MILES"]; ok {
+		miles, err := parseMiles(value)
+		if err != nil || miles <= 0 {
+			return splitRules{}, fmt.Errorf("invalid TAJU_DAILY_CAP_MILES=%q, expected a positive number", value)
+		}
+		rules.daily_miles = miles
+	}
+	return rules, nil
+}
+
+// split cuts a run at every midnight it spans, in the timezone it is dated
+// in (see clockTimeTransform). Runs within one day come back as they are.
+func (r splitRules) split(run runDetails) []runDetails {
+	if !r.midnight || run.duration_int <= 0 {
+		return []runDetails{run}
+	}
+	location := runLocation(r.zone, run)
+	start := run.start.In(location)
+	end := start.Add(time.Duration(run.duration_int) * time.Second)
+
+	var parts []runDetails
+	for part_start := start; part_start.Before(end); {
+		part_end := time.Date(part_start.Year(), part_start.Month(), part_start.Day()+1, 0, 0, 0, 0, location)
+		if part_end.After(end) {
+			part_end = end
+		}
+		share := part_end.Sub(part_start).Seconds() / float64(run.duration_int)
+		part := run
+		part.start = part_start
+		part.duration_int = int64(part_end.Sub(part_start).Seconds())
+		part.distance_float = run.distance_float * share
+		part.elevation_float = run.elevation_float * share
+		parts = append(parts, part)
+		part_start = part_end
+	}
+	if len(parts) < 2 {
+		return []runDetails{run}
+	}
+	for i := range parts {
+		parts[i].part = i + 1
+		// The photo goes with the first part only.
+		if i > 0 {
+			parts[i].photo_url = ""
+		}
+	}
+	return parts
+}
+
+// capDaily lowers the distance of runs, in date order, so no day has more
+// than TAJU_DAILY_CAP_MILES. Runs of a day that is already full are
+// dropped with the reason.
+func (r splitRules) capDaily(runs []runDetails, skipped func(run runDetails, reason string)) []runDetails {
+	if r.daily_miles <= 0 {
+		return runs
+	}
+	days := make(map[string]float64)
+	var capped []runDetails
+	for _, run := range runs {
+		miles := meter2mile(run.distance_float)
+		left := r.daily_miles - days[run.date]
+		if left <= 0 && miles > 0 {
+			skipped(run, fmt.Sprintf("daily cap of %.2f miles reached", r.daily_miles))
+			continue
+		}
+		if miles > left {
+			run.distance_float = left / meter2mile(1)
+			run.distance = r.settings.tajiDistance(run.distance_float)
+			miles = left
+		}
+		days[run.date] += miles
+		capped = append(capped, run)
+	}
+	return capped
+}
+
+// combineDaily posts the activities of one type on one day as a single
+// entry, for watches that cut one outing into several Strava activities:
+// the distance, duration and elevation are added up and the entry starts
+// when the first of them did. The entry goes by the first activity's id,
+// so an activity recorded later that day updates it instead of adding one.
+func (r splitRules) combineDaily(runs []runDetails) []runDetails {
+	if !r.summary {
+		return runs
+	}
+	var combined []runDetails
+	days := make(map[string]int)
+	for _, run := range runs {
+		day := run.date + " " + run.activity
+		i, ok := days[day]
+		if !ok {
+			days[day] = len(combined)
+			combined = append(combined, run)
+			continue
+		}
+		first := &combined[i]
+		first.distance_float += run.distance_float
+		first.duration_int += run.duration_int
+		first.elevation_float += run.elevation_float
+		if first.elevation_gain != "" || run.elevation_gain != "" {
+			first.elevation_gain = fmt.Sprintf("%.0f", meter2feet(first.elevation_float))
+		}
+		distanceTransform(nil, r.settings).apply(first)
+		durationTransform(first)
+		sportFormsTransform(nil, r.settings).apply(first)
+	}
+	return combined
+}
+
+// summaryCursor moves a fetch back to the start of its day, so a day's
+// combined entry is added up from all of its activities again.
+func (r splitRules) summaryCursor(after time.Time) time.Time {
+	if !r.summary {
+		return after
+	}
+	location := runLocation(r.zone, runDetails{})
+	day := after.In(location)
+	return time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, location)
+}
+
+// runLocation is the timezone a run is dated in.
+func runLocation(zone *time.Location, run runDetails) *time.Location {
+	switch {
+	case zone != nil:
+		return zone
+	case run.location != nil:
+		return run.location
+	}
+	return time.Local
+}
@@ -0,0 +1,144 @@
+package taju
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestSplitMidnight(t *testing.T) {
+	new_york, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	type part struct {
+		start    string
+		seconds  int64
+		distance float64
+	}
+	tests := []struct {
+		name  string
+		env   map[string]string
+		start time.Time
+		hours float64
+		want  []part
+	}{
+		{
+			name: "off by default", env: map[string]string{},
+			start: time.Date(2026, 2, 10, 23, 0, 0, 0, new_york), hours: 2,
+			want: []part{{"2026-02-10 23:00", 7200, 12000}},
+		},
+		{
+			name: "within a day", env: map[string]string{"TAJU_SPLIT_MIDNIGHT": "true"},
+			start: time.Date(2026, 2, 10, 6, 0, 0, 0, new_york), hours: 2,
+			want: []part{{"2026-02-10 06:00", 7200, 12000}},
+		},
+		{
+			name: "across midnight, shared out by time", env: map[string]string{"TAJU_SPLIT_MIDNIGHT": "true"},
+			start: time.Date(2026, 2, 10, 23, 30, 0, 0, new_york), hours: 2,
+			want: []part{{"2026-02-10 23:30", 1800, 3000}, {"2026-02-11 00:00", 5400, 9000}},
+		},
+		{
+			name: "a 30 hour ultra", env: map[string]string{"TAJU_SPLIT_MIDNIGHT": "true"},
+			start: time.Date(2026, 2, 10, 18, 0, 0, 0, new_york), hours: 30,
+			// It ends at midnight, so there is no third part.
+			want: []part{{"2026-02-10 18:00", 6 * 3600, 36000}, {"2026-02-11 00:00", 24 * 3600, 144000}},
+		},
+		{
+			// 23:30 in New York is 13:30 the next day in Tokyo.
+			name: "in TAJU_TIMEZONE", env: map[string]string{"TAJU_SPLIT_MIDNIGHT": "true", "TAJU_TIMEZONE": "Asia/Tokyo"},
+			start: time.Date(2026, 2, 10, 23, 30, 0, 0, new_york), hours: 2,
+			want: []part{{"2026-02-11 13:30", 7200, 12000}},
+		},
+		{
+			name: "in the activity's timezone", env: map[string]string{"TAJU_SPLIT_MIDNIGHT": "true"},
+			start: time.Date(2026, 2, 10, 23, 0, 0, 0, tokyo), hours: 2,
+			want: []part{{"2026-02-10 23:00", 3600, 6000}, {"2026-02-11 00:00", 3600, 6000}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			seconds := int64(test.hours * 3600)
+			run := runDetails{strava_id: 9, start: test.start.UTC(), location: test.start.Location(), duration_int: seconds,
+				distance_float: 6000 * test.hours, elevation_float: 10 * test.hours, photo_url: "https://example.com/photo.jpg"}
+			parts := splitRulesOf(t, test.env).split(run)
+			timezone, err := loadTimezone(test.env)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(parts) != len(test.want) {
+				t.Fatalf("split into %d parts, want %d", len(parts), len(test.want))
+			}
+			var total float64
+			for i, part := range parts {
+				location := runLocation(timezone, part)
+				got := part.start.In(location).Format("2006-01-02 15:04")
+				if got != test.want[i].start || part.duration_int != test.want[i].seconds || math.Abs(part.distance_float-test.want[i].distance) > 0.001 {
+					t.Errorf("part %d starts %s for %ds over %.0f m, want %+v", i+1, got, part.duration_int, part.distance_float, test.want[i])
+				}
+				total += part.distance_float
+				if len(parts) > 1 && (part.part != i+1 || (i > 0) == (part.photo_url != "")) {
+					t.Errorf("part %d is numbered %d with photo %q", i+1, part.part, part.photo_url)
+				}
+				if part.strava_id != run.strava_id {
+					t.Errorf("part %d lost the Strava id", i+1)
+				}
+			}
+			if math.Abs(total-run.distance_float) > 0.001 {
+				t.Errorf("parts add up to %.3f m of %.3f", total, run.distance_float)
+			}
+			if len(parts) == 1 && parts[0].part != 0 {
+				t.Errorf("an unsplit run is part %d", parts[0].part)
+			}
+		})
+	}
+}
+
+func TestCapDaily(t *testing.T) {
+	mile := 1 / meter2mile(1)
+	runs := []runDetails{
+		{strava_id: 1, date: "2026-02-10", distance_float: 6 * mile},
+		{strava_id: 2, date: "2026-02-10", distance_float: 6 * mile},
+		{strava_id: 3, date: "2026-02-10", distance_float: 2 * mile},
+		// Duration-only activities count nothing towards the cap.
+		{strava_id: 4, date: "2026-02-10"},
+		{strava_id: 5, date: "2026-02-11", distance_float: 12 * mile},
+	}
+	var skipped []int64
+	capped := splitRulesOf(t, map[string]string{"TAJU_DAILY_CAP_MILES": "10"}).capDaily(runs, func(run runDetails, reason string) {
+		skipped = append(skipped, run.strava_id)
+	})
+
+	want := map[int64]float64{1: 6, 2: 4, 4: 0, 5: 10}
+	if len(capped) != len(want) {
+		t.Fatalf("kept %d runs, want %d", len(capped), len(want))
+	}
+	for _, run := range capped {
+		if miles := meter2mile(run.distance_float); math.Abs(miles-want[run.strava_id]) > 0.0001 {
+			t.Errorf("run %d capped to %.2f miles, want %.2f", run.strava_id, miles, want[run.strava_id])
+		}
+	}
+	if capped[1].distance != "4.00" || capped[3].distance != "10.00" {
+		t.Errorf("capped distances posted as %q and %q", capped[1].distance, capped[3].distance)
+	}
+	if len(skipped) != 1 || skipped[0] != 3 {
+		t.Errorf("skipped %v, want run 3 of the full day", skipped)
+	}
+
+	if got := splitRulesOf(t, map[string]string{}).capDaily(runs, nil); len(got) != len(runs) {
+		t.Errorf("without a cap %d of %d runs were kept", len(got), len(runs))
+	}
+}
+
+func splitRulesOf(t *testing.T, env map[string]string) splitRules {
+	t.Helper()
+	rules, err := loadSplitRules(env, newSettings())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rules
+}
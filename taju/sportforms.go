@@ -0,0 +1,127 @@
+package taju
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A Taji activity whose form isn't run-shaped, e.g. swims logged in yards
+// or rowing in meters, gets a template of its form values from a
+// TAJU_FORM_<activity> key, e.g.
+//
+//	TAJU_FORM_SWIM=distance={yards}
+//	TAJU_FORM_YOGA=distance=,duration={minutes},duration_hours=,duration_seconds=
+//
+// Each field is a value with placeholders, filled in by the forms
+// transform after the run-shaped fields: {miles}, {km}, {meters} and
+// {yards} are the distance in that unit, {duration} is h:mm:ss, {minutes}
+// and {seconds} the whole duration in those, and {elevation} the elevation
+// gain as posted. Fields the run doesn't have are posted as well, so a
+// form's own unit select can be set. The unit the distance field is
+// written in is also the one Taji entries of the activity are read back in.
+type sportForm struct {
+	fields map[string]string
+	// unit is the distance unit of the distance field, "" for taji_units.
+	unit string
+}
+
+// SPORT_UNITS are the distance placeholders and their units, see
+// DISTANCE_SUFFIXES.
+var SPORT_UNITS = map[string]string{"{miles}": "mi", "{km}": "km", "{meters}": "m", "{yards}": "yd"}
+
+func loadSportForms(env map[string]string) (map[string]sportForm, error) {
+	forms := make(map[string]sportForm)
+	for key, value := range env {
+		activity, ok := strings.CutPrefix(key, "TAJU_FORM_")
+		if !ok {
+			continue
+		}
+		form := sportForm{fields: make(map[string]string)}
+		for _, pair := range splitList(value) {
+			field, template, ok := strings.Cut(pair, "=")
+			if !ok {
+				return nil, fmt.Errorf("invalid %s entry %q, expected field=value", key, pair)
+			}
+			field, template = strings.TrimSpace(field), strings.TrimSpace(template)
+			form.fields[field] = template
+			if field != "distance" {
+				continue
+			}
+			for placeholder, unit := range SPORT_UNITS {
+				if strings.Contains(template, placeholder) {
+					form.unit = unit
+				}
+			}
+		}
+		forms[strings.ToLower(activity)] = form
+	}
+	return forms, nil
+}
+
+// sportUnit is the distance unit entries of an activity are logged in.
+func (s *settings) sportUnit(activity string) string {
+	if form, ok := s.sport_forms[strings.ToLower(activity)]; ok && form.unit != "" {
+		return form.unit
+	}
+	return s.taji_units.distance
+}
+
+// sportFormsTransform fills in the form values of activities with a
+// TAJU_FORM_<activity> template.
+func sportFormsTransform(_ map[string]string, s *settings) runTransform {
+	return runTransform{"forms", func(run *runDetails) error {
+		form, ok := s.sport_forms[strings.ToLower(run.activity)]
+		if !ok {
+			return nil
+		}
+		for field, template := range form.fields {
+			value := form.expand(template, *run, s)
+			switch field {
+			case "distance":
+				run.distance = value
+				run.distance_unit = form.unit
+			case "duration":
+				run.duration = value
+			case "duration_hours":
+				run.duration_hours = value
+			case "duration_minutes":
+				run.duration_minutes = value
+			case "duration_seconds":
+				run.duration_seconds = value
+			case "elevation_gain":
+				run.elevation_gain = value
+			default:
+				if run.form_values == nil {
+					run.form_values = make(map[string]string)
+				}
+				run.form_values[field] = value
+			}
+		}
+		return nil
+	}}
+}
+
+func (f sportForm) expand(template string, run runDetails, s *settings) string {
+	distance := func(unit string) string {
+		if run.distance_float <= 0 {
+			return ""
+		}
+		value := run.distance_float / DISTANCE_SUFFIXES[unit]
+		if unit == "m" || unit == "yd" {
+			return strconv.FormatFloat(value, 'f', 0, 64)
+		}
+		return s.formatDistance(value)
+	}
+	replacer := strings.NewReplacer(
+		"{miles}", distance("mi"),
+		"{km}", distance("km"),
+		"{meters}", distance("m"),
+		"{yards}", distance("yd"),
+		"{duration}", fmt.Sprintf("%d:%02d:%02d", run.duration_int/3600, run.duration_int/60%60, run.duration_int%60),
+		"{minutes}", strconv.FormatInt((run.duration_int+30)/60, 10),
+		"{seconds}", strconv.FormatInt(run.duration_int, 10),
+		"{elevation}", run.elevation_gain,
+	)
+	return replacer.Replace(template)
+}
@@ -0,0 +1,212 @@
+package taju
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// sqliteStorage keeps the ledger in a SQLite database (TAJU_STORAGE=sqlite)
+// so it can be queried, e.g.
+//
+//	sqlite3 taju.state.db "SELECT date, distance, status FROM ledger WHERE section = 'entries' ORDER BY date"
+//
+// Every ledger entry is a row of ledger; the rest of the state is a JSON
+// value per section in state. A new database starts from the JSON ledger
+// it replaces, so switching backends keeps the history.
+type sqliteStorage struct {
+	path     string
+	imported jsonStorage
+}
+
+const SQLITE_SCHEMA string = `
+CREATE TABLE IF NOT EXISTS ledger (
+	section        TEXT NOT NULL,
+	entry_key      TEXT NOT NULL,
+	strava_id      INTEGER NOT NULL,
+	part           INTEGER NOT NULL,
+	log_id         TEXT NOT NULL,
+	status         TEXT NOT NULL,
+	activity       TEXT NOT NULL,
+	date           TEXT NOT NULL,
+	time           TEXT NOT NULL,
+	distance       TEXT NOT NULL,
+	duration       TEXT NOT NULL,
+	elevation_gain TEXT NOT NULL,
+	unkeyed        INTEGER NOT NULL,
+	error          TEXT NOT NULL,
+	listed         TEXT NOT NULL DEFAULT '',
+	updated_at     TEXT,
+	uploaded_at    TEXT,
+	PRIMARY KEY (section, entry_key)
+);
+CREATE TABLE IF NOT EXISTS state (
+	section TEXT PRIMARY KEY,
+	value   TEXT NOT NULL
+);`
+
+// SQLITE_MIGRATIONS add the columns of ledgerEntry that databases created
+// before them lack, keyed by column.
+var SQLITE_MIGRATIONS = []struct{ column, add string }{
+	{"listed", `ALTER TABLE ledger ADD COLUMN listed TEXT NOT NULL DEFAULT ''`},
+}
+
+const SQLITE_LEDGER_COLUMNS string = `section, entry_key, strava_id, part, log_id, status, activity, date, time, distance, duration,
+	elevation_gain, unkeyed, error, listed, updated_at, uploaded_at`
+
+func (s sqliteStorage) String() string {
+	return s.path
+}
+
+func (s sqliteStorage) open() (*sql.DB, error) {
+	db, err := sql.Open("sqlite", "file:"+s.path+"?_pragma=busy_timeout(10000)")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(SQLITE_SCHEMA); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err := migrateSqlite(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+func migrateSqlite(db *sql.DB) error {
+	for _, migration := range SQLITE_MIGRATIONS {
+		var found int
+		if err := db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('ledger') WHERE name = ?`, migration.column).Scan(&found); err != nil {
+			return err
+		}
+		if found > 0 {
+			continue
+		}
+		if _, err := db.Exec(migration.add); err != nil {
+			return fmt.Errorf("adding the %s column to the ledger: %w", migration.column, err)
+		}
+	}
+	return nil
+}
+
+func (s sqliteStorage) load(state *stateStore) error {
+	if _, err := os.Stat(s.path); errors.Is(err, os.ErrNotExist) {
+		return s.imported.load(state)
+	}
+	db, err := s.open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ledgers := make(map[string]map[string]*ledgerEntry)
+	rows, err := db.Query(`SELECT ` + SQLITE_LEDGER_COLUMNS + ` FROM ledger`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var section, key string
+		var updated, uploaded sql.NullString
+		e := new(ledgerEntry)
+		if err := rows.Scan(&section, &key, &e.StravaId, &e.Part, &e.LogId, &e.Status, &e.Activity, &e.Date, &e.Time, &e.Distance,
+			&e.Duration, &e.Elevation, &e.Unkeyed, &e.Error, &e.Listed, &updated, &uploaded); err != nil {
+			return err
+		}
+		if e.UpdatedAt, err = sqliteTime(updated); err != nil {
+			return err
+		}
+		if e.UploadedAt, err = sqliteTime(uploaded); err != nil {
+			return err
+		}
+		if ledgers[section] == nil {
+			ledgers[section] = make(map[string]*ledgerEntry)
+		}
+		ledgers[section][key] = e
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	other := make(map[string]json.RawMessage)
+	sections, err := db.Query(`SELECT section, value FROM state`)
+	if err != nil {
+		return err
+	}
+	defer sections.Close()
+	for sections.Next() {
+		var section, value string
+		if err := sections.Scan(&section, &value); err != nil {
+			return err
+		}
+		other[section] = json.RawMessage(value)
+	}
+	if err := sections.Err(); err != nil {
+		return err
+	}
+	return loadSections(state, ledgers, other)
+}
+
+// save replaces the stored state in one transaction, so a crash mid-save
+// leaves the previous one.
+func (s sqliteStorage) save(state *stateStore) error {
+	ledgers, other, err := stateSections(state)
+	if err != nil {
+		return err
+	}
+	db, err := s.open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`DELETE FROM ledger; DELETE FROM state`); err != nil {
+		return err
+	}
+	insert, err := tx.Prepare(`INSERT INTO ledger (` + SQLITE_LEDGER_COLUMNS + `) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer insert.Close()
+	for section, entries := range ledgers {
+		for key, e := range entries {
+			if _, err := insert.Exec(section, key, e.StravaId, e.Part, e.LogId, e.Status, e.Activity, e.Date, e.Time, e.Distance,
+				e.Duration, e.Elevation, e.Unkeyed, e.Error, e.Listed, sqliteValue(e.UpdatedAt), sqliteValue(e.UploadedAt)); err != nil {
+				return err
+			}
+		}
+	}
+	for section, value := range other {
+		if _, err := tx.Exec(`INSERT INTO state VALUES (?, ?)`, section, string(value)); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// sqliteValue stores a time as RFC 3339 text, which sorts and compares in
+// SQL, and a zero time as NULL.
+func sqliteValue(t time.Time) sql.NullString {
+	if t.IsZero() {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: t.Format(time.RFC3339Nano), Valid: true}
+}
+
+func sqliteTime(value sql.NullString) (time.Time, error) {
+	if !value.Valid {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339Nano, value.String)
+}
@@ -0,0 +1,458 @@
+package taju
+
+import (
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
+
+const STATE_FILENAME string = "taju.state.json"
+
+const (
+	STATE_POSTING  string = "posting"
+	STATE_UPLOADED string = "uploaded"
+	STATE_FAILED   string = "failed"
+)
+
+// ledgerEntry records what happened to one Strava activity. LogId is empty
+// until the Taji entry has been seen on the participant page.
+type ledgerEntry struct {
+	StravaId int64  `json:"strava_id"`
+	Part     int    `json:"part,omitempty"`
+	LogId    string `json:"log_id,omitempty"`
+	Status   string `json:"status"`
+	Activity string `json:"activity"`
+	Date     string `json:"date"`
+	Time     string `json:"time"`
+	Distance string `json:"distance"`
+	Duration string `json:"duration"`
+	// Elevation is the elevation gain posted, in feet.
+	Elevation string `json:"elevation_gain,omitempty"`
+	// Unkeyed marks Taji entries that were matched by date and time but
+	// weren't posted by the uploader, so they carry no idempotency key.
+	Unkeyed bool `json:"unkeyed,omitempty"`
+	// Error is why Taji rejected the last post, see recordFailure.
+	Error string `json:"error,omitempty"`
+	// Listed is the digest of the entry's row on the participant page when
+	// its values were last known, see knownEvents.
+	Listed    string    `json:"listed,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// UploadedAt is when the entry was first seen on Taji.
+	UploadedAt time.Time `json:"uploaded_at,omitempty"`
+}
+
+// stateStore is a JSON ledger of uploaded activities keyed by Strava id. It
+// is the primary dedupe source: entries it already maps to a Taji log id
+// don't need their edit page scraped every cycle.
+type stateStore struct {
+	mu      sync.Mutex
+	storage stateStorage
+	clock   clock
+	Entries map[int64]*ledgerEntry `json:"entries"`
+
+	// Parts holds the entries of activities split across days, keyed by
+	// Strava id and part (see partKey).
+	Parts map[string]*ledgerEntry `json:"parts,omitempty"`
+
+	// Templates holds the last checksum of each scraped Taji page, see
+	// noteTemplate.
+	Templates map[string]string `json:"templates,omitempty"`
+
+	// Pending holds possible duplicates waiting for `taju resolve`, keyed
+	// by Strava id, and the answers given.
+	Pending map[int64]*pendingMatch `json:"pending,omitempty"`
+
+	// Review holds activities stopped by the guard rails, keyed by Strava
+	// id, and the decisions made in `taju review`.
+	Review map[int64]*reviewItem `json:"review,omitempty"`
+
+	// Scraped caches the Taji entries that aren't in the ledger (logged on
+	// the site, or not matched to a Strava activity yet), keyed by log id,
+	// so their edit pages are read only once.
+	Scraped map[string]*scrapedEntry `json:"scraped,omitempty"`
+
+	// Manual holds the entries logged with taju add, keyed by log id. They
+	// have no Strava activity but aren't stray entries either.
+	Manual map[string]*ledgerEntry `json:"manual,omitempty"`
+
+	// LastSync is when Taji was last read, and PausedUntil when automated
+	// changes resume after manual edits on the site, see noteManualEdits.
+	LastSync    time.Time `json:"last_sync,omitempty"`
+	PausedUntil time.Time `json:"paused_until,omitempty"`
+
+	// DistanceStep is the distance increment last seen on the Taji form,
+	// see detectDistanceStep.
+	DistanceStep float64 `json:"distance_step,omitempty"`
+
+	// Standings are the team standings last read, see refreshStandings.
+	Standings *teamStandings `json:"standings,omitempty"`
+
+	// Outbox holds the runs waiting to be posted again, keyed by partKey,
+	// see queuedUpload.
+	Outbox map[string]*queuedUpload `json:"outbox,omitempty"`
+
+	// Stats are the uploader's running totals, see registerStats.
+	Stats *syncStats `json:"stats,omitempty"`
+
+	// Breaker is set while Taji keeps failing, see tajiBreaker.
+	Breaker *breakerState `json:"breaker,omitempty"`
+
+	// TajiOnly holds the entries logged on the Taji site that no Strava
+	// activity matches, keyed by log id, see recordTajiOnly.
+	TajiOnly map[string]*ledgerEntry `json:"taji_only,omitempty"`
+
+	// Forms holds the probed log form of each Taji activity, see
+	// formSchema.
+	Forms map[string]*formSchema `json:"forms,omitempty"`
+}
+
+type scrapedEntry struct {
+	Date     string `json:"date"`
+	Time     string `json:"time"`
+	Distance string `json:"distance"`
+	Duration string `json:"duration"`
+	Key      string `json:"key,omitempty"`
+	// Activity and Elevation are empty for entries cached before they were
+	// read.
+	Activity  string `json:"activity,omitempty"`
+	Elevation string `json:"elevation_gain,omitempty"`
+	// Listed is as in ledgerEntry.
+	Listed string `json:"listed,omitempty"`
+}
+
+// recordManual adds an entry logged by hand, see Manual.
+func (s *stateStore) recordManual(event tajiEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Manual == nil {
+		s.Manual = make(map[string]*ledgerEntry)
+	}
+	s.Manual[event.entry] = &ledgerEntry{LogId: event.entry, Status: STATE_UPLOADED, Activity: event.activity, Date: event.date, Time: event.time,
+		Distance: event.distance, Duration: event.duration, Elevation: event.elevation, UpdatedAt: s.clock.Now(), UploadedAt: s.clock.Now()}
+	delete(s.Scraped, event.entry)
+}
+
+func (s *stateStore) isManual(log_id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.Manual[log_id]
+	return ok
+}
+
+// updateTajiValues stores the values of an entry that was edited on Taji.
+// It returns the Strava activity the entry belongs to, if any.
+func (s *stateStore) updateTajiValues(event tajiEvent) (int64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.Scraped, event.entry)
+	entries := slices.Collect(maps.Values(s.Entries))
+	if manual, ok := s.Manual[event.entry]; ok {
+		entries = append(entries, manual)
+	}
+	for _, entry := range entries {
+		if entry.LogId != event.entry {
+			continue
+		}
+		entry.Date, entry.Time = event.date, event.time
+		entry.Distance, entry.Duration = event.distance, event.duration
+		if event.activity != "" {
+			entry.Activity, entry.Elevation = event.activity, event.elevation
+		}
+		entry.UpdatedAt = s.clock.Now()
+		if entry.StravaId != 0 {
+			return entry.StravaId, true
+		}
+	}
+	return 0, false
+}
+
+// cacheEvents remembers scraped Taji entries, see Scraped. The ledger
+// entries among them take the values read, since the ledger keeps what Taji
+// has (see link) and an entry is only read again once it was edited on the
+// site.
+func (s *stateStore) cacheEvents(events []tajiEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Scraped == nil {
+		s.Scraped = make(map[string]*scrapedEntry)
+	}
+	by_log_id := s.linkedEntries()
+	for _, event := range events {
+		s.Scraped[event.entry] = &scrapedEntry{Date: event.date, Time: event.time, Distance: event.distance, Duration: event.duration, Key: event.key,
+			Activity: event.activity, Elevation: event.elevation}
+		if entry, ok := by_log_id[event.entry]; ok && entry.Status == STATE_UPLOADED {
+			entry.Date, entry.Time = event.date, event.time
+			entry.Distance, entry.Duration = event.distance, event.duration
+			if event.activity != "" {
+				entry.Activity = event.activity
+			}
+			entry.Elevation = event.elevation
+		}
+	}
+}
+
+// linkedEntries indexes the ledger entries that are linked to a Taji entry,
+// including those logged with taju add. The caller holds mu.
+func (s *stateStore) linkedEntries() map[string]*ledgerEntry {
+	by_log_id := make(map[string]*ledgerEntry)
+	for _, entry := range s.Entries {
+		if entry.LogId != "" {
+			by_log_id[entry.LogId] = entry
+		}
+	}
+	for _, entry := range s.Parts {
+		if entry.LogId != "" {
+			by_log_id[entry.LogId] = entry
+		}
+	}
+	for log_id, entry := range s.Manual {
+		if _, ok := by_log_id[log_id]; !ok {
+			by_log_id[log_id] = entry
+		}
+	}
+	return by_log_id
+}
+
+func loadState(storage stateStorage, c clock) (*stateStore, error) {
+	state := &stateStore{storage: storage, clock: c, Entries: make(map[int64]*ledgerEntry)}
+	if err := storage.load(state); err != nil {
+		return nil, fmt.Errorf("error loading %v: %w", storage, err)
+	}
+	if state.Entries == nil {
+		state.Entries = make(map[int64]*ledgerEntry)
+	}
+	return state, nil
+}
+
+func (s *stateStore) save() error {
+	return s.storage.save(s)
+}
+
+// partKey is the Parts key of a part of a split activity.
+func partKey(strava_id int64, part int) string {
+	return fmt.Sprintf("%d/%d", strava_id, part)
+}
+
+// entry returns the ledger entry of a run (or part), nil if there is none
+// and create isn't set. The caller holds s.mu.
+func (s *stateStore) entry(run runDetails, create bool) *ledgerEntry {
+	if run.part == 0 {
+		entry, ok := s.Entries[run.strava_id]
+		if !ok && create {
+			entry = &ledgerEntry{StravaId: run.strava_id}
+			s.Entries[run.strava_id] = entry
+		}
+		return entry
+	}
+	key := partKey(run.strava_id, run.part)
+	entry, ok := s.Parts[key]
+	if !ok && create {
+		if s.Parts == nil {
+			s.Parts = make(map[string]*ledgerEntry)
+		}
+		entry = &ledgerEntry{StravaId: run.strava_id, Part: run.part}
+		s.Parts[key] = entry
+	}
+	return entry
+}
+
+func (s *stateStore) record(run runDetails, status string, log_id string) {
+	if run.strava_id == 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry := s.entry(run, true)
+	entry.Status = status
+	entry.Unkeyed = false
+	entry.Error = ""
+	if log_id != "" {
+		// The ledger owns the entry from now on.
+		entry.LogId = log_id
+		delete(s.Scraped, log_id)
+	}
+	entry.Activity = run.activity
+	entry.Date = run.date
+	entry.Time = run.time
+	entry.Distance = run.distance
+	entry.Duration = run.duration
+	entry.Elevation = run.elevation_gain
+	entry.UpdatedAt = s.clock.Now()
+	if status == STATE_UPLOADED && entry.UploadedAt.IsZero() {
+		entry.UploadedAt = entry.UpdatedAt
+	}
+	if status == STATE_UPLOADED {
+		delete(s.Outbox, partKey(run.strava_id, run.part))
+	}
+}
+
+// recordFailure keeps why a post failed, for taju status. The activity is
+// posted again on the next sync.
+func (s *stateStore) recordFailure(run runDetails, err error) {
+	s.record(run, STATE_FAILED, "")
+	if run.strava_id == 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entry(run, false).Error = redact(err.Error())
+}
+
+// failures lists the ledger entries whose last post failed, oldest first.
+// Those waiting in the outbox are listed with it instead.
+func (s *stateStore) failures() (failed []ledgerEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, entry := range slices.Concat(slices.Collect(maps.Values(s.Entries)), slices.Collect(maps.Values(s.Parts))) {
+		if _, queued := s.Outbox[partKey(entry.StravaId, entry.Part)]; entry.Status == STATE_FAILED && !queued {
+			failed = append(failed, *entry)
+		}
+	}
+	slices.SortFunc(failed, func(a, b ledgerEntry) int { return a.UpdatedAt.Compare(b.UpdatedAt) })
+	return
+}
+
+// uploaded reports whether the ledger has a run on Taji.
+func (s *stateStore) uploaded(run runDetails) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry := s.entry(run, false)
+	return entry != nil && entry.Status == STATE_UPLOADED
+}
+
+// link records that run is on Taji as event. The ledger keeps the values
+// Taji has rather than the run's, so a later edit on Strava still shows up
+// as a difference, see classifyMatch.
+func (s *stateStore) link(run runDetails, event tajiEvent) {
+	run.date, run.time = event.date, event.time
+	run.distance, run.duration = event.distance, event.duration
+	s.record(run, STATE_UPLOADED, event.entry)
+	if event.key == "" && run.strava_id != 0 {
+		s.mu.Lock()
+		s.entry(run, false).Unkeyed = true
+		s.mu.Unlock()
+	}
+}
+
+func (s *stateStore) forget(strava_id int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.Entries, strava_id)
+}
+
+func (s *stateStore) forgetPart(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.Parts, key)
+}
+
+// knownEvents splits the participant page entries into events the ledger
+// already knows and log ids that still have to be scraped. rows are the
+// digests of the entries' rows on the page (see tajiclient.ParseLogRows),
+// nil if the site doesn't list them; a known entry whose row changed since
+// its values were read was edited on the site and is scraped again.
+func (s *stateStore) knownEvents(entries []string, rows map[string]string) (events []tajiEvent, unknown []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	by_log_id := s.linkedEntries()
+	edited := func(log_id, listed string) bool {
+		if row, ok := rows[log_id]; ok && listed != "" && row != listed {
+			log.Printf("Taji entry %s changed on the site, reading it again", log_id)
+			return true
+		}
+		return false
+	}
+	for _, log_id := range entries {
+		entry, ok := by_log_id[log_id]
+		if !ok || entry.Status != STATE_UPLOADED {
+			if cached, ok := s.Scraped[log_id]; ok && !edited(log_id, cached.Listed) {
+				events = append(events, tajiEvent{
+					date:      cached.Date,
+					time:      cached.Time,
+					entry:     log_id,
+					distance:  cached.Distance,
+					duration:  cached.Duration,
+					key:       cached.Key,
+					activity:  cached.Activity,
+					elevation: cached.Elevation,
+				})
+				continue
+			}
+			unknown = append(unknown, log_id)
+			continue
+		}
+		if edited(log_id, entry.Listed) {
+			unknown = append(unknown, log_id)
+			continue
+		}
+		event := tajiEvent{
+			date:      entry.Date,
+			time:      entry.Time,
+			entry:     log_id,
+			distance:  entry.Distance,
+			duration:  entry.Duration,
+			activity:  entry.Activity,
+			elevation: entry.Elevation,
+		}
+		if !entry.Unkeyed && entry.StravaId != 0 {
+			event.key = idempotencyKey(runDetails{strava_id: entry.StravaId, part: entry.Part})
+		}
+		events = append(events, event)
+	}
+	return
+}
+
+// noteListing keeps the row digests of a participant page whose entries
+// were all read, so the next cycle only scrapes the ones edited since, see
+// knownEvents.
+func (s *stateStore) noteListing(rows map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	by_log_id := s.linkedEntries()
+	for log_id, row := range rows {
+		if entry, ok := by_log_id[log_id]; ok {
+			entry.Listed = row
+		}
+		if cached, ok := s.Scraped[log_id]; ok {
+			cached.Listed = row
+		}
+	}
+}
+
+// forgetVanished drops the ledger entries whose Taji entry disappeared
+// (deleted on the site). entries must be the whole participant page, read
+// without an error, or entries that are still there would be forgotten.
+func (s *stateStore) forgetVanished(entries []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	on_page := make(map[string]bool)
+	for _, log_id := range entries {
+		on_page[log_id] = true
+	}
+	for id, entry := range s.Entries {
+		if entry.LogId != "" && !on_page[entry.LogId] {
+			log.Printf("Taji entry %s for Strava activity %d is gone, forgetting it", entry.LogId, id)
+			delete(s.Entries, id)
+		}
+	}
+	for key, entry := range s.Parts {
+		if entry.LogId != "" && !on_page[entry.LogId] {
+			log.Printf("Taji entry %s for part %d of Strava activity %d is gone, forgetting it", entry.LogId, entry.Part, entry.StravaId)
+			delete(s.Parts, key)
+		}
+	}
+	for log_id := range s.Scraped {
+		if !on_page[log_id] {
+			delete(s.Scraped, log_id)
+		}
+	}
+	for log_id := range s.Manual {
+		if !on_page[log_id] {
+			delete(s.Manual, log_id)
+		}
+	}
+}
@@ -0,0 +1,335 @@
+package taju
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+const (
+	STATUS_RECENT   = 15
+	STATUS_ERRORS   = 20
+	STATUS_SYNCED   = 25
+	STATUS_AUTH_TTL = 10 * time.Minute
+)
+
+// statusPage is the web version of the dashboard, served on / of the
+// control server for daemons running headless (e.g. on a NAS): progress,
+// the activities on Taji, pending uploads and errors, with buttons to sync
+// now or authorize Strava and Taji again. It only listens where the control
+// server does, localhost unless TAJU_CONTROL_ADDR opens it up.
+type statusPage struct {
+	mu       sync.Mutex
+	profiles []*statusProfile
+	last     time.Time
+	next     time.Time
+	message  string
+	auths    map[string]statusAuth
+	triggers chan<- string
+	clock    clock
+}
+
+type statusProfile struct {
+	syncer *syncer
+	result cycleResult
+	synced bool
+	recent []dashboardRow
+	errors []statusError
+}
+
+type statusError struct {
+	At      time.Time
+	Message string
+}
+
+// statusAuth is a Strava authorization started from the page, keyed by its
+// state.
+type statusAuth struct {
+	profile  int
+	account  string
+	verifier string
+	redirect string
+	started  time.Time
+}
+
+func newStatusPage(syncers []*syncer, triggers chan<- string, c clock) *statusPage {
+	p := &statusPage{auths: make(map[string]statusAuth), triggers: triggers, clock: c}
+	for _, s := range syncers {
+		profile := &statusProfile{syncer: s}
+		p.profiles = append(p.profiles, profile)
+		subscribe(&s.events, func(e activityDecided) {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			profile.recent = append(profile.recent, dashboardRow{e.run, e.decision, e.result})
+			if len(profile.recent) > STATUS_RECENT {
+				profile.recent = profile.recent[len(profile.recent)-STATUS_RECENT:]
+			}
+		})
+		subscribe(&s.events, func(e errorOccurred) {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			profile.errors = append(profile.errors, statusError{s.u.clock.Now(), redact(e.err.Error())})
+			if len(profile.errors) > STATUS_ERRORS {
+				profile.errors = profile.errors[len(profile.errors)-STATUS_ERRORS:]
+			}
+		})
+		subscribe(&s.events, func(e cycleCompleted) {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			profile.result, profile.synced = e.result, true
+		})
+	}
+	return p
+}
+
+// synced records when the cycles finished and when the next ones are due.
+func (p *statusPage) synced(now time.Time, next time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.last, p.next = now, next
+}
+
+func (p *statusPage) register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /{$}", p.page)
+	mux.HandleFunc("POST /ui/sync", sameOrigin(p.syncNow))
+	mux.HandleFunc("POST /ui/strava/connect", sameOrigin(p.stravaConnect))
+	mux.HandleFunc("GET /strava/callback", p.stravaCallback)
+	mux.HandleFunc("POST /ui/taji/login", sameOrigin(p.tajiLogin))
+	mux.HandleFunc("GET /daily", p.daily)
+}
+
+var statusTemplate = template.Must(template.New("status").Parse(`<!doctype html>
+<html><head><meta charset="utf-8"><meta http-equiv="refresh" content="60"><title>Taj Uploader</title>
+<style>
+body { font-family: sans-serif; max-width: 52em; margin: 2em auto; padding: 0 1em }
+table { border-collapse: collapse; width: 100% } td, th { text-align: left; padding: .2em .6em .2em 0 }
+progress { width: 100% } .failed { color: #b00 } form { display: inline }
+</style></head>
+<body>
+<h1>Taj Uploader</h1>
+{{if .Message}}<p><b>{{.Message}}</b></p>{{end}}
+<p>{{if .Last.IsZero}}Waiting for the first sync.{{else}}Last sync {{.Last.Format "Jan 2 15:04"}}, next {{.Next.Format "Jan 2 15:04"}}.{{end}}
+<form method="post" action="/ui/sync"><button>Sync now</button></form></p>
+{{range $i, $p := .Profiles}}
+{{if $p.Name}}<h2>Profile {{$p.Name}}</h2>{{end}}
+{{if $p.Failed}}<p class="failed">The last sync failed, see the errors below.</p>{{end}}
+{{range $p.Goals}}<p>{{.Summary}}<br><progress value="{{.Done}}" max="{{.Target}}"></progress></p>{{end}}
+{{if $p.Encouragement}}<p><i>{{$p.Encouragement}}</i></p>{{end}}
+{{if $p.Standings}}<p>{{$p.Standings}}</p>{{end}}
+
+<h3>Pending uploads ({{len $p.Pending}})</h3>
+{{if $p.Pending}}<table><tr><th>Activity</th><th>Date</th><th>Time</th><th>Distance</th><th>Failed posts</th><th>Next try</th><th>Error</th></tr>
+{{range $p.Pending}}<tr><td>{{.Activity}}</td><td>{{.Date}}</td><td>{{.Time}}</td><td>{{.Distance}}</td><td>{{.Attempts}}</td><td>{{.NextAttempt.Local.Format "Jan 2 15:04"}}</td><td>{{.Error}}</td></tr>
+{{end}}</table>{{else}}<p>None.</p>{{end}}
+
+<h3>On Taji</h3>
+{{if $p.Synced}}<table><tr><th>Activity</th><th>Date</th><th>Time</th><th>Distance</th><th>Duration</th><th>Strava</th><th>Taji entry</th></tr>
+{{range $p.Synced}}<tr><td>{{.Activity}}</td><td>{{.Date}}</td><td>{{.Time}}</td><td>{{.Distance}}</td><td>{{.Duration}}</td><td>{{if .StravaId}}{{.StravaId}}{{end}}</td><td>{{.LogId}}</td></tr>
+{{end}}</table>{{else}}<p>Nothing yet.</p>{{end}}
+
+<h3>Recent decisions</h3>
+{{if $p.Recent}}<table>{{range $p.Recent}}<tr><td>{{.Date}}</td><td>{{.Time}}</td><td>{{.Activity}}</td><td>{{.Distance}}</td><td>{{.Decision}} ({{.Result}})</td></tr>
+{{end}}</table>{{else}}<p>None since the start.</p>{{end}}
+
+<h3>Errors</h3>
+{{if $p.Errors}}<table>{{range $p.Errors}}<tr><td>{{.At.Local.Format "Jan 2 15:04:05"}}</td><td>{{.Message}}</td></tr>
+{{end}}</table>{{else}}<p>None since the start.</p>{{end}}
+
+<h3>Accounts</h3>
+<p>{{range $p.Accounts}}
+<form method="post" action="/ui/strava/connect"><input type="hidden" name="profile" value="{{$i}}"><input type="hidden" name="account" value="{{.}}">
+<button>Authorize Strava account {{.}} again</button></form>
+{{end}}
+<form method="post" action="/ui/taji/login"><input type="hidden" name="profile" value="{{$i}}"><button>Log in to Taji again</button></form></p>
+{{end}}
+<p><small>Authorizing Strava from here needs <code>{{.Host}}</code> as the Authorization Callback Domain of the Strava API application.</small></p>
+</body></html>
+`))
+
+func (p *statusPage) page(rw http.ResponseWriter, r *http.Request) {
+	type goalView struct {
+		Summary      string
+		Done, Target float64
+	}
+	type recentView struct {
+		Date, Time, Activity, Distance, Decision, Result string
+	}
+	type profileView struct {
+		Name          string
+		Failed        bool
+		Goals         []goalView
+		Encouragement string
+		Standings     string
+		Pending       []queuedUpload
+		Synced        []exportedActivity
+		Recent        []recentView
+		Errors        []statusError
+		Accounts      []string
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	data := struct {
+		Message    string
+		Host       string
+		Last, Next time.Time
+		Profiles   []profileView
+	}{Message: p.message, Host: r.Host, Last: p.last.Local(), Next: p.next.Local()}
+	p.message = ""
+	for _, profile := range p.profiles {
+		u := profile.syncer.u
+		view := profileView{Name: u.profile, Failed: profile.synced && profile.result.failed, Encouragement: profile.result.encouragement}
+		for _, status := range profile.result.goals() {
+			view.Goals = append(view.Goals, goalView{status.summary(), min(status.done, status.target), status.target})
+		}
+		if standings := u.state.standings(); standings != nil {
+			view.Standings = standings.summary(u.settings.display)
+		}
+		view.Pending = u.state.queued()
+		synced := u.state.exported()
+		slices.Reverse(synced)
+		view.Synced = synced[:min(len(synced), STATUS_SYNCED)]
+		for i := len(profile.recent) - 1; i >= 0; i-- {
+			row := profile.recent[i]
+			view.Recent = append(view.Recent, recentView{row.run.date, row.run.time, row.run.activity, row.run.distance, row.decision, row.result})
+		}
+		view.Errors = slices.Clone(profile.errors)
+		slices.Reverse(view.Errors)
+		for _, account := range u.accounts {
+			view.Accounts = append(view.Accounts, account.name)
+		}
+		data.Profiles = append(data.Profiles, view)
+	}
+	if err := statusTemplate.Execute(rw, data); err != nil {
+		log.Print("Error rendering the status page: ", err)
+	}
+}
+
+// done shows message on the status page.
+func (p *statusPage) done(rw http.ResponseWriter, r *http.Request, message string) {
+	p.mu.Lock()
+	p.message = message
+	p.mu.Unlock()
+	http.Redirect(rw, r, "/", http.StatusSeeOther)
+}
+
+func (p *statusPage) syncNow(rw http.ResponseWriter, r *http.Request) {
+	queueSync(p.triggers, "requested from the status page")
+	p.done(rw, r, "Sync queued.")
+}
+
+// profileAt returns the syncer of the profile index posted by a form.
+func (p *statusPage) profileAt(r *http.Request) (int, *syncer, bool) {
+	i, err := strconv.Atoi(r.FormValue("profile"))
+	if err != nil || i < 0 || i >= len(p.profiles) {
+		return 0, nil, false
+	}
+	return i, p.profiles[i].syncer, true
+}
+
+// stravaConnect sends the browser to Strava to authorize an account again,
+// with the redirect coming back to this server.
+func (p *statusPage) stravaConnect(rw http.ResponseWriter, r *http.Request) {
+	i, s, ok := p.profileAt(r)
+	account := r.FormValue("account")
+	if !ok || !slices.ContainsFunc(s.u.accounts, func(a *strava) bool { return a.name == account }) {
+		http.Error(rw, "unknown account", http.StatusBadRequest)
+		return
+	}
+	conf := *stravaConfig(s.u.env)
+	conf.RedirectURL = fmt.Sprintf("http://%s/strava/callback", r.Host)
+	state, verifier := authState(), oauth2.GenerateVerifier()
+
+	p.mu.Lock()
+	now := p.clock.Now()
+	for key, auth := range p.auths {
+		if now.Sub(auth.started) > STATUS_AUTH_TTL {
+			delete(p.auths, key)
+		}
+	}
+	p.auths[state] = statusAuth{profile: i, account: account, verifier: verifier, redirect: conf.RedirectURL, started: now}
+	p.mu.Unlock()
+	http.Redirect(rw, r, conf.AuthCodeURL(state, oauth2.S256ChallengeOption(verifier)), http.StatusSeeOther)
+}
+
+// stravaCallback finishes an authorization started by stravaConnect. The
+// new token is swapped in between cycles.
+func (p *statusPage) stravaCallback(rw http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	auth, ok := p.auths[r.URL.Query().Get("state")]
+	delete(p.auths, r.URL.Query().Get("state"))
+	p.mu.Unlock()
+	if !ok || p.clock.Now().Sub(auth.started) > STATUS_AUTH_TTL {
+		p.done(rw, r, "That Strava authorization wasn't started here or took too long, try again.")
+		return
+	}
+	code := r.URL.Query().Get("code")
+	if code == "" {
+		p.done(rw, r, "Strava didn't authorize Taj Uploader: "+r.URL.Query().Get("error"))
+		return
+	}
+	s := p.profiles[auth.profile].syncer
+	conf := *stravaConfig(s.u.env)
+	conf.RedirectURL = auth.redirect
+	token, err := conf.Exchange(r.Context(), code, oauth2.VerifierOption(auth.verifier))
+	if err != nil {
+		log.Print("Strava authorization failed: ", err)
+		p.done(rw, r, "Strava authorization failed, try again.")
+		return
+	}
+
+	s.running.Lock()
+	defer s.running.Unlock()
+	for _, account := range s.u.accounts {
+		if account.name != auth.account {
+			continue
+		}
+		addRedaction(token.AccessToken)
+		addRedaction(token.RefreshToken)
+		account.token = token
+		account.source = account.conf.TokenSource(account.ctx, token)
+		data, _ := json.Marshal(token)
+		s.u.env[stravaTokenKey(account.name)] = string(data)
+		storeStravaScope(s.u.env, account, r.URL.Query().Get("scope"))
+	}
+	dumpEnvFile(s.u)
+	log.Printf("Strava account %q authorized from the status page", auth.account)
+	p.done(rw, r, fmt.Sprintf("Strava account %s authorized.", auth.account))
+}
+
+// tajiLogin replaces the Taji session with a new login, with the stored
+// credentials since the page doesn't ask for them.
+func (p *statusPage) tajiLogin(rw http.ResponseWriter, r *http.Request) {
+	_, s, ok := p.profileAt(r)
+	if !ok {
+		http.Error(rw, "unknown profile", http.StatusBadRequest)
+		return
+	}
+	u := s.u
+	username, _, _ := presupplied(u.env, "TAJI_USERNAME")
+	password, _, _ := presupplied(u.env, "TAJI_PASSWORD")
+	if username == "" || password == "" {
+		p.done(rw, r, "Set TAJI_USERNAME and TAJI_PASSWORD to log in from here, or run taju auth taji.")
+		return
+	}
+
+	s.running.Lock()
+	defer s.running.Unlock()
+	if err := tajiLogin(&u.taji, username, password); err != nil {
+		log.Print("Taji login failed: ", err)
+		p.done(rw, r, "Taji100 login failed, check the email and password.")
+		return
+	}
+	saveTajiLogin(&u.taji, u.env)
+	dumpEnvFile(u)
+	log.Print("Logged in to Taji from the status page")
+	p.done(rw, r, "Logged in to Taji100.")
+}
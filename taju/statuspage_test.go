@@ -0,0 +1,36 @@
+package taju
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatusAuthExpiry(t *testing.T) {
+	tests := []struct {
+		name    string
+		elapsed time.Duration
+		state   string
+		want    string
+	}{
+		{name: "took too long", elapsed: STATUS_AUTH_TTL + time.Second, state: "started", want: "took too long"},
+		{name: "not started here", state: "unknown", want: "wasn't started here"},
+		{name: "denied in time", elapsed: STATUS_AUTH_TTL, state: "started", want: "didn't authorize"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := newFakeClock(TEST_NOW)
+			p := newStatusPage(nil, make(chan string, 1), c)
+			p.auths["started"] = statusAuth{account: DEFAULT_ACCOUNT, started: TEST_NOW}
+			c.Advance(test.elapsed)
+
+			rec := httptest.NewRecorder()
+			p.stravaCallback(rec, httptest.NewRequest(http.MethodGet, "/strava/callback?state="+test.state+"&error=access_denied", nil))
+			if rec.Code != http.StatusSeeOther || !strings.Contains(p.message, test.want) {
+				t.Errorf("callback = %d %q, want a redirect with %q", rec.Code, p.message, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package taju
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	STORAGE_JSON   string = "json"
+	STORAGE_SQLITE string = "sqlite"
+	STORAGE_BBOLT  string = "bbolt"
+)
+
+// stateStorage is where the state ledger is kept between runs, picked with
+// TAJU_STORAGE. The ledger is always held in memory while taju runs; a
+// backend only loads it at startup and saves it after changes.
+//
+// The JSON file backend is the default; webdav and s3 keep the ledger on a
+// remote store, see remoteStorage. sqlite and bbolt keep it in a database
+// next to where the JSON file would be, a row per entry, see
+// sqliteStorage and boltStorage.
+type stateStorage interface {
+	// load reads the saved ledger into state. A store that doesn't exist yet
+	// leaves state empty.
+	load(state *stateStore) error
+	save(state *stateStore) error
+	String() string
+}
+
+// openStorage returns the backend configured in env for the ledger at path.
+func openStorage(env map[string]string, path string) (stateStorage, error) {
+	switch backend := env["TAJU_STORAGE"]; backend {
+	case "", STORAGE_JSON:
+		return jsonStorage{path}, nil
+	case STORAGE_WEBDAV, STORAGE_S3:
+		blobs, key, err := openRemote(env)
+		if err != nil {
+			return nil, err
+		}
+		return &remoteStorage{blobs: blobs, key: key, name: filepath.Base(path)}, nil
+	case STORAGE_SQLITE:
+		return sqliteStorage{path: databasePath(path, ".db"), imported: jsonStorage{path}}, nil
+	case STORAGE_BBOLT:
+		return boltStorage{path: databasePath(path, ".bolt"), imported: jsonStorage{path}}, nil
+	default:
+		return nil, fmt.Errorf("invalid TAJU_STORAGE=%q, expected json, sqlite, bbolt, webdav or s3", backend)
+	}
+}
+
+// jsonStorage keeps the ledger in one JSON file, the zero-dependency store.
+type jsonStorage struct {
+	path string
+}
+
+func (j jsonStorage) String() string {
+	return j.path
+}
+
+func (j jsonStorage) load(state *stateStore) error {
+	data, err := os.ReadFile(j.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, state)
+}
+
+// save writes the ledger through a temporary file so a crash mid-write never
+// leaves a truncated ledger behind.
+func (j jsonStorage) save(state *stateStore) error {
+	state.mu.Lock()
+	data, err := json.MarshalIndent(state, "", "  ")
+	state.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("encoding the state: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(j.path), ".taju-state-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), j.path)
+}
+
+// databasePath is the database kept instead of the JSON ledger at path.
+func databasePath(path string, ext string) string {
+	return strings.TrimSuffix(path, filepath.Ext(path)) + ext
+}
+
+// LEDGER_SECTIONS are the ledgers of the state, which the database
+// backends keep a row per entry so they can be queried. The other sections
+// are kept whole, as their JSON.
+var LEDGER_SECTIONS = []string{"entries", "parts", "manual", "taji_only"}
+
+// stateSections splits the state into its ledgers, keyed by section and
+// entry key, and the JSON of the other sections.
+func stateSections(state *stateStore) (ledgers map[string]map[string]*ledgerEntry, other map[string]json.RawMessage, err error) {
+	state.mu.Lock()
+	data, err := json.Marshal(state)
+	state.mu.Unlock()
+	if err != nil {
+		return nil, nil, fmt.Errorf("encoding the state: %w", err)
+	}
+	if err := json.Unmarshal(data, &other); err != nil {
+		return nil, nil, err
+	}
+	ledgers = make(map[string]map[string]*ledgerEntry)
+	for _, section := range LEDGER_SECTIONS {
+		raw, ok := other[section]
+		if !ok {
+			continue
+		}
+		var entries map[string]*ledgerEntry
+		if err := json.Unmarshal(raw, &entries); err != nil {
+			return nil, nil, err
+		}
+		ledgers[section] = entries
+		delete(other, section)
+	}
+	return ledgers, other, nil
+}
+
+// loadSections is the reverse of stateSections, reading them into state.
+func loadSections(state *stateStore, ledgers map[string]map[string]*ledgerEntry, other map[string]json.RawMessage) error {
+	sections := maps.Clone(other)
+	if sections == nil {
+		sections = make(map[string]json.RawMessage)
+	}
+	for section, entries := range ledgers {
+		raw, err := json.Marshal(entries)
+		if err != nil {
+			return err
+		}
+		sections[section] = raw
+	}
+	data, err := json.Marshal(sections)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, state)
+}
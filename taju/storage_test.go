@@ -0,0 +1,169 @@
+package taju
+
+import (
+	"database/sql"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testState is a state with every kind of section filled in.
+func testState(storage stateStorage) *stateStore {
+	state := memoryState(newFakeClock(TEST_NOW))
+	state.storage = storage
+	uploaded := TEST_NOW.Add(-time.Hour)
+	state.Entries[11] = &ledgerEntry{StravaId: 11, LogId: "901", Status: "uploaded", Activity: "run", Date: "2026-02-10", Time: "07:00 AM",
+		Distance: "3.10", Duration: "00:30:00", Elevation: "42", Listed: "digest", UpdatedAt: TEST_NOW, UploadedAt: uploaded}
+	state.Entries[12] = &ledgerEntry{StravaId: 12, Status: "failed", Activity: "walk", Date: "2026-02-11", Time: "06:00 PM",
+		Distance: "1.00", Duration: "00:20:00", Error: "distance: too small", UpdatedAt: TEST_NOW}
+	state.Parts = map[string]*ledgerEntry{partKey(13, 1): {StravaId: 13, Part: 1, LogId: "902", Status: "uploaded", Unkeyed: true, UpdatedAt: TEST_NOW}}
+	state.Manual = map[string]*ledgerEntry{"903": {LogId: "903", Status: "uploaded", Activity: "ruck", UpdatedAt: TEST_NOW}}
+	state.TajiOnly = map[string]*ledgerEntry{"904": {LogId: "904", Status: "taji-only", UpdatedAt: TEST_NOW}}
+	state.Templates = map[string]string{"entry-edit": "abc123"}
+	state.LastSync = TEST_NOW
+	state.DistanceStep = 0.01
+	return state
+}
+
+func stateJSON(t *testing.T, state *stateStore) string {
+	t.Helper()
+	data, err := json.Marshal(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestStorageRoundTrip(t *testing.T) {
+	tests := []struct {
+		backend string
+		file    string
+	}{
+		{backend: STORAGE_JSON, file: STATE_FILENAME},
+		{backend: STORAGE_SQLITE, file: "taju.state.db"},
+		{backend: STORAGE_BBOLT, file: "taju.state.bolt"},
+	}
+	for _, test := range tests {
+		t.Run(test.backend, func(t *testing.T) {
+			dir := t.TempDir()
+			storage := openTestStorage(t, map[string]string{"TAJU_STORAGE": test.backend}, filepath.Join(dir, STATE_FILENAME))
+			if storage.String() != filepath.Join(dir, test.file) {
+				t.Errorf("stored in %s, want %s", storage, test.file)
+			}
+			if got, want := stateJSON(t, loadTestState(t, storage)), stateJSON(t, memoryState(realClock{})); got != want {
+				t.Errorf("a new store loaded %s, want an empty state", got)
+			}
+
+			saved := testState(storage)
+			if err := saved.save(); err != nil {
+				t.Fatal(err)
+			}
+			// Saving again replaces what was stored.
+			delete(saved.Entries, 12)
+			if err := saved.save(); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := stateJSON(t, loadTestState(t, storage)), stateJSON(t, saved); got != want {
+				t.Errorf("loaded\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestDatabaseImportsJSON(t *testing.T) {
+	for _, backend := range []string{STORAGE_SQLITE, STORAGE_BBOLT} {
+		t.Run(backend, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), STATE_FILENAME)
+			ledger := testState(jsonStorage{path})
+			if err := ledger.save(); err != nil {
+				t.Fatal(err)
+			}
+			storage := openTestStorage(t, map[string]string{"TAJU_STORAGE": backend}, path)
+			if got, want := stateJSON(t, loadTestState(t, storage)), stateJSON(t, ledger); got != want {
+				t.Errorf("a new database loaded\n%s\nwant the JSON ledger\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestSqliteLedgerQuery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), STATE_FILENAME)
+	storage := openTestStorage(t, map[string]string{"TAJU_STORAGE": STORAGE_SQLITE}, path)
+	if err := testState(storage).save(); err != nil {
+		t.Fatal(err)
+	}
+	db, err := sql.Open("sqlite", databasePath(path, ".db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var count int
+	var miles float64
+	err = db.QueryRow(`SELECT count(*), sum(distance) FROM ledger WHERE section = 'entries' AND date BETWEEN '2026-02-01' AND '2026-02-28'`).Scan(&count, &miles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 || miles != 4.1 {
+		t.Errorf("February has %d entries and %.2f miles, want 2 and 4.10", count, miles)
+	}
+	var uploaded_at string
+	if err := db.QueryRow(`SELECT uploaded_at FROM ledger WHERE log_id = '901'`).Scan(&uploaded_at); err != nil {
+		t.Fatal(err)
+	}
+	if uploaded_at != "2026-02-20T11:00:00Z" {
+		t.Errorf("uploaded_at = %q", uploaded_at)
+	}
+}
+
+// A database from before the listed column gets it on the next open.
+func TestSqliteMigratesListed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), STATE_FILENAME)
+	db, err := sql.Open("sqlite", databasePath(path, ".db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := strings.Replace(SQLITE_SCHEMA, "listed         TEXT NOT NULL DEFAULT '',\n", "", 1)
+	if old == SQLITE_SCHEMA {
+		t.Fatal("the schema has no listed column to leave out")
+	}
+	_, err = db.Exec(old + `INSERT INTO ledger VALUES ('entries', '11', 11, 0, '901', 'uploaded', 'run', '2026-02-10', '07:00 AM',
+		'3.10', '00:30:00', '', 0, '', NULL, NULL)`)
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storage := openTestStorage(t, map[string]string{"TAJU_STORAGE": STORAGE_SQLITE}, path)
+	state := loadTestState(t, storage)
+	if entry := state.Entries[11]; entry == nil || entry.LogId != "901" || entry.Listed != "" {
+		t.Fatalf("loaded %+v, want entry 901 without a digest", entry)
+	}
+	state.Entries[11].Listed = "digest"
+	if err := state.save(); err != nil {
+		t.Fatal(err)
+	}
+	if got := loadTestState(t, storage).Entries[11].Listed; got != "digest" {
+		t.Errorf("listed %q after saving, want digest", got)
+	}
+}
+
+func openTestStorage(t *testing.T, env map[string]string, path string) stateStorage {
+	t.Helper()
+	storage, err := openStorage(env, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return storage
+}
+
+func loadTestState(t *testing.T, storage stateStorage) *stateStore {
+	t.Helper()
+	state, err := loadState(storage, realClock{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return state
+}
@@ -0,0 +1,199 @@
+package taju
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// The Strava authorization waits for the browser's redirect on a local port
+// (TAJU_AUTH_PORT, 9191 by default, 0 for any free one). Strava only checks
+// the redirect's domain, so when the port is taken the redirect can come back
+// on any free one. A random state ties the redirect to this run and a PKCE
+// verifier ties the code to it. If no redirect arrives within
+// TAJU_AUTH_TIMEOUT (e.g. on a machine without a browser) the address the
+// browser was sent to, or just its code, can be pasted instead.
+const AUTH_TIMEOUT = 3 * time.Minute
+
+type authSettings struct {
+	port    int
+	timeout time.Duration
+}
+
+func loadAuthSettings(env map[string]string) (authSettings, error) {
+	settings := authSettings{port: PORT, timeout: AUTH_TIMEOUT}
+	if value, ok := env["TAJU_AUTH_PORT"]; ok {
+		port, err := strconv.Atoi(value)
+		if err != nil || port < 0 || port > 65535 {
+			return authSettings{}, fmt.Errorf("invalid TAJU_AUTH_PORT=%q, expected a port number or 0 for any free port", value)
+		}
+		settings.port = port
+	}
+	if value, ok := env["TAJU_AUTH_TIMEOUT"]; ok {
+		timeout, err := time.ParseDuration(value)
+		if err != nil || timeout <= 0 {
+			return authSettings{}, fmt.Errorf("invalid TAJU_AUTH_TIMEOUT=%q, expected a duration like 3m", value)
+		}
+		settings.timeout = timeout
+	}
+	return settings, nil
+}
+
+// authState is a random OAuth state parameter.
+func authState() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		log.Fatal("Can't generate the authorization state: ", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// authListen listens on the configured port, or on any free one when it is
+// taken or set to 0.
+func authListen(port int) net.Listener {
+	if port != 0 {
+		listener, err := net.Listen("tcp", fmt.Sprintf("localhost:%d", port))
+		if err == nil {
+			return listener
+		}
+		if owner := portOwner(port); owner != "" {
+			log.Printf("Port %d is used by %s, waiting for Strava on another port (to free it: %s)", port, owner, closeHint(owner))
+		} else {
+			log.Printf("Port %d is in use (%v), waiting for Strava on another port", port, err)
+		}
+	}
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		log.Fatal("Can't listen for the Strava authorization redirect: ", err)
+	}
+	return listener
+}
+
+// authCode reads the code from the query of a redirect, rejecting one
+// whose state isn't ours.
+func authCode(params url.Values, state string) (string, error) {
+	if reason := params.Get("error"); reason != "" {
+		return "", fmt.Errorf("Strava denied the authorization: %s", reason)
+	}
+	if subtle.ConstantTimeCompare([]byte(params.Get("state")), []byte(state)) != 1 {
+		return "", errors.New("the authorization state doesn't match, ignoring the redirect")
+	}
+	code := params.Get("code")
+	if code == "" {
+		return "", errors.New("the redirect has no authorization code")
+	}
+	return code, nil
+}
+
+// pastedCode reads the redirect address, or the bare code, from stdin, and
+// returns the code and the scope the address says was granted.
+func pastedCode(state string) (string, string, error) {
+	fmt.Print("Paste the address the browser was redirected to (or just its code parameter): ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	line = strings.TrimSpace(line)
+	if line == "" {
+		if err == nil {
+			err = errors.New("nothing was pasted")
+		}
+		return "", "", err
+	}
+	if !strings.Contains(line, "code=") {
+		return line, "", nil
+	}
+	query := line
+	if parsed, err := url.Parse(line); err == nil && parsed.RawQuery != "" {
+		query = parsed.RawQuery
+	}
+	params, err := url.ParseQuery(query)
+	if err != nil {
+		return "", "", err
+	}
+	code, err := authCode(params, state)
+	return code, params.Get("scope"), err
+}
+
+func authStrava(s *strava) {
+	listener := authListen(s.auth.port)
+	conf := *s.conf
+	conf.RedirectURL = fmt.Sprintf("http://localhost:%d", listener.Addr().(*net.TCPAddr).Port)
+	state := authState()
+	verifier := oauth2.GenerateVerifier()
+	auth_url := conf.AuthCodeURL(state, oauth2.S256ChallengeOption(verifier))
+
+	fmt.Printf("We need to authorize Taj Uploader to access your Strava account %q...", s.name)
+	fmt.Printf("please visit the URL for the authorization dialog:\n\n%v\n\n", auth_url)
+	if !s.settings.headless {
+		if err := openBrowser(auth_url); err != nil {
+			log.Print("Couldn't open the browser: ", err)
+		}
+	}
+
+	type grant struct{ code, scope string }
+	codes := make(chan grant, 1)
+	server := &http.Server{}
+	redirectHandler := func(w http.ResponseWriter, r *http.Request) {
+		code, err := authCode(r.URL.Query(), state)
+		if err != nil {
+			log.Print("Strava authorization: ", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		fmt.Fprintf(w, "Successful authorization!")
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+		select {
+		case codes <- grant{code, r.URL.Query().Get("scope")}:
+		default:
+		}
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", redirectHandler)
+	server.Handler = mux
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal("Error waiting for the Strava authorization redirect: ", err)
+		}
+	}()
+
+	var code, scope string
+	select {
+	case granted := <-codes:
+		code, scope = granted.code, granted.scope
+	case <-time.After(s.auth.timeout):
+		if !s.settings.interactive() {
+			server.Close()
+			log.Fatalf("No Strava authorization redirect within %v. Run taju auth strava %s --code <code> with the code from the redirect address.", s.auth.timeout, s.name)
+		}
+		fmt.Printf("\nNo redirect within %v. If the browser runs on another machine its redirect to localhost failed; that address still holds the code.\n", s.auth.timeout)
+		var err error
+		if code, scope, err = pastedCode(state); err != nil {
+			log.Fatal("Strava authorization: ", err)
+		}
+	}
+	server.Close()
+
+	tok, err := conf.Exchange(s.ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		log.Fatal(err)
+	} else {
+		log.Print("Successful authorization")
+	}
+	s.token = tok
+	// The redirect tells which scope was granted; a pasted bare code
+	// doesn't, it is taken to be the one asked for.
+	s.scope = cmpOr(scope, strings.Join(conf.Scopes, ","))
+}
@@ -0,0 +1,58 @@
+package taju
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/tajuploader/stravaclient"
+	"golang.org/x/oauth2"
+)
+
+const STRAVA_API_URL string = stravaclient.API_URL
+
+type stravaActivity = stravaclient.Activity
+type stravaSplit = stravaclient.Split
+type stravaPhotos = stravaclient.Photos
+type stravaStream = stravaclient.Stream
+
+// stravaAPI is the API client of account s, authorized with its token.
+// It is cheap, make one where it is needed: accounts are copied.
+func stravaAPI(s *strava) *stravaclient.Client {
+	return &stravaclient.Client{
+		Account:    s.name,
+		API:        s.api,
+		HTTPClient: func() (*http.Client, error) { return stravaHTTPClient(s) },
+		Cache:      s.cache,
+		Context:    s.run.context(),
+		Workers:    s.detail_workers,
+		Artifact:   s.settings.artifacts.save,
+	}
+}
+
+// stravaHTTPClient returns a client with a valid access token, refreshing it
+// when it has expired. If Strava rejects the refresh token (the user revoked
+// access) it falls back to the interactive authorization flow.
+func stravaHTTPClient(s *strava) (*http.Client, error) {
+	token, err := s.source.Token()
+	if err != nil {
+		var retrieve_err *oauth2.RetrieveError
+		if !errors.As(err, &retrieve_err) || !s.settings.interactive() {
+			return nil, fmt.Errorf("refreshing the Strava token for account %q failed, run taju auth strava: %w", s.name, err)
+		}
+		log.Printf("Strava rejected the refresh token for account %q, authorizing again", s.name)
+		authStrava(s)
+		s.source = s.conf.TokenSource(s.ctx, s.token)
+		if token, err = s.source.Token(); err != nil {
+			return nil, err
+		}
+	}
+	if token.AccessToken != s.token.AccessToken {
+		log.Printf("Refreshed the Strava token for account %q", s.name)
+		addRedaction(token.AccessToken)
+		addRedaction(token.RefreshToken)
+		s.token = token
+	}
+	return oauth2.NewClient(s.ctx, s.source), nil
+}
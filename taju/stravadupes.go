@@ -0,0 +1,99 @@
+package taju
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// A run recorded on a watch and a phone app at the same time shows up on
+// Strava twice (or in two activity files). Activities of the same Taji activity starting within
+// TAJU_STRAVA_DUPLICATE_WINDOW of each other are treated as one, and only
+// the preferred one is synced. TAJU_STRAVA_DUPLICATES picks it:
+//
+//	longer        the longer distance (the default)
+//	device:<name> the one recorded on a device whose name contains <name>,
+//	              e.g. device:Garmin, else the longer one
+//	off           sync both
+const (
+	DUPLICATES_LONGER string = "longer"
+	DUPLICATES_DEVICE string = "device"
+	DUPLICATES_OFF    string = "off"
+	DUPLICATE_WINDOW         = 2 * time.Minute
+)
+
+type duplicateRule struct {
+	prefer string
+	device string
+	window time.Duration
+}
+
+func loadDuplicateRule(env map[string]string) (duplicateRule, error) {
+	rule := duplicateRule{prefer: DUPLICATES_LONGER, window: DUPLICATE_WINDOW}
+	if value, ok := env["TAJU_STRAVA_DUPLICATES"]; ok {
+		prefer, device, _ := strings.Cut(strings.TrimSpace(value), ":")
+		rule.prefer, rule.device = strings.ToLower(prefer), strings.TrimSpace(device)
+		switch {
+		case rule.prefer == DUPLICATES_DEVICE && rule.device != "":
+		case (rule.prefer == DUPLICATES_LONGER || rule.prefer == DUPLICATES_OFF) && device == "":
+		default:
+			return duplicateRule{}, fmt.Errorf("invalid TAJU_STRAVA_DUPLICATES=%q, expected longer, device:<name> or off", value)
+		}
+	}
+	if value, ok := env["TAJU_STRAVA_DUPLICATE_WINDOW"]; ok {
+		window, err := time.ParseDuration(value)
+		if err != nil || window <= 0 {
+			return duplicateRule{}, fmt.Errorf("invalid TAJU_STRAVA_DUPLICATE_WINDOW=%q, expected a duration like 2m", value)
+		}
+		rule.window = window
+	}
+	return rule, nil
+}
+
+// dropDuplicateUploads keeps one activity of every group recorded twice,
+// see intake.dropDuplicates, and returns the activities in start order. A
+// dropped activity that was synced before is forgotten, so its Taji entry
+// goes to the one kept.
+func dropDuplicateUploads(s *strava, activities []stravaActivity) []stravaActivity {
+	by_id := make(map[int64]stravaActivity)
+	var sources []sourceActivity
+	for _, activity := range activities {
+		by_id[activity.Id] = activity
+		sources = append(sources, s.sourceOf(activity))
+	}
+	devices := func(ids []int64) map[int64]string { return duplicateDevices(s, ids) }
+	var kept []stravaActivity
+	for _, source := range s.dropDuplicates(sources, devices, func(activity sourceActivity, best sourceActivity) {
+		log.Printf("Skipping Strava activity %d (%q), it was recorded twice, keeping %d (%q)", activity.id, activity.name, best.id, best.name)
+		delete(s.seen, activity.id)
+	}) {
+		kept = append(kept, by_id[source.id])
+	}
+	return kept
+}
+
+// better reports whether a is preferred over b.
+func (r duplicateRule) better(a sourceActivity, b sourceActivity) bool {
+	if r.prefer == DUPLICATES_DEVICE {
+		a_match := strings.Contains(strings.ToLower(a.device), strings.ToLower(r.device))
+		b_match := strings.Contains(strings.ToLower(b.device), strings.ToLower(r.device))
+		if a_match != b_match {
+			return a_match
+		}
+	}
+	if a.distance != b.distance {
+		return a.distance > b.distance
+	}
+	return a.elapsed > b.elapsed
+}
+
+// duplicateDevices reads the device names of the activities recorded
+// twice. Strava only has them in the detailed representation.
+func duplicateDevices(s *strava, ids []int64) map[int64]string {
+	devices := make(map[int64]string)
+	for _, detail := range stravaAPI(s).Activities(ids) {
+		devices[detail.Id] = detail.DeviceName
+	}
+	return devices
+}
@@ -0,0 +1,92 @@
+package taju
+
+import (
+	"maps"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestDropDuplicateUploads(t *testing.T) {
+	start := time.Date(2026, 2, 10, 7, 0, 0, 0, time.UTC)
+	activity := func(id int64, sport string, after time.Duration, distance float64) stravaActivity {
+		return stravaActivity{Id: id, SportType: sport, StartDate: start.Add(after).Format(time.RFC3339), Distance: distance, ElapsedTime: 1800}
+	}
+	tests := []struct {
+		name       string
+		env        map[string]string
+		activities []stravaActivity
+		want       []int64
+	}{
+		{
+			name:       "watch and phone, the longer one is kept",
+			activities: []stravaActivity{activity(1, "Run", 0, 5000), activity(2, "Run", 40*time.Second, 5100)},
+			want:       []int64{2},
+		},
+		{
+			name:       "same distance, the longer time is kept",
+			activities: []stravaActivity{activity(1, "Run", 0, 5000), func() stravaActivity { a := activity(2, "Run", 0, 5000); a.ElapsedTime = 1900; return a }()},
+			want:       []int64{2},
+		},
+		{
+			name:       "apart more than the window",
+			activities: []stravaActivity{activity(1, "Run", 0, 5000), activity(2, "Run", 3*time.Minute, 5100)},
+			want:       []int64{1, 2},
+		},
+		{
+			name:       "wider window",
+			env:        map[string]string{"TAJU_STRAVA_DUPLICATE_WINDOW": "5m"},
+			activities: []stravaActivity{activity(1, "Run", 0, 5000), activity(2, "Run", 3*time.Minute, 5100)},
+			want:       []int64{2},
+		},
+		{
+			name:       "different Taji activities",
+			activities: []stravaActivity{activity(1, "Run", 0, 5000), activity(2, "Ride", 30*time.Second, 20000)},
+			want:       []int64{1, 2},
+		},
+		{
+			name:       "off",
+			env:        map[string]string{"TAJU_STRAVA_DUPLICATES": "off"},
+			activities: []stravaActivity{activity(1, "Run", 0, 5000), activity(2, "Run", 40*time.Second, 5100)},
+			want:       []int64{1, 2},
+		},
+		{
+			name:       "sorted by start",
+			activities: []stravaActivity{activity(3, "Walk", time.Hour, 3000), activity(1, "Run", 0, 5000), activity(2, "Run", time.Minute, 4000)},
+			want:       []int64{1, 3},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			duplicates, err := loadDuplicateRule(test.env)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s := &strava{activity_map: maps.Clone(DEFAULT_ACTIVITY_MAP), intake: intake{duplicates: duplicates}}
+			var got []int64
+			for _, activity := range dropDuplicateUploads(s, test.activities) {
+				got = append(got, activity.Id)
+			}
+			if !slices.Equal(got, test.want) {
+				t.Errorf("kept %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestDuplicateRuleDevice(t *testing.T) {
+	rule, err := loadDuplicateRule(map[string]string{"TAJU_STRAVA_DUPLICATES": "device: garmin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	watch := sourceActivity{id: 1, distance: 5000, device: "Garmin Forerunner 265"}
+	phone := sourceActivity{id: 2, distance: 5100, device: "Strava iPhone App"}
+	if !rule.better(watch, phone) || rule.better(phone, watch) {
+		t.Error("the Garmin recording isn't preferred")
+	}
+	// Without a match on either, the longer one wins.
+	watch.device, phone.device = "", ""
+	if rule.better(watch, phone) {
+		t.Error("without device names the shorter recording is preferred")
+	}
+}
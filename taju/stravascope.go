@@ -0,0 +1,95 @@
+package taju
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// Strava only shows activities visible to Everyone or Followers to a
+// token with activity:read; those only the athlete can see need
+// activity:read_all. taju asks for it unless TAJU_STRAVA_SCOPE says
+// otherwise, and remembers what each account granted (the redirect says,
+// the token doesn't) in STRAVA_SCOPE, or STRAVA_SCOPE_<NAME>.
+const (
+	SCOPE_READ_ALL       string = "activity:read_all"
+	DEFAULT_STRAVA_SCOPE string = "read,activity:read_all"
+	// LEGACY_STRAVA_SCOPE is what taju asked for before, the scope of
+	// accounts authorized without a record of it.
+	LEGACY_STRAVA_SCOPE string = "read,activity:read"
+)
+
+func stravaScopeKey(name string) string {
+	if name == DEFAULT_ACCOUNT {
+		return "STRAVA_SCOPE"
+	}
+	return "STRAVA_SCOPE_" + strings.ToUpper(name)
+}
+
+// requestedScope is TAJU_STRAVA_SCOPE, the scope new authorizations ask for.
+func requestedScope(env map[string]string) string {
+	if scope := env["TAJU_STRAVA_SCOPE"]; scope != "" {
+		return scope
+	}
+	return DEFAULT_STRAVA_SCOPE
+}
+
+func seesPrivate(scope string) bool {
+	return slices.Contains(splitList(scope), SCOPE_READ_ALL)
+}
+
+// redirectGrant reads the code and the granted scope from a pasted
+// redirect address; a bare code comes back as it is, without a scope.
+func redirectGrant(pasted string) (code string, scope string) {
+	if !strings.Contains(pasted, "code=") {
+		return pasted, ""
+	}
+	if parsed, err := url.Parse(pasted); err == nil && parsed.RawQuery != "" {
+		return parsed.Query().Get("code"), parsed.Query().Get("scope")
+	}
+	return pasted, ""
+}
+
+// storeStravaScope records the scope an account was just authorized with.
+// Without the redirect address to tell, it is taken to be the one asked for.
+func storeStravaScope(env map[string]string, s *strava, granted string) {
+	s.scope = granted
+	if s.scope == "" {
+		s.scope = requestedScope(env)
+	}
+	env[stravaScopeKey(s.name)] = s.scope
+}
+
+// checkStravaScope warns when an account can't see private activities
+// although taju would sync them. An account authorized before taju asked
+// for activity:read_all is offered a new authorization when someone is at
+// the terminal; declining it is remembered.
+func checkStravaScope(env map[string]string, s *strava) {
+	granted, known := env[stravaScopeKey(s.name)]
+	if !known {
+		granted = LEGACY_STRAVA_SCOPE
+	}
+	s.scope = granted
+	if seesPrivate(granted) || !seesPrivate(requestedScope(env)) || envBool(env, "TAJU_SKIP_PRIVATE") {
+		return
+	}
+	if !known && s.settings.interactive() {
+		prompt := fmt.Sprintf("Strava account %q was authorized without access to activities only you can see, so those aren't synced. Authorize it again to include them?", s.name)
+		if confirm(prompt) {
+			authStrava(s)
+			token, _ := json.Marshal(s.token)
+			env[stravaTokenKey(s.name)] = string(token)
+			env[stravaScopeKey(s.name)] = s.scope
+			if seesPrivate(s.scope) {
+				return
+			}
+		} else {
+			env[stravaScopeKey(s.name)] = granted
+		}
+	}
+	log.Printf("Strava account %q can't see activities only you can see (it granted %s), so private activities aren't synced. "+
+		"Run taju auth strava %s and allow viewing private activities to include them.", s.name, s.scope, s.name)
+}
@@ -0,0 +1,100 @@
+package taju
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// STREAM_KEYS are the streams strava show --streams asks for; Strava leaves
+// out the ones the activity wasn't recorded with.
+var STREAM_KEYS = []string{"time", "distance", "latlng", "altitude", "velocity_smooth", "heartrate", "cadence", "watts", "temp", "moving", "grade_smooth"}
+
+// stravaCommand groups the commands that read Strava directly, without
+// touching Taji or the ledger.
+func stravaCommand(u *uploader, args []string) {
+	if len(args) < 1 {
+		log.Fatal("Usage: taju strava show <activity id> [--account name] [--streams] [--mapping]")
+	}
+	switch args[0] {
+	case "show":
+		stravaShowCommand(u, args[1:])
+	default:
+		log.Fatal("Unknown strava command: ", args[0])
+	}
+}
+
+// stravaShowCommand prints the full activity as the Strava API returns it,
+// and optionally its streams, as JSON. --mapping traces how the activity is
+// turned into Taji form values (to stderr, so the JSON can be piped on).
+func stravaShowCommand(u *uploader, args []string) {
+	flags := flag.NewFlagSet("strava show", flag.ExitOnError)
+	account := flags.String("account", DEFAULT_ACCOUNT, "Strava account the activity belongs to")
+	streams := flags.Bool("streams", false, "include the activity's streams (time, distance, altitude, heart rate...)")
+	mapping := flags.Bool("mapping", false, "trace how the activity is mapped to the Taji form (to stderr)")
+	var id_arg string
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		id_arg, args = args[0], args[1:]
+	}
+	flags.Parse(args)
+	if id_arg == "" && flags.NArg() > 0 {
+		id_arg = flags.Arg(0)
+	}
+	id, err := strconv.ParseInt(id_arg, 10, 64)
+	if err != nil || id <= 0 {
+		log.Fatal("Usage: taju strava show <activity id> [--account name] [--streams] [--mapping]")
+	}
+	if !slices.Contains(stravaAccounts(u.env), *account) {
+		log.Fatalf("Unknown Strava account %q, see taju accounts list", *account)
+	}
+
+	s := &strava{settings: u.settings}
+	if err := initStrava(u.env, s, *account); err != nil {
+		log.Fatal(err)
+	}
+	defer dumpEnvFile(u)
+
+	payload := struct {
+		Activity json.RawMessage `json:"activity"`
+		Streams  json.RawMessage `json:"streams,omitempty"`
+	}{}
+	if err := stravaAPI(s).Get(fmt.Sprintf("/activities/%d", id), nil, false, &payload.Activity); err != nil {
+		log.Fatal(err)
+	}
+	if *streams {
+		query := url.Values{}
+		query.Set("keys", strings.Join(STREAM_KEYS, ","))
+		query.Set("key_by_type", "true")
+		if err := stravaAPI(s).Get(fmt.Sprintf("/activities/%d/streams", id), query, false, &payload.Streams); err != nil {
+			log.Fatal(err)
+		}
+	}
+	data, _ := json.Marshal(payload)
+	var out bytes.Buffer
+	json.Indent(&out, data, "", "  ")
+	out.WriteByte('\n')
+	os.Stdout.Write(out.Bytes())
+
+	if *mapping {
+		var activity stravaActivity
+		if err := json.Unmarshal(payload.Activity, &activity); err != nil {
+			log.Fatal(err)
+		}
+		run, ok := activityRun(s, activity, activityDuration(s, activity))
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Strava activity %d (%s) maps to no Taji activity, see TAJU_ACTIVITY_MAP\n", id, activity.Type)
+			return
+		}
+		for _, part := range s.split.split(run) {
+			// A rejection is part of the trace.
+			traceMapping(os.Stderr, s, activity, part)
+		}
+	}
+}
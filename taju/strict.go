@@ -0,0 +1,158 @@
+package taju
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"text/tabwriter"
+)
+
+// EXIT_INCONSISTENT is the status of a strict sync that found the ledger,
+// Strava and Taji disagreeing.
+const EXIT_INCONSISTENT = 3
+
+// Strict mode (sync --strict or TAJU_STRICT=true) is for running under
+// supervision before trusting the automatic mode: instead of resolving
+// drift with the conflict policies, the first cycle that finds any stops
+// before changing Taji, reports it and exits with EXIT_INCONSISTENT. The
+// ledger isn't saved, so the next run sees the same drift.
+const (
+	DRIFT_VANISHED    string = "vanished"
+	DRIFT_MISMATCH    string = "mismatch"
+	DRIFT_NOT_ON_TAJI string = "not on taji"
+	DRIFT_UNCONFIRMED string = "unconfirmed"
+	DRIFT_DUPLICATE   string = "duplicate"
+	DRIFT_NO_STRAVA   string = "no strava"
+	DRIFT_UNRESOLVED  string = "unresolved"
+	DRIFT_RELINKED    string = "relinked"
+)
+
+type inconsistency struct {
+	kind   string
+	strava int64
+	log_id string
+	date   string
+	time   string
+	detail string
+}
+
+// vanishedEntries lists the ledger entries whose Taji entry isn't on the
+// participant page any more. forgetVanished forgets them, so strict mode
+// looks first.
+func (s *stateStore) vanishedEntries(entries []string) (found []inconsistency) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	on_page := make(map[string]bool)
+	for _, log_id := range entries {
+		on_page[log_id] = true
+	}
+	for _, entry := range slices.Concat(slices.Collect(maps.Values(s.Entries)), slices.Collect(maps.Values(s.Parts))) {
+		if entry.LogId != "" && !on_page[entry.LogId] {
+			found = append(found, inconsistency{DRIFT_VANISHED, entry.StravaId, entry.LogId, entry.Date, entry.Time,
+				"the ledger has this entry but it is gone from Taji"})
+		}
+	}
+	return
+}
+
+// ledgerStatus returns the ledger status of a run and its Taji log id, ""
+// if the ledger has none.
+func (s *stateStore) ledgerStatus(run runDetails) (string, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if entry := s.entry(run, false); entry != nil {
+		return entry.Status, entry.LogId
+	}
+	return "", ""
+}
+
+// inconsistencies compares the cycle's Strava activities, the Taji entries
+// and the ledger without changing any of them.
+func (s *syncer) inconsistencies(activities []runDetails, events []tajiEvent, partial bool) (found []inconsistency) {
+	matched := make(map[string]bool)
+	index := indexEvents(events, s.u.settings)
+	for _, run := range activities {
+		status, log_id := s.u.state.ledgerStatus(run)
+		event, ok := index.find(run)
+		if ok {
+			matched[event.entry] = true
+			if class, conflict := s.u.settings.classifyMatch(run, event); conflict {
+				found = append(found, inconsistency{DRIFT_MISMATCH, run.strava_id, event.entry, run.date, run.time,
+					fmt.Sprintf("%s: %s mi in %s on Strava, %s mi in %s on Taji", class, run.distance, run.duration, event.distance, event.duration)})
+			}
+			if log_id != "" && log_id != event.entry {
+				found = append(found, inconsistency{DRIFT_RELINKED, run.strava_id, event.entry, run.date, run.time,
+					fmt.Sprintf("the ledger links this activity to Taji entry %s", log_id)})
+			}
+			continue
+		}
+		if match, ok := s.u.state.pendingMatch(run.strava_id); ok {
+			matched[match.LogId] = true
+			if match.Resolution == "" {
+				found = append(found, inconsistency{DRIFT_UNRESOLVED, run.strava_id, match.LogId, run.date, run.time,
+					"possible duplicate waiting for taju resolve"})
+			}
+			continue
+		}
+		switch status {
+		case STATE_UPLOADED:
+			found = append(found, inconsistency{DRIFT_NOT_ON_TAJI, run.strava_id, log_id, run.date, run.time,
+				"the ledger has it as uploaded but no Taji entry matches it"})
+		case STATE_POSTING:
+			found = append(found, inconsistency{DRIFT_UNCONFIRMED, run.strava_id, "", run.date, run.time,
+				"a post was started but never confirmed and no Taji entry matches it"})
+		}
+		if duplicate, ok := findSuspectedDuplicate(run, events); ok {
+			matched[duplicate.entry] = true
+			found = append(found, inconsistency{DRIFT_DUPLICATE, run.strava_id, duplicate.entry, run.date, run.time,
+				fmt.Sprintf("looks like Taji entry %s at %s", duplicate.entry, duplicate.time)})
+		} else if uncertain, ok := findUncertainDuplicate(run, events); ok {
+			matched[uncertain.entry] = true
+			found = append(found, inconsistency{DRIFT_DUPLICATE, run.strava_id, uncertain.entry, run.date, run.time,
+				fmt.Sprintf("might be Taji entry %s at %s", uncertain.entry, uncertain.time)})
+		}
+	}
+	// An incremental fetch doesn't see the older activities, see plan.
+	if partial {
+		return
+	}
+	for _, event := range events {
+		if !matched[event.entry] && !s.u.state.isManual(event.entry) {
+			found = append(found, inconsistency{DRIFT_NO_STRAVA, 0, event.entry, event.date, event.time,
+				fmt.Sprintf("%s mi in %s on Taji without a Strava activity", event.distance, event.duration)})
+		}
+	}
+	return
+}
+
+func printInconsistencies(w io.Writer, found []inconsistency) {
+	fmt.Fprintf(w, "Strict mode: %d inconsistencies between the ledger, Strava and Taji, nothing was changed on Taji.\n", len(found))
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "KIND\tSTRAVA\tTAJI ENTRY\tDATE\tTIME\tDETAIL")
+	for _, i := range found {
+		strava := "-"
+		if i.strava != 0 {
+			strava = fmt.Sprint(i.strava)
+		}
+		log_id := i.log_id
+		if log_id == "" {
+			log_id = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", i.kind, strava, log_id, i.date, i.time, i.detail)
+	}
+	tw.Flush()
+	fmt.Fprintln(w, "Fix the drift (taju reconcile shows and fixes most of it) or run without --strict to let the conflict policies decide.")
+}
+
+// abortStrict ends a strict cycle that found drift without saving the
+// ledger.
+func (s *syncer) abortStrict(result *cycleResult, found []inconsistency) {
+	printInconsistencies(os.Stderr, found)
+	s.failed(fmt.Errorf("strict mode found %d inconsistencies", len(found)))
+	result.inconsistencies = found
+	result.failed = true
+	saveStravaTokens(s.u)
+	saveTajiSession(s.u)
+}
@@ -0,0 +1,705 @@
+package taju
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+)
+
+type cycleResult struct {
+	events     []tajiEvent
+	activities []runDetails
+	partial    bool
+	plan       []plannedAction
+	posted     []runDetails
+	failed     bool
+	standings  *teamStandings
+	progressSnapshot
+	// taji_only are the entries logged on the Taji site, see
+	// recordTajiOnly.
+	taji_only []runDetails
+	// inconsistencies stopped a strict cycle, see abortStrict.
+	inconsistencies []inconsistency
+
+	// taji_requests counts the requests the cycle sent to Taji.
+	taji_requests int64
+}
+
+const (
+	ACTION_POST   string = "post"
+	ACTION_UPDATE string = "update"
+	ACTION_DELETE string = "delete"
+)
+
+// plannedAction is one change the cycle intends to make on Taji. Planning
+// never writes to Taji, so a plan can be shown for review before it runs.
+type plannedAction struct {
+	kind   string
+	run    runDetails
+	event  tajiEvent
+	reason string
+}
+
+// syncer runs sync cycles for an uploader. Features that react to a cycle
+// (notifications, metrics, audit trails) subscribe to its events instead of
+// being wired into the cycle itself, see eventBus.
+//
+// Only one cycle runs at a time: the uploader's env, Strava accounts and
+// Taji session are owned by the running cycle, so a sync triggered from
+// another goroutine waits for the current one to finish instead of racing it.
+type syncer struct {
+	u        *uploader
+	strava   []stravaService
+	taji     tajiService
+	events   eventBus
+	policies conflictPolicies
+	guard    guardRails
+	grace    time.Duration
+	pause    time.Duration
+	split    splitRules
+	order    string
+	backoff  queueBackoff
+	mu       sync.Mutex
+	running  sync.Mutex
+	// current is the run in flight, see run.
+	current runState
+	// pace, if set, spaces out the posts, see backfillPace.
+	pace    *backfillPace
+	breaker tajiBreaker
+
+	// confirm_posts leaves posted runs at STATE_POSTING until they are
+	// seen on the participant page, see confirmPost.
+	confirm_posts bool
+
+	dry_run      bool
+	confirm_plan bool
+	strict       bool
+	// plan_out is where dry runs print the plan, stdout unless that
+	// carries --emit jsonl.
+	plan_out io.Writer
+	// scratch syncs leave the Strava cursors alone, so a demo against a
+	// throwaway Taji doesn't make the next real sync skip activities.
+	scratch bool
+}
+
+// newSyncer syncs the accounts and import directories of u to its Taji
+// session, with the policies and limits of its configuration.
+func newSyncer(u *uploader) (*syncer, error) {
+	s := &syncer{u: u, taji: &u.taji, confirm_posts: envBool(u.env, "TAJU_CONFIRM_POSTS"), plan_out: os.Stdout}
+	var err error
+	if s.policies, err = loadConflictPolicies(u.env); err != nil {
+		return nil, err
+	}
+	if s.guard, err = loadGuardRails(u.env); err != nil {
+		return nil, err
+	}
+	if s.grace, err = loadGracePeriod(u.env); err != nil {
+		return nil, err
+	}
+	if s.pause, err = loadManualEditPause(u.env); err != nil {
+		return nil, err
+	}
+	if s.split, err = loadSplitRules(u.env, u.settings); err != nil {
+		return nil, err
+	}
+	if s.order, err = loadPostOrder(u.env); err != nil {
+		return nil, err
+	}
+	if s.backoff, err = loadQueueBackoff(u.env); err != nil {
+		return nil, err
+	}
+	if s.breaker, err = loadTajiBreaker(u.env); err != nil {
+		return nil, err
+	}
+	for _, account := range u.accounts {
+		s.strava = append(s.strava, account)
+	}
+	sources, err := loadFileSources(u)
+	if err != nil {
+		return nil, err
+	}
+	for _, source := range sources {
+		s.strava = append(s.strava, source)
+	}
+	return s, nil
+}
+
+func (s *syncer) decided(decision string, run runDetails, result string, err error) {
+	s.events.publish(activityDecided{decision, run, result, err})
+}
+
+func (s *syncer) failed(err error) {
+	log.Print("Error:", err)
+	s.events.publish(errorOccurred{err})
+}
+
+// cycle uploads every Strava activity that is not on Taji yet. Strava and
+// Taji are read at the same time, then the changes are made one after the
+// other. The two halves degrade separately: without Strava, Taji is still
+// read and reconciled against the activities fetched before, and without
+// Taji the fetched activities stay queued (the cursor isn't moved past
+// them, and the outbox keeps them) until a cycle can read Taji again.
+func (s *syncer) cycle() cycleResult {
+	s.running.Lock()
+	defer s.running.Unlock()
+	return s.runCycle()
+}
+
+// runCycle is cycle for a caller already holding running.
+func (s *syncer) runCycle() (result cycleResult) {
+	u := s.u
+	u.settings.templates.reset()
+	s.events.publish(cycleStarted{})
+	requests := u.taji.transfer.Requests.Load()
+	defer func() { result.taji_requests = u.taji.transfer.Requests.Load() - requests }()
+
+	var taji tajiView
+	var reading sync.WaitGroup
+	reading.Add(1)
+	go func() {
+		defer reading.Done()
+		started := u.clock.Now()
+		taji = s.readTaji()
+		slog.Debug("Read Taji", "entries", len(taji.entries), "took", u.clock.Now().Sub(started), "error", taji.err)
+	}()
+
+	var failed atomic.Bool
+	started := u.clock.Now()
+	stravaActivities := s.fetchStrava(&result, &failed)
+	slog.Debug("Read Strava", "activities", len(stravaActivities), "took", u.clock.Now().Sub(started))
+	// The daily cap counts every account's activities together.
+	sortRuns(stravaActivities)
+	stravaActivities = s.split.combineDaily(stravaActivities)
+	stravaActivities = s.split.capDaily(stravaActivities, func(run runDetails, reason string) {
+		s.decided("skip", run, reason, nil)
+	})
+	stravaActivities = s.withQueued(stravaActivities, !result.partial && !failed.Load())
+	reading.Wait()
+	entries, events, drift, err := taji.entries, taji.events, taji.drift, taji.err
+
+	if s.cancelled() {
+		// Reads cut short by the cancellation aren't Taji or Strava
+		// failing, and nothing was changed yet.
+		log.Print("Sync cancelled before changing Taji")
+		saveStravaTokens(u)
+		saveTajiSession(u)
+		if err := u.state.save(); err != nil {
+			s.failed(err)
+		}
+		result.activities = stravaActivities
+		result.taji_only = u.state.tajiOnlyRuns(u.settings, nil)
+		result.partial = true
+		s.measure(&result, stravaActivities)
+		s.events.publish(cycleCompleted{result})
+		return
+	}
+	if err != nil {
+		if s.allowTaji() == nil {
+			s.tajiRead(err)
+		}
+		s.failed(err)
+		s.queue(stravaActivities, err)
+		saveTajiSession(u)
+		saveStravaTokens(u)
+		if err := u.state.save(); err != nil {
+			s.failed(err)
+		}
+		result.activities = stravaActivities
+		result.taji_only = u.state.tajiOnlyRuns(u.settings, nil)
+		result.failed = true
+		s.measure(&result, stravaActivities)
+		s.events.publish(cycleCompleted{result})
+		return
+	}
+
+	s.tajiRead(nil)
+
+	if journal, ok := loadJournal(u.path(JOURNAL_FILENAME)); ok {
+		journal.recover(u.state, u.settings, events)
+	}
+	if s.strict {
+		if drift = append(drift, s.inconsistencies(stravaActivities, events, result.partial)...); len(drift) > 0 {
+			s.abortStrict(&result, drift)
+			result.events = events
+			result.activities = stravaActivities
+			result.taji_only = u.state.tajiOnlyRuns(u.settings, entries)
+			s.measure(&result, stravaActivities)
+			s.events.publish(cycleCompleted{result})
+			return
+		}
+	}
+
+	started = u.clock.Now()
+	var plan []plannedAction
+	entries, events, plan = s.plan(stravaActivities, entries, events, result.partial)
+	orderPosts(plan, s.order)
+	plan = s.holdQueued(plan)
+	if until, paused := u.state.pausedUntil(u.clock.Now()); paused && len(plan) > 0 {
+		log.Printf("Postponing %d changes until %s while entries are being edited on Taji", len(plan), until.Local().Format(time.Kitchen))
+		for _, action := range plan {
+			s.decided("skip", action.run, "paused for manual edits", nil)
+		}
+		plan = nil
+	}
+	result.plan = plan
+
+	switch {
+	case s.dry_run:
+		printPlan(s.plan_out, plan)
+		for _, action := range plan {
+			s.decided(action.kind, action.run, "dry run", nil)
+		}
+	case s.confirm_plan && len(plan) > 0:
+		printPlan(s.plan_out, plan)
+		if !confirm(fmt.Sprintf("Apply these %d changes to Taji?", len(plan))) {
+			for _, action := range plan {
+				s.decided(action.kind, action.run, "declined", nil)
+			}
+			break
+		}
+		fallthrough
+	default:
+		result.posted = s.execute(plan, &failed)
+	}
+	slog.Debug("Planned and made the changes", "planned", len(plan), "posted", len(result.posted), "took", u.clock.Now().Sub(started))
+
+	if s.confirm_posts {
+		for _, run := range result.posted {
+			var event tajiEvent
+			var confirmed bool
+			entries, events, event, confirmed = s.confirmPost(run, entries, events)
+			if confirmed {
+				u.state.record(run, STATE_UPLOADED, event.entry)
+			} else {
+				// The run stays at STATE_POSTING and is looked for again,
+				// or posted again, on the next sync.
+				u.state.enqueue(run, errUnconfirmed, nil)
+			}
+		}
+	}
+
+	result.standings = s.refreshStandings()
+
+	saveStravaTokens(u)
+	saveTajiSession(u)
+	if !failed.Load() && !s.dry_run && !s.scratch {
+		saveStravaCursors(u)
+	}
+	if err := u.state.save(); err != nil {
+		s.failed(err)
+	}
+
+	result.events = events
+	result.activities = stravaActivities
+	result.taji_only = u.state.tajiOnlyRuns(u.settings, entries)
+	result.failed = failed.Load()
+	s.measure(&result, stravaActivities)
+	s.events.publish(cycleCompleted{result})
+	return
+}
+
+// fetchStrava reads the activities of every account in turn, stopping when
+// the run is cancelled. An account that fails makes the cycle partial.
+func (s *syncer) fetchStrava(result *cycleResult, failed *atomic.Bool) (fetched []runDetails) {
+	for _, account := range s.strava {
+		if s.cancelled() {
+			result.partial = true
+			break
+		}
+		activities, partial, err := account.Activities()
+		if err != nil && !s.cancelled() {
+			failed.Store(true)
+			s.failed(err)
+		}
+		if err != nil {
+			// Taji entries of activities that couldn't be fetched must
+			// not look like they have no Strava counterpart.
+			partial = true
+		}
+		result.partial = result.partial || partial
+		for _, run := range activities {
+			s.events.publish(activityDiscovered{run})
+		}
+		fetched = append(fetched, activities...)
+	}
+	return
+}
+
+// tajiView is what a cycle read from Taji, see readTaji.
+type tajiView struct {
+	entries []string
+	events  []tajiEvent
+	// drift are the entries that vanished from Taji, for strict cycles.
+	drift []inconsistency
+	err   error
+}
+
+// readTaji lists the Taji entries and reads the events behind them, while
+// the Strava activities are fetched. Planning against an incomplete view
+// of Taji would re-post entries that are already there, so a cycle stops
+// if Taji can't be read. Only entries the ledger (or the scrape cache)
+// doesn't know yet need their edit page read.
+func (s *syncer) readTaji() (view tajiView) {
+	u := s.u
+	if view.err = s.allowTaji(); view.err != nil {
+		return
+	}
+	if view.entries, view.err = s.taji.Entries(); view.err != nil {
+		return
+	}
+	if s.strict {
+		view.drift = u.state.vanishedEntries(view.entries)
+	}
+	var rows map[string]string
+	if listing, ok := s.taji.(listingService); ok {
+		rows = listing.entryRows()
+	}
+	var unknown []string
+	var scraped []tajiEvent
+	view.events, unknown = u.state.knownEvents(view.entries, rows)
+	scraped, view.err = s.taji.Events(unknown)
+	u.state.cacheEvents(scraped)
+	view.events = append(view.events, scraped...)
+	if view.err == nil {
+		// Only a page whose every entry was read is the whole picture.
+		u.state.noteListing(rows)
+		u.state.forgetVanished(view.entries)
+		if found := u.state.noteManualEdits(scraped, s.pause, u.clock.Now()); found > 0 {
+			log.Printf("%d entries were logged on the Taji site, pausing automated changes for %s", found, s.pause)
+		}
+	}
+	return
+}
+
+// queue puts the activities in the outbox because Taji couldn't be read.
+// Those the ledger has as uploaded need nothing more, and those still in
+// their grace period or held for review aren't ready to post anyway.
+func (s *syncer) queue(activities []runDetails, err error) {
+	queued := 0
+	for _, run := range activities {
+		if s.u.state.uploaded(run) {
+			continue
+		}
+		queued++
+		if !inGracePeriod(run, s.grace, s.u.clock.Now()) && s.guard.check(run) == "" {
+			s.u.state.enqueue(run, err, nil)
+		}
+		s.decided("skip", run, "queued until Taji is reachable", nil)
+	}
+	if queued > 0 {
+		log.Printf("Taji can't be read, %d Strava activities are queued for the next sync", queued)
+	}
+}
+
+// plan decides what to do with every Strava activity and unmatched Taji
+// entry, applying the conflict policies.
+func (s *syncer) plan(activities []runDetails, entries []string, events []tajiEvent, partial bool) ([]string, []tajiEvent, []plannedAction) {
+	var plan []plannedAction
+	matched := make(map[string]bool)
+	index := indexEvents(events, s.u.settings)
+	// A previous POST may have timed out after Taji accepted it, so the
+	// participant page is read again, once, before any run is taken for new.
+	if slices.ContainsFunc(activities, func(run runDetails) bool { _, ok := index.find(run); return !ok }) {
+		if entries, events = refreshTajiEvents(s.taji, entries, events); len(events) != len(index.events) {
+			index = indexEvents(events, s.u.settings)
+		}
+	}
+	for _, run := range activities {
+		if !s.passesGuard(run) {
+			if event, ok := index.find(run); ok {
+				matched[event.entry] = true
+			}
+			continue
+		}
+		event, ok := index.find(run)
+		if ok {
+			matched[event.entry] = true
+			s.u.state.link(run, event)
+			class, conflict := s.u.settings.classifyMatch(run, event)
+			if !conflict {
+				s.decided("skip", run, "already uploaded", nil)
+				continue
+			}
+			description := fmt.Sprintf("%s on %s at %s is %s mi in %s on Strava but %s mi in %s on Taji",
+				run.activity, run.date, run.time, run.distance, run.duration, event.distance, event.duration)
+			if s.policies.resolve(class, description, s.u.settings.interactive()) {
+				plan = append(plan, plannedAction{kind: ACTION_UPDATE, run: run, event: event, reason: string(class)})
+			} else {
+				s.decided("skip", run, string(class), nil)
+			}
+			continue
+		}
+
+		if match, ok := s.u.state.pendingMatch(run.strava_id); ok {
+			switch match.Resolution {
+			case RESOLUTION_SAME:
+				if i := slices.IndexFunc(events, func(e tajiEvent) bool { return e.entry == match.LogId }); i >= 0 {
+					matched[match.LogId] = true
+					s.u.state.link(run, events[i])
+					s.decided("skip", run, "resolved as the same entry", nil)
+					continue
+				}
+			case RESOLUTION_DIFFERENT:
+				if !s.inGracePeriod(run) {
+					plan = append(plan, plannedAction{kind: ACTION_POST, run: run, reason: "resolved as different"})
+				}
+				continue
+			default:
+				matched[match.LogId] = true
+				s.decided("skip", run, "awaiting resolution", nil)
+				continue
+			}
+		}
+
+		if duplicate, ok := findSuspectedDuplicate(run, events); ok {
+			matched[duplicate.entry] = true
+			description := fmt.Sprintf("%s on %s at %s looks like Taji entry %s at %s", run.activity, run.date, run.time, duplicate.entry, duplicate.time)
+			if s.policies[CONFLICT_DUPLICATE] != POLICY_LOG {
+				if s.policies.resolve(CONFLICT_DUPLICATE, description, s.u.settings.interactive()) {
+					plan = append(plan, plannedAction{kind: ACTION_UPDATE, run: run, event: duplicate, reason: string(CONFLICT_DUPLICATE)})
+				} else {
+					s.decided("skip", run, "suspected duplicate", nil)
+				}
+				continue
+			}
+			s.policies.resolve(CONFLICT_DUPLICATE, description, s.u.settings.interactive())
+		} else if uncertain, ok := findUncertainDuplicate(run, events); ok && run.strava_id != 0 && run.part == 0 {
+			matched[uncertain.entry] = true
+			s.u.state.queueMatch(run, uncertain)
+			log.Printf("%s on %s at %s might be Taji entry %s at %s, run `taju resolve` to decide",
+				run.activity, run.date, run.time, uncertain.entry, uncertain.time)
+			s.decided("skip", run, "awaiting resolution", nil)
+			continue
+		}
+		if !s.inGracePeriod(run) {
+			plan = append(plan, plannedAction{kind: ACTION_POST, run: run, reason: "new"})
+		}
+	}
+
+	// An incremental fetch doesn't see older Strava activities, and a
+	// failed one (see fetchStrava) doesn't see any, so any Taji entry
+	// would look like it has no Strava counterpart and be deleted by
+	// TAJU_POLICY_TAJI_ONLY=overwrite.
+	if partial {
+		return entries, events, plan
+	}
+	var taji_only []tajiEvent
+	for _, event := range events {
+		if matched[event.entry] || s.u.state.isManual(event.entry) {
+			continue
+		}
+		description := fmt.Sprintf("Taji entry %s on %s at %s has no Strava activity", event.entry, event.date, event.time)
+		if s.policies.resolve(CONFLICT_TAJI_ONLY, description, s.u.settings.interactive()) {
+			plan = append(plan, plannedAction{kind: ACTION_DELETE, event: event, reason: string(CONFLICT_TAJI_ONLY)})
+		} else {
+			taji_only = append(taji_only, event)
+		}
+	}
+	s.u.state.recordTajiOnly(taji_only)
+	return entries, events, plan
+}
+
+// measure fills in the goal progress of a cycle's activities.
+func (s *syncer) measure(result *cycleResult, activities []runDetails) {
+	result.progressSnapshot = takeSnapshot(s.u, slices.Concat(activities, result.taji_only))
+}
+
+// passesGuard holds activities with impossible values for review, see
+// guardRails. Matched Taji entries of held activities are left alone too,
+// since the Strava side can't be trusted.
+func (s *syncer) passesGuard(run runDetails) bool {
+	reason := s.guard.check(run)
+	if reason == "" {
+		return true
+	}
+	switch s.u.state.reviewDecision(run.strava_id) {
+	case REVIEW_APPROVED:
+		return true
+	case REVIEW_REJECTED:
+		s.decided("skip", run, "rejected in review", nil)
+	default:
+		if s.u.state.holdForReview(run, reason) {
+			log.Printf("Holding %s on %s at %s for review: %s. Run `taju review` to decide.", run.activity, run.date, run.time, reason)
+		}
+		s.decided("skip", run, "held for review", nil)
+	}
+	return false
+}
+
+// inGracePeriod holds back new activities that may still be edited on
+// Strava, see loadGracePeriod. Entries already on Taji still follow edits.
+func (s *syncer) inGracePeriod(run runDetails) bool {
+	if !inGracePeriod(run, s.grace, s.u.clock.Now()) {
+		return false
+	}
+	s.decided("skip", run, "in grace period", nil)
+	return true
+}
+
+const (
+	ORDER_OLDEST string = "oldest"
+	ORDER_NEWEST string = "newest"
+)
+
+// loadPostOrder reads TAJU_POST_ORDER, the order pending activities are
+// posted in: oldest first keeps the Taji log chronological, newest first
+// gets today's activity on Taji before a long backfill is through.
+func loadPostOrder(env map[string]string) (string, error) {
+	value, ok := env["TAJU_POST_ORDER"]
+	if !ok {
+		return ORDER_OLDEST, nil
+	}
+	switch strings.ToLower(value) {
+	case ORDER_OLDEST, "chronological":
+		return ORDER_OLDEST, nil
+	case ORDER_NEWEST, "newest-first":
+		return ORDER_NEWEST, nil
+	}
+	return "", fmt.Errorf("invalid TAJU_POST_ORDER=%q, expected oldest or newest", value)
+}
+
+// orderPosts sorts the posts of a plan by start time in the given order.
+// Updates and deletes keep their places. With several post workers the
+// posts start in this order but may finish out of it.
+func orderPosts(plan []plannedAction, order string) {
+	var slots []int
+	var posts []plannedAction
+	for i, action := range plan {
+		if action.kind == ACTION_POST {
+			slots = append(slots, i)
+			posts = append(posts, action)
+		}
+	}
+	slices.SortStableFunc(posts, func(a, b plannedAction) int {
+		c := strings.Compare(a.run.date+a.run.time, b.run.date+b.run.time)
+		if order == ORDER_NEWEST {
+			return -c
+		}
+		return c
+	})
+	for i, slot := range slots {
+		plan[slot] = posts[i]
+	}
+}
+
+// execute applies a plan to Taji and returns the runs that were posted.
+// Posts go through the post worker pool, edits and deletes run one by one.
+// The plan is journaled first, see cycleJournal.
+func (s *syncer) execute(plan []plannedAction, failed *atomic.Bool) (posted []runDetails) {
+	u := s.u
+	if len(plan) == 0 {
+		return
+	}
+	journal, err := beginJournal(u.path(JOURNAL_FILENAME), plan, u.clock.Now())
+	if err != nil {
+		// Without the journal a crash could post twice, so nothing runs.
+		failed.Store(true)
+		s.failed(fmt.Errorf("writing the cycle journal: %w", err))
+		return
+	}
+	var posts []int
+	for i, action := range plan {
+		var err error
+		switch action.kind {
+		case ACTION_POST:
+			posts = append(posts, i)
+			continue
+		}
+		if s.cancelled() {
+			s.decided(action.kind, action.run, "cancelled", nil)
+			continue
+		}
+		journal.mark(i, JOURNAL_STARTED)
+		switch action.kind {
+		case ACTION_UPDATE:
+			err = s.taji.Update(action.event.entry, action.run)
+		case ACTION_DELETE:
+			err = s.taji.Delete(action.event.entry)
+		}
+		if err == nil && action.kind == ACTION_UPDATE {
+			u.state.record(action.run, STATE_UPLOADED, action.event.entry)
+		}
+		if err != nil {
+			journal.mark(i, JOURNAL_FAILED)
+			failed.Store(true)
+			s.failed(err)
+			s.decided(action.kind, action.run, "failed", err)
+			continue
+		}
+		journal.mark(i, JOURNAL_DONE)
+		s.decided(action.kind, action.run, action.kind+"d", nil)
+	}
+
+	forEachLimit(len(posts), u.post_workers, func(i int) {
+		run := plan[posts[i]].run
+		if s.cancelled() {
+			s.decided(ACTION_POST, run, "cancelled", nil)
+			return
+		}
+		if s.pace != nil {
+			s.pace.wait()
+		}
+		// posting marks an attempt whose outcome isn't known yet; the entry
+		// is linked to its Taji log id once it shows up on the page.
+		u.state.record(run, STATE_POSTING, "")
+		journal.mark(posts[i], JOURNAL_STARTED)
+		log_id, err := s.taji.Post(run)
+		if err != nil {
+			journal.mark(posts[i], JOURNAL_FAILED)
+		} else {
+			journal.mark(posts[i], JOURNAL_DONE)
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.events.publish(entryPosted{run, err})
+		if err != nil {
+			failed.Store(true)
+			s.failed(err)
+			s.decided(ACTION_POST, run, "failed", err)
+			u.state.recordFailure(run, err)
+			u.state.enqueue(run, err, &s.backoff)
+			return
+		}
+		if s.confirm_posts {
+			u.state.record(run, STATE_POSTING, log_id)
+		} else {
+			u.state.record(run, STATE_UPLOADED, log_id)
+		}
+		posted = append(posted, run)
+		s.decided(ACTION_POST, run, "posted", nil)
+	})
+
+	// The journal is only needed until the ledger has the outcome.
+	if err := u.state.save(); err != nil {
+		s.failed(err)
+		return
+	}
+	journal.finish()
+	return
+}
+
+func printPlan(out io.Writer, plan []plannedAction) {
+	if len(plan) == 0 {
+		fmt.Fprintln(out, "Nothing to change on Taji.")
+		return
+	}
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ACTION\tACTIVITY\tDATE\tTIME\tMILES\tDURATION\tTAJI ENTRY\tREASON")
+	for _, action := range plan {
+		run := action.run
+		if action.kind == ACTION_DELETE {
+			run = runDetails{date: action.event.date, time: action.event.time, distance: action.event.distance, duration: action.event.duration}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			action.kind, run.activity, run.date, run.time, run.distance, run.duration, action.event.entry, action.reason)
+	}
+	w.Flush()
+}
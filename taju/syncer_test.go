@@ -0,0 +1,326 @@
+package taju
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TEST_NOW is the fake clock's start in the engine tests, during the
+// default event window.
+var TEST_NOW = time.Date(2026, 2, 20, 12, 0, 0, 0, time.UTC)
+
+// newTestSyncer is a syncer against fake sites, with its ledger and
+// journal in a temporary state directory and the clock at TEST_NOW.
+func newTestSyncer(t *testing.T, env map[string]string, taji tajiService, strava ...stravaService) *syncer {
+	t.Helper()
+	u := &uploader{env: env, clock: newFakeClock(TEST_NOW), post_workers: 1, state_dir: t.TempDir()}
+	settings, err := loadSettings(env, u.state_dir, u.clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.settings, u.taji.settings = settings, settings
+	storage, err := openStorage(env, u.path(STATE_FILENAME))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.state, err = loadState(storage, u.clock); err != nil {
+		t.Fatal(err)
+	}
+	u.settings.useState(u.state, u.path(HISTORY_FILENAME), u.clock)
+	s, err := newSyncer(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.taji, s.strava = taji, strava
+	return s
+}
+
+func TestTajiOnlyPolicy(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy string
+		strava *fakeStrava
+		want   []string
+	}{
+		{name: "overwrite deletes entries without an activity", policy: "overwrite", strava: &fakeStrava{}, want: []string{"7"}},
+		{name: "skip keeps them", policy: "skip", strava: &fakeStrava{}},
+		{name: "failed Strava fetch", policy: "overwrite", strava: &fakeStrava{err: errors.New("strava is down")}},
+		{name: "partial Strava fetch", policy: "overwrite", strava: &fakeStrava{partial: true}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			taji := &fakeTaji{events: []tajiEvent{{entry: "7", date: "2026-02-10", time: "07:00 AM", distance: "3.00", duration: "00:30:00"}}}
+			s := newTestSyncer(t, map[string]string{"TAJU_POLICY_TAJI_ONLY": test.policy}, taji, test.strava)
+			if _, err := s.run(context.Background(), syncOptions{}); err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(taji.deleted, test.want) {
+				t.Errorf("deleted %q, want %q", taji.deleted, test.want)
+			}
+		})
+	}
+}
+
+// slowStrava is a Strava account that notes how many cycles fetch from it
+// at once.
+type slowStrava struct {
+	fakeStrava
+	active, most atomic.Int32
+}
+
+func (f *slowStrava) Activities() ([]runDetails, bool, error) {
+	active := f.active.Add(1)
+	defer f.active.Add(-1)
+	for most := f.most.Load(); active > most && !f.most.CompareAndSwap(most, active); most = f.most.Load() {
+	}
+	time.Sleep(time.Millisecond)
+	return f.fakeStrava.Activities()
+}
+
+func TestConcurrentRuns(t *testing.T) {
+	runs := []runDetails{
+		testRun(t, 1, "2026-02-10T07:00:00Z", 1800, 5000),
+		testRun(t, 2, "2026-02-11T07:00:00Z", 2400, 6000),
+		testRun(t, 3, "2026-02-12T07:00:00Z", 3000, 8000),
+	}
+	strava := &slowStrava{fakeStrava: fakeStrava{runs: runs}}
+	taji := &fakeTaji{}
+	s := newTestSyncer(t, map[string]string{}, taji, strava)
+
+	// Syncs triggered at once (the loop, a webhook, the status page) each
+	// run a whole cycle with their own options, one after the other.
+	var triggers sync.WaitGroup
+	dry_runs := make([]int, 8)
+	for i := range 8 {
+		triggers.Add(1)
+		go func() {
+			defer triggers.Done()
+			s.run(context.Background(), syncOptions{dry_run: i%2 == 0, decided: func(d syncDecision) {
+				if d.Result == "dry run" {
+					dry_runs[i]++
+				}
+			}})
+			s.cycle()
+		}()
+	}
+	triggers.Wait()
+
+	if most := strava.most.Load(); most != 1 {
+		t.Errorf("%d cycles ran at once, want 1", most)
+	}
+	if len(taji.posted) != len(runs) {
+		t.Errorf("posted %d entries, want each of the %d runs once", len(taji.posted), len(runs))
+	}
+	for i, n := range dry_runs {
+		if i%2 == 1 && n > 0 {
+			t.Errorf("run %d was a dry run for %d activities, another run's option leaked", i, n)
+		}
+	}
+	if s.dry_run {
+		t.Error("dry_run left set after the runs")
+	}
+}
+
+func TestDryRunPlanOut(t *testing.T) {
+	run := testRun(t, 1, "2026-02-10T07:00:00Z", 1800, 5000)
+	taji := &fakeTaji{}
+	s := newTestSyncer(t, map[string]string{}, taji, &fakeStrava{runs: []runDetails{run}})
+	var out bytes.Buffer
+	s.dry_run, s.plan_out = true, &out
+	s.cycle()
+	if !bytes.HasPrefix(out.Bytes(), []byte("ACTION")) || !bytes.Contains(out.Bytes(), []byte(ACTION_POST)) {
+		t.Errorf("the plan went elsewhere, plan_out has %q", out.String())
+	}
+	if len(taji.posted) > 0 {
+		t.Error("a dry run posted")
+	}
+}
+
+func TestQueueSync(t *testing.T) {
+	triggers := make(chan string, 1)
+	queueSync(triggers, "Strava activity 1 was created")
+	queueSync(triggers, "requested over HTTP")
+	if reason := <-triggers; reason != "Strava activity 1 was created" {
+		t.Errorf("queued %q, want the first trigger", reason)
+	}
+	select {
+	case reason := <-triggers:
+		t.Errorf("second trigger %q queued, want it covered by the first", reason)
+	default:
+	}
+}
+
+func TestResyncLeavesEntries(t *testing.T) {
+	tests := []struct {
+		name    string
+		seconds int64
+	}{
+		{name: "half hour", seconds: 1800},
+		{name: "hour", seconds: 3600},
+		{name: "long run", seconds: 2*3600 + 3*60 + 9},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			strava := &fakeStrava{runs: []runDetails{testRun(t, 1, "2026-02-08T09:30:00Z", test.seconds, float64(test.seconds)*3)}}
+			taji := &fakeTaji{}
+			s := newTestSyncer(t, map[string]string{}, taji, strava)
+			for range 3 {
+				if _, err := s.run(context.Background(), syncOptions{}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if len(taji.posted) != 1 || len(taji.updated) > 0 {
+				t.Errorf("posted %d and updated %q, want one post and no edits of it", len(taji.posted), taji.updated)
+			}
+		})
+	}
+}
+
+// The participant page is read again once per plan, and an entry a timed
+// out POST left there isn't posted again.
+func TestPlanRereadsTajiOnce(t *testing.T) {
+	runs := []runDetails{
+		testRun(t, 1, "2026-02-10T07:00:00Z", 1800, 5000),
+		testRun(t, 2, "2026-02-11T07:00:00Z", 1800, 5000),
+		testRun(t, 3, "2026-02-12T07:00:00Z", 1800, 5000),
+	}
+	taji := &fakeTaji{}
+	if _, err := taji.Post(runs[0]); err != nil {
+		t.Fatal(err)
+	}
+	s := newTestSyncer(t, map[string]string{}, taji)
+	_, _, plan := s.plan(runs, nil, nil, false)
+	if taji.listings != 1 {
+		t.Errorf("read the participant page %d times, want once", taji.listings)
+	}
+	var posts []int64
+	for _, action := range plan {
+		if action.kind == ACTION_POST {
+			posts = append(posts, action.run.strava_id)
+		}
+	}
+	if !slices.Equal(posts, []int64{2, 3}) {
+		t.Errorf("planned posts of %v, want 2 and 3", posts)
+	}
+}
+
+func TestConfirmPosts(t *testing.T) {
+	run := testRun(t, 1, "2026-02-10T07:00:00Z", 1800, 5000)
+	tests := []struct {
+		name    string
+		listing func(tajiEvent) (tajiEvent, bool)
+		status  string
+	}{
+		{name: "listed as posted", status: STATE_UPLOADED},
+		{name: "not listed", listing: func(event tajiEvent) (tajiEvent, bool) { return event, false }, status: STATE_POSTING},
+		{name: "listed with other values", listing: func(event tajiEvent) (tajiEvent, bool) {
+			event.distance = "9.99"
+			return event, true
+		}, status: STATE_POSTING},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			taji := &fakeTaji{listing: test.listing}
+			s := newTestSyncer(t, map[string]string{"TAJU_CONFIRM_POSTS": "true"}, taji, &fakeStrava{runs: []runDetails{run}})
+			if _, err := s.run(context.Background(), syncOptions{}); err != nil {
+				t.Fatal(err)
+			}
+			s.u.state.mu.Lock()
+			status := s.u.state.entry(run, false).Status
+			s.u.state.mu.Unlock()
+			if status != test.status {
+				t.Errorf("ledger has the run as %q, want %q", status, test.status)
+			}
+			if _, queued := s.u.state.retryAt(run); queued != (test.status == STATE_POSTING) {
+				t.Errorf("queued %v with the run at %q", queued, status)
+			}
+		})
+	}
+
+	// An unconfirmed post that shows up later is linked on the next sync
+	// instead of being posted again.
+	taji := &fakeTaji{listing: func(event tajiEvent) (tajiEvent, bool) { return event, false }}
+	s := newTestSyncer(t, map[string]string{"TAJU_CONFIRM_POSTS": "true"}, taji, &fakeStrava{runs: []runDetails{run}})
+	if _, err := s.run(context.Background(), syncOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	taji.events = append(taji.events, eventOf("101", run))
+	if _, err := s.run(context.Background(), syncOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(taji.posted) != 1 || !s.u.state.uploaded(run) {
+		t.Errorf("posted %d times and uploaded %v, want one post confirmed on the next sync", len(taji.posted), s.u.state.uploaded(run))
+	}
+	if _, queued := s.u.state.retryAt(run); queued {
+		t.Error("the confirmed run is still queued")
+	}
+}
+
+func TestEditedOnSite(t *testing.T) {
+	run := testRun(t, 1, "2026-02-10T07:00:00Z", 1800, 5000)
+	taji := &fakeTaji{rows: map[string]string{"101": "a"}}
+	s := newTestSyncer(t, map[string]string{"TAJU_POLICY_STRAVA_EDIT": "skip"}, taji, &fakeStrava{runs: []runDetails{run}})
+	ledger := func() ledgerEntry {
+		s.u.state.mu.Lock()
+		defer s.u.state.mu.Unlock()
+		return *s.u.state.entry(run, false)
+	}
+	for range 2 {
+		if _, err := s.run(context.Background(), syncOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if entry := ledger(); entry.Listed != "a" || entry.Distance != run.distance {
+		t.Fatalf("ledger has %s mi listed as %q after the post", entry.Distance, entry.Listed)
+	}
+
+	// The entry's row on the participant page changes when it is edited on
+	// the site, so it is read again instead of taken from the ledger.
+	taji.events[0].distance = "4.00"
+	taji.rows["101"] = "b"
+	if _, err := s.run(context.Background(), syncOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if entry := ledger(); entry.Distance != "4.00" || entry.Listed != "b" {
+		t.Errorf("ledger has %s mi listed as %q, want the edited 4.00 listed as b", entry.Distance, entry.Listed)
+	}
+	if len(taji.posted) != 1 || len(taji.updated) > 0 {
+		t.Errorf("posted %d times and updated %q, want the edited entry kept", len(taji.posted), taji.updated)
+	}
+}
+
+func TestForgetVanished(t *testing.T) {
+	run := testRun(t, 1, "2026-02-10T07:00:00Z", 1800, 5000)
+	taji := &fakeTaji{}
+	s := newTestSyncer(t, map[string]string{}, taji, &fakeStrava{})
+	if _, err := s.run(context.Background(), syncOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	s.u.state.link(run, eventOf("101", run))
+
+	// Entry 101 was deleted on the site and a new one can't be read: the
+	// page isn't fully known, so the ledger keeps 101 for now.
+	taji.events = []tajiEvent{{entry: "200", date: "2026-02-11", time: "07:00:AM"}}
+	taji.scrape_err = errors.New("taji is down")
+	s.run(context.Background(), syncOptions{})
+	if !s.u.state.uploaded(run) {
+		t.Error("entry forgotten after a failed read of the page")
+	}
+
+	taji.scrape_err = nil
+	if _, err := s.run(context.Background(), syncOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	s.u.state.mu.Lock()
+	entry := s.u.state.entry(run, false)
+	s.u.state.mu.Unlock()
+	if entry != nil && entry.LogId == "101" {
+		t.Error("entry 101 still in the ledger after a full read without it")
+	}
+}
@@ -0,0 +1,80 @@
+package taju
+
+import (
+	"slices"
+	"time"
+
+	"github.com/tajuploader/config"
+)
+
+// TAJI_ONLY_ACTIVITY is the category of Taji-only entries whose form
+// didn't say.
+const TAJI_ONLY_ACTIVITY string = "other"
+
+// recordTajiOnly replaces the ledger's Taji-only entries: the entries
+// logged directly on taji100.com that no Strava activity matches and that
+// weren't logged with taju add. They are kept with all their values so the
+// totals, the export and the wrap-up count everything on Taji, not just
+// what came from Strava. Only a cycle that fetched every Strava activity of
+// the window knows which entries these are, see plan.
+func (s *stateStore) recordTajiOnly(events []tajiEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	taji_only := make(map[string]*ledgerEntry)
+	for _, event := range events {
+		entry := &ledgerEntry{LogId: event.entry, Status: STATE_UPLOADED, Activity: event.activity, Date: event.date,
+			Time: event.time, Distance: event.distance, Duration: event.duration, Elevation: event.elevation,
+			UpdatedAt: s.clock.Now(), UploadedAt: s.clock.Now()}
+		if known, ok := s.TajiOnly[event.entry]; ok {
+			entry.UploadedAt = known.UploadedAt
+			if *known == *entry {
+				entry.UpdatedAt = known.UpdatedAt
+			}
+		}
+		taji_only[event.entry] = entry
+	}
+	s.TajiOnly = taji_only
+}
+
+// tajiOnlyRuns returns the Taji-only entries still on the participant page
+// as runs, all of them without entries (Taji couldn't be read).
+func (s *stateStore) tajiOnlyRuns(settings *settings, entries []string) (runs []runDetails) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, entry := range s.TajiOnly {
+		if entries != nil && !slices.Contains(entries, entry.LogId) {
+			continue
+		}
+		runs = append(runs, settings.tajiRun(entry.Activity, entry.Date, entry.Time, entry.Distance, entry.Duration, entry.Elevation))
+	}
+	sortRuns(runs)
+	return
+}
+
+// tajiRun turns the values of a Taji entry, in the units of the form, back
+// into a run for totals and goals.
+func (s *settings) tajiRun(activity string, date string, clock string, distance string, duration string, elevation string) runDetails {
+	if activity == "" {
+		activity = TAJI_ONLY_ACTIVITY
+	}
+	run := runDetails{activity: activity, date: date, time: clock, distance: distance, duration: duration, elevation_gain: elevation}
+	if meters, err := parseDistance(distance, s.sportUnit(activity)); err == nil {
+		run.distance_float = meters
+	}
+	if seconds, ok := parseTypedDuration(duration); ok {
+		run.duration_int = seconds
+	}
+	if feet, err := config.ParseNumber(elevation); err == nil {
+		run.elevation_float = feet / meter2feet(1)
+	}
+	if start, err := time.ParseInLocation(DATE_FORMAT+" "+s.taji_units.timeLayout(), date+" "+clock, time.Local); err == nil {
+		run.start = start
+	}
+	return run
+}
+
+// logged is everything the cycle counts towards the goals: the Strava
+// activities and the Taji-only entries.
+func (r cycleResult) logged() []runDetails {
+	return slices.Concat(r.activities, r.taji_only)
+}
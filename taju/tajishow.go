@@ -0,0 +1,138 @@
+package taju
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/tajuploader/tajiclient"
+)
+
+// tajiCommand groups the commands that read Taji directly.
+func tajiCommand(u *uploader, args []string) {
+	if len(args) < 1 {
+		log.Fatal("Usage: taju taji show <log id> [--form]")
+	}
+	switch args[0] {
+	case "show":
+		tajiShowCommand(u, args[1:])
+	default:
+		log.Fatal("Unknown taji command: ", args[0])
+	}
+}
+
+// tajiShowCommand reads one entry's edit page and prints what the parser
+// makes of it, next to what the ledger has, to check the parser against an
+// entry that looks wrong. --form also lists every form field on the page.
+func tajiShowCommand(u *uploader, args []string) {
+	flags := flag.NewFlagSet("taji show", flag.ExitOnError)
+	form := flags.Bool("form", false, "also list every field of the edit form as the page has it")
+	var log_id string
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		log_id, args = args[0], args[1:]
+	}
+	flags.Parse(args)
+	if log_id == "" && flags.NArg() > 0 {
+		log_id = flags.Arg(0)
+	}
+	if log_id == "" {
+		log.Fatal("Usage: taju taji show <log id> [--form]")
+	}
+
+	if err := initTajiSession(u); err != nil {
+		log.Fatal(err)
+	}
+	body, err := u.taji.client.Read(u.taji.run.context(), fmt.Sprintf("/log/%s/edit", log_id), "entry "+log_id)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	entry, parse_err := tajiclient.ParseEntryForm(body, log_id)
+	event := entryEvent(entry)
+	fmt.Fprintf(w, "entry\t%s\n", log_id)
+	fmt.Fprintf(w, "date\t%s\n", event.date)
+	fmt.Fprintf(w, "time\t%s\n", event.time)
+	fmt.Fprintf(w, "distance\t%s\n", event.distance)
+	fmt.Fprintf(w, "duration\t%s\n", event.duration)
+	fmt.Fprintf(w, "key\t%s\n", event.key)
+	if entry, ok := u.state.byLogId(log_id); ok {
+		fmt.Fprintf(w, "ledger\t%s, strava %d%s: %s %s %s %s mi %s\n", entry.Status, entry.StravaId, partSuffix(entry.Part),
+			entry.Activity, entry.Date, entry.Time, entry.Distance, entry.Duration)
+	} else if u.state.isManual(log_id) {
+		fmt.Fprintln(w, "ledger\tlogged with taju add")
+	} else if entry, ok := u.state.byKey(event.key); ok {
+		fmt.Fprintf(w, "ledger\tnot linked, but the key belongs to strava %d%s\n", entry.StravaId, partSuffix(entry.Part))
+	} else {
+		fmt.Fprintln(w, "ledger\tnot in the ledger")
+	}
+	w.Flush()
+
+	if *form {
+		fmt.Println("\nForm fields:")
+		w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "TAG\tNAME\tTYPE\tVALUE\tCHECKED")
+		for _, tag := range []string{"input", "textarea", "select"} {
+			for _, element := range tajiclient.FindElements(body, tag) {
+				if element.Attr("name") == "csrfmiddlewaretoken" {
+					continue
+				}
+				value := element.Attr("value")
+				if tag == "textarea" {
+					value = element.Text
+				}
+				checked := ""
+				if element.Has("checked") || element.Has("selected") {
+					checked = "yes"
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%q\t%s\n", tag, element.Attr("name"), element.Attr("type"), value, checked)
+			}
+		}
+		w.Flush()
+	}
+	dumpEnvFile(u)
+
+	if parse_err != nil {
+		u.settings.artifacts.save("entry-"+log_id+".html", body)
+		log.Fatal("The parser rejected the page: ", parse_err)
+	}
+}
+
+func partSuffix(part int) string {
+	if part == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" part %d", part)
+}
+
+// byLogId returns the ledger entry linked to a Taji entry.
+func (s *stateStore) byLogId(log_id string) (ledgerEntry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, entry := range slices.Concat(slices.Collect(maps.Values(s.Entries)), slices.Collect(maps.Values(s.Parts))) {
+		if entry.LogId == log_id {
+			return *entry, true
+		}
+	}
+	return ledgerEntry{}, false
+}
+
+// byKey returns the ledger entry whose activity has the idempotency key.
+func (s *stateStore) byKey(key string) (ledgerEntry, bool) {
+	if key == "" {
+		return ledgerEntry{}, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, entry := range slices.Concat(slices.Collect(maps.Values(s.Entries)), slices.Collect(maps.Values(s.Parts))) {
+		if idempotencyKey(runDetails{strava_id: entry.StravaId, part: entry.Part}) == key {
+			return *entry, true
+		}
+	}
+	return ledgerEntry{}, false
+}
@@ -0,0 +1,1165 @@
+package taju
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"os/exec"
+	"runtime"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/tajuploader/config"
+	"github.com/tajuploader/stravaclient"
+	"github.com/tajuploader/tajiclient"
+	"golang.org/x/oauth2"
+)
+
+const PORT = 9191
+const ENV_FILENAME string = "taju.env"
+const SYNC_INTERVAL = 12 * time.Hour
+const RETRY_INTERVAL = 15 * time.Minute
+const CURSOR_OVERLAP = time.Hour
+
+type tajiEvent struct {
+	date     string
+	time     string
+	entry    string
+	distance string
+	duration string
+	key      string
+	// activity and elevation are empty when the form doesn't have them.
+	activity  string
+	elevation string
+}
+
+type runDetails struct {
+	strava_id        int64
+	part             int
+	start            time.Time
+	location         *time.Location
+	activity         string
+	date             string
+	time             string
+	time_hours       string
+	time_minutes     string
+	time_ampm        string
+	distance         string
+	duration         string
+	duration_hours   string
+	duration_minutes string
+	duration_seconds string
+	elevation_gain   string
+	distance_float   float64
+	duration_int     int64
+	elevation_float  float64
+	photo_url        string
+	// gear is the Strava gear id, and raw_distance the distance before
+	// TAJU_DISTANCE_ADJUST when it was adjusted.
+	gear         string
+	raw_distance float64
+	// notes is posted next to the idempotency key, see notesTemplate.
+	notes string
+	// distance_unit is the unit of distance when a TAJU_FORM_ template
+	// writes it in another than the taji_units of settings, and form_values the fields it
+	// adds to the form, see sportForm.
+	distance_unit string
+	form_values   map[string]string
+}
+
+type strava struct {
+	name   string
+	token  *oauth2.Token
+	source oauth2.TokenSource
+	conf   *oauth2.Config
+	// scope is what the account granted, see checkStravaScope.
+	scope string
+	ctx   context.Context
+	cache stravaclient.Cache
+	// api is STRAVA_API_URL, or a stand-in for it.
+	api string
+	// run bounds the API requests, see contextService. It is a pointer
+	// since accounts are copied.
+	run *runContext
+
+	detail_workers    int
+	activity_map      map[string]string
+	duration_only     map[string]bool
+	duration_source   map[string]string
+	elevation_streams bool
+	upload_photos     bool
+	notes             notesTemplate
+	trace_mapping     bool
+	auth              authSettings
+	settings          *settings
+
+	// grace keeps the cursor before activities still in their grace period,
+	// so they are fetched again with any edits, see loadGracePeriod.
+	grace time.Duration
+	clock clock
+
+	// cursor is the latest start date seen so far. Once set, polls only ask
+	// Strava for activities after it, and seen keeps what was fetched before.
+	// complete is set once seen covers the whole event window. An activity
+	// split across days has a run per part, see splitRules.
+	cursor   time.Time
+	seen     map[int64][]runDetails
+	complete bool
+	// full_fetch is when the whole event window was last fetched, and
+	// full_interval how long until it is again, see loadFullFetchInterval.
+	// A custom_window (a backfill) doesn't count as one.
+	full_fetch    time.Time
+	full_interval time.Duration
+	custom_window bool
+
+	// intake picks, splits and maps the activities fetched, within the
+	// event window.
+	intake
+}
+
+type taji struct {
+	client *tajiclient.Client
+	// photos downloads the Strava photos attached to posts, through the
+	// network settings like the Strava client.
+	photos *http.Client
+	// transfer counts the traffic of client.
+	transfer tajiclient.TransferStats
+	// team_id is the team linked on the participant page, see
+	// getTajiStandings.
+	team_id string
+	// rows are the digests of the entries' rows on the participant page
+	// last read, see tajiclient.ParseLogRows.
+	rows map[string]string
+
+	fetch_workers int
+
+	// env lets an expired session be renewed mid-cycle, see reloginTaji;
+	// relogged tells the cycle to save the new session.
+	env      map[string]string
+	relogged atomic.Bool
+	// run bounds the requests, see contextService.
+	run runContext
+
+	settings *settings
+}
+
+type uploader struct {
+	env      map[string]string
+	config   map[string]string
+	profile  string
+	accounts []*strava
+	taji     taji
+	clock    clock
+	state    *stateStore
+	scoring  *pointsRules
+	goal     goal
+	messages encouragements
+	settings *settings
+
+	// layers are the settings taken from the config file and the
+	// environment, left out when env is saved.
+	layers config.Layers
+	// state_dir is TAJU_STATE_DIR, see config.StateDir.
+	state_dir string
+	// remote_env is where the tokens are shared, see remoteEnv.
+	remote_env *remoteStorage
+
+	post_workers int
+	// headless is set before initUploader where nothing may prompt (the web
+	// setup, NewSyncer); TAJU_HEADLESS and sync --headless add to it, see
+	// settings.
+	headless bool
+	// credentials is where the secret values are kept, see
+	// loadCredentials.
+	credentials string
+	// participant is set for a member synced in coach mode, see
+	// participants.go; profile is then their name.
+	participant bool
+}
+
+// initUploader loads the configuration. It doesn't talk to Strava or Taji;
+// commands that need them call initStravaAccounts and initTajiSession.
+func initUploader(u *uploader) error {
+	initLogging()
+	if err := loadEnvFile(u); err != nil {
+		return err
+	}
+	var err error
+	if u.clock, err = newClock(u.env); err != nil {
+		return err
+	}
+	if err := configureLogging(u.env, u.state_dir, u.clock); err != nil {
+		return err
+	}
+	u.post_workers = envWorkers(u.env, "TAJU_POST_WORKERS", DEFAULT_POST_WORKERS)
+	return loadUploader(u)
+}
+
+// loadUploader loads what the configuration of u decides once its env file
+// is loaded: the settings, the ledger, the goal and the scoring.
+func loadUploader(u *uploader) error {
+	settings, err := loadSettings(u.env, u.state_dir, u.clock)
+	if err != nil {
+		return err
+	}
+	settings.headless = settings.headless || u.headless
+	u.settings, u.taji.settings = settings, settings
+	storage, err := openStorage(u.env, u.path(STATE_FILENAME))
+	if err != nil {
+		return err
+	}
+	if u.state, err = loadState(storage, u.clock); err != nil {
+		return err
+	}
+	u.settings.useState(u.state, config.StatePath(u.state_dir, HISTORY_FILENAME), u.clock)
+	if u.scoring, err = loadPointsRules(u.env); err != nil {
+		return err
+	}
+	if u.goal, err = loadGoal(u.env, u.settings.display); err != nil {
+		return err
+	}
+	u.messages, err = loadEncouragements(u.env)
+	return err
+}
+
+// initStravaAccounts loads (or authorizes) every account that feeds the sync.
+func initStravaAccounts(u *uploader) error {
+	start, end, err := eventWindow(u.env, u.clock.Now())
+	if err != nil {
+		return err
+	}
+	log.Printf("Syncing activities from %s to %s", start.Format(DATE_FORMAT), end.AddDate(0, 0, -1).Format(DATE_FORMAT))
+
+	for _, name := range u.syncAccounts() {
+		s := &strava{settings: u.settings, clock: u.clock}
+		if err := initStrava(u.env, s, name); err != nil {
+			return err
+		}
+		s.window_start, s.window_end = start, end
+		if s.window_dates, err = loadWindowDates(u.env); err != nil {
+			return err
+		}
+		if s.grace, err = loadGracePeriod(u.env); err != nil {
+			return err
+		}
+		if s.full_interval, err = loadFullFetchInterval(u.env); err != nil {
+			return err
+		}
+		if s.filters, err = loadActivityFilters(u.env); err != nil {
+			return err
+		}
+		loadStravaCursor(u.env, s)
+		u.accounts = append(u.accounts, s)
+	}
+	dumpEnvFile(u)
+	return nil
+}
+
+// initTajiSession loads the Taji session, logging in if there is none.
+func initTajiSession(u *uploader) error {
+	if err := initTaji(u.env, &u.taji); err != nil {
+		return err
+	}
+	if err := checkParticipantId(u); err != nil {
+		return err
+	}
+	dumpEnvFile(u)
+	return nil
+}
+
+func loadEnvFile(u *uploader) error {
+	env, err := godotenv.Read(ENV_FILENAME)
+	if err != nil {
+		return fmt.Errorf("error loading file: '%s'. Make sure that it is in the same directory as this executable", ENV_FILENAME)
+	}
+	u.config = maps.Clone(env)
+	layer, err := config.LoadLayer()
+	if err != nil {
+		return err
+	}
+	u.layers.Apply(env, layer, false)
+	if u.state_dir, err = config.StateDir(env); err != nil {
+		return err
+	}
+	if u.state_dir != "" {
+		if err := config.ReadSaved(config.StatePath(u.state_dir, ENV_FILENAME), env); err != nil {
+			return err
+		}
+	}
+	u.participant = u.profile != "" && slices.Contains(participantNames(env), u.profile)
+	if u.participant {
+		// A participant starts from the captain's settings, see
+		// loadParticipantEnv.
+		u.config = maps.Clone(env)
+		if err := loadParticipantEnv(env, u.state_dir, u.profile); err != nil {
+			return err
+		}
+	} else if u.profile != "" {
+		// Only what differs from the shared settings is saved for a profile.
+		maps.DeleteFunc(env, func(key string, value string) bool { return isProfileKey(key) })
+		u.config = maps.Clone(env)
+		if err := loadProfileEnv(env, u.state_dir, u.profile); err != nil {
+			return err
+		}
+	}
+	if u.credentials, err = loadCredentials(env); err != nil {
+		return err
+	}
+	if u.headless || envBool(env, "TAJU_HEADLESS") {
+		if err := checkPassphrase(env); err != nil {
+			return err
+		}
+	}
+	if err := decryptSecrets(env, u.profile); err != nil {
+		return err
+	}
+	if u.participant {
+		useParticipantLogin(env, u.profile)
+	}
+	u.layers.Apply(env, layer, true)
+	u.env = env
+	loadRemoteEnv(u)
+	return nil
+}
+
+func initStrava(env map[string]string, s *strava, name string) error {
+	if _, ok := env["TAJU_CLIENT_ID"]; !ok {
+		return errors.New("error unpacking TajUploader Client ID")
+	}
+
+	if _, ok := env["TAJU_CLIENT_SECRET"]; !ok {
+		return errors.New("error unpacking TajUploader Client Secret")
+	}
+
+	s.name = name
+	// Token refreshes and API calls share the retrying transport.
+	network, err := loadNetworkSettings(env)
+	if err != nil {
+		return err
+	}
+	vcr, err := newStravaVCR(env, network.transport())
+	if err != nil {
+		return err
+	}
+	s.ctx = context.WithValue(context.Background(), oauth2.HTTPClient, &http.Client{
+		Transport: newRetryTransport(network.wrap(vcr), realClock{}),
+	})
+	s.cache = stravaclient.NewMemoryCache()
+	s.api = STRAVA_API_URL
+	s.detail_workers = envWorkers(env, "TAJU_STRAVA_WORKERS", DEFAULT_STRAVA_WORKERS)
+	if s.activity_map, err = loadActivityMap(env); err != nil {
+		return err
+	}
+	s.duration_only = loadDurationOnly(env)
+	if s.duration_source, err = loadDurationSource(env); err != nil {
+		return err
+	}
+	if s.split, err = loadSplitRules(env, s.settings); err != nil {
+		return err
+	}
+	if s.duplicates, err = loadDuplicateRule(env); err != nil {
+		return err
+	}
+	if s.pipeline, err = loadPipeline(env, s.settings); err != nil {
+		return err
+	}
+	s.elevation_streams = envBool(env, "TAJU_ELEVATION_STREAMS")
+	s.upload_photos = envBool(env, "TAJU_UPLOAD_PHOTOS")
+	if s.notes, err = loadNotesTemplate(env); err != nil {
+		return err
+	}
+	s.conf = stravaConfig(env)
+	if s.auth, err = loadAuthSettings(env); err != nil {
+		return err
+	}
+
+	addRedaction(env["TAJU_CLIENT_SECRET"])
+	if env["TAJU_STRAVA_VCR"] == VCR_REPLAY {
+		// Replayed responses don't need a real token, and one must never
+		// be refreshed against Strava: without an expiry it never is.
+		s.token = &oauth2.Token{AccessToken: "replay"}
+		s.source = oauth2.StaticTokenSource(s.token)
+		return nil
+	}
+	refresh, refresh_ok, err := presupplied(env, stravaRefreshKey(name))
+	if err != nil {
+		return err
+	}
+	if token, ok := env[stravaTokenKey(name)]; ok {
+		json.Unmarshal([]byte(token), &s.token)
+		log.Printf("Successfully loaded Strava Oauth token for account %q", name)
+	} else if refresh_ok {
+		// An expired token makes the token source refresh it on first use.
+		s.token = &oauth2.Token{RefreshToken: refresh, Expiry: time.Unix(1, 0)}
+		token, _ := json.Marshal(s.token)
+		env[stravaTokenKey(name)] = string(token)
+		log.Printf("Using the pre-supplied Strava refresh token for account %q", name)
+	} else {
+		if !s.settings.interactive() {
+			return fmt.Errorf("Strava account %q isn't authorized and nobody can open the browser here. Open\n\n%s\n\n"+
+				"in any browser, approve, copy the code parameter from the address it redirects to and run\n"+
+				"taju auth strava %s --code <code>, or set %s",
+				name, s.conf.AuthCodeURL(authState()), name, stravaRefreshKey(name))
+		}
+		authStrava(s)
+		token, _ := json.Marshal(s.token)
+		env[stravaTokenKey(name)] = string(token)
+		env[stravaScopeKey(name)] = s.scope
+	}
+	checkStravaScope(env, s)
+	addRedaction(s.token.AccessToken)
+	addRedaction(s.token.RefreshToken)
+	s.source = s.conf.TokenSource(s.ctx, s.token)
+	return nil
+}
+
+func stravaConfig(env map[string]string) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     env["TAJU_CLIENT_ID"],
+		ClientSecret: env["TAJU_CLIENT_SECRET"],
+		RedirectURL:  fmt.Sprintf("http://localhost:%d", PORT),
+		Scopes:       []string{requestedScope(env)},
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  "https://www.strava.com/oauth/authorize",
+			TokenURL: "https://www.strava.com/oauth/token",
+		},
+	}
+}
+
+func initTaji(env map[string]string, t *taji) error {
+	if err := initTajiClient(env, t); err != nil {
+		return err
+	}
+
+	var (
+		session tajiclient.Session
+		csrf_ok bool
+		sess_ok bool
+		part_ok bool
+	)
+	session.CSRF, csrf_ok = env["TAJI_CSRF"]
+	session.Id, sess_ok = env["TAJI_SESSION"]
+	session.Participant, part_ok = env["TAJI_PARTICIPANT"]
+
+	if !(csrf_ok && sess_ok && part_ok) {
+		if err := loginTaji(t, env); err != nil {
+			return err
+		}
+	} else {
+		t.client.SetSession(session)
+		log.Print("Successfully loaded Taji session tokens")
+	}
+	saveTajiLogin(t, env)
+	return nil
+}
+
+// initTajiClient sets up the HTTP client and settings of t without logging in.
+func initTajiClient(env map[string]string, t *taji) error {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return err
+	}
+
+	// Retries go through the polite transport too, so they are spaced out.
+	network, err := loadNetworkSettings(env)
+	if err != nil {
+		return err
+	}
+	delay, err := loadTajiDelay(env)
+	if err != nil {
+		return err
+	}
+	polite_mode, err := politeMode(env)
+	if err != nil {
+		return err
+	}
+	polite := newPoliteTransport(network.wrap(tajiclient.NewTransport(network.transport(), &t.transfer)), delay, realClock{})
+	t.client = &tajiclient.Client{
+		HTTP:       &http.Client{Jar: jar, Transport: newRetryTransport(polite, realClock{})},
+		Relogin:    func(ctx context.Context) error { return reloginTaji(ctx, t) },
+		MaxBodyLog: DEFAULT_MAX_BODY_LOG,
+		Redact:     redact,
+		Artifact:   t.settings.artifacts.save,
+		Page:       t.settings.templates.note,
+	}
+	t.photos = network.client(network.timeout)
+	t.env = env
+	workers := DEFAULT_TAJI_WORKERS
+	if polite_mode {
+		workers = POLITE_TAJI_WORKERS
+	}
+	t.fetch_workers = envWorkers(env, "TAJU_TAJI_WORKERS", workers)
+	if value, ok := env["TAJU_MAX_BODY_LOG"]; ok {
+		if n, err := strconv.Atoi(value); err == nil && n >= 0 {
+			t.client.MaxBodyLog = n
+		} else {
+			log.Printf("Ignoring invalid TAJU_MAX_BODY_LOG=%q", value)
+		}
+	}
+	return nil
+}
+
+// saveTajiLogin puts the session of t in env, where dumpEnvFile saves it.
+func saveTajiLogin(t *taji, env map[string]string) {
+	session := t.client.Session()
+	env["TAJI_CSRF"] = session.CSRF
+	env["TAJI_SESSION"] = session.Id
+	env["TAJI_PARTICIPANT"] = session.Participant
+	addRedaction(session.CSRF)
+	addRedaction(session.Id)
+}
+
+// tajiCredentials returns the Taji login, prompting for what isn't set.
+func tajiCredentials(t *taji, env map[string]string) (username string, password string, err error) {
+	if username, err = answer(t.settings, env, "TAJI_USERNAME", "Enter your Taji100 username (it should be your email address) and hit ENTER: "); err != nil {
+		return
+	}
+	password, err = answer(t.settings, env, "TAJI_PASSWORD", "Enter your Taji100 password and hit ENTER: ")
+	return
+}
+
+func loginTaji(t *taji, env map[string]string) error {
+	username, password, err := tajiCredentials(t, env)
+	if err != nil {
+		return err
+	}
+	return tajiLogin(t, username, password)
+}
+
+// tajiLogin logs in to Taji and keeps the session in t's client.
+func tajiLogin(t *taji, username string, password string) error {
+	addRedaction(password)
+	// A login in the middle of a run is cut short with it, see
+	// contextService.
+	_, err := t.client.Login(t.run.context(), username, password)
+	return err
+}
+
+func envBool(env map[string]string, key string) bool {
+	value, err := strconv.ParseBool(env[key])
+	return err == nil && value
+}
+
+func dumpEnvFile(u *uploader) {
+	env, path := u.layers.Persisted(u.env), ENV_FILENAME
+	if u.state_dir != "" || u.profile != "" {
+		env, path = config.Changed(env, u.config), u.path(ENV_FILENAME)
+	}
+	err := writeEnvFile(encryptSecrets(env, u.profile, u.credentials), path)
+	if err != nil {
+		log.Printf("Failed to write tokens to %s: %v", path, err)
+	}
+	saveRemoteEnv(u)
+}
+
+// fullFetchDue reports whether the whole event window is fetched rather
+// than what started after the cursor: for a custom window, when it never
+// was, and every full_interval after that.
+func fullFetchDue(s *strava, now time.Time) bool {
+	return s.custom_window || s.full_fetch.IsZero() || now.Sub(s.full_fetch) >= s.full_interval
+}
+
+// getStravaActivities returns the activities to sync. partial is set when
+// the result only covers activities since the persisted cursor rather than
+// the whole event window, see fullFetchDue. When Strava can't be reached
+// the activities fetched by earlier cycles are returned, as a partial
+// result, so Taji can still be reconciled against them.
+func getStravaActivities(s *strava) (stravaActivities []runDetails, partial bool, err error) {
+	startDate, endDate := queryWindow(s.window_dates, s.window_start, s.window_end)
+
+	after := startDate
+	now := s.clock.Now()
+	if !fullFetchDue(s, now) && !s.cursor.IsZero() && s.cursor.Add(-CURSOR_OVERLAP).After(after) {
+		after = s.cursor.Add(-CURSOR_OVERLAP)
+		if s.split.summaryCursor(after).After(startDate) {
+			after = s.split.summaryCursor(after)
+		} else {
+			after = startDate
+		}
+	}
+	partial = !s.complete && after != startDate
+
+	activities, err := stravaAPI(s).ListAllActivities(after, endDate)
+	if err != nil {
+		log.Print("Error:", err)
+		for _, runs := range s.seen {
+			stravaActivities = append(stravaActivities, runs...)
+		}
+		sortRuns(stravaActivities)
+		return stravaActivities, true, err
+	}
+
+	if s.seen == nil {
+		s.seen = make(map[int64][]runDetails)
+	}
+	if !partial {
+		// Activities deleted on Strava since aren't in a complete fetch.
+		clear(s.seen)
+		if !s.custom_window {
+			s.full_fetch = now
+		}
+	}
+	s.complete = !partial
+	activities = filterActivities(s, completeActivities(s, activities))
+	activities = dropDuplicateUploads(s, activities)
+	fillElevation(s, activities)
+	fillPhotos(s, activities)
+	fillNotes(s, activities)
+	for _, activity := range activities {
+		duration := activityDuration(s, activity)
+		if durationSource(s, activity) == DURATION_AUTO && activity.ElapsedTime > 0 && duration != activity.ElapsedTime {
+			log.Printf("Using the moving time of Strava activity %d (%q), its elapsed time of %s looks wrong",
+				activity.Id, activity.Name, time.Duration(activity.ElapsedTime)*time.Second)
+		}
+		start, err := time.Parse(time.RFC3339, activity.StartDate)
+		held := s.grace > 0 && inGracePeriod(runDetails{start: start, duration_int: duration}, s.grace, s.clock.Now())
+		if err == nil && start.After(s.cursor) && !held {
+			s.cursor = start
+		}
+		if run, ok := activityRun(s, activity, duration); ok {
+			apply := s.pipeline.apply
+			if s.trace_mapping {
+				apply = func(part runDetails) (runDetails, error) { return traceMapping(os.Stderr, s, activity, part) }
+			}
+			runs, err := s.runs(run, apply)
+			if err != nil {
+				log.Printf("Skipping Strava activity %d: %v", activity.Id, err)
+				continue
+			}
+			s.seen[activity.Id] = runs
+		}
+	}
+
+	for _, runs := range s.seen {
+		stravaActivities = append(stravaActivities, runs...)
+	}
+	sortRuns(stravaActivities)
+	return
+}
+
+// activityRun holds the values of a Strava activity the transform pipeline
+// starts from, false if the activity maps to no Taji activity.
+func activityRun(s *strava, activity stravaActivity, duration int64) (runDetails, bool) {
+	taji_activity, ok := tajiActivity(s.activity_map, activity)
+	if !ok {
+		return runDetails{}, false
+	}
+	run := s.settings.createRun(
+		taji_activity,
+		activity.StartDate,
+		duration,
+		activity.Distance)
+	run.strava_id = activity.Id
+	run.location = activityLocation(activity)
+	run.elevation_float = activity.TotalElevationGain
+	run.photo_url = primaryPhotoURL(activity)
+	run.gear = activity.GearId
+	run.notes = s.notes.render(activity, run, s.settings.taji_units)
+	return run, true
+}
+
+func sortRuns(runs []runDetails) {
+	sort.Slice(runs, func(i, j int) bool {
+		return runs[i].date+runs[i].time < runs[j].date+runs[j].time
+	})
+}
+
+func getTajiEntries(t *taji) (entries []string, err error) {
+	body, err := t.client.ParticipantPage(t.run.context())
+	if err != nil {
+		return nil, err
+	}
+	t.team_id = parseTeamId(body)
+	t.rows = tajiclient.ParseLogRows(body)
+	entries = tajiclient.ParseLogEntries(body)
+	return
+}
+
+// getTajiEvents reads every entry's edit form. Entries that fail to load
+// make it return an error, since a missing event could cause a duplicate.
+func getTajiEvents(t *taji, entries []string) (events []tajiEvent, err error) {
+	// A stalled Taji shouldn't hold the cycle forever; entries that weren't
+	// fetched in time fail the cycle and are tried again on the next one.
+	ctx, cancel := context.WithTimeout(t.run.context(), TAJI_FETCH_TIMEOUT)
+	defer cancel()
+	parsed := make([]*tajiEvent, len(entries))
+	errs := make([]error, len(entries))
+	forEachLimit(len(entries), t.fetch_workers, func(i int) {
+		if err := ctx.Err(); err != nil {
+			errs[i] = fmt.Errorf("fetching Taji entry %s: %w", entries[i], err)
+			return
+		}
+		entry, err := t.client.Entry(ctx, entries[i])
+		if err != nil {
+			errs[i] = err
+			return
+		}
+		event := entryEvent(entry)
+		parsed[i] = &event
+	})
+	for _, event := range parsed {
+		if event != nil {
+			events = append(events, *event)
+		}
+	}
+	return events, errors.Join(errs...)
+}
+
+// entryEvent is the event of an entry read from Taji.
+func entryEvent(entry tajiclient.Entry) tajiEvent {
+	return tajiEvent{entry: entry.Id, date: entry.Date, time: entry.Time, distance: entry.Distance, duration: entry.Duration,
+		key: parseIdempotencyKey(entry.Notes), activity: entry.Activity, elevation: entry.Elevation}
+}
+
+// refreshTajiEvents re-reads the participant page and fetches the events for
+// any entries that were not already known.
+func refreshTajiEvents(t tajiService, entries []string, events []tajiEvent) ([]string, []tajiEvent) {
+	known := make(map[string]bool)
+	for _, entry := range entries {
+		known[entry] = true
+	}
+
+	current, err := t.Entries()
+	if err != nil {
+		log.Print("Error re-checking Taji entries: ", err)
+		return entries, events
+	}
+	var fresh []string
+	for _, entry := range current {
+		if !known[entry] {
+			fresh = append(fresh, entry)
+		}
+	}
+	if len(fresh) == 0 {
+		return entries, events
+	}
+	log.Printf("Found %d new Taji entries since the last check", len(fresh))
+	fresh_events, err := t.Events(fresh)
+	if err != nil {
+		log.Print("Error re-checking Taji entries: ", err)
+	}
+	return append(entries, fresh...), append(events, fresh_events...)
+}
+
+// confirmPost fetches the participant page after a POST and checks that the
+// run now shows up as a Taji entry with the expected values. An entry that
+// is missing or differs isn't confirmed, see classifyMatch.
+func (s *syncer) confirmPost(run runDetails, entries []string, events []tajiEvent) ([]string, []tajiEvent, tajiEvent, bool) {
+	entries, events = refreshTajiEvents(s.taji, entries, events)
+	event, ok := s.u.settings.findEvent(run, events)
+	if ok {
+		if _, conflict := s.u.settings.classifyMatch(run, event); conflict {
+			log.Printf("Taji entry %s for %s on %s at %s has other values than were posted", event.entry, run.activity, run.date, run.time)
+			ok = false
+		}
+	}
+	if ok {
+		log.Printf("Confirmed %s on %s at %s", run.activity, run.date, run.time)
+	} else {
+		log.Printf("Could not confirm %s on %s at %s, it will be retried next sync", run.activity, run.date, run.time)
+	}
+	return entries, events, event, ok
+}
+
+// createRun holds the raw Strava values of an activity. The form fields are
+// filled in by the transform pipeline, see loadPipeline. duration is the
+// elapsed or moving time, see activityDuration. The start time and duration
+// are rounded here, see clockRounding.
+func (s *settings) createRun(activity string, date string, duration int64, distance float64) runDetails {
+	t, _ := time.Parse(time.RFC3339, date)
+	return runDetails{
+		activity:       activity,
+		start:          s.roundStart(t),
+		duration_int:   s.roundDuration(duration),
+		distance_float: distance,
+	}
+}
+
+// postRun posts a run to the Taji log form and returns the log id of the
+// new entry, or "" if Taji didn't say.
+func postRun(t *taji, r runDetails) (string, error) {
+	endpoint_path := "/log/new?activity=" + url.QueryEscape(r.activity)
+
+	form, csrfmiddlewaretoken, err := t.client.Form(t.run.context(), endpoint_path)
+	if err != nil {
+		return "", err
+	}
+
+	t.settings.noteFormSchema(form, r.activity)
+	if err := t.settings.formSchemaOf(r.activity).checkSchema(r); err != nil {
+		return "", err
+	}
+	// A step only seen now applies to this post too.
+	if t.settings.detectDistanceStep(form) && r.distance_float > 0 && r.distance != "" {
+		if distance, err := strconv.ParseFloat(r.distance, 64); err == nil {
+			r.distance = t.settings.formatDistance(distance)
+		}
+	}
+	values := t.settings.runValues(csrfmiddlewaretoken, r)
+
+	var res *http.Response
+	if field, ok := tajiclient.ParseFileField(form); ok && r.photo_url != "" {
+		res, err = postRunWithPhoto(t, endpoint_path, values, field, r)
+	} else {
+		if r.photo_url != "" {
+			log.Printf("The Taji log form takes no photo, posting %s on %s without it", r.activity, r.date)
+		}
+		res, err = t.client.PostForm(t.run.context(), endpoint_path, values)
+	}
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	what := fmt.Sprintf("posting %s on %s", r.activity, r.date)
+	if err := t.client.CheckForm(res, what); err != nil {
+		return "", err
+	}
+	return tajiclient.CreatedLogId(res, what)
+}
+
+// runValues builds the Taji log form for a run. The new entry and edit entry
+// forms share the same fields.
+func (s *settings) runValues(csrfmiddlewaretoken string, r runDetails) url.Values {
+	values := url.Values{}
+	values.Add("csrfmiddlewaretoken", csrfmiddlewaretoken)
+	values.Add("activity", r.activity)
+	values.Add("date", r.date)
+	values.Add("time", r.time)
+	values.Add("time_hours", r.time_hours)
+	values.Add("time_minutes", r.time_minutes)
+	values.Add("time_ampm", r.time_ampm)
+	values.Add("distance", r.distance)
+	values.Add("duration", r.duration)
+	values.Add("duration_hours", r.duration_hours)
+	values.Add("duration_minutes", r.duration_minutes)
+	values.Add("duration_seconds", r.duration_seconds)
+	values.Add("elevation_gain", r.elevation_gain)
+	notes := r.notes
+	if key := idempotencyKey(r); key != "" {
+		// The key goes last, after the notes from the template.
+		notes = strings.TrimSpace(notes + "\n\n" + IDEMPOTENCY_PREFIX + key)
+	}
+	if notes != "" {
+		values.Add("notes", notes)
+	}
+	for name, value := range r.form_values {
+		values.Set(name, value)
+	}
+	// Fields the activity's form doesn't have aren't sent.
+	if schema := s.formSchemaOf(r.activity); schema != nil {
+		for name := range values {
+			if name != "csrfmiddlewaretoken" && !schema.keeps(name) {
+				values.Del(name)
+			}
+		}
+	}
+	return values
+}
+
+func meter2mile(meters float64) (miles float64) {
+	miles = meters * 0.000621371
+	return
+}
+
+func meter2feet(meters float64) (feet float64) {
+	feet = meters * 3.28084
+	return
+}
+
+// findEvent returns the Taji event for run, see eventIndex.match. Code
+// matching many runs against the same events indexes them once instead.
+func (s *settings) findEvent(run runDetails, events []tajiEvent) (tajiEvent, bool) {
+	return indexEvents(events, s).find(run)
+}
+
+func updateOutput(now time.Time, result cycleResult, scoring *pointsRules, transfer *tajiclient.TransferStats, interval time.Duration) {
+	clearScreen()
+	fmt.Printf("Synced at %s\n", result.units.clock(now.Local()))
+	fmt.Printf("You have logged %d events\n", len(result.events))
+	if note := result.recordedNote(); note != "" {
+		fmt.Printf("totaling %.2f %s (%s)\n", result.units.fromMiles(result.miles), result.units.name(), note)
+	} else {
+		fmt.Printf("totaling %.2f %s\n", result.units.fromMiles(result.miles), result.units.name())
+	}
+	fmt.Printf("over %d minutes.\n", result.seconds/60)
+	if len(result.taji_only) > 0 {
+		fmt.Printf("That includes %d entries logged on the Taji site.\n", len(result.taji_only))
+	}
+	if scoring != nil {
+		fmt.Printf("for %.1f %s.\n", result.points, scoring.Unit)
+	}
+	for _, total := range result.totals {
+		fmt.Printf("  %-6s %3d events  %7.2f %s", total.activity, total.count, result.units.fromMiles(total.miles), result.units.name())
+		if scoring != nil {
+			fmt.Printf("  %7.1f %s", total.points, scoring.Unit)
+		}
+		fmt.Println()
+	}
+	fmt.Println(result.progress.summary())
+	if result.climb.set() {
+		fmt.Println(result.climb.summary())
+	}
+	if result.encouragement != "" {
+		fmt.Println(result.encouragement)
+	}
+	if result.standings != nil {
+		fmt.Println(result.standings.summary(result.units))
+	}
+	fmt.Printf("Taji traffic: %d requests this sync, %d in total (%d over HTTP/2, %d on reused connections), %d KB sent, %d KB received\n",
+		result.taji_requests, transfer.Requests.Load(), transfer.HTTP2.Load(), transfer.ReusedConns.Load(),
+		transfer.BytesSent.Load()/1024, transfer.BytesReceived.Load()/1024)
+	fmt.Printf("Resyncing at %s.\n", result.units.clock(now.Local().Add(interval)))
+
+}
+
+// clearScreen clears the terminal before the summary is redrawn. Output that
+// goes to a file or pipe is left alone so logs stay readable.
+func clearScreen() {
+	info, err := os.Stdout.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return
+	}
+	if runtime.GOOS == "windows" {
+		// The classic console doesn't understand ANSI escapes unless
+		// virtual terminal processing was turned on.
+		cmd := exec.Command("cmd", "/c", "cls")
+		cmd.Stdout = os.Stdout
+		cmd.Run()
+		return
+	}
+	fmt.Print("\033[H\033[2J")
+}
+
+type activityTotal struct {
+	activity string
+	count    int
+	miles    float64
+	points   float64
+}
+
+// activityTotals breaks the activities down by Taji category, runs first and
+// the other categories alphabetically.
+func activityTotals(activities []runDetails, scoring *pointsRules) (totals []activityTotal) {
+	index := make(map[string]int)
+	for _, activity := range activities {
+		i, ok := index[activity.activity]
+		if !ok {
+			i = len(totals)
+			index[activity.activity] = i
+			totals = append(totals, activityTotal{activity: activity.activity})
+		}
+		totals[i].count++
+		totals[i].miles += meter2mile(activity.distance_float)
+		totals[i].points += scoring.points(activity)
+	}
+	sort.Slice(totals, func(i, j int) bool {
+		if (totals[i].activity == "run") != (totals[j].activity == "run") {
+			return totals[i].activity == "run"
+		}
+		return totals[i].activity < totals[j].activity
+	})
+	return
+}
+
+// syncInterval returns how long to wait before the next cycle. After failed
+// cycles it retries soon, backing off until it is back at the normal interval.
+func syncInterval(every time.Duration, failures int) time.Duration {
+	if failures == 0 {
+		return every
+	}
+	interval := min(RETRY_INTERVAL, every)
+	for i := 1; i < failures && interval < every; i++ {
+		interval *= 2
+	}
+	return min(interval, every)
+}
+
+const USAGE string = `Usage: taju [--profile name] [--log-level info] [--log-format text|json]
+            [--log-file taju.log] [command] [flags]
+
+Commands:
+  sync [--once | --daemon] [--interval 12h] [--dry-run] [--demo] [--confirm] [--emit jsonl]
+       [--trace-mapping] [--headless] [--strict]
+                          upload new Strava activities to Taji (default: --daemon);
+                          before the event the daemon counts down and runs the
+                          preflight checklist weekly
+  status                  show configured accounts, sessions and sync cursors
+                          (of every profile or participant)
+  test-login              check the Taji session and Strava tokens
+  preflight               count down to the event and check the logins and a
+                          test sync of the last week, without posting
+  reconcile [--fix missing,mismatch,orphans | --interactive] [--yes]
+                          report (and fix) drift between Strava, Taji and the
+                          ledger; --interactive picks the fix item by item
+  auth strava [account] [--code <code or redirect address>]
+                          (re)authorize a Strava account; opens the browser
+                          and waits TAJU_AUTH_TIMEOUT for its redirect on
+                          TAJU_AUTH_PORT before asking to paste the address;
+                          also to grant access to private activities
+  auth taji               log in to Taji again
+  accounts [list | add <name> | remove <name> | use <names>]
+                          manage Strava accounts
+  review                  approve or reject activities held for impossible values
+  resolve                 decide whether possible duplicates are the same workout
+  dedupe [--yes]          delete Taji entries that were logged more than once
+  add --date 2025-02-10 --duration 48:30 [--distance 5.2] [--time 07:30] [--activity run]
+                          log an activity that isn't on Strava
+  edit <log id> [--date ...] [--time ...] [--distance ...] [--duration ...]
+                          correct a Taji entry
+  backfill --from 2025-02-01 [--to 2025-02-14] [--batch 10] [--pause 1m] [--yes]
+                          upload what is missing from a past range, in paced
+                          batches after showing the changes
+  import [--activity run] [--force] <file>...
+                          upload activities from GPX, TCX or FIT files
+  export [--format csv|json] [--out feb.csv] [--daily]
+                          write the synced activities from the ledger, or
+                          with --daily the distance per day as a time series
+                          (also served on the control server's /daily)
+  wrapup [--out feb.txt]  summarize the event and the uploader's own stats
+                          (written to taju.wrapup.txt when the event ends)
+  report [--week | --month] [--last] [--format text|markdown|html] [--out week.md]
+                          sum up this (or --last) week or month from the
+                          ledger, to post to the team chat
+  strava show <activity id> [--account name] [--streams] [--mapping]
+                          print an activity as Strava returns it, to see what
+                          the mapping to the Taji form starts from
+  taji show <log id> [--form]
+                          print what the parser reads from a Taji entry's edit
+                          page, next to the ledger
+  forms probe [activity...]
+                          read the Taji log form of each activity and cache
+                          its fields, which posting and validation follow
+  delete <log id>         delete a Taji entry
+  config docs             list every taju.env setting
+  config validate         check taju.yaml (or taju.toml), the environment and taju.env
+  check-forms [--update] [--dir DIR]
+                          compare the Taji form bodies of sample activities
+                          with the goldens, testdata/forms (development)
+  web [--addr :9190]      set up and run taju from the browser (NAS packages)
+  schedule install --every 6h | schedule remove
+                          run "sync --once" from the OS scheduler
+  service install | uninstall | status [--system]
+                          run the sync daemon as a systemd unit, launchd agent
+                          or Windows task at logon; --system installs a
+                          system unit or a Windows service started on boot
+
+Running taju without a command is the same as "taju sync --daemon".
+With TAJU_PROFILES, sync and status cover every profile unless one is
+picked with --profile. With TAJU_PARTICIPANTS (coach mode) they cover the
+captain and every participant, and --profile picks one participant.
+`
+
+// Main runs the taju command line with os.Args, the taju binary's main.
+func Main() {
+	// Flags before the command apply to every command. --profile (or
+	// TAJU_PROFILE) picks one profile.
+	global := flag.NewFlagSet("taju", flag.ExitOnError)
+	global.Usage = func() { fmt.Fprint(os.Stderr, USAGE) }
+	profile := global.String("profile", os.Getenv("TAJU_PROFILE"), "profile to use, see TAJU_PROFILES")
+	global.StringVar(&logOptions.level, "log-level", "", "debug, info, warn or error (overrides TAJU_LOG_LEVEL)")
+	global.StringVar(&logOptions.format, "log-format", "", "text or json (overrides TAJU_LOG_FORMAT)")
+	global.StringVar(&logOptions.file, "log-file", "", "write the log to this file, rotated by size (overrides TAJU_LOG_FILE)")
+	global.Parse(os.Args[1:])
+	os.Args = append(os.Args[:1], global.Args()...)
+
+	// The config commands, the form check and the schedule and service
+	// installers don't need (or check) a taju.env.
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "config":
+			configCommand(os.Args[2:])
+			return
+		case "check-forms":
+			checkFormsCommand(os.Args[2:])
+			return
+		case "schedule":
+			scheduleCommand(os.Args[2:])
+			return
+		case "service":
+			serviceCommand(os.Args[2:])
+			return
+		}
+	}
+
+	u := &uploader{profile: *profile}
+	if len(os.Args) > 1 && os.Args[1] == "web" {
+		// Packages are set up from the browser and never prompt.
+		u.headless = true
+		ensureEnvFile()
+	}
+	if err := initUploader(u); err != nil {
+		log.Fatal(err)
+	}
+
+	command := "sync"
+	args := os.Args[1:]
+	if len(args) > 0 {
+		command, args = args[0], args[1:]
+	}
+
+	switch command {
+	case "sync":
+		syncCommand(u, args)
+	case "status":
+		statusCommand(u)
+	case "preflight":
+		preflightCommand(u)
+	case "test-login":
+		testLoginCommand(u)
+	case "reconcile":
+		reconcileCommand(u, args)
+	case "auth":
+		authCommand(u, args)
+	case "resolve":
+		resolveCommand(u)
+	case "review":
+		reviewCommand(u)
+	case "dedupe":
+		dedupeCommand(u, args)
+	case "delete":
+		deleteCommand(u, args)
+	case "accounts":
+		accountsCommand(u, args)
+	case "web":
+		webCommand(u, args)
+	case "add":
+		addCommand(u, args)
+	case "edit":
+		editCommand(u, args)
+	case "backfill":
+		backfillCommand(u, args)
+	case "import":
+		importCommand(u, args)
+	case "export":
+		exportCommand(u, args)
+	case "wrapup":
+		wrapupCommand(u, args)
+	case "report":
+		reportCommand(u, args)
+	case "strava":
+		stravaCommand(u, args)
+	case "taji":
+		tajiCommand(u, args)
+	case "forms":
+		formsCommand(u, args)
+	case "help", "-h", "-help", "--help":
+		fmt.Print(USAGE)
+	default:
+		fmt.Fprint(os.Stderr, USAGE)
+		log.Fatal("Unknown command: ", command)
+	}
+}
@@ -0,0 +1,221 @@
+package taju
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/tajuploader/tajiclient"
+)
+
+// Team standings are scraped from the team page linked on the participant
+// page and from the leaderboard after every cycle (TAJU_STANDINGS=false
+// skips the two requests). Without a team the leaderboard row is the
+// participant's own.
+
+const LEADERBOARD_PATH = "/leaderboard/"
+
+var TEAM_HREF_PATTERN = regexp.MustCompile(`^(?:https?://[^/]+)?/teams/([^/]+)/?$`)
+
+// teamStandings is where the team stands on the leaderboard. Next is the
+// team ranked just above and Gap the miles to catch up with it.
+type teamStandings struct {
+	Team    string    `json:"team"`
+	Miles   float64   `json:"miles"`
+	Rank    int       `json:"rank,omitempty"`
+	Ranked  int       `json:"ranked,omitempty"`
+	Next    string    `json:"next,omitempty"`
+	Gap     float64   `json:"gap,omitempty"`
+	Checked time.Time `json:"checked"`
+}
+
+// standingsService is implemented by Taji frontends that can read the
+// leaderboard; the in-memory Taji has none.
+type standingsService interface {
+	Standings() (*teamStandings, error)
+}
+
+func (t *taji) Standings() (*teamStandings, error) {
+	return getTajiStandings(t)
+}
+
+func standingsEnabled(env map[string]string) bool {
+	_, ok := env["TAJU_STANDINGS"]
+	return !ok || envBool(env, "TAJU_STANDINGS")
+}
+
+// parseTeamId finds the team link on a participant page, or "" if the
+// participant isn't on a team.
+func parseTeamId(body []byte) string {
+	for _, a := range tajiclient.FindElements(body, "a") {
+		if match := TEAM_HREF_PATTERN.FindStringSubmatch(a.Attr("href")); match != nil {
+			return match[1]
+		}
+	}
+	return ""
+}
+
+// leaderboardRow is one row of the leaderboard or of a team page.
+type leaderboardRow struct {
+	name  string
+	href  string
+	miles float64
+}
+
+// parseLeaderboard reads the table rows that link to a team or participant
+// and have a distance, in page order. The distance is the last cell that
+// reads as one, in Taji's unit.
+func parseLeaderboard(body []byte, unit string) (rows []leaderboardRow) {
+	for _, tr := range tajiclient.FindElements(body, "tr") {
+		var row leaderboardRow
+		for _, a := range tr.Find("a") {
+			href := a.Attr("href")
+			if TEAM_HREF_PATTERN.MatchString(href) || tajiclient.PARTICIPANT_HREF_PATTERN.MatchString(href) {
+				row.name, row.href = a.Text, href
+				break
+			}
+		}
+		if row.href == "" {
+			continue
+		}
+		found := false
+		for _, cell := range tr.Find("td") {
+			if meters, err := parseDistance(cell.Text, unit); err == nil && cell.Text != row.name {
+				row.miles, found = meter2mile(meters), true
+			}
+		}
+		if found {
+			rows = append(rows, row)
+		}
+	}
+	return
+}
+
+// standingsOf finds the row linking to the given team (or participant)
+// page and the row ranked above it.
+func standingsOf(rows []leaderboardRow, pattern *regexp.Regexp, id string) (*teamStandings, bool) {
+	ranked := 0
+	var above *leaderboardRow
+	for i, row := range rows {
+		match := pattern.FindStringSubmatch(row.href)
+		if match == nil {
+			continue
+		}
+		ranked++
+		if match[1] != id {
+			above = &rows[i]
+			continue
+		}
+		standings := &teamStandings{Team: row.name, Miles: row.miles, Rank: ranked}
+		if above != nil {
+			standings.Next, standings.Gap = above.name, max(above.miles-row.miles, 0)
+		}
+		for _, rest := range rows[i+1:] {
+			if pattern.MatchString(rest.href) {
+				ranked++
+			}
+		}
+		standings.Ranked = ranked
+		return standings, true
+	}
+	return nil, false
+}
+
+// getTajiStandings reads the team page for the team's name and total, and
+// the leaderboard for its rank. The team id comes from the participant page
+// read at the start of the cycle.
+func getTajiStandings(t *taji) (*teamStandings, error) {
+	pattern, id := tajiclient.PARTICIPANT_HREF_PATTERN, t.client.Session().Participant
+	var team *teamStandings
+	if t.team_id != "" {
+		body, err := t.client.Read(t.run.context(), fmt.Sprintf("/teams/%s/", t.team_id), "team page")
+		if err != nil {
+			return nil, err
+		}
+		t.settings.templates.note("team", body)
+		team = &teamStandings{Team: "team " + t.team_id}
+		for _, heading := range append(tajiclient.FindElements(body, "h1"), tajiclient.FindElements(body, "h2")...) {
+			if heading.Text != "" {
+				team.Team = heading.Text
+				break
+			}
+		}
+		// The team page lists its members; their sum stands in for the
+		// total if the team isn't on the leaderboard.
+		for _, row := range parseLeaderboard(body, t.settings.taji_units.distance) {
+			if tajiclient.PARTICIPANT_HREF_PATTERN.MatchString(row.href) {
+				team.Miles += row.miles
+			}
+		}
+		pattern, id = TEAM_HREF_PATTERN, t.team_id
+	}
+
+	body, err := t.client.Read(t.run.context(), LEADERBOARD_PATH, "leaderboard")
+	if err != nil {
+		return nil, err
+	}
+	t.settings.templates.note("leaderboard", body)
+	standings, ok := standingsOf(parseLeaderboard(body, t.settings.taji_units.distance), pattern, id)
+	switch {
+	case ok && team != nil:
+		standings.Team = team.Team
+	case team != nil:
+		standings = team
+	case !ok:
+		return nil, fmt.Errorf("participant %s isn't on the leaderboard", id)
+	}
+	return standings, nil
+}
+
+// refreshStandings updates the standings kept in the ledger. A failure only
+// keeps the last ones; standings never fail a cycle.
+func (s *syncer) refreshStandings() *teamStandings {
+	u := s.u
+	if service, ok := s.taji.(standingsService); ok && standingsEnabled(u.env) {
+		standings, err := service.Standings()
+		if err != nil {
+			log.Print("Couldn't read the team standings: ", err)
+		} else {
+			standings.Checked = u.clock.Now()
+			u.state.setStandings(standings)
+		}
+	}
+	return u.state.standings()
+}
+
+func (s *stateStore) setStandings(standings *teamStandings) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Standings = standings
+}
+
+func (s *stateStore) standings() *teamStandings {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Standings
+}
+
+// standingsRank is the rank for logs, 0 if unknown.
+func standingsRank(standings *teamStandings) int {
+	if standings == nil {
+		return 0
+	}
+	return standings.Rank
+}
+
+// summary is the standings line of the status screens, in display.
+func (t *teamStandings) summary(display units) string {
+	var line strings.Builder
+	fmt.Fprintf(&line, "%s: %.2f %s", t.Team, display.fromMiles(t.Miles), display.name())
+	if t.Rank > 0 {
+		fmt.Fprintf(&line, ", rank %d of %d", t.Rank, t.Ranked)
+	}
+	if t.Next != "" {
+		fmt.Fprintf(&line, ", %.2f %s behind %s", display.fromMiles(t.Gap), display.name(), t.Next)
+	} else if t.Rank == 1 {
+		line.WriteString(", leading")
+	}
+	return line.String()
+}
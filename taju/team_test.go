@@ -0,0 +1,28 @@
+package taju
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseLeaderboard(t *testing.T) {
+	body, err := os.ReadFile(filepath.Join("testdata", "taji", "leaderboard.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := parseLeaderboard(body, UNITS_MILES)
+	want := []leaderboardRow{
+		{name: "Hill Goats", href: "/teams/3/", miles: 812.40},
+		{name: "Couch & Co", href: "/teams/17/", miles: 640.5},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("parseLeaderboard() = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i].name != want[i].name || got[i].href != want[i].href || math.Abs(got[i].miles-want[i].miles) > 1e-9 {
+			t.Errorf("parseLeaderboard()[%d] = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,414 @@
+package taju
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/jwt"
+)
+
+const SHEETS_SCOPE string = "https://www.googleapis.com/auth/spreadsheets"
+const SHEETS_API string = "https://sheets.googleapis.com/v4/spreadsheets/"
+
+// The sheets (tabs) of the spreadsheet the team export writes to.
+const (
+	SHEET_ACTIVITIES string = "Activities"
+	SHEET_DAILY      string = "Daily"
+)
+
+var TEAM_ACTIVITY_COLUMNS = []string{"name", "date", "time", "activity", "distance", "duration", "elevation_gain", "strava_id", "posted_at"}
+var TEAM_DAILY_COLUMNS = []string{"name", "date", "distance", "total", "activities", "unit"}
+
+// teamExport keeps a team captain's spreadsheet up to date: every activity
+// posted to Taji is appended to TAJU_EXPORT_CSV and/or the Activities sheet
+// of the Google spreadsheet TAJU_SHEETS_ID, and the daily totals are
+// rewritten in TAJU_EXPORT_DAILY_CSV and/or the Daily sheet after every
+// cycle. Rows carry TAJU_EXPORT_NAME, so a team can share one file or
+// spreadsheet. Failures to write are logged and never fail the sync.
+type teamExport struct {
+	name      string
+	csv       string
+	daily_csv string
+	sheets    *sheetsClient
+	clock     clock
+
+	mu     sync.Mutex
+	posted [][]string
+}
+
+func registerTeamExport(s *syncer, env map[string]string) error {
+	e := &teamExport{name: env["TAJU_EXPORT_NAME"], csv: env["TAJU_EXPORT_CSV"], daily_csv: env["TAJU_EXPORT_DAILY_CSV"],
+		clock: s.u.clock}
+	if e.name == "" {
+		e.name = cmpOr(s.u.profile, env["TAJI_USERNAME"])
+	}
+	if id := env["TAJU_SHEETS_ID"]; id != "" {
+		sheets, err := newSheetsClient(env, id)
+		if err != nil {
+			return err
+		}
+		e.sheets = sheets
+	}
+	if e.csv == "" && e.daily_csv == "" && e.sheets == nil {
+		return nil
+	}
+	subscribe(&s.events, func(p entryPosted) {
+		if p.err != nil {
+			return
+		}
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		e.posted = append(e.posted, e.activityRow(p.run, s.u.clock.Now()))
+	})
+	subscribe(&s.events, func(c cycleCompleted) {
+		if s.dry_run || s.scratch {
+			return
+		}
+		e.mu.Lock()
+		rows := e.posted
+		e.posted = nil
+		e.mu.Unlock()
+		e.write(rows, c.result.days)
+	})
+	return nil
+}
+
+func (e *teamExport) activityRow(run runDetails, now time.Time) []string {
+	strava_id := ""
+	if run.strava_id != 0 {
+		strava_id = strconv.FormatInt(run.strava_id, 10)
+	}
+	return []string{e.name, run.date, run.time, run.activity, run.distance, run.duration, run.elevation_gain, strava_id, now.Format(time.RFC3339)}
+}
+
+func (e *teamExport) dailyRows(days []dailyDistance) (rows [][]string) {
+	for _, day := range days {
+		rows = append(rows, []string{e.name, day.Date, strconv.FormatFloat(day.Distance, 'f', 2, 64),
+			strconv.FormatFloat(day.Total, 'f', 2, 64), strconv.Itoa(day.Activities), day.Unit})
+	}
+	return
+}
+
+func (e *teamExport) write(posted [][]string, days []dailyDistance) {
+	daily := e.dailyRows(days)
+	if e.csv != "" && len(posted) > 0 {
+		if err := appendCsv(e.csv, TEAM_ACTIVITY_COLUMNS, posted, e.clock); err != nil {
+			log.Print("Failed to export the activities to ", e.csv, ": ", err)
+		}
+	}
+	if e.daily_csv != "" && len(daily) > 0 {
+		if err := replaceCsvRows(e.daily_csv, TEAM_DAILY_COLUMNS, e.name, daily, e.clock); err != nil {
+			log.Print("Failed to export the daily totals to ", e.daily_csv, ": ", err)
+		}
+	}
+	if e.sheets == nil {
+		return
+	}
+	if len(posted) > 0 {
+		if err := e.sheets.append(SHEET_ACTIVITIES, TEAM_ACTIVITY_COLUMNS, posted); err != nil {
+			log.Print("Failed to export the activities to Google Sheets: ", err)
+		}
+	}
+	if len(daily) > 0 {
+		if err := e.sheets.replaceRows(SHEET_DAILY, TEAM_DAILY_COLUMNS, e.name, daily); err != nil {
+			log.Print("Failed to export the daily totals to Google Sheets: ", err)
+		}
+	}
+}
+
+// appendCsv appends rows to a CSV file, starting it with the header.
+func appendCsv(path string, header []string, rows [][]string, c clock) error {
+	unlock, err := lockCsv(path, c)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	out := csv.NewWriter(file)
+	if info.Size() == 0 {
+		out.Write(header)
+	}
+	out.WriteAll(rows)
+	return out.Error()
+}
+
+// replaceCsvRows rewrites the rows of name in a CSV file shared with other
+// names, whose rows are kept. The file is written next to itself and
+// renamed over, under the lock, so members syncing at the same time don't
+// lose each other's rows.
+func replaceCsvRows(path string, header []string, name string, rows [][]string, c clock) error {
+	unlock, err := lockCsv(path, c)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	existing, err := readCsv(path)
+	if err != nil {
+		return err
+	}
+	kept := slices.DeleteFunc(existing, func(row []string) bool { return len(row) == 0 || row[0] == name || row[0] == header[0] })
+	var data bytes.Buffer
+	out := csv.NewWriter(&data)
+	out.Write(header)
+	out.WriteAll(append(kept, rows...))
+	if err := out.Error(); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".taju-export-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data.Bytes()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// A CSV file shared by a team (on a network drive or a synced folder) is
+// locked by creating path.lock next to it. A writer waits CSV_LOCK_WAIT for
+// another one's lock, and takes over a lock older than CSV_LOCK_STALE, left
+// behind by a writer that crashed.
+const (
+	CSV_LOCK_WAIT  = 10 * time.Second
+	CSV_LOCK_STALE = time.Minute
+	CSV_LOCK_POLL  = 100 * time.Millisecond
+)
+
+// lockCsv takes the lock of a CSV file, returning the function that
+// releases it.
+func lockCsv(path string, c clock) (unlock func(), err error) {
+	lock := path + ".lock"
+	deadline := c.Now().Add(CSV_LOCK_WAIT)
+	for {
+		file, err := os.OpenFile(lock, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			file.Close()
+			return func() { os.Remove(lock) }, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+		if info, err := os.Stat(lock); err == nil && c.Now().Sub(info.ModTime()) > CSV_LOCK_STALE {
+			log.Print("Taking over the lock of ", path, ", left behind since ", info.ModTime().Format(time.Kitchen))
+			os.Remove(lock)
+			continue
+		}
+		if c.Now().After(deadline) {
+			return nil, fmt.Errorf("%s is locked by another writer, remove %s if none is running", path, lock)
+		}
+		c.Sleep(CSV_LOCK_POLL)
+	}
+}
+
+func readCsv(path string) ([][]string, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	in := csv.NewReader(file)
+	in.FieldsPerRecord = -1
+	return in.ReadAll()
+}
+
+// sheetsClient writes to a Google spreadsheet as a service account, whose
+// key file is TAJU_SHEETS_CREDENTIALS. The spreadsheet has to be shared
+// with the service account's email as an editor.
+type sheetsClient struct {
+	id     string
+	client *http.Client
+	// api is SHEETS_API, or a stand-in for it.
+	api string
+}
+
+// serviceAccountKey is the part of a Google service account key file the
+// token exchange needs.
+type serviceAccountKey struct {
+	Email      string `json:"client_email"`
+	PrivateKey string `json:"private_key"`
+	KeyId      string `json:"private_key_id"`
+	TokenUri   string `json:"token_uri"`
+}
+
+func newSheetsClient(env map[string]string, id string) (*sheetsClient, error) {
+	path := env["TAJU_SHEETS_CREDENTIALS"]
+	if path == "" {
+		return nil, errors.New("TAJU_SHEETS_ID needs TAJU_SHEETS_CREDENTIALS, the key file of a Google service account")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading TAJU_SHEETS_CREDENTIALS: %w", err)
+	}
+	var key serviceAccountKey
+	if err := json.Unmarshal(data, &key); err != nil || key.Email == "" || key.PrivateKey == "" {
+		return nil, fmt.Errorf("invalid TAJU_SHEETS_CREDENTIALS=%q, expected the JSON key file of a Google service account", path)
+	}
+	config := &jwt.Config{Email: key.Email, PrivateKey: []byte(key.PrivateKey), PrivateKeyID: key.KeyId,
+		Scopes: []string{SHEETS_SCOPE}, TokenURL: cmpOr(key.TokenUri, "https://oauth2.googleapis.com/token")}
+	network, err := loadNetworkSettings(env)
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, network.client(network.timeout))
+	return &sheetsClient{id: id, client: config.Client(ctx), api: SHEETS_API}, nil
+}
+
+// get reads the values of a range, nil when it's empty.
+func (c *sheetsClient) get(cells string) ([][]string, error) {
+	var body struct {
+		Values [][]string `json:"values"`
+	}
+	err := c.call(http.MethodGet, "/values/"+url.PathEscape(cells), nil, &body)
+	return body.Values, err
+}
+
+// append adds rows below the last row of a sheet, after the header if the
+// sheet is empty.
+func (c *sheetsClient) append(sheet string, header []string, rows [][]string) error {
+	first, err := c.get(sheet + "!A1:A1")
+	if err != nil {
+		return err
+	}
+	if len(first) == 0 {
+		rows = append([][]string{header}, rows...)
+	}
+	return c.call(http.MethodPost, "/values/"+url.PathEscape(sheet)+":append?valueInputOption=USER_ENTERED&insertDataOption=INSERT_ROWS",
+		map[string]any{"values": rows}, nil)
+}
+
+// replaceRows rewrites the rows of name in a sheet shared with other
+// names. Only this member's rows are written, so members exporting at the
+// same time don't overwrite each other: rows are keyed by their first two
+// columns (name and date), a row already on the sheet is updated where it
+// is, a new one appended, and one no longer exported cleared.
+func (c *sheetsClient) replaceRows(sheet string, header []string, name string, rows [][]string) error {
+	keys, err := c.get(sheet + "!A:B")
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return c.append(sheet, header, rows)
+	}
+	at := make(map[string]int)
+	for i, key := range keys {
+		if len(key) == 2 && key[0] == name {
+			at[key[1]] = i + 1
+		}
+	}
+	last := columnName(len(header))
+	var updates []map[string]any
+	var added [][]string
+	for _, row := range rows {
+		number, ok := at[row[1]]
+		if !ok {
+			added = append(added, row)
+			continue
+		}
+		delete(at, row[1])
+		updates = append(updates, map[string]any{"range": fmt.Sprintf("%s!A%d:%s%d", sheet, number, last, number), "values": [][]string{row}})
+	}
+	if len(updates) > 0 {
+		err := c.call(http.MethodPost, "/values:batchUpdate", map[string]any{"valueInputOption": "USER_ENTERED", "data": updates}, nil)
+		if err != nil {
+			return err
+		}
+	}
+	if len(at) > 0 {
+		var cleared []string
+		for _, number := range slices.Sorted(maps.Values(at)) {
+			cleared = append(cleared, fmt.Sprintf("%s!A%d:%s%d", sheet, number, last, number))
+		}
+		if err := c.call(http.MethodPost, "/values:batchClear", map[string]any{"ranges": cleared}, nil); err != nil {
+			return err
+		}
+	}
+	if len(added) > 0 {
+		return c.append(sheet, header, added)
+	}
+	return nil
+}
+
+// columnName is the letter of the nth column of a sheet, A for 1.
+func columnName(n int) string {
+	name := ""
+	for ; n > 0; n = (n - 1) / 26 {
+		name = string(rune('A'+(n-1)%26)) + name
+	}
+	return name
+}
+
+func (c *sheetsClient) call(method string, path string, payload any, out any) error {
+	var body io.Reader
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, c.api+url.PathEscape(c.id)+path, body)
+	if err != nil {
+		return err
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	res, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode >= 300 {
+		var failure struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		json.Unmarshal(data, &failure)
+		return fmt.Errorf("%s %s: %s %s", method, strings.SplitN(path, "?", 2)[0], res.Status, failure.Error.Message)
+	}
+	if out != nil {
+		return json.Unmarshal(data, out)
+	}
+	return nil
+}
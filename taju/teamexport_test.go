@@ -0,0 +1,177 @@
+package taju
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReplaceCsvRowsConcurrent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "daily.csv")
+	header := []string{"name", "date", "distance"}
+	if err := replaceCsvRows(path, header, "coach", [][]string{{"coach", "2026-02-01", "1.00"}}, realClock{}); err != nil {
+		t.Fatal(err)
+	}
+
+	var writers sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := range 8 {
+		writers.Add(1)
+		go func() {
+			defer writers.Done()
+			name := fmt.Sprintf("member%d", i)
+			rows := [][]string{{name, "2026-02-01", "2.00"}, {name, "2026-02-02", "3.00"}}
+			errs <- replaceCsvRows(path, header, name, rows, realClock{})
+		}()
+	}
+	writers.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rows, err := readCsv(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1+1+8*2 {
+		t.Fatalf("got %d rows, want %d: %q", len(rows), 1+1+8*2, rows)
+	}
+	if !slices.Equal(rows[0], header) || !slices.ContainsFunc(rows, func(row []string) bool { return row[0] == "coach" }) {
+		t.Errorf("header or another member's rows lost: %q", rows)
+	}
+	if _, err := os.Stat(path + ".lock"); !os.IsNotExist(err) {
+		t.Errorf("lock left behind: %v", err)
+	}
+	leftovers, _ := filepath.Glob(filepath.Join(filepath.Dir(path), ".taju-export-*"))
+	if len(leftovers) > 0 {
+		t.Errorf("temporary files left behind: %q", leftovers)
+	}
+}
+
+func TestLockCsv(t *testing.T) {
+	tests := []struct {
+		name    string
+		age     time.Duration
+		wantErr bool
+	}{
+		{name: "held by another writer", age: time.Second, wantErr: true},
+		{name: "left behind by a crash", age: CSV_LOCK_STALE + time.Second},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "daily.csv")
+			if err := os.WriteFile(path+".lock", nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+			locked := TEST_NOW.Add(-test.age)
+			if err := os.Chtimes(path+".lock", locked, locked); err != nil {
+				t.Fatal(err)
+			}
+			// The fake clock keeps the waiting for the lock instant, the
+			// lock file's age is measured from TEST_NOW.
+			unlock, err := lockCsv(path, newFakeClock(TEST_NOW))
+			if (err != nil) != test.wantErr {
+				t.Fatalf("lockCsv() error = %v, wantErr %v", err, test.wantErr)
+			}
+			if err == nil {
+				unlock()
+			}
+		})
+	}
+}
+
+// sheetsFixture stands in for the Sheets API, with the Daily sheet's name
+// and date columns as they were read before the export.
+type sheetsFixture struct {
+	mu       sync.Mutex
+	keys     [][]string
+	requests []string
+	bodies   map[string]string
+}
+
+func (f *sheetsFixture) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	path := strings.TrimPrefix(r.URL.Path, "/sheet-id")
+	body, _ := io.ReadAll(r.Body)
+	f.requests = append(f.requests, r.Method+" "+path)
+	f.bodies[r.Method+" "+path] = string(body)
+	switch {
+	case r.Method == http.MethodGet && path == "/values/Daily!A:B":
+		json.NewEncoder(w).Encode(map[string]any{"values": f.keys})
+	case r.Method == http.MethodGet && path == "/values/Daily!A1:A1":
+		json.NewEncoder(w).Encode(map[string]any{"values": f.keys[:min(1, len(f.keys))]})
+	default:
+		w.Write([]byte("{}"))
+	}
+}
+
+func TestSheetsReplaceRows(t *testing.T) {
+	header := []string{"name", "date", "distance"}
+	tests := []struct {
+		name   string
+		keys   [][]string
+		rows   [][]string
+		want   []string
+		bodies map[string]string
+	}{
+		{
+			name: "empty sheet gets the header",
+			rows: [][]string{{"jane", "2026-02-01", "3.00"}},
+			want: []string{"GET /values/Daily!A:B", "GET /values/Daily!A1:A1", "POST /values/Daily:append"},
+			bodies: map[string]string{
+				"POST /values/Daily:append": `{"values":[["name","date","distance"],["jane","2026-02-01","3.00"]]}`,
+			},
+		},
+		{
+			name: "only this member's rows change",
+			keys: [][]string{{"name", "date"}, {"joe", "2026-02-01"}, {"jane", "2026-02-01"}, {"joe", "2026-02-02"}, {"jane", "2026-02-02"}},
+			rows: [][]string{{"jane", "2026-02-01", "3.50"}, {"jane", "2026-02-03", "1.00"}},
+			want: []string{"GET /values/Daily!A:B", "POST /values:batchUpdate", "POST /values:batchClear", "GET /values/Daily!A1:A1", "POST /values/Daily:append"},
+			bodies: map[string]string{
+				"POST /values:batchUpdate":  `{"data":[{"range":"Daily!A3:C3","values":[["jane","2026-02-01","3.50"]]}],"valueInputOption":"USER_ENTERED"}`,
+				"POST /values:batchClear":   `{"ranges":["Daily!A5:C5"]}`,
+				"POST /values/Daily:append": `{"values":[["jane","2026-02-03","1.00"]]}`,
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fixture := &sheetsFixture{keys: test.keys, bodies: make(map[string]string)}
+			server := httptest.NewServer(fixture)
+			defer server.Close()
+			c := &sheetsClient{id: "sheet-id", client: server.Client(), api: server.URL + "/"}
+			if err := c.replaceRows(SHEET_DAILY, header, "jane", test.rows); err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(fixture.requests, test.want) {
+				t.Errorf("requests = %q, want %q", fixture.requests, test.want)
+			}
+			for request, want := range test.bodies {
+				if got := fixture.bodies[request]; got != want {
+					t.Errorf("%s body = %s, want %s", request, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestColumnName(t *testing.T) {
+	for n, want := range map[int]string{1: "A", 6: "F", 26: "Z", 27: "AA", 52: "AZ", 53: "BA"} {
+		if got := columnName(n); got != want {
+			t.Errorf("columnName(%d) = %q, want %q", n, got, want)
+		}
+	}
+}
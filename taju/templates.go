@@ -0,0 +1,117 @@
+package taju
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/tajuploader/tajiclient"
+	"golang.org/x/net/html"
+)
+
+const HISTORY_FILENAME string = "taju.history.log"
+const HISTORY_MAX_LINES = 500
+
+// templateTracker fingerprints the structure of the Taji pages the uploader
+// scrapes and notes in the history log when a fingerprint changes, so a
+// sudden parse failure can be lined up with a site deployment.
+type templateTracker struct {
+	mu     sync.Mutex
+	seen   map[string]bool
+	state  *stateStore
+	clock  clock
+	path   string
+	loaded bool
+	// artifacts keeps a copy of a changed page, if enabled.
+	artifacts *artifactDir
+}
+
+// templateChecksum hashes the set of distinct tag/class/attribute-name
+// signatures of a page. Text and attribute values are ignored, so new log
+// entries or a different CSRF token don't count as a template change.
+func templateChecksum(body []byte) string {
+	signatures := make(map[string]bool)
+	tajiclient.WalkElements(tajiclient.ParsePage(body), func(node *html.Node) bool {
+		var names []string
+		for _, attr := range node.Attr {
+			names = append(names, attr.Key)
+		}
+		sort.Strings(names)
+		signatures[node.Data+"["+strings.Join(names, ",")+"]"] = true
+		return true
+	})
+	keys := make([]string, 0, len(signatures))
+	for key := range signatures {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	sum := sha256.Sum256([]byte(strings.Join(keys, "\n")))
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+// note checks a scraped page once per cycle and name.
+func (t *templateTracker) note(name string, body []byte) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.seen[name] {
+		return
+	}
+	t.seen[name] = true
+
+	checksum := templateChecksum(body)
+	t.state.mu.Lock()
+	if t.state.Templates == nil {
+		t.state.Templates = make(map[string]string)
+	}
+	previous, known := t.state.Templates[name]
+	t.state.Templates[name] = checksum
+	t.state.mu.Unlock()
+
+	switch {
+	case !known:
+		t.appendHistory(fmt.Sprintf("template %s first seen, checksum %s", name, checksum))
+	case previous != checksum:
+		log.Printf("The Taji %s page changed (%s -> %s)", name, previous, checksum)
+		t.appendHistory(fmt.Sprintf("template %s changed, checksum %s -> %s", name, previous, checksum))
+		t.artifacts.save("template-"+name+".html", body)
+	}
+}
+
+// reset starts a new cycle, so every page is checked again.
+func (t *templateTracker) reset() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.seen = make(map[string]bool)
+}
+
+// appendHistory adds a line to the history log, keeping the newest
+// HISTORY_MAX_LINES lines.
+func (t *templateTracker) appendHistory(line string) {
+	data, err := os.ReadFile(t.path)
+	if err != nil && !os.IsNotExist(err) {
+		log.Print("Failed to read ", t.path, ": ", err)
+		return
+	}
+	lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	if len(data) == 0 {
+		lines = nil
+	}
+	lines = append(lines, t.clock.Now().Format("2006-01-02T15:04:05Z07:00")+" "+line)
+	if len(lines) > HISTORY_MAX_LINES {
+		lines = lines[len(lines)-HISTORY_MAX_LINES:]
+	}
+	if err := os.WriteFile(t.path, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+		log.Print("Failed to write ", t.path, ": ", err)
+	}
+}
@@ -0,0 +1,89 @@
+package taju
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	COLOR_GREEN string = "\033[32m"
+	COLOR_RED   string = "\033[31m"
+	COLOR_RESET string = "\033[0m"
+)
+
+func printCheck(ok bool, name string, detail string) {
+	if ok {
+		fmt.Printf("%s[ OK ]%s %s: %s\n", COLOR_GREEN, COLOR_RESET, name, detail)
+	} else {
+		fmt.Printf("%s[FAIL]%s %s: %s\n", COLOR_RED, COLOR_RESET, name, detail)
+	}
+}
+
+// testLoginCommand checks the stored Taji session and Strava tokens without
+// syncing and without starting any interactive login.
+func testLoginCommand(u *uploader) {
+	all_ok := true
+	check := func(ok bool, name string, detail string) {
+		all_ok = all_ok && ok
+		printCheck(ok, name, detail)
+	}
+
+	_, csrf_ok := u.env["TAJI_CSRF"]
+	_, sess_ok := u.env["TAJI_SESSION"]
+	_, part_ok := u.env["TAJI_PARTICIPANT"]
+	if !(csrf_ok && sess_ok && part_ok) {
+		check(false, "Taji", "not logged in, run: taju auth taji")
+	} else if err := initTaji(u.env, &u.taji); err != nil {
+		check(false, "Taji", err.Error())
+	} else {
+		ok, detail := testTajiSession(&u.taji)
+		check(ok, "Taji", detail)
+	}
+
+	for _, name := range stravaAccounts(u.env) {
+		label := fmt.Sprintf("Strava (%s)", name)
+		if _, ok := u.env[stravaTokenKey(name)]; !ok {
+			check(false, label, "not authorized, run: taju auth strava "+name)
+			continue
+		}
+		s := &strava{settings: u.settings}
+		if err := initStrava(u.env, s, name); err != nil {
+			check(false, label, err.Error())
+			continue
+		}
+		u.accounts = append(u.accounts, s)
+		athlete, err := stravaAPI(s).Athlete()
+		if err != nil {
+			check(false, label, err.Error())
+			continue
+		}
+		check(true, label, fmt.Sprintf("authorized as %s %s", athlete.Firstname, athlete.Lastname))
+	}
+	saveStravaTokens(u)
+
+	if !all_ok {
+		os.Exit(1)
+	}
+}
+
+// testTajiSession fetches the participant page. An expired session is
+// redirected to the login page instead.
+func testTajiSession(t *taji) (bool, string) {
+	participant := t.client.Session().Participant
+	res, err := t.client.HTTP.Get(t.client.URL(fmt.Sprintf("/participants/%s/", participant)))
+	if err != nil {
+		return false, err.Error()
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, res.Body)
+
+	if res.StatusCode != 200 {
+		return false, fmt.Sprintf("participant page returned %s", res.Status)
+	}
+	if strings.Contains(res.Request.URL.Path, "/login") {
+		return false, "session expired, run: taju auth taji"
+	}
+	return true, fmt.Sprintf("session valid for participant %s", participant)
+}
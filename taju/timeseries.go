@@ -0,0 +1,82 @@
+package taju
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var DAILY_COLUMNS = []string{"time", "date", "distance", "total", "activities", "unit"}
+
+// dailyDistance is one day of the event as a time series point: the
+// distance logged that day and the running total, in the display units.
+// Time is the local midnight of the day, which is what Grafana's JSON and
+// CSV datasources (e.g. the Infinity plugin) take as the time field.
+type dailyDistance struct {
+	Time       time.Time `json:"time"`
+	Date       string    `json:"date"`
+	Distance   float64   `json:"distance"`
+	Total      float64   `json:"total"`
+	Activities int       `json:"activities"`
+	Unit       string    `json:"unit"`
+}
+
+// dailySeries is the daily distance of the ledger's activities, for
+// export --daily and /daily, which also work between cycles.
+func dailySeries(u *uploader) []dailyDistance {
+	now, start, end := goalWindow(u)
+	return dailyTotals(ledgerRuns(u.settings, u.state.exported(), start, end), u.settings.display, now, start, end)
+}
+
+func round2(value float64) float64 {
+	return math.Round(value*100) / 100
+}
+
+func writeDailyCsv(w io.Writer, series []dailyDistance) error {
+	out := csv.NewWriter(w)
+	out.Write(DAILY_COLUMNS)
+	for _, day := range series {
+		out.Write([]string{day.Time.Format(time.RFC3339), day.Date, strconv.FormatFloat(day.Distance, 'f', 2, 64),
+			strconv.FormatFloat(day.Total, 'f', 2, 64), strconv.Itoa(day.Activities), day.Unit})
+	}
+	out.Flush()
+	return out.Error()
+}
+
+func writeDailyJson(w io.Writer, series []dailyDistance) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if series == nil {
+		series = []dailyDistance{}
+	}
+	return encoder.Encode(series)
+}
+
+// daily serves the daily series of a profile (?profile=, the first by
+// default) as JSON, or as CSV with ?format=csv, for a Grafana datasource
+// pointed at the control server.
+func (p *statusPage) daily(rw http.ResponseWriter, r *http.Request) {
+	s := p.profiles[0].syncer
+	if r.FormValue("profile") != "" {
+		var ok bool
+		if _, s, ok = p.profileAt(r); !ok {
+			http.Error(rw, "unknown profile", http.StatusNotFound)
+			return
+		}
+	}
+	series := dailySeries(s.u)
+	switch r.FormValue("format") {
+	case "", "json":
+		rw.Header().Set("Content-Type", "application/json")
+		writeDailyJson(rw, series)
+	case "csv":
+		rw.Header().Set("Content-Type", "text/csv")
+		writeDailyCsv(rw, series)
+	default:
+		http.Error(rw, "unknown format, expected json or csv", http.StatusBadRequest)
+	}
+}
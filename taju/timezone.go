@@ -0,0 +1,89 @@
+package taju
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Taji dates are calendar days, so a run is dated in the timezone it was
+// run in (Strava's timezone of the activity) rather than the machine's.
+// TAJU_TIMEZONE=local goes back to the machine's timezone, and an IANA name
+// like Europe/Berlin dates every run in that zone.
+const (
+	TIMEZONE_ACTIVITY string = "activity"
+	TIMEZONE_LOCAL    string = "local"
+)
+
+// loadTimezone reads TAJU_TIMEZONE, returning nil for the activity's own
+// timezone.
+func loadTimezone(env map[string]string) (*time.Location, error) {
+	switch value := env["TAJU_TIMEZONE"]; value {
+	case "", TIMEZONE_ACTIVITY:
+		return nil, nil
+	case TIMEZONE_LOCAL:
+		return time.Local, nil
+	default:
+		location, err := time.LoadLocation(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TAJU_TIMEZONE=%q, expected activity, local or a timezone like Europe/Berlin", value)
+		}
+		return location, nil
+	}
+}
+
+// activityLocation is the timezone of a Strava activity. Strava names it
+// like "(GMT-08:00) America/Los_Angeles"; when the name isn't known to this
+// system the offset between start_date_local and start_date is used.
+func activityLocation(activity stravaActivity) *time.Location {
+	if _, name, ok := strings.Cut(activity.Timezone, ") "); ok {
+		if location, err := time.LoadLocation(name); err == nil {
+			return location
+		}
+	}
+	start, err1 := time.Parse(time.RFC3339, activity.StartDate)
+	// start_date_local is the local clock time, marked as UTC.
+	local, err2 := time.Parse(time.RFC3339, activity.StartDateLocal)
+	if err1 != nil || err2 != nil {
+		return nil
+	}
+	offset := local.Sub(start)
+	return time.FixedZone(activity.Timezone, int(offset.Seconds()))
+}
+
+// Days a run that spans midnight is logged on, set with TAJU_MIDNIGHT:
+// the day it started (the default), the day it ended, or the day most of
+// it was on. Runs moved to the next day are logged at midnight.
+const (
+	MIDNIGHT_START string = "start"
+	MIDNIGHT_END   string = "end"
+	MIDNIGHT_MOST  string = "most"
+)
+
+func loadMidnightRule(env map[string]string) (string, error) {
+	switch value := env["TAJU_MIDNIGHT"]; value {
+	case "":
+		return MIDNIGHT_START, nil
+	case MIDNIGHT_START, MIDNIGHT_END, MIDNIGHT_MOST:
+		return value, nil
+	default:
+		return "", fmt.Errorf("invalid TAJU_MIDNIGHT=%q, expected start, end or most", value)
+	}
+}
+
+// logStart is the time a run is logged at on Taji, in location.
+func logStart(start time.Time, duration time.Duration, location *time.Location, rule string) time.Time {
+	start = start.In(location)
+	end := start.Add(duration)
+	next_day := time.Date(start.Year(), start.Month(), start.Day()+1, 0, 0, 0, 0, location)
+	if !end.After(next_day) {
+		return start
+	}
+	switch {
+	case rule == MIDNIGHT_END:
+		return time.Date(end.Year(), end.Month(), end.Day(), 0, 0, 0, 0, location)
+	case rule == MIDNIGHT_MOST && end.Sub(next_day) > next_day.Sub(start):
+		return next_day
+	}
+	return start
+}
@@ -0,0 +1,76 @@
+package taju
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strconv"
+)
+
+// traceMapping runs the pipeline of s for one activity and writes every Strava
+// source field, what each transform changed and the Taji form values that
+// result (sync --trace-mapping).
+func traceMapping(w io.Writer, s *strava, activity stravaActivity, run runDetails) (runDetails, error) {
+	fmt.Fprintf(w, "Strava activity %d (%q)\n", activity.Id, activity.Name)
+	for _, field := range [][2]string{
+		{"type", activity.Type},
+		{"sport_type", activity.SportType},
+		{"start_date", activity.StartDate},
+		{"start_date_local", activity.StartDateLocal},
+		{"timezone", activity.Timezone},
+		{"distance", strconv.FormatFloat(activity.Distance, 'f', -1, 64)},
+		{"moving_time", strconv.FormatInt(activity.MovingTime, 10)},
+		{"elapsed_time", strconv.FormatInt(activity.ElapsedTime, 10)},
+		{"total_elevation_gain", strconv.FormatFloat(activity.TotalElevationGain, 'f', -1, 64)},
+		{"manual", strconv.FormatBool(activity.Manual)},
+		{"total_photo_count", strconv.Itoa(activity.TotalPhotoCount)},
+	} {
+		fmt.Fprintf(w, "  strava   %-22s %s\n", field[0], field[1])
+	}
+
+	run, err := s.pipeline.applyTraced(run, func(name string, before, after runDetails, err error) {
+		was, is := runFields(before), runFields(after)
+		changed := false
+		for _, field := range slices.Sorted(maps.Keys(is)) {
+			if was[field] != is[field] {
+				fmt.Fprintf(w, "  %-8s %-22s %q -> %q\n", name, field, was[field], is[field])
+				changed = true
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(w, "  %-8s rejected: %v\n", name, err)
+		} else if !changed {
+			fmt.Fprintf(w, "  %-8s (no change)\n", name)
+		}
+	})
+	if err != nil {
+		return run, err
+	}
+
+	values := s.settings.runValues("", run)
+	values.Del("csrfmiddlewaretoken")
+	for _, field := range slices.Sorted(maps.Keys(values)) {
+		fmt.Fprintf(w, "  taji     %-22s %q\n", field, values.Get(field))
+	}
+	return run, nil
+}
+
+// runFields lists the fields of a run the pipeline can change.
+func runFields(r runDetails) map[string]string {
+	return map[string]string{
+		"activity":         r.activity,
+		"date":             r.date,
+		"time":             r.time,
+		"time_hours":       r.time_hours,
+		"time_minutes":     r.time_minutes,
+		"time_ampm":        r.time_ampm,
+		"distance":         r.distance,
+		"duration":         r.duration,
+		"duration_hours":   r.duration_hours,
+		"duration_minutes": r.duration_minutes,
+		"duration_seconds": r.duration_seconds,
+		"elevation_gain":   r.elevation_gain,
+		"photo_url":        r.photo_url,
+	}
+}
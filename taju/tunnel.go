@@ -0,0 +1,120 @@
+package taju
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os/exec"
+	"regexp"
+	"time"
+)
+
+// A tunnel gives the webhook callback a public URL when the machine sits
+// behind NAT. The tunnel client (cloudflared or ngrok) has to be installed;
+// it's started with a quick, account-less tunnel and the public URL is read
+// from its log.
+var tunnelClients = map[string]struct {
+	args func(local_url string) []string
+	url  *regexp.Regexp
+}{
+	"cloudflared": {
+		args: func(local_url string) []string {
+			return []string{"tunnel", "--no-autoupdate", "--no-tls-verify", "--url", local_url}
+		},
+		url: regexp.MustCompile(`https://[-a-z0-9]+\.trycloudflare\.com`),
+	},
+	"ngrok": {
+		args: func(local_url string) []string {
+			return []string{"http", local_url, "--log", "stdout", "--log-format", "logfmt"}
+		},
+		url: regexp.MustCompile(`url=(https://\S+)`),
+	},
+}
+
+// Delays before restarting a tunnel client that exited.
+const (
+	TUNNEL_RETRY     = 5 * time.Second
+	TUNNEL_MAX_RETRY = 5 * time.Minute
+)
+
+// startTunnel runs the tunnel client for the webhook callback at addr and
+// sends its public URL on the returned channel. Quick tunnels get a new URL
+// each time the client starts, so the client is restarted when it exits and
+// every new URL is sent again.
+func startTunnel(kind string, addr string, tls bool, c clock) (<-chan string, error) {
+	client, ok := tunnelClients[kind]
+	if !ok {
+		return nil, fmt.Errorf("unknown tunnel %q, use cloudflared or ngrok", kind)
+	}
+	if _, err := exec.LookPath(kind); err != nil {
+		return nil, fmt.Errorf("tunnel client %s isn't installed: %w", kind, err)
+	}
+	local_url := localUrl(addr, tls)
+
+	urls := make(chan string, 1)
+	go func() {
+		retry := TUNNEL_RETRY
+		for {
+			started := c.Now()
+			err := runTunnel(kind, client.args(local_url), client.url, urls)
+			log.Printf("Tunnel %s stopped: %v", kind, err)
+			if c.Now().Sub(started) > TUNNEL_MAX_RETRY {
+				retry = TUNNEL_RETRY
+			}
+			c.Sleep(retry)
+			retry = min(2*retry, TUNNEL_MAX_RETRY)
+		}
+	}()
+	return urls, nil
+}
+
+// runTunnel runs the tunnel client until it exits, sending each public URL
+// it logs.
+func runTunnel(kind string, args []string, pattern *regexp.Regexp, urls chan string) error {
+	cmd := exec.Command(kind, args...)
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	// cloudflared logs to stderr, ngrok to stdout.
+	cmd.Stderr = cmd.Stdout
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(out)
+	for scanner.Scan() {
+		match := pattern.FindStringSubmatch(scanner.Text())
+		if match == nil {
+			continue
+		}
+		url := match[len(match)-1]
+		// Drop an URL the subscription hasn't picked up yet.
+		select {
+		case <-urls:
+		default:
+		}
+		urls <- url
+	}
+	io.Copy(io.Discard, out)
+	return cmd.Wait()
+}
+
+// localUrl is the URL the tunnel forwards to, for a listen address like
+// ":9192".
+func localUrl(addr string, tls bool) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		host, port = addr, "80"
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "localhost"
+	}
+	scheme := "http"
+	if tls {
+		scheme = "https"
+	}
+	return scheme + "://" + net.JoinHostPort(host, port)
+}
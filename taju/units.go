@@ -0,0 +1,141 @@
+package taju
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/tajuploader/config"
+)
+
+const (
+	UNITS_MILES string = "mi"
+	UNITS_KM    string = "km"
+)
+
+// units is how distances and clock times are written. The display units of
+// the settings are used for the terminal output (TAJU_UNITS, TAJU_CLOCK)
+// and the taji_units for the values posted to and read from the Taji form
+// (TAJU_TAJI_UNITS, TAJU_TAJI_CLOCK). Both default to miles and a 12 hour clock, which is
+// what Taji uses today. Goals and guard rails are configured in miles.
+type units struct {
+	distance string
+	clock24  bool
+}
+
+func loadUnits(env map[string]string, units_key string, clock_key string) (units, error) {
+	u := units{distance: UNITS_MILES}
+	switch value := env[units_key]; value {
+	case "", UNITS_MILES:
+	case UNITS_KM:
+		u.distance = UNITS_KM
+	default:
+		return u, fmt.Errorf("invalid %s=%q, expected mi or km", units_key, value)
+	}
+	switch value := env[clock_key]; value {
+	case "", "12h":
+	case "24h":
+		u.clock24 = true
+	default:
+		return u, fmt.Errorf("invalid %s=%q, expected 12h or 24h", clock_key, value)
+	}
+	return u, nil
+}
+
+func (u units) fromMeters(meters float64) float64 {
+	if u.distance == UNITS_KM {
+		return meters / 1000
+	}
+	return meter2mile(meters)
+}
+
+func (u units) fromMiles(miles float64) float64 {
+	if u.distance == UNITS_KM {
+		return miles * 1.609344
+	}
+	return miles
+}
+
+// name is the unit as written after a number, e.g. "3.10 miles".
+func (u units) name() string {
+	if u.distance == UNITS_KM {
+		return "km"
+	}
+	return "miles"
+}
+
+// timeLayout is the layout of the time of day, as on the Taji form.
+func (u units) timeLayout() string {
+	if u.clock24 {
+		return "15:04"
+	}
+	return "03:04:PM"
+}
+
+// clock formats a time of day for the terminal.
+func (u units) clock(t time.Time) string {
+	if u.clock24 {
+		return t.Format("Jan 2 15:04:05")
+	}
+	return t.Format("Jan 2 3:04:05 PM")
+}
+
+// tajiDistance writes meters in taji_units rounded to the distance step
+// of the Taji form, see quantizeDistance.
+func (s *settings) tajiDistance(meters float64) string {
+	return s.formatDistance(s.taji_units.fromMeters(meters))
+}
+
+// DISTANCE_SUFFIXES are the units a typed distance can end in, in meters.
+var DISTANCE_SUFFIXES = map[string]float64{
+	"km":         1000,
+	"км":         1000,
+	"m":          1,
+	"м":          1,
+	"mi":         1 / meter2mile(1),
+	"mile":       1 / meter2mile(1),
+	"miles":      1 / meter2mile(1),
+	"ft":         1 / meter2feet(1),
+	"feet":       1 / meter2feet(1),
+	"kilometers": 1000,
+	"meters":     1,
+	"yd":         0.9144,
+	"yards":      0.9144,
+}
+
+// parseDistance reads a distance like "5,2 km" or "3.1mi" into meters. A
+// number without a unit is in the given units (UNITS_MILES or UNITS_KM).
+func parseDistance(value string, units string) (float64, error) {
+	value = strings.TrimSpace(value)
+	number := strings.TrimRightFunc(value, unicode.IsLetter)
+	suffix := strings.ToLower(strings.TrimSpace(value[len(number):]))
+	scale := 1 / meter2mile(1)
+	if unit, ok := DISTANCE_SUFFIXES[units]; ok {
+		scale = unit
+	}
+	if suffix != "" {
+		var ok bool
+		if scale, ok = DISTANCE_SUFFIXES[suffix]; !ok {
+			return 0, fmt.Errorf("unknown unit %q in %q", suffix, value)
+		}
+	}
+	n, err := config.ParseNumber(number)
+	if errors.Is(err, config.ErrAmbiguousNumber) {
+		return 0, err
+	} else if err != nil {
+		return 0, fmt.Errorf("invalid number %q", value)
+	}
+	return n * scale, nil
+}
+
+// parseMiles reads a distance setting, in miles unless it has a unit (the
+// _MILES settings accept e.g. 160km too).
+func parseMiles(value string) (float64, error) {
+	if strings.TrimRightFunc(strings.TrimSpace(value), unicode.IsLetter) == strings.TrimSpace(value) {
+		return config.ParseNumber(value)
+	}
+	meters, err := parseDistance(value, UNITS_MILES)
+	return meter2mile(meters), err
+}
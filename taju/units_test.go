@@ -0,0 +1,75 @@
+package taju
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestParseDistance(t *testing.T) {
+	const MILE = 1609.344
+	tests := []struct {
+		value string
+		units string
+		want  float64
+		err   string
+	}{
+		{value: "3.1", units: UNITS_MILES, want: 3.1 * MILE},
+		{value: "3,1", units: UNITS_KM, want: 3100},
+		{value: "5,2 km", units: UNITS_MILES, want: 5200},
+		{value: "5,2km", units: UNITS_MILES, want: 5200},
+		{value: "3.1mi", units: UNITS_KM, want: 3.1 * MILE},
+		{value: "1 Mile", units: UNITS_KM, want: MILE},
+		{value: "800m", units: UNITS_MILES, want: 800},
+		{value: "400 м", units: UNITS_MILES, want: 400},
+		{value: "1.234,5 m", units: UNITS_MILES, want: 1234.5},
+		{value: "120ft", units: UNITS_MILES, want: 36.576},
+		{value: "3 leagues", units: UNITS_MILES, err: `unknown unit "leagues"`},
+		{value: "km", units: UNITS_MILES, err: "invalid number"},
+		{value: "3.1.2", units: UNITS_MILES, err: "invalid number"},
+	}
+	for _, test := range tests {
+		got, err := parseDistance(test.value, test.units)
+		switch {
+		case test.err != "":
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("parseDistance(%q) error %v, want %q", test.value, err, test.err)
+			}
+		case err != nil:
+			t.Errorf("parseDistance(%q): %v", test.value, err)
+		case math.Abs(got-test.want) > 0.01:
+			t.Errorf("parseDistance(%q, %s) = %.3f m, want %.3f", test.value, test.units, got, test.want)
+		}
+	}
+}
+
+func TestParseMiles(t *testing.T) {
+	tests := []struct {
+		value string
+		want  float64
+	}{
+		{value: "100", want: 100},
+		{value: "0,5", want: 0.5},
+		{value: "160km", want: 99.42},
+		{value: "26.2 mi", want: 26.2},
+	}
+	for _, test := range tests {
+		got, err := parseMiles(test.value)
+		if err != nil || math.Abs(got-test.want) > 0.01 {
+			t.Errorf("parseMiles(%q) = %.2f, %v, want %.2f", test.value, got, err, test.want)
+		}
+	}
+}
+
+func TestUnits(t *testing.T) {
+	miles, km := units{distance: UNITS_MILES}, units{distance: UNITS_KM, clock24: true}
+	if got := miles.fromMeters(1609.344); math.Abs(got-1) > 0.0001 {
+		t.Errorf("1609.344 m = %g mi", got)
+	}
+	if got := km.fromMiles(26.2); math.Abs(got-42.165) > 0.001 {
+		t.Errorf("26.2 mi = %g km", got)
+	}
+	if miles.timeLayout() != "03:04:PM" || km.timeLayout() != "15:04" {
+		t.Errorf("time layouts %q and %q", miles.timeLayout(), km.timeLayout())
+	}
+}
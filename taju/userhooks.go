@@ -0,0 +1,140 @@
+package taju
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"time"
+)
+
+const USER_HOOK_TIMEOUT = time.Minute
+
+// cycleSummary is the cycle result handed to user hooks, as JSON on stdin or
+// in the webhook body and as TAJU_RESULT_* environment variables.
+type cycleSummary struct {
+	Stage      string `json:"stage"`
+	Failed     bool   `json:"failed"`
+	Activities int    `json:"activities"`
+	Events     int    `json:"events"`
+	Planned    int    `json:"planned"`
+	Posted     int    `json:"posted"`
+
+	GoalMiles       float64 `json:"goal_miles,omitempty"`
+	Miles           float64 `json:"miles,omitempty"`
+	GoalElevation   float64 `json:"goal_elevation_feet,omitempty"`
+	Elevation       float64 `json:"elevation_feet,omitempty"`
+	ProjectedFinish string  `json:"projected_finish,omitempty"`
+	BehindPace      bool    `json:"behind_pace,omitempty"`
+}
+
+func summarizeCycle(stage string, result cycleResult) cycleSummary {
+	summary := cycleSummary{
+		Stage:      stage,
+		Failed:     result.failed,
+		Activities: len(result.activities),
+		Events:     len(result.events),
+		Planned:    len(result.plan),
+		Posted:     len(result.posted),
+		GoalMiles:  result.progress.target,
+		Miles:      math.Round(result.progress.done*100) / 100,
+		BehindPace: result.progress.behind(),
+	}
+	if result.climb.set() {
+		summary.GoalElevation, summary.Elevation = math.Round(result.climb.target), math.Round(result.climb.done)
+	}
+	if !result.progress.projected.IsZero() {
+		summary.ProjectedFinish = result.progress.projected.Local().Format(DATE_FORMAT)
+	}
+	return summary
+}
+
+// registerUserHooks wires TAJU_PRE_SYNC_COMMAND / TAJU_PRE_SYNC_WEBHOOK and
+// TAJU_POST_SYNC_COMMAND / TAJU_POST_SYNC_WEBHOOK into the syncer, e.g. to
+// wake a NAS disk before a cycle or ping a monitoring URL after it. Hook
+// failures are logged but never stop the sync.
+func registerUserHooks(s *syncer, env map[string]string, network networkSettings) {
+	client := network.client(USER_HOOK_TIMEOUT)
+	pre_command, pre_webhook := env["TAJU_PRE_SYNC_COMMAND"], env["TAJU_PRE_SYNC_WEBHOOK"]
+	if pre_command != "" || pre_webhook != "" {
+		subscribe(&s.events, func(cycleStarted) {
+			runUserHook(client, pre_command, pre_webhook, cycleSummary{Stage: "pre"})
+		})
+	}
+
+	post_command, post_webhook := env["TAJU_POST_SYNC_COMMAND"], env["TAJU_POST_SYNC_WEBHOOK"]
+	if post_command != "" || post_webhook != "" {
+		subscribe(&s.events, func(e cycleCompleted) {
+			runUserHook(client, post_command, post_webhook, summarizeCycle("post", e.result))
+		})
+	}
+}
+
+func runUserHook(client *http.Client, command string, webhook string, summary cycleSummary) {
+	body, err := json.Marshal(summary)
+	if err != nil {
+		log.Print("Failed to encode hook payload: ", err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), USER_HOOK_TIMEOUT)
+	defer cancel()
+
+	if command != "" {
+		if err := runHookCommand(ctx, command, summary, body); err != nil {
+			log.Printf("%s-sync command failed: %v", summary.Stage, err)
+		}
+	}
+	if webhook != "" {
+		if err := postHookWebhook(ctx, client, webhook, body); err != nil {
+			log.Printf("%s-sync webhook failed: %v", summary.Stage, err)
+		}
+	}
+}
+
+func runHookCommand(ctx context.Context, command string, summary cycleSummary, body []byte) error {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/c", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", command)
+	}
+	cmd.Env = append(os.Environ(),
+		"TAJU_RESULT_STAGE="+summary.Stage,
+		fmt.Sprintf("TAJU_RESULT_FAILED=%t", summary.Failed),
+		fmt.Sprintf("TAJU_RESULT_ACTIVITIES=%d", summary.Activities),
+		fmt.Sprintf("TAJU_RESULT_EVENTS=%d", summary.Events),
+		fmt.Sprintf("TAJU_RESULT_PLANNED=%d", summary.Planned),
+		fmt.Sprintf("TAJU_RESULT_POSTED=%d", summary.Posted),
+		fmt.Sprintf("TAJU_RESULT_MILES=%.2f", summary.Miles),
+		fmt.Sprintf("TAJU_RESULT_ELEVATION_FEET=%.0f", summary.Elevation),
+		"TAJU_RESULT_PROJECTED_FINISH="+summary.ProjectedFinish,
+		fmt.Sprintf("TAJU_RESULT_BEHIND_PACE=%t", summary.BehindPace),
+	)
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+func postHookWebhook(ctx context.Context, client *http.Client, webhook string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", webhook, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 400 {
+		return fmt.Errorf("%s returned %s", webhook, res.Status)
+	}
+	return nil
+}
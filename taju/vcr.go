@@ -0,0 +1,49 @@
+package taju
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/tajuploader/stravaclient"
+)
+
+const (
+	VCR_RECORD string = stravaclient.VCR_RECORD
+	VCR_REPLAY string = stravaclient.VCR_REPLAY
+)
+
+// newStravaVCR wraps base according to TAJU_STRAVA_VCR, returning base
+// itself when it isn't set. Recording keeps the Strava API responses in
+// TAJU_STRAVA_CASSETTE, replaying serves them from there offline:
+//
+//	TAJU_STRAVA_VCR=record TAJU_STRAVA_CASSETTE=testdata/feb taju sync --once --dry-run
+//	TAJU_STRAVA_VCR=replay TAJU_STRAVA_CASSETTE=testdata/feb TAJU_FAKE_NOW=... taju sync --once --dry-run
+//
+// Pin the clock with TAJU_FAKE_NOW when replaying, the activity list is
+// requested for a time range. See stravaclient.VCR.
+func newStravaVCR(env map[string]string, base http.RoundTripper) (http.RoundTripper, error) {
+	mode := env["TAJU_STRAVA_VCR"]
+	switch mode {
+	case "":
+		return base, nil
+	case VCR_RECORD, VCR_REPLAY:
+	default:
+		return nil, fmt.Errorf("invalid TAJU_STRAVA_VCR=%q, expected record or replay", mode)
+	}
+	cassette := env["TAJU_STRAVA_CASSETTE"]
+	if cassette == "" {
+		return nil, errors.New("TAJU_STRAVA_VCR needs TAJU_STRAVA_CASSETTE, the directory to keep responses in")
+	}
+	vcr, err := stravaclient.NewVCR(mode, cassette, base)
+	if err != nil {
+		return nil, err
+	}
+	if mode == VCR_RECORD {
+		log.Print("Recording Strava API responses to ", cassette)
+	} else {
+		log.Print("Replaying Strava API responses from ", cassette)
+	}
+	return vcr, nil
+}
@@ -0,0 +1,12 @@
+package taju
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestStravaVCROff(t *testing.T) {
+	if transport, err := newStravaVCR(map[string]string{}, http.DefaultTransport); err != nil || transport != http.DefaultTransport {
+		t.Errorf("without TAJU_STRAVA_VCR the transport is %T", transport)
+	}
+}
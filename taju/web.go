@@ -0,0 +1,313 @@
+package taju
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+const DEFAULT_WEB_ADDR string = ":9190"
+
+// webSettings are the taju.env keys that can be edited from the web UI.
+// Secrets are never sent back to the browser; leaving one blank keeps it.
+var webSettings = []struct {
+	Key    string
+	Label  string
+	Secret bool
+}{
+	{"TAJU_CLIENT_ID", "Strava client id", false},
+	{"TAJU_CLIENT_SECRET", "Strava client secret", true},
+	{"TAJI_USERNAME", "Taji100 email", false},
+	{"TAJI_PASSWORD", "Taji100 password", true},
+	{"TAJU_EVENT_YEAR", "Event year", false},
+	{"TAJU_SYNC_INTERVAL", "Sync interval (e.g. 30m)", false},
+	{"TAJU_GOAL_MILES", "Goal miles", false},
+}
+
+// webSetup is the NAS package mode: the whole setup (settings, Strava
+// authorization and Taji login) happens in the browser, since a NAS app has
+// no console to prompt on. Once everything is set up it starts the sync
+// loop in the background and keeps serving its status.
+type webSetup struct {
+	u       *uploader
+	mu      sync.Mutex
+	syncing bool
+	message string
+}
+
+var webPage = template.Must(template.New("web").Parse(`<!doctype html>
+<html><head><meta charset="utf-8"><title>Taj Uploader</title></head>
+<body style="font-family: sans-serif; max-width: 40em; margin: 2em auto">
+<h1>Taj Uploader</h1>
+{{if .Message}}<p><b>{{.Message}}</b></p>{{end}}
+{{if .Syncing}}
+<p>Setup is complete and activities are syncing. Restart the package to change the settings.</p>
+<form method="post" action="/sync"><button>Sync now</button></form>
+{{else}}
+<h2>1. Settings</h2>
+<form method="post" action="/settings">
+{{range .Settings}}<p><label>{{.Label}}<br>
+<input name="{{.Key}}" {{if .Secret}}type="password" placeholder="{{if .Set}}(saved){{end}}"{{else}}value="{{.Value}}"{{end}}></label></p>
+{{end}}<button>Save</button>
+</form>
+<h2>2. Strava</h2>
+{{if .Strava}}<p>Authorized.</p>{{end}}
+<p>Set the Authorization Callback Domain of your Strava API application to <code>{{.Host}}</code>, then
+<a href="/strava/connect">{{if .Strava}}authorize again{{else}}authorize Strava{{end}}</a>.</p>
+<h2>3. Taji100</h2>
+{{if .Taji}}<p>Logged in.</p>{{end}}
+<form method="post" action="/taji/login"><button>Log in to Taji100</button></form>
+{{end}}
+</body></html>
+`))
+
+func webCommand(u *uploader, args []string) {
+	flags := flag.NewFlagSet("web", flag.ExitOnError)
+	addr := flags.String("addr", "", "address to serve the setup page on (overrides TAJU_WEB_ADDR, default "+DEFAULT_WEB_ADDR+")")
+	flags.Parse(args)
+	if *addr == "" {
+		*addr = u.env["TAJU_WEB_ADDR"]
+	}
+	if *addr == "" {
+		*addr = DEFAULT_WEB_ADDR
+	}
+
+	w := &webSetup{u: u}
+	w.startSyncIfReady()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", w.page)
+	mux.HandleFunc("POST /settings", sameOrigin(w.saveSettings))
+	mux.HandleFunc("GET /strava/connect", w.stravaConnect)
+	mux.HandleFunc("GET /strava/callback", w.stravaCallback)
+	mux.HandleFunc("POST /taji/login", sameOrigin(w.tajiLogin))
+	mux.HandleFunc("POST /sync", sameOrigin(w.syncNow))
+
+	log.Printf("Open http://%s to set up Taj Uploader", *addr)
+	server := &http.Server{Addr: *addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	log.Fatal(server.ListenAndServe())
+}
+
+// sameOrigin rejects form posts from other sites, so a page open in the
+// same browser can't change the settings.
+func sameOrigin(handler http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		if origin := r.Header.Get("Origin"); origin != "" && origin != "http://"+r.Host {
+			http.Error(rw, "cross-site request", http.StatusForbidden)
+			return
+		}
+		handler(rw, r)
+	}
+}
+
+// ensureEnvFile creates an empty taju.env on the first start of a package,
+// which is set up from the web UI instead.
+func ensureEnvFile() {
+	if _, err := os.Stat(ENV_FILENAME); !errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	if err := os.WriteFile(ENV_FILENAME, nil, 0600); err != nil {
+		log.Fatal("Can't create ", ENV_FILENAME, ": ", err)
+	}
+}
+
+func (w *webSetup) ready() bool {
+	env := w.u.env
+	for _, key := range []string{"TAJU_CLIENT_ID", "TAJU_CLIENT_SECRET", stravaTokenKey(DEFAULT_ACCOUNT), "TAJI_CSRF", "TAJI_SESSION", "TAJI_PARTICIPANT"} {
+		if env[key] == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// startSyncIfReady starts the sync loop once everything is set up. The
+// caller must not hold w.mu.
+func (w *webSetup) startSyncIfReady() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.syncing || !w.ready() {
+		return
+	}
+	w.syncing = true
+	go func() {
+		// The sync loop only returns when it was told to stop.
+		syncCommand(w.u, []string{"--headless"})
+		os.Exit(0)
+	}()
+}
+
+func (w *webSetup) page(rw http.ResponseWriter, r *http.Request) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	type setting struct {
+		Key, Label, Value string
+		Secret, Set       bool
+	}
+	data := struct {
+		Message, Host string
+		Syncing       bool
+		Strava, Taji  bool
+		Settings      []setting
+	}{
+		Message: w.message,
+		Host:    strings.Split(r.Host, ":")[0],
+		Syncing: w.syncing,
+		Strava:  w.u.env[stravaTokenKey(DEFAULT_ACCOUNT)] != "",
+		Taji:    w.u.env["TAJI_SESSION"] != "",
+	}
+	w.message = ""
+	for _, s := range webSettings {
+		value := w.u.env[s.Key]
+		item := setting{Key: s.Key, Label: s.Label, Secret: s.Secret, Set: value != ""}
+		if !s.Secret {
+			item.Value = value
+		}
+		data.Settings = append(data.Settings, item)
+	}
+	if err := webPage.Execute(rw, data); err != nil {
+		log.Print("Error rendering the setup page: ", err)
+	}
+}
+
+// done shows message on the setup page.
+func (w *webSetup) done(rw http.ResponseWriter, r *http.Request, message string) {
+	w.mu.Lock()
+	w.message = message
+	w.mu.Unlock()
+	w.startSyncIfReady()
+	http.Redirect(rw, r, "/", http.StatusSeeOther)
+}
+
+func (w *webSetup) saveSettings(rw http.ResponseWriter, r *http.Request) {
+	w.mu.Lock()
+	if w.syncing {
+		w.mu.Unlock()
+		http.Error(rw, "settings can't be changed while syncing", http.StatusConflict)
+		return
+	}
+	for _, s := range webSettings {
+		value := strings.TrimSpace(r.FormValue(s.Key))
+		if value == "" && s.Secret {
+			continue
+		}
+		if value == "" {
+			delete(w.u.env, s.Key)
+			continue
+		}
+		w.u.env[s.Key] = value
+	}
+	dumpEnvFile(w.u)
+	w.mu.Unlock()
+	w.done(rw, r, "Settings saved.")
+}
+
+// stravaConfigFor returns the OAuth config redirecting back to this server.
+// The token isn't replaced once the sync loop is using it.
+func (w *webSetup) stravaConfigFor(r *http.Request) (*oauth2.Config, bool) {
+	if w.syncing || w.u.env["TAJU_CLIENT_ID"] == "" || w.u.env["TAJU_CLIENT_SECRET"] == "" {
+		return nil, false
+	}
+	conf := stravaConfig(w.u.env)
+	conf.RedirectURL = fmt.Sprintf("http://%s/strava/callback", r.Host)
+	return conf, true
+}
+
+func (w *webSetup) stravaConnect(rw http.ResponseWriter, r *http.Request) {
+	w.mu.Lock()
+	conf, ok := w.stravaConfigFor(r)
+	w.mu.Unlock()
+	if !ok {
+		w.done(rw, r, "Save the Strava client id and secret first (settings can't be changed while syncing).")
+		return
+	}
+	http.Redirect(rw, r, conf.AuthCodeURL("startup"), http.StatusFound)
+}
+
+func (w *webSetup) stravaCallback(rw http.ResponseWriter, r *http.Request) {
+	code := r.URL.Query().Get("code")
+	if code == "" {
+		w.done(rw, r, "Strava didn't authorize Taj Uploader: "+r.URL.Query().Get("error"))
+		return
+	}
+	w.mu.Lock()
+	conf, ok := w.stravaConfigFor(r)
+	if !ok {
+		w.mu.Unlock()
+		w.done(rw, r, "Save the Strava client id and secret first.")
+		return
+	}
+	token, err := conf.Exchange(r.Context(), code)
+	if err != nil {
+		w.mu.Unlock()
+		log.Print("Strava authorization failed: ", err)
+		w.done(rw, r, "Strava authorization failed, try again.")
+		return
+	}
+	data, _ := json.Marshal(token)
+	w.u.env[stravaTokenKey(DEFAULT_ACCOUNT)] = string(data)
+	storeStravaScope(w.u.env, &strava{name: DEFAULT_ACCOUNT}, r.URL.Query().Get("scope"))
+	dumpEnvFile(w.u)
+	w.mu.Unlock()
+	w.done(rw, r, "Strava authorized.")
+}
+
+func (w *webSetup) tajiLogin(rw http.ResponseWriter, r *http.Request) {
+	w.mu.Lock()
+	if w.syncing {
+		w.mu.Unlock()
+		http.Error(rw, "already logged in and syncing", http.StatusConflict)
+		return
+	}
+	env := w.u.env
+	if env["TAJI_USERNAME"] == "" || env["TAJI_PASSWORD"] == "" {
+		w.mu.Unlock()
+		w.done(rw, r, "Save the Taji100 email and password first.")
+		return
+	}
+	t := &taji{settings: w.u.settings}
+	if err := initTajiClient(env, t); err != nil {
+		w.mu.Unlock()
+		w.done(rw, r, err.Error())
+		return
+	}
+	if err := tajiLogin(t, env["TAJI_USERNAME"], env["TAJI_PASSWORD"]); err != nil {
+		w.mu.Unlock()
+		log.Print("Taji login failed: ", err)
+		w.done(rw, r, "Taji100 login failed, check the email and password.")
+		return
+	}
+	saveTajiLogin(t, env)
+	dumpEnvFile(w.u)
+	w.mu.Unlock()
+	w.done(rw, r, "Logged in to Taji100.")
+}
+
+// syncNow forwards to the control server of the running sync loop.
+func (w *webSetup) syncNow(rw http.ResponseWriter, r *http.Request) {
+	addr := w.u.env["TAJU_CONTROL_ADDR"]
+	if addr == "" {
+		addr = fmt.Sprintf("localhost:%d", PORT)
+	}
+	if addr == "off" {
+		w.done(rw, r, "The control server is off (TAJU_CONTROL_ADDR), the next sync runs on schedule.")
+		return
+	}
+	res, err := http.Post("http://"+addr+"/sync", "text/plain", nil)
+	if err != nil {
+		w.done(rw, r, "Couldn't reach the sync loop: "+err.Error())
+		return
+	}
+	res.Body.Close()
+	w.done(rw, r, "Sync queued.")
+}
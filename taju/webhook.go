@@ -0,0 +1,281 @@
+package taju
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const STRAVA_PUSH_URL string = STRAVA_API_URL + "/push_subscriptions"
+const DEFAULT_WEBHOOK_ADDR string = ":9192"
+const WEBHOOK_PATH string = "/strava/webhook"
+
+// Push events older than WEBHOOK_MAX_AGE, or seen before, are ignored, so a
+// captured request can't be replayed to trigger syncs. Strava retries a
+// failed delivery for a few minutes at most.
+const (
+	WEBHOOK_MAX_AGE    = 30 * time.Minute
+	WEBHOOK_MAX_BODY   = 16 << 10
+	WEBHOOK_SEEN_LIMIT = 1000
+)
+
+// stravaPushEvent is the body Strava posts to the callback for every change
+// to an athlete's activities.
+type stravaPushEvent struct {
+	ObjectType string `json:"object_type"`
+	ObjectId   int64  `json:"object_id"`
+	AspectType string `json:"aspect_type"`
+	OwnerId    int64  `json:"owner_id"`
+	EventTime  int64  `json:"event_time"`
+
+	SubscriptionId int64 `json:"subscription_id"`
+}
+
+type stravaPushSubscription struct {
+	Id          int64  `json:"id"`
+	CallbackUrl string `json:"callback_url"`
+}
+
+// startWebhook subscribes to Strava's push events and serves the callback,
+// sending to triggers whenever a new activity is created. It is enabled by
+// TAJU_WEBHOOK_URL, the public URL Strava calls (a tunnel or reverse proxy
+// forwarding to TAJU_WEBHOOK_ADDR, :9192 by default). With
+// TAJU_WEBHOOK_CERT and TAJU_WEBHOOK_KEY the callback is served over HTTPS
+// directly. Polling keeps running as a fallback for missed events. Without
+// a public URL, TAJU_TUNNEL opens one through a tunnel client, and the
+// subscription follows the tunnel when its URL changes.
+//
+// Strava doesn't sign its push events, so the callback only accepts what
+// only Strava can know: the callback path ends in a secret derived from the
+// client secret, events must carry the id of our subscription, and stale
+// or repeated events are dropped (see webhookReplays).
+func startWebhook(env map[string]string, triggers chan<- string, c clock) {
+	public_url := env["TAJU_WEBHOOK_URL"]
+	tunnel := env["TAJU_TUNNEL"]
+	if public_url == "" && tunnel == "" {
+		return
+	}
+	path := WEBHOOK_PATH + "/" + webhookSecret(env)
+	addr := env["TAJU_WEBHOOK_ADDR"]
+	if addr == "" {
+		addr = DEFAULT_WEBHOOK_ADDR
+	}
+
+	var tunnel_urls <-chan string
+	if public_url == "" {
+		var err error
+		tunnel_urls, err = startTunnel(tunnel, addr, env["TAJU_WEBHOOK_CERT"] != "", c)
+		if err != nil {
+			log.Print("Error starting the tunnel, falling back to polling: ", err)
+			return
+		}
+	}
+
+	secret := make([]byte, 16)
+	rand.Read(secret)
+	verify_token := hex.EncodeToString(secret)
+
+	// subscription is the id of our push subscription, known once
+	// subscribeStrava returns.
+	var subscription struct {
+		sync.Mutex
+		id int64
+	}
+	replays := &webhookReplays{seen: make(map[stravaPushEvent]bool)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			// Strava validates the callback while the subscription is created.
+			query := r.URL.Query()
+			if query.Get("hub.mode") != "subscribe" ||
+				subtle.ConstantTimeCompare([]byte(query.Get("hub.verify_token")), []byte(verify_token)) != 1 {
+				http.Error(w, "invalid verify token", http.StatusForbidden)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]string{"hub.challenge": query.Get("hub.challenge")})
+		case http.MethodPost:
+			var event stravaPushEvent
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, WEBHOOK_MAX_BODY)).Decode(&event); err != nil {
+				http.Error(w, "invalid event", http.StatusBadRequest)
+				return
+			}
+			subscription.Lock()
+			id := subscription.id
+			subscription.Unlock()
+			if id == 0 || event.SubscriptionId != id {
+				http.Error(w, "unknown subscription", http.StatusForbidden)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			if !replays.fresh(event, c.Now()) {
+				return
+			}
+			if event.ObjectType == "activity" && event.AspectType == "create" {
+				queueSync(triggers, fmt.Sprintf("Strava activity %d was created", event.ObjectId))
+			}
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+
+	server := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		var err error
+		if env["TAJU_WEBHOOK_CERT"] != "" {
+			err = server.ListenAndServeTLS(env["TAJU_WEBHOOK_CERT"], env["TAJU_WEBHOOK_KEY"])
+		} else {
+			err = server.ListenAndServe()
+		}
+		log.Print("Webhook server stopped, falling back to polling: ", err)
+	}()
+
+	subscribeUrl := func(public_url string) error {
+		base_url := strings.TrimSuffix(strings.TrimSuffix(public_url, "/"), WEBHOOK_PATH)
+		id, err := subscribeStrava(env, base_url+path, verify_token)
+		if err != nil {
+			return err
+		}
+		subscription.Lock()
+		subscription.id = id
+		subscription.Unlock()
+		log.Print("Listening for Strava push events at ", base_url)
+		return nil
+	}
+	if tunnel_urls != nil {
+		go followTunnel(tunnel_urls, subscribeUrl)
+	} else if err := subscribeUrl(public_url); err != nil {
+		log.Print("Error subscribing to Strava push events, falling back to polling: ", err)
+		server.Close()
+	}
+}
+
+// followTunnel moves the push subscription to each new tunnel URL. Events
+// sent to the old URL are lost in between; polling catches up on those.
+func followTunnel(urls <-chan string, subscribe func(string) error) {
+	for public_url := range urls {
+		if err := subscribe(public_url); err != nil {
+			log.Print("Error subscribing to Strava push events at the new tunnel URL: ", err)
+		}
+	}
+}
+
+// webhookSecret is the secret path segment of the callback URL. It is
+// derived from the client secret, so it stays the same across restarts
+// (keeping the subscription) without being stored anywhere.
+func webhookSecret(env map[string]string) string {
+	mac := hmac.New(sha256.New, []byte(env["TAJU_CLIENT_SECRET"]))
+	mac.Write([]byte("taju webhook"))
+	return hex.EncodeToString(mac.Sum(nil))[:32]
+}
+
+// webhookReplays remembers the push events already handled.
+type webhookReplays struct {
+	mu   sync.Mutex
+	seen map[stravaPushEvent]bool
+}
+
+// fresh reports whether an event is recent and wasn't seen before.
+func (p *webhookReplays) fresh(event stravaPushEvent, now time.Time) bool {
+	age := now.Sub(time.Unix(event.EventTime, 0))
+	if age > WEBHOOK_MAX_AGE || age < -WEBHOOK_MAX_AGE {
+		log.Printf("Ignoring a Strava push event from %s", time.Unix(event.EventTime, 0).Format(time.DateTime))
+		return false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.seen[event] {
+		return false
+	}
+	if len(p.seen) >= WEBHOOK_SEEN_LIMIT {
+		// Anything older than WEBHOOK_MAX_AGE is rejected anyway.
+		for seen := range p.seen {
+			if now.Sub(time.Unix(seen.EventTime, 0)) > WEBHOOK_MAX_AGE {
+				delete(p.seen, seen)
+			}
+		}
+	}
+	p.seen[event] = true
+	return true
+}
+
+// subscribeStrava creates the app's push subscription and returns its id.
+// Strava allows only one per app, so a subscription for another callback
+// URL is replaced.
+func subscribeStrava(env map[string]string, callback_url string, verify_token string) (int64, error) {
+	network, err := loadNetworkSettings(env)
+	if err != nil {
+		return 0, err
+	}
+	client := network.client(DEFAULT_HTTP_TIMEOUT)
+	credentials := url.Values{}
+	credentials.Set("client_id", env["TAJU_CLIENT_ID"])
+	credentials.Set("client_secret", env["TAJU_CLIENT_SECRET"])
+
+	res, err := client.Get(STRAVA_PUSH_URL + "?" + credentials.Encode())
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	if err := checkStatus(res, "listing Strava push subscriptions"); err != nil {
+		return 0, err
+	}
+	var subscriptions []stravaPushSubscription
+	if err := json.NewDecoder(res.Body).Decode(&subscriptions); err != nil {
+		return 0, err
+	}
+
+	for _, subscription := range subscriptions {
+		if subscription.CallbackUrl == callback_url {
+			// The callback only has to answer the validation when the
+			// subscription is created, so the old one keeps working.
+			return subscription.Id, nil
+		}
+		req, err := http.NewRequest(http.MethodDelete,
+			fmt.Sprintf("%s/%d?%s", STRAVA_PUSH_URL, subscription.Id, credentials.Encode()), nil)
+		if err != nil {
+			return 0, err
+		}
+		res, err := client.Do(req)
+		if err != nil {
+			return 0, err
+		}
+		res.Body.Close()
+		if err := checkStatus(res, "deleting Strava push subscription"); err != nil {
+			return 0, err
+		}
+		log.Print("Replaced the Strava push subscription for ", subscription.CallbackUrl)
+	}
+
+	form := url.Values{
+		"client_id":     {env["TAJU_CLIENT_ID"]},
+		"client_secret": {env["TAJU_CLIENT_SECRET"]},
+		"callback_url":  {callback_url},
+		"verify_token":  {verify_token},
+	}
+	created, err := client.PostForm(STRAVA_PUSH_URL, form)
+	if err != nil {
+		return 0, err
+	}
+	defer created.Body.Close()
+	if err := checkStatus(created, "creating Strava push subscription"); err != nil {
+		return 0, err
+	}
+	var new_subscription stravaPushSubscription
+	if err := json.NewDecoder(created.Body).Decode(&new_subscription); err != nil {
+		return 0, err
+	}
+	return new_subscription.Id, nil
+}
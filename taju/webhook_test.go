@@ -0,0 +1,60 @@
+package taju
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWebhookReplays(t *testing.T) {
+	event := stravaPushEvent{ObjectType: "activity", ObjectId: 1, AspectType: "create", EventTime: TEST_NOW.Add(-time.Minute).Unix(), SubscriptionId: 9}
+	tests := []struct {
+		name  string
+		event stravaPushEvent
+		seen  bool
+		want  bool
+	}{
+		{name: "new", event: event, want: true},
+		{name: "seen before", event: event, seen: true},
+		{name: "too old", event: stravaPushEvent{ObjectId: 2, EventTime: TEST_NOW.Add(-WEBHOOK_MAX_AGE - time.Second).Unix()}},
+		{name: "from the future", event: stravaPushEvent{ObjectId: 3, EventTime: TEST_NOW.Add(WEBHOOK_MAX_AGE + time.Second).Unix()}},
+		{name: "as old as allowed", event: stravaPushEvent{ObjectId: 4, EventTime: TEST_NOW.Add(-WEBHOOK_MAX_AGE).Unix()}, want: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			replays := &webhookReplays{seen: map[stravaPushEvent]bool{}}
+			if test.seen {
+				replays.seen[test.event] = true
+			}
+			if got := replays.fresh(test.event, TEST_NOW); got != test.want {
+				t.Errorf("fresh() = %t, want %t", got, test.want)
+			}
+		})
+	}
+}
+
+func TestHookClient(t *testing.T) {
+	var attempts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("User-Agent") != "club-bot" {
+			t.Errorf("sent User-Agent %q, want TAJU_USER_AGENT", r.Header.Get("User-Agent"))
+		}
+		if attempts.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+	network, err := loadNetworkSettings(map[string]string{"TAJU_USER_AGENT": "club-bot"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := postHookWebhook(context.Background(), network.client(USER_HOOK_TIMEOUT), server.URL, []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+	if n := attempts.Load(); n != 2 {
+		t.Errorf("sent the hook %d times, want it retried once after the 503", n)
+	}
+}
@@ -0,0 +1,89 @@
+package taju
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const DATE_FORMAT string = "2006-01-02"
+
+// Which activities belong to the event window (TAJU_WINDOW_DATES):
+//
+//	local  the date a run is logged under on Taji, in its own timezone and
+//	       after the midnight rule, must fall inside the window (default)
+//	query  whatever Strava returns for the window's start and end instants
+//	       in this machine's timezone, as taju did before
+//
+// With local dates a run on Jan 31 evening that is Feb 1 in UTC stays out,
+// and a run on Feb 1 morning in Tokyo is fetched although it started on
+// Jan 31 here: Strava is queried WINDOW_MARGIN past both ends and the runs
+// are filtered once dated.
+const (
+	WINDOW_LOCAL string = "local"
+	WINDOW_QUERY string = "query"
+)
+
+const WINDOW_MARGIN = 24 * time.Hour
+
+func loadWindowDates(env map[string]string) (string, error) {
+	switch value := env["TAJU_WINDOW_DATES"]; value {
+	case "":
+		return WINDOW_LOCAL, nil
+	case WINDOW_LOCAL, WINDOW_QUERY:
+		return value, nil
+	default:
+		return "", fmt.Errorf("invalid TAJU_WINDOW_DATES=%q, expected local or query", value)
+	}
+}
+
+// queryWindow is the range Strava is asked for.
+func queryWindow(dates string, start time.Time, end time.Time) (time.Time, time.Time) {
+	if dates == WINDOW_LOCAL {
+		return start.Add(-WINDOW_MARGIN), end.Add(WINDOW_MARGIN)
+	}
+	return start, end
+}
+
+// inWindow reports whether a dated run belongs to the window [start, end).
+func inWindow(dates string, run runDetails, start time.Time, end time.Time) bool {
+	if dates != WINDOW_LOCAL {
+		return true
+	}
+	return run.date >= start.Format(DATE_FORMAT) && run.date < end.Format(DATE_FORMAT)
+}
+
+// eventWindow returns the date range to sync, end exclusive. Taji100 runs
+// through February, so by default that is February of the current year.
+// TAJU_EVENT_YEAR picks another year, and TAJU_EVENT_START/TAJU_EVENT_END
+// (YYYY-MM-DD, both inclusive) set a custom range e.g. for a backfill.
+// TAJU_EVENT_END_INCLUSIVE=false makes TAJU_EVENT_END the first day that
+// isn't synced, for event rules written that way (Feb 1 to Mar 1).
+func eventWindow(env map[string]string, now time.Time) (start time.Time, end time.Time, err error) {
+	year := now.Year()
+	if value, ok := env["TAJU_EVENT_YEAR"]; ok {
+		if year, err = strconv.Atoi(value); err != nil {
+			return start, end, fmt.Errorf("invalid TAJU_EVENT_YEAR %q", value)
+		}
+	}
+	start = time.Date(year, time.February, 1, 0, 0, 0, 0, time.Local)
+	end = start.AddDate(0, 1, 0)
+
+	if value, ok := env["TAJU_EVENT_START"]; ok {
+		if start, err = time.ParseInLocation(DATE_FORMAT, value, time.Local); err != nil {
+			return start, end, fmt.Errorf("invalid TAJU_EVENT_START %q, expected YYYY-MM-DD", value)
+		}
+	}
+	if value, ok := env["TAJU_EVENT_END"]; ok {
+		if end, err = time.ParseInLocation(DATE_FORMAT, value, time.Local); err != nil {
+			return start, end, fmt.Errorf("invalid TAJU_EVENT_END %q, expected YYYY-MM-DD", value)
+		}
+		if _, ok := env["TAJU_EVENT_END_INCLUSIVE"]; !ok || envBool(env, "TAJU_EVENT_END_INCLUSIVE") {
+			end = end.AddDate(0, 0, 1)
+		}
+	}
+	if !end.After(start) {
+		return start, end, fmt.Errorf("the event window ends before it starts")
+	}
+	return
+}
@@ -0,0 +1,130 @@
+package taju
+
+import (
+	"testing"
+	"time"
+)
+
+// datedRun is a Strava run started at start (RFC 3339) in zone, dated by
+// the pipeline of env the way it is logged on Taji.
+func datedRun(t *testing.T, env map[string]string, start string, seconds int64, zone string) runDetails {
+	t.Helper()
+	location, err := time.LoadLocation(zone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := loadSettings(env, "", newFakeClock(TEST_NOW))
+	if err != nil {
+		t.Fatal(err)
+	}
+	run := s.createRun("run", start, seconds, 5000)
+	run.location = location
+	pipeline, err := loadPipeline(env, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	run, err = pipeline.apply(run)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return run
+}
+
+func TestInWindow(t *testing.T) {
+	start := time.Date(2026, 2, 1, 0, 0, 0, 0, time.Local)
+	end := start.AddDate(0, 1, 0)
+	tests := []struct {
+		name    string
+		env     map[string]string
+		start   string
+		seconds int64
+		zone    string
+		dates   string
+		want    bool
+	}{
+		{name: "Jan 31 evening, Feb 1 in UTC", start: "2026-02-01T04:30:00Z", seconds: 1800, zone: "America/New_York", want: false},
+		{name: "Jan 31 evening by query instants", start: "2026-02-01T04:30:00Z", seconds: 1800, zone: "America/New_York", dates: WINDOW_QUERY, want: true},
+		{name: "first minute of Feb 1", start: "2026-02-01T05:00:00Z", seconds: 1800, zone: "America/New_York", want: true},
+		{name: "Feb 1 morning in Tokyo", start: "2026-01-31T22:00:00Z", seconds: 1800, zone: "Asia/Tokyo", want: true},
+		{name: "last minute of Feb 28", start: "2026-03-01T04:59:00Z", seconds: 1800, zone: "America/New_York", want: true},
+		{name: "Mar 1 morning", start: "2026-03-01T12:00:00Z", seconds: 1800, zone: "America/New_York", want: false},
+		{name: "Mar 1 morning in UTC, Feb 28 here", start: "2026-03-01T02:00:00Z", seconds: 1800, zone: "America/New_York", want: true},
+		{
+			name: "across midnight into Feb, logged on the day it ended", env: map[string]string{"TAJU_MIDNIGHT": MIDNIGHT_END},
+			start: "2026-02-01T04:40:00Z", seconds: 40 * 60, zone: "America/New_York", want: true,
+		},
+		{
+			name: "across midnight into Mar, logged on the day it ended", env: map[string]string{"TAJU_MIDNIGHT": MIDNIGHT_END},
+			start: "2026-03-01T04:50:00Z", seconds: 30 * 60, zone: "America/New_York", want: false,
+		},
+		{
+			name:  "across midnight into Mar, logged on the day it started",
+			start: "2026-03-01T04:50:00Z", seconds: 30 * 60, zone: "America/New_York", want: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dates := test.dates
+			if dates == "" {
+				dates = WINDOW_LOCAL
+			}
+			run := datedRun(t, test.env, test.start, test.seconds, test.zone)
+			if got := inWindow(dates, run, start, end); got != test.want {
+				t.Errorf("inWindow(%s, run dated %s) = %t, want %t", dates, run.date, got, test.want)
+			}
+		})
+	}
+}
+
+func TestEventWindow(t *testing.T) {
+	day := func(month time.Month, d int) time.Time { return time.Date(2026, month, d, 0, 0, 0, 0, time.Local) }
+	tests := []struct {
+		name       string
+		env        map[string]string
+		start, end time.Time
+		wantErr    bool
+	}{
+		{name: "February by default", env: map[string]string{}, start: day(time.February, 1), end: day(time.March, 1)},
+		{name: "another year", env: map[string]string{"TAJU_EVENT_YEAR": "2025"}, start: time.Date(2025, 2, 1, 0, 0, 0, 0, time.Local), end: time.Date(2025, 3, 1, 0, 0, 0, 0, time.Local)},
+		{
+			name:  "inclusive end",
+			env:   map[string]string{"TAJU_EVENT_START": "2026-02-01", "TAJU_EVENT_END": "2026-02-28"},
+			start: day(time.February, 1), end: day(time.March, 1),
+		},
+		{
+			name:  "exclusive end",
+			env:   map[string]string{"TAJU_EVENT_START": "2026-02-01", "TAJU_EVENT_END": "2026-03-01", "TAJU_EVENT_END_INCLUSIVE": "false"},
+			start: day(time.February, 1), end: day(time.March, 1),
+		},
+		{
+			name:  "explicitly inclusive end",
+			env:   map[string]string{"TAJU_EVENT_END": "2026-03-01", "TAJU_EVENT_END_INCLUSIVE": "true"},
+			start: day(time.February, 1), end: day(time.March, 2),
+		},
+		{name: "exclusive end on the start", env: map[string]string{"TAJU_EVENT_START": "2026-02-01", "TAJU_EVENT_END": "2026-02-01", "TAJU_EVENT_END_INCLUSIVE": "false"}, wantErr: true},
+		{name: "invalid end", env: map[string]string{"TAJU_EVENT_END": "Mar 1"}, wantErr: true},
+		{name: "invalid year", env: map[string]string{"TAJU_EVENT_YEAR": "next"}, wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			start, end, err := eventWindow(test.env, TEST_NOW)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("eventWindow() error = %v, wantErr %v", err, test.wantErr)
+			}
+			if err == nil && (!start.Equal(test.start) || !end.Equal(test.end)) {
+				t.Errorf("eventWindow() = %v to %v, want %v to %v", start, end, test.start, test.end)
+			}
+		})
+	}
+}
+
+func TestQueryWindow(t *testing.T) {
+	start := time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(0, 1, 0)
+	if after, before := queryWindow(WINDOW_LOCAL, start, end); !after.Equal(start.Add(-WINDOW_MARGIN)) || !before.Equal(end.Add(WINDOW_MARGIN)) {
+		t.Errorf("local dates query %v to %v, want the window with a margin", after, before)
+	}
+	if after, before := queryWindow(WINDOW_QUERY, start, end); !after.Equal(start) || !before.Equal(end) {
+		t.Errorf("query dates query %v to %v, want the window", after, before)
+	}
+}
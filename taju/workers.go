@@ -0,0 +1,58 @@
+package taju
+
+import (
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	DEFAULT_TAJI_WORKERS   = 4
+	DEFAULT_STRAVA_WORKERS = 2
+	DEFAULT_POST_WORKERS   = 1
+	MAX_WORKERS            = 16
+)
+
+// TAJI_FETCH_TIMEOUT bounds reading all Taji entry pages in one cycle.
+const TAJI_FETCH_TIMEOUT = 2 * time.Minute
+
+// envWorkers reads a worker pool size from the env file, falling back to the
+// default for missing or invalid values and capping it so a typo can't flood
+// Taji or Strava with requests.
+func envWorkers(env map[string]string, key string, fallback int) int {
+	value, ok := env[key]
+	if !ok {
+		return fallback
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		log.Printf("Ignoring invalid %s=%q, using %d", key, value, fallback)
+		return fallback
+	}
+	if n > MAX_WORKERS {
+		log.Printf("Capping %s at %d", key, MAX_WORKERS)
+		return MAX_WORKERS
+	}
+	return n
+}
+
+// forEachLimit calls fn for every index in [0, count) using at most workers
+// goroutines at a time and waits for all of them to finish.
+func forEachLimit(count int, workers int, fn func(i int)) {
+	if workers < 1 {
+		workers = 1
+	}
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, workers)
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			fn(i)
+		}(i)
+	}
+	wg.Wait()
+}
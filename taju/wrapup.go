@@ -0,0 +1,253 @@
+package taju
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+)
+
+const WRAPUP_FILENAME string = "taju.wrapup.txt"
+
+// syncStats are the uploader's own running totals, kept in the ledger so
+// they add up across restarts. Uptime counts the time the process ran up to
+// its last cycle; traffic and retries are the process-wide counters, so
+// with profiles every profile sees them all.
+type syncStats struct {
+	Since         time.Time     `json:"since"`
+	Starts        int           `json:"starts"`
+	Cycles        int           `json:"cycles"`
+	FailedCycles  int           `json:"failed_cycles"`
+	Posts         int64         `json:"posts"`
+	FailedPosts   int64         `json:"failed_posts"`
+	Retries       int64         `json:"retries"`
+	TajiRequests  int64         `json:"taji_requests"`
+	BytesSent     int64         `json:"bytes_sent"`
+	BytesReceived int64         `json:"bytes_received"`
+	Uptime        time.Duration `json:"uptime"`
+	Syncing       time.Duration `json:"syncing"`
+	LastCycle     time.Time     `json:"last_cycle,omitempty"`
+	// WrappedUp is when the report was written at the end of the event.
+	WrappedUp time.Time `json:"wrapped_up,omitempty"`
+}
+
+// registerStats adds the cycles of s to the ledger's syncStats, and writes
+// the wrap-up report after the first cycle past the end of the event
+// (TAJU_WRAPUP=false turns that off). Strict cycles that stopped on drift
+// and scratch syncs leave the ledger alone.
+func registerStats(s *syncer) {
+	if s.scratch {
+		return
+	}
+	u := s.u
+	mark := u.clock.Now()
+	u.state.updateStats(func(stats *syncStats) {
+		if stats.Since.IsZero() {
+			stats.Since = mark
+		}
+		stats.Starts++
+	})
+
+	var started time.Time
+	var retries, requests, sent, received int64
+	// Posts are published from the post workers.
+	var posts, failed_posts atomic.Int64
+	subscribe(&s.events, func(cycleStarted) {
+		started = u.clock.Now()
+		retries, requests = syncMetrics.retries.Load(), u.taji.transfer.Requests.Load()
+		sent, received = u.taji.transfer.BytesSent.Load(), u.taji.transfer.BytesReceived.Load()
+		posts.Store(0)
+		failed_posts.Store(0)
+	})
+	subscribe(&s.events, func(e entryPosted) {
+		posts.Add(1)
+		if e.err != nil {
+			failed_posts.Add(1)
+		}
+	})
+	subscribe(&s.events, func(e cycleCompleted) {
+		if len(e.result.inconsistencies) > 0 {
+			return
+		}
+		now := u.clock.Now()
+		u.state.updateStats(func(stats *syncStats) {
+			stats.Cycles++
+			if e.result.failed {
+				stats.FailedCycles++
+			}
+			stats.Posts += posts.Load()
+			stats.FailedPosts += failed_posts.Load()
+			stats.Retries += syncMetrics.retries.Load() - retries
+			stats.TajiRequests += u.taji.transfer.Requests.Load() - requests
+			stats.BytesSent += u.taji.transfer.BytesSent.Load() - sent
+			stats.BytesReceived += u.taji.transfer.BytesReceived.Load() - received
+			stats.Uptime += now.Sub(mark)
+			stats.Syncing += now.Sub(started)
+			stats.LastCycle = now
+		})
+		mark = now
+
+		_, end := goalWindowBounds(u)
+		if wrapupEnabled(u.env) && !now.Before(end) && u.state.stats().WrappedUp.IsZero() {
+			path := u.path(WRAPUP_FILENAME)
+			if err := saveWrapup(u, path, now); err != nil {
+				s.failed(err)
+			} else {
+				u.state.updateStats(func(stats *syncStats) { stats.WrappedUp = now })
+				log.Printf("The event is over, the wrap-up report is in %s", path)
+			}
+		}
+		if err := u.state.save(); err != nil {
+			s.failed(err)
+		}
+	})
+}
+
+func wrapupEnabled(env map[string]string) bool {
+	_, ok := env["TAJU_WRAPUP"]
+	return !ok || envBool(env, "TAJU_WRAPUP")
+}
+
+func goalWindowBounds(u *uploader) (time.Time, time.Time) {
+	_, start, end := goalWindow(u)
+	return start, end
+}
+
+func (s *stateStore) updateStats(update func(*syncStats)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Stats == nil {
+		s.Stats = &syncStats{}
+	}
+	update(s.Stats)
+}
+
+func (s *stateStore) stats() syncStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Stats == nil {
+		return syncStats{}
+	}
+	return *s.Stats
+}
+
+// ledgerRuns turns the activities the ledger has on Taji back into runs, as
+// far as the Taji values go, for totals that don't need Strava.
+func ledgerRuns(s *settings, activities []exportedActivity, start time.Time, end time.Time) (runs []runDetails) {
+	for _, a := range activities {
+		date, err := time.ParseInLocation(DATE_FORMAT, a.Date, time.Local)
+		if err != nil || date.Before(start) || !date.Before(end) {
+			continue
+		}
+		runs = append(runs, s.tajiRun(a.Activity, a.Date, a.Time, a.Distance, a.Duration, a.Elevation))
+	}
+	return
+}
+
+// writeWrapup writes the end of event report: what was logged on Taji
+// according to the ledger, the goals and standings, and how the uploader
+// itself did over the month.
+func writeWrapup(w io.Writer, u *uploader, now time.Time) {
+	start, end := goalWindowBounds(u)
+	runs := ledgerRuns(u.settings, u.state.exported(), start, end)
+
+	fmt.Fprintf(w, "Taji100 wrap-up, %s to %s\n\n", start.Format("Jan 2"), end.AddDate(0, 0, -1).Format("Jan 2, 2006"))
+
+	fmt.Fprintln(w, "Activities")
+	days := make(map[string]bool)
+	var miles float64
+	var seconds int64
+	var longest runDetails
+	for _, run := range runs {
+		days[run.date] = true
+		miles += meter2mile(run.distance_float)
+		seconds += run.duration_int
+		if run.distance_float > longest.distance_float {
+			longest = run
+		}
+	}
+	fmt.Fprintf(w, "  %d activities on %d days, %.2f %s in %s\n", len(runs), len(days),
+		u.settings.display.fromMiles(miles), u.settings.display.name(), hoursMinutes(time.Duration(seconds)*time.Second))
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, total := range activityTotals(runs, u.scoring) {
+		fmt.Fprintf(tw, "  %s\t%d events\t%.2f %s", total.activity, total.count, u.settings.display.fromMiles(total.miles), u.settings.display.name())
+		if u.scoring != nil {
+			fmt.Fprintf(tw, "\t%.1f %s", total.points, u.scoring.Unit)
+		}
+		fmt.Fprintln(tw)
+	}
+	tw.Flush()
+	if longest.distance_float > 0 {
+		fmt.Fprintf(w, "  Longest: %.2f %s (%s) on %s\n", u.settings.display.fromMeters(longest.distance_float), u.settings.display.name(),
+			longest.activity, longest.date)
+	}
+	for _, progress := range []goalStatus{u.goal.status(runs, now, start, end), u.goal.climbStatus(runs, now, start, end)} {
+		if !progress.set() {
+			continue
+		}
+		what, outcome := "Goal", "not reached"
+		if progress.kind == GOAL_ELEVATION {
+			what = "Climbing goal"
+		}
+		if progress.done >= progress.target {
+			outcome = "complete"
+		}
+		fmt.Fprintf(w, "  %s: %s of %s (%.1f%%), %s\n", what, progress.format(progress.done, 2), progress.format(progress.target, 0),
+			progress.percent, outcome)
+	}
+	if standings := u.state.standings(); standings != nil {
+		fmt.Fprintf(w, "  Team: %s\n", standings.summary(u.settings.display))
+	}
+
+	stats := u.state.stats()
+	fmt.Fprintln(w, "\nTaji Uploader")
+	if stats.Cycles == 0 {
+		fmt.Fprintln(w, "  No sync cycles recorded.")
+		return
+	}
+	fmt.Fprintf(w, "  Running since %s, up for %s over %d starts (%s of it syncing)\n", stats.Since.Local().Format("Jan 2 15:04"),
+		hoursMinutes(stats.Uptime), stats.Starts, hoursMinutes(stats.Syncing))
+	fmt.Fprintf(w, "  %d sync cycles, %d failed, the last at %s\n", stats.Cycles, stats.FailedCycles, stats.LastCycle.Local().Format("Jan 2 15:04"))
+	fmt.Fprintf(w, "  %d posts to Taji, %d failed, %d requests retried\n", stats.Posts, stats.FailedPosts, stats.Retries)
+	fmt.Fprintf(w, "  %d Taji requests, %s sent, %s received\n", stats.TajiRequests, kilobytes(stats.BytesSent), kilobytes(stats.BytesReceived))
+}
+
+func saveWrapup(u *uploader, path string, now time.Time) error {
+	var report strings.Builder
+	writeWrapup(&report, u, now)
+	return os.WriteFile(path, []byte(report.String()), 0644)
+}
+
+func hoursMinutes(d time.Duration) string {
+	d = d.Round(time.Minute)
+	return fmt.Sprintf("%dh %02dm", int(d.Hours()), int(d.Minutes())%60)
+}
+
+func kilobytes(n int64) string {
+	if n >= 10*1024*1024 {
+		return fmt.Sprintf("%d MB", n/(1024*1024))
+	}
+	return fmt.Sprintf("%d KB", n/1024)
+}
+
+// wrapupCommand prints the wrap-up report from the ledger, also before the
+// event is over. Nothing is read from Strava or Taji.
+func wrapupCommand(u *uploader, args []string) {
+	flags := flag.NewFlagSet("wrapup", flag.ExitOnError)
+	out := flags.String("out", "-", "file to write, - for stdout")
+	flags.Parse(args)
+
+	if *out == "-" {
+		writeWrapup(os.Stdout, u, u.clock.Now())
+		return
+	}
+	if err := saveWrapup(u, *out, u.clock.Now()); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Wrote the wrap-up report to %s", *out)
+}